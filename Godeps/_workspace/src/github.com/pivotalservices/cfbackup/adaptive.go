@@ -0,0 +1,144 @@
+package cfbackup
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// ER_ADAPTIVE_SAMPLE_INTERVAL is how often an adaptive restore measures
+	// its throughput and adjusts how many stores it restores at once
+	ER_ADAPTIVE_SAMPLE_INTERVAL = 10 * time.Second
+	// adaptiveGain is how much more throughput another transfer must bring
+	// for the controller to keep it, and to try one more
+	adaptiveGain = 1.1
+)
+
+// ConcurrencyController bounds how many transfers run at once. Its limit is
+// fixed unless it is sampled: each sample compares the throughput of the
+// transfers since the last one with the best so far, adding a transfer while
+// that raises the throughput, taking back one that did not, and halving the
+// limit when a transfer failed, so that the limit settles near the best the
+// environment allows without tuning
+type ConcurrencyController struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	active   int
+	failures int
+	bytes    int64
+	baseline float64
+	raised   bool
+	sampled  time.Time
+}
+
+// NewConcurrencyController starts at initial transfers at once, and never
+// allows more than max
+func NewConcurrencyController(initial, max int) *ConcurrencyController {
+	if max < 1 {
+		max = 1
+	}
+
+	if initial < 1 || initial > max {
+		initial = max
+	}
+	controller := &ConcurrencyController{limit: initial, max: max, sampled: time.Now()}
+	controller.cond = sync.NewCond(&controller.mutex)
+	return controller
+}
+
+// Limit is how many transfers may run at once
+func (s *ConcurrencyController) Limit() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.limit
+}
+
+// Acquire waits until another transfer may start
+func (s *ConcurrencyController) Acquire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.active >= s.limit {
+		s.cond.Wait()
+	}
+	s.active++
+}
+
+// Release ends a transfer, counting it as a failure when it has an error
+func (s *ConcurrencyController) Release(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active--
+
+	if err != nil {
+		s.failures++
+	}
+	s.cond.Broadcast()
+}
+
+// Transferred counts n more bytes moved by the transfers
+func (s *ConcurrencyController) Transferred(n int) {
+	atomic.AddInt64(&s.bytes, int64(n))
+}
+
+// Sample adjusts the limit to the throughput since the last sample
+func (s *ConcurrencyController) Sample() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(s.sampled).Seconds()
+	rate := float64(atomic.SwapInt64(&s.bytes, 0)) / elapsed
+	s.sampled = now
+	limit := s.limit
+
+	switch {
+	case s.failures > 0:
+		s.limit, s.baseline, s.raised, s.failures = (s.limit+1)/2, 0, false, 0
+
+	case s.active < s.limit || rate == 0:
+		// too few transfers are left, or moving, to tell what another would
+		// bring
+
+	case s.baseline == 0 || rate >= s.baseline*adaptiveGain:
+		s.baseline, s.raised = rate, s.limit < s.max
+
+		if s.raised {
+			s.limit++
+		}
+
+	case s.raised:
+		// the transfer added did not pay for itself
+		s.limit, s.raised = s.limit-1, false
+	}
+
+	if s.limit != limit {
+		lo.G.Info("%d transfers at once, moving %.0f bytes a second", s.limit, rate)
+		s.cond.Broadcast()
+	}
+}
+
+// Run samples the controller every interval until the function returned is
+// called
+func (s *ConcurrencyController) Run(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.Sample()
+
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,79 @@
+package cfbackup_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/pivotalservices/cfbackup"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConcurrencyController", func() {
+	var controller *ConcurrencyController
+
+	acquire := func(n int) {
+		for i := 0; i < n; i++ {
+			controller.Acquire()
+		}
+	}
+
+	sampleAfter := func(bytes int) {
+		controller.Transferred(bytes)
+		time.Sleep(20 * time.Millisecond)
+		controller.Sample()
+	}
+
+	It("should hold back transfers beyond its limit", func() {
+		controller = NewConcurrencyController(2, 2)
+		acquire(2)
+		started := make(chan bool)
+
+		go func() {
+			controller.Acquire()
+			close(started)
+		}()
+		Consistently(started, 50*time.Millisecond).ShouldNot(BeClosed())
+		controller.Release(nil)
+		Eventually(started).Should(BeClosed())
+	})
+
+	It("should add transfers while they raise the throughput, and take back one that does not", func() {
+		controller = NewConcurrencyController(2, 4)
+		acquire(2)
+		sampleAfter(1 << 20)
+		Ω(controller.Limit()).Should(Equal(3))
+
+		acquire(1)
+		sampleAfter(10 << 20)
+		Ω(controller.Limit()).Should(Equal(4))
+
+		acquire(1)
+		sampleAfter(1 << 20)
+		Ω(controller.Limit()).Should(Equal(3))
+	})
+
+	It("should never go beyond its maximum", func() {
+		controller = NewConcurrencyController(2, 2)
+		acquire(2)
+		sampleAfter(1 << 20)
+		sampleAfter(10 << 20)
+		Ω(controller.Limit()).Should(Equal(2))
+	})
+
+	It("should halve its limit when a transfer fails", func() {
+		controller = NewConcurrencyController(4, 4)
+		acquire(4)
+		controller.Release(errors.New("connection reset"))
+		sampleAfter(1 << 20)
+		Ω(controller.Limit()).Should(Equal(2))
+	})
+
+	It("should leave its limit while fewer transfers run than it allows", func() {
+		controller = NewConcurrencyController(3, 6)
+		acquire(2)
+		sampleAfter(1 << 20)
+		Ω(controller.Limit()).Should(Equal(3))
+	})
+})
@@ -0,0 +1,292 @@
+package cfbackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	ghttp "github.com/pivotalservices/gtils/http"
+	"github.com/pivotalservices/gtils/retry"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	OPSMGR_EXPORTS_URL             string        = "https://%s/api/v0/installation_asset_collection/exports"
+	OPSMGR_DEFAULT_EXPORT_POLL     time.Duration = 10 * time.Second
+	OPSMGR_DEFAULT_EXPORT_DEADLINE time.Duration = 2 * time.Hour
+	OPSMGR_EXPORT_SUCCEEDED        string        = "succeeded"
+	OPSMGR_EXPORT_FAILED           string        = "failed"
+	// OPSMGR_EXPORT_PART_EXT is added to the name of the assets while they
+	// download, and OPSMGR_EXPORT_ID_EXT to the file naming the export they
+	// download from, so that a later backup can resume the download
+	OPSMGR_EXPORT_PART_EXT string = ".part"
+	OPSMGR_EXPORT_ID_EXT   string = ".export"
+
+	ErrExportFailedFormat   = "the export %s of the installation failed: %s"
+	ErrExportDeadlineFormat = "the export of the installation did not complete within %s"
+	ErrExportStatusFormat   = "%s %s responded with %s: %s"
+)
+
+// errNoAsyncExport is the answer of an ops manager without the asynchronous
+// export api
+var errNoAsyncExport = errors.New("ops manager has no asynchronous export")
+
+func ErrExportFailed(id, message string) error {
+	return fmt.Errorf(ErrExportFailedFormat, id, message)
+}
+
+func ErrExportDeadline(deadline time.Duration) error {
+	return fmt.Errorf(ErrExportDeadlineFormat, deadline)
+}
+
+func ErrExportStatus(method, url, status, body string) error {
+	return fmt.Errorf(ErrExportStatusFormat, method, url, status, strings.TrimSpace(body))
+}
+
+// AsyncExport describes exporting the installation assets through the
+// asynchronous export api of ops manager: the export is started, polled
+// until ops manager has it ready, then downloaded, resuming the download
+// where it broke off. Ops managers without the api are exported from in one
+// request, as ever
+type AsyncExport struct {
+	Enabled      bool
+	PollInterval time.Duration
+	// Deadline bounds the whole export, from starting it to the end of the
+	// download
+	Deadline time.Duration
+}
+
+type exportResponse struct {
+	Export struct {
+		ID string `json:"id"`
+	} `json:"export"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// exportAssetsAsync exports the installation assets into the file through
+// the asynchronous export api, resuming the download a backup that was
+// interrupted left behind
+func (context *OpsManager) exportAssetsAsync(filename string) (err error) {
+	var id string
+	deadline := context.AsyncExport.Deadline
+
+	if deadline <= 0 {
+		deadline = OPSMGR_DEFAULT_EXPORT_DEADLINE
+	}
+	ctx, cancel := contextWithTimeout(deadline)
+	defer cancel()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ErrExportDeadline(deadline)
+		}
+	}()
+	filePath := path.Join(context.TargetDir, context.OpsmanagerBackupDir, filename)
+	exportsURL := fmt.Sprintf(OPSMGR_EXPORTS_URL, context.Hostname)
+	client := &http.Client{Transport: ghttp.NewRoundTripper()}
+
+	if id = context.resumableExport(ctx, client, exportsURL, filePath); id == "" {
+		os.Remove(filePath + OPSMGR_EXPORT_PART_EXT)
+
+		if id, err = context.startExport(ctx, client, exportsURL); err != nil {
+			return
+		}
+
+		if err = ioutil.WriteFile(filePath+OPSMGR_EXPORT_ID_EXT, []byte(id), 0600); err != nil {
+			return
+		}
+
+		if err = context.awaitExport(ctx, client, exportsURL+"/"+id); err != nil {
+			return
+		}
+	}
+
+	if err = context.downloadExport(ctx, client, exportsURL+"/"+id+"/download", filePath+OPSMGR_EXPORT_PART_EXT); err == nil {
+		if err = os.Rename(filePath+OPSMGR_EXPORT_PART_EXT, filePath); err == nil {
+			os.Remove(filePath + OPSMGR_EXPORT_ID_EXT)
+		}
+	}
+	return
+}
+
+// resumableExport is the export an interrupted backup was downloading, when
+// ops manager still has it ready
+func (context *OpsManager) resumableExport(ctx context.Context, client *http.Client, exportsURL, filePath string) (id string) {
+	var response exportResponse
+	contents, err := ioutil.ReadFile(filePath + OPSMGR_EXPORT_ID_EXT)
+
+	if err != nil || len(contents) == 0 {
+		return ""
+	}
+	id = strings.TrimSpace(string(contents))
+
+	if _, err = context.exportRequest(ctx, client, "GET", exportsURL+"/"+id, &response); err != nil || response.Status != OPSMGR_EXPORT_SUCCEEDED {
+		return ""
+	}
+	lo.G.Info("resuming the download of export %s of the installation", id)
+	return
+}
+
+func (context *OpsManager) startExport(ctx context.Context, client *http.Client, exportsURL string) (id string, err error) {
+	var (
+		response exportResponse
+		status   int
+	)
+
+	if status, err = context.exportRequest(ctx, client, "POST", exportsURL, &response); err == nil && status == http.StatusNotFound {
+		err = errNoAsyncExport
+	}
+
+	if err == nil {
+		id = response.Export.ID
+		lo.G.Info("started export %s of the installation", id)
+	}
+	return
+}
+
+func (context *OpsManager) awaitExport(ctx context.Context, client *http.Client, exportURL string) (err error) {
+	interval := context.AsyncExport.PollInterval
+
+	if interval <= 0 {
+		interval = OPSMGR_DEFAULT_EXPORT_POLL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var response exportResponse
+
+		if _, err = context.exportRequest(ctx, client, "GET", exportURL, &response); err != nil {
+			return
+		}
+
+		switch response.Status {
+		case OPSMGR_EXPORT_SUCCEEDED:
+			return
+
+		case OPSMGR_EXPORT_FAILED:
+			return ErrExportFailed(path.Base(exportURL), response.Error)
+		}
+		lo.G.Debug("export %s of the installation is %s", path.Base(exportURL), response.Status)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// downloadExport downloads the export into the part file, asking for the
+// bytes it does not have yet whenever the download breaks off or a part was
+// left by an earlier backup. A server ignoring the range starts it over. A
+// download that broke off for any reason is resumed as the default retry
+// policy says, until the deadline of the export
+func (context *OpsManager) downloadExport(ctx context.Context, client *http.Client, downloadURL, partPath string) (err error) {
+	policy := retry.Default()
+	policy.Retryable = func(error) bool { return ctx.Err() == nil }
+	policy.OnRetry = func(_ string, _ int, err error, _ time.Duration) {
+		lo.G.Warning("the download of the installation broke off, resuming it: %s", err)
+	}
+	return policy.Do("GET "+downloadURL, func() error {
+		return context.downloadRange(ctx, client, downloadURL, partPath)
+	})
+}
+
+func (context *OpsManager) downloadRange(ctx context.Context, client *http.Client, downloadURL, partPath string) (err error) {
+	var (
+		request  *http.Request
+		response *http.Response
+		file     *os.File
+		offset   int64
+	)
+
+	if stat, statErr := os.Stat(partPath); statErr == nil {
+		offset = stat.Size()
+	}
+
+	if request, err = http.NewRequest("GET", downloadURL, nil); err != nil {
+		return
+	}
+	request = request.WithContext(ctx)
+
+	if offset > 0 {
+		request.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	if response, err = context.send(client, request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+
+	case http.StatusOK:
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part already holds the whole export
+		return nil
+
+	default:
+		body, _ := ioutil.ReadAll(response.Body)
+		return ErrExportStatus("GET", downloadURL, response.Status, string(body))
+	}
+
+	if file, err = os.OpenFile(partPath, flags, 0600); err != nil {
+		return
+	}
+	defer file.Close()
+	_, err = io.Copy(file, response.Body)
+	return
+}
+
+// exportRequest sends a request to the export api, decoding a successful
+// response into out. A not found is returned without an error
+func (context *OpsManager) exportRequest(ctx context.Context, client *http.Client, method, url string, out interface{}) (status int, err error) {
+	var (
+		request  *http.Request
+		response *http.Response
+		body     []byte
+	)
+
+	if request, err = http.NewRequest(method, url, nil); err != nil {
+		return
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+
+	if response, err = context.send(client, request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+	status = response.StatusCode
+
+	if body, err = ioutil.ReadAll(response.Body); err != nil || status == http.StatusNotFound {
+		return
+	}
+
+	if status/100 != 2 {
+		return status, ErrExportStatus(method, url, response.Status, string(body))
+	}
+	err = json.Unmarshal(body, out)
+	return
+}
+
+// contextWithTimeout is context.WithTimeout for the methods of OpsManager,
+// whose receiver hides the context package
+func contextWithTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
+}
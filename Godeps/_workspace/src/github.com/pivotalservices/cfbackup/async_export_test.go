@@ -0,0 +1,173 @@
+package cfbackup_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotalservices/cfbackup"
+)
+
+var _ = Describe("OpsManager asynchronous export", func() {
+	var (
+		opsManager *OpsManager
+		server     *httptest.Server
+		tmpDir     string
+		backupDir  string
+		mutex      sync.Mutex
+		requests   []string
+		statuses   []string
+		hasAsync   bool
+		breakOff   bool
+		accepted   string
+		assets     = strings.Repeat("installation assets ", 1000)
+	)
+
+	BeforeEach(func() {
+		tmpDir, _ = ioutil.TempDir("/tmp", "test")
+		backupDir = path.Join(tmpDir, "backup", "opsmanager")
+		requests, statuses, hasAsync, breakOff, accepted = nil, []string{"running", "succeeded"}, true, false, "password"
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+r.Header.Get("Range")))
+
+			switch _, password, _ := r.BasicAuth(); {
+			case password != accepted:
+				w.WriteHeader(http.StatusUnauthorized)
+
+			case !hasAsync && strings.HasPrefix(r.URL.Path, "/api/v0/"):
+				w.WriteHeader(http.StatusNotFound)
+
+			case r.URL.Path == "/api/installation_asset_collection":
+				fmt.Fprint(w, assets)
+
+			case r.Method == "POST":
+				fmt.Fprint(w, `{"export":{"id":"42"}}`)
+
+			case r.URL.Path == "/api/v0/installation_asset_collection/exports/42":
+				status := statuses[0]
+
+				if len(statuses) > 1 {
+					statuses = statuses[1:]
+				}
+				fmt.Fprintf(w, `{"status":%q}`, status)
+
+			case r.Header.Get("Range") != "":
+				var offset int
+				fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+				w.WriteHeader(http.StatusPartialContent)
+				fmt.Fprint(w, assets[offset:])
+
+			case breakOff:
+				// promise the whole export and hang up half way through it
+				breakOff = false
+				w.Header().Set("Content-Length", fmt.Sprint(len(assets)))
+				fmt.Fprint(w, assets[:len(assets)/2])
+
+			default:
+				fmt.Fprint(w, assets)
+			}
+		}))
+		opsManager = &OpsManager{
+			SettingsRequestor: &MockHttpGateway{StatusCode: 200, State: successString},
+			Hostname:          strings.TrimPrefix(server.URL, "https://"),
+			Username:          "user",
+			Password:          "password",
+			BackupContext: BackupContext{
+				TargetDir: path.Join(tmpDir, "backup"),
+			},
+			Executer:            &successExecuter{},
+			LocalExecuter:       NewLocalMockExecuter(),
+			DeploymentDir:       "fixtures/encryptionkey",
+			OpsmanagerBackupDir: "opsmanager",
+			AsyncExport:         AsyncExport{Enabled: true, PollInterval: time.Millisecond, Deadline: 10 * time.Second},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should start the export, wait for it and download it", func() {
+		Ω(opsManager.Backup()).Should(Succeed())
+		contents, _ := ioutil.ReadFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME))
+		Ω(string(contents)).Should(Equal(assets))
+		Ω(requests).Should(Equal([]string{
+			"POST /api/v0/installation_asset_collection/exports",
+			"GET /api/v0/installation_asset_collection/exports/42",
+			"GET /api/v0/installation_asset_collection/exports/42",
+			"GET /api/v0/installation_asset_collection/exports/42/download",
+		}))
+		Ω(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME+OPSMGR_EXPORT_ID_EXT)).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should resume a download that broke off", func() {
+		breakOff = true
+		Ω(opsManager.Backup()).Should(Succeed())
+		contents, _ := ioutil.ReadFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME))
+		Ω(string(contents)).Should(Equal(assets))
+		Ω(requests).Should(ContainElement(fmt.Sprintf("GET /api/v0/installation_asset_collection/exports/42/download bytes=%d-", len(assets)/2)))
+	})
+
+	It("should resume the download an interrupted backup left behind", func() {
+		os.MkdirAll(backupDir, 0755)
+		ioutil.WriteFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME+OPSMGR_EXPORT_ID_EXT), []byte("42"), 0600)
+		ioutil.WriteFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME+OPSMGR_EXPORT_PART_EXT), []byte(assets[:100]), 0600)
+		statuses = []string{"succeeded"}
+		Ω(opsManager.Backup()).Should(Succeed())
+		contents, _ := ioutil.ReadFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME))
+		Ω(string(contents)).Should(Equal(assets))
+		Ω(requests).Should(Equal([]string{
+			"GET /api/v0/installation_asset_collection/exports/42",
+			"GET /api/v0/installation_asset_collection/exports/42/download bytes=100-",
+		}))
+	})
+
+	It("should resolve the credentials again when ops manager refuses them", func() {
+		accepted = "rotated"
+		opsManager.Reauthenticate = func() (string, string, error) {
+			return "user", "rotated", nil
+		}
+		Ω(opsManager.Backup()).Should(Succeed())
+		contents, _ := ioutil.ReadFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME))
+		Ω(string(contents)).Should(Equal(assets))
+		Ω(opsManager.Password).Should(Equal("rotated"))
+		Ω(requests[:2]).Should(Equal([]string{
+			"POST /api/v0/installation_asset_collection/exports",
+			"POST /api/v0/installation_asset_collection/exports",
+		}))
+	})
+
+	It("should fail when ops manager refuses the credentials and they can not be resolved again", func() {
+		accepted = "rotated"
+		Ω(opsManager.Backup()).ShouldNot(Succeed())
+	})
+
+	It("should fail when the export fails", func() {
+		statuses = []string{"failed"}
+		Ω(opsManager.Backup()).Should(MatchError(ErrExportFailed("42", "")))
+	})
+
+	It("should give up on an export that is not ready by the deadline", func() {
+		statuses = []string{"running"}
+		opsManager.AsyncExport.Deadline = 20 * time.Millisecond
+		Ω(opsManager.Backup()).Should(MatchError(ErrExportDeadline(20 * time.Millisecond)))
+	})
+
+	It("should export in one request from an ops manager without the asynchronous api", func() {
+		hasAsync = false
+		Ω(opsManager.Backup()).Should(Succeed())
+		contents, _ := ioutil.ReadFile(path.Join(backupDir, OPSMGR_INSTALLATION_ASSETS_FILENAME))
+		Ω(string(contents)).Should(Equal(successString))
+	})
+})
@@ -1,6 +1,7 @@
 package cfbackup_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"errors"
 	"fmt"
@@ -147,11 +148,18 @@ var (
 	nfsFailureString string = "failed nfs"
 )
 
-type SuccessMockNFSExecuter struct{}
+type SuccessMockNFSExecuter struct {
+	Commands []string
+}
 
+// Execute answers with the tar of a blobstore holding one file of
+// nfsSuccessString
 func (s *SuccessMockNFSExecuter) Execute(dest io.Writer, cmd string) (err error) {
-	io.Copy(dest, strings.NewReader(nfsSuccessString))
-	return
+	s.Commands = append(s.Commands, cmd)
+	tw := tar.NewWriter(dest)
+	tw.WriteHeader(&tar.Header{Name: "shared/nfs", Mode: 0644, Size: int64(len(nfsSuccessString)), Typeflag: tar.TypeReg})
+	io.WriteString(tw, nfsSuccessString)
+	return tw.Close()
 }
 
 var (
@@ -0,0 +1,173 @@
+package cfbackup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	// compressedMagic are the first bytes of the formats blobs are already
+	// compressed in: gzip for droplets and buildpacks, zip for packages and
+	// resources, and bzip2, xz, zstd, png and jpeg
+	compressedMagic = [][]byte{
+		{0x1f, 0x8b},
+		{'P', 'K', 0x03, 0x04},
+		{'B', 'Z', 'h'},
+		{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		{0x28, 0xb5, 0x2f, 0xfd},
+		{0x89, 'P', 'N', 'G'},
+		{0xff, 0xd8, 0xff},
+	}
+	compressedExtensions = []string{".gz", ".tgz", ".zip", ".jar", ".war", ".bz2", ".xz", ".zst", ".png", ".jpg", ".jpeg"}
+)
+
+// BlobArchive writes a tar.gz of blobstore files, as tar.Writer does, but
+// stores the files that are already compressed rather than compressing them
+// again, which costs hours of cpu for next to no saving. The stored files go
+// into gzip members of their own, which gzip and tar read back as the one
+// stream, so that the nfs server restores the archive as before
+type BlobArchive struct {
+	out     io.Writer
+	member  *gzip.Writer
+	stored  bool
+	target  *memberWriter
+	tar     *tar.Writer
+	pending *tar.Header
+}
+
+// memberWriter writes to the current gzip member of a BlobArchive
+type memberWriter struct {
+	io.Writer
+}
+
+func NewBlobArchive(out io.Writer) *BlobArchive {
+	target := &memberWriter{}
+	return &BlobArchive{out: out, target: target, tar: tar.NewWriter(target)}
+}
+
+// WriteHeader starts the next entry. The header of a file is only written
+// with its first bytes, which tell whether the file is compressed already
+func (s *BlobArchive) WriteHeader(header *tar.Header) (err error) {
+	if err = s.writePending(nil); err != nil {
+		return
+	}
+
+	if (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) && header.Size > 0 {
+		s.pending = header
+		return
+	}
+	return s.writeHeader(header, s.stored)
+}
+
+func (s *BlobArchive) Write(p []byte) (n int, err error) {
+	if err = s.writePending(p); err != nil {
+		return
+	}
+	return s.tar.Write(p)
+}
+
+// Close ends the archive and its last gzip member
+func (s *BlobArchive) Close() (err error) {
+	if err = s.writePending(nil); err != nil {
+		return
+	}
+
+	if err = s.useMember(s.stored); err != nil {
+		return
+	}
+
+	if err = s.tar.Close(); err == nil {
+		err = s.member.Close()
+	}
+	return
+}
+
+func (s *BlobArchive) writePending(head []byte) (err error) {
+	if header := s.pending; header != nil {
+		s.pending = nil
+		err = s.writeHeader(header, alreadyCompressed(header.Name, head))
+	}
+	return
+}
+
+func (s *BlobArchive) writeHeader(header *tar.Header, stored bool) (err error) {
+	if err = s.useMember(stored); err == nil {
+		err = s.tar.WriteHeader(header)
+	}
+	return
+}
+
+// useMember ends the current gzip member and starts the next when the next
+// entry is not compressed the way the current member is
+func (s *BlobArchive) useMember(stored bool) (err error) {
+	level := gzip.DefaultCompression
+
+	if s.member != nil && s.stored == stored {
+		return
+	}
+
+	if s.member != nil {
+		// the padding of the last entry belongs to the member it is in
+		if err = s.tar.Flush(); err != nil {
+			return
+		}
+
+		if err = s.member.Close(); err != nil {
+			return
+		}
+	}
+
+	if stored {
+		level = gzip.NoCompression
+	}
+
+	if s.member, err = gzip.NewWriterLevel(s.out, level); err == nil {
+		s.stored = stored
+		s.target.Writer = s.member
+	}
+	return
+}
+
+// alreadyCompressed tells by its first bytes or, failing that, its extension
+// whether a blob is compressed already
+func alreadyCompressed(name string, head []byte) bool {
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	extension := strings.ToLower(path.Ext(name))
+
+	for _, compressed := range compressedExtensions {
+		if extension == compressed {
+			return true
+		}
+	}
+	return false
+}
+
+// repackBlobs writes the entries of the plain tar in to out as a BlobArchive
+func repackBlobs(in io.Reader, out io.Writer) (err error) {
+	var header *tar.Header
+	entries := tar.NewReader(in)
+	archive := NewBlobArchive(out)
+
+	for header, err = entries.Next(); err == nil; header, err = entries.Next() {
+		if err = archive.WriteHeader(header); err != nil {
+			return
+		}
+
+		if _, err = io.Copy(archive, entries); err != nil {
+			return
+		}
+	}
+
+	if err == io.EOF {
+		err = archive.Close()
+	}
+	return
+}
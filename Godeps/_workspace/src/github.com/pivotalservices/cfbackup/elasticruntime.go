@@ -1,13 +1,21 @@
 package cfbackup
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	. "github.com/pivotalservices/gtils/http"
 	"github.com/pivotalservices/gtils/log"
@@ -31,6 +39,17 @@ const (
 	ER_NO_PERSISTENCE_ARCHIVES    = "there are no persistence stores in the list"
 	ER_FILE_DOES_NOT_EXIST        = "file does not exist"
 	ER_DB_BACKUP_FAILURE          = "failed to backup database"
+	ER_CC_JOBS_CACHE_KEY          = "cc_jobs/"
+	ER_CC_NOT_QUIESCED_MSG        = "unable to stop the cloud controller for a consistent backup"
+	ER_CC_NOT_RESUMED_MSG         = "unable to start the cloud controller again, start its jobs with bosh"
+	ER_UNREACHABLE_MSG            = "leaving %s out of the backup, its vm can not be reached: %s"
+	ER_PHASE_CONNECT              = "connect"
+	ER_PHASE_DUMP                 = "dump"
+	ER_PHASE_RESTORE              = "restore"
+	// ER_DUMP_BUFFER_SIZE is how much of a dump is gathered before it is
+	// written to its archive, so that the archive takes few large writes
+	// rather than one for each ssh packet
+	ER_DUMP_BUFFER_SIZE = 4 << 20
 )
 
 const (
@@ -39,12 +58,62 @@ const (
 )
 
 var (
-	ER_ERROR_DIRECTOR_CREDS = errors.New(ER_INVALID_DIRECTOR_CREDS_MSG)
-	ER_ERROR_EMPTY_DB_LIST  = errors.New(ER_NO_PERSISTENCE_ARCHIVES)
-	ER_ERROR_INVALID_PATH   = &os.PathError{Err: errors.New(ER_FILE_DOES_NOT_EXIST)}
-	ER_DB_BACKUP            = errors.New(ER_DB_BACKUP_FAILURE)
+	ER_ERROR_DIRECTOR_CREDS  = errors.New(ER_INVALID_DIRECTOR_CREDS_MSG)
+	ER_ERROR_EMPTY_DB_LIST   = errors.New(ER_NO_PERSISTENCE_ARCHIVES)
+	ER_ERROR_INVALID_PATH    = &os.PathError{Err: errors.New(ER_FILE_DOES_NOT_EXIST)}
+	ER_DB_BACKUP             = errors.New(ER_DB_BACKUP_FAILURE)
+	ER_ERROR_CC_NOT_QUIESCED = errors.New(ER_CC_NOT_QUIESCED_MSG)
+	ER_ERROR_CC_NOT_RESUMED  = errors.New(ER_CC_NOT_RESUMED_MSG)
+	// ER_CC_COUPLED_COMPONENTS are the stores that must be dumped at the same
+	// point in time, the cloud controller database references the blobstore
+	ER_CC_COUPLED_COMPONENTS = []string{"ccdb", "nfs_server"}
+	// ER_PRIORITY_COMPONENTS are the small stores a recovery can not do
+	// without, in the order they are backed up and restored. Every other
+	// store, the blobstore above all, follows them, so that a run that dies
+	// during a multi-hour blobstore copy still leaves them complete. A backup
+	// with a consistency window moves ccdb after the others, next to the
+	// blobstore it is dumped with
+	ER_PRIORITY_COMPONENTS = []string{"ccdb", "uaadb", "consoledb", "mysql"}
+	// ER_UNREACHABLE_MESSAGES tell apart the failures to connect to the vm of
+	// a store from the failures of the store itself
+	ER_UNREACHABLE_MESSAGES = []string{"connection refused", "no route to host", "network is unreachable", "host is down", "i/o timeout", "connection timed out"}
 )
 
+// Checkpoint records the persistence stores an action has completed so that
+// a re-run of an interrupted action can skip them
+type Checkpoint interface {
+	Completed(step string) bool
+	MarkCompleted(step string) error
+}
+
+// Tracker is told when the action on each persistence store starts, and
+// returns the function to call with its outcome. The phases of the action on
+// a store are reported as "<component>/<phase>" steps
+type Tracker interface {
+	StartStep(step string) (finish func(err error))
+}
+
+// MetadataCache keeps what the director reports about a deployment between
+// runs. Get fills v and returns true when the key is cached
+type MetadataCache interface {
+	Get(key string, v interface{}) bool
+	Put(key string, v interface{}) error
+}
+
+// cachedCCJobs are the cloud controller jobs of a deployment, as they were
+// when its manifest had the sum
+type cachedCCJobs struct {
+	ManifestSum string  `json:"manifest_sum"`
+	Jobs        []CCJob `json:"jobs"`
+}
+
+// ProgressTracker is a Tracker that can also follow the bytes a dump or a
+// restore has streamed so far, out of the total when it is known
+type ProgressTracker interface {
+	Tracker
+	StartTransfer(step string, total int64, transferred func() int64) (finish func(err error))
+}
+
 // ElasticRuntime contains information about a Pivotal Elastic Runtime deployment
 type ElasticRuntime struct {
 	JsonFile          string
@@ -52,6 +121,41 @@ type ElasticRuntime struct {
 	PersistentSystems []SystemDump
 	HttpGateway       HttpGateway
 	InstallationName  string
+	Checkpoint        Checkpoint
+	Tracker           Tracker
+	// ConsistencyWindow, when set, stops the cloud controller during a backup
+	// only while its database and the blobstore are dumped back to back,
+	// instead of for the whole backup. Exceeding the window is logged
+	ConsistencyWindow time.Duration
+	// RestoreRate caps, in bytes a second, how fast each store is restored
+	// from its archive. It does not limit backups
+	RestoreRate int64
+	// RestoreConcurrency is how many stores are restored at once, one after
+	// the other when unset
+	RestoreConcurrency int
+	// AdaptiveConcurrency starts a restore at half of RestoreConcurrency, or
+	// of every store when that is unset, and adapts how many stores are
+	// restored at once to the throughput they reach
+	AdaptiveConcurrency bool
+	controller          *ConcurrencyController
+	// BlobstoreMirror is a local directory the nfs blobstore is synced to, so
+	// that each backup copies only the blobs changed since the last one
+	BlobstoreMirror string
+	// Bandwidth, when set, caps the bandwidth of the dumps and restores of
+	// the stores, and may be shared with other transfers
+	Bandwidth *TransferLimiter
+	// Metadata, when set, keeps the cloud controller jobs of the deployment
+	// between runs, asking the director again once its manifest changes
+	Metadata MetadataCache
+	// TransferSegments is how many byte ranges of a large archive a restore
+	// uploads at once, each on a connection of its own
+	TransferSegments int
+	// SkipUnreachable lets a backup go on without the stores whose vm can
+	// not be connected to, listing them in Unreachable, rather than failing
+	SkipUnreachable bool
+	// Unreachable are the stores a backup left out. Backups dump one store
+	// at a time
+	Unreachable []string
 	BackupContext
 }
 
@@ -146,26 +250,38 @@ func (context *ElasticRuntime) Restore() (err error) {
 
 func (context *ElasticRuntime) backupRestore(action int) (err error) {
 	var (
-		ccJobs []CCJob
+		ccJobs          []CCJob
+		cloudController *CloudController
+		manifest        string
 	)
 
 	if err = context.ReadAllUserCredentials(); err == nil && context.directorCredentialsValid() {
 		lo.G.Debug("Retrieving All CC VMs")
-		manifest, erro := context.getManifest()
-		if err != nil {
-			return erro
+		if manifest, err = context.getManifest(); err != nil {
+			return
 		}
-		if ccJobs, err = context.getAllCloudControllerVMs(); err == nil {
-			directorInfo := context.SystemsInfo[ER_DIRECTOR]
-			cloudController := NewCloudController(directorInfo.Get(SD_IP), directorInfo.Get(SD_USER), directorInfo.Get(SD_PASS), context.InstallationName, manifest, ccJobs)
-			lo.G.Debug("Setting up CC jobs")
-			defer cloudController.Start()
-			cloudController.Stop()
+
+		// without the cloud controller vms they can not be stopped, and a
+		// run on a live cloud controller is not consistent
+		if ccJobs, err = context.cloudControllerVMs(manifest); err != nil {
+			lo.G.Error("unable to list the CC vms", err)
+			return ER_ERROR_CC_NOT_QUIESCED
 		}
+		directorInfo := context.SystemsInfo[ER_DIRECTOR]
+		cloudController = NewCloudController(directorInfo.Get(SD_IP), directorInfo.Get(SD_USER), directorInfo.Get(SD_PASS), context.InstallationName, manifest, ccJobs)
+		lo.G.Debug("Setting up CC jobs")
 		lo.G.Debug("Running db action")
 		if len(context.PersistentSystems) > 0 {
-			err = context.RunDbAction(context.PersistentSystems, action)
-			if err != nil {
+			if action == EXPORT_ARCHIVE && context.ConsistencyWindow > 0 {
+				err = context.RunDbActionAtConsistencyPoint(cloudController, action)
+
+			} else {
+				err = context.whileQuiesced(cloudController, func() error {
+					return context.RunDbAction(context.PersistentSystems, action)
+				})
+			}
+
+			if err != nil && err != ER_ERROR_CC_NOT_QUIESCED && err != ER_ERROR_CC_NOT_RESUMED {
 				lo.G.Error("Error backing up db", err)
 				err = ER_DB_BACKUP
 			}
@@ -178,6 +294,31 @@ func (context *ElasticRuntime) backupRestore(action int) (err error) {
 	return
 }
 
+// cloudControllerVMs are the cloud controller jobs of the deployment, from the
+// metadata cache while the manifest is the one they were listed with, since
+// listing the vms of a large deployment takes the director minutes
+func (context *ElasticRuntime) cloudControllerVMs(manifest string) (ccvms []CCJob, err error) {
+	var cached cachedCCJobs
+	sum := sha256.Sum256([]byte(manifest))
+	key := ER_CC_JOBS_CACHE_KEY + context.InstallationName
+
+	if context.Metadata == nil {
+		return context.getAllCloudControllerVMs()
+	}
+
+	if context.Metadata.Get(key, &cached) && cached.ManifestSum == hex.EncodeToString(sum[:]) && len(cached.Jobs) > 0 {
+		lo.G.Debug("Using the cached CC vms")
+		return cached.Jobs, nil
+	}
+
+	if ccvms, err = context.getAllCloudControllerVMs(); err == nil && len(ccvms) > 0 {
+		if cacheErr := context.Metadata.Put(key, cachedCCJobs{ManifestSum: hex.EncodeToString(sum[:]), Jobs: ccvms}); cacheErr != nil {
+			lo.G.Error("unable to cache the CC vms", cacheErr)
+		}
+	}
+	return
+}
+
 func (context *ElasticRuntime) getAllCloudControllerVMs() (ccvms []CCJob, err error) {
 
 	lo.G.Debug("Entering getAllCloudControllerVMs() function")
@@ -189,17 +330,23 @@ func (context *ElasticRuntime) getAllCloudControllerVMs() (ccvms []CCJob, err er
 		gateway = NewHttpGateway()
 	}
 	lo.G.Debug("Retrieving CC vms")
-	if resp, err := gateway.Get(HttpRequestEntity{
+	resp, err := gateway.Get(HttpRequestEntity{
 		Url:         connectionURL,
 		Username:    directorInfo.Get(SD_USER),
 		Password:    directorInfo.Get(SD_PASS),
 		ContentType: "application/json",
-	})(); err == nil {
-		var jsonObj []VMObject
+	})()
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var (
+		jsonObj []VMObject
+		body    []byte
+	)
 
-		lo.G.Debug("Unmarshalling CC vms")
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
+	lo.G.Debug("Unmarshalling CC vms")
+	if body, err = ioutil.ReadAll(resp.Body); err == nil {
 		if err = json.Unmarshal(body, &jsonObj); err == nil {
 			ccvms, err = GetCCVMs(jsonObj)
 		}
@@ -208,20 +355,221 @@ func (context *ElasticRuntime) getAllCloudControllerVMs() (ccvms []CCJob, err er
 }
 
 func (context *ElasticRuntime) RunDbAction(dbInfoList []SystemDump, action int) (err error) {
+	dbInfoList = prioritized(dbInfoList)
+
+	if action == IMPORT_ARCHIVE && context.AdaptiveConcurrency {
+		concurrency := context.RestoreConcurrency
+
+		if concurrency < 2 {
+			concurrency = len(dbInfoList)
+		}
+		context.controller = NewConcurrencyController((concurrency+1)/2, concurrency)
+		defer func() { context.controller = nil }()
+		defer context.controller.Run(ER_ADAPTIVE_SAMPLE_INTERVAL)()
+		return context.runDbActionConcurrently(dbInfoList, action, context.controller)
+	}
+
+	if action == IMPORT_ARCHIVE && context.RestoreConcurrency > 1 {
+		return context.runDbActionConcurrently(dbInfoList, action, NewConcurrencyController(context.RestoreConcurrency, context.RestoreConcurrency))
+	}
 
 	for _, info := range dbInfoList {
-		lo.G.Debug(fmt.Sprintf("%v", info))
+		if err = context.runDbActionOn(info, action); err != nil {
+			break
+		}
+	}
+	return
+}
 
-		if err = info.Error(); err == nil {
-			err = context.readWriterArchive(info, context.TargetDir, action)
-			lo.G.Debug("backed up db", log.Data{"info": info})
-		} else {
+// runDbActionConcurrently runs the action against as many stores at once as
+// the controller allows. No store is started once one has failed, and the
+// first error is returned after the ones in progress finish
+func (context *ElasticRuntime) runDbActionConcurrently(dbInfoList []SystemDump, action int, controller *ConcurrencyController) (err error) {
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		failed bool
+	)
+
+	for _, info := range dbInfoList {
+		controller.Acquire()
+		mutex.Lock()
+		stop := failed
+		mutex.Unlock()
+
+		if stop {
+			controller.Release(nil)
 			break
 		}
+		wg.Add(1)
+
+		go func(info SystemDump) {
+			defer wg.Done()
+			actionErr := context.runDbActionOn(info, action)
+			mutex.Lock()
+
+			if actionErr != nil && !failed {
+				failed, err = true, actionErr
+			}
+			mutex.Unlock()
+			controller.Release(actionErr)
+		}(info)
+	}
+	wg.Wait()
+	return
+}
+
+func (context *ElasticRuntime) runDbActionOn(info SystemDump, action int) (err error) {
+	lo.G.Debug(fmt.Sprintf("%v", info))
+	component := info.Get(SD_COMPONENT)
+
+	if context.Checkpoint != nil && context.Checkpoint.Completed(component) {
+		lo.G.Info("Skipping completed step " + component)
+		return
+	}
+
+	finish := context.startStep(component)
+
+	if err = info.Error(); err == nil {
+		err = context.readWriterArchive(info, context.TargetDir, action)
+		lo.G.Debug("backed up db", log.Data{"info": info})
+	}
+
+	if err == nil && context.Checkpoint != nil {
+		err = context.Checkpoint.MarkCompleted(component)
+	}
+
+	finish(err)
+
+	if err != nil && action == EXPORT_ARCHIVE && context.SkipUnreachable && IsUnreachable(err) {
+		lo.G.Error(fmt.Sprintf(ER_UNREACHABLE_MSG, component, err))
+		os.Remove(path.Join(context.TargetDir, fmt.Sprintf(ER_BACKUP_FILE_FORMAT, component)))
+		context.Unreachable = append(context.Unreachable, component)
+		err = nil
+	}
+	return
+}
+
+// IsUnreachable tells whether the error is a failure to connect to a vm,
+// rather than one of the store on it
+func IsUnreachable(err error) bool {
+	var opErr *net.OpError
+
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+
+	for _, message := range ER_UNREACHABLE_MESSAGES {
+		if strings.Contains(msg, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunDbActionAtConsistencyPoint runs the action against the stores that do not
+// reference the blobstore while the cloud controller keeps running, then stops
+// it only while its database and the blobstore are dumped back to back. The
+// stores keep the order of ER_PRIORITY_COMPONENTS, except that ccdb waits for
+// the consistency point: it follows the other priority stores, and still
+// precedes the blobstore
+func (context *ElasticRuntime) RunDbActionAtConsistencyPoint(cloudController *CloudController, action int) (err error) {
+	var coupled, independent []SystemDump
+
+	for _, info := range prioritized(context.PersistentSystems) {
+		if isCCCoupled(info.Get(SD_COMPONENT)) {
+			coupled = append(coupled, info)
+
+		} else {
+			independent = append(independent, info)
+		}
+	}
+
+	if err = context.RunDbAction(independent, action); err != nil || len(coupled) == 0 {
+		return
+	}
+
+	if cloudController == nil {
+		return ER_ERROR_CC_NOT_QUIESCED
+	}
+	var stopped time.Time
+
+	if err = context.whileQuiesced(cloudController, func() error {
+		stopped = time.Now()
+		return context.RunDbAction(coupled, action)
+	}); stopped.IsZero() {
+		return
+	}
+
+	if downtime := time.Since(stopped); downtime > context.ConsistencyWindow {
+		lo.G.Error("cloud controller was stopped for %s, longer than the %s consistency window", downtime, context.ConsistencyWindow)
+
+	} else {
+		lo.G.Info("cloud controller was stopped for %s", downtime)
+	}
+	return
+}
+
+// whileQuiesced runs the critical phase with the cloud controller stopped,
+// and starts it again once the phase is over however it ends
+func (context *ElasticRuntime) whileQuiesced(cloudController *CloudController, phase func() error) (err error) {
+	var resume func() error
+
+	if resume, err = cloudController.Quiesce(); err != nil {
+		lo.G.Error("unable to stop the cloud controller: %s", err)
+		return ER_ERROR_CC_NOT_QUIESCED
+	}
+
+	defer func() {
+		if resumeErr := resume(); resumeErr != nil {
+			lo.G.Error("unable to start the cloud controller again: %s", resumeErr)
+
+			if err == nil {
+				err = ER_ERROR_CC_NOT_RESUMED
+			}
+		}
+	}()
+	return phase()
+}
+
+func isCCCoupled(component string) bool {
+	for _, coupled := range ER_CC_COUPLED_COMPONENTS {
+		if component == coupled {
+			return true
+		}
+	}
+	return false
+}
+
+// prioritized orders the stores as ER_PRIORITY_COMPONENTS does, keeping the
+// order of the stores it does not list after them
+func prioritized(dbInfoList []SystemDump) (ordered []SystemDump) {
+	for _, component := range ER_PRIORITY_COMPONENTS {
+		for _, info := range dbInfoList {
+			if info.Get(SD_COMPONENT) == component {
+				ordered = append(ordered, info)
+			}
+		}
+	}
+
+	for _, info := range dbInfoList {
+		if !isPriority(info.Get(SD_COMPONENT)) {
+			ordered = append(ordered, info)
+		}
 	}
 	return
 }
 
+func isPriority(component string) bool {
+	for _, priority := range ER_PRIORITY_COMPONENTS {
+		if component == priority {
+			return true
+		}
+	}
+	return false
+}
+
 func (context *ElasticRuntime) getReadWriter(fpath string, action int) (rw io.ReadWriter, err error) {
 	switch action {
 	case IMPORT_ARCHIVE:
@@ -252,28 +600,154 @@ func (context *ElasticRuntime) readWriterArchive(dbInfo SystemDump, databaseDir
 
 	if archivefile, err = context.getReadWriter(filepath, action); err == nil {
 		err = context.importExport(archivefile, dbInfo, action)
+
+		if closer, ok := archivefile.(io.Closer); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
 	}
 	return
 }
 
 func (context *ElasticRuntime) importExport(rw io.ReadWriter, s SystemDump, action int) (err error) {
 	var pb PersistanceBackup
+	component := s.Get(SD_COMPONENT)
+	finish := context.startStep(component + "/" + ER_PHASE_CONNECT)
+	pb, err = s.GetPersistanceBackup()
+	finish(err)
 
-	if pb, err = s.GetPersistanceBackup(); err == nil {
+	if err == nil {
 
 		switch action {
 		case IMPORT_ARCHIVE:
+			counter := &countingReadWriter{ReadWriter: rw, buckets: context.Bandwidth.buckets(component), controller: context.controller}
 			lo.G.Debug("we are doing something here now")
-			err = pb.Import(rw)
+			finish = context.startTransfer(component+"/"+ER_PHASE_RESTORE, archiveSize(rw), counter.Count)
+			err = pb.Import(segmentArchive(throttledReader(counter, context.RestoreRate), counter, context.TransferSegments, context.RestoreRate))
 
 		case EXPORT_ARCHIVE:
 			lo.G.Info("Dumping database to file")
-			err = pb.Dump(rw)
+
+			if nfs, ok := pb.(*NFSBackup); ok {
+				nfs.Mirror = context.BlobstoreMirror
+			}
+			// the dump is read straight into the buffer, which the archive
+			// is written from, see countingReadWriter.ReadFrom
+			buffered := bufio.NewWriterSize(writerOnly{rw}, ER_DUMP_BUFFER_SIZE)
+			counter := &countingReadWriter{ReadWriter: bufferedArchive{rw, buffered}, buckets: context.Bandwidth.buckets(component)}
+			finish = context.startTransfer(component+"/"+ER_PHASE_DUMP, 0, counter.Count)
+
+			if err = pb.Dump(counter); err == nil {
+				err = buffered.Flush()
+			}
+		}
+		finish(err)
+	}
+	return
+}
+
+// startStep tells the tracker, when there is one, that a step has started
+func (context *ElasticRuntime) startStep(step string) (finish func(error)) {
+	if context.Tracker == nil {
+		return func(error) {}
+	}
+	return context.Tracker.StartStep(step)
+}
+
+// startTransfer starts a step that streams an archive, following its progress
+// when the tracker can. A dump does not know its total ahead of time
+func (context *ElasticRuntime) startTransfer(step string, total int64, transferred func() int64) (finish func(error)) {
+	if progress, ok := context.Tracker.(ProgressTracker); ok {
+		return progress.StartTransfer(step, total, transferred)
+	}
+	return context.startStep(step)
+}
+
+// archiveSize is the size of the archive a restore reads, or 0 when it can not
+// be told
+func archiveSize(rw io.ReadWriter) int64 {
+	if file, ok := rw.(interface {
+		Stat() (os.FileInfo, error)
+	}); ok {
+		if info, err := file.Stat(); err == nil {
+			return info.Size()
 		}
 	}
+	return 0
+}
+
+// countingReadWriter counts the bytes read from or written to an archive,
+// spending them from the bandwidth buckets of the transfer
+type countingReadWriter struct {
+	io.ReadWriter
+	count      int64
+	buckets    []*tokenBucket
+	controller *ConcurrencyController
+}
+
+func (s *countingReadWriter) Read(p []byte) (n int, err error) {
+	n, err = s.ReadWriter.Read(p)
+	s.transferred(n)
+	return
+}
+
+func (s *countingReadWriter) Write(p []byte) (n int, err error) {
+	n, err = s.ReadWriter.Write(p)
+	s.transferred(n)
 	return
 }
 
+func (s *countingReadWriter) transferred(n int) {
+	atomic.AddInt64(&s.count, int64(n))
+
+	for _, bucket := range s.buckets {
+		bucket.take(n)
+	}
+
+	if s.controller != nil {
+		s.controller.Transferred(n)
+	}
+}
+
+// ReadFrom lets io.Copy, as the executers stream a dump with, hand the reader
+// to the archive when the archive reads from readers itself, rather than
+// copying through a small buffer of its own
+func (s *countingReadWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	return io.Copy(s.ReadWriter, &countingReader{Reader: r, counter: s})
+}
+
+// countingReader counts the bytes read as transferred by a countingReadWriter
+type countingReader struct {
+	io.Reader
+	counter *countingReadWriter
+}
+
+func (s *countingReader) Read(p []byte) (n int, err error) {
+	n, err = s.Reader.Read(p)
+	s.counter.transferred(n)
+	return
+}
+
+// bufferedArchive writes to an archive through a buffer, reading from it
+// directly
+type bufferedArchive struct {
+	io.Reader
+	*bufio.Writer
+}
+
+// writerOnly hides the ReadFrom of an archive file from the buffer in front
+// of it, which would otherwise hand every read of a dump straight to the
+// file, in small writes
+type writerOnly struct {
+	io.Writer
+}
+
+// Count is the number of bytes transferred so far
+func (s *countingReadWriter) Count() int64 {
+	return atomic.LoadInt64(&s.count)
+}
+
 func (context *ElasticRuntime) ReadAllUserCredentials() (err error) {
 	var (
 		fileRef *os.File
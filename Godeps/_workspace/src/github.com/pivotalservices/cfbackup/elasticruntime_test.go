@@ -1,14 +1,22 @@
 package cfbackup_test
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	. "github.com/pivotalservices/cfbackup"
+	"github.com/pivotalservices/gtils/bosh"
 	"github.com/pivotalservices/gtils/osutils"
 
 	. "github.com/onsi/ginkgo"
@@ -18,18 +26,41 @@ import (
 var (
 	ERROR_IMPORT error = errors.New("failed import")
 	ERROR_DUMP   error = errors.New("failed dump")
+	// ccVMs is what the director lists for a deployment with one cloud controller
+	ccVMs = `[{"Job": "cloud_controller-partition-2", "Index": 0}, {"Job": "router-partition-1", "Index": 0}]`
 )
 
 type PgInfoMock struct {
 	SystemInfo
 	failImport bool
 	failDump   bool
+	dumpSize   int
+	segments   *map[int64]int64
+	// unreachable fails to connect to the vm of the store
+	unreachable bool
+}
+
+type mockTracker struct {
+	steps []string
+}
+
+// StartStep records the steps of whole stores, not their phases
+func (s *mockTracker) StartStep(step string) func(error) {
+	if !strings.Contains(step, "/") {
+		s.steps = append(s.steps, step)
+	}
+	return func(error) {}
 }
 
 func (s *PgInfoMock) GetPersistanceBackup() (dumper PersistanceBackup, err error) {
+	if s.unreachable {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}
+	}
 	dumper = &mockDumper{
 		failImport: s.failImport,
 		failDump:   s.failDump,
+		dumpSize:   s.dumpSize,
+		segments:   s.segments,
 	}
 	return
 }
@@ -37,9 +68,33 @@ func (s *PgInfoMock) GetPersistanceBackup() (dumper PersistanceBackup, err error
 type mockDumper struct {
 	failImport bool
 	failDump   bool
+	dumpSize   int
+	segments   *map[int64]int64
+}
+
+type mockMetadataCache struct {
+	entries map[string]string
+	gets    []string
+}
+
+func (s *mockMetadataCache) Get(key string, v interface{}) bool {
+	s.gets = append(s.gets, key)
+	entry, ok := s.entries[key]
+	return ok && json.Unmarshal([]byte(entry), v) == nil
+}
+
+func (s *mockMetadataCache) Put(key string, v interface{}) error {
+	entry, err := json.Marshal(v)
+	s.entries[key] = string(entry)
+	return err
 }
 
 func (s mockDumper) Dump(i io.Writer) (err error) {
+	if s.dumpSize > 0 {
+		// streamed the way the executers stream a dump over ssh
+		_, err = io.Copy(i, io.LimitReader(bytes.NewReader(make([]byte, s.dumpSize)), int64(s.dumpSize)))
+		return
+	}
 	i.Write([]byte("sometext"))
 
 	if s.failDump {
@@ -51,6 +106,13 @@ func (s mockDumper) Dump(i io.Writer) (err error) {
 func (s mockDumper) Import(i io.Reader) (err error) {
 	i.Read([]byte("sometext"))
 
+	if segmenter, ok := i.(osutils.Segmenter); ok && s.segments != nil {
+		// read as the upload does, each segment from its offset
+		for _, segment := range segmenter.Segments() {
+			(*s.segments)[segment.Offset], _ = io.Copy(ioutil.Discard, segment.Reader)
+		}
+	}
+
 	if s.failImport {
 		err = ERROR_IMPORT
 	}
@@ -108,9 +170,13 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 
 			BeforeEach(func() {
 				target, _ = ioutil.TempDir("/tmp", "spec")
+				manifest = strings.NewReader("manifest")
+				getManifest, changeJobState, getTaskStatus = true, true, true
+				task = bosh.Task{State: "done"}
+				changeJobStateCount = 0
 				er = ElasticRuntime{
 					JsonFile:    installationSettingsFilePath,
-					HttpGateway: &MockHttpGateway{},
+					HttpGateway: &MockHttpGateway{State: ccVMs},
 					BackupContext: BackupContext{
 						TargetDir: target,
 					},
@@ -131,6 +197,69 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 					})
 				})
 
+				Context("With cached cloud controller jobs", func() {
+					var cache *mockMetadataCache
+
+					cacheJobs := func(manifestSum string) {
+						er.ReadAllUserCredentials()
+						cache = &mockMetadataCache{entries: map[string]string{
+							ER_CC_JOBS_CACHE_KEY + er.InstallationName: `{"manifest_sum": "` + manifestSum + `", "jobs": [{"Job": "cloud_controller-partition-1", "Index": 0}]}`,
+						}}
+						er.Metadata = cache
+					}
+
+					It("Should stop the cached jobs while the manifest is unchanged", func() {
+						sum := sha256.Sum256([]byte("manifest"))
+						cacheJobs(hex.EncodeToString(sum[:]))
+						er.HttpGateway = &MockHttpGateway{}
+						Ω(er.Backup()).Should(BeNil())
+						Ω(cache.gets).Should(Equal([]string{ER_CC_JOBS_CACHE_KEY + er.InstallationName}))
+						Ω(changeJobStateCount).Should(Equal(2))
+					})
+
+					It("Should ask the director again once the manifest changed", func() {
+						sum := sha256.Sum256([]byte("manifest"))
+						cacheJobs("sum of an older manifest")
+						Ω(er.Backup()).Should(BeNil())
+						Ω(cache.entries[ER_CC_JOBS_CACHE_KEY+er.InstallationName]).Should(ContainSubstring(hex.EncodeToString(sum[:])))
+						Ω(cache.entries[ER_CC_JOBS_CACHE_KEY+er.InstallationName]).Should(ContainSubstring("cloud_controller-partition-2"))
+					})
+				})
+
+				Context("With a consistency window", func() {
+					It("Should dump the critical stores before the blobstore, ccdb at the consistency point", func() {
+						tracker := &mockTracker{}
+						er.Tracker = tracker
+						er.ConsistencyWindow = time.Minute
+						er.PersistentSystems = nil
+
+						for _, component := range []string{"nfs_server", "mysql", "consoledb", "uaadb", "ccdb"} {
+							system := info["ConsoledbInfo"].(*PgInfoMock).SystemInfo
+							system.Component = component
+							er.PersistentSystems = append(er.PersistentSystems, &PgInfoMock{SystemInfo: system})
+						}
+						Ω(er.Backup()).Should(BeNil())
+						Ω(tracker.steps).Should(Equal([]string{"uaadb", "consoledb", "mysql", "ccdb", "nfs_server"}))
+						Ω(changeJobStateCount).Should(Equal(2))
+					})
+				})
+
+				Context("When the cloud controller vms can not be listed", func() {
+					It("Should not back up a live cloud controller", func() {
+						er.HttpGateway = &MockHttpGateway{State: `[{"Job": "router-partition-1", "Index": 0}]`}
+						Ω(er.Backup()).Should(Equal(ER_ERROR_CC_NOT_QUIESCED))
+						Ω(changeJobStateCount).Should(Equal(0))
+					})
+				})
+
+				Context("When the manifest can not be read", func() {
+					It("Should fail the backup", func() {
+						getManifest = false
+						Ω(er.Backup()).ShouldNot(BeNil())
+						Ω(changeJobStateCount).Should(Equal(0))
+					})
+				})
+
 				Context("Restore", func() {
 					var filename string = fmt.Sprintf("%s.backup", component)
 
@@ -263,9 +392,8 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 				})
 
 				It("Should not panic", func() {
-					var err error
 					Ω(func() {
-						err = er.Backup()
+						er.Backup()
 					}).ShouldNot(Panic())
 				})
 			})
@@ -279,9 +407,8 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 				})
 
 				It("Should not panic", func() {
-					var err error
 					Ω(func() {
-						err = er.Restore()
+						er.Restore()
 					}).ShouldNot(Panic())
 				})
 			})
@@ -309,9 +436,13 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 
 			BeforeEach(func() {
 				target, _ = ioutil.TempDir("/tmp", "spec")
+				manifest = strings.NewReader("manifest")
+				getManifest, changeJobState, getTaskStatus = true, true, true
+				task = bosh.Task{State: "done"}
+				changeJobStateCount = 0
 				er = ElasticRuntime{
 					JsonFile:    installationSettingsFilePath,
-					HttpGateway: &MockHttpGateway{},
+					HttpGateway: &MockHttpGateway{State: ccVMs},
 					BackupContext: BackupContext{
 						TargetDir: target,
 					},
@@ -339,6 +470,73 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 					}).ShouldNot(Panic())
 					Ω(err).Should(BeNil())
 				})
+
+				It("Should write the whole of a dump larger than its buffer", func() {
+					size := 2*ER_DUMP_BUFFER_SIZE + 100
+					large := info["ConsoledbInfo"].(*PgInfoMock)
+					large.dumpSize = size
+					defer func() { large.dumpSize = 0 }()
+					Ω(er.RunDbAction([]SystemDump{large}, EXPORT_ARCHIVE)).Should(BeNil())
+					stat, err := os.Stat(path.Join(target, fmt.Sprintf("%s.backup", component)))
+					Ω(err).Should(BeNil())
+					Ω(stat.Size()).Should(Equal(int64(size)))
+				})
+
+				It("Should dump the critical stores before the blobstore", func() {
+					tracker := &mockTracker{}
+					er.Tracker = tracker
+					var systems []SystemDump
+
+					for _, component := range []string{"nfs_server", "mysql", "consoledb", "uaadb", "ccdb"} {
+						system := info["ConsoledbInfo"].(*PgInfoMock).SystemInfo
+						system.Component = component
+						systems = append(systems, &PgInfoMock{SystemInfo: system})
+					}
+					Ω(er.RunDbAction(systems, EXPORT_ARCHIVE)).Should(BeNil())
+					Ω(tracker.steps).Should(Equal([]string{"ccdb", "uaadb", "consoledb", "mysql", "nfs_server"}))
+				})
+
+				Context("when the vm of a store can not be reached", func() {
+					var systems []SystemDump
+
+					BeforeEach(func() {
+						systems = nil
+
+						for _, component := range []string{"ccdb", "uaadb"} {
+							system := info["ConsoledbInfo"].(*PgInfoMock).SystemInfo
+							system.Component = component
+							systems = append(systems, &PgInfoMock{SystemInfo: system, unreachable: component == "ccdb"})
+						}
+					})
+
+					It("Should fail the backup", func() {
+						Ω(er.RunDbAction(systems, EXPORT_ARCHIVE)).ShouldNot(BeNil())
+					})
+
+					It("Should back up the other stores and list it when told to skip it", func() {
+						er.SkipUnreachable = true
+						Ω(er.RunDbAction(systems, EXPORT_ARCHIVE)).Should(BeNil())
+						Ω(er.Unreachable).Should(Equal([]string{"ccdb"}))
+						Ω(path.Join(target, "ccdb.backup")).ShouldNot(BeAnExistingFile())
+						Ω(path.Join(target, "uaadb.backup")).Should(BeAnExistingFile())
+					})
+
+					It("Should not take the failure of a store for an unreachable vm", func() {
+						Ω(IsUnreachable(ERROR_DUMP)).Should(BeFalse())
+						Ω(IsUnreachable(errors.New("dial tcp 10.0.0.5:22: i/o timeout"))).Should(BeTrue())
+					})
+				})
+
+				It("Should not dump faster than the bandwidth limit", func() {
+					limited := info["ConsoledbInfo"].(*PgInfoMock)
+					limited.dumpSize = 96 << 10
+					defer func() { limited.dumpSize = 0 }()
+					// a second of the limit is available at once, the rest is waited for
+					er.Bandwidth = NewTransferLimiter(64<<10, nil)
+					started := time.Now()
+					Ω(er.RunDbAction([]SystemDump{limited}, EXPORT_ARCHIVE)).Should(BeNil())
+					Ω(time.Since(started)).Should(BeNumerically(">=", 450*time.Millisecond))
+				})
 			})
 
 			Context("Restore", func() {
@@ -365,6 +563,40 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 						Ω(err).Should(BeNil())
 					})
 
+					It("should restore the stores with adaptive concurrency", func() {
+						er.AdaptiveConcurrency = true
+						err := er.RunDbAction([]SystemDump{info["ConsoledbInfo"], info["ConsoledbInfo"]}, IMPORT_ARCHIVE)
+						Ω(err).Should(BeNil())
+					})
+
+					It("should upload a large file in segments at once", func() {
+						segments := map[int64]int64{}
+						size := int64(3*ER_MIN_SEGMENT_SIZE + 10)
+						os.Truncate(path.Join(target, filename), size)
+						segmented := info["ConsoledbInfo"].(*PgInfoMock)
+						segmented.segments = &segments
+						defer func() { segmented.segments = nil }()
+						er.TransferSegments = 4
+
+						Ω(er.RunDbAction([]SystemDump{segmented}, IMPORT_ARCHIVE)).Should(BeNil())
+						Ω(segments).Should(Equal(map[int64]int64{
+							0:                             ER_MIN_SEGMENT_SIZE + 4,
+							ER_MIN_SEGMENT_SIZE + 4:       ER_MIN_SEGMENT_SIZE + 4,
+							2 * (ER_MIN_SEGMENT_SIZE + 4): ER_MIN_SEGMENT_SIZE + 2,
+						}))
+					})
+
+					It("should upload a file too small to split as one stream", func() {
+						segments := map[int64]int64{}
+						segmented := info["ConsoledbInfo"].(*PgInfoMock)
+						segmented.segments = &segments
+						defer func() { segmented.segments = nil }()
+						er.TransferSegments = 4
+
+						Ω(er.RunDbAction([]SystemDump{segmented}, IMPORT_ARCHIVE)).Should(BeNil())
+						Ω(segments).Should(BeEmpty())
+					})
+
 					Context("write failure", func() {
 						var origInfo map[string]SystemDump
 
@@ -415,9 +647,13 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 
 			BeforeEach(func() {
 				target, _ = ioutil.TempDir("/tmp", "spec")
+				manifest = strings.NewReader("manifest")
+				getManifest, changeJobState, getTaskStatus = true, true, true
+				task = bosh.Task{State: "done"}
+				changeJobStateCount = 0
 				er = ElasticRuntime{
 					JsonFile:    installationSettingsFilePath,
-					HttpGateway: &MockHttpGateway{},
+					HttpGateway: &MockHttpGateway{State: ccVMs},
 					BackupContext: BackupContext{
 						TargetDir: target,
 					},
@@ -469,9 +705,13 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 
 			BeforeEach(func() {
 				target, _ = ioutil.TempDir("/tmp", "spec")
+				manifest = strings.NewReader("manifest")
+				getManifest, changeJobState, getTaskStatus = true, true, true
+				task = bosh.Task{State: "done"}
+				changeJobStateCount = 0
 				er = ElasticRuntime{
 					JsonFile:    installationSettingsFilePath,
-					HttpGateway: &MockHttpGateway{},
+					HttpGateway: &MockHttpGateway{State: ccVMs},
 					BackupContext: BackupContext{
 						TargetDir: target,
 					},
@@ -525,9 +765,13 @@ func testERWithVersionSpecificFile(installationSettingsFilePath string) {
 
 			BeforeEach(func() {
 				target, _ = ioutil.TempDir("/tmp", "spec")
+				manifest = strings.NewReader("manifest")
+				getManifest, changeJobState, getTaskStatus = true, true, true
+				task = bosh.Task{State: "done"}
+				changeJobStateCount = 0
 				er = ElasticRuntime{
 					JsonFile:    installationSettingsFilePath,
-					HttpGateway: &MockHttpGateway{},
+					HttpGateway: &MockHttpGateway{State: ccVMs},
 					BackupContext: BackupContext{
 						TargetDir: target,
 					},
@@ -154,7 +154,7 @@ func (s *IpPasswordParser) setPassword(productObj productCompareObject) (err err
 				s.password = property.Value.(map[string]interface{})["password"].(string)
 
 			default:
-				err = fmt.Errorf("unable to cast: map[string]interface{} :", v)
+				err = fmt.Errorf("unable to cast: map[string]interface{} : %v", v)
 			}
 		}
 	}
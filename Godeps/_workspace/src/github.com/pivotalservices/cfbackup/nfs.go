@@ -32,6 +32,10 @@ func BackupNfs(password, ip string, dest io.Writer) (err error) {
 type NFSBackup struct {
 	Caller    command.Executer
 	RemoteOps remoteOpsInterface
+	// Mirror is a local directory kept in sync with the blobstore, so that a
+	// dump copies only the files changed since the last one. Dumps copy the
+	// whole blobstore when it is empty
+	Mirror string
 }
 
 var NfsNewRemoteExecuter func(command.SshConfig) (command.Executer, error) = command.NewRemoteExecutor
@@ -55,7 +59,31 @@ func NewNFSBackup(password, ip string) (nfs *NFSBackup, err error) {
 }
 
 func (s *NFSBackup) Dump(dest io.Writer) (err error) {
-	err = s.Caller.Execute(dest, s.getDumpCommand())
+	if s.Mirror != "" {
+		return s.dumpDelta(dest)
+	}
+	return s.dumpFull(dest)
+}
+
+// dumpFull streams the tar of the shared directory from the nfs server and
+// compresses it locally into dest, storing the blobs that are compressed
+// already rather than having the server compress them again
+func (s *NFSBackup) dumpFull(dest io.Writer) (err error) {
+	reader, writer := io.Pipe()
+	repacked := make(chan error, 1)
+
+	go func() {
+		repackErr := repackBlobs(reader, dest)
+		// unblocks the remote side when repacking stopped early
+		reader.CloseWithError(repackErr)
+		repacked <- repackErr
+	}()
+	err = s.Caller.Execute(writer, s.getDumpCommand())
+	writer.CloseWithError(err)
+
+	if repackErr := <-repacked; err == nil {
+		err = repackErr
+	}
 	return
 }
 
@@ -71,5 +99,5 @@ func (s *NFSBackup) getRestoreCommand() string {
 }
 
 func (s *NFSBackup) getDumpCommand() string {
-	return fmt.Sprintf("cd %s && tar cz %s", NFS_DIR_PATH, NFS_ARCHIVE_DIR)
+	return fmt.Sprintf("cd %s && tar c %s", NFS_DIR_PATH, NFS_ARCHIVE_DIR)
 }
@@ -0,0 +1,396 @@
+package cfbackup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// NFS_MIRROR_INDEX records the size and modification time of each file of
+	// the mirror, as listed on the nfs server when it was last synced
+	NFS_MIRROR_INDEX    string = "index.json"
+	NFS_ERR_LISTING_FMT string = "unable to read the blobstore listing line %q"
+)
+
+type (
+	// nfsFile is the size and modification time, in seconds, of a blobstore
+	// file
+	nfsFile struct {
+		Size  int64   `json:"size"`
+		Mtime float64 `json:"mtime"`
+	}
+
+	// nfsIndex maps the path of each file below NFS_DIR_PATH to its nfsFile
+	nfsIndex map[string]nfsFile
+
+	// nfsDirs maps the path of each directory below NFS_DIR_PATH to its
+	// modification time, in seconds
+	nfsDirs map[string]float64
+)
+
+// dumpDelta syncs the mirror directory with the blobstore, copying over ssh
+// only the files whose size or modification time changed since the last
+// sync, then writes the archive of the mirror to dest. The archive is the
+// same tar.gz of the shared directory a full dump writes, so that restores
+// do not tell the two apart
+func (s *NFSBackup) dumpDelta(dest io.Writer) (err error) {
+	var (
+		remote  nfsIndex
+		dirs    nfsDirs
+		changed []string
+		removed []string
+	)
+	indexPath := filepath.Join(s.Mirror, NFS_MIRROR_INDEX)
+	local := readNfsIndex(indexPath)
+
+	if remote, err = s.listRemote(); err != nil {
+		return
+	}
+
+	if dirs, err = s.listRemoteDirs(); err != nil {
+		return
+	}
+	changed, removed = local.diff(remote)
+	lo.G.Info("syncing the blobstore mirror %s: %d of %d files changed, %d removed", s.Mirror, len(changed), len(remote), len(removed))
+
+	// the index is dropped first so that a failed sync is started over
+	if err = os.Remove(indexPath); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	if err = s.fetchFiles(changed); err != nil {
+		return
+	}
+
+	for _, name := range removed {
+		if err = os.Remove(filepath.Join(s.Mirror, name)); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+
+	// writing and removing files changed the times of their directories
+	if err = dirs.restoreTimes(s.Mirror); err != nil {
+		return
+	}
+
+	if err = remote.write(indexPath); err != nil {
+		return
+	}
+	return archiveMirror(s.Mirror, dest)
+}
+
+func (s *NFSBackup) getListCommand() string {
+	return fmt.Sprintf(`cd %s && find %s -type f -printf '%%p\t%%s\t%%T@\n'`, NFS_DIR_PATH, NFS_ARCHIVE_DIR)
+}
+
+func (s *NFSBackup) getDirListCommand() string {
+	return fmt.Sprintf(`cd %s && find %s -type d -printf '%%p\t%%T@\n'`, NFS_DIR_PATH, NFS_ARCHIVE_DIR)
+}
+
+func (s *NFSBackup) getFetchCommand() string {
+	return fmt.Sprintf("cd %s && tar cz -T %s", NFS_DIR_PATH, s.RemoteOps.Path())
+}
+
+func (s *NFSBackup) listRemote() (index nfsIndex, err error) {
+	var listing bytes.Buffer
+
+	if err = s.Caller.Execute(&listing, s.getListCommand()); err != nil {
+		return
+	}
+	return parseNfsListing(&listing)
+}
+
+func (s *NFSBackup) listRemoteDirs() (dirs nfsDirs, err error) {
+	var listing bytes.Buffer
+
+	if err = s.Caller.Execute(&listing, s.getDirListCommand()); err != nil {
+		return
+	}
+	return parseNfsDirListing(&listing)
+}
+
+// fetchFiles uploads the list of the files to copy to the nfs server, and
+// extracts the tar.gz of them it streams back into the mirror
+func (s *NFSBackup) fetchFiles(files []string) (err error) {
+	if len(files) == 0 {
+		return
+	}
+
+	if err = s.RemoteOps.UploadFile(strings.NewReader(strings.Join(files, "\n") + "\n")); err != nil {
+		return
+	}
+	reader, writer := io.Pipe()
+	extracted := make(chan error, 1)
+
+	go func() {
+		extractErr := extractInto(s.Mirror, reader)
+		// unblocks the remote side when extracting stopped early
+		reader.CloseWithError(extractErr)
+		extracted <- extractErr
+	}()
+	err = s.Caller.Execute(writer, s.getFetchCommand())
+	writer.CloseWithError(err)
+
+	if extractErr := <-extracted; err == nil {
+		err = extractErr
+	}
+	return
+}
+
+func parseNfsListing(listing io.Reader) (index nfsIndex, err error) {
+	index = make(nfsIndex)
+	scanner := bufio.NewScanner(listing)
+
+	for scanner.Scan() {
+		var file nfsFile
+		line := scanner.Text()
+
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+
+		if len(fields) != 3 {
+			return nil, fmt.Errorf(NFS_ERR_LISTING_FMT, line)
+		}
+
+		if file.Size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf(NFS_ERR_LISTING_FMT, line)
+		}
+
+		if file.Mtime, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, fmt.Errorf(NFS_ERR_LISTING_FMT, line)
+		}
+		index[fields[0]] = file
+	}
+	return index, scanner.Err()
+}
+
+func parseNfsDirListing(listing io.Reader) (dirs nfsDirs, err error) {
+	dirs = make(nfsDirs)
+	scanner := bufio.NewScanner(listing)
+
+	for scanner.Scan() {
+		var mtime float64
+		line := scanner.Text()
+
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(NFS_ERR_LISTING_FMT, line)
+		}
+
+		if mtime, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf(NFS_ERR_LISTING_FMT, line)
+		}
+		dirs[fields[0]] = mtime
+	}
+	return dirs, scanner.Err()
+}
+
+// restoreTimes sets the modification time of each directory of the mirror
+// to that of the blobstore, creating those holding no files, deepest first
+// so that creating a directory does not change the time of its parent
+// after it was set
+func (s nfsDirs) restoreTimes(mirror string) (err error) {
+	var names []string
+
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	for _, name := range names {
+		target := filepath.Join(mirror, filepath.FromSlash(name))
+		mtime := secondsTime(s[name])
+
+		if err = os.MkdirAll(target, 0755); err != nil {
+			return
+		}
+
+		if err = os.Chtimes(target, mtime, mtime); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// secondsTime is the time of seconds since the epoch, as find prints them
+func secondsTime(seconds float64) time.Time {
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64((seconds-whole)*1e9))
+}
+
+// readNfsIndex reads the index of the last sync, empty when there is none or
+// it is unreadable, in which case every file is copied again
+func readNfsIndex(indexPath string) (index nfsIndex) {
+	index = make(nfsIndex)
+
+	if b, err := ioutil.ReadFile(indexPath); err == nil {
+		if err = json.Unmarshal(b, &index); err != nil {
+			lo.G.Error("ignoring the unreadable blobstore mirror index %s: %s", indexPath, err)
+			index = make(nfsIndex)
+		}
+	}
+	return
+}
+
+func (s nfsIndex) write(indexPath string) (err error) {
+	var b []byte
+
+	if b, err = json.Marshal(s); err != nil {
+		return
+	}
+	tmp := indexPath + ".tmp"
+
+	if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+		err = os.Rename(tmp, indexPath)
+	}
+	return
+}
+
+// diff lists, sorted, the files of remote that are new or changed since the
+// index, and those of the index no longer in remote
+func (s nfsIndex) diff(remote nfsIndex) (changed, removed []string) {
+	for name, file := range remote {
+		if previous, ok := s[name]; !ok || previous != file {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range s {
+		if _, ok := remote[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return
+}
+
+func extractInto(dir string, archive io.Reader) (err error) {
+	var (
+		gz     *gzip.Reader
+		header *tar.Header
+	)
+
+	if gz, err = gzip.NewReader(archive); err != nil {
+		return
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	for header, err = tr.Next(); err == nil; header, err = tr.Next() {
+		name := path.Clean(header.Name)
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("refusing to extract %s outside of the mirror", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err = writeMirrorFile(target, header, tr); err != nil {
+			return
+		}
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func writeMirrorFile(target string, header *tar.Header, contents io.Reader) (err error) {
+	var file *os.File
+
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return
+	}
+
+	if file, err = os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode)&os.ModePerm); err != nil {
+		return
+	}
+
+	if _, err = io.Copy(file, contents); err != nil {
+		file.Close()
+		return
+	}
+
+	if err = file.Close(); err == nil {
+		err = os.Chtimes(target, header.ModTime, header.ModTime)
+	}
+	return
+}
+
+// archiveMirror writes the tar.gz of the shared directory of the mirror
+func archiveMirror(mirror string, dest io.Writer) (err error) {
+	tw := NewBlobArchive(dest)
+
+	err = filepath.Walk(filepath.Join(mirror, NFS_ARCHIVE_DIR), func(name string, info os.FileInfo, walkErr error) (err error) {
+		var (
+			header *tar.Header
+			rel    string
+			file   *os.File
+		)
+
+		if walkErr != nil {
+			return walkErr
+		}
+
+		if rel, err = filepath.Rel(mirror, name); err != nil {
+			return
+		}
+
+		if header, err = tar.FileInfoHeader(info, ""); err != nil {
+			return
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err = tw.WriteHeader(header); err != nil || !info.Mode().IsRegular() {
+			return
+		}
+
+		if file, err = os.Open(name); err != nil {
+			return
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return
+	})
+
+	if os.IsNotExist(err) {
+		// nothing was ever synced, as with an empty blobstore
+		err = nil
+	}
+
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
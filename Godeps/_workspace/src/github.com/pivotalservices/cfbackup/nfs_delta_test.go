@@ -0,0 +1,156 @@
+package cfbackup_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfbackup"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// dirModTime is when every directory of the mock blobstore last changed
+var dirModTime = time.Unix(1456780000, 0)
+
+// mirrorMockNFSExecuter answers the listing and fetch commands of a delta
+// dump from an in memory blobstore of file name to contents
+type mirrorMockNFSExecuter struct {
+	files   map[string]string
+	fetches int
+}
+
+func (s *mirrorMockNFSExecuter) Execute(dest io.Writer, cmd string) (err error) {
+	switch {
+	case strings.Contains(cmd, "find ") && strings.Contains(cmd, "-type d"):
+		dirs := map[string]bool{"shared": true}
+
+		for name := range s.files {
+			for dir := path.Dir(name); dir != "shared"; dir = path.Dir(dir) {
+				dirs[dir] = true
+			}
+		}
+
+		for dir := range dirs {
+			fmt.Fprintf(dest, "%s\t%d\n", dir, dirModTime.Unix())
+		}
+
+	case strings.Contains(cmd, "find "):
+		for name, contents := range s.files {
+			fmt.Fprintf(dest, "%s\t%d\t1456789012.5\n", name, len(contents))
+		}
+
+	case strings.Contains(cmd, "tar cz -T"):
+		s.fetches++
+		gz := gzip.NewWriter(dest)
+		tw := tar.NewWriter(gz)
+
+		for name, contents := range s.files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+			io.WriteString(tw, contents)
+		}
+		tw.Close()
+		gz.Close()
+	}
+	return
+}
+
+func archivedFiles(archive []byte) (files map[string]string) {
+	files = make(map[string]string)
+	gz, _ := gzip.NewReader(bytes.NewReader(archive))
+	tr := tar.NewReader(gz)
+
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		if header.Typeflag == tar.TypeReg {
+			b, _ := ioutil.ReadAll(tr)
+			files[header.Name] = string(b)
+		}
+	}
+	return
+}
+
+var _ = Describe("nfs delta dumps", func() {
+	var (
+		nfs      *NFSBackup
+		executer *mirrorMockNFSExecuter
+		mirror   string
+		uploaded *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		mirror, _ = ioutil.TempDir("", "mirror")
+		uploaded = new(bytes.Buffer)
+		executer = &mirrorMockNFSExecuter{files: map[string]string{
+			"shared/cc-droplets/ab/droplet": "droplet bits",
+			"shared/cc-packages/cd/package": "package bits",
+		}}
+		nfs = getNfs(uploaded, executer)
+		nfs.Mirror = mirror
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(mirror)
+	})
+
+	Context("when the mirror is empty", func() {
+		It("should copy every file and archive the shared directory", func() {
+			var b bytes.Buffer
+			Ω(nfs.Dump(&b)).Should(Succeed())
+			Ω(executer.fetches).Should(Equal(1))
+			Ω(archivedFiles(b.Bytes())).Should(Equal(executer.files))
+			Ω(path.Join(mirror, NFS_MIRROR_INDEX)).Should(BeAnExistingFile())
+		})
+
+		It("should archive the directories with the modification times of the blobstore", func() {
+			var b bytes.Buffer
+			Ω(nfs.Dump(&b)).Should(Succeed())
+			gz, _ := gzip.NewReader(&b)
+			tr := tar.NewReader(gz)
+			dirs := 0
+
+			for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+				if header.Typeflag == tar.TypeDir {
+					dirs++
+					Ω(header.ModTime.Unix()).Should(Equal(dirModTime.Unix()), header.Name)
+				}
+			}
+			Ω(dirs).Should(Equal(5))
+		})
+	})
+
+	Context("when the mirror is in sync", func() {
+		BeforeEach(func() {
+			Ω(nfs.Dump(ioutil.Discard)).Should(Succeed())
+			uploaded.Reset()
+		})
+
+		It("should copy nothing over and still archive every file", func() {
+			var b bytes.Buffer
+			Ω(nfs.Dump(&b)).Should(Succeed())
+			Ω(executer.fetches).Should(Equal(1))
+			Ω(archivedFiles(b.Bytes())).Should(Equal(executer.files))
+		})
+
+		It("should copy only the files that changed, and drop those removed", func() {
+			var b bytes.Buffer
+			executer.files["shared/cc-packages/cd/package"] = "newer package bits"
+			delete(executer.files, "shared/cc-droplets/ab/droplet")
+			Ω(nfs.Dump(&b)).Should(Succeed())
+
+			listed := strings.Fields(uploaded.String())
+			sort.Strings(listed)
+			Ω(listed).Should(Equal([]string{"shared/cc-packages/cd/package"}))
+			Ω(archivedFiles(b.Bytes())).Should(Equal(executer.files))
+			Ω(path.Join(mirror, "shared/cc-droplets/ab/droplet")).ShouldNot(BeAnExistingFile())
+		})
+	})
+})
@@ -50,7 +50,7 @@ var _ = Describe("nfs", func() {
 			})
 
 			It("should write the local file contents to the remote", func() {
-				Ω(buffer).Should(gbytes.Say(controlString))
+				Ω(buffer).Should(gbytes.Say("%s", controlString))
 			})
 		})
 
@@ -69,7 +69,7 @@ var _ = Describe("nfs", func() {
 			})
 
 			It("should write the local file contents to the remote", func() {
-				Ω(buffer).Should(gbytes.Say(controlString))
+				Ω(buffer).Should(gbytes.Say("%s", controlString))
 			})
 		})
 
@@ -91,7 +91,7 @@ var _ = Describe("nfs", func() {
 				})
 
 				It("should write the local file contents to the remote", func() {
-					Ω(buffer).ShouldNot(gbytes.Say(controlString))
+					Ω(buffer).ShouldNot(gbytes.Say("%s", controlString))
 				})
 			})
 
@@ -109,7 +109,7 @@ var _ = Describe("nfs", func() {
 					})
 
 					It("should write the local file contents to the remote", func() {
-						Ω(buffer).ShouldNot(gbytes.Say(controlString))
+						Ω(buffer).ShouldNot(gbytes.Say("%s", controlString))
 					})
 				})
 
@@ -126,7 +126,7 @@ var _ = Describe("nfs", func() {
 					})
 
 					It("should write the local file contents to the remote", func() {
-						Ω(buffer).ShouldNot(gbytes.Say(controlString))
+						Ω(buffer).ShouldNot(gbytes.Say("%s", controlString))
 					})
 				})
 			})
@@ -160,7 +160,7 @@ var _ = Describe("nfs", func() {
 			It("should return nil error and write success output to an outfile", func() {
 				err := BackupNfs("pass", "1.2.3.4", tmpfile)
 				b, _ := ioutil.ReadFile(tmpfilepath)
-				Ω(b).Should(Equal([]byte(nfsSuccessString)))
+				Ω(archivedFiles(b)).Should(Equal(map[string]string{"shared/nfs": nfsSuccessString}))
 				Ω(err).Should(BeNil())
 			})
 		})
@@ -185,11 +185,9 @@ var _ = Describe("nfs", func() {
 				os.Remove(tmpfilepath)
 			})
 
-			It("should return non nil error and write failure output to file", func() {
+			It("should return the error of the remote command", func() {
 				err := BackupNfs("pass", "1.2.3.4", tmpfile)
-				b, _ := ioutil.ReadFile(tmpfilepath)
-				Ω(b).Should(Equal([]byte(nfsFailureString)))
-				Ω(err).ShouldNot(BeNil())
+				Ω(err).Should(Equal(mockNfsCommandError))
 			})
 		})
 
@@ -207,11 +205,16 @@ var _ = Describe("nfs", func() {
 				nfs.Caller = &SuccessMockNFSExecuter{}
 			})
 
-			It("Should return nil error and a success message in the writer", func() {
+			It("Should return nil error and the compressed archive in the writer", func() {
 				var b bytes.Buffer
 				err := nfs.Dump(&b)
 				Ω(err).Should(BeNil())
-				Ω(b.String()).Should(Equal(nfsSuccessString))
+				Ω(archivedFiles(b.Bytes())).Should(Equal(map[string]string{"shared/nfs": nfsSuccessString}))
+			})
+
+			It("Should stream the archive from the server uncompressed", func() {
+				Ω(nfs.Dump(ioutil.Discard)).Should(BeNil())
+				Ω(nfs.Caller.(*SuccessMockNFSExecuter).Commands).Should(ContainElement("cd /var/vcap/store && tar c shared"))
 			})
 		})
 
@@ -220,11 +223,10 @@ var _ = Describe("nfs", func() {
 				nfs.Caller = &FailureMockNFSExecuter{}
 			})
 
-			It("Should return non nil error and a failure output in the writer", func() {
+			It("Should return the error of the remote command", func() {
 				var b bytes.Buffer
 				err := nfs.Dump(&b)
-				Ω(err).ShouldNot(BeNil())
-				Ω(b.String()).Should(Equal(nfsFailureString))
+				Ω(err).Should(Equal(mockNfsCommandError))
 			})
 		})
 
@@ -57,6 +57,10 @@ type OpsManager struct {
 	AssetsRequestor     httpRequestor
 	DeploymentDir       string
 	OpsmanagerBackupDir string
+	AsyncExport         AsyncExport
+	// Reauthenticate, when set, resolves the admin credentials again once ops
+	// manager refuses them, and the refused request is sent again
+	Reauthenticate Reauthenticator
 }
 
 // NewOpsManager initializes an OpsManager instance
@@ -140,7 +144,7 @@ func (context *OpsManager) importInstallationPart(url, filename, fieldname strin
 		}
 
 		if res, err = upload(conn, fieldname, filename, fileRef, nil); err != nil {
-			err = fmt.Errorf(fmt.Sprintf("ERROR:%s - %v", err.Error(), res))
+			err = fmt.Errorf("ERROR:%s - %v", err.Error(), res)
 			lo.G.Debug("upload failed", log.Data{"err": err, "response": res})
 		}
 	}
@@ -157,11 +161,23 @@ func (context *OpsManager) exportAndExtract() (err error) {
 func (context *OpsManager) export() (err error) {
 
 	if err = context.exportUrlToFile(OPSMGR_INSTALLATION_SETTINGS_URL, OPSMGR_INSTALLATION_SETTINGS_FILENAME); err == nil {
-		err = context.exportUrlToFile(OPSMGR_INSTALLATION_ASSETS_URL, OPSMGR_INSTALLATION_ASSETS_FILENAME)
+		err = context.exportAssets()
 	}
 	return
 }
 
+// exportAssets exports the installation assets through the asynchronous
+// export api when enabled and ops manager has it, in one request otherwise
+func (context *OpsManager) exportAssets() (err error) {
+	if context.AsyncExport.Enabled {
+		if err = context.exportAssetsAsync(OPSMGR_INSTALLATION_ASSETS_FILENAME); err != errNoAsyncExport {
+			return
+		}
+		lo.G.Warning("ops manager %s has no asynchronous export, exporting the installation in one request", context.Hostname)
+	}
+	return context.exportUrlToFile(OPSMGR_INSTALLATION_ASSETS_URL, OPSMGR_INSTALLATION_ASSETS_FILENAME)
+}
+
 func (context *OpsManager) exportUrlToFile(urlFormat string, filename string) (err error) {
 	var settingsFileRef *os.File
 	defer settingsFileRef.Close()
@@ -177,12 +193,7 @@ func (context *OpsManager) exportUrlToFile(urlFormat string, filename string) (e
 }
 
 func (context *OpsManager) exportUrlToWriter(url string, dest io.Writer, requestor httpRequestor) (err error) {
-	resp, err := requestor.Get(ghttp.HttpRequestEntity{
-		Url:         url,
-		Username:    context.Username,
-		Password:    context.Password,
-		ContentType: "application/octet-stream",
-	})()
+	resp, err := context.get(url, requestor)
 	if err == nil && resp.StatusCode == http.StatusOK {
 		defer resp.Body.Close()
 		_, err = io.Copy(dest, resp.Body)
@@ -0,0 +1,64 @@
+package cfbackup
+
+import (
+	"net/http"
+
+	ghttp "github.com/pivotalservices/gtils/http"
+	"github.com/pivotalservices/gtils/retry"
+	"github.com/xchapter7x/lo"
+)
+
+// Reauthenticator resolves the admin credentials of ops manager again once it
+// refuses the ones a backup started with, as when they are rotated during it
+type Reauthenticator func() (username, password string, err error)
+
+// reauthenticated resolves the admin credentials again after ops manager
+// answered with the status, telling whether the refused request is worth
+// sending again with them
+func (context *OpsManager) reauthenticated(status int) bool {
+	if status != http.StatusUnauthorized || context.Reauthenticate == nil {
+		return false
+	}
+	lo.G.Warning("ops manager %s refused the credentials of %s, resolving them again", context.Hostname, context.Username)
+	username, password, err := context.Reauthenticate()
+
+	if err != nil {
+		lo.G.Error("unable to resolve the credentials of ops manager %s again: %s", context.Hostname, err)
+		return false
+	}
+	context.Username, context.Password = username, password
+	return true
+}
+
+// get requests the url through the requestor as the admin, once more when
+// ops manager refuses the credentials and they are resolved again
+func (context *OpsManager) get(url string, requestor httpRequestor) (resp *http.Response, err error) {
+	for retried := false; ; retried = true {
+		resp, err = requestor.Get(ghttp.HttpRequestEntity{
+			Url:         url,
+			Username:    context.Username,
+			Password:    context.Password,
+			ContentType: "application/octet-stream",
+		})()
+
+		if retried || err != nil || !context.reauthenticated(resp.StatusCode) {
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// send sends the request as the admin, trying again as the default retry
+// policy says while ops manager can not be reached, and once more when it
+// refuses the credentials and they are resolved again. The request must not
+// have a body
+func (context *OpsManager) send(client *http.Client, request *http.Request) (response *http.Response, err error) {
+	request.SetBasicAuth(context.Username, context.Password)
+
+	if response, err = retry.Default().Request(client, request); err == nil && context.reauthenticated(response.StatusCode) {
+		response.Body.Close()
+		request.SetBasicAuth(context.Username, context.Password)
+		response, err = retry.Default().Request(client, request)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package cfbackup
+
+import (
+	"io"
+	"os"
+
+	"github.com/pivotalservices/gtils/osutils"
+)
+
+// ER_MIN_SEGMENT_SIZE is the smallest byte range an archive is uploaded in,
+// smaller archives are uploaded in fewer segments, or as one stream
+const ER_MIN_SEGMENT_SIZE = 64 << 20
+
+// segmentedArchive is an archive file a restore uploads in byte ranges at
+// once, each on an sftp connection of its own. The ranges are counted and
+// throttled as the whole archive is, the rate being shared among them
+type segmentedArchive struct {
+	io.Reader
+	file     *os.File
+	size     int64
+	segments int
+	counter  *countingReadWriter
+	rate     int64
+}
+
+// segmentArchive splits the archive into as many as n segments of at least
+// ER_MIN_SEGMENT_SIZE, returning the reader unchanged when it can not
+func segmentArchive(r io.Reader, counter *countingReadWriter, n int, rate int64) io.Reader {
+	var (
+		file *os.File
+		ok   bool
+		stat os.FileInfo
+		err  error
+	)
+
+	if file, ok = counter.ReadWriter.(*os.File); !ok || n < 2 {
+		return r
+	}
+
+	if stat, err = file.Stat(); err != nil {
+		return r
+	}
+
+	if most := int(stat.Size() / ER_MIN_SEGMENT_SIZE); most < n {
+		n = most
+	}
+
+	if n < 2 {
+		return r
+	}
+	return &segmentedArchive{Reader: r, file: file, size: stat.Size(), segments: n, counter: counter, rate: rate}
+}
+
+func (s *segmentedArchive) Segments() (segments []osutils.Segment) {
+	length := (s.size + int64(s.segments) - 1) / int64(s.segments)
+	rate := s.rate / int64(s.segments)
+
+	if s.rate > 0 && rate == 0 {
+		rate = 1
+	}
+
+	for offset := int64(0); offset < s.size; offset += length {
+		if offset+length > s.size {
+			length = s.size - offset
+		}
+		section := &countingReader{Reader: io.NewSectionReader(s.file, offset, length), counter: s.counter}
+		segments = append(segments, osutils.Segment{
+			Offset: offset,
+			Reader: throttledReader(section, rate),
+		})
+	}
+	return
+}
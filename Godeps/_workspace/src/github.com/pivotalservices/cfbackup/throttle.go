@@ -0,0 +1,114 @@
+package cfbackup
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttledReader reads no faster than rate bytes a second, on average since
+// the first read, so that a restore does not saturate the store it streams to
+func throttledReader(r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &throttled{Reader: r, rate: rate}
+}
+
+type throttled struct {
+	io.Reader
+	rate    int64
+	read    int64
+	started time.Time
+}
+
+func (s *throttled) Read(p []byte) (n int, err error) {
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+
+	if int64(len(p)) > s.rate {
+		p = p[:s.rate]
+	}
+	n, err = s.Reader.Read(p)
+	s.read += int64(n)
+
+	if ahead := time.Duration(s.read)*time.Second/time.Duration(s.rate) - time.Since(s.started); ahead > 0 {
+		time.Sleep(ahead)
+	}
+	return
+}
+
+// TransferLimiter caps the bandwidth of the transfers of the stores, of all
+// of them together and of each component, with token buckets the concurrent
+// transfers share, backups and restores alike
+type TransferLimiter struct {
+	total      *tokenBucket
+	components map[string]*tokenBucket
+}
+
+// NewTransferLimiter limits all transfers together to total bytes a second,
+// and those of each component of the map to its own rate. A rate of zero is
+// no limit
+func NewTransferLimiter(total int64, components map[string]int64) *TransferLimiter {
+	limiter := &TransferLimiter{total: newTokenBucket(total), components: make(map[string]*tokenBucket)}
+
+	for component, rate := range components {
+		if bucket := newTokenBucket(rate); bucket != nil {
+			limiter.components[component] = bucket
+		}
+	}
+	return limiter
+}
+
+// buckets are those a transfer of the component draws from
+func (s *TransferLimiter) buckets(component string) (buckets []*tokenBucket) {
+	if s == nil {
+		return
+	}
+
+	if s.total != nil {
+		buckets = append(buckets, s.total)
+	}
+
+	if bucket, ok := s.components[component]; ok {
+		buckets = append(buckets, bucket)
+	}
+	return
+}
+
+// tokenBucket refills at rate bytes a second, holding at most a second of
+// them. Takers may overdraw it, and then wait until it is paid back, so that
+// however many share it they move no more than rate bytes a second together
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// take spends n bytes, waiting for as long as the bucket is overdrawn
+func (s *tokenBucket) take(n int) {
+	s.mutex.Lock()
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * float64(s.rate)
+
+	if s.tokens > float64(s.rate) {
+		s.tokens = float64(s.rate)
+	}
+	s.last = now
+	s.tokens -= float64(n)
+	wait := time.Duration(-s.tokens / float64(s.rate) * float64(time.Second))
+	s.mutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
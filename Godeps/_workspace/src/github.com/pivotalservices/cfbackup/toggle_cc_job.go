@@ -8,6 +8,7 @@ import (
 
 	"github.com/pivotalservices/gtils/bosh"
 	. "github.com/pivotalservices/gtils/http"
+	"github.com/xchapter7x/lo"
 )
 
 // Not ping server so frequently and exausted the resources
@@ -44,18 +45,46 @@ func (c *CloudController) Stop() error {
 	return c.toggleController("stopped")
 }
 
-func (c *CloudController) toggleController(state string) error {
+// Quiesce stops the cloud controller jobs for a critical phase, starting them
+// again when any of them cannot be stopped. The returned function starts them
+// once the phase is over
+func (c *CloudController) Quiesce() (resume func() error, err error) {
+	if err = c.Stop(); err != nil {
+		if startErr := c.Start(); startErr != nil {
+			lo.G.Error("unable to start the cloud controller again, start its jobs with bosh: %s", startErr)
+		}
+		return nil, err
+	}
+	return c.Start, nil
+}
+
+// toggleController changes the state of every cloud controller job. Stopping
+// gives up on the first job that fails, while starting goes on with the other
+// jobs so that as much of the cloud controller as possible is running again
+func (c *CloudController) toggleController(state string) (err error) {
 	for _, ccjob := range c.cloudControllers {
-		taskId, err := c.director.ChangeJobState(c.deploymentName, ccjob.Job, state, ccjob.Index, strings.NewReader(c.manifest))
-		if err != nil {
-			return err
+		var (
+			taskId int
+			jobErr error
+		)
+
+		if taskId, jobErr = c.director.ChangeJobState(c.deploymentName, ccjob.Job, state, ccjob.Index, strings.NewReader(c.manifest)); jobErr == nil {
+			jobErr = c.waitUntilDone(taskId)
 		}
-		err = c.waitUntilDone(taskId)
-		if err != nil {
-			return err
+
+		if jobErr != nil && state != "started" {
+			return jobErr
+		}
+
+		if jobErr != nil {
+			lo.G.Error("unable to start %s/%d: %s", ccjob.Job, ccjob.Index, jobErr)
+
+			if err == nil {
+				err = jobErr
+			}
 		}
 	}
-	return nil
+	return
 }
 
 func (c *CloudController) waitUntilDone(taskId int) (err error) {
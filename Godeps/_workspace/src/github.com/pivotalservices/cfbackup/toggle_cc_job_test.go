@@ -78,6 +78,18 @@ var _ = Describe("ToggleCcJob", func() {
 				err := cloudController.Start()
 				Ω(err).ShouldNot(BeNil())
 			})
+			It("Should still try to start every job", func() {
+				changeJobStateCount = 0
+				cloudController.Start()
+				Ω(changeJobStateCount).Should(Equal(3))
+			})
+			It("Should start the jobs again when they cannot be quiesced", func() {
+				changeJobStateCount = 0
+				resume, err := cloudController.Quiesce()
+				Ω(err).ShouldNot(BeNil())
+				Ω(resume).Should(BeNil())
+				Ω(changeJobStateCount).Should(Equal(4))
+			})
 		})
 		Context("Toggle successfully", func() {
 			BeforeEach(func() {
@@ -94,6 +106,12 @@ var _ = Describe("ToggleCcJob", func() {
 				cloudController.Start()
 				Ω(changeJobStateCount).Should(Equal(3))
 			})
+			It("Should start the jobs it quiesced once resumed", func() {
+				resume, err := cloudController.Quiesce()
+				Ω(err).Should(BeNil())
+				Ω(resume()).Should(BeNil())
+				Ω(changeJobStateCount).Should(Equal(6))
+			})
 			It("Should Call retriveTaskStatus 5 times with retries when task is processing", func() {
 				cloudController.Start()
 				Ω(retrieveTaskStatusCount).Should(Equal(5))
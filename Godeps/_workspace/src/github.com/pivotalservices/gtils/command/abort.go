@@ -0,0 +1,45 @@
+package command
+
+import (
+	"sync"
+
+	"github.com/pivotalservices/gtils/retry"
+)
+
+var (
+	abortMutex    sync.Mutex
+	abortHandlers = map[int]func(){}
+	abortNextId   int
+)
+
+// OnAbort registers fn to be called if the running operation is aborted,
+// typically to close a remote connection or remove remote temporary files.
+// The returned function unregisters fn once it is no longer needed
+func OnAbort(fn func()) (unregister func()) {
+	abortMutex.Lock()
+	defer abortMutex.Unlock()
+	abortNextId++
+	id := abortNextId
+	abortHandlers[id] = fn
+
+	return func() {
+		abortMutex.Lock()
+		defer abortMutex.Unlock()
+		delete(abortHandlers, id)
+	}
+}
+
+// Abort calls and unregisters every registered abort handler, causing any
+// in-flight remote command or transfer to fail, and stops the retries
+// waiting to try again
+func Abort() {
+	retry.Interrupt()
+	abortMutex.Lock()
+	handlers := abortHandlers
+	abortHandlers = map[int]func(){}
+	abortMutex.Unlock()
+
+	for _, fn := range handlers {
+		fn()
+	}
+}
@@ -0,0 +1,44 @@
+package command
+
+import "sync"
+
+var (
+	executeMutex    sync.Mutex
+	executeHandlers = map[int]func(string) func(error){}
+	executeNextId   int
+)
+
+// OnExecute registers fn to be called as every remote command starts, with
+// the command, and the function it returns once the command ends, with its
+// error, typically to time the command. The returned function unregisters fn
+func OnExecute(fn func(command string) (done func(err error))) (unregister func()) {
+	executeMutex.Lock()
+	defer executeMutex.Unlock()
+	executeNextId++
+	id := executeNextId
+	executeHandlers[id] = fn
+
+	return func() {
+		executeMutex.Lock()
+		defer executeMutex.Unlock()
+		delete(executeHandlers, id)
+	}
+}
+
+// startExecute tells every registered handler that the command starts, and
+// returns the function telling them it ended
+func startExecute(command string) (done func(error)) {
+	var dones []func(error)
+	executeMutex.Lock()
+
+	for _, fn := range executeHandlers {
+		dones = append(dones, fn(command))
+	}
+	executeMutex.Unlock()
+
+	return func(err error) {
+		for _, done := range dones {
+			done(err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/pivotalservices/gtils/retry"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -46,10 +47,13 @@ func NewRemoteExecutor(sshCfg SshConfig) (executor Executer, err error) {
 			ssh.Password(sshCfg.Password),
 		},
 	}
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", sshCfg.Host, sshCfg.Port), clientconfig)
+	client, err := Dial(fmt.Sprintf("%s:%d", sshCfg.Host, sshCfg.Port), clientconfig)
 	if err != nil {
 		return
 	}
+	OnAbort(func() {
+		client.Close()
+	})
 	c := NewClientWrapper(client)
 	executor = &DefaultRemoteExecutor{
 		Client: c,
@@ -57,6 +61,16 @@ func NewRemoteExecutor(sshCfg SshConfig) (executor Executer, err error) {
 	return
 }
 
+// Dial connects to the ssh server, trying again as the default retry policy
+// says while the server can not be reached
+func Dial(addr string, config *ssh.ClientConfig) (client *ssh.Client, err error) {
+	err = retry.Do("ssh dial "+addr, func() (dialErr error) {
+		client, dialErr = ssh.Dial("tcp", addr, config)
+		return
+	})
+	return
+}
+
 type SSHSession interface {
 	Start(cmd string) error
 	Wait() error
@@ -66,6 +80,8 @@ type SSHSession interface {
 
 // Copy the output from a command to the specified io.Writer
 func (executor *DefaultRemoteExecutor) Execute(dest io.Writer, command string) (err error) {
+	done := startExecute(command)
+	defer func() { done(err) }()
 	session, err := executor.Client.NewSession()
 	defer session.Close()
 	if err != nil {
@@ -105,6 +105,27 @@ var _ = Describe("Ssh", func() {
 				Ω(err).Should(HaveOccurred())
 			})
 		})
+		Context("With an execute handler", func() {
+			It("should tell it the command and how it ended", func() {
+				var (
+					started string
+					ended   error
+				)
+				unregister := OnExecute(func(command string) func(error) {
+					started = command
+					return func(err error) { ended = err }
+				})
+				defer unregister()
+				session.WaitSuccess = false
+				executor := &DefaultRemoteExecutor{
+					Client: client,
+				}
+				err := executor.Execute(&bytes.Buffer{}, "pg_dump ccdb")
+				Ω(started).Should(Equal("pg_dump ccdb"))
+				Ω(ended).Should(Equal(err))
+				Ω(ended).Should(HaveOccurred())
+			})
+		})
 		Context("With bad command start", func() {
 			It("should return an error", func() {
 				var writer bytes.Buffer
@@ -4,6 +4,8 @@ import (
 	"crypto/tls"
 	"io"
 	"net/http"
+
+	"github.com/pivotalservices/gtils/retry"
 )
 
 const NO_CONTENT_TYPE string = ""
@@ -17,8 +19,14 @@ type HttpRequestEntity struct {
 
 type RequestFunc func(HttpRequestEntity, string, io.Reader) (*http.Response, error)
 
+// Request sends a single request, without following redirects, trying again
+// as the default retry policy says while the server can not be reached or
+// answers with a transient status
 func Request(entity HttpRequestEntity, method string, body io.Reader) (response *http.Response, err error) {
-	transport := NewRoundTripper()
+	client := &http.Client{
+		Transport:     NewRoundTripper(),
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
 	req, err := http.NewRequest(method, entity.Url, body)
 	if err != nil {
 		return
@@ -27,7 +35,7 @@ func Request(entity HttpRequestEntity, method string, body io.Reader) (response
 	if entity.ContentType != NO_CONTENT_TYPE {
 		req.Header.Add("Content-Type", entity.ContentType)
 	}
-	return transport.RoundTrip(req)
+	return retry.Default().Request(client, req)
 }
 
 type RequestAdaptor func() (*http.Response, error)
@@ -41,9 +41,10 @@ var (
 	minLogLevel string
 )
 
+// init only adds the flags; parsing the command line is left to the program,
+// as parsing it here refuses the flags of go test
 func init() {
 	AddFlags(flag.CommandLine)
-	flag.Parse()
 }
 
 func AddFlags(flagSet *flag.FlagSet) {
@@ -0,0 +1,44 @@
+package mock
+
+import "errors"
+
+var (
+	READ_FAIL_ERROR  = errors.New("copy failed on read")
+	WRITE_FAIL_ERROR = errors.New("copy failed on write")
+	CLOSE_FAIL_ERROR = errors.New("close failed")
+)
+
+// ReadWriteCloser fails each call with the error it was given for it. Reads
+// that don't fail fill the whole of the buffer, writes that don't fail record
+// what was written without taking any of it, so that copying to it stops on
+// the first write with io.ErrShortWrite
+type ReadWriteCloser struct {
+	ReadErr      error
+	WriteErr     error
+	CloseErr     error
+	BytesRead    []byte
+	BytesWritten []byte
+}
+
+func NewReadWriteCloser(readErr, writeErr, closeErr error) *ReadWriteCloser {
+	return &ReadWriteCloser{ReadErr: readErr, WriteErr: writeErr, CloseErr: closeErr}
+}
+
+func (s *ReadWriteCloser) Read(p []byte) (n int, err error) {
+	if err = s.ReadErr; err == nil {
+		s.BytesRead = p
+		n = len(p)
+	}
+	return
+}
+
+func (s *ReadWriteCloser) Write(p []byte) (n int, err error) {
+	if err = s.WriteErr; err == nil {
+		s.BytesWritten = p
+	}
+	return
+}
+
+func (s *ReadWriteCloser) Close() error {
+	return s.CloseErr
+}
@@ -42,7 +42,10 @@ func (s sshClientBucket) remoteSafeMkdirAll(base string) (err error) {
 		}
 
 		if err == nil {
-			err = s.client.Mkdir(base)
+			// another upload may have just created it
+			if err = s.client.Mkdir(base); os.IsExist(err) {
+				err = nil
+			}
 		}
 	}
 	return
@@ -3,8 +3,11 @@ package osutils
 import (
 	"fmt"
 	"io"
+	"os"
+	"sync"
 
 	"github.com/pivotalservices/gtils/command"
+	"github.com/pivotalservices/gtils/retry"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
@@ -13,6 +16,63 @@ const (
 	REMOTE_IMPORT_PATH string = "/tmp/archive.backup"
 )
 
+// uploads hold credentials and data, only the ssh user reads them
+const uploadUmask os.FileMode = 0077
+
+// Segment is a byte range of a local file, which an upload sends on an ssh
+// connection of its own
+type Segment struct {
+	Offset int64
+	Reader io.Reader
+}
+
+// Segmenter is a local file an upload sends in byte ranges at once, since a
+// single tcp stream can not fill a long and fat network
+type Segmenter interface {
+	Segments() []Segment
+}
+
+// NewSftpClient connects an sftp client over an ssh connection of its own,
+// closed with the closer returned
+var NewSftpClient func(command.SshConfig) (*sftp.Client, io.Closer, error) = newSftpClient
+
+// transfers are the counters of the sftp clients newSftpClient connected,
+// those of the closed ones summed
+var transfers = struct {
+	sync.Mutex
+	closed sftp.ClientStats
+	open   map[*sftp.Client]bool
+}{open: make(map[*sftp.Client]bool)}
+
+// TransferStats sums the counters of every sftp client connected so far
+func TransferStats() sftp.ClientStats {
+	transfers.Lock()
+	defer transfers.Unlock()
+	stats := transfers.closed.Add(sftp.ClientStats{})
+
+	for client := range transfers.open {
+		stats = stats.Add(client.Stats())
+	}
+	return stats
+}
+
+// trackedConn closes the ssh connection of an sftp client, keeping the
+// counters of the client for TransferStats
+type trackedConn struct {
+	client *sftp.Client
+	conn   io.Closer
+}
+
+func (s trackedConn) Close() error {
+	transfers.Lock()
+	if transfers.open[s.client] {
+		delete(transfers.open, s.client)
+		transfers.closed = transfers.closed.Add(s.client.Stats())
+	}
+	transfers.Unlock()
+	return s.conn.Close()
+}
+
 func NewRemoteOperations(sshCfg command.SshConfig) *remoteOperations {
 	return &remoteOperations{
 		sshCfg:     sshCfg,
@@ -25,9 +85,17 @@ type remoteOperations struct {
 	remotePath string
 }
 
+// UploadFile copies the local file to the remote path, in segments at once
+// when the file is a Segmenter with more than one
 func (s *remoteOperations) UploadFile(lfile io.Reader) (err error) {
 	var rfile io.WriteCloser
 
+	if segmenter, ok := lfile.(Segmenter); ok {
+		if segments := segmenter.Segments(); len(segments) > 1 {
+			return s.uploadSegments(segments)
+		}
+	}
+
 	if rfile, err = s.GetRemoteFile(); err == nil {
 		defer rfile.Close()
 		_, err = io.Copy(rfile, lfile)
@@ -44,23 +112,113 @@ func (s *remoteOperations) Path() string {
 }
 
 func (s *remoteOperations) GetRemoteFile() (rfile io.WriteCloser, err error) {
+	var sftpclient *sftp.Client
+
+	if sftpclient, _, err = s.connect(); err == nil {
+		rfile, err = SafeCreateSSH(sftpclient, s.remotePath)
+	}
+	return
+}
+
+// uploadSegments creates the remote file, then writes each segment to it
+// at its offset, over a connection of its own. The first error is returned
+// once every segment has stopped
+func (s *remoteOperations) uploadSegments(segments []Segment) (err error) {
+	var (
+		sftpclient *sftp.Client
+		closer     io.Closer
+		rfile      *sftp.File
+		wg         sync.WaitGroup
+		mutex      sync.Mutex
+	)
+
+	if sftpclient, closer, err = s.connect(); err != nil {
+		return
+	}
+	defer closer.Close()
+
+	if rfile, err = SafeCreateSSH(sftpclient, s.remotePath); err != nil {
+		return
+	}
+	rfile.Close()
+
+	for _, segment := range segments {
+		wg.Add(1)
+
+		go func(segment Segment) {
+			defer wg.Done()
+			segmentErr := s.uploadSegment(segment)
+
+			mutex.Lock()
+			if err == nil {
+				err = segmentErr
+			}
+			mutex.Unlock()
+		}(segment)
+	}
+	wg.Wait()
+	return
+}
+
+func (s *remoteOperations) uploadSegment(segment Segment) (err error) {
 	var (
-		sshconn    *ssh.Client
 		sftpclient *sftp.Client
+		closer     io.Closer
+		rfile      *sftp.File
 	)
 
+	if sftpclient, closer, err = s.connect(); err != nil {
+		return
+	}
+	defer closer.Close()
+
+	if rfile, err = sftpclient.OpenFile(s.remotePath, os.O_WRONLY); err != nil {
+		return
+	}
+	defer rfile.Close()
+
+	if _, err = rfile.Seek(segment.Offset, io.SeekStart); err == nil {
+		_, err = io.Copy(rfile, segment.Reader)
+	}
+	return
+}
+
+// connect opens an sftp client on a connection of its own, which an abort
+// closes, removing the remote file
+func (s *remoteOperations) connect() (sftpclient *sftp.Client, closer io.Closer, err error) {
+	if sftpclient, closer, err = NewSftpClient(s.sshCfg); err == nil {
+		remotePath := s.remotePath
+		command.OnAbort(func() {
+			sftpclient.Remove(remotePath)
+			closer.Close()
+		})
+	}
+	return
+}
+
+func newSftpClient(sshCfg command.SshConfig) (sftpclient *sftp.Client, closer io.Closer, err error) {
+	var sshconn *ssh.Client
+
 	clientconfig := &ssh.ClientConfig{
-		User: s.sshCfg.Username,
+		User: sshCfg.Username,
 		Auth: []ssh.AuthMethod{
-			ssh.Password(s.sshCfg.Password),
+			ssh.Password(sshCfg.Password),
 		},
 	}
 
-	if sshconn, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", s.sshCfg.Host, s.sshCfg.Port), clientconfig); err == nil {
+	if sshconn, err = command.Dial(fmt.Sprintf("%s:%d", sshCfg.Host, sshCfg.Port), clientconfig); err != nil {
+		return
+	}
+	// a chunk of an upload the server fails is sent again as the default
+	// retry policy says, before the upload fails
+	policy := retry.Default()
 
-		if sftpclient, err = sftp.NewClient(sshconn); err == nil {
-			rfile, err = SafeCreateSSH(sftpclient, s.remotePath)
-		}
+	if sftpclient, err = sftp.NewClient(sshconn, sftp.WriteRetries(policy.Attempts, policy.Backoff), sftp.Umask(uploadUmask)); err != nil {
+		sshconn.Close()
+		return
 	}
-	return
+	transfers.Lock()
+	transfers.open[sftpclient] = true
+	transfers.Unlock()
+	return sftpclient, trackedConn{sftpclient, sshconn}, nil
 }
@@ -0,0 +1,105 @@
+package osutils_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+
+	"github.com/pivotalservices/gtils/command"
+	. "github.com/pivotalservices/gtils/osutils"
+	"github.com/pkg/sftp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type segmentedReader struct {
+	io.Reader
+	segments []Segment
+}
+
+func (s *segmentedReader) Segments() []Segment {
+	return s.segments
+}
+
+type pipeCloser []io.Closer
+
+func (s pipeCloser) Close() (err error) {
+	for _, closer := range s {
+		closer.Close()
+	}
+	return
+}
+
+var _ = Describe("RemoteOperations", func() {
+	var (
+		dir           string
+		connections   int32
+		newSftpClient func(command.SshConfig) (*sftp.Client, io.Closer, error)
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "cfops-remote")
+		connections = 0
+		newSftpClient = NewSftpClient
+		NewSftpClient = func(command.SshConfig) (client *sftp.Client, closer io.Closer, err error) {
+			// the pipes of the os buffer as an ssh channel does, which the sftp
+			// client needs to write while the server answers
+			serverIn, clientOut, _ := os.Pipe()
+			clientIn, serverOut, _ := os.Pipe()
+			server, _ := sftp.NewServer(serverIn, serverOut, ioutil.Discard, 0, false, dir)
+			go server.Serve()
+			atomic.AddInt32(&connections, 1)
+			client, err = sftp.NewClientPipe(clientIn, clientOut)
+			return client, pipeCloser{client, clientOut, serverOut, serverIn, clientIn}, err
+		}
+	})
+
+	AfterEach(func() {
+		NewSftpClient = newSftpClient
+		os.RemoveAll(dir)
+	})
+
+	Describe("UploadFile", func() {
+		var remoteOps interface {
+			UploadFile(io.Reader) error
+			SetPath(string)
+			Path() string
+		}
+
+		BeforeEach(func() {
+			remoteOps = NewRemoteOperations(command.SshConfig{})
+			remoteOps.SetPath(path.Join(dir, "archive.backup"))
+		})
+
+		Context("with a reader of a single stream", func() {
+			It("should copy the whole of it", func() {
+				err := remoteOps.UploadFile(bytes.NewBufferString("the whole file"))
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(ioutil.ReadFile(remoteOps.Path())).Should(Equal([]byte("the whole file")))
+				Ω(atomic.LoadInt32(&connections)).Should(Equal(int32(1)))
+			})
+		})
+
+		Context("with a file in segments", func() {
+			It("should write each segment at its offset on a connection of its own", func() {
+				contents := bytes.Repeat([]byte("0123456789"), 100000)
+				file := &segmentedReader{
+					Reader: bytes.NewReader(contents),
+					segments: []Segment{
+						{Offset: 0, Reader: bytes.NewReader(contents[:300000])},
+						{Offset: 300000, Reader: bytes.NewReader(contents[300000:700000])},
+						{Offset: 700000, Reader: bytes.NewReader(contents[700000:])},
+					},
+				}
+				err := remoteOps.UploadFile(file)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(ioutil.ReadFile(remoteOps.Path())).Should(Equal(contents))
+				Ω(atomic.LoadInt32(&connections)).Should(Equal(int32(4)))
+			})
+		})
+	})
+})
@@ -19,6 +19,9 @@ const (
 var (
 	MSQLDMP_DUMP_BIN string = "/var/vcap/packages/mariadb/bin/mysqldump"
 	MSQLDMP_SQL_BIN         = "/var/vcap/packages/mariadb/bin/mysql"
+	// MSQLDMP_DUMP_OPTIONS are added to every dump, e.g. to record the binlog
+	// coordinates the dump is consistent with
+	MSQLDMP_DUMP_OPTIONS string
 )
 
 type MysqlDump struct {
@@ -88,7 +91,12 @@ func (s *MysqlDump) getFlushCommand() string {
 }
 
 func (s *MysqlDump) getDumpCommand() string {
-	return fmt.Sprintf(MSQLDMP_DUMP_CMD, s.getConnectCommand(MSQLDMP_DUMP_BIN))
+	cmd := fmt.Sprintf(MSQLDMP_DUMP_CMD, s.getConnectCommand(MSQLDMP_DUMP_BIN))
+
+	if MSQLDMP_DUMP_OPTIONS != "" {
+		cmd += " " + MSQLDMP_DUMP_OPTIONS
+	}
+	return cmd
 }
 
 func (s *MysqlDump) getConnectCommand(bin string) string {
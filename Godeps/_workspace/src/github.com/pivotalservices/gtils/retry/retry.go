@@ -0,0 +1,280 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultAttempts   = 3
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Policy describes how an operation against a remote host is retried: it is
+// tried up to Attempts times in all while Retryable says its error is worth
+// trying again, waiting Backoff before the second attempt and twice as long
+// before each one after that, never longer than MaxBackoff
+type Policy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Retryable  func(error) bool
+	// Statuses are further status codes a request is sent again on, besides
+	// the transient ones, e.g. 500 from a flaky load balancer
+	Statuses []int
+	// OnRetry, when set, is told of every failed attempt that is tried again,
+	// typically to log it
+	OnRetry func(operation string, attempt int, err error, wait time.Duration)
+}
+
+var (
+	defaultMutex  sync.Mutex
+	defaultPolicy = Policy{
+		Attempts:   DefaultAttempts,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Retryable:  Transient,
+	}
+	// interrupted is closed by Interrupt, stopping the retries waiting on it
+	interrupted = make(chan struct{})
+)
+
+// Default is the policy the remote operations of gtils and its users retry
+// with
+func Default() Policy {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultPolicy
+}
+
+// SetDefault replaces the default policy, a policy without a Retryable
+// retrying transient errors and one of less than one attempt trying once.
+// The returned function restores the policy it replaced
+func SetDefault(policy Policy) (restore func()) {
+	if policy.Retryable == nil {
+		policy.Retryable = Transient
+	}
+
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+
+	if policy.Backoff < 0 {
+		policy.Backoff = 0
+	}
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	replaced := defaultPolicy
+	defaultPolicy = policy
+
+	return func() {
+		defaultMutex.Lock()
+		defer defaultMutex.Unlock()
+		defaultPolicy = replaced
+	}
+}
+
+// Interrupt stops every retry waiting to try its operation again, which
+// fails with the error of its last attempt
+func Interrupt() {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	close(interrupted)
+	interrupted = make(chan struct{})
+}
+
+// Do runs fn with the default policy
+func Do(operation string, fn func() error) error {
+	return Default().Do(operation, fn)
+}
+
+// Do runs fn until it succeeds, fails with an error the policy does not
+// retry, or has been tried as many times as the policy allows, returning the
+// error of its last attempt
+func (p Policy) Do(operation string, fn func() error) (err error) {
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.Attempts || !p.retryable(err) {
+			return
+		}
+		wait := p.wait(attempt)
+
+		if p.OnRetry != nil {
+			p.OnRetry(operation, attempt, err, wait)
+		}
+
+		if !sleep(wait) {
+			return
+		}
+	}
+}
+
+// Request sends the request with the client until the server answers with a
+// status that is not transient. A request whose body can not be read again
+// is sent only once, and a POST or PATCH only again when the server can not
+// have acted on it: it refused the connection or answered too many requests
+// or unavailable. The response of the last attempt is returned whatever its
+// status
+func (p Policy) Request(client *http.Client, request *http.Request) (response *http.Response, err error) {
+	operation := request.Method + " " + request.URL.String()
+
+	if request.Body != nil && request.GetBody == nil {
+		return client.Do(request)
+	}
+	attempt := 0
+
+	if request.Method == "POST" || request.Method == "PATCH" {
+		retryable := p.retryable
+		p.Retryable = func(err error) bool { return unsent(err) && retryable(err) }
+	}
+
+	err = p.Do(operation, func() (attemptErr error) {
+		if attempt++; attempt > 1 && request.GetBody != nil {
+			if request.Body, attemptErr = request.GetBody(); attemptErr != nil {
+				return
+			}
+		}
+
+		if response, attemptErr = client.Do(request); attemptErr != nil || !p.transientStatus(response.StatusCode) {
+			return
+		}
+		attemptErr = &StatusError{Operation: operation, Status: response.Status, Code: response.StatusCode}
+
+		// the response of the last attempt is left to the caller
+		if attempt < p.Attempts && p.retryable(attemptErr) {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+		return
+	})
+
+	if _, ok := err.(*StatusError); ok {
+		err = nil
+	}
+	return
+}
+
+func (p Policy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return Transient(err)
+	}
+	return p.Retryable(err)
+}
+
+// wait is how long to wait after the failed attempt before the next one
+func (p Policy) wait(attempt int) (wait time.Duration) {
+	wait = p.Backoff
+
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return
+}
+
+// sleep waits, returning false when interrupted
+func sleep(wait time.Duration) bool {
+	defaultMutex.Lock()
+	stop := interrupted
+	defaultMutex.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+
+	case <-stop:
+		return false
+	}
+}
+
+// StatusError is a transient status a server answered with
+type StatusError struct {
+	Operation string
+	Status    string
+	Code      int
+}
+
+func (s *StatusError) Error() string {
+	return s.Operation + " responded with " + s.Status
+}
+
+// Transient tells whether err is worth trying again: the connection was
+// refused, reset, cut short or timed out, or the server answered with a
+// transient status. Rejected credentials, unknown hosts and cancelled
+// operations are not
+func Transient(err error) bool {
+	var (
+		dnsErr    *net.DNSError
+		netErr    net.Error
+		statusErr *StatusError
+	)
+
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+
+	case errors.As(err, &statusErr):
+		return true
+
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ETIMEDOUT), errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return true
+
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	}
+	// ssh wraps the errors of its handshake as text
+	message := err.Error()
+	return strings.HasSuffix(message, "handshake failed: EOF") || strings.Contains(message, "connection reset by peer")
+}
+
+// unsent tells whether the server can not have acted on a request failing
+// with err
+func unsent(err error) bool {
+	var statusErr *StatusError
+
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code == http.StatusServiceUnavailable
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// transientStatus tells whether a request answered with the status code is
+// sent again: it is transient, or one of the further Statuses of the policy
+func (p Policy) transientStatus(code int) bool {
+	for _, status := range p.Statuses {
+		if status == code {
+			return true
+		}
+	}
+	return TransientStatus(code)
+}
+
+// TransientStatus tells whether a server answering with the status code may
+// answer the same request differently later
+func TransientStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
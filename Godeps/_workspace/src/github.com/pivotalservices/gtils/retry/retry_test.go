@@ -0,0 +1,188 @@
+package retry_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotalservices/gtils/retry"
+)
+
+var _ = Describe("Policy", func() {
+	var (
+		policy Policy
+		waits  []time.Duration
+	)
+
+	BeforeEach(func() {
+		waits = nil
+		policy = Policy{
+			Attempts:   4,
+			Backoff:    time.Millisecond,
+			MaxBackoff: 3 * time.Millisecond,
+			Retryable:  Transient,
+			OnRetry: func(operation string, attempt int, err error, wait time.Duration) {
+				waits = append(waits, wait)
+			},
+		}
+	})
+
+	Describe("Do", func() {
+		It("should try a transient failure again, backing off up to the longest wait", func() {
+			attempts := 0
+			err := policy.Do("dial", func() error {
+				attempts++
+				return syscall.ECONNREFUSED
+			})
+			Ω(err).Should(Equal(syscall.ECONNREFUSED))
+			Ω(attempts).Should(Equal(4))
+			Ω(waits).Should(Equal([]time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}))
+		})
+
+		It("should stop once the operation succeeds", func() {
+			attempts := 0
+			err := policy.Do("dial", func() error {
+				if attempts++; attempts < 2 {
+					return io.EOF
+				}
+				return nil
+			})
+			Ω(err).Should(BeNil())
+			Ω(attempts).Should(Equal(2))
+		})
+
+		It("should not try a failure that is not transient again", func() {
+			attempts := 0
+			err := policy.Do("dial", func() error {
+				attempts++
+				return errors.New("ssh: unable to authenticate")
+			})
+			Ω(err).ShouldNot(BeNil())
+			Ω(attempts).Should(Equal(1))
+		})
+
+		It("should stop waiting once interrupted", func() {
+			policy.Backoff, policy.MaxBackoff = time.Hour, time.Hour
+			attempts := 0
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				Interrupt()
+			}()
+			err := policy.Do("dial", func() error {
+				attempts++
+				return syscall.ECONNRESET
+			})
+			Ω(err).Should(Equal(syscall.ECONNRESET))
+			Ω(attempts).Should(Equal(1))
+		})
+	})
+
+	Describe("Request", func() {
+		var (
+			server   *httptest.Server
+			statuses []int
+			bodies   []string
+		)
+
+		BeforeEach(func() {
+			statuses, bodies = []int{http.StatusServiceUnavailable, http.StatusOK}, nil
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(statuses[0])
+				fmt.Fprint(w, "answer")
+
+				if len(statuses) > 1 {
+					statuses = statuses[1:]
+				}
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should send a request again with its body while the server is unavailable", func() {
+			request, _ := http.NewRequest("PUT", server.URL, strings.NewReader("settings"))
+			response, err := policy.Request(http.DefaultClient, request)
+			Ω(err).Should(BeNil())
+			Ω(response.StatusCode).Should(Equal(http.StatusOK))
+			Ω(bodies).Should(Equal([]string{"settings", "settings"}))
+		})
+
+		It("should send a request again on the further statuses of the policy", func() {
+			statuses = []int{http.StatusInternalServerError, http.StatusOK}
+			request, _ := http.NewRequest("GET", server.URL, nil)
+			response, _ := policy.Request(http.DefaultClient, request)
+			Ω(response.StatusCode).Should(Equal(http.StatusInternalServerError))
+			Ω(bodies).Should(HaveLen(1))
+
+			statuses, bodies = []int{http.StatusInternalServerError, http.StatusOK}, nil
+			policy.Statuses = []int{http.StatusInternalServerError}
+			request, _ = http.NewRequest("GET", server.URL, nil)
+			response, err := policy.Request(http.DefaultClient, request)
+			Ω(err).Should(BeNil())
+			Ω(response.StatusCode).Should(Equal(http.StatusOK))
+			Ω(bodies).Should(HaveLen(2))
+		})
+
+		It("should return the response of the last attempt", func() {
+			statuses = []int{http.StatusBadGateway}
+			request, _ := http.NewRequest("GET", server.URL, nil)
+			response, err := policy.Request(http.DefaultClient, request)
+			Ω(err).Should(BeNil())
+			Ω(response.StatusCode).Should(Equal(http.StatusBadGateway))
+			body, _ := io.ReadAll(response.Body)
+			Ω(string(body)).Should(Equal("answer"))
+			Ω(bodies).Should(HaveLen(4))
+		})
+
+		It("should send a POST again only when the server can not have acted on it", func() {
+			statuses = []int{http.StatusBadGateway}
+			request, _ := http.NewRequest("POST", server.URL, strings.NewReader("install"))
+			response, _ := policy.Request(http.DefaultClient, request)
+			Ω(response.StatusCode).Should(Equal(http.StatusBadGateway))
+			Ω(bodies).Should(HaveLen(1))
+
+			statuses, bodies = []int{http.StatusServiceUnavailable, http.StatusOK}, nil
+			request, _ = http.NewRequest("POST", server.URL, strings.NewReader("install"))
+			response, _ = policy.Request(http.DefaultClient, request)
+			Ω(response.StatusCode).Should(Equal(http.StatusOK))
+			Ω(bodies).Should(HaveLen(2))
+		})
+
+		It("should send a body that can not be read again only once", func() {
+			request, _ := http.NewRequest("PUT", server.URL, io.MultiReader(strings.NewReader("stream")))
+			response, err := policy.Request(http.DefaultClient, request)
+			Ω(err).Should(BeNil())
+			Ω(response.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+			Ω(bodies).Should(HaveLen(1))
+		})
+	})
+})
+
+var _ = Describe("SetDefault", func() {
+	It("should replace the default policy until restored", func() {
+		restore := SetDefault(Policy{Attempts: 0, Backoff: time.Minute})
+		Ω(Default().Attempts).Should(Equal(1))
+		Ω(Default().Retryable).ShouldNot(BeNil())
+		restore()
+		Ω(Default().Attempts).Should(Equal(DefaultAttempts))
+	})
+})
+
+var _ = Describe("Transient", func() {
+	It("should tell network failures from the others", func() {
+		Ω(Transient(fmt.Errorf("ssh: handshake failed: %v", io.EOF))).Should(BeTrue())
+		Ω(Transient(&StatusError{Operation: "GET /", Status: "503 Service Unavailable"})).Should(BeTrue())
+		Ω(Transient(errors.New("ssh: handshake failed: ssh: unable to authenticate"))).Should(BeFalse())
+		Ω(Transient(nil)).Should(BeFalse())
+	})
+})
@@ -2,15 +2,12 @@ package sftp
 
 import (
 	"bytes"
-	"encoding"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/kr/fs"
@@ -55,11 +52,8 @@ func NewClient(conn *ssh.Client, opts ...func(*Client) error) (*Client, error) {
 // the system's ssh client program (e.g. via exec.Command).
 func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...func(*Client) error) (*Client, error) {
 	sftp := &Client{
-		w:          wr,
-		r:          rd,
+		clientConn: newClientConn(rd, wr),
 		maxPacket:  1 << 15,
-		inflight:   make(map[uint32]chan<- result),
-		recvClosed: make(chan struct{}),
 	}
 	if err := sftp.applyOptions(opts...); err != nil {
 		wr.Close()
@@ -79,25 +73,28 @@ func NewClientPipe(rd io.Reader, wr io.WriteCloser, opts ...func(*Client) error)
 
 // Client represents an SFTP session on a *ssh.ClientConn SSH connection.
 // Multiple Clients can be active on a single SSH connection, and a Client
-// may be called concurrently from multiple Goroutines.
+// may be called concurrently from multiple Goroutines, whose requests are
+// all in flight at once rather than waiting for each other's responses.
 //
 // Client implements the github.com/kr/fs.FileSystem interface.
 type Client struct {
-	w io.WriteCloser
-	r io.Reader
+	clientConn
 
 	maxPacket int // max packet size read or written.
-	nextid    uint32
 
-	mu         sync.Mutex               // ensures only on request is in flight to the server at once
-	inflight   map[uint32]chan<- result // outstanding requests
-	recvClosed chan struct{}            // remote end has closed the connection
+	stats *statCache // nil unless the StatCache option is given
+
+	writeAttempts int           // tries of a chunk written, once unless WriteRetries is given
+	writeBackoff  time.Duration // wait before the second try
+
+	umask   os.FileMode // taken from the modes of files and directories created
+	umasked bool        // whether the Umask option was given
 }
 
 // Close closes the SFTP session.
 func (c *Client) Close() error {
 	err := c.w.Close()
-	<-c.recvClosed
+	<-c.closed
 	return err
 }
 
@@ -111,14 +108,11 @@ func (c *Client) Create(path string) (*File, error) {
 const sftpProtocolVersion = 3 // http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
 
 func (c *Client) sendInit() error {
-	return sendPacket(c.w, sshFxInitPacket{
+	bb, err := writePacket(c.w, sshFxInitPacket{
 		Version: sftpProtocolVersion, // http://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
 	})
-}
-
-// returns the next value of c.nextid
-func (c *Client) nextId() uint32 {
-	return atomic.AddUint32(&c.nextid, 1)
+	c.counters.sent(bb)
+	return err
 }
 
 func (c *Client) recvVersion() error {
@@ -126,6 +120,7 @@ func (c *Client) recvVersion() error {
 	if err != nil {
 		return err
 	}
+	c.counters.received(data)
 	if typ != ssh_FXP_VERSION {
 		return &unexpectedPacketErr{ssh_FXP_VERSION, typ}
 	}
@@ -138,46 +133,6 @@ func (c *Client) recvVersion() error {
 	return nil
 }
 
-// broadcastErr sends an error to all goroutines waiting for a response.
-func (c *Client) broadcastErr(err error) {
-	c.mu.Lock()
-	listeners := make([]chan<- result, 0, len(c.inflight))
-	for _, ch := range c.inflight {
-		listeners = append(listeners, ch)
-	}
-	c.mu.Unlock()
-	for _, ch := range listeners {
-		ch <- result{err: err}
-	}
-}
-
-// recv continuously reads from the server and forwards responses to the
-// appropriate channel.
-func (c *Client) recv() {
-	defer close(c.recvClosed)
-	for {
-		typ, data, err := recvPacket(c.r)
-		if err != nil {
-			// Return the error to all listeners.
-			c.broadcastErr(err)
-			return
-		}
-		sid, _ := unmarshalUint32(data)
-		c.mu.Lock()
-		ch, ok := c.inflight[sid]
-		delete(c.inflight, sid)
-		c.mu.Unlock()
-		if !ok {
-			// This is an unexpected occurrence. Send the error
-			// back to all listeners so that they terminate
-			// gracefully.
-			c.broadcastErr(fmt.Errorf("sid: %v not fond", sid))
-			return
-		}
-		ch <- result{typ: typ, data: data}
-	}
-}
-
 // Walk returns a new Walker rooted at root.
 func (c *Client) Walk(root string) *fs.Walker {
 	return fs.WalkFS(root, c)
@@ -224,7 +179,9 @@ func (c *Client) ReadDir(p string) ([]os.FileInfo, error) {
 			}
 		case ssh_FXP_STATUS:
 			// TODO(dfc) scope warning!
-			err = eofOrErr(unmarshalStatus(id, data))
+			if err = eofOrErr(unmarshalStatus(id, data)); err != io.EOF {
+				err = pathError("readdir", p, err)
+			}
 			done = true
 		default:
 			return nil, unimplementedPacketErr(typ)
@@ -253,7 +210,7 @@ func (c *Client) opendir(path string) (string, error) {
 		handle, _ := unmarshalString(data)
 		return handle, nil
 	case ssh_FXP_STATUS:
-		return "", unmarshalStatus(id, data)
+		return "", pathError("opendir", path, unmarshalStatus(id, data))
 	default:
 		return "", unimplementedPacketErr(typ)
 	}
@@ -262,6 +219,10 @@ func (c *Client) opendir(path string) (string, error) {
 // Stat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the referent file.
 func (c *Client) Stat(p string) (os.FileInfo, error) {
+	if info, ok := c.stats.get(p, false); ok {
+		return info, nil
+	}
+	epoch := c.stats.begin()
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpStatPacket{
 		Id:   id,
@@ -277,9 +238,11 @@ func (c *Client) Stat(p string) (os.FileInfo, error) {
 			return nil, &unexpectedIdErr{id, sid}
 		}
 		attr, _ := unmarshalAttrs(data)
-		return fileInfoFromStat(attr, path.Base(p)), nil
+		info := fileInfoFromStat(attr, path.Base(p))
+		c.stats.put(p, false, info, epoch)
+		return info, nil
 	case ssh_FXP_STATUS:
-		return nil, unmarshalStatus(id, data)
+		return nil, pathError("stat", p, unmarshalStatus(id, data))
 	default:
 		return nil, unimplementedPacketErr(typ)
 	}
@@ -288,6 +251,10 @@ func (c *Client) Stat(p string) (os.FileInfo, error) {
 // Lstat returns a FileInfo structure describing the file specified by path 'p'.
 // If 'p' is a symbolic link, the returned FileInfo structure describes the symbolic link.
 func (c *Client) Lstat(p string) (os.FileInfo, error) {
+	if info, ok := c.stats.get(p, true); ok {
+		return info, nil
+	}
+	epoch := c.stats.begin()
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpLstatPacket{
 		Id:   id,
@@ -303,9 +270,11 @@ func (c *Client) Lstat(p string) (os.FileInfo, error) {
 			return nil, &unexpectedIdErr{id, sid}
 		}
 		attr, _ := unmarshalAttrs(data)
-		return fileInfoFromStat(attr, path.Base(p)), nil
+		info := fileInfoFromStat(attr, path.Base(p))
+		c.stats.put(p, true, info, epoch)
+		return info, nil
 	case ssh_FXP_STATUS:
-		return nil, unmarshalStatus(id, data)
+		return nil, pathError("lstat", p, unmarshalStatus(id, data))
 	default:
 		return nil, unimplementedPacketErr(typ)
 	}
@@ -334,7 +303,7 @@ func (c *Client) ReadLink(p string) (string, error) {
 		filename, _ := unmarshalString(data) // ignore dummy attributes
 		return filename, nil
 	case ssh_FXP_STATUS:
-		return "", unmarshalStatus(id, data)
+		return "", pathError("readlink", p, unmarshalStatus(id, data))
 	default:
 		return "", unimplementedPacketErr(typ)
 	}
@@ -342,6 +311,7 @@ func (c *Client) ReadLink(p string) (string, error) {
 
 // Symlink creates a symbolic link at 'newname', pointing at target 'oldname'
 func (c *Client) Symlink(oldname, newname string) error {
+	defer c.stats.invalidate(newname)
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpSymlinkPacket{
 		Id:         id,
@@ -353,7 +323,7 @@ func (c *Client) Symlink(oldname, newname string) error {
 	}
 	switch typ {
 	case ssh_FXP_STATUS:
-		return okOrErr(unmarshalStatus(id, data))
+		return linkError("symlink", oldname, newname, unmarshalStatus(id, data))
 	default:
 		return unimplementedPacketErr(typ)
 	}
@@ -361,6 +331,7 @@ func (c *Client) Symlink(oldname, newname string) error {
 
 // setstat is a convience wrapper to allow for changing of various parts of the file descriptor.
 func (c *Client) setstat(path string, flags uint32, attrs interface{}) error {
+	defer c.stats.invalidate(path)
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpSetstatPacket{
 		Id:    id,
@@ -373,7 +344,7 @@ func (c *Client) setstat(path string, flags uint32, attrs interface{}) error {
 	}
 	switch typ {
 	case ssh_FXP_STATUS:
-		return okOrErr(unmarshalStatus(id, data))
+		return pathError("setstat", path, unmarshalStatus(id, data))
 	default:
 		return unimplementedPacketErr(typ)
 	}
@@ -427,11 +398,20 @@ func (c *Client) OpenFile(path string, f int) (*File, error) {
 }
 
 func (c *Client) open(path string, pflags uint32) (*File, error) {
+	if pflags != ssh_FXF_READ {
+		defer c.stats.invalidate(path)
+	}
+	var attrFlags, perm uint32
+	if pflags&ssh_FXF_CREAT != 0 {
+		attrFlags, perm = c.createAttrs(0666)
+	}
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpOpenPacket{
 		Id:     id,
 		Path:   path,
 		Pflags: pflags,
+		Flags:  attrFlags,
+		Perm:   perm,
 	})
 	if err != nil {
 		return nil, err
@@ -445,7 +425,7 @@ func (c *Client) open(path string, pflags uint32) (*File, error) {
 		handle, _ := unmarshalString(data)
 		return &File{c: c, path: path, handle: handle}, nil
 	case ssh_FXP_STATUS:
-		return nil, unmarshalStatus(id, data)
+		return nil, pathError("open", path, unmarshalStatus(id, data))
 	default:
 		return nil, unimplementedPacketErr(typ)
 	}
@@ -522,7 +502,7 @@ func (c *Client) StatVFS(path string) (*StatVFS, error) {
 
 	// the resquest failed
 	case ssh_FXP_STATUS:
-		return nil, errors.New(fxp(ssh_FXP_STATUS).String())
+		return nil, pathError("statvfs", path, unmarshalStatus(id, data))
 
 	default:
 		return nil, unimplementedPacketErr(typ)
@@ -538,11 +518,49 @@ func (c *Client) Join(elem ...string) string { return path.Join(elem...) }
 // file or directory with the specified path exists, or if the specified directory
 // is not empty.
 func (c *Client) Remove(path string) error {
+	defer c.stats.invalidateTree(path)
 	err := c.removeFile(path)
 	if status, ok := err.(*StatusError); ok && status.Code == ssh_FX_FAILURE {
 		err = c.removeDirectory(path)
 	}
-	return err
+	return pathError("remove", path, err)
+}
+
+// RemoveAll removes path and everything it contains, depth first. Symbolic
+// links are removed, never followed, so nothing outside the tree goes with
+// it. It removes what it can and returns the first error it encountered,
+// and nil when path does not exist. The root of the server is not removed.
+func (c *Client) RemoveAll(p string) error {
+	if path.Clean(p) == "/" {
+		return &os.PathError{Op: "remove", Path: p, Err: errors.New("sftp: refusing to remove the root")}
+	}
+	info, err := c.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.removeAll(p, info)
+}
+
+func (c *Client) removeAll(p string, info os.FileInfo) error {
+	if !info.IsDir() {
+		return c.Remove(p)
+	}
+	entries, err := c.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// the entries of a directory are not followed, as Lstat does not
+		if childErr := c.removeAll(path.Join(p, entry.Name()), entry); childErr != nil && err == nil {
+			err = childErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return c.Remove(p)
 }
 
 func (c *Client) removeFile(path string) error {
@@ -581,6 +599,7 @@ func (c *Client) removeDirectory(path string) error {
 
 // Rename renames a file.
 func (c *Client) Rename(oldname, newname string) error {
+	defer c.stats.invalidateTree(oldname, newname)
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpRenamePacket{
 		Id:      id,
@@ -592,58 +611,31 @@ func (c *Client) Rename(oldname, newname string) error {
 	}
 	switch typ {
 	case ssh_FXP_STATUS:
-		return okOrErr(unmarshalStatus(id, data))
+		return linkError("rename", oldname, newname, unmarshalStatus(id, data))
 	default:
 		return unimplementedPacketErr(typ)
 	}
 }
 
-// result captures the result of receiving the a packet from the server
-type result struct {
-	typ  byte
-	data []byte
-	err  error
-}
-
-type idmarshaler interface {
-	id() uint32
-	encoding.BinaryMarshaler
-}
-
-func (c *Client) sendRequest(p idmarshaler) (byte, []byte, error) {
-	ch := make(chan result, 1)
-	c.dispatchRequest(ch, p)
-	s := <-ch
-	return s.typ, s.data, s.err
-}
-
-func (c *Client) dispatchRequest(ch chan<- result, p idmarshaler) {
-	c.mu.Lock()
-	c.inflight[p.id()] = ch
-	if err := sendPacket(c.w, p); err != nil {
-		delete(c.inflight, p.id())
-		c.mu.Unlock()
-		ch <- result{err: err}
-		return
-	}
-	c.mu.Unlock()
-}
-
 // Creates the specified directory. An error will be returned if a file or
 // directory with the specified path already exists, or if the directory's
 // parent folder does not exist (the method cannot create complete paths).
 func (c *Client) Mkdir(path string) error {
+	defer c.stats.invalidate(path)
+	attrFlags, perm := c.createAttrs(0777)
 	id := c.nextId()
 	typ, data, err := c.sendRequest(sshFxpMkdirPacket{
-		Id:   id,
-		Path: path,
+		Id:    id,
+		Path:  path,
+		Flags: attrFlags,
+		Perm:  perm,
 	})
 	if err != nil {
 		return err
 	}
 	switch typ {
 	case ssh_FXP_STATUS:
-		return okOrErr(unmarshalStatus(id, data))
+		return pathError("mkdir", path, unmarshalStatus(id, data))
 	default:
 		return unimplementedPacketErr(typ)
 	}
@@ -671,23 +663,38 @@ type File struct {
 // Close closes the File, rendering it unusable for I/O. It returns an
 // error, if any.
 func (f *File) Close() error {
-	return f.c.close(f.handle)
+	return pathError("close", f.path, f.c.close(f.handle))
 }
 
 const maxConcurrentRequests = 64
 
+var errNegativeOffset = errors.New("sftp: negative offset")
+
 // Read reads up to len(b) bytes from the File. It returns the number of
 // bytes read and an error, if any. EOF is signaled by a zero count with
 // err set to io.EOF.
 func (f *File) Read(b []byte) (int, error) {
+	read, err := f.ReadAt(b, int64(f.offset))
+	f.offset += uint64(read)
+	return read, err
+}
+
+// ReadAt reads len(b) bytes from the File starting at byte offset off,
+// without moving the offset Read and Write use. It returns the number of
+// bytes read and an error, which is io.EOF when the file ends before b is
+// full. Reads at different offsets of the File may run at once.
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
 	// Split the read into multiple maxPacket sized concurrent reads
 	// bounded by maxConcurrentRequests. This allows reads with a suitably
 	// large buffer to transfer data at a much faster rate due to
 	// overlapping round trip times.
 	inFlight := 0
 	desiredInFlight := 1
-	offset := f.offset
-	ch := make(chan result)
+	offset := uint64(off)
+	ch := make(chan result, maxConcurrentRequests)
 	type inflightRead struct {
 		b      []byte
 		offset uint64
@@ -741,7 +748,7 @@ func (f *File) Read(b []byte) (int, error) {
 			switch res.typ {
 			case ssh_FXP_STATUS:
 				if firstErr.err == nil || req.offset < firstErr.offset {
-					firstErr = offsetErr{offset: req.offset, err: eofOrErr(unmarshalStatus(reqId, res.data))}
+					firstErr = offsetErr{offset: req.offset, err: readError(f.path, unmarshalStatus(reqId, res.data))}
 					break
 				}
 			case ssh_FXP_DATA:
@@ -767,7 +774,6 @@ func (f *File) Read(b []byte) (int, error) {
 	if firstErr.err != nil && firstErr.err != io.EOF {
 		read = 0
 	}
-	f.offset += uint64(read)
 	return read, firstErr.err
 }
 
@@ -783,7 +789,7 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 	offset := f.offset
 	writeOffset := offset
 	fileSize := uint64(fi.Size())
-	ch := make(chan result)
+	ch := make(chan result, maxConcurrentRequests)
 	type inflightRead struct {
 		b      []byte
 		offset uint64
@@ -839,7 +845,7 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 			switch res.typ {
 			case ssh_FXP_STATUS:
 				if firstErr.err == nil || req.offset < firstErr.offset {
-					firstErr = offsetErr{offset: req.offset, err: eofOrErr(unmarshalStatus(reqId, res.data))}
+					firstErr = offsetErr{offset: req.offset, err: readError(f.path, unmarshalStatus(reqId, res.data))}
 					break
 				}
 			case ssh_FXP_DATA:
@@ -901,7 +907,7 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 func (f *File) Stat() (os.FileInfo, error) {
 	fs, err := f.c.fstat(f.handle)
 	if err != nil {
-		return nil, err
+		return nil, pathError("stat", f.path, err)
 	}
 	return fileInfoFromStat(fs, path.Base(f.path)), nil
 }
@@ -910,136 +916,48 @@ func (f *File) Stat() (os.FileInfo, error) {
 // written and an error, if any. Write returns a non-nil error when n !=
 // len(b).
 func (f *File) Write(b []byte) (int, error) {
-	// Split the write into multiple maxPacket sized concurrent writes
-	// bounded by maxConcurrentRequests. This allows writes with a suitably
-	// large buffer to transfer data at a much faster rate due to
-	// overlapping round trip times.
-	inFlight := 0
-	desiredInFlight := 1
-	offset := f.offset
-	ch := make(chan result)
-	var firstErr error
-	written := len(b)
-	for len(b) > 0 || inFlight > 0 {
-		for inFlight < desiredInFlight && len(b) > 0 && firstErr == nil {
-			l := min(len(b), f.c.maxPacket)
-			rb := b[:l]
-			f.c.dispatchRequest(ch, sshFxpWritePacket{
-				Id:     f.c.nextId(),
-				Handle: f.handle,
-				Offset: offset,
-				Length: uint32(len(rb)),
-				Data:   rb,
-			})
-			inFlight++
-			offset += uint64(l)
-			b = b[l:]
-		}
+	written, err := f.WriteAt(b, int64(f.offset))
+	f.offset += uint64(written)
+	return written, err
+}
 
-		if inFlight == 0 {
-			break
-		}
-		select {
-		case res := <-ch:
-			inFlight--
-			if res.err != nil {
-				firstErr = res.err
-				break
-			}
-			switch res.typ {
-			case ssh_FXP_STATUS:
-				id, _ := unmarshalUint32(res.data)
-				err := okOrErr(unmarshalStatus(id, res.data))
-				if err != nil && firstErr == nil {
-					firstErr = err
-					break
-				}
-				if desiredInFlight < maxConcurrentRequests {
-					desiredInFlight++
-				}
-			default:
-				firstErr = unimplementedPacketErr(res.typ)
-				break
-			}
+// WriteAt writes len(b) bytes to the File starting at byte offset off,
+// without moving the offset Read and Write use. It returns the number of
+// bytes written and an error, which is non-nil when n != len(b): n is the
+// number of bytes from off on that were all written, and the error is a
+// *WriteError saying which range failed when the server failed one. Writes
+// to different ranges of the File may run at once.
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	defer f.c.stats.invalidate(f.path)
+	n, err := f.writeChunks(uint64(off), func() ([]byte, error) {
+		if len(b) == 0 {
+			return nil, io.EOF
 		}
-	}
-	// If error is non-nil, then there may be gaps in the data written to
-	// the file so it's best to return 0 so the caller can't make any
-	// incorrect assumptions about the state of the file.
-	if firstErr != nil {
-		written = 0
-	}
-	f.offset += uint64(written)
-	return written, firstErr
+		l := min(len(b), f.c.maxPacket)
+		chunk := b[:l]
+		b = b[l:]
+		return chunk, nil
+	})
+	return int(n), err
 }
 
 // ReadFrom reads data from r until EOF and writes it to the file. The return
-// value is the number of bytes read. Any error except io.EOF encountered
-// during the read is also returned.
+// value is the number of bytes written, all of those read unless a write
+// failed, in which case the error is a *WriteError saying where to resume.
+// Any error except io.EOF encountered during the read is also returned.
 func (f *File) ReadFrom(r io.Reader) (int64, error) {
-	inFlight := 0
-	desiredInFlight := 1
-	offset := f.offset
-	ch := make(chan result)
-	var firstErr error
-	read := int64(0)
-	b := make([]byte, f.c.maxPacket)
-	for inFlight > 0 || firstErr == nil {
-		for inFlight < desiredInFlight && firstErr == nil {
-			n, err := r.Read(b)
-			if err != nil {
-				firstErr = err
-			}
-			f.c.dispatchRequest(ch, sshFxpWritePacket{
-				Id:     f.c.nextId(),
-				Handle: f.handle,
-				Offset: offset,
-				Length: uint32(n),
-				Data:   b[:n],
-			})
-			inFlight++
-			offset += uint64(n)
-			read += int64(n)
-		}
-
-		if inFlight == 0 {
-			break
-		}
-		select {
-		case res := <-ch:
-			inFlight--
-			if res.err != nil {
-				firstErr = res.err
-				break
-			}
-			switch res.typ {
-			case ssh_FXP_STATUS:
-				id, _ := unmarshalUint32(res.data)
-				err := okOrErr(unmarshalStatus(id, res.data))
-				if err != nil && firstErr == nil {
-					firstErr = err
-					break
-				}
-				if desiredInFlight < maxConcurrentRequests {
-					desiredInFlight++
-				}
-			default:
-				firstErr = unimplementedPacketErr(res.typ)
-				break
-			}
-		}
-	}
-	if firstErr == io.EOF {
-		firstErr = nil
-	}
-	// If error is non-nil, then there may be gaps in the data written to
-	// the file so it's best to return 0 so the caller can't make any
-	// incorrect assumptions about the state of the file.
-	if firstErr != nil {
-		read = 0
-	}
-	f.offset += uint64(read)
-	return read, firstErr
+	defer f.c.stats.invalidate(f.path)
+	n, err := f.writeChunks(f.offset, func() ([]byte, error) {
+		// a chunk keeps its buffer until written, to be sent again
+		b := make([]byte, f.c.maxPacket)
+		n, err := r.Read(b)
+		return b[:n], err
+	})
+	f.offset += uint64(n)
+	return n, err
 }
 
 // Seek implements io.Seeker by setting the client offset for the next Read or
@@ -0,0 +1,84 @@
+package sftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+var (
+	_ io.ReaderAt = &File{}
+	_ io.WriterAt = &File{}
+)
+
+func TestFileWriteAtAndReadAtSegments(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	f, err := client.Create("/nfs_server.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	segment := 3*client.maxPacket + 17
+	segments := make([][]byte, 4)
+	for i := range segments {
+		segments[i] = bytes.Repeat([]byte{byte('a' + i)}, segment)
+	}
+
+	var wg sync.WaitGroup
+	for i, contents := range segments {
+		wg.Add(1)
+		go func(i int, contents []byte) {
+			defer wg.Done()
+			if n, err := f.WriteAt(contents, int64(i*segment)); err != nil || n != segment {
+				t.Errorf("WriteAt segment %d = %d, %v", i, n, err)
+			}
+		}(i, contents)
+	}
+	wg.Wait()
+
+	for i, contents := range segments {
+		wg.Add(1)
+		go func(i int, contents []byte) {
+			defer wg.Done()
+			read := make([]byte, segment)
+			if n, err := f.ReadAt(read, int64(i*segment)); err != nil || n != segment || !bytes.Equal(read, contents) {
+				t.Errorf("ReadAt segment %d = %d, %v", i, n, err)
+			}
+		}(i, contents)
+	}
+	wg.Wait()
+
+	if offset, _ := f.Seek(0, os.SEEK_CUR); offset != 0 {
+		t.Errorf("want the offset left at 0, got %d", offset)
+	}
+}
+
+func TestFileReadAtEnd(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	f, err := client.Create("/ccdb.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("-- PostgreSQL database dump complete"))
+
+	read := make([]byte, 16)
+	if n, err := f.ReadAt(read, 20); err != nil || n != 16 || string(read) != "se dump complete" {
+		t.Errorf("want the last 16 bytes, got %d %q, %v", n, read[:n], err)
+	}
+	if n, err := f.ReadAt(read, 30); err != io.EOF || n != 6 || string(read[:n]) != "mplete" {
+		t.Errorf("want the 6 bytes before the end and EOF, got %d %q, %v", n, read[:n], err)
+	}
+	if _, err := f.ReadAt(read, -1); err != errNegativeOffset {
+		t.Errorf("want a negative offset refused, got %v", err)
+	}
+	if _, err := f.WriteAt(read, -1); err != errNegativeOffset {
+		t.Errorf("want a negative offset refused, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package sftp
+
+// multiplexing of client requests over a session
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// result captures the result of receiving the a packet from the server
+type result struct {
+	typ  byte
+	data []byte
+	err  error
+}
+
+type idmarshaler interface {
+	id() uint32
+	encoding.BinaryMarshaler
+}
+
+// clientConn multiplexes the requests of a client over its session. Any
+// number of goroutines send requests at once without waiting for each
+// other's responses, and a single receiver hands each response to the
+// request with its id, in whatever order the server answers them.
+type clientConn struct {
+	counters clientCounters // first, for the alignment of its atomic counters
+
+	w io.WriteCloser
+	r io.Reader
+
+	nextid uint32
+
+	sendMu sync.Mutex // one packet is written to the server at a time
+
+	mu       sync.Mutex               // guards inflight and err, never held while sending
+	inflight map[uint32]chan<- result // outstanding requests
+	err      error                    // why the session ended, once it has
+	closed   chan struct{}            // closed once the receiver has returned
+}
+
+func newClientConn(rd io.Reader, wr io.WriteCloser) clientConn {
+	return clientConn{
+		w:        wr,
+		r:        rd,
+		inflight: make(map[uint32]chan<- result),
+		closed:   make(chan struct{}),
+	}
+}
+
+// returns the next value of c.nextid
+func (c *clientConn) nextId() uint32 {
+	return atomic.AddUint32(&c.nextid, 1)
+}
+
+// sendRequest sends the request and waits for its response.
+func (c *clientConn) sendRequest(p idmarshaler) (byte, []byte, error) {
+	ch := make(chan result, 1)
+	c.dispatchRequest(ch, p)
+	s := <-ch
+	return s.typ, s.data, s.err
+}
+
+// dispatchRequest sends the request without waiting for its response, which
+// is delivered on ch along with those of any other requests dispatched on
+// it. The receiver never waits for a caller, so ch must have room for a
+// result for every request outstanding on it.
+func (c *clientConn) dispatchRequest(ch chan<- result, p idmarshaler) {
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		ch <- result{err: err}
+		return
+	}
+	c.inflight[p.id()] = ch
+	c.mu.Unlock()
+
+	c.sendMu.Lock()
+	bb, err := writePacket(c.w, p)
+	c.sendMu.Unlock()
+	c.counters.sent(bb)
+	if err != nil {
+		// the receiver may have failed the request meanwhile
+		if ch, ok := c.take(p.id()); ok {
+			ch <- result{err: err}
+		}
+	}
+}
+
+// take removes the outstanding request with the id.
+func (c *clientConn) take(id uint32) (chan<- result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.inflight[id]
+	delete(c.inflight, id)
+	return ch, ok
+}
+
+// recv continuously reads from the server and forwards responses to the
+// appropriate channel, until the session ends.
+func (c *clientConn) recv() {
+	defer close(c.closed)
+	for {
+		typ, data, err := recvPacket(c.r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.counters.received(data)
+		sid, _ := unmarshalUint32(data)
+		ch, ok := c.take(sid)
+		if !ok {
+			// The server answered a request that was never sent, after
+			// which no response can be trusted to be what it seems.
+			c.fail(fmt.Errorf("sid: %v not found", sid))
+			return
+		}
+		ch <- result{typ: typ, data: data}
+	}
+}
+
+// fail ends the session with err: every outstanding request, and every one
+// sent from now on, fails with it.
+func (c *clientConn) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	listeners := c.inflight
+	c.inflight = make(map[uint32]chan<- result)
+	c.mu.Unlock()
+	for _, ch := range listeners {
+		ch <- result{err: err}
+	}
+}
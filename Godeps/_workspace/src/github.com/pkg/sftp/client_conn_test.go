@@ -0,0 +1,136 @@
+package sftp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallingWriter blocks its nth write until released
+type stallingWriter struct {
+	writes   int
+	nth      int
+	stalled  chan struct{}
+	released chan struct{}
+}
+
+func (w *stallingWriter) Write(b []byte) (int, error) {
+	if w.writes++; w.writes == w.nth {
+		close(w.stalled)
+		<-w.released
+	}
+	return len(b), nil
+}
+
+func TestClientConcurrentRequests(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("/blob-%d", i)
+			contents := bytes.Repeat([]byte{byte(i)}, 100000+i)
+			f, err := client.Create(name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			f.Write(contents)
+			f.Close()
+			if f, err = client.Open(name); err != nil {
+				errs <- err
+				return
+			}
+			defer f.Close()
+			if read, err := ioutil.ReadAll(f); err != nil || !bytes.Equal(read, contents) {
+				errs <- fmt.Errorf("%s read back %d bytes, %v", name, len(read), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestClientSlowConsumerDoesNotStallOthers(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	f, err := client.Create("/droplet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, 64*client.maxPacket))
+	f.Close()
+	if f, err = client.Open("/droplet"); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := &stallingWriter{nth: 4, stalled: make(chan struct{}), released: make(chan struct{})}
+	copied := make(chan error)
+	go func() {
+		_, err := f.WriteTo(w)
+		copied <- err
+	}()
+	<-w.stalled
+	// the responses to the reads still in flight arrive while WriteTo is stuck
+	stat := make(chan error)
+	go func() {
+		_, err := client.Stat("/droplet")
+		stat <- err
+	}()
+	select {
+	case err := <-stat:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Stat waited for WriteTo to consume its responses")
+	}
+	close(w.released)
+	if err := <-copied; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientFailsRequestsOnceTheSessionEnds(t *testing.T) {
+	cr, sw, _ := os.Pipe()
+	sr, cw, _ := os.Pipe()
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, "/", ServeFS(NewMemFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sw.Close()
+	<-client.closed
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Stat("/")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("want Stat to fail once the session ended")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Stat waited for a session that ended")
+	}
+}
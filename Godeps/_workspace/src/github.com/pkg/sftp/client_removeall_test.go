@@ -0,0 +1,50 @@
+package sftp
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRemoveAllTree(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	for _, dir := range []string{"/outside", "/store", "/store/cc-droplets", "/store/cc-droplets/ab", "/store/empty"} {
+		if err := client.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"/outside/keep", "/store/cc-droplets/ab/droplet", "/store/index"} {
+		f, err := client.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(name))
+		f.Close()
+	}
+	if err := client.Symlink("/outside", "/store/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RemoveAll("/store"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Lstat("/store"); !os.IsNotExist(err) {
+		t.Errorf("want the tree gone, got %v", err)
+	}
+	if _, err := client.Stat("/outside/keep"); err != nil {
+		t.Errorf("want the target of the link kept, got %v", err)
+	}
+}
+
+func TestRemoveAllMissing(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	if err := client.RemoveAll("/missing"); err != nil {
+		t.Errorf("want nothing to remove, got %v", err)
+	}
+	if err := client.RemoveAll("/"); err == nil {
+		t.Error("want the root refused")
+	}
+}
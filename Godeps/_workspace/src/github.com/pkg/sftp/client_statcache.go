@@ -0,0 +1,143 @@
+package sftp
+
+// caching of stat results on the client
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatCache has the client remember the results of Stat and Lstat for ttl,
+// so that asking again about an unchanging file does not round trip to the
+// server. Writing, truncating, chmod, chown, chtimes, removing, renaming,
+// mkdir and symlink through the client forget what it remembers of the
+// paths they change and of their parent directories; a remove or rename
+// forgets everything under the path as well. Changes made by anyone else go
+// unnoticed until the ttl passes, as do changes to the target of a symbolic
+// link Stat followed, so keep it short.
+func StatCache(ttl time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("stat cache ttl must be positive")
+		}
+		c.stats = &statCache{
+			ttl:     ttl,
+			now:     time.Now,
+			entries: make(map[statKey]statEntry),
+		}
+		return nil
+	}
+}
+
+type statKey struct {
+	path  string
+	lstat bool
+}
+
+type statEntry struct {
+	info    os.FileInfo
+	expires time.Time
+}
+
+// statCache is nil for clients without a stat cache, which its methods
+// treat as an empty cache
+type statCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[statKey]statEntry
+	swept   time.Time // when the expired entries were last dropped
+	// epoch counts the invalidations, so that a stat sent before one is not
+	// remembered when its answer comes after it
+	epoch uint64
+}
+
+func (s *statCache) get(p string, lstat bool) (os.FileInfo, bool) {
+	if s == nil {
+		return nil, false
+	}
+	key := statKey{path.Clean(p), lstat}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if ok && !s.now().Before(entry.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return entry.info, ok
+}
+
+// begin is the epoch to put the answer of a stat about to be sent with
+func (s *statCache) begin() uint64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.epoch
+}
+
+// put remembers the answer of a stat sent in the epoch, unless something was
+// invalidated while it was in flight, as the answer may predate the change
+func (s *statCache) put(p string, lstat bool, info os.FileInfo, epoch uint64) {
+	if s == nil {
+		return
+	}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if epoch != s.epoch {
+		return
+	}
+	// a walk stats every file once, drop those it is done with once a ttl
+	if now.Sub(s.swept) >= s.ttl {
+		for key, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, key)
+			}
+		}
+		s.swept = now
+	}
+	s.entries[statKey{path.Clean(p), lstat}] = statEntry{info, now.Add(s.ttl)}
+}
+
+// invalidate forgets the paths and their parent directories
+func (s *statCache) invalidate(paths ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epoch++
+	for _, p := range paths {
+		p = path.Clean(p)
+		for _, forgotten := range []string{p, path.Dir(p)} {
+			delete(s.entries, statKey{forgotten, false})
+			delete(s.entries, statKey{forgotten, true})
+		}
+	}
+}
+
+// invalidateTree forgets the paths, their parent directories and
+// everything under them
+func (s *statCache) invalidateTree(paths ...string) {
+	if s == nil {
+		return
+	}
+	s.invalidate(paths...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range paths {
+		prefix := strings.TrimSuffix(path.Clean(p), "/") + "/"
+		for key := range s.entries {
+			if strings.HasPrefix(key.path, prefix) {
+				delete(s.entries, key)
+			}
+		}
+	}
+}
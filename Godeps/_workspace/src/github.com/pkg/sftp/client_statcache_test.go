@@ -0,0 +1,143 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// testStatCacheClient connects a client with a stat cache to an in-process
+// server of a MemFS holding /blobs/droplet
+func testStatCacheClient(t *testing.T) (*Client, *Server) {
+	cr, sw, _ := os.Pipe()
+	sr, cw, _ := os.Pipe()
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, "/", ServeFS(NewMemFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw, StatCache(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Mkdir("/blobs"); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := client.Create("/blobs/droplet"); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Write([]byte("droplet"))
+		f.Close()
+	}
+	return client, svr
+}
+
+func TestStatCacheAnswersAgain(t *testing.T) {
+	client, svr := testStatCacheClient(t)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		if info, err := client.Stat("/blobs/droplet"); err != nil || info.Size() != 7 {
+			t.Fatalf("Stat(/blobs/droplet) = %v, %v", info, err)
+		}
+		if _, err := client.Lstat("/blobs/./droplet"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := svr.Stats()
+	if stats.Packets["SSH_FXP_STAT"] != 1 || stats.Packets["SSH_FXP_LSTAT"] != 1 {
+		t.Errorf("want 1 stat and 1 lstat, got %v", stats.Packets)
+	}
+}
+
+func TestStatCacheForgetsWrites(t *testing.T) {
+	client, svr := testStatCacheClient(t)
+	defer client.Close()
+
+	client.Stat("/blobs/droplet")
+	client.Stat("/blobs")
+	f, err := client.OpenFile("/blobs/droplet", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("-v2"))
+	f.Close()
+	if info, err := client.Stat("/blobs/droplet"); err != nil || info.Size() != 10 {
+		t.Errorf("want the size written, got %v, %v", info, err)
+	}
+	client.Stat("/blobs")
+	if stats := svr.Stats(); stats.Packets["SSH_FXP_STAT"] != 4 {
+		t.Errorf("want the file and its directory stat again, got %v", stats.Packets)
+	}
+}
+
+func TestStatCacheForgetsRenamedTrees(t *testing.T) {
+	client, _ := testStatCacheClient(t)
+	defer client.Close()
+
+	client.Stat("/blobs/droplet")
+	if err := client.Rename("/blobs", "/packages"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stat("/blobs/droplet"); !os.IsNotExist(err) {
+		t.Errorf("want the renamed file gone, got %v", err)
+	}
+	if _, err := client.Stat("/packages/droplet"); err != nil {
+		t.Error(err)
+	}
+	client.Remove("/packages/droplet")
+	if _, err := client.Stat("/packages/droplet"); !os.IsNotExist(err) {
+		t.Errorf("want the removed file gone, got %v", err)
+	}
+}
+
+func TestStatCacheForgetsStatsInFlightDuringWrites(t *testing.T) {
+	client, _ := testStatCacheClient(t)
+	defer client.Close()
+	written := false
+	// the write lands after the stat was answered and before it is remembered
+	client.stats.now = func() time.Time {
+		if !written {
+			written = true
+			f, err := client.OpenFile("/blobs/droplet", os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("-v2"))
+			f.Close()
+		}
+		return time.Now()
+	}
+
+	if info, err := client.Stat("/blobs/droplet"); err != nil || info.Size() != 7 {
+		t.Fatalf("Stat(/blobs/droplet) = %v, %v", info, err)
+	}
+	if info, err := client.Stat("/blobs/droplet"); err != nil || info.Size() != 10 {
+		t.Errorf("want the size written, got %v, %v", info, err)
+	}
+}
+
+func TestStatCacheExpires(t *testing.T) {
+	client, svr := testStatCacheClient(t)
+	defer client.Close()
+	now := time.Now()
+	client.stats.now = func() time.Time { return now }
+
+	client.Stat("/blobs/droplet")
+	now = now.Add(59 * time.Second)
+	client.Stat("/blobs/droplet")
+	now = now.Add(time.Second)
+	client.Stat("/blobs/droplet")
+	if stats := svr.Stats(); stats.Packets["SSH_FXP_STAT"] != 2 {
+		t.Errorf("want the file stat again once the ttl passed, got %v", stats.Packets)
+	}
+}
+
+func TestStatCacheNeedsTTL(t *testing.T) {
+	cr, cw, _ := os.Pipe()
+	defer cr.Close()
+	if _, err := NewClientPipe(cr, cw, StatCache(0)); err == nil {
+		t.Error("want a stat cache without ttl refused")
+	}
+}
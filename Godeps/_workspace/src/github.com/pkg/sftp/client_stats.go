@@ -0,0 +1,75 @@
+package sftp
+
+// counters of the traffic of clients
+
+import "sync/atomic"
+
+// ClientStats are the counters of a client since it connected
+type ClientStats struct {
+	// Packets counts the packets sent to the server by type, e.g.
+	// SSH_FXP_WRITE
+	Packets map[string]uint64
+	// BytesSent and BytesReceived count every packet, lengths included
+	BytesSent     uint64
+	BytesReceived uint64
+	// Retransmits counts the writes sent again after the server failed them
+	Retransmits uint64
+}
+
+// Add returns the sum of the counters of both clients
+func (s ClientStats) Add(other ClientStats) ClientStats {
+	sum := ClientStats{
+		Packets:       make(map[string]uint64, len(s.Packets)),
+		BytesSent:     s.BytesSent + other.BytesSent,
+		BytesReceived: s.BytesReceived + other.BytesReceived,
+		Retransmits:   s.Retransmits + other.Retransmits,
+	}
+	for _, packets := range []map[string]uint64{s.Packets, other.Packets} {
+		for name, count := range packets {
+			sum.Packets[name] += count
+		}
+	}
+	return sum
+}
+
+// Stats returns a snapshot of the counters of the client
+func (c *Client) Stats() ClientStats {
+	stats := ClientStats{
+		Packets:       make(map[string]uint64),
+		BytesSent:     atomic.LoadUint64(&c.counters.bytesSent),
+		BytesReceived: atomic.LoadUint64(&c.counters.bytesReceived),
+		Retransmits:   atomic.LoadUint64(&c.counters.retransmits),
+	}
+	for typ := range c.counters.packets {
+		if count := atomic.LoadUint64(&c.counters.packets[typ]); count > 0 {
+			stats.Packets[fxp(typ).String()] = count
+		}
+	}
+	return stats
+}
+
+// clientCounters are updated atomically by every goroutine of a client
+type clientCounters struct {
+	bytesSent     uint64
+	bytesReceived uint64
+	retransmits   uint64
+	packets       [256]uint64 // by packet type
+}
+
+// sent counts the packet bb, nil when marshalling it failed
+func (s *clientCounters) sent(bb []byte) {
+	if len(bb) == 0 {
+		return
+	}
+	atomic.AddUint64(&s.packets[bb[0]], 1)
+	atomic.AddUint64(&s.bytesSent, uint64(len(bb)+4))
+}
+
+// received counts a packet of the data after its type
+func (s *clientCounters) received(data []byte) {
+	atomic.AddUint64(&s.bytesReceived, uint64(len(data)+5))
+}
+
+func (s *clientCounters) retransmitted() {
+	atomic.AddUint64(&s.retransmits, 1)
+}
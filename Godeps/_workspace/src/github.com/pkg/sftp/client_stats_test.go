@@ -0,0 +1,53 @@
+package sftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestClientStats(t *testing.T) {
+	cr, sw, _ := os.Pipe()
+	sr, cw, _ := os.Pipe()
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, "/", ServeFS(NewMemFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	contents := bytes.Repeat([]byte("uaadb"), 1<<14)
+	f, err := client.Create("/uaadb.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(contents)
+	f.Close()
+
+	stats := client.Stats()
+	if stats.Packets["SSH_FXP_INIT"] != 1 || stats.Packets["SSH_FXP_OPEN"] != 1 || stats.Packets["SSH_FXP_WRITE"] != 3 {
+		t.Errorf("want the init, open and 3 writes counted, got %v", stats.Packets)
+	}
+	if stats.BytesSent <= uint64(len(contents)) || stats.BytesReceived == 0 {
+		t.Errorf("want every byte counted, got %d sent and %d received", stats.BytesSent, stats.BytesReceived)
+	}
+	if received := svr.Stats().BytesReceived; received != stats.BytesSent {
+		t.Errorf("want the server to have received %d bytes, got %d", stats.BytesSent, received)
+	}
+	if stats.Retransmits != 0 {
+		t.Errorf("want no retransmits, got %d", stats.Retransmits)
+	}
+}
+
+func TestClientStatsAdd(t *testing.T) {
+	sum := ClientStats{Packets: map[string]uint64{"SSH_FXP_WRITE": 2}, BytesSent: 10, Retransmits: 1}.
+		Add(ClientStats{Packets: map[string]uint64{"SSH_FXP_WRITE": 1, "SSH_FXP_OPEN": 1}, BytesReceived: 4})
+	if sum.Packets["SSH_FXP_WRITE"] != 3 || sum.Packets["SSH_FXP_OPEN"] != 1 || sum.BytesSent != 10 || sum.BytesReceived != 4 || sum.Retransmits != 1 {
+		t.Errorf("want the counters summed, got %+v", sum)
+	}
+}
@@ -0,0 +1,151 @@
+package sftp
+
+// writing files in concurrent chunks, retrying those the server fails
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// WriteRetries has the client try each chunk of a write up to attempts
+// times while the server answers it with a generic failure, waiting backoff
+// before the second attempt and twice as long before each one after that.
+// Other errors are not retried. Without it every chunk is tried once.
+func WriteRetries(attempts int, backoff time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if attempts < 1 {
+			return fmt.Errorf("write attempts must be at least 1")
+		}
+		if backoff < 0 {
+			return fmt.Errorf("write retry backoff must not be negative")
+		}
+		c.writeAttempts = attempts
+		c.writeBackoff = backoff
+		return nil
+	}
+}
+
+// WriteError is the error of a write some chunk of which failed. The bytes
+// before Offset were written and the Length bytes at Offset, the lowest
+// chunk that failed, were not; those after it may or may not have been, so a
+// write resumes from Offset. errors.Is(err, os.ErrPermission) holds when the
+// server refused the chunk for its permissions, and so on for the other errors
+// of package os.
+type WriteError struct {
+	Path     string
+	Offset   int64
+	Length   int
+	Attempts int   // how many times the chunk at Offset was tried
+	Err      error // why its last attempt failed
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("sftp: writing %d bytes at %d of %s failed after %d attempts: %v", e.Length, e.Offset, e.Path, e.Attempts, e.Err)
+}
+
+func (e *WriteError) Unwrap() error { return e.Err }
+
+type writeChunk struct {
+	offset   uint64
+	data     []byte
+	attempts int
+}
+
+// retryable reports whether the server failed a write for no given reason,
+// as it does for errors that may not happen again
+func retryable(err error) bool {
+	status, ok := err.(*StatusError)
+	return ok && status.Code == ssh_FX_FAILURE
+}
+
+// writeChunks writes the chunks next returns to the file from off on, with
+// up to maxConcurrentRequests of them outstanding at once, until next
+// returns an error. This allows writes with a suitably large buffer to
+// transfer data at a much faster rate due to overlapping round trip times.
+// It returns the number of bytes from off on that were all written, and a
+// *WriteError for the lowest chunk that failed or else the error of next
+// other than io.EOF.
+func (f *File) writeChunks(off uint64, next func() ([]byte, error)) (int64, error) {
+	ch := make(chan result, maxConcurrentRequests)
+	pending := make(map[uint32]*writeChunk) // outstanding chunks by request id
+	inFlight := 0
+	desiredInFlight := 1
+	offset := off
+	var nextErr, sessionErr error
+	var failed *WriteError
+	fail := func(c *writeChunk, err error) {
+		if failed == nil || int64(c.offset) < failed.Offset {
+			failed = &WriteError{Path: f.path, Offset: int64(c.offset), Length: len(c.data), Attempts: c.attempts, Err: err}
+		}
+	}
+	send := func(c *writeChunk) {
+		id := f.c.nextId()
+		pending[id] = c
+		inFlight++
+		c.attempts++
+		p := sshFxpWritePacket{
+			Id:     id,
+			Handle: f.handle,
+			Offset: c.offset,
+			Length: uint32(len(c.data)),
+			Data:   c.data,
+		}
+		if c.attempts == 1 {
+			f.c.dispatchRequest(ch, p)
+			return
+		}
+		f.c.counters.retransmitted()
+		time.AfterFunc(f.c.writeBackoff<<uint(c.attempts-2), func() { f.c.dispatchRequest(ch, p) })
+	}
+	for {
+		for inFlight < desiredInFlight && nextErr == nil && sessionErr == nil && failed == nil {
+			data, err := next()
+			if len(data) > 0 {
+				send(&writeChunk{offset: offset, data: data})
+				offset += uint64(len(data))
+			}
+			nextErr = err
+		}
+		if inFlight == 0 {
+			break
+		}
+		res := <-ch
+		inFlight--
+		if res.err != nil {
+			// the session ended, the chunk of the request stays pending
+			sessionErr = res.err
+			continue
+		}
+		id, _ := unmarshalUint32(res.data)
+		c := pending[id]
+		delete(pending, id)
+		var err error
+		switch res.typ {
+		case ssh_FXP_STATUS:
+			err = okOrErr(unmarshalStatus(id, res.data))
+		default:
+			err = unimplementedPacketErr(res.typ)
+		}
+		switch {
+		case err == nil:
+			if desiredInFlight < maxConcurrentRequests {
+				desiredInFlight++
+			}
+		case retryable(err) && c.attempts < f.c.writeAttempts && sessionErr == nil:
+			send(c)
+		default:
+			fail(c, err)
+		}
+	}
+	for _, c := range pending {
+		fail(c, sessionErr)
+	}
+	if failed != nil {
+		return failed.Offset - int64(off), failed
+	}
+	if nextErr == io.EOF {
+		nextErr = nil
+	}
+	return int64(offset - off), nextErr
+}
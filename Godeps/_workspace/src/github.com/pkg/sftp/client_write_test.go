@@ -0,0 +1,152 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyFS is a MemFS failing the first writes at an offset
+type flakyFS struct {
+	*MemFS
+	offset int64
+
+	mu       sync.Mutex
+	failures int // writes at offset still to fail
+	writes   int // writes at offset tried
+}
+
+func (fs *flakyFS) OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error) {
+	f, err := fs.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return flakyFile{f, fs}, nil
+}
+
+type flakyFile struct {
+	ServerFile
+	fs *flakyFS
+}
+
+func (f flakyFile) WriteAt(b []byte, off int64) (int, error) {
+	if off == f.fs.offset {
+		f.fs.mu.Lock()
+		f.fs.writes++
+		fail := f.fs.failures > 0
+		if fail {
+			f.fs.failures--
+		}
+		f.fs.mu.Unlock()
+		if fail {
+			return 0, errors.New("disk hiccup")
+		}
+	}
+	return f.ServerFile.WriteAt(b, off)
+}
+
+// testFlakyClient connects a client with the options to an in-process
+// server of fs
+func testFlakyClient(t *testing.T, fs *flakyFS, options ...func(*Client) error) *Client {
+	cr, sw, _ := os.Pipe()
+	sr, cw, _ := os.Pipe()
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, "/", ServeFS(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestWriteRetriesFailedChunk(t *testing.T) {
+	fs := &flakyFS{MemFS: NewMemFS(), offset: 1 << 16, failures: 2}
+	client := testFlakyClient(t, fs, WriteRetries(3, time.Millisecond))
+	defer client.Close()
+
+	contents := bytes.Repeat([]byte("nfs_server"), 1<<14)
+	f, err := client.Create("/nfs_server.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write(contents); err != nil || n != len(contents) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	f.Close()
+	if fs.writes != 3 {
+		t.Errorf("want the failed chunk written on the third attempt, got %d attempts", fs.writes)
+	}
+	if retransmits := client.Stats().Retransmits; retransmits != 2 {
+		t.Errorf("want 2 retransmits counted, got %d", retransmits)
+	}
+	r, _ := client.Open("/nfs_server.backup")
+	defer r.Close()
+	if got, _ := ioutil.ReadAll(r); !bytes.Equal(got, contents) {
+		t.Errorf("want %d bytes written back, got %d", len(contents), len(got))
+	}
+}
+
+func TestWriteErrorRecordsFailedRange(t *testing.T) {
+	fs := &flakyFS{MemFS: NewMemFS(), offset: 1 << 16, failures: 3}
+	client := testFlakyClient(t, fs, WriteRetries(3, time.Millisecond))
+	defer client.Close()
+
+	contents := bytes.Repeat([]byte("ccdb"), 1<<16)
+	f, err := client.Create("/ccdb.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, err := f.ReadFrom(bytes.NewReader(contents))
+	werr, ok := err.(*WriteError)
+	if !ok {
+		t.Fatalf("want a *WriteError, got %v", err)
+	}
+	if n != 1<<16 || werr.Offset != 1<<16 || werr.Length != client.maxPacket || werr.Attempts != 3 {
+		t.Errorf("want the chunk at %d failed after 3 attempts, got %d, %+v", 1<<16, n, werr)
+	}
+	if _, ok := werr.Err.(*StatusError); !ok {
+		t.Errorf("want the status of the last attempt, got %v", werr.Err)
+	}
+
+	// resuming from the failed offset completes the file
+	if _, err := f.WriteAt(contents[werr.Offset:], werr.Offset); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := f.Stat(); info.Size() != int64(len(contents)) {
+		t.Errorf("want the resumed file complete, got %d bytes", info.Size())
+	}
+}
+
+func TestWriteWithoutRetries(t *testing.T) {
+	fs := &flakyFS{MemFS: NewMemFS(), offset: 0, failures: 1}
+	client := testFlakyClient(t, fs)
+	defer client.Close()
+
+	f, err := client.Create("/uaadb.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := f.Write([]byte("uaadb")); n != 0 || err == nil {
+		t.Errorf("want the write failed, got %d, %v", n, err)
+	}
+	if fs.writes != 1 {
+		t.Errorf("want a single attempt, got %d", fs.writes)
+	}
+}
+
+func TestWriteRetriesNeedAttempts(t *testing.T) {
+	cr, cw, _ := os.Pipe()
+	defer cr.Close()
+	if _, err := NewClientPipe(cr, cw, WriteRetries(0, time.Second)); err == nil {
+		t.Error("want a write without attempts refused")
+	}
+}
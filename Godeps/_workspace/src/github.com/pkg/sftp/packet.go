@@ -9,9 +9,14 @@ import (
 )
 
 var (
-	shortPacketError = fmt.Errorf("packet too short")
+	shortPacketError    = fmt.Errorf("packet too short")
+	longPacketError     = fmt.Errorf("packet too long")
+	trailingPacketError = fmt.Errorf("packet has trailing bytes")
 )
 
+// maxPacketLength is the longest packet received, as openssh limits it
+const maxPacketLength = 256 * 1024
+
 const (
 	debugDumpTxPacket      = false
 	debugDumpRxPacket      = false
@@ -111,9 +116,16 @@ func unmarshalStringSafe(b []byte) (string, []byte, error) {
 
 // sendPacket marshals p according to RFC 4234.
 func sendPacket(w io.Writer, m encoding.BinaryMarshaler) error {
+	_, err := writePacket(w, m)
+	return err
+}
+
+// writePacket sends m as sendPacket does, returning the packet m was
+// marshalled into, without its length
+func writePacket(w io.Writer, m encoding.BinaryMarshaler) ([]byte, error) {
 	bb, err := m.MarshalBinary()
 	if err != nil {
-		return fmt.Errorf("marshal2(%#v): binary marshaller failed", err)
+		return nil, fmt.Errorf("marshal2(%#v): binary marshaller failed", err)
 	}
 	if debugDumpTxPacketBytes {
 		debug("send packet: %s %d bytes %x", fxp(bb[0]), len(bb), bb[1:])
@@ -124,17 +136,22 @@ func sendPacket(w io.Writer, m encoding.BinaryMarshaler) error {
 	hdr := []byte{byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)}
 	_, err = w.Write(hdr)
 	if err != nil {
-		return err
+		return bb, err
 	}
 	_, err = w.Write(bb)
-	return err
+	return bb, err
 }
 
 func (svr *Server) sendPacket(m encoding.BinaryMarshaler) error {
 	// any responder can call sendPacket(); actual socket access must be serialized
+	if status, ok := m.(sshFxpStatusPacket); ok {
+		svr.stats.responded(status)
+	}
 	svr.outMutex.Lock()
 	defer svr.outMutex.Unlock()
-	return sendPacket(svr.out, m)
+	bb, err := writePacket(svr.out, m)
+	svr.stats.wire(len(bb)+4, 0)
+	return err
 }
 
 func recvPacket(r io.Reader) (uint8, []byte, error) {
@@ -143,6 +160,11 @@ func recvPacket(r io.Reader) (uint8, []byte, error) {
 		return 0, nil, err
 	}
 	l, _ := unmarshalUint32(b)
+	if l == 0 {
+		return 0, nil, shortPacketError
+	} else if l > maxPacketLength {
+		return 0, nil, longPacketError
+	}
 	b = make([]byte, l)
 	if _, err := io.ReadFull(r, b); err != nil {
 		debug("recv packet %d bytes: err %v", l, err)
@@ -260,9 +282,76 @@ func unmarshalIdString(b []byte, id *uint32, str *string) error {
 	if err != nil {
 		return err
 	}
+	return noTrailing(b)
+}
+
+// noTrailing rejects the bytes left after the last field of a packet
+func noTrailing(b []byte) error {
+	if len(b) > 0 {
+		return trailingPacketError
+	}
 	return nil
 }
 
+// validateAttrs checks that the attributes are exactly those the flags name
+func validateAttrs(flags uint32, b []byte) (err error) {
+	if flags&ssh_FILEXFER_ATTR_SIZE != 0 {
+		if _, b, err = unmarshalUint64Safe(b); err != nil {
+			return
+		}
+	}
+	if flags&ssh_FILEXFER_ATTR_UIDGID != 0 {
+		if _, b, err = unmarshalUint64Safe(b); err != nil {
+			return
+		}
+	}
+	if flags&ssh_FILEXFER_ATTR_PERMISSIONS != 0 {
+		if _, b, err = unmarshalUint32Safe(b); err != nil {
+			return
+		}
+	}
+	if flags&ssh_FILEXFER_ATTR_ACMODTIME != 0 {
+		if _, b, err = unmarshalUint64Safe(b); err != nil {
+			return
+		}
+	}
+	if flags&ssh_FILEXFER_ATTR_EXTENDED != 0 {
+		var count uint32
+		if count, b, err = unmarshalUint32Safe(b); err != nil {
+			return
+		}
+		for i := uint32(0); i < count; i++ {
+			if _, b, err = unmarshalExtensionPair(b); err != nil {
+				return
+			}
+		}
+	}
+	return noTrailing(b)
+}
+
+// marshalPermAttrs appends the attributes of a request creating a file or a
+// directory, which carry at most its permissions
+func marshalPermAttrs(b []byte, flags, perm uint32) []byte {
+	flags &= ssh_FILEXFER_ATTR_PERMISSIONS
+	b = marshalUint32(b, flags)
+	if flags != 0 {
+		b = marshalUint32(b, perm)
+	}
+	return b
+}
+
+// unmarshalPermAttrs reads the attributes ending a request creating a file
+// or a directory, keeping only its permissions
+func unmarshalPermAttrs(b []byte) (flags, perm uint32, err error) {
+	if flags, b, err = unmarshalUint32Safe(b); err != nil {
+		return
+	} else if err = validateAttrs(flags, b); err != nil {
+		return
+	}
+	attrs, _ := unmarshalAttrs(append(marshalUint32(nil, flags), b...))
+	return flags & ssh_FILEXFER_ATTR_PERMISSIONS, attrs.Mode, nil
+}
+
 type sshFxpReaddirPacket struct {
 	Id     uint32
 	Handle string
@@ -316,7 +405,7 @@ type sshFxpStatPacket struct {
 func (p sshFxpStatPacket) id() uint32 { return p.Id }
 
 func (p sshFxpStatPacket) MarshalBinary() ([]byte, error) {
-	return marshalIdString(ssh_FXP_LSTAT, p.Id, p.Path)
+	return marshalIdString(ssh_FXP_STAT, p.Id, p.Path)
 }
 
 func (p *sshFxpStatPacket) UnmarshalBinary(b []byte) error {
@@ -413,7 +502,7 @@ func (p *sshFxpSymlinkPacket) UnmarshalBinary(b []byte) error {
 	} else if p.Linkpath, b, err = unmarshalStringSafe(b); err != nil {
 		return err
 	}
-	return nil
+	return noTrailing(b)
 }
 
 type sshFxpReadlinkPacket struct {
@@ -439,7 +528,7 @@ type sshFxpRealpathPacket struct {
 func (p sshFxpRealpathPacket) id() uint32 { return p.Id }
 
 func (p sshFxpRealpathPacket) MarshalBinary() ([]byte, error) {
-	return marshalIdString(ssh_FXP_READLINK, p.Id, p.Path)
+	return marshalIdString(ssh_FXP_REALPATH, p.Id, p.Path)
 }
 
 func (p *sshFxpRealpathPacket) UnmarshalBinary(b []byte) error {
@@ -486,7 +575,8 @@ type sshFxpOpenPacket struct {
 	Id     uint32
 	Path   string
 	Pflags uint32
-	Flags  uint32 // ignored
+	Flags  uint32 // of the attributes, only the permissions are kept
+	Perm   uint32 // present when Flags has ssh_FILEXFER_ATTR_PERMISSIONS
 }
 
 func (p sshFxpOpenPacket) id() uint32 { return p.Id }
@@ -494,14 +584,14 @@ func (p sshFxpOpenPacket) id() uint32 { return p.Id }
 func (p sshFxpOpenPacket) MarshalBinary() ([]byte, error) {
 	l := 1 + 4 +
 		4 + len(p.Path) +
-		4 + 4
+		4 + 4 + 4
 
 	b := make([]byte, 0, l)
 	b = append(b, ssh_FXP_OPEN)
 	b = marshalUint32(b, p.Id)
 	b = marshalString(b, p.Path)
 	b = marshalUint32(b, p.Pflags)
-	b = marshalUint32(b, p.Flags)
+	b = marshalPermAttrs(b, p.Flags, p.Perm)
 	return b, nil
 }
 
@@ -512,9 +602,8 @@ func (p *sshFxpOpenPacket) UnmarshalBinary(b []byte) (err error) {
 		return
 	} else if p.Pflags, b, err = unmarshalUint32Safe(b); err != nil {
 		return
-	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
-		return
 	}
+	p.Flags, p.Perm, err = unmarshalPermAttrs(b)
 	return
 }
 
@@ -551,7 +640,7 @@ func (p *sshFxpReadPacket) UnmarshalBinary(b []byte) (err error) {
 	} else if p.Len, b, err = unmarshalUint32Safe(b); err != nil {
 		return
 	}
-	return
+	return noTrailing(b)
 }
 
 type sshFxpRenamePacket struct {
@@ -583,7 +672,7 @@ func (p *sshFxpRenamePacket) UnmarshalBinary(b []byte) (err error) {
 	} else if p.Newpath, b, err = unmarshalStringSafe(b); err != nil {
 		return
 	}
-	return
+	return noTrailing(b)
 }
 
 type sshFxpWritePacket struct {
@@ -624,8 +713,11 @@ func (p *sshFxpWritePacket) UnmarshalBinary(b []byte) (err error) {
 	} else if uint32(len(b)) < p.Length {
 		err = shortPacketError
 		return
+	} else if uint32(len(b)) > p.Length {
+		err = trailingPacketError
+		return
 	} else {
-		p.Data = append([]byte{}, b[:p.Length]...)
+		p.Data = append([]byte{}, b...)
 	}
 	return
 }
@@ -633,7 +725,8 @@ func (p *sshFxpWritePacket) UnmarshalBinary(b []byte) (err error) {
 type sshFxpMkdirPacket struct {
 	Id    uint32
 	Path  string
-	Flags uint32 // ignored
+	Flags uint32 // of the attributes, only the permissions are kept
+	Perm  uint32 // present when Flags has ssh_FILEXFER_ATTR_PERMISSIONS
 }
 
 func (p sshFxpMkdirPacket) id() uint32 { return p.Id }
@@ -641,13 +734,13 @@ func (p sshFxpMkdirPacket) id() uint32 { return p.Id }
 func (p sshFxpMkdirPacket) MarshalBinary() ([]byte, error) {
 	l := 1 + 4 + // type(byte) + uint32
 		4 + len(p.Path) +
-		4 // uint32
+		4 + 4 // uint32 + uint32
 
 	b := make([]byte, 0, l)
 	b = append(b, ssh_FXP_MKDIR)
 	b = marshalUint32(b, p.Id)
 	b = marshalString(b, p.Path)
-	b = marshalUint32(b, p.Flags)
+	b = marshalPermAttrs(b, p.Flags, p.Perm)
 	return b, nil
 }
 
@@ -656,10 +749,9 @@ func (p *sshFxpMkdirPacket) UnmarshalBinary(b []byte) (err error) {
 		return err
 	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
 		return err
-	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
-		return err
 	}
-	return nil
+	p.Flags, p.Perm, err = unmarshalPermAttrs(b)
+	return
 }
 
 type sshFxpSetstatPacket struct {
@@ -715,6 +807,8 @@ func (p *sshFxpSetstatPacket) UnmarshalBinary(b []byte) error {
 		return err
 	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
 		return err
+	} else if err = validateAttrs(p.Flags, b); err != nil {
+		return err
 	}
 	p.Attrs = b
 	return nil
@@ -728,6 +822,8 @@ func (p *sshFxpFsetstatPacket) UnmarshalBinary(b []byte) error {
 		return err
 	} else if p.Flags, b, err = unmarshalUint32Safe(b); err != nil {
 		return err
+	} else if err = validateAttrs(p.Flags, b); err != nil {
+		return err
 	}
 	p.Attrs = b
 	return nil
@@ -778,6 +874,8 @@ func (p *sshFxpDataPacket) UnmarshalBinary(b []byte) (err error) {
 		return err
 	} else if uint32(len(b)) < p.Length {
 		return fmt.Errorf("truncated packet")
+	} else if uint32(len(b)) > p.Length {
+		return trailingPacketError
 	} else {
 		p.Data = make([]byte, p.Length)
 		copy(p.Data, b)
@@ -805,6 +903,33 @@ func (p sshFxpStatvfsPacket) MarshalBinary() ([]byte, error) {
 	return b, nil
 }
 
+// sshFxpExtendedPacket is an extended request as the server receives it,
+// the request specific data following its name
+type sshFxpExtendedPacket struct {
+	Id              uint32
+	ExtendedRequest string
+	Data            []byte
+}
+
+func (p sshFxpExtendedPacket) id() uint32 { return p.Id }
+
+func (p *sshFxpExtendedPacket) UnmarshalBinary(b []byte) error {
+	var err error = nil
+	if p.Id, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest == "statvfs@openssh.com" {
+		if _, rest, err := unmarshalStringSafe(b); err != nil {
+			return err
+		} else if err = noTrailing(rest); err != nil {
+			return err
+		}
+	}
+	p.Data = b
+	return nil
+}
+
 type StatVFS struct {
 	Id      uint32
 	Bsize   uint64 /* file system block size */
@@ -820,6 +945,16 @@ type StatVFS struct {
 	Namemax uint64 /* maximum filename length */
 }
 
+func (p *StatVFS) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 1+4+11*8)
+	b = append(b, ssh_FXP_EXTENDED_REPLY)
+	b = marshalUint32(b, p.Id)
+	for _, v := range []uint64{p.Bsize, p.Frsize, p.Blocks, p.Bfree, p.Bavail, p.Files, p.Ffree, p.Favail, p.Fsid, p.Flag, p.Namemax} {
+		b = marshalUint64(b, v)
+	}
+	return b, nil
+}
+
 func (p *StatVFS) TotalSpace() uint64 {
 	return p.Frsize * p.Blocks
 }
@@ -0,0 +1,81 @@
+// +build go1.18
+
+package sftp
+
+import (
+	"bytes"
+	"encoding"
+	"io/ioutil"
+	"testing"
+)
+
+// fuzzSeeds are well formed requests of every type the server decodes
+var fuzzSeeds = []encoding.BinaryMarshaler{
+	sshFxInitPacket{Version: 3, Extensions: []ExtensionPair{{"posix-rename@openssh.com", "1"}}},
+	sshFxpOpenPacket{1, "/artifact", ssh_FXF_READ | ssh_FXF_WRITE, ssh_FILEXFER_ATTR_PERMISSIONS, 0600},
+	sshFxpReadPacket{2, "1", 1024, 32768},
+	sshFxpWritePacket{3, "1", 0, 5, []byte("hello")},
+	sshFxpClosePacket{4, "1"},
+	sshFxpStatPacket{5, "/artifact"},
+	sshFxpFstatPacket{6, "1"},
+	sshFxpSetstatPacket{7, "/artifact", ssh_FILEXFER_ATTR_PERMISSIONS, uint32(0644)},
+	sshFxpFsetstatPacket{8, "1", ssh_FILEXFER_ATTR_ACMODTIME, struct{ Atime, Mtime uint32 }{1, 2}},
+	sshFxpOpendirPacket{9, "/"},
+	sshFxpReaddirPacket{10, "2"},
+	sshFxpRemovePacket{11, "/artifact"},
+	sshFxpMkdirPacket{12, "/dir", 0, 0},
+	sshFxpRmdirPacket{13, "/dir"},
+	sshFxpRealpathPacket{14, "."},
+	sshFxpRenamePacket{15, "/a", "/b"},
+	sshFxpReadlinkPacket{16, "/link"},
+	sshFxpSymlinkPacket{17, "/target", "/link"},
+	sshFxpStatvfsPacket{18, "/"},
+}
+
+func FuzzDecodePacket(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		b, _ := seed.MarshalBinary()
+		f.Add(b)
+	}
+	svr, err := NewServer(bytes.NewReader(nil), nil, ioutil.Discard, 0, false, "/")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if len(b) == 0 {
+			return
+		}
+		pkt, err := svr.decodePacket(fxp(b[0]), b[1:])
+		if err != nil {
+			return
+		}
+		// whatever decodes is exactly what marshals back to the same bytes
+		if m, ok := pkt.(encoding.BinaryMarshaler); ok {
+			if again, err := m.MarshalBinary(); err != nil || !bytes.Equal(again, b) {
+				t.Errorf("%T decoded from %x marshals to %x (%v)", pkt, b, again, err)
+			}
+		}
+	})
+}
+
+func FuzzRecvPacket(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		b, _ := seed.MarshalBinary()
+		f.Add(append(marshalUint32(nil, uint32(len(b))), b...))
+	}
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 1})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		recvPacket(bytes.NewReader(b))
+	})
+}
+
+func TestUnmarshalTrailingBytes(t *testing.T) {
+	for _, seed := range fuzzSeeds {
+		b, _ := seed.MarshalBinary()
+		b = append(b, 0)
+		if _, err := (&Server{}).decodePacket(fxp(b[0]), b[1:]); err == nil {
+			t.Errorf("%T with a trailing byte was decoded", seed)
+		}
+	}
+}
@@ -29,21 +29,34 @@ type Server struct {
 	debugLevel    int
 	readOnly      bool
 	rootDir       string
+	confined      bool
+	user          string
+	authorize     AuthorizeFunc
+	limiter       *Limiter
+	outstanding   chan struct{}
+	stats         serverStats
+	fs            ServerFS
 	lastId        uint32
 	pktChan       chan rxPacket
-	openFiles     map[string]*os.File
+	handlePkts    []chan rxPacket
+	workers       sync.WaitGroup
+	openFiles     map[string]ServerFile
+	handleGrants  map[string]handleGrant
 	openFilesLock *sync.RWMutex
 	handleCount   int
 	maxTxPacket   uint32
 	workerCount   int
+	renamePolicy  RenamePolicy
+	umask         os.FileMode
 }
 
-func (svr *Server) nextHandle(f *os.File) string {
+func (svr *Server) nextHandle(f ServerFile, grant handleGrant) string {
 	svr.openFilesLock.Lock()
 	defer svr.openFilesLock.Unlock()
 	svr.handleCount++
 	handle := fmt.Sprintf("%d", svr.handleCount)
 	svr.openFiles[handle] = f
+	svr.handleGrants[handle] = grant
 	return handle
 }
 
@@ -52,13 +65,14 @@ func (svr *Server) closeHandle(handle string) error {
 	defer svr.openFilesLock.Unlock()
 	if f, ok := svr.openFiles[handle]; ok {
 		delete(svr.openFiles, handle)
+		delete(svr.handleGrants, handle)
 		return f.Close()
 	} else {
 		return syscall.EBADF
 	}
 }
 
-func (svr *Server) getHandle(handle string) (*os.File, bool) {
+func (svr *Server) getHandle(handle string) (ServerFile, bool) {
 	svr.openFilesLock.RLock()
 	defer svr.openFilesLock.RUnlock()
 	f, ok := svr.openFiles[handle]
@@ -72,8 +86,9 @@ type serverRespondablePacket interface {
 
 // Creates a new server instance around the provided streams.
 // Various debug output will be written to debugStream, with verbosity set by debugLevel
+// Options such as ConfineToRoot are applied in order.
 // A subsequent call to Serve() is required.
-func NewServer(in io.Reader, out io.WriteCloser, debugStream io.Writer, debugLevel int, readOnly bool, rootDir string) (*Server, error) {
+func NewServer(in io.Reader, out io.WriteCloser, debugStream io.Writer, debugLevel int, readOnly bool, rootDir string, options ...func(*Server) error) (*Server, error) {
 	if rootDir == "" {
 		if wd, err := os.Getwd(); err != nil {
 			return nil, err
@@ -81,7 +96,7 @@ func NewServer(in io.Reader, out io.WriteCloser, debugStream io.Writer, debugLev
 			rootDir = wd
 		}
 	}
-	return &Server{
+	svr := &Server{
 		in:            in,
 		out:           out,
 		outMutex:      &sync.Mutex{},
@@ -89,25 +104,40 @@ func NewServer(in io.Reader, out io.WriteCloser, debugStream io.Writer, debugLev
 		debugLevel:    debugLevel,
 		readOnly:      readOnly,
 		rootDir:       rootDir,
-		pktChan:       make(chan rxPacket, sftpServerWorkerCount),
-		openFiles:     map[string]*os.File{},
+		openFiles:     map[string]ServerFile{},
+		handleGrants:  map[string]handleGrant{},
 		openFilesLock: &sync.RWMutex{},
 		maxTxPacket:   1 << 15,
 		workerCount:   sftpServerWorkerCount,
-	}, nil
+		fs:            osFS{},
+	}
+	for _, option := range options {
+		if err := option(svr); err != nil {
+			return nil, err
+		}
+	}
+	if err := svr.resolveRoot(); err != nil {
+		return nil, err
+	}
+	return svr, nil
 }
 
 type rxPacket struct {
-	pktType  fxp
-	pktBytes []byte
+	pktType fxp
+	pkt     serverRespondablePacket
 }
 
-// Unmarshal a single logical packet from the secure channel
+// Unmarshal a single logical packet from the secure channel, handing it to
+// the worker of its handle or else to any worker
 func (svr *Server) rxPackets() error {
-	defer close(svr.pktChan)
+	defer svr.closeWorkers()
 
 	for {
+		svr.acquireRequest()
 		pktType, pktBytes, err := recvPacket(svr.in)
+		if err == nil {
+			svr.stats.wire(0, len(pktBytes)+5)
+		}
 		if err == io.EOF {
 			fmt.Fprintf(svr.debugStream, "rxPackets loop done\n")
 			return nil
@@ -116,38 +146,55 @@ func (svr *Server) rxPackets() error {
 			return err
 		}
 
-		svr.pktChan <- rxPacket{fxp(pktType), pktBytes}
-	}
-}
-
-// Up to N parallel servers
-func (svr *Server) sftpServerWorker(doneChan chan error) {
-	for pkt := range svr.pktChan {
-		if pkt, err := svr.decodePacket(pkt.pktType, pkt.pktBytes); err != nil {
+		pkt, err := svr.decodePacket(fxp(pktType), pktBytes)
+		if err != nil {
+			// abort early and shut down the session on un-decodable packets
 			fmt.Fprintf(svr.debugStream, "decodePacket error: %v\n", err)
-			doneChan <- err
-			return
-		} else {
-			//fmt.Fprintf(svr.debugStream, "pkt: %T %v\n", pkt, pkt)
-			pkt.respond(svr)
+			return err
 		}
+		svr.dispatch(rxPacket{fxp(pktType), pkt})
+	}
+}
+
+// Up to N parallel servers, each also serving the packets of the handles
+// sharded to it, in the order they arrived
+func (svr *Server) sftpServerWorker(handlePkts chan rxPacket) {
+	pktChan := svr.pktChan
+	for pktChan != nil || handlePkts != nil {
+		var rx rxPacket
+		var ok bool
+		select {
+		case rx, ok = <-pktChan:
+			if !ok {
+				pktChan = nil
+				continue
+			}
+		case rx, ok = <-handlePkts:
+			if !ok {
+				handlePkts = nil
+				continue
+			}
+		}
+		//fmt.Fprintf(svr.debugStream, "pkt: %T %v\n", rx.pkt, rx.pkt)
+		id := svr.stats.begin(rx.pktType, rx.pkt)
+		if svr.authorized(rx.pkt) {
+			rx.pkt.respond(svr)
+		}
+		svr.stats.end(id)
+		svr.releaseRequest()
 	}
-	doneChan <- nil
 }
 
 // Run this server until the streams stop or until the subsystem is stopped
 func (svr *Server) Serve() error {
-	go svr.rxPackets()
-	doneChan := make(chan error)
-	for i := 0; i < svr.workerCount; i++ {
-		go svr.sftpServerWorker(doneChan)
-	}
-	for i := 0; i < svr.workerCount; i++ {
-		if err := <-doneChan; err != nil {
-			// abort early and shut down the session on un-decodable packets
-			break
-		}
-	}
+	if err := svr.limiter.acquireSession(); err != nil {
+		svr.out.Close()
+		return err
+	}
+	defer svr.limiter.releaseSession()
+	svr.startWorkers()
+	svr.rxPackets()
+	svr.workers.Wait()
 	fmt.Fprintf(svr.debugStream, "sftp server run finished\n")
 	// close any still-open files
 	for handle, file := range svr.openFiles {
@@ -199,6 +246,8 @@ func (svr *Server) decodePacket(pktType fxp, pktBytes []byte) (serverRespondable
 		pkt = &sshFxpReadlinkPacket{}
 	case ssh_FXP_SYMLINK:
 		pkt = &sshFxpSymlinkPacket{}
+	case ssh_FXP_EXTENDED:
+		pkt = &sshFxpExtendedPacket{}
 	default:
 		return nil, fmt.Errorf("unhandled packet type: %s", pktType.String())
 	}
@@ -229,7 +278,9 @@ func (p sshFxpStatResponse) MarshalBinary() ([]byte, error) {
 
 func (p sshFxpLstatPacket) respond(svr *Server) error {
 	// stat the requested file
-	if info, err := os.Lstat(p.Path); err != nil {
+	if path, err := svr.realPath(p.Path, false); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else if info, err := svr.fs.Lstat(path); err != nil {
 		return svr.sendPacket(statusFromError(p.Id, err))
 	} else {
 		return svr.sendPacket(sshFxpStatResponse{p.Id, info})
@@ -238,7 +289,9 @@ func (p sshFxpLstatPacket) respond(svr *Server) error {
 
 func (p sshFxpStatPacket) respond(svr *Server) error {
 	// stat the requested file
-	if info, err := os.Stat(p.Path); err != nil {
+	if path, err := svr.realPath(p.Path, true); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else if info, err := svr.fs.Stat(path); err != nil {
 		return svr.sendPacket(statusFromError(p.Id, err))
 	} else {
 		return svr.sendPacket(sshFxpStatResponse{p.Id, info})
@@ -259,8 +312,10 @@ func (p sshFxpMkdirPacket) respond(svr *Server) error {
 	if svr.readOnly {
 		return svr.sendPacket(statusFromError(p.Id, syscall.EPERM))
 	}
-	// TODO FIXME: ignore flags field
-	err := os.Mkdir(p.Path, 0755)
+	path, err := svr.realPath(p.Path, false)
+	if err == nil {
+		err = svr.fs.Mkdir(path, svr.createMode(p.Flags, p.Perm, 0755))
+	}
 	return svr.sendPacket(statusFromError(p.Id, err))
 }
 
@@ -268,7 +323,10 @@ func (p sshFxpRmdirPacket) respond(svr *Server) error {
 	if svr.readOnly {
 		return svr.sendPacket(statusFromError(p.Id, syscall.EPERM))
 	}
-	err := os.Remove(p.Path)
+	path, err := svr.realPath(p.Path, false)
+	if err == nil {
+		err = svr.fs.Remove(path)
+	}
 	return svr.sendPacket(statusFromError(p.Id, err))
 }
 
@@ -276,7 +334,10 @@ func (p sshFxpRemovePacket) respond(svr *Server) error {
 	if svr.readOnly {
 		return svr.sendPacket(statusFromError(p.Id, syscall.EPERM))
 	}
-	err := os.Remove(p.Filename)
+	path, err := svr.realPath(p.Filename, false)
+	if err == nil {
+		err = svr.fs.Remove(path)
+	}
 	return svr.sendPacket(statusFromError(p.Id, err))
 }
 
@@ -284,7 +345,13 @@ func (p sshFxpRenamePacket) respond(svr *Server) error {
 	if svr.readOnly {
 		return svr.sendPacket(statusFromError(p.Id, syscall.EPERM))
 	}
-	err := os.Rename(p.Oldpath, p.Newpath)
+	oldpath, err := svr.realPath(p.Oldpath, false)
+	if err == nil {
+		var newpath string
+		if newpath, err = svr.realPath(p.Newpath, false); err == nil {
+			err = svr.rename(oldpath, newpath)
+		}
+	}
 	return svr.sendPacket(statusFromError(p.Id, err))
 }
 
@@ -292,22 +359,34 @@ func (p sshFxpSymlinkPacket) respond(svr *Server) error {
 	if svr.readOnly {
 		return svr.sendPacket(statusFromError(p.Id, syscall.EPERM))
 	}
-	err := os.Symlink(p.Targetpath, p.Linkpath)
+	linkpath, err := svr.realPath(p.Linkpath, false)
+	if err == nil {
+		var targetpath string
+		if targetpath, err = svr.symlinkTarget(p.Targetpath, linkpath); err == nil {
+			err = svr.fs.Symlink(targetpath, linkpath)
+		}
+	}
 	return svr.sendPacket(statusFromError(p.Id, err))
 }
 
 var emptyFileStat = []interface{}{uint32(0)}
 
 func (p sshFxpReadlinkPacket) respond(svr *Server) error {
-	if f, err := os.Readlink(p.Path); err != nil {
+	if path, err := svr.realPath(p.Path, false); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else if f, err := svr.fs.Readlink(path); err != nil {
 		return svr.sendPacket(statusFromError(p.Id, err))
 	} else {
+		f = svr.clientPath(f)
 		return svr.sendPacket(sshFxpNamePacket{p.Id, []sshFxpNameAttr{sshFxpNameAttr{f, f, emptyFileStat}}})
 	}
 }
 
 func (p sshFxpRealpathPacket) respond(svr *Server) error {
-	if f, err := filepath.Abs(p.Path); err != nil {
+	if svr.confined {
+		f := filepath.Clean("/" + p.Path)
+		return svr.sendPacket(sshFxpNamePacket{p.Id, []sshFxpNameAttr{sshFxpNameAttr{f, f, emptyFileStat}}})
+	} else if f, err := filepath.Abs(p.Path); err != nil {
 		return svr.sendPacket(statusFromError(p.Id, err))
 	} else {
 		f = filepath.Clean(f)
@@ -315,11 +394,34 @@ func (p sshFxpRealpathPacket) respond(svr *Server) error {
 	}
 }
 
+func (p sshFxpExtendedPacket) respond(svr *Server) error {
+	if p.ExtendedRequest != "statvfs@openssh.com" {
+		return svr.sendPacket(statusFromError(p.Id, syscall.ENOTSUP))
+	}
+	if name, _, err := unmarshalStringSafe(p.Data); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else if path, err := svr.realPath(name, true); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else if stat, err := svr.fs.Statvfs(path); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else {
+		stat.Id = p.Id
+		return svr.sendPacket(stat)
+	}
+}
+
 func (p sshFxpOpendirPacket) respond(svr *Server) error {
-	return sshFxpOpenPacket{p.Id, p.Path, ssh_FXF_READ, 0}.respond(svr)
+	return sshFxpOpenPacket{Id: p.Id, Path: p.Path, Pflags: ssh_FXF_READ}.open(svr, OpList)
 }
 
 func (p sshFxpOpenPacket) respond(svr *Server) error {
+	operation, _ := p.authorization()
+	return p.open(svr, operation)
+}
+
+// open opens the file and records the operation it was authorized for on the
+// handle, which requests on the handle are then held to
+func (p sshFxpOpenPacket) open(svr *Server, operation string) error {
 	osFlags := 0
 	if p.Pflags&ssh_FXF_READ != 0 && p.Pflags&ssh_FXF_WRITE != 0 {
 		if svr.readOnly {
@@ -352,10 +454,12 @@ func (p sshFxpOpenPacket) respond(svr *Server) error {
 		osFlags |= os.O_EXCL
 	}
 
-	if f, err := os.OpenFile(p.Path, osFlags, 0644); err != nil {
+	if path, err := svr.realPath(p.Path, true); err != nil {
+		return svr.sendPacket(statusFromError(p.Id, err))
+	} else if f, err := svr.fs.OpenFile(path, osFlags, svr.createMode(p.Flags, p.Perm, 0644)); err != nil {
 		return svr.sendPacket(statusFromError(p.Id, err))
 	} else {
-		handle := svr.nextHandle(f)
+		handle := svr.nextHandle(f, handleGrant{operation, svr.authorizedPath(p.Path)})
 		return svr.sendPacket(sshFxpHandlePacket{p.Id, handle})
 	}
 }
@@ -375,6 +479,8 @@ func (p sshFxpReadPacket) respond(svr *Server) error {
 		if n, err := f.ReadAt(ret.Data, int64(p.Offset)); err != nil && (err != io.EOF || n == 0) {
 			return svr.sendPacket(statusFromError(p.Id, err))
 		} else {
+			svr.limiter.waitBandwidth(n)
+			svr.stats.transferred(p.Id, n, false)
 			ret.Length = uint32(n)
 			return svr.sendPacket(ret)
 		}
@@ -389,7 +495,9 @@ func (p sshFxpWritePacket) respond(svr *Server) error {
 	if f, ok := svr.getHandle(p.Handle); !ok {
 		return svr.sendPacket(statusFromError(p.Id, syscall.EBADF))
 	} else {
-		_, err := f.WriteAt(p.Data, int64(p.Offset))
+		svr.limiter.waitBandwidth(len(p.Data))
+		n, err := f.WriteAt(p.Data, int64(p.Offset))
+		svr.stats.transferred(p.Id, n, true)
 		return svr.sendPacket(statusFromError(p.Id, err))
 	}
 }
@@ -426,19 +534,22 @@ func (p sshFxpSetstatPacket) respond(svr *Server) error {
 	} else {
 		// additional unmarshalling is required for each possibility here
 		b := p.Attrs.([]byte)
-		var err error = nil
+		path, err := svr.realPath(p.Path, true)
+		if err != nil {
+			return svr.sendPacket(statusFromError(p.Id, err))
+		}
 
 		debug("setstat name \"%s\"", p.Path)
 		if (p.Flags & ssh_FILEXFER_ATTR_SIZE) != 0 {
 			var size uint64 = 0
 			if size, b, err = unmarshalUint64Safe(b); err == nil {
-				err = os.Truncate(p.Path, int64(size))
+				err = svr.fs.Truncate(path, int64(size))
 			}
 		}
 		if (p.Flags & ssh_FILEXFER_ATTR_PERMISSIONS) != 0 {
 			var mode uint32 = 0
 			if mode, b, err = unmarshalUint32Safe(b); err == nil {
-				err = os.Chmod(p.Path, os.FileMode(mode))
+				err = svr.fs.Chmod(path, os.FileMode(mode))
 			}
 		}
 		if (p.Flags & ssh_FILEXFER_ATTR_ACMODTIME) != 0 {
@@ -449,7 +560,7 @@ func (p sshFxpSetstatPacket) respond(svr *Server) error {
 			} else {
 				atimeT := time.Unix(int64(atime), 0)
 				mtimeT := time.Unix(int64(mtime), 0)
-				err = os.Chtimes(p.Path, atimeT, mtimeT)
+				err = svr.fs.Chtimes(path, atimeT, mtimeT)
 			}
 		}
 		if (p.Flags & ssh_FILEXFER_ATTR_UIDGID) != 0 {
@@ -458,7 +569,7 @@ func (p sshFxpSetstatPacket) respond(svr *Server) error {
 			if uid, b, err = unmarshalUint32Safe(b); err != nil {
 			} else if gid, b, err = unmarshalUint32Safe(b); err != nil {
 			} else {
-				err = os.Chown(p.Path, int(uid), int(gid))
+				err = svr.fs.Chown(path, int(uid), int(gid))
 			}
 		}
 
@@ -497,7 +608,7 @@ func (p sshFxpFsetstatPacket) respond(svr *Server) error {
 			} else {
 				atimeT := time.Unix(int64(atime), 0)
 				mtimeT := time.Unix(int64(mtime), 0)
-				err = os.Chtimes(f.Name(), atimeT, mtimeT)
+				err = svr.fs.Chtimes(f.Name(), atimeT, mtimeT)
 			}
 		}
 		if (p.Flags & ssh_FILEXFER_ATTR_UIDGID) != 0 {
@@ -514,52 +625,16 @@ func (p sshFxpFsetstatPacket) respond(svr *Server) error {
 	}
 }
 
-func errnoToSshErr(errno syscall.Errno) uint32 {
-	if errno == 0 {
-		return ssh_FX_OK
-	} else if errno == syscall.ENOENT {
-		return ssh_FX_NO_SUCH_FILE
-	} else if errno == syscall.EPERM {
-		return ssh_FX_PERMISSION_DENIED
-	} else {
-		return ssh_FX_FAILURE
-	}
-
-	return uint32(errno)
-}
-
 func statusFromError(id uint32, err error) sshFxpStatusPacket {
 	ret := sshFxpStatusPacket{
 		Id: id,
 		StatusError: StatusError{
-			// ssh_FX_OK                = 0
-			// ssh_FX_EOF               = 1
-			// ssh_FX_NO_SUCH_FILE      = 2 ENOENT
-			// ssh_FX_PERMISSION_DENIED = 3
-			// ssh_FX_FAILURE           = 4
-			// ssh_FX_BAD_MESSAGE       = 5
-			// ssh_FX_NO_CONNECTION     = 6
-			// ssh_FX_CONNECTION_LOST   = 7
-			// ssh_FX_OP_UNSUPPORTED    = 8
-			Code: ssh_FX_OK,
-			msg:  "",
-			lang: "",
+			Code: statusCode(err),
 		},
 	}
 	if err != nil {
 		debug("statusFromError: error is %T %#v", err, err)
-		ret.StatusError.Code = ssh_FX_FAILURE
 		ret.StatusError.msg = err.Error()
-		if err == io.EOF {
-			ret.StatusError.Code = ssh_FX_EOF
-		} else if errno, ok := err.(syscall.Errno); ok {
-			ret.StatusError.Code = errnoToSshErr(errno)
-		} else if pathError, ok := err.(*os.PathError); ok {
-			debug("statusFromError: error is %T %#v", pathError.Err, pathError.Err)
-			if errno, ok := pathError.Err.(syscall.Errno); ok {
-				ret.StatusError.Code = errnoToSshErr(errno)
-			}
-		}
 	}
 	return ret
 }
@@ -0,0 +1,155 @@
+package sftp
+
+// per request authorization of server operations
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// Operations passed to an AuthorizeFunc. Reads and writes through a handle
+// are authorized when the handle is opened; changing the attributes through a
+// handle is authorized as a setstat of the path it was opened on, and only
+// for handles opened for writing.
+const (
+	OpRead     = "read"     // open a file for reading
+	OpWrite    = "write"    // open a file for writing
+	OpList     = "list"     // open a directory
+	OpStat     = "stat"     // stat, lstat, realpath or statvfs
+	OpReadlink = "readlink" // read a symlink
+	OpSetstat  = "setstat"  // change the attributes of a path
+	OpMkdir    = "mkdir"    // create a directory
+	OpRemove   = "remove"   // remove a file or a directory
+	OpRename   = "rename"   // rename, authorized for both the old and the new path
+	OpSymlink  = "symlink"  // create a symlink, authorized for the link path
+)
+
+// AuthorizeFunc decides whether the user may perform the operation on the
+// path, as the client sees it. Denied requests fail with
+// SSH_FX_PERMISSION_DENIED.
+type AuthorizeFunc func(user, operation, path string) bool
+
+// Authorize has every request of the user's session approved by authorize,
+// e.g. to scope users to some directories or to reading only.
+func Authorize(user string, authorize AuthorizeFunc) func(*Server) error {
+	return func(svr *Server) error {
+		svr.user = user
+		svr.authorize = authorize
+		return nil
+	}
+}
+
+// authorizablePacket is a request on paths that an AuthorizeFunc approves
+type authorizablePacket interface {
+	id() uint32
+	authorization() (operation string, paths []string)
+}
+
+// handleAuthorizablePacket is a request on a handle that an AuthorizeFunc
+// approves for the path the handle was opened on
+type handleAuthorizablePacket interface {
+	id() uint32
+	handleAuthorization() (operation, handle string)
+}
+
+// handleGrant is the path a handle was opened on, as given to the
+// AuthorizeFunc, and the operation that was approved to open it
+type handleGrant struct {
+	operation string
+	path      string
+}
+
+func (svr *Server) getHandleGrant(handle string) (handleGrant, bool) {
+	svr.openFilesLock.RLock()
+	defer svr.openFilesLock.RUnlock()
+	grant, ok := svr.handleGrants[handle]
+	return grant, ok
+}
+
+// authorized tells whether the packet may be responded to, answering it
+// with SSH_FX_PERMISSION_DENIED if not
+func (svr *Server) authorized(pkt serverRespondablePacket) bool {
+	if svr.authorize == nil {
+		return true
+	}
+	if p, ok := pkt.(handleAuthorizablePacket); ok {
+		return svr.handleAuthorized(p)
+	}
+	p, ok := pkt.(authorizablePacket)
+	if !ok {
+		return true
+	}
+	operation, paths := p.authorization()
+	for _, path := range paths {
+		if !svr.authorize(svr.user, operation, svr.authorizedPath(path)) {
+			svr.sendPacket(statusFromError(p.id(), syscall.EPERM))
+			return false
+		}
+	}
+	return true
+}
+
+// handleAuthorized refuses requests on handles that were not opened for
+// writing and has the rest approved on the path the handle was opened on.
+// Unknown handles are left to fail as bad handles when responded to
+func (svr *Server) handleAuthorized(p handleAuthorizablePacket) bool {
+	operation, handle := p.handleAuthorization()
+	grant, ok := svr.getHandleGrant(handle)
+	if !ok {
+		return true
+	}
+	if grant.operation != OpWrite || !svr.authorize(svr.user, operation, grant.path) {
+		svr.sendPacket(statusFromError(p.id(), syscall.EPERM))
+		return false
+	}
+	return true
+}
+
+// authorizedPath is the clean path an AuthorizeFunc is given, absolute from
+// the root of a confined server
+func (svr *Server) authorizedPath(p string) string {
+	if svr.confined {
+		return filepath.Clean("/" + p)
+	}
+	return filepath.Clean(p)
+}
+
+func (p sshFxpOpenPacket) authorization() (string, []string) {
+	if p.Pflags&(ssh_FXF_WRITE|ssh_FXF_APPEND|ssh_FXF_CREAT|ssh_FXF_TRUNC) != 0 {
+		return OpWrite, []string{p.Path}
+	}
+	return OpRead, []string{p.Path}
+}
+
+func (p sshFxpOpendirPacket) authorization() (string, []string) { return OpList, []string{p.Path} }
+func (p sshFxpStatPacket) authorization() (string, []string)    { return OpStat, []string{p.Path} }
+func (p sshFxpLstatPacket) authorization() (string, []string)   { return OpStat, []string{p.Path} }
+func (p sshFxpRealpathPacket) authorization() (string, []string) {
+	return OpStat, []string{p.Path}
+}
+func (p sshFxpReadlinkPacket) authorization() (string, []string) {
+	return OpReadlink, []string{p.Path}
+}
+func (p sshFxpSetstatPacket) authorization() (string, []string) { return OpSetstat, []string{p.Path} }
+func (p sshFxpMkdirPacket) authorization() (string, []string)   { return OpMkdir, []string{p.Path} }
+func (p sshFxpRmdirPacket) authorization() (string, []string)   { return OpRemove, []string{p.Path} }
+func (p sshFxpRemovePacket) authorization() (string, []string)  { return OpRemove, []string{p.Filename} }
+func (p sshFxpRenamePacket) authorization() (string, []string) {
+	return OpRename, []string{p.Oldpath, p.Newpath}
+}
+func (p sshFxpSymlinkPacket) authorization() (string, []string) {
+	return OpSymlink, []string{p.Linkpath}
+}
+
+// authorization of statvfs is that of a stat; other extended requests are
+// not supported and need none
+func (p sshFxpExtendedPacket) authorization() (string, []string) {
+	if name, _, err := unmarshalStringSafe(p.Data); err == nil && p.ExtendedRequest == "statvfs@openssh.com" {
+		return OpStat, []string{name}
+	}
+	return OpStat, nil
+}
+
+func (p sshFxpFsetstatPacket) handleAuthorization() (string, string) {
+	return OpSetstat, p.Handle
+}
@@ -0,0 +1,139 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServerAuthorize(t *testing.T) {
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	if err := os.Mkdir(filepath.Join(root, "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		asked []string
+	)
+	// team-a may read anything but write only under /prod
+	authorize := func(user, operation, path string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		asked = append(asked, user+" "+operation+" "+path)
+		if operation == OpRead || operation == OpStat || operation == OpList {
+			return true
+		}
+		return strings.HasPrefix(path, "/prod/")
+	}
+	client := testServerClient(t, root, ConfineToRoot(), Authorize("team-a", authorize))
+	defer client.Close()
+
+	if f, err := client.Open("/artifact"); err != nil {
+		t.Errorf("Open(/artifact): %v", err)
+	} else {
+		f.Close()
+	}
+	if _, err := client.Create("/planted"); !isPermissionDenied(err) {
+		t.Errorf("Create(/planted): want permission denied, got %v", err)
+	}
+	if err := client.Rename("/artifact", "/prod/artifact"); !isPermissionDenied(err) {
+		t.Errorf("Rename(/artifact, /prod/artifact): want permission denied, got %v", err)
+	}
+	if f, err := client.Create("/prod/../prod/allowed"); err != nil {
+		t.Errorf("Create(/prod/allowed): %v", err)
+	} else {
+		f.Close()
+	}
+	if _, err := os.Stat(filepath.Join(root, "planted")); !os.IsNotExist(err) {
+		t.Errorf("a denied create still created the file: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"team-a read /artifact", "team-a write /planted", "team-a rename /artifact", "team-a write /prod/allowed"}
+	for _, w := range want {
+		found := false
+		for _, a := range asked {
+			found = found || a == w
+		}
+		if !found {
+			t.Errorf("authorize was not asked %q, asked %q", w, asked)
+		}
+	}
+}
+
+// fsetstat changes the mode of the file through its handle
+func fsetstat(client *Client, f *File, mode os.FileMode) error {
+	id := client.nextId()
+	typ, data, err := client.sendRequest(sshFxpFsetstatPacket{
+		Id:     id,
+		Handle: f.handle,
+		Flags:  ssh_FILEXFER_ATTR_PERMISSIONS,
+		Attrs:  uint32(mode),
+	})
+	if err != nil {
+		return err
+	}
+	if typ != ssh_FXP_STATUS {
+		return unimplementedPacketErr(typ)
+	}
+	return pathError("fsetstat", f.path, unmarshalStatus(id, data))
+}
+
+func TestServerAuthorizeFsetstat(t *testing.T) {
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	if err := os.Mkdir(filepath.Join(root, "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		asked []string
+	)
+	// team-a may read anything and write, but not change attributes, under /prod
+	authorize := func(user, operation, path string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		asked = append(asked, operation+" "+path)
+		if operation == OpRead || operation == OpStat || operation == OpList {
+			return true
+		}
+		return operation == OpWrite && strings.HasPrefix(path, "/prod/")
+	}
+	client := testServerClient(t, root, ConfineToRoot(), Authorize("team-a", authorize))
+	defer client.Close()
+
+	if f, err := client.Open("/artifact"); err != nil {
+		t.Errorf("Open(/artifact): %v", err)
+	} else {
+		if err := fsetstat(client, f, 0777); !isPermissionDenied(err) {
+			t.Errorf("fsetstat of a handle opened for reading: want permission denied, got %v", err)
+		}
+		f.Close()
+	}
+	if info, err := os.Stat(filepath.Join(root, "artifact")); err != nil || info.Mode().Perm() == 0777 {
+		t.Errorf("a denied fsetstat still changed the file: %v %v", info.Mode(), err)
+	}
+	if f, err := client.Create("/prod/written"); err != nil {
+		t.Errorf("Create(/prod/written): %v", err)
+	} else {
+		if err := fsetstat(client, f, 0777); !isPermissionDenied(err) {
+			t.Errorf("fsetstat of a handle opened for writing: want permission denied, got %v", err)
+		}
+		f.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "setstat /prod/written"
+	found := false
+	for _, a := range asked {
+		found = found || a == want
+	}
+	if !found {
+		t.Errorf("authorize was not asked %q, asked %q", want, asked)
+	}
+}
@@ -0,0 +1,80 @@
+package sftp
+
+// the filesystem a server serves
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ServerFS is the filesystem a server serves, the local one unless another is
+// given with ServeFS. Paths are those of the server after confinement, and
+// errors are mapped onto sftp status codes as those of the os package are.
+type ServerFS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Mkdir(name string, perm os.FileMode) error
+	// Remove removes a file or an empty directory
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	// EvalSymlinks is the path after evaluating every symlink in it
+	EvalSymlinks(name string) (string, error)
+	Truncate(name string, size int64) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chown(name string, uid, gid int) error
+	// Statvfs returns syscall.ENOTSUP where there are no filesystem statistics
+	Statvfs(name string) (*StatVFS, error)
+}
+
+// ServerFile is a file or directory opened on a ServerFS
+type ServerFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	// Readdir returns io.EOF once every entry of the directory was returned
+	Readdir(n int) ([]os.FileInfo, error)
+	Truncate(size int64) error
+	Chmod(mode os.FileMode) error
+	Chown(uid, gid int) error
+}
+
+// ServeFS has the server serve fs instead of the local filesystem
+func ServeFS(fs ServerFS) func(*Server) error {
+	return func(svr *Server) error {
+		svr.fs = fs
+		return nil
+	}
+}
+
+// osFS is the local filesystem
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error) {
+	if f, err := os.OpenFile(name, flag, perm); err != nil {
+		return nil, err
+	} else {
+		return f, nil
+	}
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error)             { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error)            { return os.Lstat(name) }
+func (osFS) Mkdir(name string, perm os.FileMode) error         { return os.Mkdir(name, perm) }
+func (osFS) Remove(name string) error                          { return os.Remove(name) }
+func (osFS) Rename(oldname, newname string) error              { return os.Rename(oldname, newname) }
+func (osFS) Symlink(oldname, newname string) error             { return os.Symlink(oldname, newname) }
+func (osFS) Readlink(name string) (string, error)              { return os.Readlink(name) }
+func (osFS) EvalSymlinks(name string) (string, error)          { return filepath.EvalSymlinks(name) }
+func (osFS) Truncate(name string, size int64) error            { return os.Truncate(name, size) }
+func (osFS) Chmod(name string, mode os.FileMode) error         { return os.Chmod(name, mode) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error { return os.Chtimes(name, atime, mtime) }
+func (osFS) Chown(name string, uid, gid int) error             { return os.Chown(name, uid, gid) }
+func (osFS) Statvfs(name string) (*StatVFS, error)             { return statvfs(name) }
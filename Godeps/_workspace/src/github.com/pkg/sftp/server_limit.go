@@ -0,0 +1,103 @@
+package sftp
+
+// limits on sessions, outstanding requests and bandwidth of servers
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManySessions is returned by Serve when the limiter of the server
+// already serves its maximum of sessions
+var ErrTooManySessions = errors.New("sftp: too many concurrent sessions")
+
+// A Limiter caps the concurrent sessions and the aggregate bandwidth of every
+// server sharing it, so that no single client starves the others.
+type Limiter struct {
+	maxSessions    int
+	bytesPerSecond int64
+
+	mu       sync.Mutex
+	sessions int
+	next     time.Time // when the bandwidth already granted is used up
+}
+
+// NewLimiter returns a Limiter of at most maxSessions concurrent sessions
+// transferring at most bytesPerSecond of file data between them. Zero leaves
+// either unlimited.
+func NewLimiter(maxSessions int, bytesPerSecond int64) *Limiter {
+	return &Limiter{maxSessions: maxSessions, bytesPerSecond: bytesPerSecond}
+}
+
+// Limit has the server share the session and bandwidth caps of the limiter
+func Limit(limiter *Limiter) func(*Server) error {
+	return func(svr *Server) error {
+		svr.limiter = limiter
+		return nil
+	}
+}
+
+// MaxOutstandingRequests caps the requests of the session being worked on at
+// once; further requests are not read until one is responded to
+func MaxOutstandingRequests(n int) func(*Server) error {
+	return func(svr *Server) error {
+		if n < 1 {
+			return errors.New("sftp: at least one outstanding request is required")
+		}
+		svr.outstanding = make(chan struct{}, n)
+		return nil
+	}
+}
+
+func (l *Limiter) acquireSession() error {
+	if l == nil || l.maxSessions <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions >= l.maxSessions {
+		return ErrTooManySessions
+	}
+	l.sessions++
+	return nil
+}
+
+func (l *Limiter) releaseSession() {
+	if l == nil || l.maxSessions <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessions--
+}
+
+// waitBandwidth blocks until n more bytes may be transferred
+func (l *Limiter) waitBandwidth(n int) {
+	if l == nil || l.bytesPerSecond <= 0 || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// acquireRequest blocks while the session has its maximum of outstanding
+// requests
+func (svr *Server) acquireRequest() {
+	if svr.outstanding != nil {
+		svr.outstanding <- struct{}{}
+	}
+}
+
+func (svr *Server) releaseRequest() {
+	if svr.outstanding != nil {
+		<-svr.outstanding
+	}
+}
@@ -0,0 +1,66 @@
+package sftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerSessionLimit(t *testing.T) {
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	limiter := NewLimiter(1, 0)
+	client := testServerClient(t, root, Limit(limiter))
+
+	_, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, err := NewServer(bytes.NewReader(nil), w, ioutil.Discard, 0, false, root, Limit(limiter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.Serve(); err != ErrTooManySessions {
+		t.Errorf("Serve() with the session in use: want %v, got %v", ErrTooManySessions, err)
+	}
+
+	client.Close()
+	// the first session is released once its server notices the close
+	deadline := time.Now().Add(5 * time.Second)
+	for limiter.acquireSession() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the closed session was never released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	limiter.releaseSession()
+}
+
+func TestServerBandwidthLimit(t *testing.T) {
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	contents := bytes.Repeat([]byte("x"), 1<<17)
+	if err := ioutil.WriteFile(filepath.Join(root, "large"), contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := testServerClient(t, root, Limit(NewLimiter(0, 1<<18)), MaxOutstandingRequests(1))
+	defer client.Close()
+
+	started := time.Now()
+	f, err := client.Open(filepath.Join(root, "large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	read, err := ioutil.ReadAll(f)
+	if err != nil || !bytes.Equal(read, contents) {
+		t.Fatalf("reading through the limited server: %d bytes, %v", len(read), err)
+	}
+	// half a second of bandwidth, less the first packet which goes right away
+	if elapsed := time.Since(started); elapsed < 300*time.Millisecond {
+		t.Errorf("read %d bytes at %d bytes per second in %v", len(read), 1<<18, elapsed)
+	}
+}
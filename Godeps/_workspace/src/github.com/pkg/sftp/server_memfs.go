@@ -0,0 +1,432 @@
+package sftp
+
+// in-memory filesystem for servers
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxSymlinks bounds the symlinks followed resolving one path
+const maxSymlinks = 40
+
+// MemFS is a ServerFS kept in memory, so that tests can run whole sftp
+// sessions without touching the local filesystem. Permissions are recorded
+// but not enforced.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+	target  string // of a symlink
+	uid     int
+	gid     int
+}
+
+// NewMemFS returns an empty MemFS, holding only its root directory
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: map[string]*memNode{
+		"/": &memNode{mode: os.ModeDir | 0755, modTime: time.Now()},
+	}}
+}
+
+func memPath(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// lookup finds the node at the path, following the symlinks on the way to it
+// and, when follow is set, the one it names. The path is returned resolved
+// even when the node does not exist, for it to be created at.
+func (fs *MemFS) lookup(name string, follow bool) (string, *memNode, error) {
+	return fs.lookupDepth(memPath(name), follow, 0)
+}
+
+func (fs *MemFS) lookupDepth(p string, follow bool, depth int) (string, *memNode, error) {
+	if depth > maxSymlinks {
+		return p, nil, syscall.ELOOP
+	}
+	if p == "/" {
+		return p, fs.nodes[p], nil
+	}
+	dir, parent, err := fs.lookupDepth(filepath.Dir(p), true, depth)
+	if err != nil {
+		return p, nil, err
+	} else if !parent.mode.IsDir() {
+		return p, nil, syscall.ENOTDIR
+	}
+	p = filepath.Join(dir, filepath.Base(p))
+	node, ok := fs.nodes[p]
+	if !ok {
+		return p, nil, syscall.ENOENT
+	}
+	if follow && node.mode&os.ModeSymlink != 0 {
+		target := node.target
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		return fs.lookupDepth(target, true, depth+1)
+	}
+	return p, node, nil
+}
+
+// create adds a node at the path, the parent of which must be a directory
+func (fs *MemFS) create(op, name string, node *memNode) (string, error) {
+	p, existing, err := fs.lookup(name, false)
+	if err == nil && existing != nil {
+		return p, &os.PathError{Op: op, Path: name, Err: syscall.EEXIST}
+	} else if err != syscall.ENOENT {
+		return p, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	node.modTime = time.Now()
+	fs.nodes[p] = node
+	return p, nil
+}
+
+func (fs *MemFS) children(dir string) (names []string) {
+	for p := range fs.nodes {
+		if p != "/" && filepath.Dir(p) == dir {
+			names = append(names, filepath.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, node, err := fs.lookup(name, true)
+	if err == syscall.ENOENT && flag&os.O_CREATE != 0 {
+		node = &memNode{mode: perm & os.ModePerm}
+		if p, err = fs.create("open", p, node); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	} else if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	} else if node.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	} else if flag&os.O_TRUNC != 0 {
+		node.data, node.modTime = nil, time.Now()
+	}
+	return &memFile{fs: fs, node: node, name: p, flag: flag}, nil
+}
+
+func (fs *MemFS) stat(op, name string, follow bool) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, node, err := fs.lookup(name, follow)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return newMemFileInfo(p, node), nil
+}
+
+func (fs *MemFS) Stat(name string) (os.FileInfo, error)  { return fs.stat("stat", name, true) }
+func (fs *MemFS) Lstat(name string) (os.FileInfo, error) { return fs.stat("lstat", name, false) }
+
+func (fs *MemFS) Mkdir(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, err := fs.create("mkdir", name, &memNode{mode: os.ModeDir | perm&os.ModePerm})
+	return err
+}
+
+func (fs *MemFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, node, err := fs.lookup(name, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	} else if p == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	} else if node.mode.IsDir() && len(fs.children(p)) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(fs.nodes, p)
+	return nil
+}
+
+// Rename moves the node, and everything under a directory, replacing a file
+// or an empty directory at the new path
+func (fs *MemFS) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldpath, node, err := fs.lookup(oldname, false)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	newpath, existing, err := fs.lookup(newname, false)
+	if err != nil && err != syscall.ENOENT {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	} else if oldpath == "/" || newpath == oldpath {
+		return nil
+	} else if existing != nil && existing.mode.IsDir() && len(fs.children(newpath)) > 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.ENOTEMPTY}
+	} else if node.mode.IsDir() && strings.HasPrefix(newpath, oldpath+"/") {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+	moved := map[string]*memNode{}
+	for p, n := range fs.nodes {
+		if p == oldpath || strings.HasPrefix(p, oldpath+"/") {
+			moved[newpath+p[len(oldpath):]] = n
+			delete(fs.nodes, p)
+		}
+	}
+	for p, n := range moved {
+		fs.nodes[p] = n
+	}
+	return nil
+}
+
+func (fs *MemFS) Symlink(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, err := fs.create("symlink", newname, &memNode{mode: os.ModeSymlink | 0777, target: oldname})
+	return err
+}
+
+func (fs *MemFS) Readlink(name string) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, node, err := fs.lookup(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	} else if node.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return node.target, nil
+}
+
+func (fs *MemFS) EvalSymlinks(name string) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, _, err := fs.lookup(name, true)
+	if err != nil {
+		return "", &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return p, nil
+}
+
+// update changes the node at the path, after following its symlinks
+func (fs *MemFS) update(op, name string, change func(*memNode) error) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, node, err := fs.lookup(name, true)
+	if err == nil {
+		err = change(node)
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+func (fs *MemFS) Truncate(name string, size int64) error {
+	return fs.update("truncate", name, func(node *memNode) error { return node.truncate(size) })
+}
+
+func (fs *MemFS) Chmod(name string, mode os.FileMode) error {
+	return fs.update("chmod", name, func(node *memNode) error {
+		node.mode = node.mode&^os.ModePerm | mode&os.ModePerm
+		return nil
+	})
+}
+
+func (fs *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.update("chtimes", name, func(node *memNode) error {
+		node.modTime = mtime
+		return nil
+	})
+}
+
+func (fs *MemFS) Chown(name string, uid, gid int) error {
+	return fs.update("chown", name, func(node *memNode) error {
+		node.uid, node.gid = uid, gid
+		return nil
+	})
+}
+
+// Statvfs is not supported, memory has no filesystem statistics
+func (fs *MemFS) Statvfs(name string) (*StatVFS, error) {
+	return nil, syscall.ENOTSUP
+}
+
+func (node *memNode) truncate(size int64) error {
+	if node.mode.IsDir() {
+		return syscall.EISDIR
+	} else if size < 0 {
+		return syscall.EINVAL
+	}
+	if size <= int64(len(node.data)) {
+		node.data = node.data[:size]
+	} else {
+		node.data = append(node.data, make([]byte, size-int64(len(node.data)))...)
+	}
+	node.modTime = time.Now()
+	return nil
+}
+
+// memFile is a file or directory opened on a MemFS. Like an open os.File it
+// keeps its node when the path is renamed or removed.
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	listed int // entries of a directory already returned by Readdir
+	closed bool
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) check(op string, write bool) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	} else if write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	} else if f.node.mode.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	} else if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		off = int64(len(f.node.data))
+	}
+	if end := off + int64(len(b)); end > int64(len(f.node.data)) {
+		f.node.truncate(end)
+	}
+	copy(f.node.data[off:], b)
+	f.node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("close", false); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("stat", false); err != nil {
+		return nil, err
+	}
+	return newMemFileInfo(f.name, f.node), nil
+}
+
+func (f *memFile) Readdir(n int) ([]os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("readdirent", false); err != nil {
+		return nil, err
+	} else if !f.node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	names := f.fs.children(f.name)
+	if f.listed >= len(names) {
+		if n <= 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+	names = names[f.listed:]
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	f.listed += len(names)
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		p := filepath.Join(f.name, name)
+		infos = append(infos, newMemFileInfo(p, f.fs.nodes[p]))
+	}
+	return infos, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	} else if err := f.node.truncate(size); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *memFile) Chmod(mode os.FileMode) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("chmod", false); err != nil {
+		return err
+	}
+	f.node.mode = f.node.mode&^os.ModePerm | mode&os.ModePerm
+	return nil
+}
+
+func (f *memFile) Chown(uid, gid int) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("chown", false); err != nil {
+		return err
+	}
+	f.node.uid, f.node.gid = uid, gid
+	return nil
+}
+
+// memFileInfo is a snapshot of a node of a MemFS
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newMemFileInfo(p string, node *memNode) *memFileInfo {
+	return &memFileInfo{filepath.Base(p), int64(len(node.data)), node.mode, node.modTime}
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
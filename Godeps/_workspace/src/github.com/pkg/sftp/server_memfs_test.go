@@ -0,0 +1,101 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+)
+
+// testMemFSClient connects a client to an in-process server of a MemFS
+func testMemFSClient(t *testing.T, options ...func(*Server) error) (*Client, *MemFS) {
+	fs := NewMemFS()
+	return testServerClient(t, "/", append([]func(*Server) error{ServeFS(fs)}, options...)...), fs
+}
+
+func TestMemFSRoundTrip(t *testing.T) {
+	client, fs := testMemFSClient(t)
+	defer client.Close()
+
+	if err := client.Mkdir("/backup"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := client.Create("/backup/installation.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("installation settings")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := client.Rename("/backup", "/restore"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Symlink("restore/installation.zip", "/latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := client.Open("/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(contents) != "installation settings" {
+		t.Errorf("reading /latest: want the installation, got %q (%v)", contents, err)
+	}
+
+	infos, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "latest" || names[1] != "restore" {
+		t.Errorf("ReadDir(/): want latest and restore, got %v", names)
+	}
+	if target, err := client.ReadLink("/latest"); err != nil || target != "restore/installation.zip" {
+		t.Errorf("ReadLink(/latest): got %q (%v)", target, err)
+	}
+
+	if err := client.Remove("/restore"); err == nil {
+		t.Errorf("Remove(/restore) removed a directory that is not empty")
+	}
+	if err := client.Remove("/restore/installation.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/restore/installation.zip"); !os.IsNotExist(err) {
+		t.Errorf("the removed file is still in the filesystem: %v", err)
+	}
+	if _, err := client.Open("/latest"); err == nil {
+		t.Errorf("Open(/latest) succeeded on a dangling link")
+	}
+}
+
+func TestMemFSConfined(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.Mkdir("/artifacts", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/private", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/private", "/artifacts/escape"); err != nil {
+		t.Fatal(err)
+	}
+	client := testServerClient(t, "/artifacts", ServeFS(fs), ConfineToRoot())
+	defer client.Close()
+
+	if err := client.Mkdir("/../made"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/artifacts/made"); err != nil {
+		t.Errorf("Mkdir(/../made) did not create it under the root: %v", err)
+	}
+	if _, err := client.Create("/escape/planted"); !isPermissionDenied(err) {
+		t.Errorf("Create(/escape/planted): want permission denied, got %v", err)
+	}
+}
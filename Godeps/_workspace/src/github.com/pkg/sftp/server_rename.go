@@ -0,0 +1,72 @@
+package sftp
+
+// what a server does with renames onto paths that exist
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// RenamePolicy is what a server does with a rename onto a path that exists
+type RenamePolicy int
+
+const (
+	// RenameOverwrite replaces a file or an empty directory at the new path
+	// in one step, as the filesystem renames
+	RenameOverwrite RenamePolicy = iota
+	// RenameReject fails the rename, so that a file once written there is
+	// never replaced
+	RenameReject
+	// RenameVersion keeps what is at the new path as path.1, or path.2 when
+	// that is taken too, and so on, before renaming onto it
+	RenameVersion
+)
+
+// renameMu serializes the renames of every session with a policy other than
+// RenameOverwrite, so that two of them do not both find the new path free.
+// Renames by other processes are not seen.
+var renameMu sync.Mutex
+
+// RenameCollisions sets what the server does with a rename onto a path that
+// exists, RenameOverwrite unless given
+func RenameCollisions(policy RenamePolicy) func(*Server) error {
+	return func(svr *Server) error {
+		switch policy {
+		case RenameOverwrite, RenameReject, RenameVersion:
+		default:
+			return fmt.Errorf("sftp: unknown rename policy %d", policy)
+		}
+		svr.renamePolicy = policy
+		return nil
+	}
+}
+
+// rename renames oldpath onto newpath, paths of the filesystem, as the
+// rename policy says
+func (svr *Server) rename(oldpath, newpath string) error {
+	if svr.renamePolicy == RenameOverwrite || oldpath == newpath {
+		return svr.fs.Rename(oldpath, newpath)
+	}
+	renameMu.Lock()
+	defer renameMu.Unlock()
+	if _, err := svr.fs.Lstat(newpath); os.IsNotExist(err) {
+		return svr.fs.Rename(oldpath, newpath)
+	} else if err != nil {
+		return err
+	} else if svr.renamePolicy == RenameReject {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EEXIST}
+	}
+	for version := 1; ; version++ {
+		versioned := fmt.Sprintf("%s.%d", newpath, version)
+		if _, err := svr.fs.Lstat(versioned); os.IsNotExist(err) {
+			if err = svr.fs.Rename(newpath, versioned); err != nil {
+				return err
+			}
+			return svr.fs.Rename(oldpath, newpath)
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// testRenameCollision renames /upload.tmp onto an existing /ccdb.backup on a
+// server with the policy, returning the error of the rename
+func testRenameCollision(t *testing.T, client *Client) error {
+	for name, contents := range map[string]string{"/ccdb.backup": "monday", "/upload.tmp": "tuesday"} {
+		f, err := client.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(contents))
+		f.Close()
+	}
+	return client.Rename("/upload.tmp", "/ccdb.backup")
+}
+
+func readRemote(t *testing.T, client *Client, name string) string {
+	f, err := client.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, _ := ioutil.ReadAll(f)
+	return string(b)
+}
+
+func TestRenameOverwrite(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	if err := testRenameCollision(t, client); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRemote(t, client, "/ccdb.backup"); got != "tuesday" {
+		t.Errorf("want the file replaced, got %q", got)
+	}
+}
+
+func TestRenameReject(t *testing.T) {
+	client, _ := testMemFSClient(t, RenameCollisions(RenameReject))
+	defer client.Close()
+
+	if err := testRenameCollision(t, client); err == nil {
+		t.Fatal("want the rename onto an existing file refused")
+	}
+	if got := readRemote(t, client, "/ccdb.backup"); got != "monday" {
+		t.Errorf("want the file kept, got %q", got)
+	}
+	if err := client.Rename("/upload.tmp", "/uaadb.backup"); err != nil {
+		t.Errorf("want a rename onto a free path, got %v", err)
+	}
+}
+
+func TestRenameVersion(t *testing.T) {
+	client, _ := testMemFSClient(t, RenameCollisions(RenameVersion))
+	defer client.Close()
+
+	if err := testRenameCollision(t, client); err != nil {
+		t.Fatal(err)
+	}
+	if err := testRenameCollision(t, client); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"/ccdb.backup": "tuesday", "/ccdb.backup.1": "monday", "/ccdb.backup.2": "monday"} {
+		if got := readRemote(t, client, name); got != want {
+			t.Errorf("want %s to hold %q, got %q", name, want, got)
+		}
+	}
+}
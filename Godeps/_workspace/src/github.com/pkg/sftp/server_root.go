@@ -0,0 +1,118 @@
+package sftp
+
+// confinement of server paths to the root directory
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ConfineToRoot pins every path a client names under the root directory of
+// the server, as if the root were chrooted: absolute paths start at the root,
+// ".." never climbs above it and symlinks resolving outside of it are denied.
+func ConfineToRoot() func(*Server) error {
+	return func(svr *Server) error {
+		svr.confined = true
+		return nil
+	}
+}
+
+// resolveRoot makes the root of a confined server absolute and free of
+// symlinks, on the filesystem it serves
+func (svr *Server) resolveRoot() error {
+	if !svr.confined {
+		return nil
+	}
+	root, err := filepath.Abs(svr.rootDir)
+	if err != nil {
+		return err
+	}
+	if root, err = svr.fs.EvalSymlinks(root); err != nil {
+		return err
+	}
+	svr.rootDir = root
+	return nil
+}
+
+// realPath maps a client path onto the filesystem. When the server is
+// confined, every symlink on the way to the path must stay under the root,
+// and so must the path itself when follow is set, e.g. for stat and open
+// but not for lstat, readlink or remove.
+func (svr *Server) realPath(p string, follow bool) (string, error) {
+	if !svr.confined {
+		return p, nil
+	}
+	joined := filepath.Join(svr.rootDir, filepath.Clean("/"+p))
+	if joined == svr.rootDir {
+		return joined, nil
+	}
+	dir, err := svr.resolveExisting(filepath.Dir(joined))
+	if err != nil {
+		return "", err
+	}
+	if !svr.withinRoot(dir) {
+		return "", syscall.EPERM
+	}
+	real := filepath.Join(dir, filepath.Base(joined))
+	if follow {
+		if resolved, err := svr.resolveExisting(real); err != nil {
+			return "", err
+		} else if !svr.withinRoot(resolved) {
+			return "", syscall.EPERM
+		}
+	}
+	return real, nil
+}
+
+// clientPath is the path a client knows a real path under the root by
+func (svr *Server) clientPath(real string) string {
+	if !svr.confined || !svr.withinRoot(real) {
+		return real
+	}
+	return filepath.Join("/", strings.TrimPrefix(real, svr.rootDir))
+}
+
+// symlinkTarget maps the target of a symlink a client creates at the real
+// link path: absolute targets move under the root and relative ones must not
+// climb out of it
+func (svr *Server) symlinkTarget(target, link string) (string, error) {
+	if !svr.confined {
+		return target, nil
+	}
+	if filepath.IsAbs(target) {
+		return filepath.Join(svr.rootDir, filepath.Clean(target)), nil
+	}
+	if !svr.withinRoot(filepath.Join(filepath.Dir(link), target)) {
+		return "", syscall.EPERM
+	}
+	return target, nil
+}
+
+func (svr *Server) withinRoot(real string) bool {
+	prefix := svr.rootDir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return real == svr.rootDir || strings.HasPrefix(real, prefix)
+}
+
+// resolveExisting evaluates the symlinks of the longest existing prefix of
+// the path, the rest of which cannot be a symlink
+func (svr *Server) resolveExisting(p string) (string, error) {
+	resolved, err := svr.fs.EvalSymlinks(p)
+	if err == nil {
+		return resolved, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p, nil
+	}
+	if resolved, err = svr.resolveExisting(parent); err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(p)), nil
+}
@@ -0,0 +1,135 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testServerClient connects a client to an in-process server of rootDir
+func testServerClient(t *testing.T, rootDir string, options ...func(*Server) error) *Client {
+	cr, sw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, cw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, rootDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func testRootDirs(t *testing.T) (root, outside string) {
+	base, err := ioutil.TempDir("", "sftptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, outside = filepath.Join(base, "root"), filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "artifact"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func isPermissionDenied(err error) bool {
+	return os.IsPermission(err)
+}
+
+func TestServerConfinedPaths(t *testing.T) {
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	client := testServerClient(t, root, ConfineToRoot())
+	defer client.Close()
+
+	for _, p := range []string{"/artifact", "artifact", "../../artifact", "/../artifact"} {
+		f, err := client.Open(p)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", p, err)
+		}
+		contents, err := ioutil.ReadAll(f)
+		f.Close()
+		if string(contents) != "inside" {
+			t.Errorf("Open(%q): want inside, got %q (%v)", p, contents, err)
+		}
+	}
+	if _, err := client.Stat("../outside/secret"); err == nil {
+		t.Errorf("Stat(../outside/secret) reached outside the root")
+	}
+	if err := client.Mkdir("/made"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "made")); err != nil {
+		t.Errorf("Mkdir(/made) did not create it under the root: %v", err)
+	}
+}
+
+func TestServerConfinedSymlinks(t *testing.T) {
+	root, outside := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "secret")); err != nil {
+		t.Fatal(err)
+	}
+	client := testServerClient(t, root, ConfineToRoot())
+	defer client.Close()
+
+	for _, p := range []string{"/escape/secret", "/secret"} {
+		if _, err := client.Open(p); !isPermissionDenied(err) {
+			t.Errorf("Open(%q): want permission denied, got %v", p, err)
+		}
+	}
+	if _, err := client.Create("/escape/planted"); !isPermissionDenied(err) {
+		t.Errorf("Create(/escape/planted): want permission denied, got %v", err)
+	}
+	if err := client.Rename("/artifact", "/escape/artifact"); !isPermissionDenied(err) {
+		t.Errorf("Rename(/artifact, /escape/artifact): want permission denied, got %v", err)
+	}
+	if err := client.Symlink("../outside", "/climb"); !isPermissionDenied(err) {
+		t.Errorf("Symlink(../outside, /climb): want permission denied, got %v", err)
+	}
+
+	// an absolute target is created under the root and read back as the client named it
+	if err := client.Symlink("/artifact", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := client.ReadLink("/link"); err != nil || target != "/artifact" {
+		t.Errorf("ReadLink(/link): want /artifact, got %q (%v)", target, err)
+	}
+	if f, err := client.Open("/link"); err != nil {
+		t.Errorf("Open(/link): %v", err)
+	} else {
+		contents, _ := ioutil.ReadAll(f)
+		f.Close()
+		if string(contents) != "inside" {
+			t.Errorf("Open(/link): want the artifact, got %q", contents)
+		}
+	}
+
+	// the escaping link itself may still be removed
+	if err := client.Remove("/secret"); err != nil {
+		t.Errorf("Remove(/secret): %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("Remove(/secret) removed the file outside the root: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package sftp
+
+// request statistics of servers
+
+import "sync"
+
+// ServerStats are the counters of a server since it started serving
+type ServerStats struct {
+	// Packets counts the requests by packet type, e.g. SSH_FXP_READ
+	Packets      map[string]uint64
+	BytesRead    uint64 // file data sent to the client
+	BytesWritten uint64 // file data received from the client
+	// BytesSent and BytesReceived count every packet, lengths included
+	BytesSent     uint64
+	BytesReceived uint64
+	// Errors counts the requests answered with a failure status
+	Errors      uint64
+	OpenHandles int
+}
+
+// RequestStat is what a StatsHook learns of each request responded to
+type RequestStat struct {
+	Type  string // the packet type, e.g. SSH_FXP_OPEN
+	Bytes int    // the file data read or written
+	// Err is the status the client was sent when the request failed
+	Err *StatusError
+}
+
+// StatsHook has hook called with the statistics of each request once it is
+// responded to, e.g. to time or export them
+func StatsHook(hook func(RequestStat)) func(*Server) error {
+	return func(svr *Server) error {
+		svr.stats.hook = hook
+		return nil
+	}
+}
+
+// Stats returns a snapshot of the counters of the server
+func (svr *Server) Stats() ServerStats {
+	svr.stats.mu.Lock()
+	stats := svr.stats.ServerStats
+	stats.Packets = make(map[string]uint64, len(svr.stats.Packets))
+	for name, count := range svr.stats.Packets {
+		stats.Packets[name] = count
+	}
+	svr.stats.mu.Unlock()
+
+	svr.openFilesLock.RLock()
+	defer svr.openFilesLock.RUnlock()
+	stats.OpenHandles = len(svr.openFiles)
+	return stats
+}
+
+type serverStats struct {
+	ServerStats
+	mu       sync.Mutex
+	hook     func(RequestStat)
+	inflight map[uint32]*RequestStat
+}
+
+// identifiedPacket is a request the response of which carries its id
+type identifiedPacket interface {
+	id() uint32
+}
+
+func (s *serverStats) begin(pktType fxp, pkt serverRespondablePacket) (id uint32) {
+	if p, ok := pkt.(identifiedPacket); ok {
+		id = p.id()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Packets == nil {
+		s.Packets = map[string]uint64{}
+		s.inflight = map[uint32]*RequestStat{}
+	}
+	s.Packets[pktType.String()]++
+	s.inflight[id] = &RequestStat{Type: pktType.String()}
+	return
+}
+
+// wire counts the bytes of packets sent and received
+func (s *serverStats) wire(sent, received int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BytesSent += uint64(sent)
+	s.BytesReceived += uint64(received)
+}
+
+func (s *serverStats) transferred(id uint32, n int, write bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if write {
+		s.BytesWritten += uint64(n)
+	} else {
+		s.BytesRead += uint64(n)
+	}
+	if stat, ok := s.inflight[id]; ok {
+		stat.Bytes += n
+	}
+}
+
+// responded counts a status sent to the client, as an error unless it is ok
+// or the end of a file or directory
+func (s *serverStats) responded(status sshFxpStatusPacket) {
+	if status.Code == ssh_FX_OK || status.Code == ssh_FX_EOF {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors++
+	if stat, ok := s.inflight[status.Id]; ok {
+		err := status.StatusError
+		stat.Err = &err
+	}
+}
+
+func (s *serverStats) end(id uint32) {
+	s.mu.Lock()
+	stat := s.inflight[id]
+	delete(s.inflight, id)
+	s.mu.Unlock()
+	if s.hook != nil && stat != nil {
+		s.hook(*stat)
+	}
+}
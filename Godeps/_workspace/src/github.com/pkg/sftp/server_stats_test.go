@@ -0,0 +1,82 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestServerStats(t *testing.T) {
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	cr, sw, _ := os.Pipe()
+	sr, cw, _ := os.Pipe()
+	var (
+		mu        sync.Mutex
+		requests  []RequestStat
+		collector = func(stat RequestStat) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, stat)
+		}
+	)
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, root, ConfineToRoot(), StatsHook(collector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	f, err := client.Open("/artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if stats := svr.Stats(); stats.OpenHandles != 1 {
+		t.Errorf("want 1 open handle, got %d", stats.OpenHandles)
+	}
+	f.Close()
+	if _, err := client.Open("/missing"); err == nil {
+		t.Fatal("Open(/missing) succeeded")
+	}
+	if w, err := client.Create("/written"); err != nil {
+		t.Fatal(err)
+	} else {
+		w.Write([]byte("12345"))
+		w.Close()
+	}
+
+	stats := svr.Stats()
+	if stats.BytesRead != uint64(len("inside")) || stats.BytesWritten != 5 {
+		t.Errorf("want 6 bytes read and 5 written, got %d and %d", stats.BytesRead, stats.BytesWritten)
+	}
+	if stats.Errors != 1 || stats.OpenHandles != 0 {
+		t.Errorf("want 1 error and no open handles, got %d and %d", stats.Errors, stats.OpenHandles)
+	}
+	if stats.Packets["SSH_FXP_OPEN"] != 3 || stats.Packets["SSH_FXP_CLOSE"] != 2 {
+		t.Errorf("want 3 opens and 2 closes, got %v", stats.Packets)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var failed, read int
+	for _, stat := range requests {
+		if stat.Err != nil && stat.Type == "SSH_FXP_OPEN" && stat.Err.Code == ssh_FX_NO_SUCH_FILE {
+			failed++
+		}
+		if stat.Type == "SSH_FXP_READ" {
+			read += stat.Bytes
+		}
+	}
+	if failed != 1 || read != len("inside") {
+		t.Errorf("want the hook to see 1 failed open and 6 bytes read, got %d and %d in %v", failed, read, requests)
+	}
+}
@@ -0,0 +1,22 @@
+package sftp
+
+import "syscall"
+
+func statvfs(path string) (*StatVFS, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+	return &StatVFS{
+		Bsize:   uint64(stat.Bsize),
+		Frsize:  uint64(stat.Bsize),
+		Blocks:  stat.Blocks,
+		Bfree:   stat.Bfree,
+		Bavail:  stat.Bavail,
+		Files:   stat.Files,
+		Ffree:   stat.Ffree,
+		Favail:  stat.Ffree,
+		Flag:    uint64(stat.Flags),
+		Namemax: 255,
+	}, nil
+}
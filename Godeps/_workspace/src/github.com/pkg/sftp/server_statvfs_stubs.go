@@ -0,0 +1,9 @@
+// +build !darwin,!linux
+
+package sftp
+
+import "syscall"
+
+func statvfs(path string) (*StatVFS, error) {
+	return nil, syscall.ENOTSUP
+}
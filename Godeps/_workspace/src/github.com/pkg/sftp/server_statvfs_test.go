@@ -0,0 +1,29 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestServerStatVFS(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("statvfs is only served on linux and darwin")
+	}
+	root, _ := testRootDirs(t)
+	defer os.RemoveAll(filepath.Dir(root))
+	client := testServerClient(t, root, ConfineToRoot())
+	defer client.Close()
+
+	vfs, err := client.StatVFS("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vfs.TotalSpace() == 0 || vfs.FreeSpace() > vfs.TotalSpace() {
+		t.Errorf("StatVFS(/): want free space within a non zero total, got %d of %d", vfs.FreeSpace(), vfs.TotalSpace())
+	}
+	if _, err := client.StatVFS("/missing"); err == nil {
+		t.Errorf("StatVFS(/missing) succeeded")
+	}
+}
@@ -0,0 +1,65 @@
+package sftp
+
+// the workers of a server
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+// Workers sets how many requests of a session are worked on in parallel.
+// Requests on the same handle are always worked on in the order they arrived.
+func Workers(n int) func(*Server) error {
+	return func(svr *Server) error {
+		if n < 1 {
+			return errors.New("sftp: at least one worker is required")
+		}
+		svr.workerCount = n
+		return nil
+	}
+}
+
+// handlePacket is a request on an open handle
+type handlePacket interface {
+	handle() string
+}
+
+func (p sshFxpClosePacket) handle() string    { return p.Handle }
+func (p sshFxpReadPacket) handle() string     { return p.Handle }
+func (p sshFxpWritePacket) handle() string    { return p.Handle }
+func (p sshFxpFstatPacket) handle() string    { return p.Handle }
+func (p sshFxpFsetstatPacket) handle() string { return p.Handle }
+func (p sshFxpReaddirPacket) handle() string  { return p.Handle }
+
+func (svr *Server) startWorkers() {
+	svr.pktChan = make(chan rxPacket, svr.workerCount)
+	svr.handlePkts = make([]chan rxPacket, svr.workerCount)
+	svr.workers.Add(svr.workerCount)
+	for i := range svr.handlePkts {
+		svr.handlePkts[i] = make(chan rxPacket, 1)
+		go func(handlePkts chan rxPacket) {
+			defer svr.workers.Done()
+			svr.sftpServerWorker(handlePkts)
+		}(svr.handlePkts[i])
+	}
+}
+
+func (svr *Server) closeWorkers() {
+	close(svr.pktChan)
+	for _, handlePkts := range svr.handlePkts {
+		close(handlePkts)
+	}
+}
+
+// dispatch hands a request on a handle to the worker the handle is sharded
+// to, so that its requests are responded to in order, and any other request
+// to the first worker free
+func (svr *Server) dispatch(rx rxPacket) {
+	if p, ok := rx.pkt.(handlePacket); ok {
+		h := fnv.New32a()
+		h.Write([]byte(p.handle()))
+		svr.handlePkts[h.Sum32()%uint32(len(svr.handlePkts))] <- rx
+		return
+	}
+	svr.pktChan <- rx
+}
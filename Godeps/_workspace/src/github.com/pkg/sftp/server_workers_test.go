@@ -0,0 +1,78 @@
+package sftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// trackingFS records how many reads of each file are in flight at once
+type trackingFS struct {
+	*MemFS
+	mu       sync.Mutex
+	inflight map[string]int
+	max      map[string]int
+}
+
+type trackingFile struct {
+	ServerFile
+	fs *trackingFS
+}
+
+func (fs *trackingFS) OpenFile(name string, flag int, perm os.FileMode) (ServerFile, error) {
+	f, err := fs.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &trackingFile{f, fs}, nil
+}
+
+func (f *trackingFile) ReadAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	f.fs.inflight[f.Name()]++
+	if f.fs.inflight[f.Name()] > f.fs.max[f.Name()] {
+		f.fs.max[f.Name()] = f.fs.inflight[f.Name()]
+	}
+	f.fs.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	defer func() {
+		f.fs.mu.Lock()
+		f.fs.inflight[f.Name()]--
+		f.fs.mu.Unlock()
+	}()
+	return f.ServerFile.ReadAt(b, off)
+}
+
+func TestServerHandleOrdering(t *testing.T) {
+	fs := &trackingFS{MemFS: NewMemFS(), inflight: map[string]int{}, max: map[string]int{}}
+	contents := bytes.Repeat([]byte("x"), 1<<20)
+	w, _ := fs.MemFS.OpenFile("/artifact", os.O_WRONLY|os.O_CREATE, 0644)
+	w.WriteAt(contents, 0)
+	client := testServerClient(t, "/", ServeFS(fs), Workers(4))
+	defer client.Close()
+
+	f, err := client.Open("/artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var read bytes.Buffer
+	// WriteTo keeps many reads of the handle outstanding
+	if _, err := f.WriteTo(&read); err != nil || !bytes.Equal(read.Bytes(), contents) {
+		t.Fatalf("reading /artifact: %d bytes, %v", read.Len(), err)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.max["/artifact"] != 1 {
+		t.Errorf("want the reads of one handle worked on in order, got %d at once", fs.max["/artifact"])
+	}
+}
+
+func TestServerWorkers(t *testing.T) {
+	if _, err := NewServer(bytes.NewReader(nil), nil, ioutil.Discard, 0, false, "/", Workers(0)); err == nil {
+		t.Errorf("a server without workers was created")
+	}
+}
@@ -46,6 +46,10 @@ const (
 	ssh_FX_NO_CONNECTION     = 6
 	ssh_FX_CONNECTION_LOST   = 7
 	ssh_FX_OP_UNSUPPORTED    = 8
+
+	// from later versions of the protocol, which version 3 clients take as
+	// a failure
+	ssh_FX_FILE_ALREADY_EXISTS = 11
 )
 
 const (
@@ -142,6 +146,8 @@ func (f fx) String() string {
 		return "SSH_FX_CONNECTION_LOST"
 	case ssh_FX_OP_UNSUPPORTED:
 		return "SSH_FX_OP_UNSUPPORTED"
+	case ssh_FX_FILE_ALREADY_EXISTS:
+		return "SSH_FX_FILE_ALREADY_EXISTS"
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,106 @@
+package sftp
+
+// mapping between go errors and sftp status codes
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// statusErrors are the errors each status code stands for, the server
+// replying with the first of them matching a failure
+var statusErrors = []struct {
+	code uint32
+	errs []error
+}{
+	{ssh_FX_EOF, []error{io.EOF}},
+	{ssh_FX_NO_SUCH_FILE, []error{os.ErrNotExist}},
+	{ssh_FX_PERMISSION_DENIED, []error{os.ErrPermission}},
+	{ssh_FX_BAD_MESSAGE, []error{shortPacketError, longPacketError, trailingPacketError}},
+	{ssh_FX_OP_UNSUPPORTED, []error{syscall.ENOTSUP, syscall.ENOSYS}},
+	{ssh_FX_FILE_ALREADY_EXISTS, []error{os.ErrExist}},
+}
+
+// statusCode is the status code a server replies to a failure with. Errors
+// wrapping a *StatusError, e.g. from a filesystem backed by another sftp
+// server, keep their code.
+func statusCode(err error) uint32 {
+	var status *StatusError
+	if err == nil {
+		return ssh_FX_OK
+	} else if errors.As(err, &status) {
+		return status.Code
+	}
+	for _, s := range statusErrors {
+		for _, target := range s.errs {
+			if errors.Is(err, target) {
+				return s.code
+			}
+		}
+	}
+	return ssh_FX_FAILURE
+}
+
+// Is reports whether a status stands for the target error, so that
+// errors.Is(err, os.ErrNotExist) holds when a server found no such file.
+func (s *StatusError) Is(target error) bool {
+	for _, status := range statusErrors {
+		if status.code == s.Code {
+			return target == status.errs[0]
+		}
+	}
+	return false
+}
+
+// pathError reports the status a server replied to an operation on a path
+// with as package os would, so that os.IsNotExist, os.IsPermission and
+// os.IsExist hold as they do for local files. A status of ssh_FX_OK is no
+// error.
+func pathError(op, path string, err error) error {
+	if err = statusError(err); err == nil {
+		return nil
+	} else if _, ok := err.(*unexpectedIdErr); ok {
+		return err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// readError is pathError for the status a read of path ended with, which is
+// io.EOF at the end of the file
+func readError(path string, err error) error {
+	if err = eofOrErr(err); err == io.EOF {
+		return err
+	}
+	return pathError("read", path, err)
+}
+
+// linkError is pathError for the operations on two paths
+func linkError(op, oldname, newname string, err error) error {
+	if err = statusError(err); err == nil {
+		return nil
+	} else if _, ok := err.(*unexpectedIdErr); ok {
+		return err
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+}
+
+// statusError replaces the status codes package os has errors for with them
+func statusError(err error) error {
+	status, ok := err.(*StatusError)
+	if !ok {
+		return err
+	}
+	switch status.Code {
+	case ssh_FX_OK:
+		return nil
+	case ssh_FX_NO_SUCH_FILE:
+		return os.ErrNotExist
+	case ssh_FX_PERMISSION_DENIED:
+		return os.ErrPermission
+	case ssh_FX_FILE_ALREADY_EXISTS:
+		return os.ErrExist
+	}
+	return status
+}
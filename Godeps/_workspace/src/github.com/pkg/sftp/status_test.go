@@ -0,0 +1,107 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+var statusCodeTests = []struct {
+	err  error
+	want uint32
+}{
+	{nil, ssh_FX_OK},
+	{io.EOF, ssh_FX_EOF},
+	{syscall.ENOENT, ssh_FX_NO_SUCH_FILE},
+	{&os.PathError{Op: "open", Path: "/missing", Err: syscall.ENOENT}, ssh_FX_NO_SUCH_FILE},
+	{&os.LinkError{Op: "rename", Old: "/a", New: "/b", Err: os.ErrNotExist}, ssh_FX_NO_SUCH_FILE},
+	{syscall.EPERM, ssh_FX_PERMISSION_DENIED},
+	{&os.PathError{Op: "open", Path: "/secret", Err: syscall.EACCES}, ssh_FX_PERMISSION_DENIED},
+	{fmt.Errorf("read: %w", io.EOF), ssh_FX_EOF},
+	{syscall.ENOTSUP, ssh_FX_OP_UNSUPPORTED},
+	{&os.PathError{Op: "mkdir", Path: "/dir", Err: syscall.EEXIST}, ssh_FX_FILE_ALREADY_EXISTS},
+	{trailingPacketError, ssh_FX_BAD_MESSAGE},
+	{fmt.Errorf("remote: %w", &StatusError{Code: ssh_FX_NO_CONNECTION}), ssh_FX_NO_CONNECTION},
+	{syscall.EISDIR, ssh_FX_FAILURE},
+	{errors.New("disk on fire"), ssh_FX_FAILURE},
+}
+
+func TestStatusCode(t *testing.T) {
+	for _, tt := range statusCodeTests {
+		if got := statusCode(tt.err); got != tt.want {
+			t.Errorf("statusCode(%#v): want: %v, got: %v", tt.err, fx(tt.want), fx(got))
+		}
+	}
+}
+
+func TestStatusErrorIs(t *testing.T) {
+	if !errors.Is(&StatusError{Code: ssh_FX_NO_SUCH_FILE}, os.ErrNotExist) {
+		t.Errorf("no such file is not os.ErrNotExist")
+	}
+	if !errors.Is(&StatusError{Code: ssh_FX_PERMISSION_DENIED}, os.ErrPermission) {
+		t.Errorf("permission denied is not os.ErrPermission")
+	}
+	if !errors.Is(&StatusError{Code: ssh_FX_FILE_ALREADY_EXISTS}, os.ErrExist) {
+		t.Errorf("file already exists is not os.ErrExist")
+	}
+	if !errors.Is(&StatusError{Code: ssh_FX_EOF}, io.EOF) {
+		t.Errorf("eof is not io.EOF")
+	}
+	if errors.Is(&StatusError{Code: ssh_FX_FAILURE}, os.ErrNotExist) {
+		t.Errorf("failure is os.ErrNotExist")
+	}
+}
+
+func TestClientStatusErrors(t *testing.T) {
+	client, _ := testMemFSClient(t)
+	defer client.Close()
+
+	if _, err := client.Stat("/missing"); !os.IsNotExist(err) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(/missing): want not exist, got %#v", err)
+	} else if pathErr, ok := err.(*os.PathError); !ok || pathErr.Op != "stat" || pathErr.Path != "/missing" {
+		t.Errorf("Stat(/missing): want a stat path error, got %#v", err)
+	}
+	if _, err := client.Open("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Open(/missing): want not exist, got %#v", err)
+	}
+	if err := client.Rename("/missing", "/moved"); !os.IsNotExist(err) {
+		t.Errorf("Rename(/missing): want not exist, got %#v", err)
+	}
+	if err := client.Remove("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Remove(/missing): want not exist, got %#v", err)
+	}
+	if err := client.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Mkdir("/dir"); !os.IsExist(err) {
+		t.Errorf("Mkdir(/dir) again: want exist, got %#v", err)
+	}
+	if _, err := client.ReadDir("/dir"); err != nil {
+		t.Errorf("ReadDir(/dir): want no error, got %#v", err)
+	}
+
+	f, err := client.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := f.Stat(); err == nil {
+		t.Errorf("Stat of a closed file: want an error")
+	} else if pathErr, ok := err.(*os.PathError); !ok || pathErr.Op != "stat" || pathErr.Path != "/file" {
+		t.Errorf("Stat of a closed file: want a stat path error, got %#v", err)
+	}
+	if err := f.Close(); err == nil {
+		t.Errorf("Close of a closed file: want an error")
+	} else if pathErr, ok := err.(*os.PathError); !ok || pathErr.Op != "close" || pathErr.Path != "/file" {
+		t.Errorf("Close of a closed file: want a close path error, got %#v", err)
+	}
+
+	_, err = client.StatVFS("/")
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != ssh_FX_OP_UNSUPPORTED {
+		t.Errorf("StatVFS(/): want %v, got %#v", fx(ssh_FX_OP_UNSUPPORTED), err)
+	}
+}
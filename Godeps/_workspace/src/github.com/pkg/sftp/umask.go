@@ -0,0 +1,55 @@
+package sftp
+
+// masking the modes files and directories are created with
+
+import (
+	"fmt"
+	"os"
+)
+
+// Umask has the client create files with mode 0666 and directories with
+// mode 0777, less the bits of mask, e.g. 0077 for files only their owner
+// reads. The modes are sent with each request creating a file or a
+// directory, so they do not depend on the default modes of the server; its
+// umask can still take bits away. Without it the server chooses the modes.
+func Umask(mask os.FileMode) func(*Client) error {
+	return func(c *Client) error {
+		if mask&^os.ModePerm != 0 {
+			return fmt.Errorf("umask %v has bits other than permissions", mask)
+		}
+		c.umask = mask
+		c.umasked = true
+		return nil
+	}
+}
+
+// ServerUmask has the server take the bits of mask away from the mode of
+// every file and directory it creates, whatever mode the client asked for.
+func ServerUmask(mask os.FileMode) func(*Server) error {
+	return func(svr *Server) error {
+		if mask&^os.ModePerm != 0 {
+			return fmt.Errorf("sftp: umask %v has bits other than permissions", mask)
+		}
+		svr.umask = mask
+		return nil
+	}
+}
+
+// createMode is the mode the server creates a file or directory with, that
+// of the request if it has one or else def, less the umask
+func (svr *Server) createMode(flags, perm uint32, def os.FileMode) os.FileMode {
+	mode := def
+	if flags&ssh_FILEXFER_ATTR_PERMISSIONS != 0 {
+		mode = os.FileMode(perm) & os.ModePerm
+	}
+	return mode &^ svr.umask
+}
+
+// createAttrs are the attributes of a request creating a file or directory
+// with mode less the umask, none when the client was not given a Umask
+func (c *Client) createAttrs(mode os.FileMode) (flags, perm uint32) {
+	if !c.umasked {
+		return 0, 0
+	}
+	return ssh_FILEXFER_ATTR_PERMISSIONS, uint32(mode &^ c.umask)
+}
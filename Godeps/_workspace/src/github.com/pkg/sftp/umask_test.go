@@ -0,0 +1,62 @@
+package sftp
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testUmaskModes creates a file and a directory through a client with the
+// options on a MemFS server with the server options, returning their modes
+func testUmaskModes(t *testing.T, options []func(*Client) error, serverOptions ...func(*Server) error) (file, dir os.FileMode) {
+	cr, sw, _ := os.Pipe()
+	sr, cw, _ := os.Pipe()
+	svr, err := NewServer(sr, sw, ioutil.Discard, 0, false, "/", append([]func(*Server) error{ServeFS(NewMemFS())}, serverOptions...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve()
+	client, err := NewClientPipe(cr, cw, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	f, err := client.Create("/ccdb.backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := client.Mkdir("/nfs_server"); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, _ := client.Stat("/ccdb.backup")
+	dirInfo, _ := client.Stat("/nfs_server")
+	return fileInfo.Mode().Perm(), dirInfo.Mode().Perm()
+}
+
+func TestUmaskDefaultModes(t *testing.T) {
+	if file, dir := testUmaskModes(t, nil); file != 0644 || dir != 0755 {
+		t.Errorf("want the modes of the server, got %v and %v", file, dir)
+	}
+}
+
+func TestUmaskClient(t *testing.T) {
+	if file, dir := testUmaskModes(t, []func(*Client) error{Umask(0077)}); file != 0600 || dir != 0700 {
+		t.Errorf("want 0600 and 0700, got %v and %v", file, dir)
+	}
+}
+
+func TestUmaskServer(t *testing.T) {
+	if file, dir := testUmaskModes(t, []func(*Client) error{Umask(0)}, ServerUmask(0027)); file != 0640 || dir != 0750 {
+		t.Errorf("want 0640 and 0750, got %v and %v", file, dir)
+	}
+}
+
+func TestUmaskOnlyPermissions(t *testing.T) {
+	cr, cw, _ := os.Pipe()
+	defer cr.Close()
+	if _, err := NewClientPipe(cr, cw, Umask(os.ModeSetuid)); err == nil {
+		t.Error("want a umask of other bits refused")
+	}
+}
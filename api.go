@@ -0,0 +1,249 @@
+package cfops
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	EventsPath    = "/events"
+	SchedulesPath = "/schedules/"
+	RunsPath      = "/runs/"
+
+	// RoleViewer can read the schedules and follow runs, RoleOperator can also
+	// trigger backups
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+
+	ErrNoAPICallersMsg   = "the api needs at least one token or client certificate in the config"
+	ErrUnknownRoleFormat = "api caller %s has unknown role %q, expected viewer or operator"
+	ErrEmptyTokenFormat  = "api caller %s has an empty token"
+
+	// sseKeepalive is how often an idle event stream is sent a comment, so
+	// proxies don't close it
+	sseKeepalive = 15 * time.Second
+)
+
+var (
+	ErrNoAPICallers = errors.New(ErrNoAPICallersMsg)
+	roleRanks       = map[string]int{RoleViewer: 1, RoleOperator: 2}
+)
+
+type (
+	// APIConfig lists who may call the api: holders of a bearer token, and
+	// clients presenting a certificate with a given common name over tls
+	APIConfig struct {
+		Tokens  []APIToken  `yaml:"tokens"`
+		Clients []APIClient `yaml:"clients"`
+	}
+
+	APIToken struct {
+		Name  string `yaml:"name"`
+		Token string `yaml:"token"`
+		Role  string `yaml:"role"`
+	}
+
+	APIClient struct {
+		CommonName string `yaml:"cn"`
+		Role       string `yaml:"role"`
+	}
+
+	apiHandler struct {
+		scheduler *Scheduler
+		config    APIConfig
+	}
+)
+
+func ErrUnknownRole(caller, role string) error {
+	return fmt.Errorf(ErrUnknownRoleFormat, caller, role)
+}
+
+func ErrEmptyToken(caller string) error {
+	return fmt.Errorf(ErrEmptyTokenFormat, caller)
+}
+
+// Validate checks the api has callers, that each token is set and that each
+// caller has a known role
+func (s APIConfig) Validate() (err error) {
+	if len(s.Tokens) == 0 && len(s.Clients) == 0 {
+		return ErrNoAPICallers
+	}
+
+	for _, token := range s.Tokens {
+		if token.Token == "" {
+			return ErrEmptyToken(token.Name)
+		}
+
+		if roleRanks[token.Role] == 0 {
+			return ErrUnknownRole(token.Name, token.Role)
+		}
+	}
+
+	for _, client := range s.Clients {
+		if roleRanks[client.Role] == 0 {
+			return ErrUnknownRole(client.CommonName, client.Role)
+		}
+	}
+	return
+}
+
+// caller identifies the caller of a request by its bearer token or, over tls,
+// by the common name of its verified client certificate. An empty token never
+// identifies anyone, even when the config has one
+func (s APIConfig) caller(r *http.Request) (name, role string) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		presented := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+		if len(presented) == 0 {
+			return
+		}
+
+		for _, token := range s.Tokens {
+			if token.Token != "" && subtle.ConstantTimeCompare(presented, []byte(token.Token)) == 1 {
+				return token.Name, token.Role
+			}
+		}
+		return
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+		for _, client := range s.Clients {
+			if client.CommonName == commonName {
+				return commonName, client.Role
+			}
+		}
+	}
+	return
+}
+
+// NewAPIHandler serves the api of the scheduler daemon to the callers of the
+// config:
+//
+//	GET  /events                 (viewer) streams the progress of runs as server
+//	                             sent events, of a single run with ?run_id=
+//	GET  /schedules/             (viewer) the state of every schedule
+//	POST /schedules/<name>/run   (operator) queues a run of the schedule now
+//	GET  /runs/                  (viewer) the queued runs and the history of runs
+func NewAPIHandler(scheduler *Scheduler, config APIConfig) http.Handler {
+	handler := &apiHandler{scheduler: scheduler, config: config}
+	mux := http.NewServeMux()
+	mux.HandleFunc(EventsPath, handler.authorize(RoleViewer, "GET", serveEvents))
+	mux.HandleFunc(SchedulesPath, handler.serveSchedules)
+	mux.HandleFunc(RunsPath, handler.authorize(RoleViewer, "GET", handler.serveRuns))
+	return mux
+}
+
+// authorize only lets callers holding at least the role use the method
+func (s *apiHandler) authorize(role, method string, handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, callerRole := s.config.caller(r)
+
+		switch {
+		case r.Method != method:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		case name == "":
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		case roleRanks[callerRole] < roleRanks[role]:
+			lo.G.Info("refused %s %s to %s, a %s", r.Method, r.URL.Path, name, callerRole)
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+		default:
+			lo.G.Debug("%s %s by %s", r.Method, r.URL.Path, name)
+			handle(w, r)
+		}
+	}
+}
+
+func (s *apiHandler) serveSchedules(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, SchedulesPath)
+
+	switch {
+	case name == "":
+		s.authorize(RoleViewer, "GET", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, s.scheduler.Status())
+		})(w, r)
+
+	case strings.HasSuffix(name, "/run"):
+		s.authorize(RoleOperator, "POST", func(w http.ResponseWriter, r *http.Request) {
+			s.triggerSchedule(w, r, strings.TrimSuffix(name, "/run"))
+		})(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *apiHandler) triggerSchedule(w http.ResponseWriter, r *http.Request, name string) {
+	caller, _ := s.config.caller(r)
+
+	if !s.scheduler.Has(name) {
+		http.Error(w, ErrUnknownSchedule(name).Error(), http.StatusNotFound)
+
+	} else if err := s.scheduler.Trigger(name, caller); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+	} else {
+		lo.G.Info("%s triggered schedule %s", caller, name)
+		writeJSON(w, http.StatusAccepted, map[string]string{"queued": name})
+	}
+}
+
+func (s *apiHandler) serveRuns(w http.ResponseWriter, r *http.Request) {
+	queue, history := s.scheduler.State().Snapshot()
+	writeJSON(w, http.StatusOK, map[string][]ScheduledRun{"queue": queue, "history": history})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	runID := r.URL.Query().Get("run_id")
+	subscription, cancel := SubscribeEvents()
+	defer cancel()
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+
+		case event := <-subscription:
+			if runID != "" && event.RunID != runID {
+				continue
+			}
+			contents, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, contents)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,146 @@
+package cfops_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API", func() {
+	var (
+		server    *httptest.Server
+		scheduler *Scheduler
+		dir       string
+	)
+
+	call := func(method, path, token string) (resp *http.Response) {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		return
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "api")
+		job := ScheduledJob{Entry: ScheduleEntry{Name: "nightly", Cron: "@daily"}, Schedule: everySchedule(time.Hour)}
+		state, _ := OpenSchedulerState("")
+		scheduler = NewScheduler([]ScheduledJob{job}, state, func(context.Context, ScheduledJob) error { return nil })
+		server = httptest.NewServer(NewAPIHandler(scheduler, APIConfig{Tokens: []APIToken{
+			{Name: "dashboard", Token: "viewer-token", Role: RoleViewer},
+			{Name: "ci", Token: "operator-token", Role: RoleOperator},
+		}}))
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				return &mockTile{}, nil
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("APIConfig", func() {
+		It("should require callers with known roles", func() {
+			Ω(APIConfig{}.Validate()).Should(Equal(ErrNoAPICallers))
+			Ω(APIConfig{Tokens: []APIToken{{Name: "ci", Token: "secret", Role: "root"}}}.Validate()).Should(Equal(ErrUnknownRole("ci", "root")))
+			Ω(APIConfig{Clients: []APIClient{{CommonName: "ops", Role: "admin"}}}.Validate()).Should(Equal(ErrUnknownRole("ops", "admin")))
+		})
+
+		It("should refuse a token that is empty", func() {
+			Ω(APIConfig{Tokens: []APIToken{{Name: "ci", Role: RoleOperator}}}.Validate()).Should(Equal(ErrEmptyToken("ci")))
+		})
+	})
+
+	Describe("authorization", func() {
+		It("should refuse callers without a known token", func() {
+			Ω(call("GET", SchedulesPath, "").StatusCode).Should(Equal(http.StatusUnauthorized))
+			Ω(call("GET", SchedulesPath, "guess").StatusCode).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("should refuse an empty bearer token even when the config has one", func() {
+			server.Close()
+			server = httptest.NewServer(NewAPIHandler(scheduler, APIConfig{Tokens: []APIToken{{Name: "unset", Role: RoleOperator}}}))
+			req, _ := http.NewRequest("GET", server.URL+SchedulesPath, nil)
+			req.Header.Set("Authorization", "Bearer ")
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("should only let operators trigger a backup", func() {
+			Ω(call("POST", SchedulesPath+"nightly/run", "viewer-token").StatusCode).Should(Equal(http.StatusForbidden))
+			Ω(call("POST", SchedulesPath+"nightly/run", "operator-token").StatusCode).Should(Equal(http.StatusAccepted))
+		})
+	})
+
+	Describe("/schedules/", func() {
+		It("should report a triggered schedule as queued and refuse to queue it twice", func() {
+			Ω(call("POST", SchedulesPath+"nightly/run", "operator-token").StatusCode).Should(Equal(http.StatusAccepted))
+			Ω(call("POST", SchedulesPath+"nightly/run", "operator-token").StatusCode).Should(Equal(http.StatusConflict))
+			Ω(call("POST", SchedulesPath+"weekly/run", "operator-token").StatusCode).Should(Equal(http.StatusNotFound))
+
+			var statuses []ScheduleStatus
+			resp := call("GET", SchedulesPath, "viewer-token")
+			Ω(json.NewDecoder(resp.Body).Decode(&statuses)).Should(BeNil())
+			Ω(statuses).Should(HaveLen(1))
+			Ω(statuses[0].Queued).Should(BeTrue())
+		})
+	})
+
+	Describe("/runs/", func() {
+		It("should list the queued runs with who triggered them", func() {
+			call("POST", SchedulesPath+"nightly/run", "operator-token")
+			var runs map[string][]ScheduledRun
+			resp := call("GET", RunsPath, "viewer-token")
+			Ω(json.NewDecoder(resp.Body).Decode(&runs)).Should(BeNil())
+			Ω(runs["queue"]).Should(HaveLen(1))
+			Ω(runs["queue"][0].TriggerBy).Should(Equal("ci"))
+		})
+	})
+
+	Describe("GET /events", func() {
+		It("should stream the progress of a run as server sent events", func() {
+			resp := call("GET", EventsPath, "viewer-token")
+			defer resp.Body.Close()
+			Ω(resp.Header.Get("Content-Type")).Should(Equal("text/event-stream"))
+
+			RunPipeline(&mockFlagSet{tileListFlag: "opsmanager", dest: dir}, Backup)
+			reader := bufio.NewReader(resp.Body)
+			var received []Event
+
+			for len(received) == 0 || received[len(received)-1].Type != EventRunFinished {
+				line, err := reader.ReadString('\n')
+				Ω(err).Should(BeNil())
+
+				if strings.HasPrefix(line, "data: ") {
+					var event Event
+					Ω(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)).Should(BeNil())
+					received = append(received, event)
+				}
+			}
+			Ω(received[0].Type).Should(Equal(EventRunStarted))
+			Ω(received[1].Type).Should(Equal(EventTaskStarted))
+			Ω(received[1].Task).Should(Equal("OPSMANAGER"))
+			Ω(received[2].Type).Should(Equal(EventTaskFinished))
+			Ω(received[3].Message).Should(Equal(SetComplete))
+			Ω(received[3].RunID).Should(Equal(received[0].RunID))
+		})
+	})
+})
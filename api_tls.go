@@ -0,0 +1,129 @@
+package cfops
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrAPICertificateMsg = "the api needs both a certificate and a key, or a self signed certificate"
+	selfSignedValidity   = 365 * 24 * time.Hour
+)
+
+var ErrAPICertificate = errors.New(ErrAPICertificateMsg)
+
+// APITLS describes how the api is served over tls: from a pem certificate
+// and key, or from a self signed certificate generated at start up for labs.
+// ClientCAFile, when set, lets callers identify with client certificates
+// signed by those authorities
+type APITLS struct {
+	CertFile     string
+	KeyFile      string
+	SelfSigned   bool
+	ClientCAFile string
+}
+
+// Enabled tells whether the api should be served over tls at all
+func (s APITLS) Enabled() bool {
+	return s.CertFile != "" || s.KeyFile != "" || s.SelfSigned
+}
+
+// Config builds the tls config of the api server
+func (s APITLS) Config() (config *tls.Config, err error) {
+	var certificate tls.Certificate
+
+	switch {
+	case s.CertFile != "" && s.KeyFile != "":
+		certificate, err = tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+
+	case s.SelfSigned && s.CertFile == "" && s.KeyFile == "":
+		lo.G.Info("serving the api with a self signed certificate")
+		certificate, err = SelfSignedCertificate()
+
+	default:
+		err = ErrAPICertificate
+	}
+
+	if err != nil {
+		return
+	}
+	config = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.ClientCAFile != "" {
+		if config.ClientCAs, err = loadCertPool(s.ClientCAFile); err != nil {
+			return nil, err
+		}
+		// tokens still work for callers without a certificate
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return
+}
+
+// SelfSignedCertificate generates a certificate for the hostname of this host
+// and localhost, valid for a year
+func SelfSignedCertificate() (certificate tls.Certificate, err error) {
+	var (
+		key    *ecdsa.PrivateKey
+		serial *big.Int
+		der    []byte
+	)
+	hostname, _ := os.Hostname()
+
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+
+	if serial, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)); err != nil {
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname, Organization: []string{"cfops"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{hostname, "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	if der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key); err == nil {
+		certificate = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	return
+}
+
+// RedirectToHTTPS redirects every request to the same path on the https port
+// of the api
+func RedirectToHTTPS(httpsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,44 @@
+package cfops_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API over tls", func() {
+	Describe("APITLS", func() {
+		It("should serve a self signed certificate", func() {
+			config, err := APITLS{SelfSigned: true}.Config()
+			Ω(err).Should(BeNil())
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = config
+			server.StartTLS()
+			defer server.Close()
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+			resp, err := client.Get(server.URL)
+			Ω(err).Should(BeNil())
+			Ω(resp.TLS.PeerCertificates[0].DNSNames).Should(ContainElement("localhost"))
+		})
+
+		It("should need a key along with a certificate", func() {
+			_, err := APITLS{CertFile: "api.pem"}.Config()
+			Ω(err).Should(Equal(ErrAPICertificate))
+		})
+	})
+
+	Describe("RedirectToHTTPS", func() {
+		It("should send plain http requests to the same path on the https port", func() {
+			recorder := httptest.NewRecorder()
+			RedirectToHTTPS(":8443").ServeHTTP(recorder, httptest.NewRequest("GET", "http://backups.example.com/schedules/?x=1", nil))
+			Ω(recorder.Code).Should(Equal(http.StatusMovedPermanently))
+			Ω(recorder.Header().Get("Location")).Should(Equal("https://backups.example.com:8443/schedules/?x=1"))
+		})
+	})
+})
@@ -0,0 +1,230 @@
+package cfops
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/gtils/retry"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// DefaultApplyChangesTimeout bounds how long a restore waits for the
+	// installation it started
+	DefaultApplyChangesTimeout = 4 * time.Hour
+	ErrApplyChangesFormat      = "ops manager %s responded with %s: %s"
+	ErrInstallationFormat      = "apply changes (installation %d) finished as %s, see the installation log in ops manager"
+	ErrInstallationTimeoutFmt  = "apply changes (installation %d) still running after %s"
+	installationsPath          = "/api/v0/installations"
+	legacyInstallationPath     = "/api/installation"
+	installationRunning        = "running"
+)
+
+var (
+	applyChangesClient = &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	// installationSucceeded are the statuses ops manager reports a completed
+	// installation with, across versions
+	installationSucceeded = map[string]bool{"succeeded": true, "success": true}
+)
+
+type (
+	// ApplyChangesConfig describes the apply changes a restore starts once the
+	// installation settings are imported
+	ApplyChangesConfig struct {
+		Enabled bool
+		// Products are the guids of the products to deploy, all of them when
+		// empty or when ops manager cannot deploy selectively
+		Products     []string
+		Timeout      time.Duration
+		PollInterval time.Duration
+	}
+
+	// ApplyChangesResult is the outcome of the apply changes a restore started
+	ApplyChangesResult struct {
+		Installation int      `json:"installation"`
+		Status       string   `json:"status"`
+		Products     []string `json:"products,omitempty"`
+		Seconds      float64  `json:"seconds"`
+	}
+
+	opsManagerClient struct {
+		base string
+		user string
+		pass string
+	}
+
+	installationResponse struct {
+		Install struct {
+			ID int `json:"id"`
+		} `json:"install"`
+		Status string `json:"status"`
+	}
+)
+
+func ErrApplyChanges(request, status, body string) error {
+	return fmt.Errorf(ErrApplyChangesFormat, request, status, body)
+}
+
+func ErrInstallation(id int, status string) error {
+	return fmt.Errorf(ErrInstallationFormat, id, status)
+}
+
+func ErrInstallationTimeout(id int, timeout time.Duration) error {
+	return fmt.Errorf(ErrInstallationTimeoutFmt, id, timeout)
+}
+
+// ApplyChanges starts an installation on ops manager, deploying only the
+// configured products where ops manager supports it, and polls it until it
+// finishes, fails, times out or the context is cancelled
+func ApplyChanges(ctx context.Context, config ApplyChangesConfig, host, user, pass string) (result *ApplyChangesResult, err error) {
+	var statusPath string
+	client := &opsManagerClient{base: opsManagerBase(host), user: user, pass: pass}
+	started := time.Now()
+	result = &ApplyChangesResult{Products: config.Products}
+
+	if result.Installation, statusPath, err = client.startInstallation(config.Products); err != nil {
+		return
+	}
+	lo.G.Info("apply changes started as installation %d", result.Installation)
+
+	if result.Status, err = client.awaitInstallation(ctx, config, statusPath, result.Installation); err == nil && !installationSucceeded[result.Status] {
+		err = ErrInstallation(result.Installation, result.Status)
+	}
+	result.Seconds = time.Since(started).Seconds()
+	return
+}
+
+// opsManagerBase is the url of ops manager, over https unless the host names
+// its scheme
+func opsManagerBase(host string) string {
+	if strings.Contains(host, "://") {
+		return strings.TrimRight(host, "/")
+	}
+	return "https://" + host
+}
+
+// startInstallation starts deploying the products through the installations
+// api, falling back to the api of ops manager versions without one, which
+// always deploys every product
+func (s *opsManagerClient) startInstallation(products []string) (id int, statusPath string, err error) {
+	var (
+		body     []byte
+		response installationResponse
+		status   int
+	)
+	request := map[string]interface{}{"ignore_warnings": true, "deploy_products": "all"}
+
+	if len(products) > 0 {
+		request["deploy_products"] = products
+	}
+
+	if body, err = json.Marshal(request); err != nil {
+		return
+	}
+	statusPath = installationsPath
+
+	if status, err = s.do("POST", installationsPath, body, &response); status == http.StatusNotFound {
+		if len(products) > 0 {
+			warn("ops manager cannot deploy selected products, applying changes to every product")
+		}
+		statusPath = legacyInstallationPath
+		status, err = s.do("POST", legacyInstallationPath+"?ignore_warnings=true", nil, &response)
+	}
+
+	if err == nil && status/100 != 2 {
+		err = ErrApplyChanges("POST "+statusPath, http.StatusText(status), "")
+	}
+	id = response.Install.ID
+	return
+}
+
+func (s *opsManagerClient) awaitInstallation(ctx context.Context, config ApplyChangesConfig, statusPath string, id int) (status string, err error) {
+	interval, timeout := config.PollInterval, config.Timeout
+
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultApplyChangesTimeout
+	}
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var (
+			response installationResponse
+			code     int
+		)
+
+		if code, err = s.do("GET", fmt.Sprintf("%s/%d", statusPath, id), nil, &response); err == nil && code != http.StatusOK {
+			err = ErrApplyChanges(fmt.Sprintf("GET %s/%d", statusPath, id), http.StatusText(code), "")
+		}
+
+		if err != nil || response.Status != installationRunning {
+			return response.Status, err
+		}
+		lo.G.Debug("installation %d is still running", id)
+
+		select {
+		case <-ctx.Done():
+			return installationRunning, ctx.Err()
+
+		case <-deadline:
+			return installationRunning, ErrInstallationTimeout(id, timeout)
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// do sends a json request authenticated as the ops manager admin, decoding a
+// successful response into out. A request ops manager refuses the credentials
+// of is sent again once they are resolved again
+func (s *opsManagerClient) do(method, requestPath string, body []byte, out interface{}) (status int, err error) {
+	if status, err = s.send(method, requestPath, body, out); s.reauthenticate(status) {
+		status, err = s.send(method, requestPath, body, out)
+	}
+	return
+}
+
+func (s *opsManagerClient) send(method, requestPath string, body []byte, out interface{}) (status int, err error) {
+	var (
+		request  *http.Request
+		response *http.Response
+		contents []byte
+	)
+
+	if request, err = http.NewRequest(method, s.base+requestPath, bytes.NewReader(body)); err != nil {
+		return
+	}
+	request.SetBasicAuth(s.user, s.pass)
+	request.Header.Set("Content-Type", "application/json")
+
+	if response, err = retry.Default().Request(applyChangesClient, request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+	status = response.StatusCode
+
+	if contents, err = ioutil.ReadAll(response.Body); err != nil || status == http.StatusNotFound {
+		return
+	}
+
+	if status/100 != 2 {
+		return status, ErrApplyChanges(method+" "+requestPath, response.Status, strings.TrimSpace(string(contents)))
+	}
+	err = json.Unmarshal(contents, out)
+	return
+}
@@ -0,0 +1,139 @@
+package cfops_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyChanges", func() {
+	var (
+		server   *httptest.Server
+		mutex    sync.Mutex
+		requests []string
+		started  map[string]interface{}
+		statuses []string
+		hasV0    bool
+		user     string
+		config   ApplyChangesConfig
+	)
+
+	BeforeEach(func() {
+		requests, started, statuses, hasV0 = nil, nil, []string{"running", "succeeded"}, true
+		config = ApplyChangesConfig{Enabled: true, PollInterval: time.Millisecond, Timeout: time.Second}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			user, _, _ = r.BasicAuth()
+
+			switch {
+			case !hasV0 && r.URL.Path == "/api/v0/installations":
+				w.WriteHeader(http.StatusNotFound)
+
+			case r.Method == "POST":
+				body, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(body, &started)
+				fmt.Fprint(w, `{"install":{"id":42}}`)
+
+			default:
+				status := statuses[0]
+
+				if len(statuses) > 1 {
+					statuses = statuses[1:]
+				}
+				fmt.Fprintf(w, `{"status":%q}`, status)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should start an installation and wait for it to succeed", func() {
+		result, err := ApplyChanges(context.Background(), config, server.URL, "admin", "pass")
+		Ω(err).Should(BeNil())
+		Ω(result.Installation).Should(Equal(42))
+		Ω(result.Status).Should(Equal("succeeded"))
+		Ω(requests).Should(Equal([]string{"POST /api/v0/installations", "GET /api/v0/installations/42", "GET /api/v0/installations/42"}))
+		Ω(user).Should(Equal("admin"))
+		Ω(started["deploy_products"]).Should(Equal("all"))
+	})
+
+	It("should only deploy the selected products", func() {
+		config.Products = []string{"cf-0123"}
+		ApplyChanges(context.Background(), config, server.URL, "admin", "pass")
+		Ω(started["deploy_products"]).Should(Equal([]interface{}{"cf-0123"}))
+	})
+
+	It("should fall back to the api of ops managers without installations", func() {
+		hasV0 = false
+		_, err := ApplyChanges(context.Background(), config, server.URL, "admin", "pass")
+		Ω(err).Should(BeNil())
+		Ω(requests).Should(ContainElement("POST /api/installation"))
+		Ω(requests).Should(ContainElement("GET /api/installation/42"))
+	})
+
+	It("should fail when the installation fails", func() {
+		statuses = []string{"failed"}
+		_, err := ApplyChanges(context.Background(), config, server.URL, "admin", "pass")
+		Ω(err).Should(Equal(ErrInstallation(42, "failed")))
+	})
+
+	It("should give up once the timeout passes", func() {
+		statuses = []string{"running"}
+		config.Timeout = 20 * time.Millisecond
+		_, err := ApplyChanges(context.Background(), config, server.URL, "admin", "pass")
+		Ω(err).Should(Equal(ErrInstallationTimeout(42, config.Timeout)))
+	})
+
+	It("should stop waiting once cancelled", func() {
+		statuses = []string{"running"}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ApplyChanges(ctx, config, server.URL, "admin", "pass")
+		Ω(err).Should(Equal(context.Canceled))
+	})
+
+	Describe("running a restore", func() {
+		var fs *mockFlagSet
+
+		BeforeEach(func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+			fs = &mockFlagSet{host: server.URL, tileListFlag: "opsmanager", dest: tempDest(), applyChanges: config}
+		})
+
+		It("should apply changes once the restore completes", func() {
+			entry, err := RunPipelineResult(context.Background(), fs, Restore)
+			Ω(err).Should(BeNil())
+			Ω(entry.ApplyChanges.Status).Should(Equal("succeeded"))
+		})
+
+		It("should not complete a restore whose apply changes failed", func() {
+			statuses = []string{"failed"}
+			entry, err := RunPipelineResult(context.Background(), fs, Restore)
+			Ω(err).Should(Equal(ErrInstallation(42, "failed")))
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+		})
+
+		It("should not apply changes after a backup", func() {
+			RunPipeline(fs, Backup)
+			Ω(requests).Should(BeEmpty())
+		})
+	})
+})
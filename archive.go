@@ -0,0 +1,507 @@
+package cfops
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotalservices/gtils/retry"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// ArchiveName is the indexed tar a backup is packed into with --archive
+	ArchiveName = "cfops-backup.tar"
+	// ArchiveIndexName is the entry listing where every other entry of the
+	// archive starts, so a single artifact can be read without the rest
+	ArchiveIndexName           = "cfops-index.json"
+	archiveOffsetName          = "cfops-index.offset"
+	archiveOffsetSize          = 20
+	archiveBlock               = 512
+	ErrNotIndexedArchiveFormat = "%s is not an indexed cfops archive"
+	ErrArchiveEntryFormat      = "archive has no entry %s"
+	ErrRangeRequestFormat      = "range request for %s responded with %s"
+)
+
+type (
+	// ArchiveEntry is where the contents of an artifact start in the archive
+	ArchiveEntry struct {
+		Name   string `json:"name"`
+		Offset int64  `json:"offset"`
+		Size   int64  `json:"size"`
+	}
+
+	// ArchiveIndex lists the entries of an archive
+	ArchiveIndex struct {
+		Entries []ArchiveEntry `json:"entries"`
+	}
+
+	// Archive reads the entries of an indexed archive from anything that can
+	// read at an offset: a local file, an http url serving range requests, or
+	// an sftp file through NewSeekReaderAt
+	Archive struct {
+		Index  ArchiveIndex
+		reader io.ReaderAt
+	}
+
+	countingWriter struct {
+		io.Writer
+		n int64
+	}
+
+	seekReaderAt struct {
+		mutex  sync.Mutex
+		reader io.ReadSeeker
+	}
+
+	httpRangeReader struct {
+		url string
+	}
+
+	// rangeOpener streams a whole range at once, rather than in the small
+	// reads a reader at an offset is asked for
+	rangeOpener interface {
+		openRange(offset, size int64) (io.ReadCloser, error)
+	}
+)
+
+// archiveClient has no overall timeout, since streaming a large artifact over
+// http takes as long as it takes
+var archiveClient = &http.Client{}
+
+func ErrNotIndexedArchive(name string) error {
+	return fmt.Errorf(ErrNotIndexedArchiveFormat, name)
+}
+
+func ErrArchiveEntry(name string) error {
+	return fmt.Errorf(ErrArchiveEntryFormat, name)
+}
+
+func ErrRangeRequest(url, status string) error {
+	return fmt.Errorf(ErrRangeRequestFormat, url, status)
+}
+
+func (s *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = s.Writer.Write(p)
+	s.n += int64(n)
+	return
+}
+
+// WriteArchive writes the files of the root directory with the given relative
+// names as a tar, followed by an index of where each one starts. The result
+// is a plain tar any tar tool can extract. The index is the next to last
+// entry, the last one holds its offset at a fixed distance from the end
+func WriteArchive(w io.Writer, root string, names []string) (index ArchiveIndex, err error) {
+	counter := &countingWriter{Writer: w}
+	archive := tar.NewWriter(counter)
+	modTime := time.Now().Truncate(time.Second)
+
+	for _, name := range names {
+		if err = writeArchiveFile(archive, counter, &index, root, name); err != nil {
+			return
+		}
+	}
+
+	if err = archive.Flush(); err != nil {
+		return
+	}
+	indexOffset := counter.n
+	contents, _ := json.Marshal(index)
+
+	if err = writeArchiveEntry(archive, ArchiveIndexName, modTime, contents); err == nil {
+		offset := fmt.Sprintf("%0*d", archiveOffsetSize, indexOffset)
+
+		if err = writeArchiveEntry(archive, archiveOffsetName, modTime, []byte(offset)); err == nil {
+			err = archive.Close()
+		}
+	}
+	return
+}
+
+func writeArchiveFile(archive *tar.Writer, counter *countingWriter, index *ArchiveIndex, root, name string) (err error) {
+	var (
+		file *os.File
+		info os.FileInfo
+	)
+
+	if file, err = os.Open(path.Join(root, name)); err != nil {
+		return
+	}
+	defer file.Close()
+
+	if info, err = file.Stat(); err != nil {
+		return
+	}
+	header := &tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: info.ModTime().Truncate(time.Second)}
+
+	if err = archive.WriteHeader(header); err == nil {
+		index.Entries = append(index.Entries, ArchiveEntry{Name: name, Offset: counter.n, Size: info.Size()})
+		_, err = io.Copy(archive, file)
+	}
+	return
+}
+
+func writeArchiveEntry(archive *tar.Writer, name string, modTime time.Time, contents []byte) (err error) {
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(contents)), ModTime: modTime}
+
+	if err = archive.WriteHeader(header); err == nil {
+		_, err = archive.Write(contents)
+	}
+	return
+}
+
+// OpenArchive reads the index of an archive of the given size in two reads:
+// one of its last few blocks, then one of the index itself
+func OpenArchive(reader io.ReaderAt, size int64) (archive *Archive, err error) {
+	var (
+		contents []byte
+		offset   int64
+	)
+	// the offset entry is one header and one block, followed by the two
+	// blocks ending the tar
+	trailerSize := int64(4 * archiveBlock)
+
+	if size < trailerSize {
+		return nil, ErrNotIndexedArchive(ArchiveName)
+	}
+
+	if contents, err = readArchiveEntry(reader, size-trailerSize, trailerSize, archiveOffsetName); err != nil {
+		return
+	}
+
+	if offset, err = strconv.ParseInt(string(contents), 10, 64); err != nil || offset < 0 || offset > size-trailerSize {
+		return nil, ErrNotIndexedArchive(ArchiveName)
+	}
+
+	if contents, err = readArchiveEntry(reader, offset, size-trailerSize-offset, ArchiveIndexName); err == nil {
+		archive = &Archive{reader: reader}
+		err = json.Unmarshal(contents, &archive.Index)
+	}
+	return
+}
+
+// readArchiveEntry reads a whole section of the archive at once and returns
+// the contents of the entry it starts with, provided it has the given name
+func readArchiveEntry(reader io.ReaderAt, offset, size int64, name string) (contents []byte, err error) {
+	var header *tar.Header
+	section := make([]byte, size)
+
+	if _, err = reader.ReadAt(section, offset); err != nil && err != io.EOF {
+		return
+	}
+	entries := tar.NewReader(bytes.NewReader(section))
+
+	if header, err = entries.Next(); err != nil || header.Name != name {
+		return nil, ErrNotIndexedArchive(ArchiveName)
+	}
+	return ioutil.ReadAll(entries)
+}
+
+// OpenArchiveFile opens the indexed archive at a local path
+func OpenArchiveFile(archivePath string) (archive *Archive, closer io.Closer, err error) {
+	var (
+		file *os.File
+		info os.FileInfo
+	)
+
+	if file, err = os.Open(archivePath); err != nil {
+		return
+	}
+
+	if info, err = file.Stat(); err == nil {
+		archive, err = OpenArchive(file, info.Size())
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return archive, file, nil
+}
+
+// OpenHTTPArchive opens the indexed archive served at the url, reading only
+// the parts of it asked for through range requests
+func OpenHTTPArchive(url string) (archive *Archive, err error) {
+	var response *http.Response
+
+	if response, err = archiveClient.Head(url); err != nil {
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK || response.ContentLength <= 0 {
+		return nil, ErrRangeRequest(url, response.Status)
+	}
+	return OpenArchive(&httpRangeReader{url: url}, response.ContentLength)
+}
+
+func (s *httpRangeReader) ReadAt(p []byte, offset int64) (n int, err error) {
+	var body io.ReadCloser
+
+	if len(p) == 0 {
+		return
+	}
+
+	if body, err = s.openRange(offset, int64(len(p))); err != nil {
+		return
+	}
+	defer body.Close()
+
+	if n, err = io.ReadFull(body, p); err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
+
+func (s *httpRangeReader) openRange(offset, size int64) (body io.ReadCloser, err error) {
+	var (
+		request  *http.Request
+		response *http.Response
+	)
+
+	if request, err = http.NewRequest("GET", s.url, nil); err != nil {
+		return
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+
+	if response, err = retry.Default().Request(archiveClient, request); err != nil {
+		return
+	}
+
+	if response.StatusCode != http.StatusPartialContent {
+		response.Body.Close()
+		return nil, ErrRangeRequest(s.url, response.Status)
+	}
+	return response.Body, nil
+}
+
+// NewSeekReaderAt reads at offsets of a reader that can only seek, like an
+// sftp file
+func NewSeekReaderAt(reader io.ReadSeeker) io.ReaderAt {
+	return &seekReaderAt{reader: reader}
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, offset int64) (n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err = s.reader.Seek(offset, 0); err == nil {
+		if n, err = io.ReadFull(s.reader, p); err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+	}
+	return
+}
+
+// Entry looks an artifact up in the index
+func (s *Archive) Entry(name string) (entry ArchiveEntry, ok bool) {
+	for _, entry = range s.Index.Entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return ArchiveEntry{}, false
+}
+
+// Open reads the contents of a single artifact, seeking straight to it
+func (s *Archive) Open(name string) (contents io.ReadCloser, err error) {
+	entry, ok := s.Entry(name)
+
+	switch {
+	case !ok:
+		err = ErrArchiveEntry(name)
+
+	case entry.Size == 0:
+		contents = ioutil.NopCloser(strings.NewReader(""))
+
+	default:
+		if opener, isOpener := s.reader.(rangeOpener); isOpener {
+			return opener.openRange(entry.Offset, entry.Size)
+		}
+		contents = ioutil.NopCloser(io.NewSectionReader(s.reader, entry.Offset, entry.Size))
+	}
+	return
+}
+
+// Extract writes the named artifacts of the archive under the destination,
+// concurrency of them at once, one for each cpu when it is not set
+func (s *Archive) Extract(destination string, names []string, concurrency int) (err error) {
+	return forEachConcurrently(names, concurrency, func(name string) error {
+		return s.extract(destination, name)
+	})
+}
+
+func (s *Archive) extract(destination, name string) (err error) {
+	var (
+		contents io.ReadCloser
+		file     *os.File
+	)
+	target := path.Join(destination, name)
+
+	if contents, err = s.Open(name); err != nil {
+		return
+	}
+	defer contents.Close()
+
+	if err = os.MkdirAll(path.Dir(target), 0700); err != nil {
+		return
+	}
+
+	if file, err = os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err == nil {
+		_, err = io.Copy(file, contents)
+
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// ArchiveBackup packs the artifacts a backup run wrote into the indexed
+// archive in the destination, then removes the loose artifacts
+func ArchiveBackup(destination string, entry *CatalogEntry) (err error) {
+	var (
+		file  *os.File
+		names []string
+	)
+
+	for _, artifact := range backupArtifacts(entry) {
+		if _, statErr := os.Stat(path.Join(destination, artifact)); statErr == nil {
+			names = append(names, artifact)
+		}
+	}
+	tmp := path.Join(destination, ArchiveName+".tmp")
+
+	if file, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+		return
+	}
+
+	if _, err = WriteArchive(file, destination, names); err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path.Join(destination, ArchiveName))
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	for _, name := range names {
+		lo.G.Debug("Removing archived artifact " + name)
+		os.Remove(path.Join(destination, name))
+	}
+	return
+}
+
+// setArtifacts lists the artifacts of the tiles a run went through
+func setArtifacts(entry *CatalogEntry) (artifacts []string) {
+	for _, component := range entry.Components {
+		for _, tileName := range expandTiles(component.Name) {
+			artifacts = append(artifacts, BackupArtifacts[tileName]...)
+		}
+	}
+	return
+}
+
+func expandTiles(tileName string) []string {
+	if tileName == AllTiles {
+		return []string{OpsMgr, ER}
+	}
+	return []string{tileName}
+}
+
+// restoreArtifacts lists the artifacts a restore of the csv tilelist, all
+// tiles when empty, limited to the csv list of components reads. The elastic
+// runtime always needs the installation settings of ops manager
+func restoreArtifacts(tilelist, components string) (artifacts []string) {
+	tiles := []string{OpsMgr, ER}
+	seen := make(map[string]bool)
+
+	if tilelist != "" {
+		tiles = formatArray(strings.Split(tilelist, ","))
+	}
+	add := func(artifact string) {
+		if !seen[artifact] {
+			seen[artifact] = true
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	for _, tileName := range tiles {
+		if tileName != ER {
+			for _, artifact := range BackupArtifacts[tileName] {
+				add(artifact)
+			}
+			continue
+		}
+		add(BackupArtifacts[OpsMgr][0])
+
+		if components == "" {
+			for _, artifact := range BackupArtifacts[ER] {
+				add(artifact)
+			}
+			continue
+		}
+
+		for _, component := range strings.Split(components, ",") {
+			add(erArtifact(strings.ToLower(strings.TrimSpace(component))))
+		}
+	}
+	return
+}
+
+// extractForRestore extracts the artifacts a restore reads from the archive
+// in the destination, when there is one, returning a func removing them again
+func extractForRestore(fs flagSet) (cleanup func(), err error) {
+	var (
+		archive *Archive
+		closer  io.Closer
+	)
+	cleanup = func() {}
+	archivePath := path.Join(fs.Dest(), ArchiveName)
+
+	if _, statErr := os.Stat(archivePath); statErr != nil {
+		return
+	}
+
+	if archive, closer, err = OpenArchiveFile(archivePath); err != nil {
+		return
+	}
+	defer closer.Close()
+	var names []string
+
+	for _, name := range restoreArtifacts(fs.Tilelist(), fs.Components()) {
+		if _, ok := archive.Entry(name); ok {
+			names = append(names, name)
+		}
+	}
+	lo.G.Info("extracting %d artifacts from %s, %d at once", len(names), archivePath, extractWorkers(fs.ExtractConcurrency()))
+	cleanup = func() {
+		for _, name := range names {
+			os.Remove(path.Join(fs.Dest(), name))
+		}
+	}
+
+	if err = archive.Extract(fs.Dest(), names, fs.ExtractConcurrency()); err != nil {
+		cleanup()
+		cleanup = func() {}
+	}
+	return
+}
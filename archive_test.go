@@ -0,0 +1,224 @@
+package cfops_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Archive", func() {
+	var (
+		dir      string
+		names    []string
+		contents bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "archive")
+		names = append(append([]string{}, BackupArtifacts[OpsMgr]...), BackupArtifacts[ER]...)
+		writeArtifacts(dir, names)
+		contents.Reset()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("WriteArchive", func() {
+		It("should write a plain tar of the artifacts followed by the index", func() {
+			_, err := WriteArchive(&contents, dir, names)
+			Ω(err).Should(BeNil())
+			var listed []string
+			reader := tar.NewReader(bytes.NewReader(contents.Bytes()))
+
+			for header, err := reader.Next(); err == nil; header, err = reader.Next() {
+				listed = append(listed, header.Name)
+			}
+			Ω(listed[:len(names)]).Should(Equal(names))
+			Ω(listed[len(names)]).Should(Equal(ArchiveIndexName))
+		})
+	})
+
+	Describe("OpenArchive", func() {
+		BeforeEach(func() {
+			WriteArchive(&contents, dir, names)
+		})
+
+		It("should read a single artifact through the index", func() {
+			archive, err := OpenArchive(bytes.NewReader(contents.Bytes()), int64(contents.Len()))
+			Ω(err).Should(BeNil())
+			Ω(archive.Index.Entries).Should(HaveLen(len(names)))
+			artifact, err := archive.Open(BackupArtifacts[ER][1])
+			Ω(err).Should(BeNil())
+			read, _ := ioutil.ReadAll(artifact)
+			Ω(string(read)).Should(Equal(artifactContents(BackupArtifacts[ER][1])))
+		})
+
+		It("should fail on an entry that is not in the index", func() {
+			archive, _ := OpenArchive(bytes.NewReader(contents.Bytes()), int64(contents.Len()))
+			_, err := archive.Open("missing")
+			Ω(err).Should(Equal(ErrArchiveEntry("missing")))
+		})
+
+		It("should reject a tar without an index", func() {
+			var plain bytes.Buffer
+			writer := tar.NewWriter(&plain)
+			writer.WriteHeader(&tar.Header{Name: "file", Mode: 0600, Size: 4})
+			writer.Write([]byte("file"))
+			writer.Close()
+			_, err := OpenArchive(bytes.NewReader(plain.Bytes()), int64(plain.Len()))
+			Ω(err).Should(Equal(ErrNotIndexedArchive(ArchiveName)))
+		})
+
+		It("should read through a reader that can only seek", func() {
+			reader := NewSeekReaderAt(bytes.NewReader(contents.Bytes()))
+			archive, err := OpenArchive(reader, int64(contents.Len()))
+			Ω(err).Should(BeNil())
+			artifact, _ := archive.Open(BackupArtifacts[OpsMgr][0])
+			read, _ := ioutil.ReadAll(artifact)
+			Ω(string(read)).Should(Equal("-- artifact"))
+		})
+
+		It("should extract several artifacts at once", func() {
+			target, _ := ioutil.TempDir("", "extract")
+			defer os.RemoveAll(target)
+			archive, _ := OpenArchive(bytes.NewReader(contents.Bytes()), int64(contents.Len()))
+			Ω(archive.Extract(target, names, 3)).Should(BeNil())
+
+			for _, name := range names {
+				read, _ := ioutil.ReadFile(path.Join(target, name))
+				Ω(string(read)).Should(Equal(artifactContents(name)))
+			}
+		})
+
+		It("should fail the extraction of an artifact that is not in the index", func() {
+			target, _ := ioutil.TempDir("", "extract")
+			defer os.RemoveAll(target)
+			archive, _ := OpenArchive(bytes.NewReader(contents.Bytes()), int64(contents.Len()))
+			Ω(archive.Extract(target, append(names, "missing"), 2)).Should(Equal(ErrArchiveEntry("missing")))
+		})
+	})
+
+	Describe("ArchiveBackup", func() {
+		var entry *CatalogEntry
+
+		BeforeEach(func() {
+			entry = &CatalogEntry{Action: Backup, Components: []ComponentResult{{Name: OpsMgr}, {Name: ER}}}
+		})
+
+		It("should replace the loose artifacts with the archive", func() {
+			Ω(ArchiveBackup(dir, entry)).Should(BeNil())
+			Ω(path.Join(dir, ArchiveName)).Should(BeAnExistingFile())
+			Ω(path.Join(dir, BackupArtifacts[ER][0])).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should still verify once archived", func() {
+			ArchiveBackup(dir, entry)
+			Ω(Verify(dir, []string{OpsMgr, ER})).Should(BeNil())
+			Ω(Verify(path.Join(dir, ArchiveName), []string{ER})).Should(BeNil())
+		})
+
+		It("should name the archived artifact that is empty", func() {
+			os.Truncate(path.Join(dir, BackupArtifacts[ER][0]), 0)
+			ArchiveBackup(dir, entry)
+			err := Verify(dir, []string{ER})
+			Ω(err).Should(Equal(ErrMissingArtifact(path.Join(dir, ArchiveName) + ":" + BackupArtifacts[ER][0])))
+		})
+	})
+
+	Describe("running a pipeline", func() {
+		var (
+			fs       *mockFlagSet
+			restored []string
+		)
+
+		BeforeEach(func() {
+			restored = nil
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+				ER: func() (Tile, error) {
+					for _, artifact := range names {
+						if _, err := os.Stat(path.Join(dir, artifact)); err == nil {
+							restored = append(restored, artifact)
+						}
+					}
+					return &mockTile{}, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager, er", dest: dir, archive: true}
+		})
+
+		It("should archive a backup that completed", func() {
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			Ω(path.Join(dir, ArchiveName)).Should(BeAnExistingFile())
+			Ω(path.Join(dir, BackupArtifacts[OpsMgr][0])).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should only extract the artifacts a partial restore reads", func() {
+			RunPipeline(fs, Backup)
+			fs.tileListFlag = "er"
+			fs.components = "ccdb"
+			restored = nil
+			Ω(RunPipeline(fs, Restore)).Should(BeNil())
+			Ω(restored).Should(ConsistOf(BackupArtifacts[OpsMgr][0], BackupArtifacts[ER][0]))
+			Ω(path.Join(dir, BackupArtifacts[ER][0])).ShouldNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("verifying over http", func() {
+		var (
+			server *httptest.Server
+			ranges []string
+		)
+
+		BeforeEach(func() {
+			ranges = nil
+			WriteArchive(&contents, dir, names)
+			archived := contents.Bytes()
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					ranges = append(ranges, r.Header.Get("Range"))
+				}
+				http.ServeContent(w, r, ArchiveName, time.Time{}, bytes.NewReader(archived))
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should only request the index and the artifacts it verifies", func() {
+			Ω(RunVerify(server.URL+"/"+ArchiveName, "opsmanager", false, nil)).Should(BeNil())
+			Ω(ranges).Should(HaveLen(2))
+
+			for _, requested := range ranges {
+				Ω(requested).Should(HavePrefix("bytes="))
+			}
+		})
+
+		It("should stream a whole artifact in a single request", func() {
+			archive, err := OpenHTTPArchive(server.URL)
+			Ω(err).Should(BeNil())
+			requests := len(ranges)
+			artifact, _ := archive.Open(BackupArtifacts[ER][0])
+			io.Copy(ioutil.Discard, artifact)
+			artifact.Close()
+			Ω(ranges).Should(HaveLen(requests + 1))
+			Ω(strings.HasPrefix(ranges[requests], "bytes=")).Should(BeTrue())
+		})
+	})
+})
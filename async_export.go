@@ -0,0 +1,15 @@
+package cfops
+
+import "time"
+
+// AsyncExportConfig has ops manager export the installation through its
+// asynchronous export api rather than in one request that large
+// installations outlast: the export is started and polled every PollInterval
+// until it is ready, then downloaded, the download resuming where it broke
+// off. Deadline bounds the whole export. Ops managers without the api are
+// exported from in one request, as ever
+type AsyncExportConfig struct {
+	Enabled      bool
+	PollInterval time.Duration
+	Deadline     time.Duration
+}
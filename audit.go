@@ -0,0 +1,268 @@
+package cfops
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// AuditFileName is the copy of the audit log kept in each destination
+	AuditFileName             = "cfops.audit.log"
+	AuditSucceeded            = "succeeded"
+	AuditFailed               = "failed"
+	AuditBreak                = "audit-break"
+	ErrAuditChainBrokenFormat = "audit log %s is broken at line %d: %s"
+	redacted                  = "REDACTED"
+)
+
+// secretFlag matches the password, secret, token and key flags, the headers
+// flags carrying credentials such as --otlpheaders, and the short names of the
+// password flags
+var secretFlag = regexp.MustCompile(`(?i)^--?([a-z]*(pass|secret|token|key|header)[a-z]*|dp|omp|smpw)$`)
+
+// AuditRecord is one entry of the audit log. Hash covers every other field,
+// including the hash of the previous entry, so removing or editing an entry
+// breaks the chain
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	User        string    `json:"user"`
+	Hostname    string    `json:"hostname"`
+	Action      string    `json:"action"`
+	Args        []string  `json:"args"`
+	Foundation  string    `json:"foundation"`
+	Destination string    `json:"destination"`
+	RunID       string    `json:"run_id,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+}
+
+func ErrAuditChainBroken(auditPath string, line int, reason string) error {
+	return fmt.Errorf(ErrAuditChainBrokenFormat, auditPath, line, reason)
+}
+
+// NewAuditRecord describes an invocation of the action by the current user,
+// with the values of secret flags redacted from its arguments
+func NewAuditRecord(action, foundation, destination string, args []string) (record AuditRecord) {
+	record = AuditRecord{
+		Time:        time.Now().UTC(),
+		Action:      action,
+		Args:        RedactArgs(args),
+		Foundation:  foundation,
+		Destination: destination,
+		Outcome:     AuditSucceeded,
+	}
+	record.Hostname, _ = os.Hostname()
+
+	if current, err := user.Current(); err == nil {
+		record.User = current.Username
+
+	} else {
+		record.User = os.Getenv("USER")
+	}
+	return
+}
+
+// RedactArgs replaces the values of password, secret, token and key flags
+func RedactArgs(args []string) (redactedArgs []string) {
+	redactNext := false
+
+	for _, arg := range args {
+		switch {
+		case redactNext:
+			arg = redacted
+			redactNext = false
+
+		case strings.Contains(arg, "="):
+			if name := arg[:strings.Index(arg, "=")]; secretFlag.MatchString(name) {
+				arg = name + "=" + redacted
+			}
+
+		case secretFlag.MatchString(arg):
+			redactNext = true
+		}
+		redactedArgs = append(redactedArgs, arg)
+	}
+	return
+}
+
+// AppendAudit chains the record to the last entry of the audit log and
+// appends it, holding an exclusive lock on the log while doing so
+func AppendAudit(auditPath string, record AuditRecord) (err error) {
+	var (
+		file     *os.File
+		contents []byte
+	)
+
+	if err = os.MkdirAll(path.Dir(auditPath), 0700); err != nil {
+		return
+	}
+
+	if file, err = os.OpenFile(auditPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		return
+	}
+	defer file.Close()
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	tail, err := lastAuditHash(file)
+	if err != nil {
+		return
+	}
+	record.PrevHash = tail.hash
+
+	if !tail.terminated {
+		contents = []byte{'\n'}
+	}
+
+	if tail.brokenLine > 0 {
+		breakRecord := AuditRecord{
+			Time:     record.Time,
+			User:     record.User,
+			Hostname: record.Hostname,
+			Action:   AuditBreak,
+			Outcome:  AuditFailed,
+			Error:    fmt.Sprintf("line %d of the audit log could not be read", tail.brokenLine),
+			PrevHash: tail.hash,
+		}
+		breakRecord.Hash = auditHash(breakRecord)
+		record.PrevHash = breakRecord.Hash
+
+		if contents, err = appendAuditRecord(contents, breakRecord); err != nil {
+			return
+		}
+	}
+	record.Hash = auditHash(record)
+
+	if contents, err = appendAuditRecord(contents, record); err == nil {
+		_, err = file.Write(contents)
+	}
+	return
+}
+
+func appendAuditRecord(contents []byte, record AuditRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	return append(append(contents, line...), '\n'), err
+}
+
+type auditTail struct {
+	hash       string
+	brokenLine int
+	terminated bool
+}
+
+// lastAuditHash returns the hash the next entry chains to. A line that can not
+// be read is chained over by the hash of its raw bytes and reported as
+// brokenLine, so the caller records a break entry instead of hiding the damage
+func lastAuditHash(file *os.File) (tail auditTail, err error) {
+	var info os.FileInfo
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	tail.terminated = true
+
+	for line := 1; scanner.Scan(); line++ {
+		var record AuditRecord
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			tail.hash, tail.brokenLine = record.Hash, 0
+		} else {
+			sum := sha256.Sum256(scanner.Bytes())
+			tail.hash, tail.brokenLine = hex.EncodeToString(sum[:]), line
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	if info, err = file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = file.ReadAt(last, info.Size()-1); err == nil {
+			tail.terminated = last[0] == '\n'
+		}
+	}
+	return
+}
+
+func auditHash(record AuditRecord) string {
+	record.Hash = ""
+	contents, _ := json.Marshal(record)
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditLog walks the hash chain of the audit log, failing on the first
+// entry that was edited, removed or inserted
+func VerifyAuditLog(auditPath string) (entries int, err error) {
+	var (
+		file     *os.File
+		prevHash string
+	)
+
+	if file, err = os.Open(auditPath); err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		var record AuditRecord
+
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return entries, ErrAuditChainBroken(auditPath, line, err.Error())
+		}
+
+		if record.PrevHash != prevHash {
+			return entries, ErrAuditChainBroken(auditPath, line, "previous entry is missing")
+		}
+
+		if auditHash(record) != record.Hash {
+			return entries, ErrAuditChainBroken(auditPath, line, "entry was modified")
+		}
+		prevHash = record.Hash
+		entries++
+	}
+	return entries, scanner.Err()
+}
+
+// auditRun appends the outcome of a run to the audit log and to the copy kept
+// in the destination. Failing to record the destination copy is only logged
+func auditRun(fs flagSet, action string, entry *CatalogEntry, runErr error) (err error) {
+	if fs.AuditLog() == "" {
+		return
+	}
+	record := NewAuditRecord(action, fs.Host(), fs.Dest(), os.Args)
+	record.RunID = entry.ID
+
+	if runErr != nil {
+		record.Outcome = AuditFailed
+		record.Error = runErr.Error()
+	}
+
+	if fs.Dest() != "" {
+		if destErr := AppendAudit(path.Join(fs.Dest(), AuditFileName), record); destErr != nil {
+			warn("unable to record the audit entry in the destination: %s", destErr)
+		}
+	}
+	return AppendAudit(fs.AuditLog(), record)
+}
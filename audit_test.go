@@ -0,0 +1,110 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit log", func() {
+	var (
+		dir       string
+		auditPath string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "audit")
+		auditPath = path.Join(dir, "audit", "audit.log")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("RedactArgs", func() {
+		It("should hide the values of secret flags in either form", func() {
+			Ω(RedactArgs([]string{"cfops", "backup", "--adminpass", "s3cret", "--omp=s3cret", "-d", "/backups"})).Should(Equal(
+				[]string{"cfops", "backup", "--adminpass", "REDACTED", "--omp=REDACTED", "-d", "/backups"},
+			))
+		})
+
+		It("should hide the headers sent to the trace collector", func() {
+			Ω(RedactArgs([]string{"cfops", "--otlpheaders", "Authorization=Bearer t0ken", "--otlpheaders=x-api-key=k3y"})).Should(Equal(
+				[]string{"cfops", "--otlpheaders", "REDACTED", "--otlpheaders=REDACTED"},
+			))
+		})
+	})
+
+	Describe("AppendAudit", func() {
+		BeforeEach(func() {
+			for _, action := range []string{Backup, Restore, Backup} {
+				Ω(AppendAudit(auditPath, NewAuditRecord(action, "opsman", "/backups", nil))).Should(BeNil())
+			}
+		})
+
+		It("should chain every entry to the one before it", func() {
+			Ω(VerifyAuditLog(auditPath)).Should(Equal(3))
+		})
+
+		It("should detect an entry that was removed", func() {
+			contents, _ := ioutil.ReadFile(auditPath)
+			lines := strings.SplitAfter(string(contents), "\n")
+			ioutil.WriteFile(auditPath, []byte(lines[0]+lines[2]), 0600)
+			_, err := VerifyAuditLog(auditPath)
+			Ω(err).Should(Equal(ErrAuditChainBroken(auditPath, 2, "previous entry is missing")))
+		})
+
+		It("should detect an entry that was modified", func() {
+			contents, _ := ioutil.ReadFile(auditPath)
+			ioutil.WriteFile(auditPath, []byte(strings.Replace(string(contents), `"action":"restore"`, `"action":"backup"`, 1)), 0600)
+			_, err := VerifyAuditLog(auditPath)
+			Ω(err).Should(Equal(ErrAuditChainBroken(auditPath, 2, "entry was modified")))
+		})
+
+		It("should record a break after a truncated tail instead of chaining over it", func() {
+			contents, _ := ioutil.ReadFile(auditPath)
+			ioutil.WriteFile(auditPath, contents[:len(contents)-20], 0600)
+			Ω(AppendAudit(auditPath, NewAuditRecord(Backup, "opsman", "/backups", nil))).Should(BeNil())
+
+			contents, _ = ioutil.ReadFile(auditPath)
+			lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+			Ω(lines).Should(HaveLen(5))
+			var breakRecord AuditRecord
+			Ω(json.Unmarshal([]byte(lines[3]), &breakRecord)).Should(BeNil())
+			Ω(breakRecord.Action).Should(Equal(AuditBreak))
+			Ω(breakRecord.Error).Should(Equal("line 3 of the audit log could not be read"))
+
+			_, err := VerifyAuditLog(auditPath)
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(HavePrefix(fmt.Sprintf("audit log %s is broken at line 3", auditPath)))
+		})
+	})
+
+	Describe("RunPipeline", func() {
+		BeforeEach(func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{ErrReturned: errors.New("opsmanager failed")}, nil
+				},
+			}
+		})
+
+		It("should record the failed run in the audit log and the destination", func() {
+			fs := &mockFlagSet{tileListFlag: "opsmanager", dest: dir, auditLog: auditPath}
+			RunPipeline(fs, Backup)
+			Ω(VerifyAuditLog(auditPath)).Should(Equal(1))
+			Ω(VerifyAuditLog(path.Join(dir, AuditFileName))).Should(Equal(1))
+			contents, _ := ioutil.ReadFile(auditPath)
+			Ω(string(contents)).Should(ContainSubstring(`"outcome":"failed","error":"OPSMANAGER: opsmanager failed"`))
+		})
+	})
+})
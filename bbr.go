@@ -0,0 +1,288 @@
+package cfops
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	// BBRMetadataName is the file bosh-backup-restore describes its artifacts in
+	BBRMetadataName        = "metadata"
+	ErrBBRChecksumFormat   = "bbr artifact %s: checksum of %s does not match its metadata"
+	ErrBBRDumpFormat       = "bbr artifact %s holds %d files, expected a single database dump"
+	ErrBBRCustomDumpFormat = "bbr artifact %s is a pg_dump custom format archive, convert it with pg_restore -f before restoring"
+	bbrBlobstoreDir        = "shared"
+)
+
+var (
+	// BBRComponents maps the jobs of a bbr backup of the elastic runtime to the
+	// components their artifacts restore
+	BBRComponents = map[string]string{
+		"bbr-cloudcontrollerdb": "ccdb",
+		"bbr-uaadb":             "uaadb",
+		"bbr-consoledb":         "consoledb",
+		"bbr-mysql":             "mysql",
+		"mysql-backup":          "mysql",
+		"blobstore":             "nfs_server",
+	}
+	pgCustomDumpMagic = []byte("PGDMP")
+)
+
+type (
+	// BBRMetadata is the metadata file of a bosh-backup-restore artifact
+	BBRMetadata struct {
+		Instances []BBRInstance `yaml:"instances"`
+	}
+
+	BBRInstance struct {
+		Name      string        `yaml:"name"`
+		Index     string        `yaml:"index"`
+		Artifacts []BBRArtifact `yaml:"artifacts"`
+	}
+
+	// BBRArtifact is a tar of the files a job backed up, with the sha256 of
+	// each file by its path in the tar
+	BBRArtifact struct {
+		Name      string            `yaml:"name"`
+		Checksums map[string]string `yaml:"checksums"`
+	}
+)
+
+func ErrBBRChecksum(artifact, file string) error {
+	return fmt.Errorf(ErrBBRChecksumFormat, artifact, file)
+}
+
+func ErrBBRDump(artifact string, files int) error {
+	return fmt.Errorf(ErrBBRDumpFormat, artifact, files)
+}
+
+func ErrBBRCustomDump(artifact string) error {
+	return fmt.Errorf(ErrBBRCustomDumpFormat, artifact)
+}
+
+// LoadBBRMetadata reads the metadata of the bbr artifact in the directory
+func LoadBBRMetadata(bbrDir string) (metadata BBRMetadata, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(path.Join(bbrDir, BBRMetadataName)); err == nil {
+		err = yaml.Unmarshal(contents, &metadata)
+	}
+	return
+}
+
+// ImportBBR converts the artifacts of a bosh-backup-restore backup of the
+// elastic runtime into the cfops artifacts of their components in the
+// destination, checking every file against the checksums of the metadata.
+// Only the named cfops artifacts are written, all of them when none are
+// named. Concurrency artifacts are imported at once, one for each cpu when it
+// is not set. It returns the artifacts it wrote
+func ImportBBR(bbrDir, destination string, artifacts []string, concurrency int) (imported []string, err error) {
+	var (
+		metadata BBRMetadata
+		targets  []string
+		mutex    sync.Mutex
+	)
+	wanted := make(map[string]bool)
+	done := make(map[string]bool)
+	// the bbr artifacts of each target are imported in turn, since a later one
+	// replaces an earlier one
+	sources := make(map[string][]func() error)
+
+	for _, artifact := range artifacts {
+		wanted[artifact] = true
+	}
+
+	if metadata, err = LoadBBRMetadata(bbrDir); err != nil {
+		return
+	}
+
+	for _, instance := range metadata.Instances {
+		for _, artifact := range instance.Artifacts {
+			component, known := BBRComponents[artifact.Name]
+			target := erArtifact(component)
+
+			if !known || (len(artifacts) > 0 && !wanted[target]) {
+				lo.G.Debug("skipping bbr artifact %s of %s", artifact.Name, instance.Name)
+				continue
+			}
+			tarName := fmt.Sprintf("%s-%s-%s.tar", instance.Name, instance.Index, artifact.Name)
+			artifact, blobstore := artifact, component == "nfs_server"
+
+			if _, ok := sources[target]; !ok {
+				targets = append(targets, target)
+			}
+			sources[target] = append(sources[target], func() error {
+				lo.G.Info("importing bbr artifact %s as %s", tarName, target)
+				return importBBRArtifact(path.Join(bbrDir, tarName), artifact, path.Join(destination, target), blobstore)
+			})
+		}
+	}
+
+	err = forEachConcurrently(targets, concurrency, func(target string) (err error) {
+		for _, source := range sources[target] {
+			if err = source(); err != nil {
+				return
+			}
+		}
+		mutex.Lock()
+		done[target] = true
+		mutex.Unlock()
+		return
+	})
+
+	for _, target := range targets {
+		if done[target] {
+			imported = append(imported, target)
+		}
+	}
+	return
+}
+
+// importBBRArtifact writes the single dump a database job backed up, or the
+// files of the blobstore repacked the way cfops archives the nfs server
+func importBBRArtifact(tarPath string, artifact BBRArtifact, target string, blobstore bool) (err error) {
+	var (
+		file  *os.File
+		out   *os.File
+		files int
+	)
+
+	if file, err = os.Open(tarPath); err != nil {
+		return
+	}
+	defer file.Close()
+
+	if err = os.MkdirAll(path.Dir(target), 0700); err != nil {
+		return
+	}
+	tmp := target + ".tmp"
+
+	if out, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+		return
+	}
+	defer os.Remove(tmp)
+
+	if blobstore {
+		files, err = repackBlobstore(tar.NewReader(file), artifact, out)
+
+	} else {
+		files, err = copyDump(tar.NewReader(file), artifact, out)
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && !blobstore && files != 1 {
+		err = ErrBBRDump(artifact.Name, files)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, target)
+	}
+	return
+}
+
+func copyDump(entries *tar.Reader, artifact BBRArtifact, out io.Writer) (files int, err error) {
+	var header *tar.Header
+
+	for header, err = entries.Next(); err == nil; header, err = entries.Next() {
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if files++; files > 1 {
+			return files, ErrBBRDump(artifact.Name, files)
+		}
+		peek := make([]byte, len(pgCustomDumpMagic))
+		n, _ := io.ReadFull(entries, peek)
+
+		if bytes.Equal(peek[:n], pgCustomDumpMagic) {
+			return files, ErrBBRCustomDump(artifact.Name)
+		}
+
+		if err = copyChecked(out, io.MultiReader(bytes.NewReader(peek[:n]), entries), artifact, header.Name); err != nil {
+			return
+		}
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func repackBlobstore(entries *tar.Reader, artifact BBRArtifact, out io.Writer) (files int, err error) {
+	var header *tar.Header
+	repacked := cfbackup.NewBlobArchive(out)
+
+	for header, err = entries.Next(); err == nil; header, err = entries.Next() {
+		name := strings.TrimPrefix(header.Name, "./")
+
+		if !strings.HasPrefix(name, bbrBlobstoreDir+"/") && name != bbrBlobstoreDir {
+			name = path.Join(bbrBlobstoreDir, name)
+		}
+		checksumName := header.Name
+		header.Name = name
+
+		if err = repacked.WriteHeader(header); err != nil {
+			return
+		}
+
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			files++
+
+			if err = copyChecked(repacked, entries, artifact, checksumName); err != nil {
+				return
+			}
+		}
+	}
+
+	if err == io.EOF {
+		err = repacked.Close()
+	}
+	return
+}
+
+// copyChecked copies a file of the artifact, failing when its sha256 is not
+// the one the metadata records for it
+func copyChecked(out io.Writer, contents io.Reader, artifact BBRArtifact, name string) (err error) {
+	hash := sha256.New()
+
+	if _, err = io.Copy(io.MultiWriter(out, hash), contents); err != nil {
+		return
+	}
+	expected, ok := artifact.Checksums[name]
+
+	if !ok {
+		expected, ok = artifact.Checksums["./"+strings.TrimPrefix(name, "./")]
+	}
+
+	if !ok || expected != hex.EncodeToString(hash.Sum(nil)) {
+		err = ErrBBRChecksum(artifact.Name, name)
+	}
+	return
+}
+
+// importBBRForRestore imports the artifacts a restore reads from the bbr
+// backup given to it, when there is one. The installation settings of ops
+// manager are not part of a bbr backup and must already be in the destination
+func importBBRForRestore(fs flagSet) (err error) {
+	if fs.BBRArtifact() != "" {
+		_, err = ImportBBR(fs.BBRArtifact(), fs.Dest(), restoreArtifacts(fs.Tilelist(), fs.Components()), fs.ExtractConcurrency())
+	}
+	return
+}
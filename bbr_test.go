@@ -0,0 +1,120 @@
+package cfops_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ImportBBR", func() {
+	var (
+		bbrDir string
+		dir    string
+		dump   string
+	)
+
+	writeTar := func(name string, files map[string]string) {
+		file, _ := os.Create(path.Join(bbrDir, name))
+		defer file.Close()
+		writer := tar.NewWriter(file)
+
+		for fileName, contents := range files {
+			writer.WriteHeader(&tar.Header{Name: fileName, Mode: 0600, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+			writer.Write([]byte(contents))
+		}
+		writer.Close()
+	}
+
+	checksum := func(contents string) string {
+		sum := sha256.Sum256([]byte(contents))
+		return hex.EncodeToString(sum[:])
+	}
+
+	writeMetadata := func(dumpChecksum string) {
+		metadata := fmt.Sprintf(`instances:
+- name: backup_restore
+  index: "0"
+  artifacts:
+  - name: bbr-cloudcontrollerdb
+    checksums:
+      ./ccdb.sql: %s
+- name: nfs_server
+  index: "0"
+  artifacts:
+  - name: blobstore
+    checksums:
+      ./cc-droplets/ab/droplet: %s
+`, dumpChecksum, checksum("droplet"))
+		ioutil.WriteFile(path.Join(bbrDir, BBRMetadataName), []byte(metadata), 0600)
+	}
+
+	BeforeEach(func() {
+		bbrDir, _ = ioutil.TempDir("", "bbr")
+		dir, _ = ioutil.TempDir("", "bbr-import")
+		dump = postgresDump
+		writeTar("backup_restore-0-bbr-cloudcontrollerdb.tar", map[string]string{"./ccdb.sql": dump})
+		writeTar("nfs_server-0-blobstore.tar", map[string]string{"./cc-droplets/ab/droplet": "droplet"})
+		writeMetadata(checksum(dump))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bbrDir)
+		os.RemoveAll(dir)
+	})
+
+	It("should write the dump of a database job as the artifact of its component", func() {
+		imported, err := ImportBBR(bbrDir, dir, nil, 0)
+		Ω(err).Should(BeNil())
+		Ω(imported).Should(ConsistOf("ccdb.backup", "nfs_server.backup"))
+		contents, _ := ioutil.ReadFile(path.Join(dir, "ccdb.backup"))
+		Ω(string(contents)).Should(Equal(dump))
+		Ω(ValidateDump(path.Join(dir, "ccdb.backup"), PostgresEngine)).Should(BeNil())
+	})
+
+	It("should repack the blobstore the way the nfs server is archived", func() {
+		ImportBBR(bbrDir, dir, nil, 0)
+		file, _ := os.Open(path.Join(dir, "nfs_server.backup"))
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		Ω(err).Should(BeNil())
+		header, err := tar.NewReader(gz).Next()
+		Ω(err).Should(BeNil())
+		Ω(header.Name).Should(Equal("shared/cc-droplets/ab/droplet"))
+	})
+
+	It("should import one artifact at a time when asked to", func() {
+		imported, err := ImportBBR(bbrDir, dir, nil, 1)
+		Ω(err).Should(BeNil())
+		Ω(imported).Should(Equal([]string{"ccdb.backup", "nfs_server.backup"}))
+	})
+
+	It("should only import the artifacts asked for", func() {
+		imported, err := ImportBBR(bbrDir, dir, []string{"ccdb.backup"}, 0)
+		Ω(err).Should(BeNil())
+		Ω(imported).Should(Equal([]string{"ccdb.backup"}))
+		Ω(path.Join(dir, "nfs_server.backup")).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should refuse a file that does not match its checksum", func() {
+		writeMetadata(checksum("something else"))
+		_, err := ImportBBR(bbrDir, dir, nil, 0)
+		Ω(err).Should(Equal(ErrBBRChecksum("bbr-cloudcontrollerdb", "./ccdb.sql")))
+		Ω(path.Join(dir, "ccdb.backup")).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should refuse a custom format dump psql cannot restore", func() {
+		writeTar("backup_restore-0-bbr-cloudcontrollerdb.tar", map[string]string{"./ccdb.sql": "PGDMP binary"})
+		_, err := ImportBBR(bbrDir, dir, nil, 0)
+		Ω(err).Should(Equal(ErrBBRCustomDump("bbr-cloudcontrollerdb")))
+	})
+})
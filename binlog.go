@@ -0,0 +1,391 @@
+package cfops
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/gtils/persistence"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrNoBinlogCoordinatesFormat  = "%s does not record the binlog coordinates it is consistent with, it was not taken with binlog capture enabled"
+	ErrMissingBinlogFormat        = "binlog %s is missing from %s, the binlogs can not be replayed past it"
+	ErrPointInTimeFormat          = "cannot recover to %s, before the backup completed at %s"
+	ErrPointInTimeWithoutMysqlMsg = "point in time recovery replays the binlogs of mysql, which the restore does not include"
+	// binlogDumpOptions make a dump record the binlog coordinates it is
+	// consistent with, as a comment
+	binlogDumpOptions = "--single-transaction --master-data=2"
+	// binlogCoordinatesLines bounds how far into a dump its coordinates are looked for
+	binlogCoordinatesLines = 100
+	mysqlbinlogTimeFormat  = "2006-01-02 15:04:05"
+)
+
+var (
+	ErrPointInTimeWithoutMysql = errors.New(ErrPointInTimeWithoutMysqlMsg)
+	binlogCoordinatesPattern   = regexp.MustCompile(`CHANGE MASTER TO MASTER_LOG_FILE='([^']+)', MASTER_LOG_POS=(\d+)`)
+)
+
+type (
+	// BinlogConfig describes capturing the binlogs of the mysql server of the
+	// elastic runtime into a directory, alongside its nightly dumps, and
+	// replaying them on restore to recover to a point in time
+	BinlogConfig struct {
+		// Dir keeps the binlogs across runs, they are not captured when empty
+		Dir  string
+		Host string
+		Port string
+		User string
+		Pass string
+		// PointInTime is the time a restore replays the binlogs up to, they
+		// are not replayed when zero
+		PointInTime time.Time
+		// MysqlBinary and MysqlbinlogBinary default to mysql and mysqlbinlog
+		// on the path
+		MysqlBinary       string
+		MysqlbinlogBinary string
+	}
+
+	// BinlogCoordinates are the binlog and the position in it a dump is
+	// consistent with
+	BinlogCoordinates struct {
+		File     string
+		Position int64
+	}
+
+	binlogFile struct {
+		name string
+		size int64
+	}
+)
+
+func ErrNoBinlogCoordinates(artifact string) error {
+	return fmt.Errorf(ErrNoBinlogCoordinatesFormat, artifact)
+}
+
+func ErrMissingBinlog(name, dir string) error {
+	return fmt.Errorf(ErrMissingBinlogFormat, name, dir)
+}
+
+func ErrPointInTime(pointInTime, completed time.Time) error {
+	return fmt.Errorf(ErrPointInTimeFormat, pointInTime.UTC().Format(time.RFC3339), completed.UTC().Format(time.RFC3339))
+}
+
+// Enabled tells whether binlogs are captured at all
+func (s BinlogConfig) Enabled() bool {
+	return s.Dir != ""
+}
+
+func (s BinlogConfig) connection() (args []string) {
+	args = []string{"-h", s.Host, "-u", s.User, "--password=" + s.Pass}
+
+	if s.Port != "" {
+		args = append(args, "-P", s.Port)
+	}
+	return
+}
+
+func (s BinlogConfig) mysql() string {
+	if s.MysqlBinary == "" {
+		return "mysql"
+	}
+	return s.MysqlBinary
+}
+
+func (s BinlogConfig) mysqlbinlog() string {
+	if s.MysqlbinlogBinary == "" {
+		return "mysqlbinlog"
+	}
+	return s.MysqlbinlogBinary
+}
+
+// fetchArgs are the arguments of mysqlbinlog copying binlogs of the server
+// as they are into the directory
+func (s BinlogConfig) fetchArgs() []string {
+	return append([]string{"--read-from-remote-server", "--raw", "--result-file=" + s.Dir + "/"}, s.connection()...)
+}
+
+// recordBinlogCoordinates makes the mysql dumps of a backup record the binlog
+// coordinates they are consistent with when binlogs are captured, which is
+// where a restore starts replaying them from
+func recordBinlogCoordinates(config BinlogConfig) {
+	if config.Enabled() {
+		persistence.MSQLDMP_DUMP_OPTIONS = binlogDumpOptions
+	} else {
+		persistence.MSQLDMP_DUMP_OPTIONS = ""
+	}
+}
+
+// serverBinlogs lists the binlogs the server still has
+func (s BinlogConfig) serverBinlogs() (binlogs []binlogFile, err error) {
+	var out []byte
+
+	if out, err = runCommand(nil, s.mysql(), append(s.connection(), "-N", "-e", "SHOW BINARY LOGS")...); err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) < 2 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		binlogs = append(binlogs, binlogFile{name: fields[0], size: size})
+	}
+	return
+}
+
+// CaptureBinlogs copies the binlogs of the server the directory does not
+// hold yet, or holds only part of, into it. It returns the binlogs copied
+func CaptureBinlogs(config BinlogConfig) (captured []string, err error) {
+	var binlogs []binlogFile
+
+	if err = os.MkdirAll(config.Dir, 0700); err != nil {
+		return
+	}
+
+	if binlogs, err = config.serverBinlogs(); err != nil {
+		return
+	}
+
+	for _, binlog := range binlogs {
+		if info, statErr := os.Stat(path.Join(config.Dir, binlog.name)); statErr != nil || info.Size() != binlog.size {
+			captured = append(captured, binlog.name)
+		}
+	}
+
+	if len(captured) == 0 {
+		return
+	}
+	lo.G.Info("capturing %d binlogs into %s", len(captured), config.Dir)
+	_, err = runCommand(nil, config.mysqlbinlog(), append(config.fetchArgs(), captured...)...)
+	return
+}
+
+// FollowBinlogs copies the binlogs of the server into the directory as they
+// are written, from the last binlog it holds, until the context is cancelled
+func FollowBinlogs(ctx context.Context, config BinlogConfig) (err error) {
+	var (
+		local   []string
+		binlogs []binlogFile
+		from    string
+	)
+
+	if err = os.MkdirAll(config.Dir, 0700); err != nil {
+		return
+	}
+
+	if local, err = localBinlogs(config.Dir); err != nil {
+		return
+	}
+
+	if len(local) > 0 {
+		from = local[len(local)-1]
+
+	} else if binlogs, err = config.serverBinlogs(); err != nil {
+		return
+
+	} else if len(binlogs) > 0 {
+		from = binlogs[0].name
+	}
+	lo.G.Info("following the binlogs from %s into %s", from, config.Dir)
+	cmd := execCommand(config.mysqlbinlog(), append(config.fetchArgs(), "--stop-never", from)...)
+
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case err = <-exited:
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-exited
+	}
+	return
+}
+
+// localBinlogs lists the binlogs in the directory in the order the server
+// wrote them
+func localBinlogs(dir string) (binlogs []string, err error) {
+	var files []os.FileInfo
+
+	if files, err = ioutil.ReadDir(dir); err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if _, ok := binlogSequence(file.Name()); ok && file.Mode().IsRegular() {
+			binlogs = append(binlogs, file.Name())
+		}
+	}
+	sort.Strings(binlogs)
+	return
+}
+
+// binlogSequence is the number a binlog name ends with, e.g. 12 of
+// mysql-bin.000012
+func binlogSequence(name string) (sequence int, ok bool) {
+	extension := path.Ext(name)
+
+	if len(extension) < 2 {
+		return
+	}
+	sequence, err := strconv.Atoi(extension[1:])
+	return sequence, err == nil
+}
+
+// ReadBinlogCoordinates finds the binlog coordinates a dump taken with
+// binlog capture enabled records in its header
+func ReadBinlogCoordinates(dump io.Reader) (coordinates BinlogCoordinates, ok bool) {
+	scanner := bufio.NewScanner(dump)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 0; line < binlogCoordinatesLines && scanner.Scan(); line++ {
+		if match := binlogCoordinatesPattern.FindStringSubmatch(scanner.Text()); match != nil {
+			coordinates.File = match[1]
+			coordinates.Position, _ = strconv.ParseInt(match[2], 10, 64)
+			return coordinates, true
+		}
+	}
+	return
+}
+
+// ReplayBinlogs replays the binlogs of the directory into the mysql server,
+// from the coordinates the mysql dump of the destination records up to the
+// point in time, recovering the changes made since the backup was taken
+func ReplayBinlogs(config BinlogConfig, destination string) (err error) {
+	var (
+		dump        *os.File
+		coordinates BinlogCoordinates
+		ok          bool
+		binlogs     []string
+		stderr      bytes.Buffer
+	)
+	artifact := erArtifact("mysql")
+
+	if manifest, loadErr := LoadManifest(destination); loadErr == nil && config.PointInTime.Before(manifest.Created) {
+		return ErrPointInTime(config.PointInTime, manifest.Created)
+	}
+
+	if dump, err = os.Open(path.Join(destination, artifact)); err != nil {
+		return
+	}
+	coordinates, ok = ReadBinlogCoordinates(dump)
+	dump.Close()
+
+	if !ok {
+		return ErrNoBinlogCoordinates(artifact)
+	}
+
+	if binlogs, err = replayedBinlogs(config.Dir, coordinates.File); err != nil {
+		return
+	}
+	args := []string{
+		"--start-position=" + strconv.FormatInt(coordinates.Position, 10),
+		"--stop-datetime=" + config.PointInTime.UTC().Format(mysqlbinlogTimeFormat),
+	}
+
+	for _, binlog := range binlogs {
+		args = append(args, path.Join(config.Dir, binlog))
+	}
+	lo.G.Info("replaying %d binlogs from %s:%d up to %s", len(binlogs), coordinates.File, coordinates.Position, config.PointInTime.UTC().Format(time.RFC3339))
+	replay := execCommand(config.mysqlbinlog(), args...)
+	// mysqlbinlog reads the stop time in the time zone it runs in
+	replay.Env = append(os.Environ(), "TZ=UTC")
+	replay.Stderr = &stderr
+	events, err := replay.StdoutPipe()
+
+	if err != nil {
+		return
+	}
+
+	if err = replay.Start(); err != nil {
+		return
+	}
+	_, err = runCommand(events, config.mysql(), config.connection()...)
+
+	if replayErr := replay.Wait(); err == nil && replayErr != nil {
+		err = fmt.Errorf("%s: %s %s", config.mysqlbinlog(), replayErr, strings.TrimSpace(stderr.String()))
+	}
+	return
+}
+
+// replayedBinlogs lists the binlogs of the directory from the first, failing
+// when one is missing in between
+func replayedBinlogs(dir, first string) (binlogs []string, err error) {
+	var local []string
+
+	if local, err = localBinlogs(dir); err != nil {
+		return
+	}
+	expected := first
+
+	for _, binlog := range local {
+		if binlog < first {
+			continue
+		}
+
+		if binlog != expected {
+			return nil, ErrMissingBinlog(expected, dir)
+		}
+		binlogs = append(binlogs, binlog)
+		expected = nextBinlog(binlog)
+	}
+
+	if len(binlogs) == 0 {
+		return nil, ErrMissingBinlog(first, dir)
+	}
+	return
+}
+
+// nextBinlog names the binlog the server writes after the given one
+func nextBinlog(name string) string {
+	extension := path.Ext(name)
+	sequence, _ := binlogSequence(name)
+	return fmt.Sprintf("%s.%0*d", strings.TrimSuffix(name, extension), len(extension)-1, sequence+1)
+}
+
+// checkPointInTime makes sure a restore to a point in time restores the mysql
+// dump the binlogs are replayed onto, before anything is restored
+func checkPointInTime(fs flagSet) error {
+	if fs.Binlogs().PointInTime.IsZero() {
+		return nil
+	}
+
+	for _, artifact := range restoreArtifacts(fs.Tilelist(), fs.Components()) {
+		if artifact == erArtifact("mysql") {
+			return nil
+		}
+	}
+	return ErrPointInTimeWithoutMysql
+}
+
+// replayForRestore recovers the mysql server to the point in time once the
+// restore completes, when one is given
+func replayForRestore(fs flagSet) (recoveredTo *time.Time, err error) {
+	config := fs.Binlogs()
+
+	if config.PointInTime.IsZero() {
+		return
+	}
+
+	if err = ReplayBinlogs(config, fs.Dest()); err == nil {
+		recoveredTo = &config.PointInTime
+	}
+	return
+}
@@ -0,0 +1,202 @@
+package cfops_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+	"github.com/pivotalservices/gtils/persistence"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeMysql lists the binlogs of the server, and otherwise records the events
+// it was fed
+const fakeMysql = `#!/bin/sh
+bin="$(dirname "$0")"
+case "$*" in
+*"SHOW BINARY LOGS"*)
+	printf 'mysql-bin.000001\t5\nmysql-bin.000002\t10\n'
+	;;
+*)
+	cat >> "$bin/events"
+	;;
+esac
+`
+
+// fakeMysqlbinlog records its arguments and the time zone it ran in, and
+// answers with an event per binlog it replays
+const fakeMysqlbinlog = `#!/bin/sh
+bin="$(dirname "$0")"
+echo "TZ=$TZ $*" >> "$bin/calls"
+for arg in "$@"; do
+	case "$arg" in
+	--*) ;;
+	*/mysql-bin.*) echo "event of $(basename "$arg")" ;;
+	esac
+done
+`
+
+var _ = Describe("Binlogs", func() {
+	var (
+		dir    string
+		bin    string
+		config BinlogConfig
+	)
+
+	calls := func() []string {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	writeBinlogs := func(names ...string) {
+		for _, name := range names {
+			ioutil.WriteFile(path.Join(config.Dir, name), []byte("12345"), 0600)
+		}
+	}
+
+	writeDump := func(header string) {
+		ioutil.WriteFile(path.Join(dir, "mysql.backup"), []byte("-- MySQL dump\n"+header+"\nCREATE TABLE t (id int);\n"), 0600)
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "binlog")
+		bin, _ = ioutil.TempDir("", "binlog-bin")
+		ioutil.WriteFile(path.Join(bin, "mysql"), []byte(fakeMysql), 0755)
+		ioutil.WriteFile(path.Join(bin, "mysqlbinlog"), []byte(fakeMysqlbinlog), 0755)
+		config = BinlogConfig{
+			Dir:               path.Join(dir, "binlogs"),
+			Host:              "10.0.16.5",
+			User:              "admin",
+			Pass:              "secret",
+			PointInTime:       time.Date(2017, 3, 2, 14, 30, 0, 0, time.FixedZone("EST", -5*3600)),
+			MysqlBinary:       path.Join(bin, "mysql"),
+			MysqlbinlogBinary: path.Join(bin, "mysqlbinlog"),
+		}
+		os.MkdirAll(config.Dir, 0700)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		os.RemoveAll(bin)
+		persistence.MSQLDMP_DUMP_OPTIONS = ""
+	})
+
+	Describe("CaptureBinlogs", func() {
+		It("should only fetch the binlogs it does not hold in full yet", func() {
+			writeBinlogs("mysql-bin.000001")
+			captured, err := CaptureBinlogs(config)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(captured).Should(Equal([]string{"mysql-bin.000002"}))
+			Ω(calls()).Should(Equal([]string{
+				"TZ= --read-from-remote-server --raw --result-file=" + config.Dir + "/ -h 10.0.16.5 -u admin --password=secret mysql-bin.000002",
+			}))
+		})
+
+		It("should not run mysqlbinlog when every binlog is held", func() {
+			writeBinlogs("mysql-bin.000001")
+			ioutil.WriteFile(path.Join(config.Dir, "mysql-bin.000002"), []byte("0123456789"), 0600)
+			captured, err := CaptureBinlogs(config)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(captured).Should(BeEmpty())
+			Ω(path.Join(bin, "calls")).ShouldNot(BeAnExistingFile())
+		})
+	})
+
+	It("should read the coordinates a dump records", func() {
+		coordinates, ok := ReadBinlogCoordinates(strings.NewReader("-- MySQL dump\n-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000002', MASTER_LOG_POS=4411;\n"))
+		Ω(ok).Should(BeTrue())
+		Ω(coordinates).Should(Equal(BinlogCoordinates{File: "mysql-bin.000002", Position: 4411}))
+
+		_, ok = ReadBinlogCoordinates(strings.NewReader("-- MySQL dump\nCREATE TABLE t (id int);\n"))
+		Ω(ok).Should(BeFalse())
+	})
+
+	Describe("ReplayBinlogs", func() {
+		BeforeEach(func() {
+			writeDump("-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000002', MASTER_LOG_POS=4411;")
+		})
+
+		It("should replay the binlogs from the coordinates of the dump up to the point in time", func() {
+			writeBinlogs("mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003")
+			Ω(ReplayBinlogs(config, dir)).Should(Succeed())
+			Ω(calls()).Should(Equal([]string{
+				"TZ=UTC --start-position=4411 --stop-datetime=2017-03-02 19:30:00 " + path.Join(config.Dir, "mysql-bin.000002") + " " + path.Join(config.Dir, "mysql-bin.000003"),
+			}))
+			events, _ := ioutil.ReadFile(path.Join(bin, "events"))
+			Ω(string(events)).Should(Equal("event of mysql-bin.000002\nevent of mysql-bin.000003\n"))
+		})
+
+		It("should refuse to replay past a missing binlog", func() {
+			writeBinlogs("mysql-bin.000002", "mysql-bin.000004")
+			Ω(ReplayBinlogs(config, dir)).Should(MatchError(ErrMissingBinlog("mysql-bin.000003", config.Dir)))
+			Ω(path.Join(bin, "calls")).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should refuse a dump taken without binlog capture", func() {
+			writeBinlogs("mysql-bin.000002")
+			writeDump("")
+			Ω(ReplayBinlogs(config, dir)).Should(MatchError(ErrNoBinlogCoordinates("mysql.backup")))
+		})
+
+		It("should refuse a point in time before the backup completed", func() {
+			created := config.PointInTime.Add(time.Hour)
+			manifest, _ := json.Marshal(Manifest{FormatVersion: ManifestFormatVersion, Created: created})
+			ioutil.WriteFile(path.Join(dir, ManifestName), manifest, 0600)
+			Ω(ReplayBinlogs(config, dir)).Should(MatchError(ErrPointInTime(config.PointInTime, created)))
+		})
+	})
+
+	Describe("running the pipeline", func() {
+		var (
+			fs   *mockFlagSet
+			tile *mockTile
+		)
+
+		BeforeEach(func() {
+			tile = &mockTile{}
+			SupportedTiles = map[string]func() (Tile, error){
+				ER: func() (Tile, error) {
+					return tile, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "er", dest: dir, lockDir: dir, binlogs: config}
+		})
+
+		It("should have the dumps record their coordinates and capture the binlogs after a backup", func() {
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(persistence.MSQLDMP_DUMP_OPTIONS).Should(Equal("--single-transaction --master-data=2"))
+			Ω(entry.Binlogs).Should(Equal([]string{"mysql-bin.000001", "mysql-bin.000002"}))
+		})
+
+		It("should leave the dumps alone without binlog capture", func() {
+			persistence.MSQLDMP_DUMP_OPTIONS = "--single-transaction --master-data=2"
+			fs.binlogs = BinlogConfig{}
+			Ω(RunPipeline(fs, Backup)).Should(Succeed())
+			Ω(persistence.MSQLDMP_DUMP_OPTIONS).Should(BeEmpty())
+		})
+
+		It("should replay the binlogs once the restore completes, recording the point in time", func() {
+			writeDump("-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000002', MASTER_LOG_POS=4411;")
+			writeBinlogs("mysql-bin.000002")
+			entry, err := RunPipelineResult(context.Background(), fs, Restore)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(tile.RunCount).Should(Equal(1))
+			Ω(*entry.RecoveredTo).Should(Equal(config.PointInTime))
+		})
+
+		It("should not restore anything to a point in time without mysql", func() {
+			fs.tileListFlag = "opsmanager"
+			SupportedTiles[OpsMgr] = SupportedTiles[ER]
+			Ω(RunPipeline(fs, Restore)).Should(Equal(ErrPointInTimeWithoutMysql))
+			Ω(tile.RunCount).Should(Equal(0))
+		})
+	})
+})
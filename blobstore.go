@@ -0,0 +1,134 @@
+package cfops
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrUnknownBlobstoreCategoryFormat = "unknown blobstore category %s, expected one of %s"
+)
+
+var (
+	// BlobstoreCategories maps the categories of blobs a restore can be
+	// limited to onto their directory in the nfs server archive
+	BlobstoreCategories = map[string]string{
+		"droplets":   "cc-droplets",
+		"packages":   "cc-packages",
+		"buildpacks": "cc-buildpacks",
+		"resources":  "cc-resources",
+	}
+)
+
+func ErrUnknownBlobstoreCategory(category string) error {
+	var known []string
+
+	for name := range BlobstoreCategories {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	return fmt.Errorf(ErrUnknownBlobstoreCategoryFormat, category, strings.Join(known, ", "))
+}
+
+// BlobstoreDirs are the directories of the nfs server archive holding the
+// csv list of blobstore categories
+func BlobstoreDirs(categories string) (dirs []string, err error) {
+	for _, category := range strings.Split(categories, ",") {
+		if category = strings.ToLower(strings.TrimSpace(category)); category == "" {
+			continue
+		}
+		dir, ok := BlobstoreCategories[category]
+
+		if !ok {
+			return nil, ErrUnknownBlobstoreCategory(category)
+		}
+		dirs = append(dirs, path.Join(cfbackup.NFS_ARCHIVE_DIR, dir))
+	}
+	return
+}
+
+// FilterBlobstore copies the entries of an nfs server archive under the
+// directories, and the directories leading to them, from in to out. The nfs
+// server only extracts what the archive holds, so restoring the filtered
+// archive leaves every other blob in place
+func FilterBlobstore(in io.Reader, out io.Writer, dirs []string) (files int, err error) {
+	var (
+		contents io.ReadCloser
+		header   *tar.Header
+	)
+
+	if contents, err = decompressAhead(in); err != nil {
+		return
+	}
+	defer contents.Close()
+	entries := tar.NewReader(contents)
+	filtered := cfbackup.NewBlobArchive(out)
+
+	for header, err = entries.Next(); err == nil; header, err = entries.Next() {
+		if !blobstoreEntrySelected(header.Name, dirs) {
+			continue
+		}
+
+		if err = filtered.WriteHeader(header); err != nil {
+			return
+		}
+
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			files++
+
+			if _, err = io.Copy(filtered, entries); err != nil {
+				return
+			}
+		}
+	}
+
+	if err == io.EOF {
+		err = filtered.Close()
+	}
+	return
+}
+
+func blobstoreEntrySelected(name string, dirs []string) bool {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+
+	for _, dir := range dirs {
+		if name == dir || strings.HasPrefix(name, dir+"/") || strings.HasPrefix(dir, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterBlobstoreForRestore replaces the nfs server archive of the
+// destination with one holding only the blobstore categories the restore is
+// limited to, when it is, returning the function putting the original back
+func filterBlobstoreForRestore(fs flagSet) (restore func(), err error) {
+	var dirs []string
+	restore = func() {}
+
+	if dirs, err = BlobstoreDirs(fs.BlobstoreCategories()); err != nil || len(dirs) == 0 {
+		return
+	}
+	blobstore := erArtifact("nfs_server")
+
+	for _, artifact := range restoreArtifacts(fs.Tilelist(), fs.Components()) {
+		if artifact == blobstore {
+			return substituteArtifacts(fs.Dest(), []string{blobstore}, func(artifact string, in io.Reader, out io.Writer) (err error) {
+				var files int
+
+				if files, err = FilterBlobstore(in, out, dirs); err == nil {
+					lo.G.Info("restoring %d blobs of %s from %s", files, fs.BlobstoreCategories(), artifact)
+				}
+				return
+			})
+		}
+	}
+	return
+}
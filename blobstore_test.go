@@ -0,0 +1,169 @@
+package cfops_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blobstoreTile records the entries of the nfs server archive it is restored from
+type blobstoreTile struct {
+	archivePath string
+	entries     []string
+}
+
+func (s *blobstoreTile) Restore() (err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(s.archivePath); err == nil {
+		s.entries = blobstoreEntries(contents)
+	}
+	return
+}
+
+func (s *blobstoreTile) Backup() error {
+	return nil
+}
+
+func blobstoreArchive(entries ...string) []byte {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	writer := tar.NewWriter(gz)
+
+	for _, name := range entries {
+		if name[len(name)-1] == '/' {
+			writer.WriteHeader(&tar.Header{Name: name, Mode: 0700, Typeflag: tar.TypeDir})
+			continue
+		}
+		writer.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(name)), Typeflag: tar.TypeReg})
+		writer.Write([]byte(name))
+	}
+	writer.Close()
+	gz.Close()
+	return archive.Bytes()
+}
+
+func blobstoreEntries(archive []byte) (entries []string) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	Ω(err).ShouldNot(HaveOccurred())
+	reader := tar.NewReader(gz)
+
+	for header, err := reader.Next(); err != io.EOF; header, err = reader.Next() {
+		Ω(err).ShouldNot(HaveOccurred())
+		entries = append(entries, header.Name)
+	}
+	sort.Strings(entries)
+	return
+}
+
+var _ = Describe("Selective blobstore restore", func() {
+	archive := blobstoreArchive(
+		"shared/",
+		"shared/cc-droplets/",
+		"shared/cc-droplets/ab/droplet",
+		"shared/cc-packages/cd/package",
+		"shared/cc-buildpacks/ruby_buildpack",
+		"shared/cc-resources/ef/resource",
+	)
+
+	It("should keep only the blobs of the categories and the directories leading to them", func() {
+		var filtered bytes.Buffer
+		dirs, err := BlobstoreDirs("droplets, buildpacks")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		files, err := FilterBlobstore(bytes.NewReader(archive), &filtered, dirs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(Equal(2))
+		Ω(blobstoreEntries(filtered.Bytes())).Should(Equal([]string{
+			"shared/",
+			"shared/cc-buildpacks/ruby_buildpack",
+			"shared/cc-droplets/",
+			"shared/cc-droplets/ab/droplet",
+		}))
+	})
+
+	It("should store the blobs that are compressed already rather than compress them again", func() {
+		var droplet, source, filtered bytes.Buffer
+		gz := gzip.NewWriter(&droplet)
+		gz.Write(bytes.Repeat([]byte("droplet bits "), 1000))
+		gz.Close()
+		text := bytes.Repeat([]byte("buildpack source "), 1000)
+
+		writer := tar.NewWriter(&source)
+		writer.WriteHeader(&tar.Header{Name: "shared/cc-droplets/ab/droplet", Mode: 0600, Size: int64(droplet.Len()), Typeflag: tar.TypeReg})
+		writer.Write(droplet.Bytes())
+		writer.WriteHeader(&tar.Header{Name: "shared/cc-buildpacks/source", Mode: 0600, Size: int64(len(text)), Typeflag: tar.TypeReg})
+		writer.Write(text)
+		writer.Close()
+		var compressed bytes.Buffer
+		gz = gzip.NewWriter(&compressed)
+		gz.Write(source.Bytes())
+		gz.Close()
+
+		dirs, _ := BlobstoreDirs("droplets, buildpacks")
+		_, err := FilterBlobstore(&compressed, &filtered, dirs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(bytes.Contains(filtered.Bytes(), droplet.Bytes())).Should(BeTrue())
+		Ω(bytes.Contains(filtered.Bytes(), text)).Should(BeFalse())
+
+		reader, _ := gzip.NewReader(bytes.NewReader(filtered.Bytes()))
+		read, _ := ioutil.ReadAll(reader)
+		Ω(read).Should(Equal(source.Bytes()))
+	})
+
+	It("should refuse an unknown category", func() {
+		_, err := BlobstoreDirs("droplets, logs")
+		Ω(err).Should(MatchError(ErrUnknownBlobstoreCategory("logs")))
+	})
+
+	Describe("restoring the elastic runtime", func() {
+		var (
+			dir         string
+			archivePath string
+			er          *blobstoreTile
+			fs          *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "blobstore")
+			archivePath = path.Join(dir, "nfs_server.backup")
+			ioutil.WriteFile(archivePath, archive, 0600)
+
+			er = &blobstoreTile{archivePath: archivePath}
+			SupportedTiles = map[string]func() (Tile, error){
+				ER: func() (Tile, error) {
+					return er, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "er", dest: dir, lockDir: dir, blobstore: "droplets"}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should restore only the chosen categories and leave the backup as it was", func() {
+			Ω(RunPipeline(fs, Restore)).Should(Succeed())
+			Ω(er.entries).Should(Equal([]string{"shared/", "shared/cc-droplets/", "shared/cc-droplets/ab/droplet"}))
+
+			contents, _ := ioutil.ReadFile(archivePath)
+			Ω(contents).Should(Equal(archive))
+		})
+
+		It("should restore the whole blobstore when no category is chosen", func() {
+			fs.blobstore = ""
+			Ω(RunPipeline(fs, Restore)).Should(Succeed())
+			Ω(er.entries).Should(HaveLen(6))
+		})
+	})
+})
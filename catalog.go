@@ -0,0 +1,440 @@
+package cfops
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	SetRunning    = "running"
+	SetComplete   = "complete"
+	SetIncomplete = "incomplete"
+	SetAborted    = "aborted"
+	// SetPartial is a backup set that completed without the stores whose vms
+	// could not be reached
+	SetPartial = "partial"
+
+	ComponentSucceeded = "succeeded"
+	ComponentFailed    = "failed"
+	ComponentSkipped   = "skipped"
+
+	// AllTiles names the single component recorded for a run of the builtin pipeline
+	AllTiles = "ALL"
+
+	ErrNoCompleteBackupMsg = "no complete backup found in the catalog"
+	catalogTimeFormat      = "20060102T150405Z"
+)
+
+var ErrNoCompleteBackup = errors.New(ErrNoCompleteBackupMsg)
+
+type (
+	// Catalog is the persisted history of backup and restore runs
+	Catalog struct {
+		Entries []*CatalogEntry `json:"entries"`
+		path    string
+		// saved are the entries as last read or saved, by id, to tell the
+		// ones this run changed from those it only read
+		saved map[string]string
+	}
+
+	// CatalogEntry records one run of a set of tiles. A set is only complete
+	// when every mandatory component in it succeeded
+	CatalogEntry struct {
+		ID          string            `json:"id"`
+		Action      string            `json:"action"`
+		Foundation  string            `json:"foundation,omitempty"`
+		Destination string            `json:"destination"`
+		Status      string            `json:"status"`
+		Started     time.Time         `json:"started"`
+		Finished    time.Time         `json:"finished,omitempty"`
+		Components  []ComponentResult `json:"components"`
+		// ArtifactsRemoved is set when the partial artifacts of a failed set were deleted
+		ArtifactsRemoved bool `json:"artifacts_removed,omitempty"`
+		// IdempotencyKey identifies retries of the same run, e.g. a ci build
+		IdempotencyKey string `json:"idempotency_key,omitempty"`
+		// Registry is the digest reference of the manifest a backup was pushed as
+		Registry string `json:"registry,omitempty"`
+		// ResticSnapshot is the id of the restic snapshot a backup was kept as
+		ResticSnapshot string `json:"restic_snapshot,omitempty"`
+		// Binlogs are the mysql binlogs a backup captured alongside its dumps
+		Binlogs []string `json:"binlogs,omitempty"`
+		// RecoveredTo is the point in time a restore replayed the binlogs up to
+		RecoveredTo *time.Time `json:"recovered_to,omitempty"`
+		// Migrations are the migrations a restore applied to bring the backup
+		// to the elastic runtime version of the foundation
+		Migrations []string `json:"migrations,omitempty"`
+		// Quiesced are the instance groups a backup stopped while it ran
+		Quiesced []string `json:"quiesced,omitempty"`
+		// DeploymentManifests are the manifests of the bosh deployments a
+		// backup captured alongside its artifacts
+		DeploymentManifests []string `json:"deployment_manifests,omitempty"`
+		// Snapshots are the snapshots of the disks of the stores a backup
+		// snapshotted rather than dumped
+		Snapshots []Snapshot `json:"snapshots,omitempty"`
+		// Verification is the outcome of the last verify of a backup
+		Verification *VerificationResult `json:"verification,omitempty"`
+		// ApplyChanges is the outcome of the apply changes a restore started
+		ApplyChanges *ApplyChangesResult `json:"apply_changes,omitempty"`
+		// Health is what the health check a restore ended with, or a backup
+		// started with, found
+		Health *HealthReport `json:"health,omitempty"`
+		// SmokeTests are the outcome of the smoke tests a restore ended with
+		SmokeTests *SmokeTestReport `json:"smoke_tests,omitempty"`
+		// Transfer is the sftp traffic of the run, when it had any
+		Transfer *TransferStats `json:"transfer,omitempty"`
+	}
+
+	// TransferStats counts the packets a run sent over sftp, by type, and the
+	// bytes of every packet sent and received
+	TransferStats struct {
+		BytesSent     uint64            `json:"bytes_sent"`
+		BytesReceived uint64            `json:"bytes_received"`
+		Packets       map[string]uint64 `json:"packets"`
+		// Retransmits are the writes sent again after the server failed them
+		Retransmits uint64 `json:"retransmits"`
+	}
+
+	// VerificationResult is the outcome of verifying the artifacts of a backup
+	VerificationResult struct {
+		Time   time.Time `json:"time"`
+		Deep   bool      `json:"deep"`
+		Passed bool      `json:"passed"`
+		Error  string    `json:"error,omitempty"`
+	}
+
+	// ComponentResult is the outcome of a single tile within a set
+	ComponentResult struct {
+		Name    string  `json:"name"`
+		Status  string  `json:"status"`
+		Error   string  `json:"error,omitempty"`
+		Seconds float64 `json:"seconds,omitempty"`
+		// Bytes is the size of the artifacts the component wrote or read
+		Bytes  int64         `json:"bytes,omitempty"`
+		Phases []PhaseTiming `json:"phases,omitempty"`
+		// Unreachable are the stores of the tile a backup left out, as their
+		// vms could not be reached
+		Unreachable []string `json:"unreachable,omitempty"`
+	}
+
+	// PhaseTiming is the time a component spent in one phase, e.g. connecting
+	// to or dumping its databases
+	PhaseTiming struct {
+		Name    string  `json:"name"`
+		Seconds float64 `json:"seconds"`
+	}
+)
+
+// OpenCatalog reads the catalog at the given path, starting an empty one if
+// it does not exist yet
+func OpenCatalog(catalogPath string) (catalog *Catalog, err error) {
+	var contents []byte
+	catalog = &Catalog{path: catalogPath}
+
+	if contents, err = ioutil.ReadFile(catalogPath); err == nil {
+		err = json.Unmarshal(contents, catalog)
+
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	catalog.remember()
+	return
+}
+
+// Save atomically replaces the catalog file with the current entries, merged
+// with any entries other runs saved since the catalog was opened. The catalog
+// file is locked from reading those entries until it is replaced, so that
+// runs sharing it save one at a time
+func (s *Catalog) Save() (err error) {
+	var (
+		contents []byte
+		onDisk   *Catalog
+		unlock   func()
+	)
+
+	if err = os.MkdirAll(path.Dir(s.path), 0700); err != nil {
+		return
+	}
+
+	if unlock, err = lockCatalog(s.path); err != nil {
+		return
+	}
+	defer unlock()
+
+	if onDisk, err = OpenCatalog(s.path); err != nil {
+		return
+	}
+	s.merge(onDisk.Entries)
+
+	if contents, err = json.MarshalIndent(s, "", "  "); err != nil {
+		return
+	}
+	tmp := s.path + ".tmp"
+
+	if err = ioutil.WriteFile(tmp, contents, 0600); err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+
+	if err == nil {
+		s.remember()
+	}
+	return
+}
+
+// lockCatalog takes an exclusive lock on the catalog at the path, held until
+// the returned function is called. The lock is on a file of its own, since
+// saving replaces the catalog file
+func lockCatalog(catalogPath string) (unlock func(), err error) {
+	var file *os.File
+
+	if file, err = os.OpenFile(catalogPath+".lock", os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		return
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// merge takes in the entries other runs saved: those the catalog does not
+// hold, and the saved copy of those it holds without having changed them,
+// which another run may have finished since
+func (s *Catalog) merge(entries []*CatalogEntry) {
+	known := make(map[string]*CatalogEntry)
+
+	for _, entry := range s.Entries {
+		known[entry.ID] = entry
+	}
+
+	for _, entry := range entries {
+		if held, ok := known[entry.ID]; !ok {
+			s.Entries = append(s.Entries, entry)
+		} else if !s.changed(held) {
+			*held = *entry
+		}
+	}
+	sort.Sort(byStarted(s.Entries))
+}
+
+// remember records the entries as saved
+func (s *Catalog) remember() {
+	s.saved = make(map[string]string)
+
+	for _, entry := range s.Entries {
+		contents, _ := json.Marshal(entry)
+		s.saved[entry.ID] = string(contents)
+	}
+}
+
+// changed tells whether the entry was added or changed since the catalog was
+// last read or saved
+func (s *Catalog) changed(entry *CatalogEntry) bool {
+	contents, _ := json.Marshal(entry)
+	saved, ok := s.saved[entry.ID]
+	return !ok || saved != string(contents)
+}
+
+type byStarted []*CatalogEntry
+
+func (s byStarted) Len() int           { return len(s) }
+func (s byStarted) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStarted) Less(i, j int) bool { return s[i].Started.Before(s[j].Started) }
+
+// Begin adds a running entry for the action against the destination
+func (s *Catalog) Begin(action, destination string) (entry *CatalogEntry) {
+	entry = NewCatalogEntry(action, destination)
+	s.Entries = append(s.Entries, entry)
+	return
+}
+
+// BeginKeyed is Begin for a run identified by an idempotency key. An earlier
+// run of the action with the same key is started over in place rather than
+// recorded twice, unless it completed, in which case it is returned as done
+func (s *Catalog) BeginKeyed(action, destination, key string) (entry *CatalogEntry, done bool) {
+	if key == "" {
+		return s.Begin(action, destination), false
+	}
+
+	for _, existing := range s.Entries {
+		if existing.Action == action && existing.IdempotencyKey == key {
+			if existing.Status == SetComplete {
+				return existing, true
+			}
+			id := existing.ID
+			*existing = *NewCatalogEntry(action, destination)
+			existing.ID, existing.IdempotencyKey = id, key
+			return existing, false
+		}
+	}
+	entry = s.Begin(action, destination)
+	entry.IdempotencyKey = key
+	return
+}
+
+// Continue is Begin for a run carrying on an interrupted one: the entry of the
+// interrupted run is started over in place, keeping its id, or a new entry is
+// added with that id when the catalog never recorded it
+func (s *Catalog) Continue(action, destination, id string) (entry *CatalogEntry) {
+	for _, existing := range s.Entries {
+		if existing.Action == action && existing.ID == id {
+			key := existing.IdempotencyKey
+			*existing = *NewCatalogEntry(action, destination)
+			existing.ID, existing.IdempotencyKey = id, key
+			return existing
+		}
+	}
+	entry = s.Begin(action, destination)
+	entry.ID = id
+	return
+}
+
+// NewCatalogEntry starts a running entry for the action against the destination
+func NewCatalogEntry(action, destination string) *CatalogEntry {
+	return &CatalogEntry{
+		ID:          NewRunID(),
+		Action:      action,
+		Destination: destination,
+		Status:      SetRunning,
+		Started:     time.Now().UTC(),
+	}
+}
+
+// Latest returns the most recent complete backup, never an incomplete or
+// still running set
+func (s *Catalog) Latest() (entry *CatalogEntry, err error) {
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		if s.Entries[i].Action == Backup && s.Entries[i].Status == SetComplete {
+			return s.Entries[i], nil
+		}
+	}
+	return nil, ErrNoCompleteBackup
+}
+
+// RecordVerification records the outcome of verifying the destination on the
+// newest backup of it, reporting whether the catalog has such a backup
+func (s *Catalog) RecordVerification(destination string, deep bool, err error) (recorded bool) {
+	destination = path.Clean(destination)
+
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		if entry := s.Entries[i]; entry.Action == Backup && path.Clean(entry.Destination) == destination {
+			entry.Verification = &VerificationResult{Time: time.Now().UTC(), Deep: deep, Passed: err == nil}
+
+			if err != nil {
+				entry.Verification.Error = err.Error()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Record adds the outcome of a component to the set
+func (s *CatalogEntry) Record(name string, err error) {
+	s.Add(ComponentResult{Name: name}, err)
+}
+
+// Add appends the result of a component to the set, as failed when err is set
+func (s *CatalogEntry) Add(result ComponentResult, err error) {
+	result.Status = ComponentSucceeded
+
+	if err != nil {
+		result.Status = ComponentFailed
+		result.Error = err.Error()
+	}
+	s.Components = append(s.Components, result)
+}
+
+// Bytes is the size of the artifacts of every component in the set
+func (s *CatalogEntry) Bytes() (bytes int64) {
+	for _, c := range s.Components {
+		bytes += c.Bytes
+	}
+	return
+}
+
+// LastSuccess returns when the most recent complete set for the action finished
+func (s *Catalog) LastSuccess(action string) (finished time.Time) {
+	for i := len(s.Entries) - 1; i >= 0; i-- {
+		if s.Entries[i].Action == action && s.Entries[i].Status == SetComplete {
+			return s.Entries[i].Finished
+		}
+	}
+	return
+}
+
+// Skip records a component that was never attempted
+func (s *CatalogEntry) Skip(name string) {
+	s.Components = append(s.Components, ComponentResult{Name: name, Status: ComponentSkipped})
+}
+
+// Finish marks the set complete only if no component failed or was skipped,
+// and partial when a component left out stores it could not reach
+func (s *CatalogEntry) Finish() {
+	s.Finished = time.Now().UTC()
+	s.Status = SetComplete
+
+	for _, c := range s.Components {
+		if c.Status != ComponentSucceeded {
+			s.Status = SetIncomplete
+			break
+		}
+
+		if len(c.Unreachable) > 0 {
+			s.Status = SetPartial
+		}
+	}
+}
+
+// Succeeded tells whether every component of the set ran, whether or not it
+// left out stores it could not reach
+func (s *CatalogEntry) Succeeded() bool {
+	return s.Status == SetComplete || s.Status == SetPartial
+}
+
+// Unreachable are the stores every component of the set left out
+func (s *CatalogEntry) Unreachable() (stores []string) {
+	for _, c := range s.Components {
+		stores = append(stores, c.Unreachable...)
+	}
+	return
+}
+
+// LatestBackup returns the destination of the newest complete backup in the catalog
+func LatestBackup(catalogPath string) (destination string, err error) {
+	var (
+		catalog *Catalog
+		entry   *CatalogEntry
+	)
+
+	if catalog, err = OpenCatalog(catalogPath); err == nil {
+
+		if entry, err = catalog.Latest(); err == nil {
+			destination = entry.Destination
+		}
+	}
+	return
+}
+
+// NewRunID returns a sortable, unique identifier for a run
+func NewRunID() string {
+	suffix := make([]byte, 4)
+
+	if _, err := rand.Read(suffix); err != nil {
+		lo.G.Error("unable to read random run id suffix: %s", err)
+	}
+	return time.Now().UTC().Format(catalogTimeFormat) + "-" + hex.EncodeToString(suffix)
+}
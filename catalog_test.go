@@ -0,0 +1,206 @@
+package cfops_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Catalog", func() {
+	var (
+		dir         string
+		catalogPath string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "catalog")
+		catalogPath = path.Join(dir, "catalog.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("OpenCatalog", func() {
+		It("should start an empty catalog when the file does not exist", func() {
+			catalog, err := OpenCatalog(catalogPath)
+			Ω(err).Should(BeNil())
+			Ω(catalog.Entries).Should(BeEmpty())
+		})
+
+		It("should read back saved entries", func() {
+			catalog, _ := OpenCatalog(catalogPath)
+			entry := catalog.Begin(Backup, "/backups/one")
+			entry.Record(OpsMgr, nil)
+			entry.Finish()
+			Ω(catalog.Save()).Should(BeNil())
+
+			reopened, err := OpenCatalog(catalogPath)
+			Ω(err).Should(BeNil())
+			Ω(reopened.Entries).Should(HaveLen(1))
+			Ω(reopened.Entries[0].ID).Should(Equal(entry.ID))
+			Ω(reopened.Entries[0].Status).Should(Equal(SetComplete))
+		})
+	})
+
+	Describe("Save", func() {
+		It("should keep what other runs saved of the entries it did not change", func() {
+			first, _ := OpenCatalog(catalogPath)
+			second, _ := OpenCatalog(catalogPath)
+			a := first.Begin(Backup, "/backups/a")
+			Ω(first.Save()).Should(BeNil())
+			b := second.Begin(Backup, "/backups/b")
+			Ω(second.Save()).Should(BeNil())
+			a.Add(ComponentResult{Name: OpsMgr, Status: ComponentSucceeded}, nil)
+			a.Finish()
+			Ω(first.Save()).Should(BeNil())
+			b.Add(ComponentResult{Name: OpsMgr, Status: ComponentSucceeded}, nil)
+			b.Finish()
+			Ω(second.Save()).Should(BeNil())
+
+			saved, _ := OpenCatalog(catalogPath)
+			Ω(saved.Entries).Should(HaveLen(2))
+
+			for _, entry := range saved.Entries {
+				Ω(entry.Status).Should(Equal(SetComplete), entry.Destination)
+			}
+		})
+
+		It("should keep every entry of catalogs saved at once", func() {
+			done := make(chan error)
+
+			for i := 0; i < 8; i++ {
+				go func() {
+					catalog, err := OpenCatalog(catalogPath)
+
+					if err == nil {
+						catalog.Begin(Backup, "/backups")
+						err = catalog.Save()
+					}
+					done <- err
+				}()
+			}
+
+			for i := 0; i < 8; i++ {
+				Ω(<-done).Should(BeNil())
+			}
+			saved, _ := OpenCatalog(catalogPath)
+			Ω(saved.Entries).Should(HaveLen(8))
+		})
+	})
+
+	Describe("CatalogEntry.Finish", func() {
+		It("should mark a set with a failed component incomplete", func() {
+			entry := &CatalogEntry{}
+			entry.Record(OpsMgr, nil)
+			entry.Record(ER, errors.New("failed"))
+			entry.Finish()
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+		})
+
+		It("should mark a set with a skipped component incomplete", func() {
+			entry := &CatalogEntry{}
+			entry.Record(OpsMgr, nil)
+			entry.Skip(ER)
+			entry.Finish()
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+		})
+
+		It("should mark a set that left out unreachable stores partial", func() {
+			entry := &CatalogEntry{}
+			entry.Record(OpsMgr, nil)
+			entry.Add(ComponentResult{Name: ER, Unreachable: []string{"ccdb"}}, nil)
+			entry.Finish()
+			Ω(entry.Status).Should(Equal(SetPartial))
+			Ω(entry.Succeeded()).Should(BeTrue())
+			Ω(entry.Unreachable()).Should(Equal([]string{"ccdb"}))
+		})
+
+		It("should not take a partial set for a complete one", func() {
+			catalog, _ := OpenCatalog(catalogPath)
+			entry := catalog.Begin(Backup, "/backups/partial")
+			entry.Add(ComponentResult{Name: ER, Unreachable: []string{"ccdb"}}, nil)
+			entry.Finish()
+			catalog.Save()
+			_, err := LatestBackup(catalogPath)
+			Ω(err).Should(Equal(ErrNoCompleteBackup))
+		})
+	})
+
+	Describe("RunPipeline with an idempotency key", func() {
+		var (
+			runs int
+			fs   *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			runs = 0
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					runs++
+					return &mockTile{}, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir, catalog: catalogPath, idempotency: "build-42"}
+		})
+
+		It("should not repeat or record twice a run that completed", func() {
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			catalog, _ := OpenCatalog(catalogPath)
+			Ω(catalog.Entries).Should(HaveLen(1))
+			Ω(catalog.Entries[0].IdempotencyKey).Should(Equal("build-42"))
+			Ω(runs).Should(Equal(1))
+		})
+
+		It("should start a run that did not complete over in the same entry", func() {
+			catalog, _ := OpenCatalog(catalogPath)
+			failed := catalog.Begin(Backup, dir)
+			failed.IdempotencyKey = "build-42"
+			failed.Record(OpsMgr, errors.New("failed"))
+			failed.Finish()
+			catalog.Save()
+
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			Ω(entry.ID).Should(Equal(failed.ID))
+			Ω(entry.Status).Should(Equal(SetComplete))
+			catalog, _ = OpenCatalog(catalogPath)
+			Ω(catalog.Entries).Should(HaveLen(1))
+		})
+	})
+
+	Describe("LatestBackup", func() {
+		Context("when the newest backup is incomplete", func() {
+			BeforeEach(func() {
+				catalog, _ := OpenCatalog(catalogPath)
+				complete := catalog.Begin(Backup, "/backups/complete")
+				complete.Record(ER, nil)
+				complete.Finish()
+				incomplete := catalog.Begin(Backup, "/backups/incomplete")
+				incomplete.Record(ER, errors.New("failed"))
+				incomplete.Finish()
+				catalog.Begin(Backup, "/backups/running")
+				catalog.Save()
+			})
+
+			It("should select the newest complete backup", func() {
+				Ω(LatestBackup(catalogPath)).Should(Equal("/backups/complete"))
+			})
+		})
+
+		Context("when there is no complete backup", func() {
+			It("should return an error", func() {
+				_, err := LatestBackup(catalogPath)
+				Ω(err).Should(Equal(ErrNoCompleteBackup))
+			})
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package cfops
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultCertificateWindow is how soon a certificate has to expire to be
+	// reported as expiring when no window is given
+	DefaultCertificateWindow     = 30 * 24 * time.Hour
+	CertificateExpired           = "expired"
+	CertificateExpiring          = "expiring"
+	CertificateValid             = "valid"
+	ErrNoCertificateInventoryMsg = "ops manager has no certificate inventory, it is only reported from ops manager 2.0 on"
+	deployedCertificatesPath     = "/api/v0/deployed/certificates"
+)
+
+var ErrNoCertificateInventory = errors.New(ErrNoCertificateInventoryMsg)
+
+type (
+	// Certificate is a certificate ops manager reports as deployed, whether
+	// it keeps it itself or in credhub
+	Certificate struct {
+		Product      string    `json:"product_guid"`
+		Property     string    `json:"property_reference,omitempty"`
+		VariablePath string    `json:"variable_path,omitempty"`
+		Location     string    `json:"location"`
+		Issuer       string    `json:"issuer"`
+		IsCA         bool      `json:"is_ca"`
+		Configurable bool      `json:"configurable"`
+		ValidFrom    time.Time `json:"valid_from"`
+		ValidUntil   time.Time `json:"valid_until"`
+	}
+
+	// CertificateReport is the certificate inventory of a foundation, soonest
+	// to expire first, each with how long it has left
+	CertificateReport struct {
+		Generated     time.Time           `json:"generated"`
+		Foundation    string              `json:"foundation"`
+		WindowSeconds int64               `json:"window_seconds"`
+		Certificates  []CertificateExpiry `json:"certificates"`
+	}
+
+	// CertificateExpiry is a certificate and whether it is valid, expiring
+	// within the window of the report or already expired
+	CertificateExpiry struct {
+		Certificate
+		Status        string `json:"status"`
+		DaysRemaining int    `json:"days_remaining"`
+	}
+)
+
+// DeployedCertificates asks ops manager for the certificates of the
+// foundation
+func DeployedCertificates(host, user, pass string) (certificates []Certificate, err error) {
+	var (
+		status   int
+		response struct {
+			Certificates []Certificate `json:"certificates"`
+		}
+	)
+	client := &opsManagerClient{base: opsManagerBase(host), user: user, pass: pass}
+
+	if status, err = client.do("GET", deployedCertificatesPath, nil, &response); err == nil && status == http.StatusNotFound {
+		err = ErrNoCertificateInventory
+	}
+	return response.Certificates, err
+}
+
+// NewCertificateReport reports on the certificates as of now, those expiring
+// within the window as expiring
+func NewCertificateReport(foundation string, certificates []Certificate, now time.Time, window time.Duration) (report CertificateReport) {
+	if window <= 0 {
+		window = DefaultCertificateWindow
+	}
+	report = CertificateReport{Generated: now.UTC(), Foundation: foundation, WindowSeconds: int64(window.Seconds())}
+
+	for _, certificate := range certificates {
+		remaining := certificate.ValidUntil.Sub(now)
+		expiry := CertificateExpiry{Certificate: certificate, Status: CertificateValid, DaysRemaining: int(remaining.Hours() / 24)}
+
+		switch {
+		case remaining <= 0:
+			expiry.Status = CertificateExpired
+
+		case remaining <= window:
+			expiry.Status = CertificateExpiring
+		}
+		report.Certificates = append(report.Certificates, expiry)
+	}
+	sort.SliceStable(report.Certificates, func(i, j int) bool {
+		return report.Certificates[i].ValidUntil.Before(report.Certificates[j].ValidUntil)
+	})
+	return
+}
+
+// Expiring is how many certificates have expired or expire within the window
+func (s CertificateReport) Expiring() (expiring int) {
+	for _, certificate := range s.Certificates {
+		if certificate.Status != CertificateValid {
+			expiring++
+		}
+	}
+	return
+}
+
+// WriteCertificateReport renders the report as json, or as csv with a row
+// per certificate
+func WriteCertificateReport(w io.Writer, report CertificateReport, format string) (err error) {
+	switch format {
+	case ReportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+
+	case ReportCSV:
+		out := csv.NewWriter(w)
+		out.Write([]string{"foundation", "product_guid", "property_reference", "variable_path", "location", "issuer", "is_ca", "valid_from", "valid_until", "days_remaining", "status"})
+
+		for _, certificate := range report.Certificates {
+			out.Write([]string{
+				report.Foundation,
+				certificate.Product,
+				certificate.Property,
+				certificate.VariablePath,
+				certificate.Location,
+				certificate.Issuer,
+				strconv.FormatBool(certificate.IsCA),
+				formatTime(certificate.ValidFrom),
+				formatTime(certificate.ValidUntil),
+				strconv.Itoa(certificate.DaysRemaining),
+				certificate.Status,
+			})
+		}
+		out.Flush()
+		err = out.Error()
+
+	default:
+		err = ErrReportFormat(format)
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package cfops_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Certificates", func() {
+	var now = time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+
+	certificates := []Certificate{
+		{Product: "cf-0123", Property: ".properties.networking_poe_ssl_certs", Location: "ops_manager", ValidUntil: now.AddDate(1, 0, 0)},
+		{Product: "p-bosh-0456", Property: ".properties.director_ssl", Location: "ops_manager", ValidUntil: now.AddDate(0, 0, 10)},
+		{Product: "cf-0123", VariablePath: "/p-bosh/cf-0123/diego-instance-identity-intermediate-ca", Location: "credhub", IsCA: true, ValidUntil: now.AddDate(0, 0, -1)},
+	}
+
+	Describe("asking ops manager for them", func() {
+		var (
+			server *httptest.Server
+			hasV0  bool
+		)
+
+		BeforeEach(func() {
+			hasV0 = true
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !hasV0 || r.URL.Path != "/api/v0/deployed/certificates" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, `{"certificates":[{"configurable":true,"is_ca":false,"property_reference":".properties.networking_poe_ssl_certs","product_guid":"cf-0123","location":"ops_manager","variable_path":null,"issuer":"/C=US/O=Pivotal","valid_from":"2026-01-01T00:00:00Z","valid_until":"2028-01-01T00:00:00Z"}]}`)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should list the deployed certificates", func() {
+			deployed, err := DeployedCertificates(server.URL, "admin", "pass")
+			Ω(err).Should(BeNil())
+			Ω(deployed).Should(HaveLen(1))
+			Ω(deployed[0].Product).Should(Equal("cf-0123"))
+			Ω(deployed[0].Issuer).Should(Equal("/C=US/O=Pivotal"))
+			Ω(deployed[0].ValidUntil).Should(Equal(time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should say when ops manager has no certificate inventory", func() {
+			hasV0 = false
+			_, err := DeployedCertificates(server.URL, "admin", "pass")
+			Ω(err).Should(Equal(ErrNoCertificateInventory))
+		})
+	})
+
+	Describe("reporting on them", func() {
+		It("should list the soonest to expire first, with whether they are expiring", func() {
+			report := NewCertificateReport("opsman.prod", certificates, now, 30*24*time.Hour)
+			Ω(report.Certificates).Should(HaveLen(3))
+			Ω(report.Certificates[0].Status).Should(Equal(CertificateExpired))
+			Ω(report.Certificates[0].DaysRemaining).Should(Equal(-1))
+			Ω(report.Certificates[1].Status).Should(Equal(CertificateExpiring))
+			Ω(report.Certificates[1].DaysRemaining).Should(Equal(10))
+			Ω(report.Certificates[2].Status).Should(Equal(CertificateValid))
+			Ω(report.Expiring()).Should(Equal(2))
+		})
+
+		It("should take the default window when given none", func() {
+			report := NewCertificateReport("opsman.prod", certificates[:2], now, 0)
+			Ω(report.WindowSeconds).Should(Equal(int64(DefaultCertificateWindow.Seconds())))
+			Ω(report.Expiring()).Should(Equal(1))
+		})
+
+		It("should render a csv row per certificate", func() {
+			var out bytes.Buffer
+			report := NewCertificateReport("opsman.prod", certificates, now, 30*24*time.Hour)
+			Ω(WriteCertificateReport(&out, report, ReportCSV)).Should(BeNil())
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			Ω(lines).Should(HaveLen(4))
+			Ω(lines[0]).Should(HavePrefix("foundation,product_guid,"))
+			Ω(lines[1]).Should(HavePrefix("opsman.prod,cf-0123,,/p-bosh/cf-0123/diego-instance-identity-intermediate-ca,credhub,"))
+			Ω(lines[1]).Should(HaveSuffix(",-1,expired"))
+		})
+
+		It("should refuse an unknown format", func() {
+			Ω(WriteCertificateReport(&bytes.Buffer{}, CertificateReport{}, "xml")).Should(Equal(ErrReportFormat("xml")))
+		})
+	})
+})
@@ -2,12 +2,15 @@ package cfops_test
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/pivotalservices/cfops"
 
 	"testing"
+	"time"
 )
 
 func TestCfops(t *testing.T) {
@@ -15,6 +18,30 @@ func TestCfops(t *testing.T) {
 	RunSpecs(t, "Cfops")
 }
 
+// tempDests are the destinations tempDest made for the spec
+var tempDests []string
+
+// tempDest is a destination of its own for the runs of a spec, removed once
+// the spec is over
+func tempDest() (dir string) {
+	dir, _ = ioutil.TempDir("", "dest")
+	tempDests = append(tempDests, dir)
+	return
+}
+
+// runs given no destination write into the package directory, where they
+// would otherwise be taken for artifacts of the next run
+var _ = AfterEach(func() {
+	for _, name := range []string{ManifestName, BackupCheckpointFileName, CheckpointFileName, SummaryName, RestoreSummaryName} {
+		os.Remove(name)
+	}
+
+	for _, dir := range tempDests {
+		os.RemoveAll(dir)
+	}
+	tempDests = nil
+})
+
 func testPipelineExecutionError(action string) {
 	var errMock = errors.New("random execution mock error")
 	Context("when an error is returned from the builtin pipeline", func() {
@@ -26,12 +53,15 @@ func testPipelineExecutionError(action string) {
 			BuiltinPipelineExecution[action] = m.action
 			fs = &mockFlagSet{
 				tileListFlag: "",
+				host:         "opsman.example.com",
+				dest:         tempDest(),
 			}
 		})
 
-		It("should return the error", func() {
+		It("should return the error, placed at the host it happened on", func() {
 			Ω(RunPipeline(fs, action)).ShouldNot(BeNil())
-			Ω(RunPipeline(fs, action)).Should(Equal(errMock))
+			Ω(RunPipeline(fs, action)).Should(Equal(&RunError{Tile: AllTiles, Host: "opsman.example.com", Err: errMock}))
+			Ω(RunPipeline(fs, action)).Should(MatchError("ALL on opsman.example.com: random execution mock error"))
 		})
 	})
 
@@ -56,6 +86,7 @@ func testWithValidTilelist(action string) {
 
 			fs = &mockFlagSet{
 				tileListFlag: "badflag",
+				dest:         tempDest(),
 			}
 		})
 
@@ -89,6 +120,7 @@ func testWithInvalidTileList(action string) {
 
 			fs = &mockFlagSet{
 				tileListFlag: "testflag",
+				dest:         tempDest(),
 			}
 		})
 
@@ -112,6 +144,7 @@ func testWithoutTilelist(action string) {
 
 			fs = &mockFlagSet{
 				tileListFlag: "",
+				dest:         tempDest(),
 			}
 		})
 
@@ -122,10 +155,61 @@ func testWithoutTilelist(action string) {
 }
 
 type mockFlagSet struct {
+	host         string
+	opsUser      string
 	tileListFlag string
+	dest         string
+	catalog      string
+	cleanup      bool
+	overwrite    bool
+	skipUnreach  bool
+	restart      bool
+	lockDir      string
+	checkpoints  string
+	breakLock    bool
+	components   string
+	window       time.Duration
+	heartbeat    time.Duration
+	tracing      TracingConfig
+	retry        RetryConfig
+	idempotency  string
+	runID        string
+	metricsFile  string
+	pushGateway  string
+	statsd       string
+	cloudWatch   CloudWatchConfig
+	smtp         SMTPConfig
+	pagerDuty    PagerDutyConfig
+	archive      bool
+	registry     RegistryConfig
+	restic       ResticConfig
+	bbrArtifact  string
+	remap        string
+	blobstore    string
+	blobMirror   string
+	binlogs      BinlogConfig
+	limits       RestoreLimits
+	bandwidth    BandwidthLimits
+	extract      int
+	segments     int
+	metadata     MetadataCacheConfig
+	quiesce      QuiesceConfig
+	smokeTests   SmokeTestConfig
+	version      string
+	shipLogs     bool
+	diagnostics  bool
+	asyncExport  AsyncExportConfig
+	reauth       CredentialSource
+	snapshots    SnapshotConfig
+	pks          PKSConfig
+	manifests    DeploymentManifestsConfig
+	applyChanges ApplyChangesConfig
+	healthCheck  HealthCheckConfig
+	auditLog     string
 }
 
 func (s *mockFlagSet) Host() (r string) {
+	r = s.host
 	return
 }
 
@@ -138,6 +222,7 @@ func (s *mockFlagSet) AdminPass() (r string) {
 }
 
 func (s *mockFlagSet) OpsManagerUser() (r string) {
+	r = s.opsUser
 	return
 }
 
@@ -146,6 +231,7 @@ func (s *mockFlagSet) OpsManagerPass() (r string) {
 }
 
 func (s *mockFlagSet) Dest() (r string) {
+	r = s.dest
 	return
 }
 
@@ -154,6 +240,243 @@ func (s *mockFlagSet) Tilelist() (r string) {
 	return
 }
 
+func (s *mockFlagSet) Catalog() (r string) {
+	r = s.catalog
+	return
+}
+
+func (s *mockFlagSet) CleanupOnFailure() (r bool) {
+	r = s.cleanup
+	return
+}
+
+func (s *mockFlagSet) Overwrite() (r bool) {
+	r = s.overwrite
+	return
+}
+
+func (s *mockFlagSet) SkipUnreachable() (r bool) {
+	r = s.skipUnreach
+	return
+}
+
+func (s *mockFlagSet) RestartRestore() (r bool) {
+	r = s.restart
+	return
+}
+
+func (s *mockFlagSet) LockDir() (r string) {
+	r = s.lockDir
+	return
+}
+
+func (s *mockFlagSet) CheckpointDir() (r string) {
+	r = s.checkpoints
+	return
+}
+
+func (s *mockFlagSet) BreakLock() (r bool) {
+	r = s.breakLock
+	return
+}
+
+func (s *mockFlagSet) Components() (r string) {
+	r = s.components
+	return
+}
+
+func (s *mockFlagSet) ConsistencyWindow() (r time.Duration) {
+	r = s.window
+	return
+}
+
+func (s *mockFlagSet) RestoreLimits() (r RestoreLimits) {
+	r = s.limits
+	return
+}
+
+func (s *mockFlagSet) Bandwidth() (r BandwidthLimits) {
+	r = s.bandwidth
+	return
+}
+
+func (s *mockFlagSet) ExtractConcurrency() (r int) {
+	r = s.extract
+	return
+}
+
+func (s *mockFlagSet) TransferSegments() (r int) {
+	r = s.segments
+	return
+}
+
+func (s *mockFlagSet) MetadataCache() (r MetadataCacheConfig) {
+	r = s.metadata
+	return
+}
+
+func (s *mockFlagSet) SmokeTests() (r SmokeTestConfig) {
+	r = s.smokeTests
+	return
+}
+
+func (s *mockFlagSet) Quiesce() (r QuiesceConfig) {
+	r = s.quiesce
+	return
+}
+
+func (s *mockFlagSet) Heartbeat() (r time.Duration) {
+	r = s.heartbeat
+	return
+}
+
+func (s *mockFlagSet) Tracing() (r TracingConfig) {
+	r = s.tracing
+	return
+}
+
+func (s *mockFlagSet) Retry() (r RetryConfig) {
+	r = s.retry
+	return
+}
+
+func (s *mockFlagSet) IdempotencyKey() (r string) {
+	r = s.idempotency
+	return
+}
+
+func (s *mockFlagSet) RunID() (r string) {
+	r = s.runID
+	return
+}
+
+func (s *mockFlagSet) MetricsFile() (r string) {
+	r = s.metricsFile
+	return
+}
+
+func (s *mockFlagSet) PushGateway() (r string) {
+	r = s.pushGateway
+	return
+}
+
+func (s *mockFlagSet) StatsdAddress() (r string) {
+	r = s.statsd
+	return
+}
+
+func (s *mockFlagSet) SMTP() (r SMTPConfig) {
+	r = s.smtp
+	return
+}
+
+func (s *mockFlagSet) BBRArtifact() (r string) {
+	r = s.bbrArtifact
+	return
+}
+
+func (s *mockFlagSet) Remap() (r string) {
+	r = s.remap
+	return
+}
+
+func (s *mockFlagSet) BlobstoreCategories() (r string) {
+	r = s.blobstore
+	return
+}
+
+func (s *mockFlagSet) BlobstoreMirror() (r string) {
+	r = s.blobMirror
+	return
+}
+
+func (s *mockFlagSet) TargetVersion() (r string) {
+	r = s.version
+	return
+}
+
+func (s *mockFlagSet) Binlogs() (r BinlogConfig) {
+	r = s.binlogs
+	return
+}
+
+func (s *mockFlagSet) Restic() (r ResticConfig) {
+	r = s.restic
+	return
+}
+
+func (s *mockFlagSet) CloudWatch() (r CloudWatchConfig) {
+	r = s.cloudWatch
+	return
+}
+
+func (s *mockFlagSet) Registry() (r RegistryConfig) {
+	r = s.registry
+	return
+}
+
+func (s *mockFlagSet) HealthCheck() (r HealthCheckConfig) {
+	r = s.healthCheck
+	return
+}
+
+func (s *mockFlagSet) ApplyChanges() (r ApplyChangesConfig) {
+	r = s.applyChanges
+	return
+}
+
+func (s *mockFlagSet) ShipLogs() (r bool) {
+	r = s.shipLogs
+	return
+}
+
+func (s *mockFlagSet) Diagnostics() (r bool) {
+	r = s.diagnostics
+	return
+}
+
+func (s *mockFlagSet) AsyncExport() (r AsyncExportConfig) {
+	r = s.asyncExport
+	return
+}
+
+func (s *mockFlagSet) Reauthenticate() (user, pass string, err error) {
+	if s.reauth == nil {
+		return "", "", errors.New("no credential source")
+	}
+	return s.reauth()
+}
+
+func (s *mockFlagSet) Snapshots() (r SnapshotConfig) {
+	r = s.snapshots
+	return
+}
+
+func (s *mockFlagSet) PKS() (r PKSConfig) {
+	r = s.pks
+	return
+}
+
+func (s *mockFlagSet) DeploymentManifests() (r DeploymentManifestsConfig) {
+	r = s.manifests
+	return
+}
+
+func (s *mockFlagSet) Archive() (r bool) {
+	r = s.archive
+	return
+}
+
+func (s *mockFlagSet) PagerDuty() (r PagerDutyConfig) {
+	r = s.pagerDuty
+	return
+}
+
+func (s *mockFlagSet) AuditLog() (r string) {
+	r = s.auditLog
+	return
+}
+
 type mockBuiltinPipeline struct {
 	ErrReturned error
 }
@@ -169,10 +492,10 @@ type mockTile struct {
 
 func (s *mockTile) Restore() (err error) {
 	s.RunCount++
-	return
+	return s.ErrReturned
 }
 
 func (s *mockTile) Backup() (err error) {
 	s.RunCount++
-	return
+	return s.ErrReturned
 }
@@ -0,0 +1,234 @@
+package cfops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// CheckpointFileName is written into the destination while a restore is in progress
+	CheckpointFileName = "restore.checkpoint.json"
+	// BackupCheckpointFileName is written into the destination while a backup
+	// is in progress
+	BackupCheckpointFileName = "backup.checkpoint.json"
+	stepSeparator            = "/"
+)
+
+// RestoreCheckpoint persists the restore steps that completed against a
+// destination, so re-invoking an interrupted restore continues where it
+// stopped instead of re-applying finished steps. A backup keeps one too, of
+// the tiles and stores it already has artifacts of. Along with the steps it
+// records what the run was doing when last seen, for cfops status and resume
+type RestoreCheckpoint struct {
+	Action string `json:"action,omitempty"`
+	// Foundation is the ops manager host the run talks to
+	Foundation string `json:"foundation,omitempty"`
+	// RunID is the id of the run in the catalog, which a resumed backup
+	// carries on
+	RunID       string               `json:"run_id,omitempty"`
+	Destination string               `json:"destination,omitempty"`
+	Tilelist    string               `json:"tilelist,omitempty"`
+	Components  string               `json:"components,omitempty"`
+	Steps       map[string]time.Time `json:"steps"`
+	// Phase is the task the run last started, e.g. ER/ccdb/dump
+	Phase string `json:"phase,omitempty"`
+	// Transfers are the bytes each transfer that has not completed had
+	// streamed when last seen
+	Transfers map[string]int64 `json:"transfers,omitempty"`
+	Updated   time.Time        `json:"updated"`
+	// Progress are the steps of the restore in progress, in order
+	Progress []RestoreStep `json:"progress,omitempty"`
+	path     string
+	// local is the copy of the checkpoint kept on the host running cfops
+	local string
+	mutex sync.Mutex
+}
+
+// OpenCheckpoint loads the restore checkpoint from the destination, or starts
+// a new one when there is none
+func OpenCheckpoint(destination string) (checkpoint *RestoreCheckpoint, err error) {
+	return openCheckpoint(path.Join(destination, CheckpointFileName))
+}
+
+// OpenBackupCheckpoint loads the checkpoint an interrupted backup of the
+// foundation left in the destination, or starts a new one when there is none
+// or it belongs to another foundation
+func OpenBackupCheckpoint(destination, foundation string) (checkpoint *RestoreCheckpoint, err error) {
+	if checkpoint, err = openCheckpoint(path.Join(destination, BackupCheckpointFileName)); err == nil && checkpoint.Foundation != foundation {
+		checkpoint.Steps, checkpoint.Progress, checkpoint.Transfers = make(map[string]time.Time), nil, nil
+		checkpoint.RunID = ""
+	}
+	checkpoint.Foundation = foundation
+	return
+}
+
+// PartialBackup is the checkpoint of an interrupted backup of the foundation
+// into the destination, nil when no backup of it left artifacts there
+func PartialBackup(destination, foundation string) (checkpoint *RestoreCheckpoint, err error) {
+	if checkpoint, err = OpenBackupCheckpoint(destination, foundation); err != nil || len(checkpoint.Steps) == 0 {
+		return nil, err
+	}
+	return
+}
+
+func openCheckpoint(checkpointPath string) (checkpoint *RestoreCheckpoint, err error) {
+	var contents []byte
+	checkpoint = &RestoreCheckpoint{
+		Steps: make(map[string]time.Time),
+		path:  checkpointPath,
+	}
+
+	if contents, err = ioutil.ReadFile(checkpoint.path); err == nil {
+		err = json.Unmarshal(contents, checkpoint)
+
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// Completed reports whether the step already finished in an earlier run
+func (s *RestoreCheckpoint) Completed(step string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.Steps[step]
+	return ok
+}
+
+// CompletedSteps are the steps that finished in earlier runs, in order
+func (s *RestoreCheckpoint) CompletedSteps() (steps []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for step := range s.Steps {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+	return
+}
+
+// MarkCompleted records the step and persists the checkpoint immediately
+func (s *RestoreCheckpoint) MarkCompleted(step string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Steps[step] = time.Now().UTC()
+	return s.save()
+}
+
+// Remove discards the checkpoint once every step has completed, or when an
+// operator asks for a restore to start over
+func (s *RestoreCheckpoint) Remove() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Steps = make(map[string]time.Time)
+	s.Progress, s.Transfers, s.Phase = nil, nil, ""
+
+	for _, checkpointPath := range []string{s.path, s.local} {
+		if checkpointPath == "" {
+			continue
+		}
+
+		if rmErr := os.Remove(checkpointPath); rmErr != nil && !os.IsNotExist(rmErr) {
+			err = rmErr
+		}
+	}
+	return
+}
+
+// Scope returns a view of the checkpoint whose steps are nested under the tile
+func (s *RestoreCheckpoint) Scope(tileName string) cfbackup.Checkpoint {
+	return scopedCheckpoint{checkpoint: s, prefix: tileName + stepSeparator}
+}
+
+type scopedCheckpoint struct {
+	checkpoint *RestoreCheckpoint
+	prefix     string
+}
+
+func (s scopedCheckpoint) Completed(step string) bool {
+	return s.checkpoint.Completed(s.prefix + step)
+}
+
+func (s scopedCheckpoint) MarkCompleted(step string) error {
+	return s.checkpoint.MarkCompleted(s.prefix + step)
+}
+
+// LocalCheckpointPath is where the host running cfops keeps its copy of the
+// checkpoint of the action against the foundation
+func LocalCheckpointPath(dir, foundation, action string) string {
+	return path.Join(dir, lockNameSanitizer.ReplaceAllString(foundation, "_")+"."+action+".checkpoint.json")
+}
+
+// keepLocally keeps a copy of the checkpoint at the local path too, taking
+// the progress recorded there when it is more recent than that of the
+// destination, e.g. when the destination could not be written to
+func (s *RestoreCheckpoint) keepLocally(localPath string) (err error) {
+	var local *RestoreCheckpoint
+
+	if local, err = openCheckpoint(localPath); err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.local = localPath
+
+	if local.Updated.After(s.Updated) && local.Destination == s.Destination {
+		s.Steps, s.Progress, s.Phase, s.Transfers = local.Steps, local.Progress, local.Phase, local.Transfers
+	}
+	return
+}
+
+// follow records the task the run is in and the bytes its transfers have
+// streamed, saving the checkpoint as they start and at every heartbeat, until
+// it is stopped
+func (s *RestoreCheckpoint) follow() (stop func()) {
+	subscription, cancel := SubscribeEvents()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for event := range subscription {
+			s.record(event)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (s *RestoreCheckpoint) record(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch event.Type {
+	case EventTaskStarted:
+		s.Phase = event.Task
+
+	case EventProgress:
+		if s.Transfers == nil {
+			s.Transfers = make(map[string]int64)
+		}
+		s.Phase, s.Transfers[event.Task] = event.Task, event.Bytes
+
+	case EventTaskFinished:
+		delete(s.Transfers, event.Task)
+
+	default:
+		return
+	}
+
+	if err := s.save(); err != nil {
+		lo.G.Error("unable to record the progress of the run: %s", err)
+	}
+}
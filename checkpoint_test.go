@@ -0,0 +1,185 @@
+package cfops_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RestoreCheckpoint", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "checkpoint")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should persist completed steps across runs", func() {
+		checkpoint, _ := OpenCheckpoint(dir)
+		Ω(checkpoint.MarkCompleted(OpsMgr)).Should(BeNil())
+		Ω(checkpoint.Scope(ER).MarkCompleted("ccdb")).Should(BeNil())
+
+		reopened, err := OpenCheckpoint(dir)
+		Ω(err).Should(BeNil())
+		Ω(reopened.Completed(OpsMgr)).Should(BeTrue())
+		Ω(reopened.Scope(ER).Completed("ccdb")).Should(BeTrue())
+		Ω(reopened.Scope(ER).Completed("uaadb")).Should(BeFalse())
+	})
+
+	It("should forget every step once removed", func() {
+		checkpoint, _ := OpenCheckpoint(dir)
+		checkpoint.MarkCompleted(OpsMgr)
+		Ω(checkpoint.Remove()).Should(BeNil())
+		Ω(checkpoint.Completed(OpsMgr)).Should(BeFalse())
+		_, err := os.Stat(path.Join(dir, CheckpointFileName))
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	Describe("the progress of a restore", func() {
+		It("should number the steps and persist how each went", func() {
+			checkpoint, _ := OpenCheckpoint(dir)
+			checkpoint.MarkCompleted(OpsMgr)
+			Ω(checkpoint.PlanSteps([]RestoreStep{
+				{Name: OpsMgr, Description: "restoring opsmanager"},
+				{Name: ER + "/uaadb", Description: "importing uaadb"},
+				{Name: ER + "/ccdb", Description: "importing ccdb"},
+			})).Should(Succeed())
+			checkpoint.StartStep(ER + "/uaadb")(nil)
+			checkpoint.StartStep(ER + "/ccdb")(errors.New("connection refused"))
+
+			reopened, _ := OpenCheckpoint(dir)
+			Ω(reopened.Progress).Should(HaveLen(3))
+			Ω(reopened.Progress[0].Status).Should(Equal(StepCompleted))
+			Ω(reopened.Progress[1].Number).Should(Equal(2))
+			Ω(reopened.Progress[1].Status).Should(Equal(StepCompleted))
+			Ω(reopened.Progress[1].Started).ShouldNot(BeNil())
+			Ω(reopened.Progress[2].Status).Should(Equal(StepFailed))
+			Ω(reopened.Progress[2].Error).Should(Equal("connection refused"))
+		})
+
+		It("should keep the progress of the steps an interrupted restore completed", func() {
+			checkpoint, _ := OpenCheckpoint(dir)
+			steps := []RestoreStep{{Name: OpsMgr}, {Name: ER + "/ccdb"}}
+			checkpoint.PlanSteps(steps)
+			finish := checkpoint.StartStep(OpsMgr)
+			checkpoint.MarkCompleted(OpsMgr)
+			finish(nil)
+
+			reopened, _ := OpenCheckpoint(dir)
+			reopened.PlanSteps(steps)
+			Ω(reopened.Progress[0].Status).Should(Equal(StepCompleted))
+			Ω(reopened.Progress[0].Started).ShouldNot(BeNil())
+			Ω(reopened.Progress[1].Status).Should(Equal(StepPending))
+		})
+
+		It("should record the steps of a restore that failed", func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{ErrReturned: errors.New("upload failed")}, nil
+				},
+			}
+			RunPipeline(&mockFlagSet{tileListFlag: "opsmanager, er", components: "uaadb, ccdb", dest: dir}, Restore)
+
+			reopened, _ := OpenCheckpoint(dir)
+			Ω(reopened.Progress).Should(HaveLen(3))
+			Ω(reopened.Progress[0].Status).Should(Equal(StepFailed))
+			Ω(reopened.Progress[1].Name).Should(Equal(ER + "/uaadb"))
+			Ω(reopened.Progress[1].Description).Should(Equal("importing uaadb"))
+			Ω(reopened.Progress[2].Status).Should(Equal(StepPending))
+		})
+	})
+
+	Describe("resuming an interrupted restore", func() {
+		var (
+			opsmgr *mockTile
+			fs     *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			opsmgr = &mockTile{}
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return opsmgr, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir}
+			checkpoint, _ := OpenCheckpoint(dir)
+			checkpoint.MarkCompleted(OpsMgr)
+		})
+
+		It("should skip the steps that already completed", func() {
+			Ω(RunPipeline(fs, Restore)).Should(BeNil())
+			Ω(opsmgr.RunCount).Should(Equal(0))
+		})
+
+		It("should re-apply every step when asked to restart", func() {
+			fs.restart = true
+			Ω(RunPipeline(fs, Restore)).Should(BeNil())
+			Ω(opsmgr.RunCount).Should(Equal(1))
+		})
+
+		It("should discard the checkpoint once the restore completes", func() {
+			RunPipeline(fs, Restore)
+			_, err := os.Stat(path.Join(dir, CheckpointFileName))
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+		})
+	})
+
+	Describe("resuming an interrupted backup", func() {
+		var (
+			opsmgr *mockTile
+			fs     *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			opsmgr = &mockTile{}
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return opsmgr, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir, host: "opsman.prod"}
+			checkpoint, _ := OpenBackupCheckpoint(dir, "opsman.prod")
+			checkpoint.MarkCompleted(OpsMgr)
+		})
+
+		It("should find the partial backup of the foundation", func() {
+			partial, err := PartialBackup(dir, "opsman.prod")
+			Ω(err).Should(BeNil())
+			Ω(partial.CompletedSteps()).Should(Equal([]string{OpsMgr}))
+		})
+
+		It("should not take the partial backup of another foundation for one of this foundation", func() {
+			Ω(PartialBackup(dir, "opsman.staging")).Should(BeNil())
+			fs.host = "opsman.staging"
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			Ω(opsmgr.RunCount).Should(Equal(1))
+		})
+
+		It("should skip the tiles it already has artifacts of", func() {
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			Ω(opsmgr.RunCount).Should(Equal(0))
+		})
+
+		It("should back up every tile again when asked to restart", func() {
+			fs.restart = true
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			Ω(opsmgr.RunCount).Should(Equal(1))
+		})
+
+		It("should discard the checkpoint once the backup completes", func() {
+			RunPipeline(fs, Backup)
+			_, err := os.Stat(path.Join(dir, BackupCheckpointFileName))
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+		})
+	})
+})
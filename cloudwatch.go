@@ -0,0 +1,328 @@
+package cfops
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ErrCloudWatchFormat           = "cloudwatch %s responded with %s: %s"
+	ErrCloudWatchDimensionsFormat = "invalid cloudwatch dimensions %q, expected e.g. Environment=prod,Team=platform"
+	ErrAWSCredentialsMsg          = "no aws credentials in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, nor from the instance profile"
+	cloudWatchService             = "monitoring"
+	cloudWatchAPIVersion          = "2010-08-01"
+	// cloudWatchBatch keeps each PutMetricData request within the limit of
+	// every region
+	cloudWatchBatch = 20
+	awsTimeFormat   = "20060102T150405Z"
+	awsDateFormat   = "20060102"
+)
+
+var (
+	ErrAWSCredentials = errors.New(ErrAWSCredentialsMsg)
+	// ec2MetadataEndpoint is where the credentials of the instance profile are
+	// read from when none are in the environment
+	ec2MetadataEndpoint = "http://169.254.169.254"
+)
+
+type (
+	// CloudWatchConfig describes where in cloudwatch the metrics of a run are
+	// published. Credentials come from the environment or the instance profile
+	CloudWatchConfig struct {
+		Namespace string
+		// Region defaults to AWS_REGION
+		Region string
+		// Dimensions are added to every metric, after the foundation
+		Dimensions [][2]string
+		// Endpoint overrides https://monitoring.<region>.amazonaws.com, e.g.
+		// for a vpc endpoint
+		Endpoint string
+	}
+
+	awsCredentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+)
+
+func ErrCloudWatch(action, status, body string) error {
+	return fmt.Errorf(ErrCloudWatchFormat, action, status, body)
+}
+
+func ErrCloudWatchDimensions(dimensions string) error {
+	return fmt.Errorf(ErrCloudWatchDimensionsFormat, dimensions)
+}
+
+// Enabled tells whether metrics should be published to cloudwatch at all
+func (s CloudWatchConfig) Enabled() bool {
+	return s.Namespace != ""
+}
+
+// ParseCloudWatchDimensions reads dimensions like Environment=prod,Team=platform
+func ParseCloudWatchDimensions(dimensions string) (parsed [][2]string, err error) {
+	for _, pair := range strings.Split(dimensions, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrCloudWatchDimensions(dimensions)
+		}
+		parsed = append(parsed, [2]string{parts[0], parts[1]})
+	}
+	return
+}
+
+// PutCloudWatchMetrics publishes the metric set of a finished run to the
+// namespace, dimensioned by the foundation, the action and the tile. The
+// timestamps of the set are left out, cloudwatch records when each value was
+// put and alarms on missing data instead
+func PutCloudWatchMetrics(config CloudWatchConfig, foundation string, entry *CatalogEntry, lastSuccess time.Time) (err error) {
+	var (
+		credentials awsCredentials
+		data        []url.Values
+	)
+
+	if credentials, err = loadAWSCredentials(); err != nil {
+		return
+	}
+	now := time.Now().UTC()
+
+	for _, m := range runMetrics(entry, lastSuccess) {
+		if strings.HasSuffix(m.name, "_timestamp_seconds") {
+			continue
+		}
+		datum := url.Values{
+			"MetricName": {cloudWatchName(m.name)},
+			"Value":      {fmt.Sprintf("%g", m.value)},
+			"Unit":       {cloudWatchUnit(m.name)},
+			"Timestamp":  {now.Format(time.RFC3339)},
+		}
+		dimensions := append([][2]string{{"Foundation", foundation}}, config.Dimensions...)
+
+		for _, label := range m.labels {
+			dimensions = append(dimensions, [2]string{strings.Title(label[0]), label[1]})
+		}
+
+		for i, dimension := range dimensions {
+			datum.Set(fmt.Sprintf("Dimensions.member.%d.Name", i+1), dimension[0])
+			datum.Set(fmt.Sprintf("Dimensions.member.%d.Value", i+1), dimension[1])
+		}
+		data = append(data, datum)
+	}
+
+	for start := 0; start < len(data) && err == nil; start += cloudWatchBatch {
+		end := start + cloudWatchBatch
+
+		if end > len(data) {
+			end = len(data)
+		}
+		err = putMetricData(config, credentials, data[start:end], now)
+	}
+	return
+}
+
+// cloudWatchName turns cfops_run_duration_seconds into RunDurationSeconds
+func cloudWatchName(name string) string {
+	parts := strings.Split(strings.TrimPrefix(name, statsdPrefix+"_"), "_")
+
+	for i, part := range parts {
+		parts[i] = strings.Title(part)
+	}
+	return strings.Join(parts, "")
+}
+
+func cloudWatchUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_bytes_per_second"):
+		return "Bytes/Second"
+	case strings.HasSuffix(name, "_seconds"):
+		return "Seconds"
+	case strings.HasSuffix(name, "_bytes"):
+		return "Bytes"
+	}
+	return "None"
+}
+
+func putMetricData(config CloudWatchConfig, credentials awsCredentials, data []url.Values, now time.Time) (err error) {
+	var (
+		request  *http.Request
+		response *http.Response
+		body     []byte
+	)
+	form := url.Values{"Action": {"PutMetricData"}, "Version": {cloudWatchAPIVersion}, "Namespace": {config.Namespace}}
+	region := config.region()
+
+	for i, datum := range data {
+		for key, values := range datum {
+			form.Set(fmt.Sprintf("MetricData.member.%d.%s", i+1, key), values[0])
+		}
+	}
+	endpoint := config.Endpoint
+
+	if endpoint == "" {
+		endpoint = "https://" + cloudWatchService + "." + region + ".amazonaws.com"
+	}
+	payload := form.Encode()
+
+	if request, err = http.NewRequest("POST", strings.TrimRight(endpoint, "/")+"/", strings.NewReader(payload)); err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(request, []byte(payload), credentials, region, cloudWatchService, now)
+
+	if response, err = metricsClient.Do(request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		body, _ = ioutil.ReadAll(response.Body)
+		err = ErrCloudWatch("PutMetricData", response.Status, strings.TrimSpace(string(body)))
+	}
+	return
+}
+
+func (s CloudWatchConfig) region() string {
+	if s.Region != "" {
+		return s.Region
+	}
+	return os.Getenv("AWS_REGION")
+}
+
+// signAWSRequest signs a request with aws signature version 4, over its
+// content type, host and x-amz headers
+func signAWSRequest(request *http.Request, payload []byte, credentials awsCredentials, region, service string, now time.Time) {
+	amzDate, date := now.Format(awsTimeFormat), now.Format(awsDateFormat)
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	if credentials.Token != "" {
+		request.Header.Set("X-Amz-Security-Token", credentials.Token)
+	}
+	values := map[string]string{"host": request.URL.Host}
+
+	if contentType := request.Header.Get("Content-Type"); contentType != "" {
+		values["content-type"] = contentType
+	}
+
+	for name := range request.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			values[name] = strings.TrimSpace(request.Header.Get(name))
+		}
+	}
+	var headers []string
+
+	for name := range values {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	canonicalHeaders := ""
+
+	for _, name := range headers {
+		canonicalHeaders += name + ":" + values[name] + "\n"
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalPath := request.URL.EscapedPath()
+
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{request.Method, canonicalPath, request.URL.RawQuery, canonicalHeaders, signedHeaders, sha256Hex(payload)}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+func sha256Hex(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, contents string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(contents))
+	return mac.Sum(nil)
+}
+
+// loadAWSCredentials reads the credentials from the environment, or else
+// those of the instance profile through the instance metadata service
+func loadAWSCredentials() (credentials awsCredentials, err error) {
+	credentials = awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:           os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if credentials.AccessKeyID != "" && credentials.SecretAccessKey != "" {
+		return
+	}
+
+	if credentials, err = instanceProfileCredentials(); err != nil || credentials.AccessKeyID == "" {
+		err = ErrAWSCredentials
+	}
+	return
+}
+
+func instanceProfileCredentials() (credentials awsCredentials, err error) {
+	var (
+		token []byte
+		role  []byte
+		body  []byte
+	)
+	client := &http.Client{Timeout: 2 * time.Second}
+	metadata := func(method, resource string, header [2]string) (contents []byte, err error) {
+		var (
+			request  *http.Request
+			response *http.Response
+		)
+
+		if request, err = http.NewRequest(method, ec2MetadataEndpoint+resource, nil); err != nil {
+			return
+		}
+		request.Header.Set(header[0], header[1])
+
+		if response, err = client.Do(request); err != nil {
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, ErrCloudWatch(resource, response.Status, "")
+		}
+		return ioutil.ReadAll(response.Body)
+	}
+
+	if token, err = metadata("PUT", "/latest/api/token", [2]string{"X-aws-ec2-metadata-token-ttl-seconds", "60"}); err != nil {
+		return
+	}
+	auth := [2]string{"X-aws-ec2-metadata-token", string(token)}
+	credentialsPath := "/latest/meta-data/iam/security-credentials/"
+
+	if role, err = metadata("GET", credentialsPath, auth); err != nil {
+		return
+	}
+
+	if body, err = metadata("GET", credentialsPath+strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]), auth); err == nil {
+		err = json.Unmarshal(body, &credentials)
+	}
+	return
+}
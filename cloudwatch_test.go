@@ -0,0 +1,138 @@
+package cfops_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CloudWatch", func() {
+	var (
+		server   *httptest.Server
+		requests []url.Values
+		headers  http.Header
+		status   int
+		config   CloudWatchConfig
+		entry    *CatalogEntry
+	)
+
+	BeforeEach(func() {
+		requests, status = nil, http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			requests = append(requests, r.PostForm)
+			headers = r.Header
+			w.WriteHeader(status)
+		}))
+		os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		config = CloudWatchConfig{Namespace: "CFOps", Region: "us-east-1", Endpoint: server.URL, Dimensions: [][2]string{{"Environment", "prod"}}}
+		started := time.Unix(1000, 0)
+		entry = &CatalogEntry{
+			Action:   Backup,
+			Status:   SetComplete,
+			Started:  started,
+			Finished: started.Add(10 * time.Second),
+			Components: []ComponentResult{
+				{Name: OpsMgr, Status: ComponentSucceeded, Seconds: 4, Bytes: 100},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	})
+
+	Describe("PutCloudWatchMetrics", func() {
+		It("should put the success, duration and bytes of the run", func() {
+			Ω(PutCloudWatchMetrics(config, "opsman.example.com", entry, time.Unix(500, 0))).Should(BeNil())
+			Ω(requests).Should(HaveLen(1))
+			form := requests[0]
+			Ω(form.Get("Action")).Should(Equal("PutMetricData"))
+			Ω(form.Get("Namespace")).Should(Equal("CFOps"))
+			Ω(form.Get("MetricData.member.1.MetricName")).Should(Equal("RunDurationSeconds"))
+			Ω(form.Get("MetricData.member.1.Value")).Should(Equal("10"))
+			Ω(form.Get("MetricData.member.1.Unit")).Should(Equal("Seconds"))
+			Ω(form.Get("MetricData.member.2.MetricName")).Should(Equal("RunSuccess"))
+			Ω(form.Get("MetricData.member.2.Value")).Should(Equal("1"))
+			Ω(form.Get("MetricData.member.3.MetricName")).Should(Equal("RunBytes"))
+			Ω(form.Get("MetricData.member.3.Unit")).Should(Equal("Bytes"))
+		})
+
+		It("should dimension every metric by the foundation, the configured dimensions and the action", func() {
+			PutCloudWatchMetrics(config, "opsman.example.com", entry, time.Time{})
+			form := requests[0]
+			Ω(form.Get("MetricData.member.1.Dimensions.member.1.Name")).Should(Equal("Foundation"))
+			Ω(form.Get("MetricData.member.1.Dimensions.member.1.Value")).Should(Equal("opsman.example.com"))
+			Ω(form.Get("MetricData.member.1.Dimensions.member.2.Value")).Should(Equal("prod"))
+			Ω(form.Get("MetricData.member.1.Dimensions.member.3.Name")).Should(Equal("Action"))
+			Ω(form.Get("MetricData.member.1.Dimensions.member.3.Value")).Should(Equal(Backup))
+		})
+
+		It("should leave out the timestamps of the run", func() {
+			PutCloudWatchMetrics(config, "opsman.example.com", entry, time.Unix(500, 0))
+
+			for key, values := range requests[0] {
+				if strings.HasSuffix(key, ".MetricName") {
+					Ω(values[0]).ShouldNot(HaveSuffix("Timestamp"))
+				}
+			}
+		})
+
+		It("should sign the request with the credentials of the environment", func() {
+			PutCloudWatchMetrics(config, "opsman.example.com", entry, time.Time{})
+			Ω(headers.Get("Authorization")).Should(MatchRegexp(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/\d{8}/us-east-1/monitoring/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=[0-9a-f]{64}$`))
+			Ω(headers.Get("X-Amz-Date")).ShouldNot(BeEmpty())
+		})
+
+		It("should sign the session token of temporary credentials", func() {
+			os.Setenv("AWS_SESSION_TOKEN", "token")
+			defer os.Unsetenv("AWS_SESSION_TOKEN")
+			PutCloudWatchMetrics(config, "opsman.example.com", entry, time.Time{})
+			Ω(headers.Get("X-Amz-Security-Token")).Should(Equal("token"))
+			Ω(headers.Get("Authorization")).Should(ContainSubstring("SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,"))
+		})
+
+		It("should fail when cloudwatch refuses the metrics", func() {
+			status = http.StatusForbidden
+			err := PutCloudWatchMetrics(config, "opsman.example.com", entry, time.Time{})
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring("403"))
+		})
+	})
+
+	Describe("ParseCloudWatchDimensions", func() {
+		It("should read name=value pairs", func() {
+			dimensions, err := ParseCloudWatchDimensions("Environment=prod, Team=platform")
+			Ω(err).Should(BeNil())
+			Ω(dimensions).Should(Equal([][2]string{{"Environment", "prod"}, {"Team", "platform"}}))
+		})
+
+		It("should reject a dimension without a value", func() {
+			_, err := ParseCloudWatchDimensions("Environment")
+			Ω(err).Should(Equal(ErrCloudWatchDimensions("Environment")))
+		})
+	})
+
+	Describe("running a pipeline", func() {
+		It("should put the metrics of the run", func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+			Ω(RunPipeline(&mockFlagSet{tileListFlag: "opsmanager", dest: tempDest(), cloudWatch: config}, Backup)).Should(BeNil())
+			Ω(requests).Should(HaveLen(1))
+		})
+	})
+})
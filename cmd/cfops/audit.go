@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	audit_full_name string = "audit"
+	audit_usage            = "[--auditlog <path>]"
+	audit_descr            = "Check that no entry of the audit log of backups and restores was edited or removed"
+)
+
+var auditCli = cli.Command{
+	Name:      audit_full_name,
+	Usage:     audit_descr,
+	ArgsUsage: audit_usage,
+	Flags: []cli.Flag{
+		stringFlag(flagList[auditLog]),
+	},
+	Action: func(c *cli.Context) {
+		auditPath := auditLogPath(c, cfopsHome())
+
+		if entries, err := cfops.VerifyAuditLog(auditPath); err != nil {
+			fmt.Println(err)
+			ExitCode = errExitCode
+
+		} else {
+			fmt.Printf("audit log %s is intact with %d entries\n", auditPath, entries)
+		}
+	},
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/pivotalservices/cfops"
@@ -10,41 +15,187 @@ import (
 const (
 	backup_full_name  string = "backup"
 	backup_short_name        = "b"
-	backup_usage             = "backup --opsmanagerhost <host> --adminuser <usr> --adminpass <pass> --opsmanageruser <opsuser> --opsmanagerpass <opspass> -d <dir> --tl 'opsmanager, er'"
-	backup_descr             = "backup a Cloud Foundry deployment, including Ops Manager configuration, databases, and blob store"
+	backup_usage             = "--opsmanagerhost <host> --adminuser <usr> --adminpass <pass> --opsmanageruser <opsuser> --opsmanagerpass <opspass> -d <dir> --tl 'opsmanager, er'"
+	backup_descr             = "Backup a Cloud Foundry deployment, including Ops Manager configuration, databases, and blob store"
+	autoResume               = "auto-resume"
+
+	errPartialBackupFormat = "%s holds a partial backup of %s, which already has %s; give --auto-resume to continue it or --restart to start over"
+	resumePromptFormat     = "%s holds a partial backup of %s, which already has %s. Continue it? [Y/n] "
+)
+
+var errBackupNotStarted = errors.New("backup not started")
+
+var backupFlags = withFlags(append(backupRestoreFlags, stringFlags(registryFlagList)...),
+	cli.BoolFlag{
+		Name:  cleanup,
+		Usage: "remove the partial artifacts of a backup set that did not complete",
+	},
+	cli.BoolFlag{
+		Name:   skipUnreach,
+		Usage:  "leave the stores whose vms can not be reached out of the backup, marking it partial, rather than failing it",
+		EnvVar: "CFOPS_SKIP_UNREACHABLE",
+	},
+	cli.BoolFlag{
+		Name:  overwrite,
+		Usage: "replace the artifacts an interrupted run of another id left in the destination, which a backup otherwise refuses to write over",
+	},
+	cli.DurationFlag{
+		Name:   window,
+		Usage:  "only stop the cloud controller while its database and the blobstore are dumped, warning when that takes longer than this window (e.g. 5m)",
+		EnvVar: "CFOPS_CONSISTENCY_WINDOW",
+	},
+	cli.StringFlag{
+		Name:   blobMirror,
+		Usage:  "a local directory kept in sync with the nfs blobstore, so that each backup copies only the blobs changed since the last one over ssh (the whole blobstore when omitted)",
+		EnvVar: "CFOPS_BLOBSTORE_MIRROR",
+	},
+	cli.BoolFlag{
+		Name:   quiesce,
+		Usage:  "stop the background jobs of the cloud controller through bosh before the backup, and start them again once it is over, even when it fails",
+		EnvVar: "CFOPS_QUIESCE",
+	},
+	cli.StringFlag{
+		Name:   quiesceDeploy,
+		Usage:  "the elastic runtime deployment --quiesce stops jobs of (the only cf- deployment of the director when omitted)",
+		EnvVar: "CFOPS_QUIESCE_DEPLOYMENT",
+	},
+	cli.StringFlag{
+		Name:   quiesceJobs,
+		Usage:  "a csv list of the jobs --quiesce stops, e.g. 'cloud_controller_worker, clock_global, diego_brain' to also pause staging (" + strings.Join(cfops.DefaultQuiesceJobs, ", ") + " when omitted)",
+		EnvVar: "CFOPS_QUIESCE_JOBS",
+	},
+	cli.BoolFlag{
+		Name:   healthCheck,
+		Usage:  "start the backup with bosh cloud-check in report mode and a check of every instance, not backing up a foundation that is not healthy",
+		EnvVar: "CFOPS_HEALTH_CHECK",
+	},
+	cli.StringFlag{
+		Name:   healthDeploys,
+		Usage:  "a csv list of the deployments --healthcheck checks (all deployments of the director when omitted)",
+		EnvVar: "CFOPS_HEALTH_DEPLOYMENTS",
+	},
+	cli.BoolFlag{
+		Name:   ignoreHealth,
+		Usage:  "back up a foundation --healthcheck finds unhealthy anyway, warning about what is unhealthy",
+		EnvVar: "CFOPS_IGNORE_UNHEALTHY",
+	},
+	cli.BoolFlag{
+		Name:   autoResume,
+		Usage:  "continue the partial backup an interrupted backup of the foundation left in the destination without asking",
+		EnvVar: "CFOPS_AUTO_RESUME",
+	},
+	cli.BoolFlag{
+		Name:  restart,
+		Usage: "discard the partial backup an interrupted backup of the foundation left in the destination and start over",
+	},
+	cli.BoolFlag{
+		Name:  versioned,
+		Usage: "back up into a directory of the destination named after the --idempotency-key, or the start of the run",
+	},
+	cli.BoolFlag{
+		Name:  archive,
+		Usage: "pack the artifacts into an indexed tar, " + cfops.ArchiveName + ", single artifacts of which verify and restore can read without the rest",
+	},
+	cli.BoolFlag{
+		Name:  shipLogs,
+		Usage: "write the redacted log of the run, " + cfops.RunLogName + ", next to the artifacts",
+	},
+	cli.BoolFlag{
+		Name:   diagnostics,
+		Usage:  "write the diagnostic report of ops manager, the manifests of its deployed products and its certificate inventory, " + cfops.DiagnosticsName + ", next to the artifacts",
+		EnvVar: "CFOPS_DIAGNOSTICS",
+	},
+	cli.BoolFlag{
+		Name:   manifests,
+		Usage:  "write the manifest of each bosh deployment, its secrets redacted, into " + cfops.DeploymentManifestsDir + "/ next to the artifacts",
+		EnvVar: "CFOPS_DEPLOYMENT_MANIFESTS",
+	},
+	cli.StringFlag{
+		Name:   manifestDeploy,
+		Usage:  "a csv list of the deployments --manifests captures (all deployments of the director when omitted)",
+		EnvVar: "CFOPS_MANIFEST_DEPLOYMENTS",
+	},
+	cli.StringFlag{
+		Name:   manifestKey,
+		Usage:  "also seal each manifest --manifests captures, secrets and all, with this key, next to the redacted one",
+		EnvVar: "CFOPS_MANIFEST_KEY",
+	},
+	cli.StringFlag{
+		Name:   strategy,
+		Usage:  "a csv list of tiles and the strategies they are backed up with, dump or snapshot, e.g. 'er=snapshot' to have the director snapshot the persistent disks of the stores on the iaas rather than dump them",
+		EnvVar: "CFOPS_STRATEGY",
+	},
+	cli.StringFlag{
+		Name:   snapshotDeploy,
+		Usage:  "the elastic runtime deployment a snapshot strategy snapshots the instances of (the only cf- deployment of the director when omitted)",
+		EnvVar: "CFOPS_SNAPSHOT_DEPLOYMENT",
+	},
+	cli.StringFlag{
+		Name:   snapshotComps,
+		Usage:  "a csv list of the stores a snapshot strategy snapshots, e.g. 'nfs_server, mysql', dumping the others (every store when omitted)",
+		EnvVar: "CFOPS_SNAPSHOT_COMPONENTS",
+	},
+	cli.BoolFlag{
+		Name:   asyncExport,
+		Usage:  "export the installation through the asynchronous export api of ops manager, polling until the export is ready and resuming its download where it breaks off",
+		EnvVar: "CFOPS_ASYNC_EXPORT",
+	},
+	cli.DurationFlag{
+		Name:   exportPoll,
+		Value:  10 * time.Second,
+		Usage:  "how often --asyncexport asks ops manager whether the export is ready",
+		EnvVar: "CFOPS_EXPORT_POLL",
+	},
+	cli.DurationFlag{
+		Name:   exportDeadline,
+		Value:  2 * time.Hour,
+		Usage:  "how long --asyncexport waits for the export, from starting it to the end of its download, before failing the backup",
+		EnvVar: "CFOPS_EXPORT_DEADLINE",
+	},
+	cli.StringFlag{
+		Name:   resticKeep,
+		Usage:  "snapshots of the foundation to keep in the --restic repository, pruning the rest, e.g. daily=7,weekly=4",
+		EnvVar: "CFOPS_RESTIC_KEEP",
+	},
+	cli.BoolFlag{
+		Name:  registryHTTP,
+		Usage: "talk to the --registry over plain http rather than https",
+	},
 )
 
 var backupCli = cli.Command{
-	Name:        backup_full_name,
-	ShortName:   backup_short_name,
-	Usage:       backup_usage,
-	Description: backup_descr,
-	Flags:       backupRestoreFlags,
+	Name:      backup_full_name,
+	ShortName: backup_short_name,
+	Usage:     backup_descr,
+	ArgsUsage: backup_usage,
+	Flags:     withFlags(backupFlags, estateFlags...),
 	Action: func(c *cli.Context) {
-		var (
-			err error
-			fs  = &flagSet{
-				host:           c.String(flagList[opsManagerHost].Flag[0]),
-				adminUser:      c.String(flagList[adminUser].Flag[0]),
-				adminPass:      c.String(flagList[adminPass].Flag[0]),
-				opsManagerUser: c.String(flagList[opsManagerUser].Flag[0]),
-				opsManagerPass: c.String(flagList[opsManagerPass].Flag[0]),
-				dest:           c.String(flagList[dest].Flag[0]),
-				tilelist:       c.String(flagList[tilelist].Flag[0]),
-			}
-		)
+		var err error
 
-		if hasValidBackupRestoreFlags(fs) {
-			cfops.SetupSupportedTiles(fs)
-			err = cfops.RunPipeline(fs, cfops.Backup)
+		if c.String(foundations) != "" {
+			backUpFoundations(c)
+			return
+		}
+		fs := newFlagSet(c)
+		fs.runID = cfops.NewRunID()
 
-			if err != nil {
-				fmt.Println(err)
-				ExitCode = errExitCode
+		if fs.dest, err = cfops.ResolveDestination(fs.dest, cfops.NewDestinationFields(fs.host, fs.idempotencyKey, fs.runID, time.Now())); err != nil {
+			fmt.Println(err)
+			ExitCode = errExitCode
+			return
+		}
+
+		if c.Bool(versioned) && fs.dest != "" {
+			fs.dest = cfops.VersionedDestination(fs.dest, fs.idempotencyKey, fs.runID)
+		}
 
-			} else {
-				fmt.Println(backup_full_name, " completed successfully.")
+		if hasValidBackupRestoreFlags(fs) {
+			if err = resumeOrRestart(c, fs); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				ExitCode = errExitCode
+				return
 			}
+			runPipeline(c, fs, cfops.Backup, backup_full_name)
 
 		} else {
 			cli.ShowCommandHelp(c, backup_full_name)
@@ -52,3 +203,37 @@ var backupCli = cli.Command{
 		}
 	},
 }
+
+// resumeOrRestart settles what becomes of the partial backup an interrupted
+// backup of the foundation left in the destination: --auto-resume continues
+// it, --restart discards it and otherwise the operator is asked, as a backup
+// silently starting over would orphan the artifacts it already has. With no
+// one to ask the backup is refused rather than guessed
+func resumeOrRestart(c *cli.Context, fs *flagSet) (err error) {
+	var partial *cfops.RestoreCheckpoint
+
+	if fs.restart || c.Bool(autoResume) {
+		return
+	}
+
+	if partial, err = cfops.PartialBackup(fs.dest, fs.host); err != nil || partial == nil {
+		return
+	}
+	completed := strings.Join(partial.CompletedSteps(), ", ")
+
+	if !cfops.IsTerminal(os.Stdin) {
+		return fmt.Errorf(errPartialBackupFormat, fs.dest, fs.host, completed)
+	}
+	fmt.Fprintf(os.Stderr, resumePromptFormat, fs.dest, fs.host, completed)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+		return
+
+	case "n", "no":
+		fs.restart = true
+		return
+	}
+	return errBackupNotStarted
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	binlogs_full_name string = "binlogs"
+	binlogs_usage            = "--binlogs <dir> --binlogmysqlhost <host> --binlogmysqluser <usr> --binlogmysqlpass <pass> [--follow]"
+	binlogs_descr            = "Capture the binlogs of the elastic runtime mysql server into a directory, between backups or continuously, for point in time recovery"
+)
+
+var binlogsCli = cli.Command{
+	Name:      binlogs_full_name,
+	Usage:     binlogs_descr,
+	ArgsUsage: binlogs_usage,
+	Flags: append(stringFlags(binlogFlagList),
+		cli.BoolFlag{
+			Name:  follow,
+			Usage: "keep capturing the binlogs as the server writes them until interrupted",
+		},
+	),
+	Action: func(c *cli.Context) {
+		var (
+			err      error
+			captured []string
+			config   = newBinlogConfig(c)
+		)
+
+		if !config.Enabled() || config.Host == "" || config.User == "" {
+			cli.ShowCommandHelp(c, binlogs_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+
+		if c.Bool(follow) {
+			ctx, stop := cfops.WatchSignals(abortExitCode)
+
+			// an interrupt is how following the binlogs ends
+			if err = cfops.FollowBinlogs(ctx, config); ctx.Err() != nil {
+				err = nil
+			}
+			stop()
+
+		} else if captured, err = cfops.CaptureBinlogs(config); err == nil {
+			fmt.Printf("captured %d binlogs into %s\n", len(captured), config.Dir)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			ExitCode = errExitCode
+		}
+	},
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	certs_full_name string = "certs-report"
+	certs_usage            = "--opsmanagerhost <host> --adminuser <usr> --adminpass <pass> [--within 720h] [--format json|csv] [-o <file>]"
+	certs_descr            = "Render the certificates ops manager reports as deployed and when each expires as json or csv, exiting with 3 when any expires within the window"
+	certsWindow            = "within"
+)
+
+var certsCli = cli.Command{
+	Name:      certs_full_name,
+	Usage:     certs_descr,
+	ArgsUsage: certs_usage,
+	Flags: append(withFlags(secretFlags, foundationFlags...),
+		stringFlag(flagList[opsManagerHost]),
+		stringFlag(flagList[adminUser]),
+		stringFlag(flagList[adminPass]),
+		cli.DurationFlag{
+			Name:  certsWindow,
+			Value: cfops.DefaultCertificateWindow,
+			Usage: "report the certificates expiring within this window as expiring",
+		},
+		cli.StringFlag{
+			Name:  reportFormat,
+			Value: cfops.ReportJSON,
+			Usage: "json, or csv",
+		},
+		cli.StringFlag{
+			Name:  reportOutput + ", o",
+			Usage: "write the report to this file rather than stdout",
+		},
+	),
+	Action: func(c *cli.Context) {
+		var (
+			certificates []cfops.Certificate
+			out          io.Writer = os.Stdout
+			err          error
+		)
+		fs := newFlagSet(c)
+
+		if fs.Host() == "" || fs.AdminUser() == "" || fs.AdminPass() == "" || fs.secretsErr != nil || fs.foundationErr != nil {
+			for _, flagErr := range []error{fs.secretsErr, fs.foundationErr} {
+				if flagErr != nil {
+					fmt.Println(flagErr)
+				}
+			}
+			cli.ShowCommandHelp(c, certs_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+
+		if certificates, err = cfops.DeployedCertificates(fs.Host(), fs.AdminUser(), fs.AdminPass()); err == nil && c.String(reportOutput) != "" {
+			var file *os.File
+
+			if file, err = os.Create(c.String(reportOutput)); err == nil {
+				defer file.Close()
+				out = file
+			}
+		}
+		report := cfops.NewCertificateReport(fs.Host(), certificates, time.Now(), c.Duration(certsWindow))
+
+		if err == nil {
+			err = cfops.WriteCertificateReport(out, report, c.String(reportFormat))
+		}
+
+		switch {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+
+		case report.Expiring() > 0:
+			fmt.Fprintf(os.Stderr, "%d of the %d certificates of %s expire within %s\n", report.Expiring(), len(report.Certificates), fs.Host(), c.Duration(certsWindow))
+			ExitCode = warnExitCode
+		}
+	},
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	errUnknownCommandFormat = "cfops has no command %s%s, see 'cfops help'"
+	errUnknownFlagFormat    = "cfops %s has no flag %s%s, see 'cfops %s --help'"
+	didYouMeanFormat        = " (did you mean %s?)"
+	// suggestionDistance is the most edits a misspelt name may be away from
+	// the name it is suggested to be
+	suggestionDistance = 2
+)
+
+// openLogFile is the log file of the last configured command, closed when
+// logging is configured again
+var openLogFile io.Closer
+
+// globalFlags apply to every command, and may be given before or after it
+var globalFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   logLevelFlag,
+		Value:  "info",
+		Usage:  "log only records of this level or above: debug, info, notice, warning, error or critical",
+		EnvVar: logLevelEnv,
+	},
+	cli.StringFlag{
+		Name:   logFormat,
+		Value:  cfops.LogFormatText,
+		Usage:  "write logs as text or as json lines tagged with run and task ids",
+		EnvVar: "CFOPS_LOG_FORMAT",
+	},
+	cli.StringFlag{
+		Name:   syslogAddress,
+		Usage:  "also send logs to a syslog endpoint, e.g. udp://host:514, tcp://host:514 or tls://host:6514",
+		EnvVar: "CFOPS_SYSLOG",
+	},
+	cli.StringFlag{
+		Name:   syslogFacility,
+		Value:  "user",
+		Usage:  "syslog facility of the logs, e.g. local0",
+		EnvVar: "CFOPS_SYSLOG_FACILITY",
+	},
+	cli.StringFlag{
+		Name:   syslogCA,
+		Usage:  "pem file of the certificate authorities trusted for a tls syslog endpoint (system roots when omitted)",
+		EnvVar: "CFOPS_SYSLOG_CA",
+	},
+	cli.StringFlag{
+		Name:   logFile,
+		Usage:  "write logs to this file instead of stderr, e.g. when running as a scheduler",
+		EnvVar: "CFOPS_LOG_FILE",
+	},
+	cli.StringFlag{
+		Name:   logMaxSize,
+		Usage:  "rotate the log file once it would grow past this size, e.g. 100MB",
+		EnvVar: "CFOPS_LOG_MAX_SIZE",
+	},
+	cli.DurationFlag{
+		Name:   logRotate,
+		Usage:  "also rotate the log file at every multiple of this interval, e.g. 24h for daily at midnight UTC",
+		EnvVar: "CFOPS_LOG_ROTATE",
+	},
+	cli.IntFlag{
+		Name:   logKeep,
+		Value:  7,
+		Usage:  "how many rotated log files to keep, every one when 0",
+		EnvVar: "CFOPS_LOG_KEEP",
+	},
+	cli.BoolFlag{
+		Name:  noColor,
+		Usage: "never color the status lines, which are only colored on a terminal and without the NO_COLOR environment variable",
+	},
+	cli.BoolFlag{
+		Name:  jsonOutput,
+		Usage: "print the outcome of the command to stdout as json, and nothing else",
+	},
+	cli.StringFlag{
+		Name:   foundationConfig,
+		Usage:  "path of the yaml config of the named foundations (defaults to ~/.cfops/foundations.yml)",
+		EnvVar: "CFOPS_FOUNDATION_CONFIG",
+	},
+}
+
+// withGlobalFlags lets the command take the global flags after its name too,
+// and configures logging from them before it runs
+func withGlobalFlags(command cli.Command) cli.Command {
+	action := command.Action
+	command.Flags = withFlags(command.Flags, globalFlags...)
+	command.Action = func(c *cli.Context) {
+		if err := configureLogging(c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+			return
+		}
+		action(c)
+	}
+	return command
+}
+
+func configureLogging(c *cli.Context) (err error) {
+	var out io.Writer = os.Stderr
+
+	if openLogFile != nil {
+		openLogFile.Close()
+		openLogFile = nil
+	}
+
+	if globalString(c, logFile) != "" {
+		if out, err = openRotatingLog(c); err != nil {
+			return
+		}
+	}
+
+	if err = cfops.SetLogLevel(globalString(c, logLevelFlag)); err != nil {
+		return
+	}
+
+	if err = cfops.ConfigureLogging(globalString(c, logFormat), out); err == nil && globalString(c, syslogAddress) != "" {
+		err = cfops.ConfigureSyslog(globalString(c, syslogAddress), globalString(c, syslogFacility), globalString(c, syslogCA))
+	}
+	return
+}
+
+// openRotatingLog opens the log file the flags give, rotated by size and
+// interval
+func openRotatingLog(c *cli.Context) (out io.WriteCloser, err error) {
+	config := cfops.LogFileConfig{
+		Path:     globalString(c, logFile),
+		Interval: globalDuration(c, logRotate),
+		Keep:     globalInt(c, logKeep),
+	}
+
+	if config.MaxSize, err = cfops.ParseByteSize(globalString(c, logMaxSize)); err != nil {
+		return
+	}
+
+	if out, err = cfops.OpenLogFile(config); err == nil {
+		openLogFile = out
+	}
+	return
+}
+
+// globalString is the value of a global flag given after the command, or else
+// before it
+func globalString(c *cli.Context, name string) string {
+	if c.IsSet(name) {
+		return c.String(name)
+	}
+	return c.GlobalString(name)
+}
+
+func globalDuration(c *cli.Context, name string) time.Duration {
+	if c.IsSet(name) {
+		return c.Duration(name)
+	}
+	return c.GlobalDuration(name)
+}
+
+func globalInt(c *cli.Context, name string) int {
+	if c.IsSet(name) {
+		return c.Int(name)
+	}
+	return c.GlobalInt(name)
+}
+
+func globalBool(c *cli.Context, name string) bool {
+	return c.Bool(name) || c.GlobalBool(name)
+}
+
+// mergeFlags are the flags of every list, each flag only once however many of
+// the lists have it
+func mergeFlags(lists ...[]cli.Flag) (merged []cli.Flag) {
+	set := flag.NewFlagSet("merged", flag.ContinueOnError)
+
+	for _, list := range lists {
+		for _, f := range list {
+			var taken bool
+			names := flag.NewFlagSet("names", flag.ContinueOnError)
+			f.Apply(names)
+			names.VisitAll(func(defined *flag.Flag) { taken = taken || set.Lookup(defined.Name) != nil })
+
+			if !taken {
+				f.Apply(set)
+				merged = append(merged, f)
+			}
+		}
+	}
+	return
+}
+
+// checkCommandLine refuses a command cfops does not have, or a flag the command
+// does not take, suggesting what was likely meant instead of running it
+func checkCommandLine(c *cli.Context) error {
+	args := c.Args()
+
+	if !args.Present() {
+		return nil
+	}
+	var names []string
+
+	for _, command := range c.App.Commands {
+		names = append(names, command.Names()...)
+	}
+	command := c.App.Command(args.First())
+
+	if command == nil {
+		return fmt.Errorf(errUnknownCommandFormat, args.First(), suggest(args.First(), names, ""))
+	}
+	set := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+
+	for _, f := range append(command.Flags, cli.HelpFlag) {
+		f.Apply(set)
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			break
+		}
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			continue
+		}
+		dashes := "--"
+
+		if !strings.HasPrefix(arg, dashes) {
+			dashes = "-"
+		}
+		name := strings.SplitN(strings.TrimPrefix(arg, dashes), "=", 2)[0]
+		defined := set.Lookup(name)
+
+		if defined == nil {
+			var flags []string
+			set.VisitAll(func(f *flag.Flag) { flags = append(flags, f.Name) })
+			return fmt.Errorf(errUnknownFlagFormat, command.Name, dashes+name, suggest(name, flags, dashes), command.Name)
+		}
+
+		// the value of a flag given without = is the next argument
+		if boolean, ok := defined.Value.(interface{ IsBoolFlag() bool }); !strings.Contains(arg, "=") && !(ok && boolean.IsBoolFlag()) {
+			i++
+		}
+	}
+	return nil
+}
+
+// suggest names the closest of the names to the one given, when one is close
+// enough to have been meant
+func suggest(given string, names []string, prefix string) string {
+	var closest []string
+	best := suggestionDistance + 1
+
+	for _, name := range names {
+		distance := editDistance(strings.ToLower(given), strings.ToLower(name))
+
+		if distance < best {
+			closest, best = []string{name}, distance
+
+		} else if distance == best {
+			closest = append(closest, name)
+		}
+	}
+
+	if len(closest) == 0 {
+		return ""
+	}
+	sort.Strings(closest)
+	return fmt.Sprintf(didYouMeanFormat, prefix+closest[0])
+}
+
+// editDistance is the levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
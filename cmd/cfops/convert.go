@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	convert_full_name string = "convert"
+	convert_usage            = "-d <dir>"
+	convert_descr            = "Rewrite a backup taken by an earlier cfops version into the current layout, with a synthesized manifest, so it can be restored"
+)
+
+var convertCli = cli.Command{
+	Name:      convert_full_name,
+	Usage:     convert_descr,
+	ArgsUsage: convert_usage,
+	Flags: []cli.Flag{
+		stringFlag(flagList[dest]),
+	},
+	Action: func(c *cli.Context) {
+		destination := c.String(flagList[dest].Flag[0])
+
+		if destination == "" {
+			cli.ShowCommandHelp(c, convert_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+		moved, err := cfops.ConvertLegacy(destination)
+
+		switch {
+		case err == cfops.ErrAlreadyCurrent:
+			fmt.Println(err)
+
+		case err != nil:
+			fmt.Println(err)
+			ExitCode = errExitCode
+
+		default:
+			fmt.Printf("%s completed successfully, moved %d artifacts.\n", convert_full_name, len(moved))
+		}
+	},
+}
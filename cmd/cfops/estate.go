@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	foundations string = "foundations"
+	parallel    string = "parallel"
+)
+
+var errSharedPassFD = errors.New("the runs of several foundations can not all read --pass-fd, give the passwords in files, the environment or the foundation config")
+
+var estateFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   foundations,
+		Usage:  "back up each of these foundations of the --foundationconfig in a run of its own, a csv list or 'all', printing the outcome of each",
+		EnvVar: "CFOPS_FOUNDATIONS",
+	},
+	cli.IntFlag{
+		Name:   parallel,
+		Value:  1,
+		Usage:  "how many of the --foundations to back up at once",
+		EnvVar: "CFOPS_PARALLEL",
+	},
+}
+
+// cfopsExecutable is the binary run for each foundation of a run over
+// several foundations
+var cfopsExecutable = os.Executable
+
+// backUpFoundations backs up each of the --foundations with a cfops run of its
+// own, taking every other flag given, and prints the outcome of each. The
+// run exits with the worst exit code of the foundations
+func backUpFoundations(c *cli.Context) {
+	var (
+		config     cfops.FoundationConfig
+		names      []string
+		executable string
+		dir        string
+		err        error
+	)
+
+	if config, err = cfops.LoadFoundationConfig(foundationConfigPath(c)); err == nil {
+		names, err = config.Select(c.String(foundations))
+	}
+
+	if err == nil && len(names) > 1 && c.String(dest) != "" && !strings.Contains(c.String(dest), ".Foundation") {
+		err = cfops.ErrSharedDestination(c.String(dest))
+	}
+
+	if err == nil && c.IsSet(passFD) {
+		err = errSharedPassFD
+	}
+
+	if err == nil {
+		executable, err = cfopsExecutable()
+	}
+
+	if err == nil {
+		dir, err = ioutil.TempDir("", "cfops-foundations")
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		ExitCode = errExitCode
+		return
+	}
+	defer os.RemoveAll(dir)
+	args := withoutFlags(os.Args[1:], foundations, parallel, resultFile, jsonOutput)
+	ctx, stop := cfops.WatchSignals(abortExitCode)
+	var output sync.Mutex
+
+	runs := cfops.RunFoundations(names, c.Int(parallel), func(name string) cfops.FoundationRun {
+		resultPath := path.Join(dir, name+".json")
+		foundationArgs := append(append([]string{}, args...), "--"+foundation, name, "--"+resultFile, resultPath)
+		return backUpFoundation(ctx, executable, foundationArgs, name, resultPath, &output)
+	})
+	stop()
+
+	if err = cfops.WriteFoundationRuns(os.Stdout, runs, globalBool(c, jsonOutput)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if c.String(resultFile) != "" {
+		if err = cfops.WriteFoundationResultFile(c.String(resultFile), runs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	ExitCode = foundationsExitCode(runs)
+}
+
+// backUpFoundation runs cfops for the foundation, prefixing each line it
+// prints with the foundation, and asks it to stop once the context is
+// cancelled. The outcome is read from the result file the run writes
+func backUpFoundation(ctx context.Context, executable string, args []string, name, resultPath string, output *sync.Mutex) (run cfops.FoundationRun) {
+	if ctx.Err() != nil {
+		return cfops.FoundationRun{ExitCode: abortExitCode, Error: cfops.ErrAborted.Error()}
+	}
+	stdout, stderr := &prefixWriter{out: os.Stdout, prefix: name, mutex: output}, &prefixWriter{out: os.Stderr, prefix: name, mutex: output}
+	defer stdout.Flush()
+	defer stderr.Flush()
+	cmd := exec.Command(executable, args...)
+	cmd.Env = withoutEnv(os.Environ(), "CFOPS_FOUNDATIONS", "CFOPS_PARALLEL")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	if err := cmd.Start(); err != nil {
+		return cfops.FoundationRun{ExitCode: errExitCode, Error: err.Error()}
+	}
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Signal(syscall.SIGTERM)
+
+		case <-done:
+		}
+	}()
+	cmd.Wait()
+	close(done)
+	run.ExitCode = cmd.ProcessState.ExitCode()
+
+	if result, err := cfops.ReadResultFile(resultPath); err == nil {
+		run.Error, run.Run = result.Error, result.Run
+	}
+	return
+}
+
+// foundationsExitCode is the worst exit code of the foundations: an abort,
+// then a failure, then a partial backup
+func foundationsExitCode(runs []cfops.FoundationRun) (exitCode int) {
+	rank := map[int]int{cleanExitCode: 0, warnExitCode: 1, helpExitCode: 2, errExitCode: 3, abortExitCode: 4}
+
+	for _, run := range runs {
+		code, known := run.ExitCode, true
+
+		if _, known = rank[code]; !known {
+			code = errExitCode
+		}
+
+		if rank[code] > rank[exitCode] {
+			exitCode = code
+		}
+	}
+	return
+}
+
+// withoutFlags is the command line without the named flags and their values,
+// in either the --name value or the --name=value form. Only the json flag is
+// a bool, taking no value
+func withoutFlags(args []string, names ...string) (kept []string) {
+	for i := 0; i < len(args); i++ {
+		name, removed := strings.TrimLeft(args[i], "-"), false
+
+		for _, flag := range names {
+			if !strings.HasPrefix(args[i], "-") {
+				break
+			}
+
+			if name == flag && flag != jsonOutput {
+				removed = true
+				i++
+
+			} else if name == flag || strings.HasPrefix(name, flag+"=") {
+				removed = true
+			}
+		}
+
+		if !removed {
+			kept = append(kept, args[i])
+		}
+	}
+	return
+}
+
+// withoutEnv is the environment without the named variables
+func withoutEnv(env []string, names ...string) (kept []string) {
+	for _, variable := range env {
+		removed := false
+
+		for _, name := range names {
+			removed = removed || strings.HasPrefix(variable, name+"=")
+		}
+
+		if !removed {
+			kept = append(kept, variable)
+		}
+	}
+	return
+}
+
+// prefixWriter writes whole lines to out, each prefixed with the foundation
+// they came from, holding the mutex the runs of every foundation share while
+// writing
+type prefixWriter struct {
+	out     io.Writer
+	prefix  string
+	mutex   *sync.Mutex
+	partial []byte
+}
+
+func (s *prefixWriter) Write(p []byte) (n int, err error) {
+	s.partial = append(s.partial, p...)
+
+	for {
+		end := bytes.IndexByte(s.partial, '\n')
+
+		if end < 0 {
+			return len(p), nil
+		}
+		s.writeLine(s.partial[:end+1])
+		s.partial = s.partial[end+1:]
+	}
+}
+
+// Flush writes what is left of an unfinished last line
+func (s *prefixWriter) Flush() {
+	if len(s.partial) > 0 {
+		s.writeLine(append(s.partial, '\n'))
+		s.partial = nil
+	}
+}
+
+func (s *prefixWriter) writeLine(line []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintf(s.out, "[%s] %s", s.prefix, line)
+}
@@ -2,15 +2,23 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+	"github.com/pivotalservices/gtils/retry"
 )
 
 const (
 	errExitCode           = 1
 	helpExitCode          = 2
 	cleanExitCode         = 0
+	abortExitCode         = 130
+	warnExitCode          = 3
 	opsManagerHost string = "opsmanagerHost"
 	adminUser      string = "adminUser"
 	adminPass      string = "adminPass"
@@ -18,6 +26,113 @@ const (
 	opsManagerPass string = "opsManagerPass"
 	dest           string = "destination"
 	tilelist       string = "tilelist"
+	catalog        string = "catalog"
+	cleanup        string = "cleanuponfailure"
+	overwrite      string = "overwrite"
+	skipUnreach    string = "skip-unreachable"
+	latest         string = "latest"
+	restart        string = "restart"
+	breakLock      string = "breaklock"
+	components     string = "components"
+	window         string = "consistencywindow"
+	heartbeat      string = "heartbeat"
+	retries        string = "retries"
+	retryBackoff   string = "retrybackoff"
+	retryMaxWait   string = "retrymaxbackoff"
+	retryStatus    string = "retrystatus"
+	jsonOutput     string = "json"
+	progress       string = "progress"
+	versioned      string = "versioned"
+	archive        string = "archive"
+	shipLogs       string = "shiplogs"
+	diagnostics    string = "diagnostics"
+	manifests      string = "manifests"
+	manifestDeploy string = "manifestdeployments"
+	manifestKey    string = "manifestkey"
+	asyncExport    string = "asyncexport"
+	exportPoll     string = "exportpoll"
+	exportDeadline string = "exportdeadline"
+	registry       string = "registry"
+	registryUser   string = "registryUser"
+	registryPass   string = "registryPass"
+	registryHTTP   string = "registryplainhttp"
+	restic         string = "restic"
+	resticPassFile string = "resticPasswordFile"
+	resticKeep     string = "restickeep"
+	resticSnapshot string = "resticsnapshot"
+	bbrArtifact    string = "bbr"
+	remap          string = "remap"
+	blobstore      string = "blobstore"
+	blobMirror     string = "blobstoremirror"
+	binlogs        string = "binlogs"
+	binlogHost     string = "binlogHost"
+	binlogPort     string = "binlogPort"
+	binlogUser     string = "binlogUser"
+	binlogPass     string = "binlogPass"
+	pointInTime    string = "pointintime"
+	follow         string = "follow"
+	plan           string = "plan"
+	targetVersion  string = "targetversion"
+	restoreRate    string = "restorerate"
+	restoreConc    string = "restoreconcurrency"
+	adaptiveConc   string = "adaptiveconcurrency"
+	extractConc    string = "extractconcurrency"
+	transferSegs   string = "transfersegments"
+	metadataCache  string = "metadatacache"
+	metadataTTL    string = "metadatacachettl"
+	metadataKey    string = "metadatacachekey"
+	bwLimitTotal   string = "bwlimit-total"
+	bwLimit        string = "bwlimit"
+	applyChanges   string = "applychanges"
+	applyProducts  string = "applychangesproducts"
+	applyTimeout   string = "applychangestimeout"
+	healthCheck    string = "healthcheck"
+	healthDeploys  string = "healthdeployments"
+	ignoreHealth   string = "ignore-unhealthy"
+	smokeTests     string = "smoketests"
+	smokeAPI       string = "smokeAPI"
+	smokeUser      string = "smokeUser"
+	smokePass      string = "smokePass"
+	smokeOrg       string = "smokeOrg"
+	smokeSpace     string = "smokeSpace"
+	smokeApp       string = "smokeApp"
+	smokeDroplet   string = "smokeDropletApp"
+	smokeSkipSSL   string = "smokeskipsslvalidation"
+	smokeTestList  string = "smoketestlist"
+	quiesce        string = "quiesce"
+	quiesceDeploy  string = "quiescedeployment"
+	quiesceJobs    string = "quiescejobs"
+	strategy       string = "strategy"
+	snapshotDeploy string = "snapshotdeployment"
+	snapshotComps  string = "snapshotcomponents"
+	pksDeploy      string = "pksdeployment"
+	pksClusters    string = "pksclusters"
+	idempotencyKey string = "idempotency-key"
+	stateless      string = "stateless"
+	resultFile     string = "resultfile"
+	pagerDutyKey   string = "pagerDutyKey"
+	pagerDutySev   string = "pagerdutyseverity"
+	metricsFile    string = "metricsFile"
+	pushGateway    string = "pushGateway"
+	statsd         string = "statsd"
+	cloudWatchNS   string = "cloudWatchNamespace"
+	cloudWatchDims string = "cloudWatchDimensions"
+	cloudWatchReg  string = "cloudwatchregion"
+	otlpEndpoint   string = "otlpEndpoint"
+	otlpHeaders    string = "otlpHeaders"
+	smtpHost       string = "smtpHost"
+	smtpUser       string = "smtpUser"
+	smtpPass       string = "smtpPass"
+	smtpFrom       string = "smtpFrom"
+	smtpTo         string = "smtpTo"
+	smtpTLS        string = "smtptls"
+	notifyOn       string = "notifyon"
+	auditLog       string = "auditLog"
+	deep           string = "deep"
+	scratchHost    string = "scratchHost"
+	scratchPort    string = "scratchPort"
+	scratchUser    string = "scratchUser"
+	scratchPass    string = "scratchPass"
 )
 
 var (
@@ -54,9 +169,207 @@ var (
 		},
 		tilelist: flagBucket{
 			Flag:   []string{"tilelist", "tl"},
-			Desc:   "a csv list of the tiles you would like to run the operation on",
+			Desc:   "a csv list of the tiles you would like to run the operation on: opsmanager, er, pks",
 			EnvVar: "CFOPS_TILE_LIST",
 		},
+		catalog: flagBucket{
+			Flag:   []string{"catalog", "c"},
+			Desc:   "path of the backup catalog (defaults to ~/.cfops/catalog.json)",
+			EnvVar: "CFOPS_CATALOG",
+		},
+		auditLog: flagBucket{
+			Flag:   []string{"auditlog"},
+			Desc:   "path of the hash chained audit log (defaults to ~/.cfops/audit.log)",
+			EnvVar: "CFOPS_AUDIT_LOG",
+		},
+		metricsFile: flagBucket{
+			Flag:   []string{"metricsfile", "mf"},
+			Desc:   "path of a prometheus node exporter textfile to write the metrics of the run to",
+			EnvVar: "CFOPS_METRICS_FILE",
+		},
+		pushGateway: flagBucket{
+			Flag:   []string{"pushgateway", "pg"},
+			Desc:   "url of a prometheus pushgateway to push the metrics of the run to",
+			EnvVar: "CFOPS_PUSHGATEWAY",
+		},
+		pagerDutyKey: flagBucket{
+			Flag:   []string{"pagerdutykey"},
+			Desc:   "pagerduty events api routing key to alert on runs that did not complete",
+			EnvVar: "CFOPS_PAGERDUTY_ROUTING_KEY",
+		},
+		statsd: flagBucket{
+			Flag:   []string{"statsd"},
+			Desc:   "host:port of a statsd server to send the metrics of the run to",
+			EnvVar: "CFOPS_STATSD",
+		},
+		cloudWatchNS: flagBucket{
+			Flag:   []string{"cloudwatchnamespace"},
+			Desc:   "cloudwatch namespace to put the metrics of the run to, with aws credentials from the environment or the instance profile",
+			EnvVar: "CFOPS_CLOUDWATCH_NAMESPACE",
+		},
+		cloudWatchDims: flagBucket{
+			Flag:   []string{"cloudwatchdimensions"},
+			Desc:   "a csv list of name=value dimensions added to the cloudwatch metrics, e.g. Environment=prod",
+			EnvVar: "CFOPS_CLOUDWATCH_DIMENSIONS",
+		},
+		otlpEndpoint: flagBucket{
+			Flag:   []string{"otlpendpoint"},
+			Desc:   "url of an opentelemetry collector to export the spans of the run to over otlp/http, e.g. http://collector:4318",
+			EnvVar: "OTEL_EXPORTER_OTLP_ENDPOINT",
+		},
+		otlpHeaders: flagBucket{
+			Flag:   []string{"otlpheaders"},
+			Desc:   "a csv list of name=value headers added to the otlp export, e.g. x-api-key=secret",
+			EnvVar: "OTEL_EXPORTER_OTLP_HEADERS",
+		},
+	}
+
+	smtpFlagList = map[string]flagBucket{
+		smtpHost: flagBucket{
+			Flag:   []string{"smtphost"},
+			Desc:   "host:port of an smtp server to email the outcome of the run through",
+			EnvVar: "CFOPS_SMTP_HOST",
+		},
+		smtpUser: flagBucket{
+			Flag:   []string{"smtpuser"},
+			Desc:   "username for the smtp server",
+			EnvVar: "CFOPS_SMTP_USER",
+		},
+		smtpPass: flagBucket{
+			Flag:   []string{"smtppass"},
+			Desc:   "password for the smtp server",
+			EnvVar: "CFOPS_SMTP_PASS",
+		},
+		smtpFrom: flagBucket{
+			Flag:   []string{"smtpfrom"},
+			Desc:   "sender address of the run email",
+			EnvVar: "CFOPS_SMTP_FROM",
+		},
+		smtpTo: flagBucket{
+			Flag:   []string{"smtpto"},
+			Desc:   "a csv list of the recipients of the run email",
+			EnvVar: "CFOPS_SMTP_TO",
+		},
+	}
+
+	registryFlagList = map[string]flagBucket{
+		registry: flagBucket{
+			Flag:   []string{"registry"},
+			Desc:   "oci registry repository to push the backup to, e.g. harbor.example.com/cfops/foundation",
+			EnvVar: "CFOPS_REGISTRY",
+		},
+		registryUser: flagBucket{
+			Flag:   []string{"registryuser"},
+			Desc:   "username for the oci registry",
+			EnvVar: "CFOPS_REGISTRY_USER",
+		},
+		registryPass: flagBucket{
+			Flag:   []string{"registrypass"},
+			Desc:   "password for the oci registry",
+			EnvVar: "CFOPS_REGISTRY_PASS",
+		},
+	}
+
+	resticFlagList = map[string]flagBucket{
+		restic: flagBucket{
+			Flag:   []string{"restic"},
+			Desc:   "restic repository to keep the backup in, e.g. s3:s3.amazonaws.com/bucket/cfops",
+			EnvVar: "CFOPS_RESTIC_REPOSITORY",
+		},
+		resticPassFile: flagBucket{
+			Flag:   []string{"resticpasswordfile"},
+			Desc:   "file holding the password of the restic repository, which may otherwise be given in RESTIC_PASSWORD",
+			EnvVar: "CFOPS_RESTIC_PASSWORD_FILE",
+		},
+	}
+
+	binlogFlagList = map[string]flagBucket{
+		binlogs: flagBucket{
+			Flag:   []string{"binlogs"},
+			Desc:   "directory to capture the binlogs of the elastic runtime mysql server into, and replay them from to --pointintime",
+			EnvVar: "CFOPS_BINLOGS",
+		},
+		binlogHost: flagBucket{
+			Flag:   []string{"binlogmysqlhost"},
+			Desc:   "hostname of the elastic runtime mysql server the binlogs are captured from and replayed into",
+			EnvVar: "CFOPS_BINLOG_MYSQL_HOST",
+		},
+		binlogPort: flagBucket{
+			Flag:   []string{"binlogmysqlport"},
+			Desc:   "port of the elastic runtime mysql server",
+			EnvVar: "CFOPS_BINLOG_MYSQL_PORT",
+		},
+		binlogUser: flagBucket{
+			Flag:   []string{"binlogmysqluser"},
+			Desc:   "username for the elastic runtime mysql server, which needs the replication client and slave privileges",
+			EnvVar: "CFOPS_BINLOG_MYSQL_USER",
+		},
+		binlogPass: flagBucket{
+			Flag:   []string{"binlogmysqlpass"},
+			Desc:   "password for the elastic runtime mysql server",
+			EnvVar: "CFOPS_BINLOG_MYSQL_PASS",
+		},
+	}
+
+	smokeFlagList = map[string]flagBucket{
+		smokeAPI: flagBucket{
+			Flag:   []string{"smokeapi"},
+			Desc:   "cloud controller api of the restored foundation the --smoketests run against, e.g. https://api.sys.example.com",
+			EnvVar: "CFOPS_SMOKE_API",
+		},
+		smokeUser: flagBucket{
+			Flag:   []string{"smokeuser"},
+			Desc:   "username the --smoketests log in to uaa with",
+			EnvVar: "CFOPS_SMOKE_USER",
+		},
+		smokePass: flagBucket{
+			Flag:   []string{"smokepass"},
+			Desc:   "password the --smoketests log in to uaa with",
+			EnvVar: "CFOPS_SMOKE_PASS",
+		},
+		smokeOrg: flagBucket{
+			Flag:   []string{"smokeorg"},
+			Desc:   "org the --smoketests target and push into",
+			EnvVar: "CFOPS_SMOKE_ORG",
+		},
+		smokeSpace: flagBucket{
+			Flag:   []string{"smokespace"},
+			Desc:   "space the --smoketests target and push into",
+			EnvVar: "CFOPS_SMOKE_SPACE",
+		},
+		smokeApp: flagBucket{
+			Flag:   []string{"smokeapp"},
+			Desc:   "directory of a test app the --smoketests push, and delete again",
+			EnvVar: "CFOPS_SMOKE_APP",
+		},
+		smokeDroplet: flagBucket{
+			Flag:   []string{"smokedropletapp"},
+			Desc:   "a restored app the --smoketests download the droplet of from the blobstore (the pushed app when omitted)",
+			EnvVar: "CFOPS_SMOKE_DROPLET_APP",
+		},
+	}
+
+	scratchFlagList = map[string]flagBucket{
+		scratchHost: flagBucket{
+			Flag:   []string{"scratchmysqlhost", "smh"},
+			Desc:   "hostname of a disposable MySQL server to restore mysql dumps into during a deep verify",
+			EnvVar: "CFOPS_SCRATCH_MYSQL_HOST",
+		},
+		scratchPort: flagBucket{
+			Flag:   []string{"scratchmysqlport", "smp"},
+			Desc:   "port of the scratch MySQL server",
+			EnvVar: "CFOPS_SCRATCH_MYSQL_PORT",
+		},
+		scratchUser: flagBucket{
+			Flag:   []string{"scratchmysqluser", "smu"},
+			Desc:   "username for the scratch MySQL server",
+			EnvVar: "CFOPS_SCRATCH_MYSQL_USER",
+		},
+		scratchPass: flagBucket{
+			Flag:   []string{"scratchmysqlpass", "smpw"},
+			Desc:   "password for the scratch MySQL server",
+			EnvVar: "CFOPS_SCRATCH_MYSQL_PASS",
+		},
 	}
 )
 
@@ -69,6 +382,66 @@ type (
 		opsManagerPass string
 		dest           string
 		tilelist       string
+		catalog        string
+		cleanup        bool
+		overwrite      bool
+		skipUnreach    bool
+		restart        bool
+		lockDir        string
+		checkpointDir  string
+		breakLock      bool
+		components     string
+		window         time.Duration
+		heartbeat      time.Duration
+		idempotencyKey string
+		runID          string
+		metricsFile    string
+		pushGateway    string
+		statsd         string
+		cloudWatch     cfops.CloudWatchConfig
+		cloudWatchErr  error
+		tracing        cfops.TracingConfig
+		tracingErr     error
+		retryErr       error
+		retry          cfops.RetryConfig
+		smtp           cfops.SMTPConfig
+		archive        bool
+		shipLogs       bool
+		diagnostics    bool
+		asyncExport    cfops.AsyncExportConfig
+		manifests      cfops.DeploymentManifestsConfig
+		applyChanges   cfops.ApplyChangesConfig
+		healthCheck    cfops.HealthCheckConfig
+		smokeTests     cfops.SmokeTestConfig
+		quiesce        cfops.QuiesceConfig
+		snapshots      cfops.SnapshotConfig
+		pks            cfops.PKSConfig
+		strategyErr    error
+		registry       cfops.RegistryConfig
+		restic         cfops.ResticConfig
+		bbrArtifact    string
+		remap          string
+		blobstore      string
+		blobMirror     string
+		targetVersion  string
+		binlogs        cfops.BinlogConfig
+		pointInTimeErr error
+		limits         cfops.RestoreLimits
+		extract        int
+		segments       int
+		metadata       cfops.MetadataCacheConfig
+		rateErr        error
+		bandwidth      cfops.BandwidthLimits
+		bandwidthErr   error
+		pagerDuty      cfops.PagerDutyConfig
+		pagerDutyErr   error
+		secretsErr     error
+		foundationErr  error
+		auditLog       string
+		adminPassFile  string
+		adminPassCmd   string
+		// credentials guards the adminPass Reauthenticate resolves again
+		credentials sync.Mutex
 	}
 
 	flagBucket struct {
@@ -87,6 +460,8 @@ func (s *flagSet) AdminUser() string {
 }
 
 func (s *flagSet) AdminPass() string {
+	s.credentials.Lock()
+	defer s.credentials.Unlock()
 	return s.adminPass
 }
 
@@ -106,28 +481,636 @@ func (s *flagSet) Tilelist() string {
 	return s.tilelist
 }
 
+func (s *flagSet) Catalog() string {
+	return s.catalog
+}
+
+func (s *flagSet) CleanupOnFailure() bool {
+	return s.cleanup
+}
+
+func (s *flagSet) Overwrite() bool {
+	return s.overwrite
+}
+
+func (s *flagSet) SkipUnreachable() bool {
+	return s.skipUnreach
+}
+
+func (s *flagSet) RestartRestore() bool {
+	return s.restart
+}
+
+func (s *flagSet) LockDir() string {
+	return s.lockDir
+}
+
+func (s *flagSet) CheckpointDir() string {
+	return s.checkpointDir
+}
+
+func (s *flagSet) BreakLock() bool {
+	return s.breakLock
+}
+
+func (s *flagSet) Components() string {
+	return s.components
+}
+
+func (s *flagSet) ConsistencyWindow() time.Duration {
+	return s.window
+}
+
+func (s *flagSet) RestoreLimits() cfops.RestoreLimits {
+	return s.limits
+}
+
+func (s *flagSet) Bandwidth() cfops.BandwidthLimits {
+	return s.bandwidth
+}
+
+func (s *flagSet) ExtractConcurrency() int {
+	return s.extract
+}
+
+func (s *flagSet) TransferSegments() int {
+	return s.segments
+}
+
+func (s *flagSet) MetadataCache() cfops.MetadataCacheConfig {
+	return s.metadata
+}
+
+func (s *flagSet) Heartbeat() time.Duration {
+	return s.heartbeat
+}
+
+func (s *flagSet) IdempotencyKey() string {
+	return s.idempotencyKey
+}
+
+func (s *flagSet) RunID() string {
+	return s.runID
+}
+
+func (s *flagSet) MetricsFile() string {
+	return s.metricsFile
+}
+
+func (s *flagSet) PushGateway() string {
+	return s.pushGateway
+}
+
+func (s *flagSet) StatsdAddress() string {
+	return s.statsd
+}
+
+func (s *flagSet) SMTP() cfops.SMTPConfig {
+	return s.smtp
+}
+
+func (s *flagSet) BBRArtifact() string {
+	return s.bbrArtifact
+}
+
+func (s *flagSet) Remap() string {
+	return s.remap
+}
+
+func (s *flagSet) BlobstoreCategories() string {
+	return s.blobstore
+}
+
+func (s *flagSet) BlobstoreMirror() string {
+	return s.blobMirror
+}
+
+func (s *flagSet) TargetVersion() string {
+	return s.targetVersion
+}
+
+func (s *flagSet) Binlogs() cfops.BinlogConfig {
+	return s.binlogs
+}
+
+func (s *flagSet) Restic() cfops.ResticConfig {
+	return s.restic
+}
+
+func (s *flagSet) CloudWatch() cfops.CloudWatchConfig {
+	return s.cloudWatch
+}
+
+func (s *flagSet) Tracing() cfops.TracingConfig {
+	return s.tracing
+}
+
+func (s *flagSet) Retry() cfops.RetryConfig {
+	return s.retry
+}
+
+func (s *flagSet) Registry() cfops.RegistryConfig {
+	return s.registry
+}
+
+func (s *flagSet) HealthCheck() cfops.HealthCheckConfig {
+	return s.healthCheck
+}
+
+func (s *flagSet) SmokeTests() cfops.SmokeTestConfig {
+	return s.smokeTests
+}
+
+func (s *flagSet) Quiesce() cfops.QuiesceConfig {
+	return s.quiesce
+}
+
+func (s *flagSet) Snapshots() cfops.SnapshotConfig {
+	return s.snapshots
+}
+
+func (s *flagSet) PKS() cfops.PKSConfig {
+	return s.pks
+}
+
+func (s *flagSet) ApplyChanges() cfops.ApplyChangesConfig {
+	return s.applyChanges
+}
+
+func (s *flagSet) ShipLogs() bool {
+	return s.shipLogs
+}
+
+func (s *flagSet) Diagnostics() bool {
+	return s.diagnostics
+}
+
+func (s *flagSet) AsyncExport() cfops.AsyncExportConfig {
+	return s.asyncExport
+}
+
+func (s *flagSet) DeploymentManifests() cfops.DeploymentManifestsConfig {
+	return s.manifests
+}
+
+func (s *flagSet) Archive() bool {
+	return s.archive
+}
+
+func (s *flagSet) PagerDuty() cfops.PagerDutyConfig {
+	return s.pagerDuty
+}
+
+func (s *flagSet) AuditLog() string {
+	return s.auditLog
+}
+
+func newFlagSet(c *cli.Context) *flagSet {
+	fs := &flagSet{
+		host:           c.String(flagList[opsManagerHost].Flag[0]),
+		adminUser:      c.String(flagList[adminUser].Flag[0]),
+		adminPass:      c.String(flagList[adminPass].Flag[0]),
+		opsManagerUser: c.String(flagList[opsManagerUser].Flag[0]),
+		opsManagerPass: c.String(flagList[opsManagerPass].Flag[0]),
+		dest:           c.String(flagList[dest].Flag[0]),
+		tilelist:       c.String(flagList[tilelist].Flag[0]),
+		cleanup:        c.Bool(cleanup),
+		overwrite:      c.Bool(overwrite),
+		skipUnreach:    c.Bool(skipUnreach),
+		restart:        c.Bool(restart),
+		breakLock:      c.Bool(breakLock),
+		components:     c.String(components),
+		window:         c.Duration(window),
+		archive:        c.Bool(archive),
+		shipLogs:       c.Bool(shipLogs),
+		diagnostics:    c.Bool(diagnostics),
+		bbrArtifact:    c.String(bbrArtifact),
+		remap:          c.String(remap),
+		blobstore:      c.String(blobstore),
+		blobMirror:     c.String(blobMirror),
+		targetVersion:  c.String(targetVersion),
+		heartbeat:      c.Duration(heartbeat),
+		retry: cfops.RetryConfig{
+			Attempts:   c.Int(retries),
+			Backoff:    c.Duration(retryBackoff),
+			MaxBackoff: c.Duration(retryMaxWait),
+		},
+		idempotencyKey: c.String(idempotencyKey),
+		metricsFile:    c.String(flagList[metricsFile].Flag[0]),
+		pushGateway:    c.String(flagList[pushGateway].Flag[0]),
+		statsd:         c.String(flagList[statsd].Flag[0]),
+		smtp: cfops.SMTPConfig{
+			Host:        c.String(smtpFlagList[smtpHost].Flag[0]),
+			User:        c.String(smtpFlagList[smtpUser].Flag[0]),
+			Pass:        c.String(smtpFlagList[smtpPass].Flag[0]),
+			From:        c.String(smtpFlagList[smtpFrom].Flag[0]),
+			ImplicitTLS: c.Bool(smtpTLS),
+			NotifyOn:    c.String(notifyOn),
+		},
+		restic: cfops.ResticConfig{
+			Repository:   c.String(resticFlagList[restic].Flag[0]),
+			PasswordFile: c.String(resticFlagList[resticPassFile].Flag[0]),
+			Keep:         c.String(resticKeep),
+			Snapshot:     c.String(resticSnapshot),
+		},
+		applyChanges: cfops.ApplyChangesConfig{
+			Enabled: c.Bool(applyChanges),
+			Timeout: c.Duration(applyTimeout),
+		},
+		healthCheck: cfops.HealthCheckConfig{
+			Enabled:         c.Bool(healthCheck),
+			IgnoreUnhealthy: c.Bool(ignoreHealth),
+		},
+		smokeTests: cfops.SmokeTestConfig{
+			Enabled:           c.Bool(smokeTests),
+			API:               c.String(smokeFlagList[smokeAPI].Flag[0]),
+			User:              c.String(smokeFlagList[smokeUser].Flag[0]),
+			Pass:              c.String(smokeFlagList[smokePass].Flag[0]),
+			Org:               c.String(smokeFlagList[smokeOrg].Flag[0]),
+			Space:             c.String(smokeFlagList[smokeSpace].Flag[0]),
+			App:               c.String(smokeFlagList[smokeApp].Flag[0]),
+			DropletApp:        c.String(smokeFlagList[smokeDroplet].Flag[0]),
+			SkipSSLValidation: c.Bool(smokeSkipSSL),
+		},
+		quiesce: cfops.QuiesceConfig{
+			Enabled:    c.Bool(quiesce),
+			Deployment: c.String(quiesceDeploy),
+		},
+		asyncExport: cfops.AsyncExportConfig{
+			Enabled:      c.Bool(asyncExport),
+			PollInterval: c.Duration(exportPoll),
+			Deadline:     c.Duration(exportDeadline),
+		},
+		snapshots: cfops.SnapshotConfig{
+			Deployment: c.String(snapshotDeploy),
+		},
+		pks: cfops.PKSConfig{
+			Deployment: c.String(pksDeploy),
+		},
+		manifests: cfops.DeploymentManifestsConfig{
+			Enabled: c.Bool(manifests),
+			Key:     c.String(manifestKey),
+		},
+		binlogs: newBinlogConfig(c),
+		registry: cfops.RegistryConfig{
+			Repository: c.String(registryFlagList[registry].Flag[0]),
+			User:       c.String(registryFlagList[registryUser].Flag[0]),
+			Pass:       c.String(registryFlagList[registryPass].Flag[0]),
+			PlainHTTP:  c.Bool(registryHTTP),
+		},
+	}
+
+	for _, to := range strings.Split(c.String(smtpFlagList[smtpTo].Flag[0]), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			fs.smtp.To = append(fs.smtp.To, to)
+		}
+	}
+
+	for _, product := range strings.Split(c.String(applyProducts), ",") {
+		if product = strings.TrimSpace(product); product != "" {
+			fs.applyChanges.Products = append(fs.applyChanges.Products, product)
+		}
+	}
+
+	for _, deployment := range strings.Split(c.String(healthDeploys), ",") {
+		if deployment = strings.TrimSpace(deployment); deployment != "" {
+			fs.healthCheck.Deployments = append(fs.healthCheck.Deployments, deployment)
+		}
+	}
+
+	for _, test := range strings.Split(c.String(smokeTestList), ",") {
+		if test = strings.TrimSpace(test); test != "" {
+			fs.smokeTests.Tests = append(fs.smokeTests.Tests, test)
+		}
+	}
+
+	for _, job := range strings.Split(c.String(quiesceJobs), ",") {
+		if job = strings.TrimSpace(job); job != "" {
+			fs.quiesce.Jobs = append(fs.quiesce.Jobs, job)
+		}
+	}
+
+	for _, deployment := range strings.Split(c.String(manifestDeploy), ",") {
+		if deployment = strings.TrimSpace(deployment); deployment != "" {
+			fs.manifests.Deployments = append(fs.manifests.Deployments, deployment)
+		}
+	}
+
+	for _, cluster := range strings.Split(c.String(pksClusters), ",") {
+		if cluster = strings.TrimSpace(cluster); cluster != "" {
+			fs.pks.Clusters = append(fs.pks.Clusters, cluster)
+		}
+	}
+
+	fs.pagerDuty.RoutingKey = c.String(flagList[pagerDutyKey].Flag[0])
+	fs.pagerDuty.Severities, fs.pagerDutyErr = cfops.ParsePagerDutySeverities(c.String(pagerDutySev))
+	fs.snapshots.Strategies, fs.strategyErr = cfops.ParseStrategies(c.String(strategy))
+
+	for _, component := range strings.Split(c.String(snapshotComps), ",") {
+		if component = strings.ToLower(strings.TrimSpace(component)); component != "" {
+			fs.snapshots.Components = append(fs.snapshots.Components, component)
+		}
+	}
+
+	fs.cloudWatch.Namespace = c.String(flagList[cloudWatchNS].Flag[0])
+	fs.cloudWatch.Region = c.String(cloudWatchReg)
+	fs.cloudWatch.Dimensions, fs.cloudWatchErr = cfops.ParseCloudWatchDimensions(c.String(flagList[cloudWatchDims].Flag[0]))
+	fs.tracing.Endpoint = c.String(flagList[otlpEndpoint].Flag[0])
+	fs.tracing.Headers, fs.tracingErr = cfops.ParseOTLPHeaders(c.String(flagList[otlpHeaders].Flag[0]))
+	fs.retry.Statuses, fs.retryErr = cfops.ParseRetryStatuses(c.String(retryStatus))
+
+	fs.limits.Concurrency = c.Int(restoreConc)
+	fs.limits.Adaptive = c.Bool(adaptiveConc)
+	fs.extract = c.Int(extractConc)
+	fs.segments = c.Int(transferSegs)
+	fs.limits.Rate, fs.rateErr = cfops.ParseByteRate(c.String(restoreRate))
+	fs.bandwidth, fs.bandwidthErr = cfops.ParseBandwidthLimits(c.String(bwLimitTotal), c.String(bwLimit))
+
+	if c.String(pointInTime) != "" {
+		fs.binlogs.PointInTime, fs.pointInTimeErr = time.Parse(time.RFC3339, c.String(pointInTime))
+	}
+
+	fs.secretsErr = fs.readSecrets(c)
+	fs.foundationErr = fs.useFoundation(c)
+
+	state := stateDir(c, fs.dest)
+	fs.lockDir = path.Join(state, "locks")
+	fs.checkpointDir = path.Join(state, "checkpoints")
+	fs.catalog = catalogPath(c, state)
+	fs.auditLog = auditLogPath(c, state)
+	fs.metadata = metadataCacheConfig(c, state, fs.host)
+	return fs
+}
+
+func newBinlogConfig(c *cli.Context) cfops.BinlogConfig {
+	return cfops.BinlogConfig{
+		Dir:  c.String(binlogFlagList[binlogs].Flag[0]),
+		Host: c.String(binlogFlagList[binlogHost].Flag[0]),
+		Port: c.String(binlogFlagList[binlogPort].Flag[0]),
+		User: c.String(binlogFlagList[binlogUser].Flag[0]),
+		Pass: c.String(binlogFlagList[binlogPass].Flag[0]),
+	}
+}
+
+func catalogPath(c *cli.Context, state string) (catalogPath string) {
+	if catalogPath = c.String(flagList[catalog].Flag[0]); catalogPath == "" {
+		catalogPath = path.Join(state, "catalog.json")
+	}
+	return
+}
+
+func auditLogPath(c *cli.Context, state string) (auditPath string) {
+	if auditPath = c.String(flagList[auditLog].Flag[0]); auditPath == "" {
+		auditPath = path.Join(state, "audit.log")
+	}
+	return
+}
+
+// metadataCacheConfig caches the metadata of the foundation in the state
+// directory unless --metadatacache says where, and not at all without a ttl
+func metadataCacheConfig(c *cli.Context, state, host string) (config cfops.MetadataCacheConfig) {
+	config = cfops.MetadataCacheConfig{TTL: c.Duration(metadataTTL), Key: c.String(metadataKey)}
+
+	if config.TTL <= 0 {
+		return
+	}
+
+	if config.Path = c.String(metadataCache); config.Path == "" {
+		config.Path = path.Join(state, "metadata", host+".cache")
+	}
+	return
+}
+
+// stateDir is where the catalog, locks and audit log are kept by default:
+// ~/.cfops, or the .cfops directory of the destination for a --stateless run
+func stateDir(c *cli.Context, destination string) string {
+	if c.Bool(stateless) && destination != "" {
+		return path.Join(destination, ".cfops")
+	}
+	return cfopsHome()
+}
+
+func cfopsHome() string {
+	return path.Join(os.Getenv("HOME"), ".cfops")
+}
+
 func hasValidBackupRestoreFlags(fs *flagSet) bool {
 	res := (fs.Host() != "" && fs.AdminUser() != "" && fs.AdminPass() != "" && fs.OpsManagerUser() != "" && fs.OpsManagerPass() != "" && fs.Dest() != "")
 
+	if fs.secretsErr != nil {
+		fmt.Println(fs.secretsErr)
+		res = false
+	}
+
+	if fs.foundationErr != nil {
+		fmt.Println(fs.foundationErr)
+		res = false
+	}
+
+	if fs.strategyErr != nil {
+		fmt.Println(fs.strategyErr)
+		res = false
+	}
+
+	if fs.pagerDutyErr != nil {
+		fmt.Println(fs.pagerDutyErr)
+		res = false
+	}
+
+	if fs.cloudWatchErr != nil {
+		fmt.Println(fs.cloudWatchErr)
+		res = false
+	}
+
+	if fs.tracingErr != nil {
+		fmt.Println(fs.tracingErr)
+		res = false
+	}
+
+	if fs.retryErr != nil {
+		fmt.Println(fs.retryErr)
+		res = false
+	}
+
+	if fs.rateErr != nil {
+		fmt.Println(fs.rateErr)
+		res = false
+	}
+
+	if fs.bandwidthErr != nil {
+		fmt.Println(fs.bandwidthErr)
+		res = false
+	}
+
+	if fs.pointInTimeErr != nil {
+		fmt.Println(fs.pointInTimeErr)
+		res = false
+	}
+
+	if !fs.binlogs.PointInTime.IsZero() && !fs.binlogs.Enabled() {
+		fmt.Println("--pointintime replays the binlogs of --binlogs")
+		res = false
+	}
+
+	if fs.smokeTests.Enabled && (fs.smokeTests.API == "" || fs.smokeTests.User == "") {
+		fmt.Println("--smoketests log in to the --smokeapi as --smokeuser")
+		res = false
+	}
+
+	if _, err := fs.restic.KeepArgs(); err != nil {
+		fmt.Println(err)
+		res = false
+	}
+
 	if res == false {
 		fmt.Println("OpsManagerHost: ", fs.Host())
 		fmt.Println("adminUser: ", fs.AdminUser())
-		fmt.Println("adminPass: ", fs.AdminPass())
+		fmt.Println("adminPass: ", masked(fs.AdminPass()))
 		fmt.Println("OpsManagerUser: ", fs.OpsManagerUser())
-		fmt.Println("OpsManagerPass: ", fs.OpsManagerPass())
+		fmt.Println("OpsManagerPass: ", masked(fs.OpsManagerPass()))
 		fmt.Println("Destination: ", fs.Dest())
 	}
 	return res
 }
 
-var backupRestoreFlags = func() (flags []cli.Flag) {
-	for _, v := range flagList {
-		flags = append(flags, cli.StringFlag{
-			Name:   strings.Join(v.Flag, ", "),
-			Value:  "",
-			Usage:  v.Desc,
-			EnvVar: v.EnvVar,
-		})
+// masked stands in for a password in what is printed, telling only whether it
+// was given
+func masked(password string) string {
+	if password == "" {
+		return ""
+	}
+	return "********"
+}
+
+func stringFlags(list map[string]flagBucket) (flags []cli.Flag) {
+	for _, v := range list {
+		flags = append(flags, stringFlag(v))
 	}
 	return
-}()
+}
+
+func stringFlag(v flagBucket) cli.Flag {
+	return cli.StringFlag{
+		Name:   strings.Join(v.Flag, ", "),
+		Value:  "",
+		Usage:  v.Desc,
+		EnvVar: v.EnvVar,
+	}
+}
+
+func withFlags(base []cli.Flag, extra ...cli.Flag) []cli.Flag {
+	return append(append([]cli.Flag{}, base...), extra...)
+}
+
+var backupRestoreFlags = withFlags(append(append(append(append(append(stringFlags(flagList), stringFlags(smtpFlagList)...), stringFlags(resticFlagList)...), stringFlags(binlogFlagList)...), secretFlags...), foundationFlags...),
+	cli.BoolFlag{
+		Name:  breakLock,
+		Usage: "discard the lock left behind by an earlier run of this foundation or destination",
+	},
+	cli.BoolFlag{
+		Name:   smtpTLS,
+		Usage:  "connect to the smtp server over tls (port 465) instead of upgrading with STARTTLS",
+		EnvVar: "CFOPS_SMTP_TLS",
+	},
+	cli.StringFlag{
+		Name:   notifyOn,
+		Value:  cfops.NotifyFailure,
+		Usage:  "email the outcome of every run (always) or only of runs that did not complete (failure)",
+		EnvVar: "CFOPS_NOTIFY_ON",
+	},
+	cli.StringFlag{
+		Name:   bwLimitTotal,
+		Usage:  "the most bytes a second the transfers of the elastic runtime stores move together, however many run at once, e.g. 100MB (unlimited when omitted)",
+		EnvVar: "CFOPS_BWLIMIT_TOTAL",
+	},
+	cli.StringFlag{
+		Name:   bwLimit,
+		Usage:  "a csv list of the most bytes a second the transfers of single components move, e.g. 'nfs_server=20MB, ccdb=5MB'",
+		EnvVar: "CFOPS_BWLIMIT",
+	},
+	cli.StringFlag{
+		Name:   metadataCache,
+		Usage:  "path of the encrypted cache of what ops manager and the bosh director report about the foundation (a file of the state directory when omitted)",
+		EnvVar: "CFOPS_METADATA_CACHE",
+	},
+	cli.DurationFlag{
+		Name:   metadataTTL,
+		Value:  cfops.DefaultMetadataCacheTTL,
+		Usage:  "how long the --metadatacache is trusted before ops manager and the director are asked again (0 to never cache)",
+		EnvVar: "CFOPS_METADATA_CACHE_TTL",
+	},
+	cli.StringFlag{
+		Name:   metadataKey,
+		Usage:  "the secret the --metadatacache is encrypted with (the ops manager credentials when omitted)",
+		EnvVar: "CFOPS_METADATA_CACHE_KEY",
+	},
+	cli.DurationFlag{
+		Name:   heartbeat,
+		Value:  cfops.DefaultHeartbeat,
+		Usage:  "how often to log the progress of a database or blobstore transfer (0 to never)",
+		EnvVar: "CFOPS_HEARTBEAT",
+	},
+	cli.IntFlag{
+		Name:   retries,
+		Value:  retry.DefaultAttempts,
+		Usage:  "how many times in all to try an ssh dial, sftp write, ops manager api call or storage upload that fails for a transient reason (1 to never retry)",
+		EnvVar: "CFOPS_RETRIES",
+	},
+	cli.DurationFlag{
+		Name:   retryBackoff,
+		Value:  retry.DefaultBackoff,
+		Usage:  "how long to wait before the first retry, doubled before each one after that",
+		EnvVar: "CFOPS_RETRY_BACKOFF",
+	},
+	cli.DurationFlag{
+		Name:   retryMaxWait,
+		Value:  retry.DefaultMaxBackoff,
+		Usage:  "the longest wait between two retries",
+		EnvVar: "CFOPS_RETRY_MAX_BACKOFF",
+	},
+	cli.StringFlag{
+		Name:   retryStatus,
+		Usage:  "further http status codes to send a request again on, e.g. 500,408, besides 429, 502, 503 and 504",
+		EnvVar: "CFOPS_RETRY_STATUS",
+	},
+	cli.StringFlag{
+		Name:   cloudWatchReg,
+		Usage:  "aws region of the --cloudwatchnamespace",
+		EnvVar: "AWS_REGION",
+	},
+	cli.StringFlag{
+		Name:   progress,
+		Usage:  "print the progress of each transfer at every --heartbeat, 'plain' for single PROGRESS lines of key=value pairs",
+		EnvVar: "CFOPS_PROGRESS",
+	},
+	cli.StringFlag{
+		Name:   idempotencyKey,
+		Usage:  "identifies retries of the same run, e.g. a ci build, which reuse its catalog entry and are skipped once it completed",
+		EnvVar: "CFOPS_IDEMPOTENCY_KEY",
+	},
+	cli.StringFlag{
+		Name:   pagerDutySev,
+		Usage:  "severities of the pagerduty alerts of incomplete and aborted runs, e.g. incomplete=critical,aborted=warning",
+		EnvVar: "CFOPS_PAGERDUTY_SEVERITY",
+	},
+	cli.BoolFlag{
+		Name:   stateless,
+		Usage:  "keep the catalog, locks and audit log in the .cfops directory of the destination instead of ~/.cfops",
+		EnvVar: "CFOPS_STATELESS",
+	},
+	cli.StringFlag{
+		Name:   resultFile,
+		Usage:  "path of a json file to write the exit code and outcome of the run to",
+		EnvVar: "CFOPS_RESULT_FILE",
+	},
+	cli.StringFlag{
+		Name:   pksDeploy,
+		Usage:  "the pks control plane deployment the pks tile backs up and restores (the only pivotal-container-service- deployment of the director when omitted)",
+		EnvVar: "CFOPS_PKS_DEPLOYMENT",
+	},
+	cli.StringFlag{
+		Name:   pksClusters,
+		Usage:  "a csv list of the cluster deployments the pks tile snapshots the etcd of (every service-instance_ deployment of the director when omitted)",
+		EnvVar: "CFOPS_PKS_CLUSTERS",
+	},
+)
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	foundation       string = "foundation"
+	foundationConfig string = "foundationconfig"
+)
+
+var foundationFlags = withFlags(nil,
+	cli.StringFlag{
+		Name:   foundation,
+		Usage:  "take the settings not given as flags from this foundation of the --foundationconfig, e.g. prod",
+		EnvVar: "CFOPS_FOUNDATION",
+	},
+)
+
+// useFoundation fills the connection settings given neither as flags, in the
+// environment nor in secret files from the --foundation profile
+func (s *flagSet) useFoundation(c *cli.Context) (err error) {
+	var profile cfops.FoundationProfile
+
+	if profile, err = foundationProfile(c); err != nil {
+		return
+	}
+	settings := []struct {
+		value   string
+		setting *string
+	}{
+		{profile.Host, &s.host},
+		{profile.AdminUser, &s.adminUser},
+		{profile.AdminPass, &s.adminPass},
+		{profile.OpsManagerUser, &s.opsManagerUser},
+		{profile.OpsManagerPass, &s.opsManagerPass},
+		{profile.Destination, &s.dest},
+		{profile.Tilelist, &s.tilelist},
+	}
+
+	for _, setting := range settings {
+		if *setting.setting == "" {
+			*setting.setting = setting.value
+		}
+	}
+	return
+}
+
+// foundationProfile is the profile of the --foundation, an empty one when
+// there is none
+func foundationProfile(c *cli.Context) (profile cfops.FoundationProfile, err error) {
+	var config cfops.FoundationConfig
+
+	if c.String(foundation) == "" {
+		return
+	}
+
+	if config, err = cfops.LoadFoundationConfig(foundationConfigPath(c)); err == nil {
+		profile, err = config.Profile(c.String(foundation))
+	}
+	return
+}
+
+// foundationConfigPath is the --foundationconfig, ~/.cfops/foundations.yml
+// when omitted
+func foundationConfigPath(c *cli.Context) string {
+	if configPath := globalString(c, foundationConfig); configPath != "" {
+		return configPath
+	}
+	return path.Join(cfopsHome(), "foundations.yml")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/codegangsta/cli"
@@ -8,7 +9,17 @@ import (
 )
 
 const (
-	logLevelEnv = "LOG_LEVEL"
+	logLevelEnv    = "LOG_LEVEL"
+	logLevelFlag   = "logLevel"
+	logFormat      = "logformat"
+	syslogAddress  = "syslog"
+	syslogFacility = "syslogfacility"
+	syslogCA       = "syslogca"
+	logFile        = "logfile"
+	logMaxSize     = "logmaxsize"
+	logRotate      = "logrotate"
+	logKeep        = "logkeep"
+	noColor        = "no-color"
 )
 
 var (
@@ -24,7 +35,12 @@ func init() {
 
 func main() {
 	app := NewApp()
-	app.Run(os.Args)
+
+	// flags cfops does not know given before the command fail to parse
+	if err := app.Run(os.Args); err != nil && ExitCode == cleanExitCode {
+		fmt.Fprintln(os.Stderr, err)
+		ExitCode = helpExitCode
+	}
 	os.Exit(ExitCode)
 }
 
@@ -34,23 +50,39 @@ func NewApp() *cli.App {
 	app.Version = VERSION
 	app.Name = "cfops"
 	app.Usage = "Cloud Foundry Operations Tool"
-	app.Flags = append(app.Flags,
-		cli.StringFlag{
-			Name:   "logLevel",
-			Value:  "info",
-			Usage:  "set the log level by setting the LOG_LEVEL environment variable",
-			EnvVar: "LOG_LEVEL",
-		},
-	)
+	app.Flags = append(app.Flags, globalFlags...)
+	app.Before = func(c *cli.Context) (err error) {
+		if err = checkCommandLine(c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = helpExitCode
+		}
+		return
+	}
 	app.Commands = append(app.Commands, []cli.Command{
 		cli.Command{
-			Name: "version",
+			Name:  "version",
+			Usage: "print the version of cfops",
 			Action: func(c *cli.Context) {
 				cli.ShowVersion(c)
 			},
 		},
 		backupCli,
 		restoreCli,
+		verifyCli,
+		auditCli,
+		scheduleCli,
+		reportCli,
+		statusCli,
+		resumeCli,
+		convertCli,
+		unsealCli,
+		certsCli,
+		binlogsCli,
+		sftpServerCli,
 	}...)
+
+	for i := range app.Commands {
+		app.Commands[i] = withGlobalFlags(app.Commands[i])
+	}
 	return app
 }
@@ -1,13 +1,63 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/codegangsta/cli"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/op/go-logging"
+	"github.com/pivotalservices/cfops"
+	"github.com/xchapter7x/lo"
 )
 
+// stdoutOf runs the app with the args, returning what it printed to stdout
+func stdoutOf(app *cli.App, args ...string) string {
+	reader, writer, _ := os.Pipe()
+	printed := make(chan []byte)
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	go func() {
+		out, _ := ioutil.ReadAll(reader)
+		printed <- out
+	}()
+	app.Run(args)
+	os.Stdout = stdout
+	writer.Close()
+	return string(<-printed)
+}
+
+// passwordsFD opens the file on a descriptor of its own for --pass-fd, which
+// cfops closes once read. The descriptor of an *os.File would be closed again
+// when it is collected, along with whatever file reused it meanwhile
+func passwordsFD(passPath string) string {
+	file, _ := os.Open(passPath)
+	defer file.Close()
+	fd, _ := syscall.Dup(int(file.Fd()))
+	return strconv.Itoa(fd)
+}
+
 var _ = Describe("NewApp", func() {
+	var home string
+
+	BeforeEach(func() {
+		home = os.Getenv("HOME")
+	})
+
+	AfterEach(func() {
+		os.Setenv("HOME", home)
+	})
+
 	Describe("`cfops backup` command", func() {
 		runTestSuiteFor("backup")
 	})
@@ -15,6 +65,345 @@ var _ = Describe("NewApp", func() {
 	Describe("`cfops restore` caommand", func() {
 		runTestSuiteFor("restore")
 	})
+
+	Describe("an unknown command", func() {
+		It("Should show help and suggest the command that was meant", func() {
+			ExitCode = cleanExitCode
+			err := NewApp().Run([]string{"cfops", "bakup"})
+			Ω(err).Should(MatchError("cfops has no command bakup (did you mean backup?), see 'cfops help'"))
+			Ω(ExitCode).Should(Equal(helpExitCode))
+		})
+	})
+
+	Describe("the global flags", func() {
+		var level logging.Level
+
+		BeforeEach(func() {
+			ExitCode = cleanExitCode
+			level = logging.GetLevel(lo.LOG_MODULE)
+		})
+
+		AfterEach(func() {
+			logging.SetLevel(level, lo.LOG_MODULE)
+		})
+
+		It("Should apply the log level given before or after the command", func() {
+			NewApp().Run([]string{"cfops", "--logLevel", "error", "version"})
+			Ω(logging.GetLevel(lo.LOG_MODULE)).Should(Equal(logging.ERROR))
+			NewApp().Run([]string{"cfops", "version", "--logLevel", "debug"})
+			Ω(logging.GetLevel(lo.LOG_MODULE)).Should(Equal(logging.DEBUG))
+			Ω(ExitCode).Should(Equal(cleanExitCode))
+		})
+
+		It("Should fail on an unknown log level", func() {
+			NewApp().Run([]string{"cfops", "version", "--logLevel", "verbose"})
+			Ω(ExitCode).Should(Equal(errExitCode))
+		})
+
+		It("Should read the foundation config given before the command", func() {
+			dir, _ := ioutil.TempDir("", "foundations")
+			defer os.RemoveAll(dir)
+			configPath := path.Join(dir, "foundations.yml")
+			ioutil.WriteFile(configPath, []byte("foundations:\n  prod:\n    destination: "+dir+"\n"), 0600)
+			NewApp().Run([]string{"cfops", "--foundationconfig", configPath, "status", "--foundation", "prod"})
+			Ω(ExitCode).Should(Equal(cleanExitCode))
+		})
+	})
+
+	Describe("`cfops status` and `cfops resume` commands", func() {
+		var (
+			app  = NewApp()
+			dest string
+		)
+
+		BeforeEach(func() {
+			home, _ := ioutil.TempDir("", "home")
+			os.Setenv("HOME", home)
+			dest = path.Join(home, "backup")
+			os.MkdirAll(dest, 0755)
+			ExitCode = cleanExitCode
+			app = NewApp()
+		})
+
+		Context("When given neither a foundation nor a destination", func() {
+			It("Should show help", func() {
+				app.Run([]string{"cfops", "status"})
+				Ω(ExitCode).Should(Equal(helpExitCode))
+			})
+		})
+
+		Context("When no run left a checkpoint", func() {
+			It("Should say so", func() {
+				out := stdoutOf(app, "cfops", "status", "-d", dest)
+				Ω(ExitCode).Should(Equal(cleanExitCode))
+				Ω(out).Should(Equal(fmt.Sprintf(errNothingToResumeFormat+"\n", dest)))
+			})
+
+			It("Should have nothing to resume", func() {
+				app.Run([]string{"cfops", "resume", "-d", dest, "--adminuser", "<usr>", "--adminpass", "<pass>", "--opsmanageruser", "<opsuser>", "--opsmanagerpass", "<opspass>"})
+				Ω(ExitCode).Should(Equal(errExitCode))
+			})
+		})
+
+		Context("When an interrupted backup left a checkpoint", func() {
+			BeforeEach(func() {
+				checkpoint, _ := cfops.OpenBackupCheckpoint(dest, "<host>")
+				checkpoint.Action, checkpoint.Destination, checkpoint.Tilelist = cfops.Backup, dest, "opsmanager,er"
+				checkpoint.MarkCompleted(cfops.OpsMgr)
+			})
+
+			It("Should show it", func() {
+				out := stdoutOf(app, "cfops", "status", "-d", dest)
+				Ω(ExitCode).Should(Equal(cleanExitCode))
+				Ω(out).Should(ContainSubstring("backup of <host>, " + dest))
+				Ω(out).Should(ContainSubstring("completed: " + cfops.OpsMgr))
+			})
+
+			It("Should show it as json", func() {
+				var checkpoint cfops.RestoreCheckpoint
+				out := stdoutOf(app, "cfops", "status", "-d", dest, "--json")
+				Ω(ExitCode).Should(Equal(cleanExitCode))
+				Ω(json.Unmarshal([]byte(out), &checkpoint)).Should(BeNil())
+				Ω(checkpoint.Foundation).Should(Equal("<host>"))
+				Ω(checkpoint.Completed(cfops.OpsMgr)).Should(BeTrue())
+			})
+
+			It("Should resume it, skipping the tiles already backed up", func() {
+				logPath := path.Join(dest, "..", "cfops.log")
+				app.Run([]string{"cfops", "resume", "-d", dest, "--adminuser", "<usr>", "--adminpass", "<pass>", "--opsmanageruser", "<opsuser>", "--opsmanagerpass", "<opspass>", "--logfile", logPath})
+				cfops.ConfigureLogging(cfops.LogFormatText, os.Stderr)
+				Ω(ExitCode).ShouldNot(Equal(helpExitCode))
+				logged, _ := ioutil.ReadFile(logPath)
+				Ω(string(logged)).Should(ContainSubstring("Skipping completed step " + cfops.OpsMgr))
+			})
+		})
+	})
+
+	Describe("`cfops verify` command", func() {
+		var app = NewApp()
+
+		BeforeEach(func() {
+			ExitCode = cleanExitCode
+			app = NewApp()
+		})
+
+		Context("When missing a destination", func() {
+			It("Should show help", func() {
+				app.Run([]string{"cfops", "verify", "--deep"})
+				Ω(ExitCode).Should(Equal(helpExitCode))
+			})
+		})
+
+		Context("When the destination holds no backup", func() {
+			It("Should exit with an error", func() {
+				app.Run([]string{"cfops", "verify", "-d", "/does/not/exist"})
+				Ω(ExitCode).Should(Equal(errExitCode))
+			})
+		})
+	})
+
+	Describe("`cfops schedule` command", func() {
+		var (
+			app        = NewApp()
+			configPath string
+		)
+
+		BeforeEach(func() {
+			home, _ := ioutil.TempDir("", "home")
+			os.Setenv("HOME", home)
+			configPath = path.Join(home, "schedule.yml")
+			ExitCode = cleanExitCode
+			app = NewApp()
+		})
+
+		Context("When the config does not exist", func() {
+			It("Should exit with an error", func() {
+				app.Run([]string{"cfops", "schedule", "--config", configPath})
+				Ω(ExitCode).Should(Equal(errExitCode))
+			})
+		})
+
+		Context("When a schedule has an invalid cron expression", func() {
+			It("Should exit with an error", func() {
+				ioutil.WriteFile(configPath, []byte("schedules:\n- name: nightly\n  cron: 0 25 * * *\n"), 0600)
+				app.Run([]string{"cfops", "schedule", "--config", configPath})
+				Ω(ExitCode).Should(Equal(errExitCode))
+			})
+		})
+
+		Context("When a schedule is missing credentials", func() {
+			It("Should show help", func() {
+				ioutil.WriteFile(configPath, []byte("schedules:\n- name: nightly\n  cron: '@daily'\n  destination: /backups\n"), 0600)
+				app.Run([]string{"cfops", "schedule", "--config", configPath})
+				Ω(ExitCode).Should(Equal(helpExitCode))
+			})
+		})
+
+		Context("When the api is served without tls", func() {
+			config := cfops.APIConfig{Tokens: []cfops.APIToken{{Name: "ci", Token: "secret", Role: cfops.RoleOperator}}}
+
+			It("Should only take bearer tokens on a loopback address", func() {
+				Ω(checkPlainAPI(":8080", "", config)).Should(MatchError(fmt.Sprintf(errPlainTokensFormat, ":8080")))
+				Ω(checkPlainAPI("10.0.0.5:8080", "", config)).ShouldNot(BeNil())
+				Ω(checkPlainAPI("127.0.0.1:8080", "", config)).Should(BeNil())
+				Ω(checkPlainAPI("[::1]:8080", "", config)).Should(BeNil())
+				Ω(checkPlainAPI("localhost:8080", "", config)).Should(BeNil())
+			})
+
+			It("Should refuse to redirect to https", func() {
+				Ω(checkPlainAPI("127.0.0.1:8080", ":80", config)).Should(Equal(errRedirectWithoutTLS))
+			})
+		})
+	})
+
+	Describe("`cfops sftp-server` command", func() {
+		Context("When no bucket is given", func() {
+			It("Should show help", func() {
+				ExitCode = cleanExitCode
+				NewApp().Run([]string{"cfops", "sftp-server", "--readonly"})
+				Ω(ExitCode).Should(Equal(helpExitCode))
+			})
+		})
+	})
+
+	Describe("`cfops certs-report` command", func() {
+		var (
+			server *httptest.Server
+			out    string
+		)
+
+		BeforeEach(func() {
+			ExitCode = cleanExitCode
+			home, _ := ioutil.TempDir("", "home")
+			os.Setenv("HOME", home)
+			out = path.Join(home, "certs.csv")
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"certificates":[{"product_guid":"cf-0123","location":"ops_manager","valid_until":%q}]}`, time.Now().Add(24*time.Hour).Format(time.RFC3339))
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		Context("When the ops manager credentials are missing", func() {
+			It("Should show help", func() {
+				NewApp().Run([]string{"cfops", "certs-report", "--opsmanagerhost", server.URL})
+				Ω(ExitCode).Should(Equal(helpExitCode))
+			})
+		})
+
+		Context("When a certificate expires within the window", func() {
+			It("Should write the report and exit with a warning", func() {
+				NewApp().Run([]string{"cfops", "certs-report", "--opsmanagerhost", server.URL, "--adminuser", "admin", "--adminpass", "pass", "--format", "csv", "-o", out})
+				Ω(ExitCode).Should(Equal(warnExitCode))
+				contents, _ := ioutil.ReadFile(out)
+				Ω(string(contents)).Should(ContainSubstring(",expiring"))
+			})
+
+			It("Should exit cleanly when the window is shorter", func() {
+				NewApp().Run([]string{"cfops", "certs-report", "--opsmanagerhost", server.URL, "--adminuser", "admin", "--adminpass", "pass", "--within", "1h", "-o", out})
+				Ω(ExitCode).Should(Equal(cleanExitCode))
+			})
+		})
+	})
+
+	Describe("`cfops backup --foundations` command", func() {
+		var (
+			app        = NewApp()
+			home       string
+			configPath string
+			resultPath string
+			osArgs     = os.Args
+		)
+
+		BeforeEach(func() {
+			home, _ = ioutil.TempDir("", "home")
+			os.Setenv("HOME", home)
+			configPath = path.Join(home, "foundations.yml")
+			resultPath = path.Join(home, "result.json")
+			ioutil.WriteFile(configPath, []byte("foundations:\n  prod:\n    opsmanagerhost: opsman.prod\n  staging:\n    opsmanagerhost: opsman.staging\n"), 0600)
+			// stands in for cfops, backing up prod and failing on staging
+			script := path.Join(home, "cfops")
+			ioutil.WriteFile(script, []byte(`#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --foundation) foundation=$2 ;;
+    --resultfile) result=$2 ;;
+  esac
+  shift
+done
+echo "backing up $foundation"
+if [ "$foundation" = staging ]; then
+  echo '{"exit_code": 1, "error": "could not reach ops manager"}' > "$result"
+  exit 1
+fi
+echo '{"exit_code": 0, "run": {"status": "complete", "destination": "/backups/prod"}}' > "$result"
+`), 0755)
+			cfopsExecutable = func() (string, error) { return script, nil }
+			ExitCode = cleanExitCode
+			app = NewApp()
+		})
+
+		AfterEach(func() {
+			cfopsExecutable = os.Executable
+			os.Args = osArgs
+			os.RemoveAll(home)
+		})
+
+		run := func(extra ...string) {
+			os.Args = append([]string{"cfops", "backup", "--foundationconfig", configPath, "--resultfile", resultPath}, extra...)
+			app.Run(os.Args)
+		}
+
+		Context("When every foundation is backed up", func() {
+			It("Should exit with the worst exit code and write the outcome of each", func() {
+				run("--foundations", "all", "--parallel", "2", "-d", "/backups/{{.Foundation}}")
+				Ω(ExitCode).Should(Equal(errExitCode))
+
+				var runs []cfops.FoundationRun
+				contents, _ := ioutil.ReadFile(resultPath)
+				Ω(json.Unmarshal(contents, &runs)).Should(BeNil())
+				Ω(runs).Should(HaveLen(2))
+				Ω(runs[0].Foundation).Should(Equal("prod"))
+				Ω(runs[0].ExitCode).Should(Equal(cleanExitCode))
+				Ω(runs[0].Run.Status).Should(Equal(cfops.SetComplete))
+				Ω(runs[1].Foundation).Should(Equal("staging"))
+				Ω(runs[1].Error).Should(Equal("could not reach ops manager"))
+			})
+		})
+
+		Context("When only some foundations are backed up", func() {
+			It("Should exit cleanly when they succeed", func() {
+				run("--foundations", "prod")
+				Ω(ExitCode).Should(Equal(cleanExitCode))
+			})
+		})
+
+		Context("When a foundation is not in the config", func() {
+			It("Should exit with an error", func() {
+				run("--foundations", "prod, dr")
+				Ω(ExitCode).Should(Equal(errExitCode))
+				Ω(resultPath).ShouldNot(BeAnExistingFile())
+			})
+		})
+
+		Context("When the foundations would share a destination", func() {
+			It("Should exit with an error", func() {
+				run("--foundations", "all", "-d", "/backups")
+				Ω(ExitCode).Should(Equal(errExitCode))
+				Ω(resultPath).ShouldNot(BeAnExistingFile())
+			})
+		})
+
+		Context("When the passwords are to be read from a file descriptor", func() {
+			It("Should exit with an error", func() {
+				run("--foundations", "all", "-d", "/backups/{{.Foundation}}", "--pass-fd", "3")
+				Ω(ExitCode).Should(Equal(errExitCode))
+				Ω(resultPath).ShouldNot(BeAnExistingFile())
+			})
+		})
+	})
 })
 
 func runTestSuiteFor(command string) {
@@ -27,8 +416,12 @@ func runTestSuiteFor(command string) {
 	)
 
 	BeforeEach(func() {
+		home, _ := ioutil.TempDir("", "home")
+		os.Setenv("HOME", home)
+		dir := path.Join(home, "backup")
 		ExitCode = cleanExitCode
 		app = NewApp()
+		secrets = &secretReader{fds: make(map[int][]byte)}
 		requiredArgs = []string{
 			"cfops",
 			command,
@@ -37,7 +430,7 @@ func runTestSuiteFor(command string) {
 			"--adminpass", "<pass>",
 			"--opsmanageruser", "<opsuser>",
 			"--opsmanagerpass", "<opspass>",
-			"-d", "<dir>",
+			"-d", dir,
 		}
 		allArgs = append(requiredArgs, "-tl", "'opsmanager, er'")
 		invalidArgs = append(requiredArgs, "--fakearg", "blah")
@@ -47,7 +440,7 @@ func runTestSuiteFor(command string) {
 			"--opsmanagerhost", "<host>",
 			"--adminuser", "<usr>",
 			"--opsmanagerpass", "<opspass>",
-			"-d", "<dir>",
+			"-d", dir,
 		}
 	})
 
@@ -60,11 +453,123 @@ func runTestSuiteFor(command string) {
 
 	Context("When given all available arguments", func() {
 		It("Should not throw an error", func() {
-			err := app.Run(missingRequiredArgs)
+			err := app.Run(allArgs)
 			Ω(err).Should(BeNil())
 		})
 	})
 
+	Context("When running stateless with a result file", func() {
+		It("Should keep its state in the destination and write the result", func() {
+			dir := requiredArgs[len(requiredArgs)-1]
+			resultPath := path.Join(dir, "..", "result.json")
+			app.Run(append(requiredArgs, "--stateless", "--resultfile", resultPath))
+			Ω(path.Join(dir, ".cfops", "catalog.json")).Should(BeAnExistingFile())
+			Ω(path.Join(dir, ".cfops", "audit.log")).Should(BeAnExistingFile())
+
+			var result cfops.RunResult
+			contents, _ := ioutil.ReadFile(resultPath)
+			Ω(json.Unmarshal(contents, &result)).Should(BeNil())
+			Ω(result.ExitCode).Should(Equal(ExitCode))
+			Ω(result.Run.Action).Should(Equal(command))
+		})
+	})
+
+	Context("When reading the passwords from files", func() {
+		It("Should not show help", func() {
+			dir := requiredArgs[len(requiredArgs)-1]
+			os.MkdirAll(dir, 0755)
+			adminPassPath, opsmgrPassPath := path.Join(dir, "..", "adminpass"), path.Join(dir, "..", "opsmgrpass")
+			ioutil.WriteFile(adminPassPath, []byte("<pass>\n"), 0600)
+			ioutil.WriteFile(opsmgrPassPath, []byte("<opspass>\n"), 0600)
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--admin-pass-file", adminPassPath, "--opsmgr-pass-file", opsmgrPassPath))
+			Ω(ExitCode).ShouldNot(Equal(helpExitCode))
+		})
+
+		It("Should show help, without reading stdin, when two secrets are read from it", func() {
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--admin-pass-file", "-", "--opsmgr-pass-file", "-"))
+			Ω(ExitCode).Should(Equal(helpExitCode))
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--pass-fd", "0", "--admin-pass-file", "-"))
+			Ω(ExitCode).Should(Equal(helpExitCode))
+		})
+	})
+
+	Context("When taking the settings of a foundation profile", func() {
+		var configPath string
+
+		BeforeEach(func() {
+			dir := requiredArgs[len(requiredArgs)-1]
+			os.MkdirAll(dir, 0755)
+			configPath = path.Join(dir, "..", "foundations.yml")
+			ioutil.WriteFile(configPath, []byte("defaults:\n  adminuser: <usr>\n  opsmanageruser: <opsuser>\nfoundations:\n  prod:\n    opsmanagerhost: <host>\n    destination: "+dir+"\n"), 0600)
+		})
+
+		It("Should run with the settings not given as flags", func() {
+			dir := requiredArgs[len(requiredArgs)-1]
+			app.Run([]string{"cfops", command, "--foundation", "prod", "--foundationconfig", configPath, "--adminpass", "<pass>", "--opsmanagerpass", "<opspass>", "--stateless"})
+			Ω(ExitCode).ShouldNot(Equal(helpExitCode))
+			Ω(path.Join(dir, ".cfops", "catalog.json")).Should(BeAnExistingFile())
+		})
+
+		It("Should show help when the foundation is unknown", func() {
+			app.Run([]string{"cfops", command, "--foundation", "dr", "--foundationconfig", configPath, "--adminpass", "<pass>", "--opsmanagerpass", "<opspass>"})
+			Ω(ExitCode).Should(Equal(helpExitCode))
+		})
+	})
+
+	Context("When reading the passwords from a file descriptor", func() {
+		It("Should not show help", func() {
+			passPath := path.Join(requiredArgs[len(requiredArgs)-1], "..", "passwords")
+			ioutil.WriteFile(passPath, []byte("# ops manager\nadminpass=<pass>\nopsmanagerpass=<opspass>\n"), 0600)
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--pass-fd", passwordsFD(passPath)))
+			Ω(ExitCode).ShouldNot(Equal(helpExitCode))
+		})
+
+		It("Should show help when a line names no password", func() {
+			passPath := path.Join(requiredArgs[len(requiredArgs)-1], "..", "passwords")
+			ioutil.WriteFile(passPath, []byte("adminpass=<pass>\nsecret\n"), 0600)
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--pass-fd", passwordsFD(passPath)))
+			Ω(ExitCode).Should(Equal(helpExitCode))
+		})
+	})
+
+	Context("When printing the admin password with a command", func() {
+		It("Should not show help", func() {
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--admin-pass-command", "echo '<pass>'"))
+			Ω(ExitCode).ShouldNot(Equal(helpExitCode))
+		})
+
+		It("Should show help when the command fails", func() {
+			app.Run(append(missingRequiredArgs, "--opsmanageruser", "<opsuser>", "--admin-pass-command", "exit 1"))
+			Ω(ExitCode).Should(Equal(helpExitCode))
+		})
+	})
+
+	Context("When ops manager refuses the admin password during the run", func() {
+		It("Should run the command again for the rotated password", func() {
+			fs := &flagSet{adminUser: "<usr>", adminPass: "<pass>", adminPassCmd: "echo '<rotated>'"}
+			user, pass, err := fs.Reauthenticate()
+			Ω(err).Should(BeNil())
+			Ω(user).Should(Equal("<usr>"))
+			Ω(pass).Should(Equal("<rotated>"))
+			Ω(fs.AdminPass()).Should(Equal("<rotated>"))
+		})
+
+		It("Should read the password file again", func() {
+			passPath := path.Join(requiredArgs[len(requiredArgs)-1], "..", "adminpass")
+			ioutil.WriteFile(passPath, []byte("<rotated>\n"), 0600)
+			fs := &flagSet{adminUser: "<usr>", adminPass: "<pass>", adminPassFile: passPath}
+			_, pass, err := fs.Reauthenticate()
+			Ω(err).Should(BeNil())
+			Ω(pass).Should(Equal("<rotated>"))
+		})
+
+		It("Should fail when the password was not rotated", func() {
+			fs := &flagSet{adminUser: "<usr>", adminPass: "<pass>", adminPassCmd: "echo '<pass>'"}
+			_, _, err := fs.Reauthenticate()
+			Ω(err).Should(Equal(errCredentialsUnchanged))
+		})
+	})
+
 	Context("When given invalid arguments", func() {
 		It("Should throw an error", func() {
 			fmt.Println(invalidArgs)
@@ -73,6 +578,40 @@ func runTestSuiteFor(command string) {
 		})
 	})
 
+	Context("When given a misspelt flag", func() {
+		It("Should show help and suggest the flag that was meant", func() {
+			err := app.Run(append(requiredArgs, "--adminpas", "<pass>"))
+			Ω(err).Should(MatchError("cfops " + command + " has no flag --adminpas (did you mean --adminpass?), see 'cfops " + command + " --help'"))
+			Ω(ExitCode).Should(Equal(helpExitCode))
+		})
+	})
+
+	Context("When given a global flag after the command", func() {
+		It("Should read it as if it came before", func() {
+			app.Run(append(requiredArgs, "--logformat", "xml"))
+			Ω(ExitCode).Should(Equal(errExitCode))
+		})
+	})
+
+	Context("When writing logs to a file", func() {
+		AfterEach(func() {
+			cfops.ConfigureLogging(cfops.LogFormatText, os.Stderr)
+		})
+
+		It("Should write them there instead of to stderr", func() {
+			logPath := path.Join(requiredArgs[len(requiredArgs)-1], "..", "logs", "cfops.log")
+			app.Run(append(requiredArgs, "--logfile", logPath, "--logmaxsize", "10MB", "--logkeep", "3"))
+			Ω(ExitCode).ShouldNot(Equal(helpExitCode))
+			Ω(logPath).Should(BeAnExistingFile())
+		})
+
+		It("Should fail when the size to rotate at is not a size", func() {
+			logPath := path.Join(requiredArgs[len(requiredArgs)-1], "..", "cfops.log")
+			app.Run(append(requiredArgs, "--logfile", logPath, "--logmaxsize", "10MB/s"))
+			Ω(ExitCode).Should(Equal(errExitCode))
+		})
+	})
+
 	Context("When missing a required argument", func() {
 		It("Should throw an error", func() {
 			fmt.Println(missingRequiredArgs)
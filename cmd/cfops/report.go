@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	report_full_name  string = "report"
+	report_usage             = "[--catalog <path>] [--format json|csv] [--foundations] [-o <file>]"
+	report_descr             = "Render the backup catalog, the verification of each backup and the age of the last successful backup of each foundation as json or csv"
+	reportFormat             = "format"
+	reportFoundations        = "foundations"
+	reportOutput             = "output"
+)
+
+var reportCli = cli.Command{
+	Name:      report_full_name,
+	Usage:     report_descr,
+	ArgsUsage: report_usage,
+	Flags: []cli.Flag{
+		stringFlag(flagList[catalog]),
+		cli.StringFlag{
+			Name:  reportFormat,
+			Value: cfops.ReportJSON,
+			Usage: "json, or csv",
+		},
+		cli.BoolFlag{
+			Name:  reportFoundations,
+			Usage: "render one csv row per foundation rather than per run",
+		},
+		cli.StringFlag{
+			Name:  reportOutput + ", o",
+			Usage: "write the report to this file rather than stdout",
+		},
+	},
+	Action: func(c *cli.Context) {
+		var (
+			catalog *cfops.Catalog
+			out     io.Writer = os.Stdout
+			err     error
+		)
+
+		if catalog, err = cfops.OpenCatalog(catalogPath(c, cfopsHome())); err == nil {
+			if c.String(reportOutput) != "" {
+				var file *os.File
+
+				if file, err = os.Create(c.String(reportOutput)); err == nil {
+					defer file.Close()
+					out = file
+				}
+			}
+
+			if err == nil {
+				report := cfops.NewReport(catalog, time.Now())
+				err = cfops.WriteReport(out, report, c.String(reportFormat), c.Bool(reportFoundations))
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+		}
+	},
+}
@@ -2,49 +2,166 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/codegangsta/cli"
 	"github.com/pivotalservices/cfops"
 )
 
 const (
-	restore_full_name  string = "restore"
-	restore_short_name        = "r"
-	restore_usage             = "restore --opsmanagerhost <host> --adminuser <usr> --adminpass <pass> --opsmanageruser <opsuser> --opsmanagerpass <opspass> -d <dir> --tl 'opsmanager, er'"
-	restore_descr             = "Restore a Cloud Foundry deployment, including Ops Manager configuration, databases, and blob store"
+	restore_full_name      string = "restore"
+	restore_short_name            = "r"
+	restore_usage                 = "--opsmanagerhost <host> --adminuser <usr> --adminpass <pass> --opsmanageruser <opsuser> --opsmanagerpass <opspass> (-d <dir> | --latest) --tl 'opsmanager, er' [--components 'ccdb']"
+	restore_descr                 = "Restore a Cloud Foundry deployment, including Ops Manager configuration, databases, and blob store"
+	defaultRestoreTilelist        = "opsmanager, er"
 )
 
 var restoreCli = cli.Command{
-	Name:        restore_full_name,
-	ShortName:   restore_short_name,
-	Usage:       restore_usage,
-	Description: restore_descr,
-	Flags:       backupRestoreFlags,
+	Name:      restore_full_name,
+	ShortName: restore_short_name,
+	Usage:     restore_descr,
+	ArgsUsage: restore_usage,
+	Flags: withFlags(append(backupRestoreFlags, stringFlags(smokeFlagList)...),
+		cli.BoolFlag{
+			Name:  latest,
+			Usage: "restore the most recent complete backup recorded in the catalog instead of --destination",
+		},
+		cli.StringFlag{
+			Name:   components,
+			Usage:  "a csv list of elastic runtime components to restore, e.g. 'ccdb, uaadb' (all when omitted)",
+			EnvVar: "CFOPS_COMPONENTS",
+		},
+		cli.BoolFlag{
+			Name:  plan,
+			Usage: "print the ordered list of what the restore would do to the foundation, e.g. for change approval, and restore nothing",
+		},
+		cli.BoolFlag{
+			Name:  restart,
+			Usage: "ignore the steps an interrupted restore of this backup already completed and start over",
+		},
+		cli.StringFlag{
+			Name:   bbrArtifact,
+			Usage:  "a bosh-backup-restore backup directory of the elastic runtime to import the database dumps and blobstore of into --destination first",
+			EnvVar: "CFOPS_BBR_ARTIFACT",
+		},
+		cli.StringFlag{
+			Name:   remap,
+			Usage:  "a yaml file mapping the system and apps domains, networks and static ips of the backed up foundation to those of the one restored to",
+			EnvVar: "CFOPS_REMAP",
+		},
+		cli.StringFlag{
+			Name:   blobstore,
+			Usage:  "a csv list of the blobstore categories to restore, e.g. 'droplets, buildpacks', of droplets, packages, buildpacks and resources (all when omitted)",
+			EnvVar: "CFOPS_BLOBSTORE",
+		},
+		cli.StringFlag{
+			Name:   targetVersion,
+			Usage:  "the elastic runtime version of the foundation restored to, e.g. 1.6.0, which the backup is migrated to (asked of ops manager when omitted)",
+			EnvVar: "CFOPS_TARGET_VERSION",
+		},
+		cli.StringFlag{
+			Name:   restoreRate,
+			Usage:  "the most bytes a second each store is restored at, e.g. 50MB, to spare the nfs server and databases of a live foundation (unlimited when omitted)",
+			EnvVar: "CFOPS_RESTORE_RATE",
+		},
+		cli.IntFlag{
+			Name:   restoreConc,
+			Value:  1,
+			Usage:  "how many elastic runtime stores are restored at once",
+			EnvVar: "CFOPS_RESTORE_CONCURRENCY",
+		},
+		cli.BoolFlag{
+			Name:   adaptiveConc,
+			Usage:  "start at half of --restoreconcurrency, or of every store when it is not given, and adapt how many stores are restored at once to the throughput they reach",
+			EnvVar: "CFOPS_ADAPTIVE_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   extractConc,
+			Usage:  "how many artifacts are extracted from an archive or imported from a --bbr backup at once (one for each cpu when omitted)",
+			EnvVar: "CFOPS_EXTRACT_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   transferSegs,
+			Value:  1,
+			Usage:  "how many byte ranges of an archive of 128MB or more are uploaded at once, each on an sftp connection of its own, to fill a long and fat network",
+			EnvVar: "CFOPS_TRANSFER_SEGMENTS",
+		},
+		cli.StringFlag{
+			Name:   pointInTime,
+			Usage:  "replay the --binlogs into the mysql server once the restore completes, up to this time (RFC3339, e.g. 2017-03-02T14:30:00Z)",
+			EnvVar: "CFOPS_POINT_IN_TIME",
+		},
+		cli.BoolFlag{
+			Name:   applyChanges,
+			Usage:  "start apply changes on ops manager once the restore completes, and wait for it to finish",
+			EnvVar: "CFOPS_APPLY_CHANGES",
+		},
+		cli.StringFlag{
+			Name:   applyProducts,
+			Usage:  "a csv list of the guids of the products --applychanges deploys, where ops manager supports it (all when omitted)",
+			EnvVar: "CFOPS_APPLY_CHANGES_PRODUCTS",
+		},
+		cli.DurationFlag{
+			Name:   applyTimeout,
+			Value:  cfops.DefaultApplyChangesTimeout,
+			Usage:  "how long to wait for --applychanges to finish",
+			EnvVar: "CFOPS_APPLY_CHANGES_TIMEOUT",
+		},
+		cli.BoolFlag{
+			Name:   healthCheck,
+			Usage:  "end the restore with bosh cloud-check in report mode and a check of every instance, failing when the foundation is not healthy",
+			EnvVar: "CFOPS_HEALTH_CHECK",
+		},
+		cli.StringFlag{
+			Name:   healthDeploys,
+			Usage:  "a csv list of the deployments --healthcheck checks (all deployments of the director when omitted)",
+			EnvVar: "CFOPS_HEALTH_DEPLOYMENTS",
+		},
+		cli.BoolFlag{
+			Name:   smokeTests,
+			Usage:  "end the restore by logging in to the restored foundation, and pushing the --smokeapp and downloading a droplet when given, failing when any of it fails",
+			EnvVar: "CFOPS_SMOKE_TESTS",
+		},
+		cli.StringFlag{
+			Name:   smokeTestList,
+			Usage:  "a csv list of the --smoketests to run after logging in, of push and droplet (those whose app is given when omitted)",
+			EnvVar: "CFOPS_SMOKE_TEST_LIST",
+		},
+		cli.BoolFlag{
+			Name:   smokeSkipSSL,
+			Usage:  "do not validate the certificate of the --smokeapi",
+			EnvVar: "CFOPS_SMOKE_SKIP_SSL_VALIDATION",
+		},
+		cli.StringFlag{
+			Name:   resticSnapshot,
+			Usage:  "the snapshot of the --restic repository to restore into --destination first (the latest of the foundation when omitted)",
+			EnvVar: "CFOPS_RESTIC_SNAPSHOT",
+		},
+	),
 	Action: func(c *cli.Context) {
 		var (
 			err error
-			fs  = &flagSet{
-				host:           c.String(flagList[opsManagerHost].Flag[0]),
-				adminUser:      c.String(flagList[adminUser].Flag[0]),
-				adminPass:      c.String(flagList[adminPass].Flag[0]),
-				opsManagerUser: c.String(flagList[opsManagerUser].Flag[0]),
-				opsManagerPass: c.String(flagList[opsManagerPass].Flag[0]),
-				dest:           c.String(flagList[dest].Flag[0]),
-				tilelist:       c.String(flagList[tilelist].Flag[0]),
-			}
+			fs  = newFlagSet(c)
 		)
 
-		if hasValidBackupRestoreFlags(fs) {
-			cfops.SetupSupportedTiles(fs)
-			err = cfops.RunPipeline(fs, cfops.Restore)
-
-			if err != nil {
+		if c.Bool(latest) {
+			if fs.dest, err = cfops.LatestBackup(fs.Catalog()); err != nil {
 				fmt.Println(err)
 				ExitCode = errExitCode
-
-			} else {
-				fmt.Println(restore_full_name, " completed successfully.")
+				return
 			}
+		}
+
+		if fs.tilelist == "" {
+			// restores always run tile by tile so each step can be checkpointed
+			fs.tilelist = defaultRestoreTilelist
+		}
+
+		if hasValidBackupRestoreFlags(fs) && c.Bool(plan) {
+			printPlan(c, fs)
+
+		} else if hasValidBackupRestoreFlags(fs) {
+			runPipeline(c, fs, cfops.Restore, restore_full_name)
 
 		} else {
 			cli.ShowCommandHelp(c, restore_full_name)
@@ -52,3 +169,21 @@ var restoreCli = cli.Command{
 		}
 	},
 }
+
+// printPlan prints what the restore would do, as text or, with --json, as
+// json, without restoring anything
+func printPlan(c *cli.Context, fs *flagSet) {
+	restorePlan, err := cfops.PlanRestore(fs)
+
+	switch {
+	case err != nil:
+		fmt.Println(err)
+		ExitCode = errExitCode
+
+	case globalBool(c, jsonOutput):
+		cfops.WritePlanJSON(os.Stdout, restorePlan)
+
+	default:
+		cfops.WritePlan(os.Stdout, restorePlan)
+	}
+}
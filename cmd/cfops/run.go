@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+// runPipeline runs the action and reports its progress and outcome, as status
+// lines on stdout or, with --json, as the run output on stdout and the status
+// lines on stderr
+func runPipeline(c *cli.Context, fs *flagSet, action, commandName string) {
+	out := os.Stdout
+
+	if globalBool(c, jsonOutput) {
+		out = os.Stderr
+	}
+	console := cfops.NewConsole(out, !globalBool(c, noColor) && cfops.ColorSupported(out))
+
+	if err := console.SetProgress(c.String(progress)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		ExitCode = errExitCode
+		return
+	}
+	cfops.SetupSupportedTiles(fs)
+	ctx, stop := cfops.WatchSignals(abortExitCode)
+	unfollow := console.Follow()
+	entry, err := cfops.RunPipelineResult(ctx, fs, action)
+	unfollow()
+	stop()
+
+	if globalBool(c, jsonOutput) {
+		cfops.WriteRunOutput(os.Stdout, entry)
+	}
+
+	if err == cfops.ErrAborted {
+		console.Status(cfops.StatusWarn, "%s", err)
+		ExitCode = abortExitCode
+
+	} else if err != nil {
+		console.Status(cfops.StatusFail, "%s", err)
+		ExitCode = errExitCode
+
+	} else if entry.Status == cfops.SetPartial {
+		console.Status(cfops.StatusWarn, "%s completed without %s, which could not be reached.", commandName, strings.Join(entry.Unreachable(), ", "))
+		ExitCode = warnExitCode
+
+	} else {
+		console.Status(cfops.StatusOK, "%s completed successfully.", commandName)
+	}
+
+	if c.String(resultFile) != "" {
+		if resultErr := cfops.WriteResultFile(c.String(resultFile), entry, ExitCode, err); resultErr != nil {
+			fmt.Fprintln(os.Stderr, resultErr)
+		}
+	}
+}
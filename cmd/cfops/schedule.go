@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	schedule_full_name string = "schedule"
+	schedule_usage            = "[--config <path>] [backup flags shared by every schedule]"
+	schedule_descr            = "Run the backups of the schedule config at the times of their cron expressions until interrupted"
+	scheduleConfig            = "config"
+	schedulerState            = "state"
+	listen                    = "listen"
+	tlsCert                   = "tlscert"
+	tlsKey                    = "tlskey"
+	tlsSelfSigned             = "tlsselfsigned"
+	tlsClientCA               = "tlsclientca"
+	redirectFrom              = "redirectfrom"
+
+	errPlainTokensFormat = "the api on %s would take bearer tokens over plain http, serve it over tls with --tlscert or --tlsselfsigned, or listen on a loopback address such as 127.0.0.1:8080"
+)
+
+var errRedirectWithoutTLS = errors.New("--redirectfrom redirects to the https api, serve the api over tls with --tlscert or --tlsselfsigned to use it")
+
+var scheduleCli = cli.Command{
+	Name:      schedule_full_name,
+	Usage:     schedule_descr,
+	ArgsUsage: schedule_usage,
+	Flags: withFlags(backupFlags,
+		cli.StringFlag{
+			Name:   scheduleConfig,
+			Usage:  "path of the yaml schedule config (defaults to ~/.cfops/schedule.yml)",
+			EnvVar: "CFOPS_SCHEDULE_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   schedulerState,
+			Usage:  "path of the file keeping the queue and history of runs across restarts (defaults to ~/.cfops/scheduler.json)",
+			EnvVar: "CFOPS_SCHEDULER_STATE",
+		},
+		cli.StringFlag{
+			Name:   listen,
+			Usage:  "address to serve the api on, e.g. :8080, for the callers in the api section of the config (no api when omitted)",
+			EnvVar: "CFOPS_LISTEN",
+		},
+		cli.StringFlag{
+			Name:   tlsCert,
+			Usage:  "pem certificate to serve the api over tls with",
+			EnvVar: "CFOPS_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   tlsKey,
+			Usage:  "pem key of the api certificate",
+			EnvVar: "CFOPS_TLS_KEY",
+		},
+		cli.BoolFlag{
+			Name:  tlsSelfSigned,
+			Usage: "serve the api over tls with a self signed certificate generated at start up (labs only)",
+		},
+		cli.StringFlag{
+			Name:   tlsClientCA,
+			Usage:  "pem file of the certificate authorities whose client certificates identify api callers",
+			EnvVar: "CFOPS_TLS_CLIENT_CA",
+		},
+		cli.StringFlag{
+			Name:   redirectFrom,
+			Usage:  "address to redirect plain http requests to the https api from, e.g. :80",
+			EnvVar: "CFOPS_REDIRECT_FROM",
+		},
+	),
+	Action: func(c *cli.Context) {
+		var (
+			err    error
+			config cfops.ScheduleConfig
+			jobs   []cfops.ScheduledJob
+			state  *cfops.SchedulerState
+		)
+		configPath := c.String(scheduleConfig)
+
+		if configPath == "" {
+			configPath = path.Join(cfopsHome(), "schedule.yml")
+		}
+
+		if config, err = cfops.LoadScheduleConfig(configPath); err == nil {
+			jobs, err = config.Jobs()
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			ExitCode = errExitCode
+			return
+		}
+
+		for _, job := range jobs {
+			fs, err := scheduledFlagSet(c, job, time.Now())
+
+			if err != nil {
+				fmt.Printf("schedule %s: %s\n", job.Entry.Name, err)
+				ExitCode = errExitCode
+				return
+			}
+
+			if !hasValidBackupRestoreFlags(fs) {
+				fmt.Printf("schedule %s is missing settings\n", job.Entry.Name)
+				ExitCode = helpExitCode
+				return
+			}
+		}
+
+		statePath := c.String(schedulerState)
+
+		if statePath == "" {
+			statePath = path.Join(cfopsHome(), "scheduler.json")
+		}
+
+		if state, err = cfops.OpenSchedulerState(statePath); err != nil {
+			fmt.Println(err)
+			ExitCode = errExitCode
+			return
+		}
+		scheduler := cfops.NewScheduler(jobs, state, func(ctx context.Context, job cfops.ScheduledJob) error {
+			fs, err := scheduledFlagSet(c, job, time.Now())
+
+			if err != nil {
+				return err
+			}
+			cfops.SetupSupportedTiles(fs)
+			return cfops.RunPipelineContext(ctx, fs, cfops.Backup)
+		})
+
+		if c.String(listen) != "" {
+			var stopAPI func()
+
+			if stopAPI, err = serveAPI(c, scheduler, config.API); err != nil {
+				fmt.Println(err)
+				ExitCode = errExitCode
+				return
+			}
+			defer stopAPI()
+		}
+		ctx, stop := cfops.WatchSignals(abortExitCode)
+		scheduler.Run(ctx)
+		stop()
+	},
+}
+
+// scheduledFlagSet is the flags of the daemon overridden by the settings of
+// the schedule, backing up into a directory of its own for the run
+func scheduledFlagSet(c *cli.Context, job cfops.ScheduledJob, started time.Time) (fs *flagSet, err error) {
+	fs = newFlagSet(c)
+	entry := job.Entry
+	overrides := []struct {
+		value string
+		flag  *string
+	}{
+		{entry.Host, &fs.host},
+		{entry.AdminUser, &fs.adminUser},
+		{entry.AdminPass, &fs.adminPass},
+		{entry.OpsManagerUser, &fs.opsManagerUser},
+		{entry.OpsManagerPass, &fs.opsManagerPass},
+		{entry.Destination, &fs.dest},
+		{entry.Tilelist, &fs.tilelist},
+	}
+
+	for _, override := range overrides {
+		if override.value != "" {
+			*override.flag = override.value
+		}
+	}
+	job.Entry.Destination = fs.dest
+
+	if fs.runID = cfops.NewRunID(); fs.dest != "" {
+		fs.dest, err = job.RunDestination(fs.host, fs.runID, started)
+	}
+	return
+}
+
+// serveAPI starts serving the api of the daemon, over tls when a certificate
+// was given, along with a listener redirecting plain http to it
+func serveAPI(c *cli.Context, scheduler *cfops.Scheduler, config cfops.APIConfig) (stop func(), err error) {
+	var (
+		listener  net.Listener
+		tlsConfig *tls.Config
+		servers   []*http.Server
+		settings  = cfops.APITLS{
+			CertFile:     c.String(tlsCert),
+			KeyFile:      c.String(tlsKey),
+			SelfSigned:   c.Bool(tlsSelfSigned),
+			ClientCAFile: c.String(tlsClientCA),
+		}
+	)
+
+	if err = config.Validate(); err != nil {
+		return
+	}
+
+	if settings.Enabled() {
+		if tlsConfig, err = settings.Config(); err != nil {
+			return
+		}
+	} else if err = checkPlainAPI(c.String(listen), c.String(redirectFrom), config); err != nil {
+		return
+	}
+
+	if listener, err = net.Listen("tcp", c.String(listen)); err != nil {
+		return
+	}
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	servers = append(servers, &http.Server{Handler: cfops.NewAPIHandler(scheduler, config)})
+	go servers[0].Serve(listener)
+
+	if c.String(redirectFrom) != "" {
+		if listener, err = net.Listen("tcp", c.String(redirectFrom)); err != nil {
+			servers[0].Close()
+			return
+		}
+		servers = append(servers, &http.Server{Handler: cfops.RedirectToHTTPS(c.String(listen))})
+		go servers[1].Serve(listener)
+	}
+
+	return func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}, nil
+}
+
+// checkPlainAPI refuses to serve the api without tls when it would redirect
+// to https, or take bearer tokens anywhere but on a loopback address
+func checkPlainAPI(address, redirect string, config cfops.APIConfig) error {
+	if redirect != "" {
+		return errRedirectWithoutTLS
+	}
+
+	if len(config.Tokens) > 0 && !loopback(address) {
+		return fmt.Errorf(errPlainTokensFormat, address)
+	}
+	return nil
+}
+
+// loopback tells whether the address only listens on the loopback interface
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	adminPassFile  string = "admin-pass-file"
+	opsmgrPassFile string = "opsmgr-pass-file"
+	passFD         string = "pass-fd"
+	adminPassCmd   string = "admin-pass-command"
+	// stdinPath reads a secret from stdin rather than a file
+	stdinPath = "-"
+
+	errSecretLineFormat    = "line %d of --%s is not a name=value line"
+	errUnknownSecretFormat = "--%s has no password flag named %s"
+	errReadSecretFormat    = "unable to read the secret of --%s: %s"
+	errSecretCommandFormat = "--%s failed: %s"
+	errSharedSecretFormat  = "--%s and --%s both read file descriptor %d, which can only be read once"
+)
+
+var (
+	errNoCredentialSource   = errors.New("the --adminpass can only be resolved again from --admin-pass-command, an --admin-pass-file or a terminal")
+	errCredentialsUnchanged = errors.New("the --adminpass resolved again is the one ops manager refused")
+)
+
+var secretFlags = withFlags(nil,
+	cli.StringFlag{
+		Name:  adminPassFile,
+		Usage: "read the --adminpass from this file, or from stdin when it is -",
+	},
+	cli.StringFlag{
+		Name:  opsmgrPassFile,
+		Usage: "read the --opsmanagerpass from this file, or from stdin when it is -",
+	},
+	cli.IntFlag{
+		Name:  passFD,
+		Value: -1,
+		Usage: "read passwords from this file descriptor (0 for stdin) as lines such as adminpass=... and opsmanagerpass=..., named after their flags",
+	},
+	cli.StringFlag{
+		Name:   adminPassCmd,
+		Usage:  "print the --adminpass with this shell command, e.g. 'vault kv get -field=password secret/opsman', run again whenever ops manager refuses it",
+		EnvVar: "CFOPS_ADMIN_PASS_COMMAND",
+	},
+)
+
+// secrets reads the file descriptors secrets are passed on once for the life
+// of cfops, as the scheduler asks for them again on every run
+var secrets = &secretReader{fds: make(map[int][]byte)}
+
+// secretReader reads secrets from files and file descriptors, reading each
+// file descriptor, stdin included, no more than once
+type secretReader struct {
+	mutex sync.Mutex
+	fds   map[int][]byte
+}
+
+func (s *secretReader) readFD(fd int) (contents []byte, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	contents, read := s.fds[fd]
+
+	if read {
+		return
+	}
+	file := os.Stdin
+
+	if fd != 0 {
+		file = os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+		defer file.Close()
+	}
+
+	if contents, err = ioutil.ReadAll(file); err == nil {
+		s.fds[fd] = contents
+	}
+	return
+}
+
+func (s *secretReader) readFile(name string) ([]byte, error) {
+	if name == stdinPath {
+		return s.readFD(0)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// checkSecretSources refuses more than one secret flag reading the same file
+// descriptor, as the first to read it would leave the others nothing
+func checkSecretSources(c *cli.Context) error {
+	readers := make(map[int]string)
+
+	for _, flag := range []string{passFD, adminPassFile, opsmgrPassFile} {
+		fd := -1
+
+		if flag == passFD {
+			fd = c.Int(passFD)
+
+		} else if c.String(flag) == stdinPath {
+			fd = 0
+		}
+
+		if fd < 0 {
+			continue
+		}
+
+		if other, shared := readers[fd]; shared {
+			return fmt.Errorf(errSharedSecretFormat, other, flag, fd)
+		}
+		readers[fd] = flag
+	}
+	return nil
+}
+
+// readSecrets fills the passwords not given on the command line or in the
+// environment from the files and the file descriptor they were asked to be
+// read from, so that they never show up in argv or the environment
+func (s *flagSet) readSecrets(c *cli.Context) (err error) {
+	var (
+		passwords = map[string]*string{
+			flagList[adminPass].Flag[0]:            &s.adminPass,
+			flagList[opsManagerPass].Flag[0]:       &s.opsManagerPass,
+			smtpFlagList[smtpPass].Flag[0]:         &s.smtp.Pass,
+			registryFlagList[registryPass].Flag[0]: &s.registry.Pass,
+			binlogFlagList[binlogPass].Flag[0]:     &s.binlogs.Pass,
+			smokeFlagList[smokePass].Flag[0]:       &s.smokeTests.Pass,
+		}
+	)
+
+	if err = checkSecretSources(c); err != nil {
+		return
+	}
+
+	if fd := c.Int(passFD); fd >= 0 {
+		var contents []byte
+
+		if contents, err = secrets.readFD(fd); err != nil {
+			return fmt.Errorf(errReadSecretFormat, passFD, err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+		for number := 1; scanner.Scan(); number++ {
+			line := strings.TrimRight(scanner.Text(), "\r")
+
+			if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			parts := strings.SplitN(line, "=", 2)
+
+			if len(parts) != 2 {
+				// the line may well be a password, so it is not repeated
+				return fmt.Errorf(errSecretLineFormat, number, passFD)
+			}
+			name := strings.TrimSpace(parts[0])
+			password, known := passwords[name]
+
+			if !known {
+				return fmt.Errorf(errUnknownSecretFormat, passFD, name)
+			}
+
+			if *password == "" {
+				*password = parts[1]
+			}
+		}
+	}
+
+	s.adminPassFile, s.adminPassCmd = c.String(adminPassFile), c.String(adminPassCmd)
+
+	if s.adminPassCmd != "" && s.adminPass == "" {
+		if s.adminPass, err = runSecretCommand(s.adminPassCmd); err != nil {
+			return
+		}
+	}
+
+	for flag, password := range map[string]*string{adminPassFile: &s.adminPass, opsmgrPassFile: &s.opsManagerPass} {
+		if c.String(flag) == "" || *password != "" {
+			continue
+		}
+		var contents []byte
+
+		if contents, err = secrets.readFile(c.String(flag)); err != nil {
+			return fmt.Errorf(errReadSecretFormat, flag, err)
+		}
+		*password = strings.TrimRight(string(contents), "\r\n")
+	}
+	return
+}
+
+// Reauthenticate resolves the --adminpass again once ops manager refuses it
+// during a run, as when it is rotated: from --admin-pass-command, from an
+// --admin-pass-file the rotation rewrote, or from whoever is at the terminal
+func (s *flagSet) Reauthenticate() (user, pass string, err error) {
+	s.credentials.Lock()
+	defer s.credentials.Unlock()
+	var contents []byte
+
+	switch {
+	case s.adminPassCmd != "":
+		pass, err = runSecretCommand(s.adminPassCmd)
+
+	case s.adminPassFile != "" && s.adminPassFile != stdinPath:
+		if contents, err = ioutil.ReadFile(s.adminPassFile); err == nil {
+			pass = strings.TrimRight(string(contents), "\r\n")
+		}
+
+	case cfops.IsTerminal(os.Stdin):
+		fmt.Fprintf(os.Stderr, "ops manager %s refused the password of %s, enter it again: ", s.host, s.adminUser)
+		contents, err = terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		pass = string(contents)
+
+	default:
+		err = errNoCredentialSource
+	}
+
+	if err == nil && pass == s.adminPass {
+		err = errCredentialsUnchanged
+	}
+
+	if err == nil {
+		s.adminPass = pass
+	}
+	return s.adminUser, s.adminPass, err
+}
+
+// runSecretCommand prints a secret with the shell command, such as one asking
+// vault or credhub for it
+func runSecretCommand(command string) (secret string, err error) {
+	var output []byte
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+
+	if output, err = cmd.Output(); err != nil {
+		return "", fmt.Errorf(errSecretCommandFormat, adminPassCmd, err)
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
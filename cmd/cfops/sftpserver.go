@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+	"github.com/pkg/sftp"
+)
+
+const (
+	sftpserver_full_name string = "sftp-server"
+	sftpserver_usage            = "--bucket <bucket> [--prefix <key>] [--region <region>] [--endpoint <url>] [--readonly]"
+	sftpserver_descr            = "Serve the backups kept in an s3 bucket over sftp on stdin and stdout, as the sftp subsystem of an ssh server"
+	s3Bucket                    = "bucket"
+	s3Prefix                    = "prefix"
+	s3Region                    = "region"
+	s3Endpoint                  = "endpoint"
+	sftpReadOnly                = "readonly"
+)
+
+var sftpServerCli = cli.Command{
+	Name:      sftpserver_full_name,
+	Usage:     sftpserver_descr,
+	ArgsUsage: sftpserver_usage,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   s3Bucket,
+			Usage:  "the bucket to serve",
+			EnvVar: "CFOPS_S3_BUCKET",
+		},
+		cli.StringFlag{
+			Name:   s3Prefix,
+			Usage:  "the key of the directory of the bucket served as the root, e.g. backups",
+			EnvVar: "CFOPS_S3_PREFIX",
+		},
+		cli.StringFlag{
+			Name:   s3Region,
+			Usage:  "the region of the bucket (defaults to AWS_REGION)",
+			EnvVar: "CFOPS_S3_REGION",
+		},
+		cli.StringFlag{
+			Name:   s3Endpoint,
+			Usage:  "the url of an s3 compatible store to use instead of aws, e.g. minio",
+			EnvVar: "CFOPS_S3_ENDPOINT",
+		},
+		cli.BoolFlag{
+			Name:  sftpReadOnly,
+			Usage: "refuse every write, for tooling that only pulls backups",
+		},
+	},
+	Action: func(c *cli.Context) {
+		var (
+			fs     *cfops.S3FS
+			server *sftp.Server
+			err    error
+		)
+
+		if c.String(s3Bucket) == "" {
+			cli.ShowCommandHelp(c, sftpserver_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+		config := cfops.S3Config{
+			Bucket:   c.String(s3Bucket),
+			Prefix:   c.String(s3Prefix),
+			Region:   c.String(s3Region),
+			Endpoint: c.String(s3Endpoint),
+		}
+
+		if fs, err = cfops.NewS3FS(config); err == nil {
+			server, err = sftp.NewServer(os.Stdin, os.Stdout, ioutil.Discard, 0, c.Bool(sftpReadOnly), "/", sftp.ServeFS(fs), sftp.ConfineToRoot())
+		}
+
+		if err == nil {
+			err = server.Serve()
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+		}
+	},
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	status_full_name string = "status"
+	status_usage            = "(--opsmanagerhost <host> | --foundation <name> | -d <dir>) [--json]"
+	status_descr            = "Show what the last run of a foundation, or into a destination, was doing when it was last seen, from its checkpoint"
+	resume_full_name string = "resume"
+	resume_usage            = "(--opsmanagerhost <host> | --foundation <name> | -d <dir>) --adminuser <usr> --adminpass <pass> --opsmanageruser <opsuser> --opsmanagerpass <opspass> [flags of the interrupted run]"
+	resume_descr            = "Continue the interrupted backup or restore of a foundation, or into a destination, from its checkpoint"
+
+	errNothingToResumeFormat = "no interrupted run of %s left a checkpoint"
+)
+
+var statusCli = cli.Command{
+	Name:      status_full_name,
+	Usage:     status_descr,
+	ArgsUsage: status_usage,
+	Flags: withFlags(foundationFlags,
+		stringFlag(flagList[opsManagerHost]),
+		stringFlag(flagList[dest]),
+	),
+	Action: func(c *cli.Context) {
+		var (
+			checkpoint *cfops.RestoreCheckpoint
+			profile    cfops.FoundationProfile
+			err        error
+		)
+
+		if profile, err = foundationProfile(c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+			return
+		}
+		host, destination := c.String(flagList[opsManagerHost].Flag[0]), c.String(flagList[dest].Flag[0])
+
+		if host == "" {
+			host = profile.Host
+		}
+
+		if destination == "" && !cfops.IsDestinationTemplate(profile.Destination) {
+			destination = profile.Destination
+		}
+
+		if host == "" && destination == "" {
+			cli.ShowCommandHelp(c, status_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+
+		if checkpoint, err = cfops.FindCheckpoint(path.Join(cfopsHome(), "checkpoints"), host, destination); err == nil {
+			if checkpoint == nil {
+				fmt.Printf(errNothingToResumeFormat+"\n", runTarget(host, destination))
+				return
+			}
+			err = cfops.WriteStatus(os.Stdout, checkpoint, time.Now(), globalBool(c, jsonOutput))
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+		}
+	},
+}
+
+var resumeCli = cli.Command{
+	Name:      resume_full_name,
+	Usage:     resume_descr,
+	ArgsUsage: resume_usage,
+	Flags:     mergeFlags(backupFlags, restoreCli.Flags),
+	Action: func(c *cli.Context) {
+		var (
+			checkpoint *cfops.RestoreCheckpoint
+			err        error
+		)
+		fs := newFlagSet(c)
+
+		// the resolved destination is in the checkpoint
+		if cfops.IsDestinationTemplate(fs.dest) {
+			fs.dest = ""
+		}
+
+		if fs.host == "" && fs.dest == "" {
+			cli.ShowCommandHelp(c, resume_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+
+		if checkpoint, err = cfops.FindCheckpoint(fs.checkpointDir, fs.host, fs.dest); err == nil && checkpoint == nil {
+			err = fmt.Errorf(errNothingToResumeFormat, runTarget(fs.host, fs.dest))
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+			return
+		}
+		settings := []struct {
+			value   string
+			setting *string
+		}{
+			{checkpoint.Foundation, &fs.host},
+			{checkpoint.Destination, &fs.dest},
+			{checkpoint.Tilelist, &fs.tilelist},
+			{checkpoint.Components, &fs.components},
+		}
+
+		for _, setting := range settings {
+			if *setting.setting == "" {
+				*setting.setting = setting.value
+			}
+		}
+		fs.restart = false
+
+		if hasValidBackupRestoreFlags(fs) {
+			runPipeline(c, fs, checkpoint.Action, checkpoint.Action)
+
+		} else {
+			cli.ShowCommandHelp(c, resume_full_name)
+			ExitCode = helpExitCode
+		}
+	},
+}
+
+// runTarget names the foundation, or else the destination, of a run
+func runTarget(host, destination string) string {
+	if host != "" {
+		return host
+	}
+	return destination
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	unseal_full_name string = "unseal"
+	unseal_usage            = "--manifestkey <key> <deployment>.yml.sealed"
+	unseal_descr            = "Print a deployment manifest a backup sealed with its secrets"
+)
+
+var unsealCli = cli.Command{
+	Name:      unseal_full_name,
+	Usage:     unseal_descr,
+	ArgsUsage: unseal_usage,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   manifestKey,
+			Usage:  "the key the manifest was sealed with",
+			EnvVar: "CFOPS_MANIFEST_KEY",
+		},
+	},
+	Action: func(c *cli.Context) {
+		if c.String(manifestKey) == "" || len(c.Args()) != 1 {
+			cli.ShowCommandHelp(c, unseal_full_name)
+			ExitCode = helpExitCode
+			return
+		}
+		manifest, err := cfops.UnsealDeploymentManifest(c.Args()[0], c.String(manifestKey))
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ExitCode = errExitCode
+			return
+		}
+		os.Stdout.Write(manifest)
+	},
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codegangsta/cli"
+	"github.com/pivotalservices/cfops"
+)
+
+const (
+	verify_full_name  string = "verify"
+	verify_short_name        = "v"
+	verify_usage             = "-d <dir|archive.tar|url> [--tl 'opsmanager, er'] [--deep [--scratchmysqlhost <host> --scratchmysqluser <usr> --scratchmysqlpass <pass>]]"
+	verify_descr             = "Verify a Cloud Foundry backup archive is complete, optionally restoring its database dumps into a disposable sandbox"
+)
+
+var verifyCli = cli.Command{
+	Name:      verify_full_name,
+	ShortName: verify_short_name,
+	Usage:     verify_descr,
+	ArgsUsage: verify_usage,
+	Flags: append(stringFlags(scratchFlagList),
+		stringFlag(flagList[dest]),
+		stringFlag(flagList[tilelist]),
+		stringFlag(flagList[catalog]),
+		cli.BoolFlag{
+			Name:  deep,
+			Usage: "restore database dumps into a disposable container (or the scratch MySQL server) and sanity check them",
+		},
+	),
+	Action: func(c *cli.Context) {
+		var (
+			err     error
+			scratch = cfops.ScratchMysql{
+				Host: c.String(scratchFlagList[scratchHost].Flag[0]),
+				Port: c.String(scratchFlagList[scratchPort].Flag[0]),
+				User: c.String(scratchFlagList[scratchUser].Flag[0]),
+				Pass: c.String(scratchFlagList[scratchPass].Flag[0]),
+			}
+			destination = c.String(flagList[dest].Flag[0])
+		)
+
+		if destination != "" {
+			err = cfops.RunVerify(destination, c.String(flagList[tilelist].Flag[0]), c.Bool(deep), cfops.NewSandboxFactory(scratch))
+			recordVerification(catalogPath(c, cfopsHome()), destination, c.Bool(deep), err)
+
+			if err != nil {
+				fmt.Println(err)
+				ExitCode = errExitCode
+
+			} else {
+				fmt.Println(verify_full_name, " completed successfully.")
+			}
+
+		} else {
+			cli.ShowCommandHelp(c, verify_full_name)
+			ExitCode = helpExitCode
+		}
+	},
+}
+
+// recordVerification keeps the outcome of verifying a backup in the catalog
+// entry of the backup, for reports. A backup the catalog does not know of is
+// left alone
+func recordVerification(catalogPath, destination string, deep bool, verifyErr error) {
+	catalog, err := cfops.OpenCatalog(catalogPath)
+
+	if err == nil && catalog.RecordVerification(destination, deep, verifyErr) {
+		err = catalog.Save()
+	}
+
+	if err != nil {
+		fmt.Println("unable to record the verification in the catalog:", err)
+	}
+}
@@ -0,0 +1,168 @@
+package cfops
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// NoColorEnv disables colored status lines whatever the terminal, see
+	// https://no-color.org
+	NoColorEnv = "NO_COLOR"
+
+	// the levels of a status line
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+
+	// ProgressPlain prints the progress of each transfer as a single line of
+	// key=value pairs
+	ProgressPlain = "plain"
+
+	ErrProgressModeFormat = "unknown progress mode %q, expected plain"
+
+	colorReset = "\x1b[0m"
+)
+
+var statusColors = map[string]string{
+	StatusOK:   "\x1b[32m",
+	StatusWarn: "\x1b[33m",
+	StatusFail: "\x1b[31m",
+}
+
+// Console writes a status line for each task and warning published while it
+// follows the events, colored by level when asked to, and the progress of the
+// transfers in the progress mode asked for
+type Console struct {
+	mutex    sync.Mutex
+	w        io.Writer
+	color    bool
+	progress string
+}
+
+func ErrProgressMode(mode string) error {
+	return fmt.Errorf(ErrProgressModeFormat, mode)
+}
+
+// NewConsole creates a console writing to w
+func NewConsole(w io.Writer, color bool) *Console {
+	return &Console{w: w, color: color}
+}
+
+// SetProgress sets how the console writes the progress of transfers: not at
+// all, the default, or as plain PROGRESS lines
+func (s *Console) SetProgress(mode string) error {
+	if mode != "" && mode != ProgressPlain {
+		return ErrProgressMode(mode)
+	}
+	s.progress = mode
+	return nil
+}
+
+// ColorSupported tells whether status lines written to the file can be
+// colored: it must be a terminal and NO_COLOR must not be set
+func ColorSupported(f *os.File) bool {
+	if _, set := os.LookupEnv(NoColorEnv); set || f == nil {
+		return false
+	}
+	return IsTerminal(f)
+}
+
+// IsTerminal tells whether the file is a terminal, and so has someone at it
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Status writes a line tagged with its level, green when it is ok, yellow
+// when it warns and red when it fails. Lines without a level are not tagged
+func (s *Console) Status(level, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case level == "":
+		fmt.Fprintf(s.w, "       %s\n", line)
+
+	case s.color:
+		fmt.Fprintf(s.w, "%s[%-4s]%s %s\n", statusColors[level], level, colorReset, line)
+
+	default:
+		fmt.Fprintf(s.w, "[%-4s] %s\n", level, line)
+	}
+}
+
+// Follow writes the status lines of the events published until stop is
+// called, which returns once the events already received are written
+func (s *Console) Follow() (stop func()) {
+	subscription, cancel := SubscribeEvents()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for event := range subscription {
+			s.event(event)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (s *Console) event(event Event) {
+	switch event.Type {
+	case EventTaskStarted:
+		s.Status("", "%s", event.Task)
+
+	case EventTaskFinished:
+		s.Status(StatusOK, "%s", event.Task)
+
+	case EventTaskFailed:
+		s.Status(StatusFail, "%s: %s", event.Task, event.Message)
+
+	case EventWarning:
+		s.Status(StatusWarn, "%s", event.Message)
+
+	case EventProgress:
+		if s.progress == ProgressPlain {
+			s.plainProgress(event)
+		}
+	}
+}
+
+// plainProgress writes the progress of a transfer as a single line such as
+// PROGRESS tile=er component=nfs_server phase=restore pct=42 bytes=..., with
+// the percentage only when the total of the transfer is known
+func (s *Console) plainProgress(event Event) {
+	var tile, component, phase string
+	parts := strings.SplitN(event.Task, stepSeparator, 3)
+	tile = strings.ToLower(parts[0])
+
+	if len(parts) > 1 {
+		component = parts[1]
+	}
+
+	if len(parts) > 2 {
+		phase = parts[2]
+	}
+	line := fmt.Sprintf("PROGRESS tile=%s component=%s phase=%s", tile, component, phase)
+
+	if event.Total > 0 {
+		line += fmt.Sprintf(" pct=%d", event.Bytes*100/event.Total)
+	}
+	line += fmt.Sprintf(" bytes=%d rate=%.0f", event.Bytes, event.Rate)
+
+	if event.Total > 0 {
+		line += fmt.Sprintf(" total=%d", event.Total)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintln(s.w, line)
+}
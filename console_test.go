@@ -0,0 +1,88 @@
+package cfops_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Console", func() {
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+	})
+
+	It("should write a status line for each task it follows", func() {
+		stop := NewConsole(out, false).Follow()
+		StartTask("ER").Finish(nil)
+		StartTask("ER/ccdb").Finish(errors.New("connection refused"))
+		stop()
+		Ω(out.String()).Should(Equal("       ER\n[ok  ] ER\n       ER/ccdb\n[fail] ER/ccdb: connection refused\n"))
+	})
+
+	It("should color the level of a status line", func() {
+		console := NewConsole(out, true)
+		console.Status(StatusOK, "backup completed successfully.")
+		console.Status(StatusWarn, "slow")
+		console.Status(StatusFail, "failed")
+		Ω(out.String()).Should(Equal("\x1b[32m[ok  ]\x1b[0m backup completed successfully.\n\x1b[33m[warn]\x1b[0m slow\n\x1b[31m[fail]\x1b[0m failed\n"))
+	})
+
+	It("should write nothing once it stops following", func() {
+		NewConsole(out, false).Follow()()
+		StartTask("ER").Finish(nil)
+		Ω(out.String()).Should(BeEmpty())
+	})
+
+	Describe("SetProgress", func() {
+		It("should write the progress of a transfer as plain lines", func() {
+			console := NewConsole(out, false)
+			Ω(console.SetProgress(ProgressPlain)).Should(Succeed())
+			stop := console.Follow()
+			heartbeat := StartHeartbeat("ER/nfs_server/restore", 4096, func() int64 { return 1024 }, 10*time.Millisecond)
+			time.Sleep(35 * time.Millisecond)
+			heartbeat()
+			stop()
+			Ω(out.String()).Should(MatchRegexp(`^PROGRESS tile=er component=nfs_server phase=restore pct=25 bytes=1024 rate=\d+ total=4096\n`))
+		})
+
+		It("should leave out the percentage of a transfer of unknown size", func() {
+			console := NewConsole(out, false)
+			Ω(console.SetProgress(ProgressPlain)).Should(Succeed())
+			stop := console.Follow()
+			heartbeat := StartHeartbeat("ER/ccdb/dump", 0, func() int64 { return 2048 }, 10*time.Millisecond)
+			time.Sleep(35 * time.Millisecond)
+			heartbeat()
+			stop()
+			Ω(out.String()).Should(MatchRegexp(`^PROGRESS tile=er component=ccdb phase=dump bytes=2048 rate=\d+\n`))
+		})
+
+		It("should refuse an unknown mode", func() {
+			Ω(NewConsole(out, false).SetProgress("fancy")).Should(Equal(ErrProgressMode("fancy")))
+		})
+	})
+
+	Describe("ColorSupported", func() {
+		It("should not color what is not a terminal", func() {
+			f, err := ioutil.TempFile("", "console")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove(f.Name())
+			defer f.Close()
+			Ω(ColorSupported(f)).Should(BeFalse())
+		})
+
+		It("should not color when NO_COLOR is set", func() {
+			os.Setenv(NoColorEnv, "")
+			defer os.Unsetenv(NoColorEnv)
+			Ω(ColorSupported(os.Stdout)).Should(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,104 @@
+package cfops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrNoArtifactsFormat  = "no backup artifacts found in %s"
+	ErrLegacyLayoutFormat = "%s holds a backup of an earlier cfops version, convert it with cfops convert -d %s first"
+)
+
+// ErrAlreadyCurrent is returned when converting a backup that has a manifest
+var ErrAlreadyCurrent = errors.New("the backup already has a manifest, there is nothing to convert")
+
+func ErrNoArtifacts(destination string) error {
+	return fmt.Errorf(ErrNoArtifactsFormat, destination)
+}
+
+func ErrLegacyLayout(destination string) error {
+	return fmt.Errorf(ErrLegacyLayoutFormat, destination, destination)
+}
+
+// legacyArtifacts maps where earlier cfops versions wrote an artifact to
+// where it is kept now: the elastic runtime dumps were written under
+// elasticruntime/ and the ops manager files next to them
+func legacyArtifacts() (paths map[string]string) {
+	paths = make(map[string]string)
+
+	for _, artifact := range BackupArtifacts[ER] {
+		paths[path.Join(cfbackup.ER_BACKUP_DIR, artifact)] = artifact
+	}
+
+	for _, artifact := range BackupArtifacts[OpsMgr] {
+		paths[path.Base(artifact)] = artifact
+	}
+	return
+}
+
+// IsLegacyLayout tells whether the destination holds artifacts where an
+// earlier cfops version wrote them and has no manifest
+func IsLegacyLayout(destination string) bool {
+	if _, err := os.Stat(path.Join(destination, ManifestName)); err == nil {
+		return false
+	}
+
+	for legacy := range legacyArtifacts() {
+		if _, err := os.Stat(path.Join(destination, legacy)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertLegacy moves the artifacts of a backup taken by an earlier cfops
+// version to where they are kept now and synthesizes the manifest the backup
+// never had. It returns the artifacts it moved
+func ConvertLegacy(destination string) (moved []string, err error) {
+	var manifest Manifest
+
+	if _, statErr := os.Stat(path.Join(destination, ManifestName)); statErr == nil {
+		return nil, ErrAlreadyCurrent
+	}
+
+	for legacy, current := range legacyArtifacts() {
+		from, to := path.Join(destination, legacy), path.Join(destination, current)
+
+		if _, statErr := os.Stat(from); statErr != nil {
+			continue
+		}
+
+		if _, statErr := os.Stat(to); statErr == nil {
+			warn("keeping %s, %s exists already", legacy, current)
+			continue
+		}
+		lo.G.Info("moving %s to %s", legacy, current)
+
+		if err = os.MkdirAll(path.Dir(to), 0700); err == nil {
+			err = os.Rename(from, to)
+		}
+
+		if err != nil {
+			return
+		}
+		moved = append(moved, current)
+	}
+	all := append(append([]string{}, BackupArtifacts[OpsMgr]...), BackupArtifacts[ER]...)
+
+	if manifest, err = NewManifest(destination, all); err != nil {
+		return
+	}
+
+	if len(manifest.Artifacts) == 0 {
+		return moved, ErrNoArtifacts(destination)
+	}
+	manifest.Synthesized = true
+	err = WriteManifest(destination, manifest)
+	return
+}
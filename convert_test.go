@@ -0,0 +1,108 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConvertLegacy", func() {
+	var (
+		dir    string
+		legacy []string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "convert")
+		legacy = nil
+
+		writeLegacy := func(name, artifact string) {
+			os.MkdirAll(path.Dir(path.Join(dir, name)), 0755)
+			ioutil.WriteFile(path.Join(dir, name), []byte(artifactContents(artifact)), 0644)
+			legacy = append(legacy, name)
+		}
+
+		for _, artifact := range BackupArtifacts[OpsMgr] {
+			writeLegacy(path.Base(artifact), artifact)
+		}
+
+		for _, artifact := range BackupArtifacts[ER] {
+			writeLegacy(path.Join("elasticruntime", artifact), artifact)
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should recognize the layout of an earlier cfops version", func() {
+		Ω(IsLegacyLayout(dir)).Should(BeTrue())
+	})
+
+	It("should move the artifacts to where they are kept now", func() {
+		moved, err := ConvertLegacy(dir)
+		Ω(err).Should(BeNil())
+		Ω(moved).Should(HaveLen(len(legacy)))
+		Ω(IsLegacyLayout(dir)).Should(BeFalse())
+		Ω(Verify(dir, []string{OpsMgr, ER})).Should(BeNil())
+	})
+
+	It("should synthesize a manifest describing every artifact", func() {
+		ConvertLegacy(dir)
+		manifest, err := LoadManifest(dir)
+		Ω(err).Should(BeNil())
+		Ω(manifest.Synthesized).Should(BeTrue())
+		Ω(manifest.FormatVersion).Should(Equal(ManifestFormatVersion))
+		Ω(manifest.Artifacts).Should(HaveLen(len(legacy)))
+		Ω(manifest.Artifacts[0].Name).Should(Equal(BackupArtifacts[OpsMgr][0]))
+		Ω(manifest.Artifacts[0].Size).Should(Equal(int64(len(artifactContents(BackupArtifacts[OpsMgr][0])))))
+		Ω(manifest.Artifacts[0].SHA256).Should(HaveLen(64))
+	})
+
+	It("should not convert a backup twice", func() {
+		ConvertLegacy(dir)
+		_, err := ConvertLegacy(dir)
+		Ω(err).Should(Equal(ErrAlreadyCurrent))
+	})
+
+	It("should fail on a destination without artifacts", func() {
+		empty, _ := ioutil.TempDir("", "convert")
+		defer os.RemoveAll(empty)
+		_, err := ConvertLegacy(empty)
+		Ω(err).Should(Equal(ErrNoArtifacts(empty)))
+	})
+
+	Describe("running a pipeline", func() {
+		BeforeEach(func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+				ER: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+		})
+
+		It("should refuse to restore a backup that was not converted", func() {
+			err := RunPipeline(&mockFlagSet{tileListFlag: "opsmanager, er", dest: dir}, Restore)
+			Ω(err).Should(Equal(ErrLegacyLayout(dir)))
+		})
+
+		It("should write the manifest of a backup that completed", func() {
+			current, _ := ioutil.TempDir("", "convert")
+			defer os.RemoveAll(current)
+			writeArtifacts(current, append(append([]string{}, BackupArtifacts[OpsMgr]...), BackupArtifacts[ER]...))
+			Ω(RunPipeline(&mockFlagSet{tileListFlag: "opsmanager, er", dest: current}, Backup)).Should(BeNil())
+			manifest, err := LoadManifest(current)
+			Ω(err).Should(BeNil())
+			Ω(manifest.Synthesized).Should(BeFalse())
+			Ω(manifest.Artifacts).ShouldNot(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package cfops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrInvalidCronFormat = "invalid cron expression %q: %s"
+	// cronHorizon bounds the search for the next time of a schedule that can
+	// never fire, such as the 30th of February
+	cronHorizon = 5 * 366 * 24 * time.Hour
+)
+
+var (
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	cronMonths   = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type (
+	// Schedule gives the next time something should run after a given time
+	Schedule interface {
+		Next(after time.Time) time.Time
+	}
+
+	// CronSchedule is a standard five field cron expression: minute, hour, day
+	// of month, month and day of week, evaluated in the location of the time
+	// passed to Next
+	CronSchedule struct {
+		minute, hour, dom, month, dow uint64
+		// domAny and dowAny record a * day field, since cron matches either
+		// day field when both are restricted but only the other when one is *
+		domAny, dowAny bool
+	}
+
+	cronField struct {
+		min, max int
+		names    []string
+	}
+)
+
+func ErrInvalidCron(expr, reason string) error {
+	return fmt.Errorf(ErrInvalidCronFormat, expr, reason)
+}
+
+// ParseCron parses a five field cron expression, or one of the @yearly,
+// @monthly, @weekly, @daily and @hourly descriptors. Fields accept *, lists,
+// ranges, steps and three letter month and weekday names
+func ParseCron(expr string) (schedule *CronSchedule, err error) {
+	spec := strings.TrimSpace(expr)
+
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+
+	if len(fields) != 5 {
+		return nil, ErrInvalidCron(expr, "expected 5 fields")
+	}
+	schedule = &CronSchedule{
+		domAny: fields[2] == "*" || fields[2] == "?",
+		dowAny: fields[4] == "*" || fields[4] == "?",
+	}
+	parsers := []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&schedule.minute, cronField{0, 59, nil}},
+		{&schedule.hour, cronField{0, 23, nil}},
+		{&schedule.dom, cronField{1, 31, nil}},
+		{&schedule.month, cronField{1, 12, cronMonths}},
+		{&schedule.dow, cronField{0, 7, cronWeekdays}},
+	}
+
+	for i, parser := range parsers {
+		if *parser.bits, err = parser.field.parse(fields[i]); err != nil {
+			return nil, ErrInvalidCron(expr, err.Error())
+		}
+	}
+
+	// 7 is sunday too
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	return
+}
+
+func (s cronField) parse(field string) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var (
+			low, high = s.min, s.max
+			step      = 1
+			span      = part
+		)
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			span = part[:i]
+
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		switch {
+		case span == "*" || span == "?":
+
+		case strings.Contains(span, "-"):
+			bounds := strings.SplitN(span, "-", 2)
+
+			if low, err = s.value(bounds[0]); err == nil {
+				high, err = s.value(bounds[1])
+			}
+
+		default:
+			if low, err = s.value(span); err == nil && !strings.Contains(part, "/") {
+				high = low
+			}
+		}
+
+		if err != nil {
+			return
+		}
+
+		if low > high {
+			return 0, fmt.Errorf("range %q runs backwards", part)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return
+}
+
+func (s cronField) value(text string) (v int, err error) {
+	for i, name := range s.names {
+		if name != "" && strings.EqualFold(text, name) {
+			return i, nil
+		}
+	}
+
+	if v, err = strconv.Atoi(text); err != nil || v < s.min || v > s.max {
+		return 0, fmt.Errorf("%q is not between %d and %d", text, s.min, s.max)
+	}
+	return
+}
+
+// Next is the first minute after the given time the schedule matches, or the
+// zero time when it never does
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	horizon := t.Add(cronHorizon)
+
+	for t.Before(horizon) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case s.domAny || s.dowAny:
+		return dom && dow
+
+	default:
+		return dom || dow
+	}
+}
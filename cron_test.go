@@ -0,0 +1,47 @@
+package cfops_test
+
+import (
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseCron", func() {
+	var from = time.Date(2026, time.October, 15, 14, 30, 0, 0, time.UTC)
+
+	next := func(expr string) time.Time {
+		schedule, err := ParseCron(expr)
+		Ω(err).Should(BeNil())
+		return schedule.Next(from)
+	}
+
+	It("should find the next matching minute", func() {
+		Ω(next("*/20 * * * *")).Should(Equal(time.Date(2026, time.October, 15, 14, 40, 0, 0, time.UTC)))
+		Ω(next("15 2 * * *")).Should(Equal(time.Date(2026, time.October, 16, 2, 15, 0, 0, time.UTC)))
+		Ω(next("@monthly")).Should(Equal(time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("should accept ranges and names", func() {
+		Ω(next("0 1 * jan-mar mon-fri")).Should(Equal(time.Date(2027, time.January, 1, 1, 0, 0, 0, time.UTC)))
+		Ω(next("0 3 * * sun")).Should(Equal(time.Date(2026, time.October, 18, 3, 0, 0, 0, time.UTC)))
+		Ω(next("0 3 * * 7")).Should(Equal(time.Date(2026, time.October, 18, 3, 0, 0, 0, time.UTC)))
+	})
+
+	It("should match either day field when both are restricted", func() {
+		Ω(next("0 0 20 * 5")).Should(Equal(time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("should never fire a schedule for a day that does not exist", func() {
+		Ω(next("0 0 30 2 *").IsZero()).Should(BeTrue())
+	})
+
+	It("should reject invalid expressions", func() {
+		for _, expr := range []string{"* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "0 0 * foo *"} {
+			_, err := ParseCron(expr)
+			Ω(err).ShouldNot(BeNil(), expr)
+		}
+	})
+})
@@ -0,0 +1,212 @@
+package cfops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/xchapter7x/lo"
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	// DeploymentManifestsDir is the directory of the destination holding the
+	// manifest of each deployment of the director, its secrets redacted
+	DeploymentManifestsDir = "deployments"
+	// SealedManifestExt is added to the name of a manifest sealed with its
+	// secrets, next to the redacted one
+	SealedManifestExt   = ".sealed"
+	ErrSealedShortMsg   = "the sealed file is too short to have been sealed"
+	redactedManifestVal = "((redacted))"
+)
+
+var ErrSealedShort = errors.New(ErrSealedShortMsg)
+
+// secretKey finds the properties of a manifest holding a secret, e.g.
+// admin_password, client_secret or ca.private_key
+var secretKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|private_key|credential)`)
+
+// DeploymentManifestsConfig describes the deployment manifests a backup
+// captures from the bosh director. The bosh cli reads the director and its
+// credentials from BOSH_ENVIRONMENT, BOSH_CLIENT, BOSH_CLIENT_SECRET and
+// BOSH_CA_CERT
+type DeploymentManifestsConfig struct {
+	Enabled bool
+	// Deployments are captured, every deployment of the director when empty
+	Deployments []string
+	// Key seals each manifest with its secrets next to the redacted one,
+	// which is all that is kept when it is empty
+	Key string
+	// Binary defaults to bosh on the path
+	Binary string
+}
+
+// CaptureDeploymentManifests writes the manifest of each deployment into the
+// deployments directory of the destination, its secrets redacted, and sealed
+// with the key when there is one. It returns the artifacts it wrote
+func CaptureDeploymentManifests(config DeploymentManifestsConfig, destination string) (artifacts []string, err error) {
+	var (
+		rows   []map[string]string
+		output boshOutput
+		aead   cipher.AEAD
+	)
+	deployments := config.Deployments
+
+	if config.Key != "" {
+		if aead, err = newAEAD(config.Key); err != nil {
+			return
+		}
+	}
+
+	if len(deployments) == 0 {
+		if rows, err = runBosh(config.Binary, "deployments"); err != nil {
+			return
+		}
+
+		for _, row := range rows {
+			deployments = append(deployments, row["name"])
+		}
+	}
+
+	if err = os.MkdirAll(path.Join(destination, DeploymentManifestsDir), 0700); err != nil {
+		return
+	}
+
+	for _, deployment := range deployments {
+		var redacted []byte
+		lo.G.Info("capturing the manifest of deployment %s", deployment)
+
+		if output, err = runBoshOutput(config.Binary, "-d", deployment, "manifest"); err != nil {
+			return
+		}
+		manifest := []byte(strings.Join(output.Blocks, ""))
+
+		if redacted, err = RedactManifest(manifest); err != nil {
+			return
+		}
+		artifact := path.Join(DeploymentManifestsDir, deployment+".yml")
+
+		if err = writeArtifact(destination, artifact, redacted); err != nil {
+			return
+		}
+		artifacts = append(artifacts, artifact)
+
+		if aead != nil {
+			var sealed []byte
+
+			if sealed, err = seal(aead, manifest, []byte(deployment)); err == nil {
+				err = writeArtifact(destination, artifact+SealedManifestExt, sealed)
+			}
+
+			if err != nil {
+				return
+			}
+			artifacts = append(artifacts, artifact+SealedManifestExt)
+		}
+	}
+	return
+}
+
+// RedactManifest replaces the value of every property of the manifest named
+// like a secret. Variables the director interpolates, e.g. ((admin_password)),
+// are no secret and are kept
+func RedactManifest(manifest []byte) (redacted []byte, err error) {
+	var document interface{}
+
+	if err = yaml.Unmarshal(manifest, &document); err != nil {
+		return
+	}
+	return yaml.Marshal(redactSecrets(document))
+}
+
+func redactSecrets(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		for key, child := range typed {
+			if name, ok := key.(string); ok && secretKey.MatchString(name) && !isManifestVariable(child) {
+				typed[key] = redactedManifestVal
+				continue
+			}
+			typed[key] = redactSecrets(child)
+		}
+
+	case []interface{}:
+		for i, child := range typed {
+			typed[i] = redactSecrets(child)
+		}
+	}
+	return value
+}
+
+func isManifestVariable(value interface{}) bool {
+	text, ok := value.(string)
+	return ok && strings.HasPrefix(text, "((") && strings.HasSuffix(text, "))")
+}
+
+// UnsealDeploymentManifest opens a manifest CaptureDeploymentManifests sealed
+// with the key
+func UnsealDeploymentManifest(sealedPath, key string) (manifest []byte, err error) {
+	var (
+		aead   cipher.AEAD
+		sealed []byte
+	)
+	deployment := strings.TrimSuffix(path.Base(sealedPath), ".yml"+SealedManifestExt)
+
+	if aead, err = newAEAD(key); err != nil {
+		return
+	}
+
+	if sealed, err = ioutil.ReadFile(sealedPath); err == nil {
+		manifest, err = unseal(aead, sealed, []byte(deployment))
+	}
+	return
+}
+
+// writeArtifact atomically writes the artifact into the destination
+func writeArtifact(destination, artifact string, contents []byte) (err error) {
+	tmp := path.Join(destination, artifact+".tmp")
+
+	if err = ioutil.WriteFile(tmp, contents, 0600); err == nil {
+		err = os.Rename(tmp, path.Join(destination, artifact))
+	}
+	return
+}
+
+// newAEAD is aes-gcm keyed with the sha256 of the key, whatever its length
+func newAEAD(key string) (aead cipher.AEAD, err error) {
+	var block cipher.Block
+	sum := sha256.Sum256([]byte(key))
+
+	if block, err = aes.NewCipher(sum[:]); err == nil {
+		aead, err = cipher.NewGCM(block)
+	}
+	return
+}
+
+// seal encrypts and authenticates the contents, bound to the additional
+// data, behind a random nonce
+func seal(aead cipher.AEAD, contents, additional []byte) (sealed []byte, err error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err = io.ReadFull(rand.Reader, nonce); err == nil {
+		sealed = aead.Seal(nonce, nonce, contents, additional)
+	}
+	return
+}
+
+func unseal(aead cipher.AEAD, sealed, additional []byte) (contents []byte, err error) {
+	size := aead.NonceSize()
+
+	if len(sealed) < size {
+		return nil, ErrSealedShort
+	}
+	return aead.Open(nil, sealed[:size], sealed[size:], additional)
+}
@@ -0,0 +1,91 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const deploymentManifest = `name: cf-0123
+properties:
+  uaa:
+    admin:
+      client_secret: s3cr3t
+    clients:
+    - name: login
+      secret: ((login_secret))
+  nats:
+    user: nats
+    password: n4ts
+instance_groups:
+- name: router
+  instances: 2
+`
+
+var _ = Describe("CaptureDeploymentManifests", func() {
+	var (
+		bin    string
+		dir    string
+		config DeploymentManifestsConfig
+	)
+
+	BeforeEach(func() {
+		bin, _ = ioutil.TempDir("", "bosh-bin")
+		dir, _ = ioutil.TempDir("", "manifests")
+		ioutil.WriteFile(path.Join(bin, "bosh"), []byte(fakeBosh), 0755)
+		ioutil.WriteFile(path.Join(bin, "deployments.json"), []byte(`{"Tables":[{"Rows":[{"name":"cf-0123"},{"name":"p-mysql-4567"}]}]}`), 0644)
+		blocks, _ := json.Marshal(map[string][]string{"Blocks": {deploymentManifest}})
+		ioutil.WriteFile(path.Join(bin, "manifest.json"), blocks, 0644)
+		config = DeploymentManifestsConfig{Enabled: true, Binary: path.Join(bin, "bosh")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bin)
+		os.RemoveAll(dir)
+	})
+
+	It("should write the manifest of every deployment with its secrets redacted", func() {
+		artifacts, err := CaptureDeploymentManifests(config, dir)
+		Ω(err).Should(BeNil())
+		Ω(artifacts).Should(Equal([]string{"deployments/cf-0123.yml", "deployments/p-mysql-4567.yml"}))
+
+		contents, _ := ioutil.ReadFile(path.Join(dir, "deployments", "cf-0123.yml"))
+		Ω(string(contents)).ShouldNot(ContainSubstring("s3cr3t"))
+		Ω(string(contents)).ShouldNot(ContainSubstring("n4ts"))
+		Ω(string(contents)).Should(ContainSubstring("((login_secret))"))
+		Ω(string(contents)).Should(ContainSubstring("instances: 2"))
+	})
+
+	It("should only capture the deployments it is given", func() {
+		config.Deployments = []string{"p-mysql-4567"}
+		artifacts, err := CaptureDeploymentManifests(config, dir)
+		Ω(err).Should(BeNil())
+		Ω(artifacts).Should(Equal([]string{"deployments/p-mysql-4567.yml"}))
+	})
+
+	It("should seal the manifest with its secrets next to the redacted one", func() {
+		config.Deployments, config.Key = []string{"cf-0123"}, "manifest-key"
+		artifacts, err := CaptureDeploymentManifests(config, dir)
+		Ω(err).Should(BeNil())
+		Ω(artifacts).Should(Equal([]string{"deployments/cf-0123.yml", "deployments/cf-0123.yml.sealed"}))
+
+		manifest, err := UnsealDeploymentManifest(path.Join(dir, "deployments", "cf-0123.yml.sealed"), "manifest-key")
+		Ω(err).Should(BeNil())
+		Ω(string(manifest)).Should(Equal(deploymentManifest))
+
+		_, err = UnsealDeploymentManifest(path.Join(dir, "deployments", "cf-0123.yml.sealed"), "another-key")
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should fail when the director can not be asked", func() {
+		os.Remove(path.Join(bin, "manifest.json"))
+		_, err := CaptureDeploymentManifests(config, dir)
+		Ω(err).ShouldNot(BeNil())
+	})
+})
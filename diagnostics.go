@@ -0,0 +1,86 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// DiagnosticsName is the diagnostic report of ops manager, the manifests
+	// of its deployed products and its certificates, captured next to the
+	// artifacts of a backup as a record of the versions and configuration
+	// backed up
+	DiagnosticsName           = "opsmanager-diagnostics.json"
+	diagnosticReportPath      = "/api/v0/diagnostic_report"
+	productManifestPathFormat = deployedProductsPath + "/%s/manifest"
+)
+
+// Diagnostics is what ops manager reported about the foundation when it was
+// backed up. Manifests are keyed by the guid of their product. Manifests and
+// certificates are left out for ops manager versions that can not report them
+type Diagnostics struct {
+	Captured     time.Time                  `json:"captured"`
+	Report       json.RawMessage            `json:"diagnostic_report,omitempty"`
+	Manifests    map[string]json.RawMessage `json:"manifests,omitempty"`
+	Certificates []Certificate              `json:"certificates,omitempty"`
+}
+
+// CaptureDiagnostics asks ops manager for its diagnostic report, the manifest
+// of each deployed product and the certificate inventory, and writes them
+// into the destination
+func CaptureDiagnostics(host, user, pass, destination string) (diagnostics Diagnostics, err error) {
+	var (
+		status   int
+		products []productVersion
+		contents []byte
+	)
+	client := &opsManagerClient{base: opsManagerBase(host), user: user, pass: pass}
+	diagnostics = Diagnostics{Captured: time.Now().UTC(), Manifests: make(map[string]json.RawMessage)}
+
+	if status, err = client.do("GET", diagnosticReportPath, nil, &diagnostics.Report); err != nil {
+		return
+	}
+
+	if status == http.StatusNotFound {
+		warn("ops manager %s has no diagnostic report", host)
+	}
+
+	if products, err = deployedProducts(host, user, pass); err != nil {
+		return
+	}
+
+	for _, product := range products {
+		var manifest json.RawMessage
+
+		if status, err = client.do("GET", fmt.Sprintf(productManifestPathFormat, product.Guid), nil, &manifest); err != nil {
+			return
+		}
+
+		if status != http.StatusNotFound {
+			diagnostics.Manifests[product.Guid] = manifest
+		}
+	}
+
+	if diagnostics.Certificates, err = DeployedCertificates(host, user, pass); err == ErrNoCertificateInventory {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if contents, err = json.MarshalIndent(diagnostics, "", "  "); err != nil {
+		return
+	}
+	tmp := path.Join(destination, DiagnosticsName+".tmp")
+
+	if err = ioutil.WriteFile(tmp, append(contents, '\n'), 0600); err == nil {
+		err = os.Rename(tmp, path.Join(destination, DiagnosticsName))
+	}
+	return
+}
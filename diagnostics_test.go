@@ -0,0 +1,114 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CaptureDiagnostics", func() {
+	var (
+		server   *httptest.Server
+		dir      string
+		hasV0    bool
+		requests []string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "diagnostics")
+		hasV0, requests = true, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+
+			switch r.URL.Path {
+			case "/api/installation_settings":
+				fmt.Fprint(w, `{"products":[{"guid":"cf-0123","identifier":"cf","product_version":"1.5.2.0"}]}`)
+
+			case "/api/v0/diagnostic_report":
+				if !hasV0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, `{"versions":{"release_version":"1.6.0"}}`)
+
+			case "/api/v0/deployed/products":
+				if !hasV0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, `[{"guid":"p-bosh-0456","type":"p-bosh"},{"guid":"cf-0123","type":"cf"}]`)
+
+			case "/api/v0/deployed/products/cf-0123/manifest":
+				if !hasV0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, `{"name":"cf-0123"}`)
+
+			case "/api/v0/deployed/products/p-bosh-0456/manifest":
+				fmt.Fprint(w, `{"name":"p-bosh-0456"}`)
+
+			case "/api/v0/deployed/certificates":
+				if !hasV0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, `{"certificates":[{"product_guid":"cf-0123","property_reference":".properties.networking_poe_ssl_certs","location":"ops_manager","valid_until":"2027-01-01T00:00:00Z"}]}`)
+
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	readDiagnostics := func() (diagnostics Diagnostics) {
+		contents, err := ioutil.ReadFile(path.Join(dir, DiagnosticsName))
+		Ω(err).Should(BeNil())
+		Ω(json.Unmarshal(contents, &diagnostics)).Should(BeNil())
+		return
+	}
+
+	It("should write the diagnostic report, the manifest of each deployed product and the certificates", func() {
+		_, err := CaptureDiagnostics(server.URL, "admin", "pass", dir)
+		Ω(err).Should(BeNil())
+		diagnostics := readDiagnostics()
+		Ω(diagnostics.Report).Should(MatchJSON(`{"versions":{"release_version":"1.6.0"}}`))
+		Ω(diagnostics.Manifests).Should(HaveLen(2))
+		Ω(diagnostics.Manifests["cf-0123"]).Should(MatchJSON(`{"name":"cf-0123"}`))
+		Ω(diagnostics.Manifests["p-bosh-0456"]).Should(MatchJSON(`{"name":"p-bosh-0456"}`))
+		Ω(diagnostics.Certificates).Should(HaveLen(1))
+		Ω(diagnostics.Certificates[0].Property).Should(Equal(".properties.networking_poe_ssl_certs"))
+		Ω(diagnostics.Captured.IsZero()).Should(BeFalse())
+	})
+
+	It("should leave out what ops manager versions without the api can not report", func() {
+		hasV0 = false
+		_, err := CaptureDiagnostics(server.URL, "admin", "pass", dir)
+		Ω(err).Should(BeNil())
+		diagnostics := readDiagnostics()
+		Ω(diagnostics.Report).Should(BeEmpty())
+		Ω(diagnostics.Manifests).Should(BeEmpty())
+		Ω(diagnostics.Certificates).Should(BeEmpty())
+		Ω(requests).Should(ContainElement("/api/installation_settings"))
+	})
+
+	It("should fail when ops manager fails", func() {
+		_, err := CaptureDiagnostics(server.URL+"/broken", "admin", "pass", dir)
+		Ω(err).ShouldNot(BeNil())
+		Ω(path.Join(dir, DiagnosticsName)).ShouldNot(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,150 @@
+package cfops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	ErrTruncatedDumpFormat    = "database dump %s is truncated, its completion marker is missing"
+	ErrDumpWithoutTableFormat = "database dump %s does not create any tables"
+	ErrDumpErrorBannerFormat  = "database dump %s contains an error: %s"
+)
+
+var (
+	// dumpCompletionMarkers are the last statements each dump tool writes
+	dumpCompletionMarkers = map[string]string{
+		MysqlEngine:    "-- Dump completed",
+		PostgresEngine: "-- PostgreSQL database dump complete",
+	}
+	// dumpErrorBanners start the lines the dump tools write when they fail part way
+	dumpErrorBanners = []string{
+		"mysqldump:",
+		"pg_dump:",
+		"ERROR:",
+		"FATAL:",
+	}
+	createTableStatement = []byte("CREATE TABLE")
+	dumpCommentLine      = []byte("--")
+)
+
+func ErrTruncatedDump(artifact string) error {
+	return fmt.Errorf(ErrTruncatedDumpFormat, artifact)
+}
+
+func ErrDumpWithoutTable(artifact string) error {
+	return fmt.Errorf(ErrDumpWithoutTableFormat, artifact)
+}
+
+func ErrDumpErrorBanner(artifact, banner string) error {
+	return fmt.Errorf(ErrDumpErrorBannerFormat, artifact, banner)
+}
+
+// ValidateDumps checks every database dump in the destination, or only the
+// dumps of the csv list of elastic runtime components when one is given
+func ValidateDumps(destination, components string) (err error) {
+	return validateDumps(dirSource(destination), components)
+}
+
+func validateDumps(source artifactSource, components string) (err error) {
+	for _, dump := range DatabaseDumps {
+		var contents io.ReadCloser
+
+		if !dumpSelected(dump, components) {
+			continue
+		}
+
+		if contents, err = source.openArtifact(dump.Artifact); err != nil {
+			break
+		}
+		err = validateDump(contents, source.location(dump.Artifact), dumpCompletionMarkers[dump.Engine])
+		contents.Close()
+
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func dumpSelected(dump DatabaseDump, components string) bool {
+	if components == "" {
+		return true
+	}
+
+	for _, component := range strings.Split(components, ",") {
+		if erArtifact(strings.ToLower(strings.TrimSpace(component))) == dump.Artifact {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDump fails a dump that is missing its completion marker, creates
+// no tables or contains an error banner from the dump tool
+func ValidateDump(artifactPath, engine string) (err error) {
+	var file *os.File
+
+	if file, err = os.Open(artifactPath); err == nil {
+		defer file.Close()
+		err = validateDump(file, artifactPath, dumpCompletionMarkers[engine])
+	}
+	return
+}
+
+func validateDump(dump io.Reader, artifact, completionMarker string) (err error) {
+	var (
+		line         []byte
+		isPrefix     bool
+		continuation bool
+		tables       int
+		lastLine     string
+		reader       = bufio.NewReader(dump)
+	)
+
+	for {
+		// long insert lines come back in fragments, only the first is of interest
+		if line, isPrefix, err = reader.ReadLine(); err != nil {
+			break
+		}
+		wasContinuation := continuation
+		continuation = isPrefix
+
+		if wasContinuation {
+			continue
+		}
+		trimmed := bytes.TrimSpace(line)
+
+		if bytes.HasPrefix(trimmed, createTableStatement) {
+			tables++
+		}
+
+		for _, banner := range dumpErrorBanners {
+			if bytes.HasPrefix(trimmed, []byte(banner)) {
+				return ErrDumpErrorBanner(artifact, string(trimmed))
+			}
+		}
+
+		if len(trimmed) > 0 && !bytes.Equal(trimmed, dumpCommentLine) {
+			lastLine = string(trimmed)
+		}
+	}
+
+	if err != io.EOF {
+		return
+	}
+	err = nil
+
+	switch {
+	case !strings.HasPrefix(lastLine, completionMarker):
+		err = ErrTruncatedDump(artifact)
+
+	case tables == 0:
+		err = ErrDumpWithoutTable(artifact)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateDump", func() {
+	var (
+		dir      string
+		dumpPath string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "dump")
+		dumpPath = path.Join(dir, "mysql.backup")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("when the dump is complete", func() {
+		It("should not return an error", func() {
+			ioutil.WriteFile(dumpPath, []byte(mysqlDump), 0644)
+			Ω(ValidateDump(dumpPath, MysqlEngine)).Should(BeNil())
+		})
+
+		It("should accept the trailing comments of a postgres dump", func() {
+			ioutil.WriteFile(dumpPath, []byte(postgresDump), 0644)
+			Ω(ValidateDump(dumpPath, PostgresEngine)).Should(BeNil())
+		})
+
+		It("should read past lines longer than the read buffer", func() {
+			long := "INSERT INTO users VALUES " + strings.Repeat("(1),", 100000) + "(1);\n"
+			ioutil.WriteFile(dumpPath, []byte(strings.Replace(mysqlDump, "INSERT INTO users VALUES (1);\n", long, 1)), 0644)
+			Ω(ValidateDump(dumpPath, MysqlEngine)).Should(BeNil())
+		})
+	})
+
+	Context("when the dump stops before its completion marker", func() {
+		It("should return a truncated dump error", func() {
+			ioutil.WriteFile(dumpPath, []byte(mysqlDump[:strings.Index(mysqlDump, "-- Dump completed")]), 0644)
+			Ω(ValidateDump(dumpPath, MysqlEngine)).Should(Equal(ErrTruncatedDump(dumpPath)))
+		})
+	})
+
+	Context("when the dump creates no tables", func() {
+		It("should return a dump without table error", func() {
+			ioutil.WriteFile(dumpPath, []byte("-- MySQL dump 10.13\n-- Dump completed on 2015-08-01 12:00:00\n"), 0644)
+			Ω(ValidateDump(dumpPath, MysqlEngine)).Should(Equal(ErrDumpWithoutTable(dumpPath)))
+		})
+	})
+
+	Context("when the dump tool wrote an error into the dump", func() {
+		It("should return an error banner error", func() {
+			banner := "mysqldump: Got error: 2013: Lost connection to MySQL server"
+			ioutil.WriteFile(dumpPath, []byte(banner+"\n"+mysqlDump), 0644)
+			Ω(ValidateDump(dumpPath, MysqlEngine)).Should(Equal(ErrDumpErrorBanner(dumpPath, banner)))
+		})
+	})
+
+	Describe("ValidateDumps", func() {
+		BeforeEach(func() {
+			writeArtifacts(dir, BackupArtifacts[ER])
+			os.Truncate(path.Join(dir, DatabaseDumps[0].Artifact), 10)
+		})
+
+		It("should check every dump by default", func() {
+			Ω(ValidateDumps(dir, "")).Should(Equal(ErrTruncatedDump(path.Join(dir, DatabaseDumps[0].Artifact))))
+		})
+
+		It("should only check the dumps of the given components", func() {
+			Ω(ValidateDumps(dir, "uaadb, mysql")).Should(BeNil())
+		})
+	})
+})
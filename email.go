@@ -0,0 +1,208 @@
+package cfops
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	NotifyAlways  = "always"
+	NotifyFailure = "failure"
+
+	ErrNoRecipientsMsg = "no email recipients configured"
+	summaryAttachment  = "summary.json"
+	smtpDialTimeout    = 30 * time.Second
+)
+
+var ErrNoRecipients = errors.New(ErrNoRecipientsMsg)
+
+// SMTPConfig describes how to email the outcome of a run. Host is host:port;
+// ImplicitTLS connects over tls (usually port 465), otherwise STARTTLS is used
+// whenever the server offers it
+type SMTPConfig struct {
+	Host        string
+	User        string
+	Pass        string
+	From        string
+	To          []string
+	ImplicitTLS bool
+	// NotifyOn is NotifyFailure (the default) or NotifyAlways
+	NotifyOn string
+}
+
+// SendRunEmail emails a summary of the finished run to the recipients, with
+// the catalog entry of the run attached as json
+func SendRunEmail(config SMTPConfig, foundation string, entry *CatalogEntry) (err error) {
+	var (
+		message []byte
+		client  *smtp.Client
+		writer  io.WriteCloser
+	)
+
+	if len(config.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	if message, err = runEmail(config, foundation, entry); err != nil {
+		return
+	}
+
+	if client, err = dialSMTP(config); err != nil {
+		return
+	}
+	defer client.Close()
+
+	if err = client.Mail(config.From); err != nil {
+		return
+	}
+
+	for _, to := range config.To {
+		if err = client.Rcpt(to); err != nil {
+			return
+		}
+	}
+
+	if writer, err = client.Data(); err != nil {
+		return
+	}
+
+	if _, err = writer.Write(message); err == nil {
+		err = writer.Close()
+	}
+
+	if err == nil {
+		err = client.Quit()
+	}
+	return
+}
+
+func dialSMTP(config SMTPConfig) (client *smtp.Client, err error) {
+	var (
+		conn net.Conn
+		host string
+	)
+
+	if host, _, err = net.SplitHostPort(config.Host); err != nil {
+		return
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+
+	if config.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", config.Host, tlsConfig)
+
+	} else {
+		conn, err = dialer.Dial("tcp", config.Host)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if client, err = smtp.NewClient(conn, host); err != nil {
+		conn.Close()
+		return
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !config.ImplicitTLS {
+		err = client.StartTLS(tlsConfig)
+	}
+
+	if err == nil && config.User != "" {
+		err = client.Auth(smtp.PlainAuth("", config.User, config.Pass, host))
+	}
+
+	if err != nil {
+		client.Close()
+		client = nil
+	}
+	return
+}
+
+func runEmail(config SMTPConfig, foundation string, entry *CatalogEntry) (message []byte, err error) {
+	var (
+		buffer  bytes.Buffer
+		summary []byte
+		part    io.Writer
+	)
+
+	if summary, err = json.MarshalIndent(entry, "", "  "); err != nil {
+		return
+	}
+	writer := multipart.NewWriter(&buffer)
+	fmt.Fprintf(&buffer, "From: %s\r\n", config.From)
+	fmt.Fprintf(&buffer, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&buffer, "Subject: cfops %s %s for %s\r\n", entry.Action, entry.Status, foundation)
+	fmt.Fprintf(&buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buffer, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buffer, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	if part, err = writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}}); err != nil {
+		return
+	}
+	part.Write([]byte(runEmailBody(foundation, entry)))
+
+	if part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/json"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", summaryAttachment)},
+	}); err != nil {
+		return
+	}
+	part.Write([]byte(wrapBase64(summary)))
+
+	if err = writer.Close(); err == nil {
+		message = buffer.Bytes()
+	}
+	return
+}
+
+func runEmailBody(foundation string, entry *CatalogEntry) string {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s of %s to %s: %s\r\n", entry.Action, foundation, entry.Destination, entry.Status)
+	fmt.Fprintf(&body, "run %s started %s, finished %s\r\n\r\n", entry.ID, entry.Started.Format(time.RFC3339), entry.Finished.Format(time.RFC3339))
+
+	for _, c := range entry.Components {
+		fmt.Fprintf(&body, "%-12s %-10s %8.1fs %12d bytes", c.Name, c.Status, c.Seconds, c.Bytes)
+
+		if c.Error != "" {
+			fmt.Fprintf(&body, "  %s", c.Error)
+		}
+		body.WriteString("\r\n")
+	}
+	return body.String()
+}
+
+func wrapBase64(contents []byte) string {
+	var wrapped bytes.Buffer
+	encoded := base64.StdEncoding.EncodeToString(contents)
+
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+	return wrapped.String()
+}
+
+// emailNotifier emails the outcome of a run that did not complete, or of
+// every run with NotifyAlways
+type emailNotifier SMTPConfig
+
+func (s emailNotifier) Notify(notification Notification) error {
+	if notification.Type == NotifyRunFailed || (notification.Type == NotifyRunFinished && s.NotifyOn == NotifyAlways) {
+		return SendRunEmail(SMTPConfig(s), notification.Foundation, notification.Entry)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package cfops_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SendRunEmail", func() {
+	var (
+		server *fakeSMTPServer
+		config SMTPConfig
+		entry  *CatalogEntry
+	)
+
+	BeforeEach(func() {
+		server = newFakeSMTPServer()
+		config = SMTPConfig{
+			Host: server.listener.Addr().String(),
+			From: "cfops@example.com",
+			To:   []string{"ops@example.com", "oncall@example.com"},
+		}
+		entry = &CatalogEntry{
+			ID:          "run",
+			Action:      Backup,
+			Destination: "/backups/nightly",
+			Status:      SetIncomplete,
+			Started:     time.Now(),
+			Finished:    time.Now(),
+			Components:  []ComponentResult{{Name: ER, Status: ComponentFailed, Error: "dump truncated"}},
+		}
+	})
+
+	AfterEach(func() {
+		server.listener.Close()
+	})
+
+	It("should mail a summary of the run to every recipient", func() {
+		Ω(SendRunEmail(config, "opsman.example.com", entry)).Should(BeNil())
+		Eventually(server.messages).Should(Receive(&server.last))
+		Ω(server.recipients).Should(Equal(config.To))
+		Ω(server.last).Should(ContainSubstring("Subject: cfops backup incomplete for opsman.example.com"))
+		Ω(server.last).Should(ContainSubstring("dump truncated"))
+		Ω(server.last).Should(ContainSubstring(`filename="summary.json"`))
+	})
+
+	It("should refuse to send without recipients", func() {
+		config.To = nil
+		Ω(SendRunEmail(config, "opsman.example.com", entry)).Should(Equal(ErrNoRecipients))
+	})
+})
+
+// fakeSMTPServer accepts a single plain text smtp session
+type fakeSMTPServer struct {
+	listener   net.Listener
+	recipients []string
+	messages   chan string
+	last       string
+}
+
+func newFakeSMTPServer() (s *fakeSMTPServer) {
+	s = &fakeSMTPServer{messages: make(chan string, 1)}
+	s.listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	go s.serve()
+	return
+}
+
+func (s *fakeSMTPServer) serve() {
+	conn, err := s.listener.Accept()
+
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake ESMTP")
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 fake")
+
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.recipients = append(s.recipients, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 ok")
+
+		case command == "DATA":
+			reply("354 go ahead")
+			var message []string
+
+			for {
+				dataLine, _ := reader.ReadString('\n')
+
+				if strings.TrimSpace(dataLine) == "." {
+					break
+				}
+				message = append(message, dataLine)
+			}
+			s.messages <- strings.Join(message, "")
+			reply("250 queued")
+
+		case command == "QUIT":
+			reply("221 bye")
+			return
+
+		default:
+			reply("250 ok")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// AllFoundations selects every foundation of the config
+	AllFoundations = "all"
+
+	ErrSharedDestinationFormat = "%s would be shared by every foundation, name {{.Foundation}} in it or give each foundation a destination of its own"
+)
+
+// FoundationRun is the outcome of the run of one foundation of a run over
+// several: the exit code of the cfops run and the result file it left
+type FoundationRun struct {
+	Foundation string `json:"foundation"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	// Run is the catalog entry of the run, nil when it did not get as far as
+	// starting one
+	Run *CatalogEntry `json:"run,omitempty"`
+}
+
+func ErrSharedDestination(destination string) error {
+	return fmt.Errorf(ErrSharedDestinationFormat, destination)
+}
+
+// Select is the foundations of the csv list, every foundation of the config
+// for AllFoundations, failing on the first one the config does not have
+func (s FoundationConfig) Select(list string) (names []string, err error) {
+	if strings.TrimSpace(list) == AllFoundations {
+		if names = s.Names(); len(names) == 0 {
+			err = ErrUnknownFoundation(s.path, AllFoundations, nil)
+		}
+		return
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		if _, err = s.Profile(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return
+}
+
+// RunFoundations runs each foundation, at most parallel of them at once, and
+// returns their outcomes in the order the foundations were given. The run of
+// one foundation does not stop the others
+func RunFoundations(foundations []string, parallel int, run func(foundation string) FoundationRun) []FoundationRun {
+	var wg sync.WaitGroup
+	runs := make([]FoundationRun, len(foundations))
+	slots := make(chan struct{}, maxInt(parallel, 1))
+
+	for i, foundation := range foundations {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(i int, foundation string) {
+			defer wg.Done()
+			runs[i] = run(foundation)
+			runs[i].Foundation = foundation
+			<-slots
+		}(i, foundation)
+	}
+	wg.Wait()
+	return runs
+}
+
+// WriteFoundationRuns writes the outcome of each foundation, as json or as a
+// line of text each
+func WriteFoundationRuns(w io.Writer, runs []FoundationRun, asJSON bool) (err error) {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(runs)
+	}
+
+	for _, run := range runs {
+		status, destination := "not started", ""
+
+		if run.Run != nil {
+			status, destination = run.Run.Status, run.Run.Destination
+		}
+		line := fmt.Sprintf("%s: %s, exit %d", run.Foundation, status, run.ExitCode)
+
+		if destination != "" {
+			line += ", " + destination
+		}
+
+		if run.Error != "" {
+			line += ": " + run.Error
+		}
+
+		if _, err = fmt.Fprintln(w, line); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
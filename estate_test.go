@@ -0,0 +1,115 @@
+package cfops_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Estate", func() {
+	Describe("selecting the foundations of the config", func() {
+		var (
+			dir    string
+			config FoundationConfig
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "estate")
+			configPath := path.Join(dir, "foundations.yml")
+			ioutil.WriteFile(configPath, []byte("foundations:\n  prod:\n    opsmanagerhost: opsman.prod\n  staging:\n    opsmanagerhost: opsman.staging\n"), 0600)
+			config, _ = LoadFoundationConfig(configPath)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should take every foundation for all", func() {
+			Ω(config.Select("all")).Should(Equal([]string{"prod", "staging"}))
+		})
+
+		It("should take the foundations of the list in its order", func() {
+			Ω(config.Select("staging, prod,")).Should(Equal([]string{"staging", "prod"}))
+		})
+
+		It("should fail on a foundation the config does not have", func() {
+			_, err := config.Select("prod, dr")
+			Ω(err).Should(Equal(ErrUnknownFoundation(path.Join(dir, "foundations.yml"), "dr", []string{"prod", "staging"})))
+		})
+	})
+
+	Describe("running the foundations", func() {
+		It("should give the outcomes in the order of the foundations", func() {
+			runs := RunFoundations([]string{"prod", "staging", "dev"}, 3, func(foundation string) FoundationRun {
+				if foundation == "prod" {
+					time.Sleep(20 * time.Millisecond)
+					return FoundationRun{ExitCode: 1, Error: "failed"}
+				}
+				return FoundationRun{}
+			})
+			Ω(runs).Should(Equal([]FoundationRun{{Foundation: "prod", ExitCode: 1, Error: "failed"}, {Foundation: "staging"}, {Foundation: "dev"}}))
+		})
+
+		It("should run no more foundations at once than asked", func() {
+			var (
+				mutex             sync.Mutex
+				running, mostSeen int
+			)
+			RunFoundations([]string{"a", "b", "c", "d", "e"}, 2, func(foundation string) FoundationRun {
+				mutex.Lock()
+				running++
+				if running > mostSeen {
+					mostSeen = running
+				}
+				mutex.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+				return FoundationRun{}
+			})
+			Ω(mostSeen).Should(Equal(2))
+		})
+
+		It("should run them one at a time when not asked for more", func() {
+			var order []string
+			RunFoundations([]string{"a", "b", "c"}, 0, func(foundation string) FoundationRun {
+				order = append(order, foundation)
+				return FoundationRun{}
+			})
+			Ω(order).Should(Equal([]string{"a", "b", "c"}))
+		})
+	})
+
+	Describe("writing the outcomes", func() {
+		var runs []FoundationRun
+
+		BeforeEach(func() {
+			runs = []FoundationRun{
+				{Foundation: "prod", Run: &CatalogEntry{Status: SetComplete, Destination: "/backups/prod"}},
+				{Foundation: "staging", ExitCode: 1, Error: "could not reach ops manager"},
+			}
+		})
+
+		It("should write a line for each foundation", func() {
+			var out bytes.Buffer
+			Ω(WriteFoundationRuns(&out, runs, false)).Should(BeNil())
+			Ω(out.String()).Should(Equal("prod: complete, exit 0, /backups/prod\nstaging: not started, exit 1: could not reach ops manager\n"))
+		})
+
+		It("should write them as json", func() {
+			var out bytes.Buffer
+			Ω(WriteFoundationRuns(&out, runs, true)).Should(BeNil())
+			Ω(out.String()).Should(ContainSubstring(`"foundation": "staging"`))
+			Ω(out.String()).Should(ContainSubstring(`"exit_code": 1`))
+		})
+	})
+})
@@ -0,0 +1,95 @@
+package cfops
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	EventRunStarted   = "run_started"
+	EventRunFinished  = "run_finished"
+	EventTaskStarted  = "task_started"
+	EventTaskFinished = "task_finished"
+	EventTaskFailed   = "task_failed"
+	EventProgress     = "progress"
+	EventWarning      = "warning"
+
+	// eventBuffer is how many events a subscriber may fall behind by before
+	// further events are dropped for it
+	eventBuffer = 256
+)
+
+var events = &eventBroker{subscribers: make(map[chan Event]bool)}
+
+type (
+	// Event is a change in the progress of a run: a run or task starting or
+	// finishing, the bytes a transfer has streamed so far out of its total
+	// when that is known, or a warning
+	Event struct {
+		Type    string    `json:"type"`
+		Time    time.Time `json:"time"`
+		RunID   string    `json:"run_id,omitempty"`
+		TaskID  string    `json:"task_id,omitempty"`
+		Task    string    `json:"task,omitempty"`
+		Bytes   int64     `json:"bytes,omitempty"`
+		Total   int64     `json:"total_bytes,omitempty"`
+		Rate    float64   `json:"bytes_per_second,omitempty"`
+		Message string    `json:"message,omitempty"`
+	}
+
+	eventBroker struct {
+		mutex       sync.Mutex
+		subscribers map[chan Event]bool
+	}
+)
+
+// SubscribeEvents delivers every event published from now on until cancel is
+// called. A subscriber that falls behind misses events rather than holding up
+// the run
+func SubscribeEvents() (subscription <-chan Event, cancel func()) {
+	ch := make(chan Event, eventBuffer)
+	events.mutex.Lock()
+	events.subscribers[ch] = true
+	events.mutex.Unlock()
+
+	return ch, func() {
+		events.mutex.Lock()
+		defer events.mutex.Unlock()
+
+		if events.subscribers[ch] {
+			delete(events.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishEvent stamps the event with the time and the run and task in
+// progress, then hands it to every subscriber
+func publishEvent(event Event) {
+	event.Time = time.Now().UTC()
+	runID, taskID, task, _ := logContext.current()
+	event.RunID = runID
+
+	if event.Task == "" {
+		event.TaskID, event.Task = taskID, task
+	}
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+
+	for ch := range events.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// warn logs a problem that does not fail the run and publishes it as a
+// warning event
+func warn(format string, args ...interface{}) {
+	lo.G.Error(format, args...)
+	publishEvent(Event{Type: EventWarning, Message: fmt.Sprintf(format, args...)})
+}
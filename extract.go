@@ -0,0 +1,73 @@
+package cfops
+
+import (
+	"compress/gzip"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// extractWorkers is how many artifacts are extracted at once for a
+// concurrency of n, one for each cpu when n is not set
+func extractWorkers(n int) int {
+	if n < 1 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+// forEachConcurrently runs each of the names through fn, n at a time, and
+// returns the first error. No further name is started once one has failed
+func forEachConcurrently(names []string, n int, fn func(name string) error) (err error) {
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		failed bool
+	)
+	slots := make(chan struct{}, extractWorkers(n))
+
+	for _, name := range names {
+		slots <- struct{}{}
+		mutex.Lock()
+		stop := failed
+		mutex.Unlock()
+
+		if stop {
+			<-slots
+			break
+		}
+		wg.Add(1)
+
+		go func(name string) {
+			defer wg.Done()
+			nameErr := fn(name)
+
+			mutex.Lock()
+			if nameErr != nil && !failed {
+				failed, err = true, nameErr
+			}
+			mutex.Unlock()
+			<-slots
+		}(name)
+	}
+	wg.Wait()
+	return
+}
+
+// decompressAhead decompresses the gzip stream on a goroutine of its own, so
+// that decompressing an artifact overlaps with whatever its reader does with
+// it, such as compressing it again. Closing the reader stops the goroutine
+func decompressAhead(in io.Reader) (contents io.ReadCloser, err error) {
+	var gz *gzip.Reader
+
+	if gz, err = gzip.NewReader(in); err != nil {
+		return
+	}
+	reader, writer := io.Pipe()
+
+	go func() {
+		_, copyErr := io.Copy(writer, gz)
+		writer.CloseWithError(firstError(copyErr, gz.Close()))
+	}()
+	return reader, nil
+}
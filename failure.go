@@ -0,0 +1,129 @@
+package cfops
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	HintAuth              = "check the --adminuser/--adminpass and --opsmanageruser/--opsmanagerpass credentials, and that the account is not locked"
+	HintNoSpace           = "free up space in the destination, or on the vm being dumped, or back up to a larger volume"
+	HintHostKey           = "the ssh host key of the vm changed since it was recorded, make sure the vm was rebuilt rather than impersonated and remove its old known_hosts entry"
+	HintOpsManagerVersion = "this version of ops manager does not serve the api cfops expects, check the version compatibility in the README"
+)
+
+// remediations are the hints of the known kinds of failure, each told apart by
+// what its message contains
+var remediations = []struct {
+	hint     string
+	messages []string
+}{
+	{HintAuth, []string{"unable to authenticate", "401 unauthorized", "invalid director credentials", "password authentication failed", "access denied for user", "bad credentials"}},
+	{HintNoSpace, []string{"no space left on device"}},
+	{HintHostKey, []string{"key mismatch", "remote host identification has changed"}},
+	{HintOpsManagerVersion, []string{"404 not found", "invalid character '<' looking for beginning of value"}},
+}
+
+// RunError is a failure of a run along with where it happened, the tile, the
+// host it was talking to and the store and phase it was in, when those are
+// known, and how to fix it when it is a known kind of failure
+type RunError struct {
+	Tile string
+	Host string
+	Step string
+	Hint string
+	Err  error
+}
+
+func (e *RunError) Error() string {
+	msg := e.Err.Error()
+
+	if where := strings.TrimSpace(e.Tile + " " + e.Step); where != "" && e.Host != "" {
+		msg = fmt.Sprintf("%s on %s: %s", where, e.Host, msg)
+
+	} else if where != "" {
+		msg = fmt.Sprintf("%s: %s", where, msg)
+	}
+
+	if e.Hint != "" {
+		msg += "; hint: " + e.Hint
+	}
+	return msg
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// RemediationHint tells how to fix the failure when it is of a known kind
+func RemediationHint(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if errors.Is(err, syscall.ENOSPC) {
+		return HintNoSpace
+	}
+	msg := strings.ToLower(err.Error())
+
+	for _, remediation := range remediations {
+		for _, message := range remediation.messages {
+			if strings.Contains(msg, message) {
+				return remediation.hint
+			}
+		}
+	}
+	return ""
+}
+
+// tileError places the failure of a tile at the host and step it happened in
+func tileError(tile, host, step string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RunError{Tile: tile, Host: host, Step: step, Hint: RemediationHint(err), Err: err}
+}
+
+// withHint adds the hint of a known kind of failure to an error that has not
+// been placed in a tile, leaving every other error as it is
+func withHint(err error) error {
+	if _, placed := err.(*RunError); placed || err == nil {
+		return err
+	}
+
+	if hint := RemediationHint(err); hint != "" {
+		return &RunError{Hint: hint, Err: err}
+	}
+	return err
+}
+
+// failedStep remembers the first step of a tile to fail, which is the phase
+// of a store rather than the store itself as phases finish first
+type failedStep struct {
+	mutex sync.Mutex
+	name  string
+}
+
+func (s *failedStep) record(step string, err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.name == "" {
+		s.name = step
+	}
+}
+
+func (s *failedStep) step() string {
+	if s == nil {
+		return ""
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.name
+}
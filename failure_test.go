@@ -0,0 +1,44 @@
+package cfops_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RunError", func() {
+	It("should place the failure at the tile, step and host it happened in", func() {
+		err := &RunError{Tile: ER, Host: "10.0.16.5", Step: "ccdb/dump", Err: errors.New("EOF")}
+		Ω(err).Should(MatchError("ER ccdb/dump on 10.0.16.5: EOF"))
+	})
+
+	It("should append the hint of a known failure", func() {
+		err := &RunError{Tile: OpsMgr, Hint: HintAuth, Err: errors.New("401 Unauthorized")}
+		Ω(err).Should(MatchError("OPSMANAGER: 401 Unauthorized; hint: " + HintAuth))
+	})
+
+	Describe("RemediationHint", func() {
+		It("should recognize the known kinds of failure", func() {
+			for message, hint := range map[string]string{
+				"ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password]": HintAuth,
+				"pq: password authentication failed for user \"admin\"":                                 HintAuth,
+				"write /backups/nfs_server.backup: no space left on device":                             HintNoSpace,
+				"ssh: handshake failed: knownhosts: key mismatch":                                       HintHostKey,
+				"invalid character '<' looking for beginning of value":                                  HintOpsManagerVersion,
+				"EOF": "",
+			} {
+				Ω(RemediationHint(errors.New(message))).Should(Equal(hint), message)
+			}
+		})
+
+		It("should recognize a full disk however its error is wrapped", func() {
+			err := &os.PathError{Op: "write", Path: "/backups/ccdb.backup", Err: syscall.ENOSPC}
+			Ω(RemediationHint(err)).Should(Equal(HintNoSpace))
+		})
+	})
+})
@@ -0,0 +1,94 @@
+package cfops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	ErrUnknownFoundationFormat = "%s has no foundation %s, it has %s"
+	ErrNoFoundationsFormat     = "%s has no foundations"
+)
+
+type (
+	// FoundationConfig is the file named foundations are read from, so that
+	// one file describes prod, staging and dr alike. Settings a foundation
+	// leaves empty fall back to the defaults
+	FoundationConfig struct {
+		Defaults    FoundationProfile            `yaml:"defaults"`
+		Foundations map[string]FoundationProfile `yaml:"foundations"`
+		path        string
+	}
+
+	// FoundationProfile is the connection settings of a foundation, taken in
+	// place of the flags left empty
+	FoundationProfile struct {
+		Host           string `yaml:"opsmanagerhost"`
+		AdminUser      string `yaml:"adminuser"`
+		AdminPass      string `yaml:"adminpass"`
+		OpsManagerUser string `yaml:"opsmanageruser"`
+		OpsManagerPass string `yaml:"opsmanagerpass"`
+		Destination    string `yaml:"destination"`
+		Tilelist       string `yaml:"tilelist"`
+	}
+)
+
+func ErrUnknownFoundation(configPath, name string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf(ErrNoFoundationsFormat, configPath)
+	}
+	return fmt.Errorf(ErrUnknownFoundationFormat, configPath, name, strings.Join(names, ", "))
+}
+
+// LoadFoundationConfig reads the named foundations from a yaml file
+func LoadFoundationConfig(configPath string) (config FoundationConfig, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(configPath); err == nil {
+		err = yaml.Unmarshal(contents, &config)
+	}
+	config.path = configPath
+	return
+}
+
+// Names are the names of the foundations of the config, in order
+func (s FoundationConfig) Names() (names []string) {
+	for name := range s.Foundations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Profile is the settings of the named foundation, with the defaults in place
+// of those it leaves empty
+func (s FoundationConfig) Profile(name string) (profile FoundationProfile, err error) {
+	profile, defined := s.Foundations[name]
+
+	if !defined {
+		return profile, ErrUnknownFoundation(s.path, name, s.Names())
+	}
+	defaults := []struct {
+		value   string
+		setting *string
+	}{
+		{s.Defaults.Host, &profile.Host},
+		{s.Defaults.AdminUser, &profile.AdminUser},
+		{s.Defaults.AdminPass, &profile.AdminPass},
+		{s.Defaults.OpsManagerUser, &profile.OpsManagerUser},
+		{s.Defaults.OpsManagerPass, &profile.OpsManagerPass},
+		{s.Defaults.Destination, &profile.Destination},
+		{s.Defaults.Tilelist, &profile.Tilelist},
+	}
+
+	for _, d := range defaults {
+		if *d.setting == "" {
+			*d.setting = d.value
+		}
+	}
+	return
+}
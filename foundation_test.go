@@ -0,0 +1,55 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FoundationConfig", func() {
+	var (
+		dir        string
+		configPath string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "foundations")
+		configPath = path.Join(dir, "foundations.yml")
+		ioutil.WriteFile(configPath, []byte(`
+defaults:
+  adminuser: admin
+  opsmanageruser: ubuntu
+  tilelist: opsmanager, er
+foundations:
+  prod:
+    opsmanagerhost: opsman.prod
+    destination: /backups/prod
+  staging:
+    opsmanagerhost: opsman.staging
+    tilelist: opsmanager
+`), 0600)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should take the defaults in place of the settings a foundation leaves empty", func() {
+		config, err := LoadFoundationConfig(configPath)
+		Ω(err).Should(BeNil())
+		Ω(config.Profile("prod")).Should(Equal(FoundationProfile{Host: "opsman.prod", AdminUser: "admin", OpsManagerUser: "ubuntu", Destination: "/backups/prod", Tilelist: "opsmanager, er"}))
+		Ω(config.Profile("staging")).Should(Equal(FoundationProfile{Host: "opsman.staging", AdminUser: "admin", OpsManagerUser: "ubuntu", Tilelist: "opsmanager"}))
+	})
+
+	It("should name the foundations it has when asked for one it does not", func() {
+		config, err := LoadFoundationConfig(configPath)
+		Ω(err).Should(BeNil())
+		_, err = config.Profile("dr")
+		Ω(err).Should(Equal(ErrUnknownFoundation(configPath, "dr", []string{"prod", "staging"})))
+	})
+})
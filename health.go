@@ -0,0 +1,210 @@
+package cfops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrUnhealthyFormat       = "the restored foundation is not healthy: %s"
+	ErrUnhealthyBackupFormat = "not backing up a foundation that is not healthy, give --ignore-unhealthy to back it up anyway: %s"
+	// instanceRunning and instanceStopped are the process states of instances
+	// that are where the deployment wants them
+	instanceRunning = "running"
+	instanceStopped = "stopped"
+)
+
+type (
+	// HealthCheckConfig describes the health check a restore ends with, or a
+	// backup starts with. The bosh cli reads the director and its credentials
+	// from BOSH_ENVIRONMENT, BOSH_CLIENT, BOSH_CLIENT_SECRET and BOSH_CA_CERT
+	HealthCheckConfig struct {
+		Enabled bool
+		// Deployments are checked, every deployment of the director when empty
+		Deployments []string
+		// IgnoreUnhealthy lets a backup of an unhealthy foundation go ahead,
+		// warning about what is unhealthy
+		IgnoreUnhealthy bool
+		// Binary defaults to bosh on the path
+		Binary string
+	}
+
+	// HealthReport is what the health check found in each deployment
+	HealthReport struct {
+		Deployments []DeploymentHealth `json:"deployments"`
+	}
+
+	// DeploymentHealth lists the problems cloud check reports for a deployment
+	// and the instances whose processes are not running
+	DeploymentHealth struct {
+		Name      string   `json:"name"`
+		Problems  []string `json:"problems,omitempty"`
+		Unhealthy []string `json:"unhealthy_instances,omitempty"`
+	}
+
+	boshOutput struct {
+		Tables []struct {
+			Rows []map[string]string `json:"Rows"`
+		} `json:"Tables"`
+		Blocks []string `json:"Blocks"`
+		Lines  []string `json:"Lines"`
+	}
+)
+
+func ErrUnhealthy(problems string) error {
+	return fmt.Errorf(ErrUnhealthyFormat, problems)
+}
+
+func ErrUnhealthyBackup(problems string) error {
+	return fmt.Errorf(ErrUnhealthyBackupFormat, problems)
+}
+
+// Healthy tells whether no deployment has a problem or an unhealthy instance
+func (s *HealthReport) Healthy() bool {
+	return len(s.summary()) == 0
+}
+
+func (s *HealthReport) summary() (problems []string) {
+	for _, deployment := range s.Deployments {
+		for _, problem := range deployment.Problems {
+			problems = append(problems, deployment.Name+": "+problem)
+		}
+
+		for _, instance := range deployment.Unhealthy {
+			problems = append(problems, deployment.Name+": "+instance)
+		}
+	}
+	return
+}
+
+// CheckBackupHealth is CheckHealth before a backup, which fails when anything
+// is unhealthy unless told to ignore it. A director that can not be asked
+// fails the check either way
+func CheckBackupHealth(config HealthCheckConfig) (report *HealthReport, err error) {
+	if report, err = CheckHealth(config); err == nil || report.Healthy() {
+		return
+	}
+	problems := strings.Join(report.summary(), "; ")
+
+	if config.IgnoreUnhealthy {
+		warn("backing up a foundation that is not healthy: %s", problems)
+		return report, nil
+	}
+	return report, ErrUnhealthyBackup(problems)
+}
+
+// CheckHealth runs cloud check in report mode, resolving nothing, and lists
+// the instances of each deployment, failing when anything is unhealthy
+func CheckHealth(config HealthCheckConfig) (report *HealthReport, err error) {
+	var rows []map[string]string
+	report = &HealthReport{}
+	deployments := config.Deployments
+
+	if len(deployments) == 0 {
+		if rows, err = config.bosh("deployments"); err != nil {
+			return
+		}
+
+		for _, row := range rows {
+			deployments = append(deployments, row["name"])
+		}
+	}
+
+	for _, name := range deployments {
+		var deployment DeploymentHealth
+
+		if deployment, err = config.checkDeployment(name); err != nil {
+			return
+		}
+		report.Deployments = append(report.Deployments, deployment)
+	}
+
+	if problems := report.summary(); len(problems) > 0 {
+		err = ErrUnhealthy(strings.Join(problems, "; "))
+	}
+	return
+}
+
+func (s HealthCheckConfig) checkDeployment(name string) (deployment DeploymentHealth, err error) {
+	var rows []map[string]string
+	deployment.Name = name
+	lo.G.Info("checking the health of deployment %s", name)
+
+	if rows, err = s.bosh("-d", name, "cloud-check", "--report"); err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		deployment.Problems = append(deployment.Problems, fmt.Sprintf("%s %s", row["type"], row["description"]))
+	}
+
+	if rows, err = s.bosh("-d", name, "instances"); err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		if state := row["process_state"]; state != instanceRunning && state != instanceStopped {
+			deployment.Unhealthy = append(deployment.Unhealthy, fmt.Sprintf("%s is %s", row["instance"], state))
+		}
+	}
+	return
+}
+
+func (s HealthCheckConfig) bosh(args ...string) (rows []map[string]string, err error) {
+	return runBosh(s.Binary, args...)
+}
+
+// runBosh runs the bosh cli with json output, returning the rows of its
+// tables. Cloud check in report mode exits non zero when it finds problems,
+// so a failed command that still reported rows is not an error
+func runBosh(binary string, args ...string) (rows []map[string]string, err error) {
+	var output boshOutput
+
+	if output, err = runBoshOutput(binary, args...); err == nil {
+		rows = output.rows()
+	}
+	return
+}
+
+// runBoshOutput runs the bosh cli with json output, returning all it output,
+// such as the blocks of text a manifest is printed as
+func runBoshOutput(binary string, args ...string) (output boshOutput, err error) {
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	)
+
+	if binary == "" {
+		binary = "bosh"
+	}
+	cmd := execCommand(binary, append([]string{"--json", "--non-interactive"}, args...)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+	jsonErr := json.Unmarshal(stdout.Bytes(), &output)
+
+	if jsonErr != nil || (runErr != nil && len(output.rows()) == 0) {
+		message := strings.TrimSpace(strings.Join(output.Lines, " ") + " " + stderr.String())
+		return boshOutput{}, fmt.Errorf("bosh %s: %v %s", strings.Join(args, " "), firstError(runErr, jsonErr), message)
+	}
+	return
+}
+
+func (s boshOutput) rows() (rows []map[string]string) {
+	for _, table := range s.Tables {
+		rows = append(rows, table.Rows...)
+	}
+	return
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package cfops_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBosh records its arguments and answers like the bosh cli would, with
+// the answers of the director in files named after the command
+const fakeBosh = `#!/bin/sh
+dir="$(dirname "$0")"
+echo "$*" >> "$dir/calls"
+for arg in "$@"; do command="$arg"; done
+case "$*" in
+*cloud-check*) command=cck ;;
+*" stop "*) command=stop ;;
+*" start "*) command=start ;;
+esac
+cat "$dir/$command.json" 2>/dev/null || { echo '{"Tables":[],"Lines":["Director responded with non-successful status code 401"]}'; exit 1; }
+[ -f "$dir/$command.fail" ] && exit 1
+exit 0
+`
+
+var _ = Describe("CheckHealth", func() {
+	var (
+		bin    string
+		config HealthCheckConfig
+	)
+
+	answer := func(command, output string, fail bool) {
+		ioutil.WriteFile(path.Join(bin, command+".json"), []byte(output), 0644)
+
+		if fail {
+			ioutil.WriteFile(path.Join(bin, command+".fail"), nil, 0644)
+		}
+	}
+
+	calls := func() []string {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		bin, _ = ioutil.TempDir("", "bosh-bin")
+		ioutil.WriteFile(path.Join(bin, "bosh"), []byte(fakeBosh), 0755)
+		answer("deployments", `{"Tables":[{"Rows":[{"name":"cf-0123"}]}]}`, false)
+		answer("cck", `{"Tables":[{"Rows":[]}]}`, false)
+		answer("instances", `{"Tables":[{"Rows":[{"instance":"router/0","process_state":"running"},{"instance":"errand/0","process_state":"stopped"}]}]}`, false)
+		config = HealthCheckConfig{Enabled: true, Binary: path.Join(bin, "bosh")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bin)
+	})
+
+	It("should check every deployment of the director in report mode", func() {
+		report, err := CheckHealth(config)
+		Ω(err).Should(BeNil())
+		Ω(report.Healthy()).Should(BeTrue())
+		Ω(report.Deployments).Should(HaveLen(1))
+		Ω(calls()).Should(Equal([]string{
+			"--json --non-interactive deployments",
+			"--json --non-interactive -d cf-0123 cloud-check --report",
+			"--json --non-interactive -d cf-0123 instances",
+		}))
+	})
+
+	It("should only check the given deployments", func() {
+		config.Deployments = []string{"p-mysql"}
+		CheckHealth(config)
+		Ω(calls()[0]).Should(Equal("--json --non-interactive -d p-mysql cloud-check --report"))
+	})
+
+	It("should report the problems cloud check found", func() {
+		answer("cck", `{"Tables":[{"Rows":[{"id":"3","type":"unresponsive_agent","description":"VM for 'diego_cell/1' is not responding"}]}]}`, true)
+		report, err := CheckHealth(config)
+		Ω(report.Healthy()).Should(BeFalse())
+		Ω(err).Should(Equal(ErrUnhealthy("cf-0123: unresponsive_agent VM for 'diego_cell/1' is not responding")))
+	})
+
+	It("should report the instances that are not running", func() {
+		answer("instances", `{"Tables":[{"Rows":[{"instance":"router/0","process_state":"failing"}]}]}`, false)
+		_, err := CheckHealth(config)
+		Ω(err).Should(Equal(ErrUnhealthy("cf-0123: router/0 is failing")))
+	})
+
+	It("should fail rather than pass when the director cannot be asked", func() {
+		os.Remove(path.Join(bin, "deployments.json"))
+		_, err := CheckHealth(config)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("401"))
+	})
+
+	Describe("running a restore", func() {
+		It("should not complete a restore of an unhealthy foundation", func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+			answer("instances", `{"Tables":[{"Rows":[{"instance":"router/0","process_state":"failing"}]}]}`, false)
+			entry, err := RunPipelineResult(context.Background(), &mockFlagSet{tileListFlag: "opsmanager", dest: tempDest(), healthCheck: config}, Restore)
+			Ω(err).ShouldNot(BeNil())
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+			Ω(entry.Health.Deployments[0].Unhealthy).Should(ConsistOf("router/0 is failing"))
+		})
+	})
+
+	Describe("running a backup", func() {
+		var runs int
+
+		BeforeEach(func() {
+			runs = 0
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					runs++
+					return &mockTile{}, nil
+				},
+			}
+			answer("instances", `{"Tables":[{"Rows":[{"instance":"router/0","process_state":"failing"}]}]}`, false)
+		})
+
+		It("should not back up an unhealthy foundation", func() {
+			entry, err := RunPipelineResult(context.Background(), &mockFlagSet{tileListFlag: "opsmanager", dest: tempDest(), healthCheck: config}, Backup)
+			Ω(err).Should(Equal(ErrUnhealthyBackup("cf-0123: router/0 is failing")))
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+			Ω(entry.Health.Deployments[0].Unhealthy).Should(ConsistOf("router/0 is failing"))
+			Ω(runs).Should(Equal(0))
+		})
+
+		It("should back it up anyway when told to ignore it", func() {
+			config.IgnoreUnhealthy = true
+			entry, err := RunPipelineResult(context.Background(), &mockFlagSet{tileListFlag: "opsmanager", dest: tempDest(), healthCheck: config}, Backup)
+			Ω(err).Should(BeNil())
+			Ω(entry.Health.Healthy()).Should(BeFalse())
+			Ω(runs).Should(Equal(1))
+		})
+
+		It("should not back up when the director cannot be asked, even when told to ignore an unhealthy foundation", func() {
+			config.IgnoreUnhealthy = true
+			os.Remove(path.Join(bin, "deployments.json"))
+			_, err := RunPipelineResult(context.Background(), &mockFlagSet{tileListFlag: "opsmanager", dest: tempDest(), healthCheck: config}, Backup)
+			Ω(err).ShouldNot(BeNil())
+			Ω(runs).Should(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package cfops
+
+import (
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+// DefaultHeartbeat is how often a long transfer logs its progress unless the
+// operator asks otherwise
+const DefaultHeartbeat = time.Minute
+
+// StartHeartbeat logs the bytes the named transfer has streamed so far, and its
+// throughput since the previous heartbeat, every interval until it is stopped,
+// so a slow transfer can be told apart from a hung one. A zero interval never
+// logs. The total is published along with the bytes when it is known
+func StartHeartbeat(name string, total int64, transferred func() int64, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		started, last, lastBytes := time.Now(), time.Now(), int64(0)
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case now := <-ticker.C:
+				bytes := transferred()
+				rate := float64(bytes-lastBytes) / now.Sub(last).Seconds()
+				lo.G.Info("%s still running after %s: %d bytes so far, %.0f bytes/s", name, now.Sub(started).Round(time.Second), bytes, rate)
+				publishEvent(Event{Type: EventProgress, Task: name, Bytes: bytes, Total: total, Rate: rate})
+				last, lastBytes = now, bytes
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,177 @@
+package cfops
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// LockFileName is the marker left in the destination while a run owns it
+	LockFileName      = "cfops.lock"
+	ErrLockHeldFormat = "%s is locked by a %s started %s by pid %d on %s (remove %s or use --breaklock if that run is gone)"
+)
+
+var lockNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+type (
+	// RunLock guards a foundation and its destination against concurrent runs
+	RunLock struct {
+		paths []string
+	}
+
+	lockOwner struct {
+		Pid      int       `json:"pid"`
+		Hostname string    `json:"hostname"`
+		Action   string    `json:"action"`
+		Started  time.Time `json:"started"`
+	}
+)
+
+func ErrLockHeld(target string, owner lockOwner, lockPath string) error {
+	return fmt.Errorf(ErrLockHeldFormat, target, owner.Action, owner.Started.Format(time.RFC3339), owner.Pid, owner.Hostname, lockPath)
+}
+
+// AcquireLock takes a local lock for the foundation in lockDir and places a
+// marker in the destination. Either being held by another live run fails the
+// acquisition; breakLock discards existing locks first
+func AcquireLock(lockDir, foundation, destination, action string, breakLock bool) (lock *RunLock, err error) {
+	var (
+		paths []string
+		owner = currentOwner(action)
+	)
+	lock = &RunLock{}
+
+	if lockDir != "" {
+		paths = append(paths, path.Join(lockDir, lockNameSanitizer.ReplaceAllString(foundation, "_")+".lock"))
+	}
+
+	if destination != "" {
+		paths = append(paths, path.Join(destination, LockFileName))
+	}
+
+	for _, lockPath := range paths {
+		if breakLock {
+			os.Remove(lockPath)
+		}
+
+		if err = createLock(lockPath, owner); err != nil {
+			lock.Release()
+			return nil, err
+		}
+		lock.paths = append(lock.paths, lockPath)
+	}
+	return
+}
+
+// Release removes every lock this run holds
+func (s *RunLock) Release() (err error) {
+	for _, lockPath := range s.paths {
+		if rmErr := os.Remove(lockPath); rmErr != nil && !os.IsNotExist(rmErr) {
+			err = rmErr
+		}
+	}
+	s.paths = nil
+	return
+}
+
+// createLock creates the lock file, taking over one left by a process that is
+// gone. The directory of the lock is locked meanwhile, so that two runs
+// finding the same stale lock can not both remove it and each take the lock
+func createLock(lockPath string, owner lockOwner) (err error) {
+	var (
+		file     *os.File
+		contents []byte
+		unlock   func()
+	)
+
+	if err = os.MkdirAll(path.Dir(lockPath), 0700); err != nil {
+		return
+	}
+
+	if unlock, err = lockDirectory(path.Dir(lockPath)); err != nil {
+		return
+	}
+	defer unlock()
+
+	if file, err = os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); os.IsExist(err) {
+		var holder lockOwner
+
+		if contents, err = ioutil.ReadFile(lockPath); err == nil {
+			json.Unmarshal(contents, &holder)
+		}
+
+		if !isStale(holder) {
+			return ErrLockHeld(path.Dir(lockPath), holder, lockPath)
+		}
+		lo.G.Info("Removing stale lock " + lockPath)
+		os.Remove(lockPath)
+		file, err = os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+
+	if err == nil {
+		defer file.Close()
+
+		if contents, err = json.Marshal(owner); err == nil {
+			_, err = file.Write(contents)
+		}
+	}
+	return
+}
+
+// lockDirectory takes an exclusive lock on the directory, held until the
+// returned function is called
+func lockDirectory(dir string) (unlock func(), err error) {
+	var file *os.File
+
+	if file, err = os.Open(dir); err != nil {
+		return
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+func currentOwner(action string) lockOwner {
+	hostname, _ := os.Hostname()
+	return lockOwner{
+		Pid:      os.Getpid(),
+		Hostname: hostname,
+		Action:   action,
+		Started:  time.Now().UTC(),
+	}
+}
+
+// isStale only trusts a lock to be abandoned when it was taken on this host
+// by a process that no longer exists
+func isStale(holder lockOwner) bool {
+	hostname, _ := os.Hostname()
+
+	if holder.Pid == 0 || holder.Hostname != hostname {
+		return false
+	}
+	return !processAlive(holder.Pid)
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+
+	if err == nil {
+		err = process.Signal(syscall.Signal(0))
+	}
+	return err == nil || !(errors.Is(err, os.ErrProcessDone) || err == syscall.ESRCH)
+}
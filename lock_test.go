@@ -0,0 +1,99 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AcquireLock", func() {
+	var (
+		lockDir     string
+		destination string
+	)
+
+	BeforeEach(func() {
+		lockDir, _ = ioutil.TempDir("", "locks")
+		destination, _ = ioutil.TempDir("", "destination")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(lockDir)
+		os.RemoveAll(destination)
+	})
+
+	It("should lock the foundation locally and mark the destination", func() {
+		lock, err := AcquireLock(lockDir, "opsman.example.com", destination, Backup, false)
+		Ω(err).Should(BeNil())
+		Ω(path.Join(lockDir, "opsman.example.com.lock")).Should(BeAnExistingFile())
+		Ω(path.Join(destination, LockFileName)).Should(BeAnExistingFile())
+		Ω(lock.Release()).Should(BeNil())
+		Ω(path.Join(destination, LockFileName)).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should refuse a second run against the same foundation", func() {
+		lock, _ := AcquireLock(lockDir, "opsman.example.com", destination, Backup, false)
+		defer lock.Release()
+		other, _ := ioutil.TempDir("", "other")
+		defer os.RemoveAll(other)
+
+		_, err := AcquireLock(lockDir, "opsman.example.com", other, Restore, false)
+		Ω(err).ShouldNot(BeNil())
+		Ω(path.Join(other, LockFileName)).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should refuse a second run writing to the same destination", func() {
+		lock, _ := AcquireLock(lockDir, "opsman.example.com", destination, Backup, false)
+		defer lock.Release()
+
+		_, err := AcquireLock(lockDir, "other.example.com", destination, Backup, false)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should take over a lock whose process is gone", func() {
+		hostname, _ := os.Hostname()
+		stale, _ := json.Marshal(map[string]interface{}{"pid": 1 << 30, "hostname": hostname})
+		ioutil.WriteFile(path.Join(destination, LockFileName), stale, 0600)
+
+		lock, err := AcquireLock(lockDir, "opsman.example.com", destination, Backup, false)
+		Ω(err).Should(BeNil())
+		lock.Release()
+	})
+
+	It("should let only one of the runs finding a stale lock take it over", func() {
+		hostname, _ := os.Hostname()
+		stale, _ := json.Marshal(map[string]interface{}{"pid": 1 << 30, "hostname": hostname})
+		ioutil.WriteFile(path.Join(destination, LockFileName), stale, 0600)
+		taken := make(chan *RunLock)
+
+		for i := 0; i < 8; i++ {
+			go func() {
+				lock, _ := AcquireLock("", "opsman.example.com", destination, Backup, false)
+				taken <- lock
+			}()
+		}
+		var held []*RunLock
+
+		for i := 0; i < 8; i++ {
+			if lock := <-taken; lock != nil {
+				held = append(held, lock)
+			}
+		}
+		Ω(held).Should(HaveLen(1))
+		held[0].Release()
+	})
+
+	It("should break a held lock when asked to", func() {
+		lock, _ := AcquireLock(lockDir, "opsman.example.com", destination, Backup, false)
+		defer lock.Release()
+
+		_, err := AcquireLock(lockDir, "opsman.example.com", destination, Backup, true)
+		Ω(err).Should(BeNil())
+	})
+})
@@ -0,0 +1,223 @@
+package cfops
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ErrByteSizeFormat = "%q is not a size, expected bytes such as 100MB or 1048576"
+	// rotatedLogFormat stamps a rotated log file with the time it was
+	// rotated, so that the rotated files sort oldest first
+	rotatedLogFormat = "20060102T150405"
+	// rotateRetry is how long a log file that could not be rotated is
+	// written on before rotating it is tried again
+	rotateRetry = time.Minute
+)
+
+type (
+	// LogFileConfig describes a log file rotated once it would grow past
+	// MaxSize bytes, and at every multiple of Interval, e.g. daily at
+	// midnight UTC with 24h. The Keep most recent rotated files are kept. A
+	// zero MaxSize or Interval never rotates for it, and a zero Keep keeps
+	// every rotated file
+	LogFileConfig struct {
+		Path     string
+		MaxSize  int64
+		Interval time.Duration
+		Keep     int
+	}
+
+	// rotatingFile appends to the log file, moving it aside as its config
+	// says
+	rotatingFile struct {
+		config LogFileConfig
+		mutex  sync.Mutex
+		file   *os.File
+		size   int64
+		// period is the start of the interval the file was written in
+		period time.Time
+		// retryAt is when a rotation that failed is tried again, and
+		// rotateFailed whether that failure was reported
+		retryAt      time.Time
+		rotateFailed bool
+	}
+)
+
+func ErrByteSize(size string) error {
+	return fmt.Errorf(ErrByteSizeFormat, size)
+}
+
+// ParseByteSize reads a size such as 100MB or 1048576, in binary units. An
+// empty size is zero
+func ParseByteSize(size string) (bytes int64, err error) {
+	if strings.HasSuffix(strings.ToUpper(strings.TrimSpace(size)), "/S") {
+		return 0, ErrByteSize(size)
+	}
+
+	if bytes, err = ParseByteRate(size); err != nil {
+		err = ErrByteSize(size)
+	}
+	return
+}
+
+// OpenLogFile opens the log file for appending, creating it and its
+// directory when missing, and rotates it as the config says while it is
+// written to
+func OpenLogFile(config LogFileConfig) (log io.WriteCloser, err error) {
+	file := &rotatingFile{config: config}
+
+	if err = os.MkdirAll(path.Dir(config.Path), 0700); err == nil {
+		err = file.open()
+	}
+	return file, err
+}
+
+func (s *rotatingFile) open() (err error) {
+	var info os.FileInfo
+
+	if s.file, err = os.OpenFile(s.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return
+	}
+
+	if info, err = s.file.Stat(); err != nil {
+		return
+	}
+	s.size, s.period = info.Size(), s.periodOf(time.Now())
+
+	// a file left by an earlier process belongs to the interval it was
+	// last written in
+	if s.size > 0 {
+		s.period = s.periodOf(info.ModTime())
+	}
+	return
+}
+
+func (s *rotatingFile) periodOf(t time.Time) time.Time {
+	if s.config.Interval <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(s.config.Interval)
+}
+
+func (s *rotatingFile) Write(p []byte) (n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	full := s.config.MaxSize > 0 && s.size > 0 && s.size+int64(len(p)) > s.config.MaxSize
+
+	if (full || !s.periodOf(now).Equal(s.period)) && !now.Before(s.retryAt) {
+		s.rotateOrWriteOn(now)
+	}
+
+	if s.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err = s.file.Write(p)
+	s.size += int64(n)
+	return
+}
+
+// rotateOrWriteOn rotates the log file, or else writes on to it until
+// rotating it is tried again, reporting the failure on stderr the first time,
+// since the log can not carry it
+func (s *rotatingFile) rotateOrWriteOn(now time.Time) {
+	err := s.rotate(now)
+
+	if err == nil {
+		s.retryAt, s.rotateFailed = time.Time{}, false
+		s.prune()
+		return
+	}
+	s.period, s.retryAt = s.periodOf(now), now.Add(rotateRetry)
+
+	if !s.rotateFailed {
+		fmt.Fprintf(os.Stderr, "unable to rotate the log file %s, writing on to it: %s\n", s.config.Path, err)
+		s.rotateFailed = true
+	}
+}
+
+// rotate moves the log file aside, stamped with the time, and opens a new one
+func (s *rotatingFile) rotate(now time.Time) (err error) {
+	s.file.Close()
+	renameErr := os.Rename(s.config.Path, s.rotatedName(now))
+
+	if err = s.open(); err != nil {
+		s.file = nil
+		return
+	}
+
+	if renameErr == nil {
+		s.period = s.periodOf(now)
+	}
+	return renameErr
+}
+
+// rotatedName is the name the log file is moved to, numbered after the files
+// rotated earlier in the same second so that the names keep sorting oldest
+// first
+func (s *rotatingFile) rotatedName(now time.Time) string {
+	rotated := s.config.Path + "." + now.UTC().Format(rotatedLogFormat)
+	earlier, _ := filepath.Glob(rotated + "*")
+	last := -1
+
+	for _, name := range earlier {
+		var number int
+
+		if name == rotated {
+			number = 0
+		} else if _, err := fmt.Sscanf(strings.TrimPrefix(name, rotated), ".%d", &number); err != nil {
+			continue
+		}
+
+		if number > last {
+			last = number
+		}
+	}
+
+	if last < 0 {
+		return rotated
+	}
+	return fmt.Sprintf("%s.%03d", rotated, last+1)
+}
+
+// prune removes the oldest rotated files beyond those to keep
+func (s *rotatingFile) prune() (err error) {
+	var rotated []string
+
+	if s.config.Keep <= 0 {
+		return
+	}
+
+	if rotated, err = filepath.Glob(s.config.Path + ".[0-9]*"); err != nil {
+		return
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > s.config.Keep {
+		if removeErr := os.Remove(rotated[0]); removeErr != nil && err == nil {
+			err = removeErr
+		}
+		rotated = rotated[1:]
+	}
+	return
+}
+
+func (s *rotatingFile) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
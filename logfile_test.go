@@ -0,0 +1,137 @@
+package cfops_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenLogFile", func() {
+	var (
+		dir     string
+		logPath string
+		log     io.WriteCloser
+	)
+
+	rotated := func() []string {
+		files, _ := filepath.Glob(logPath + ".*")
+		return files
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "logfile")
+		logPath = path.Join(dir, "logs", "cfops.log")
+	})
+
+	AfterEach(func() {
+		if log != nil {
+			log.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	It("should create the log file and its directory", func() {
+		var err error
+		log, err = OpenLogFile(LogFileConfig{Path: logPath})
+		Ω(err).Should(BeNil())
+		io.WriteString(log, "started\n")
+		contents, _ := ioutil.ReadFile(logPath)
+		Ω(string(contents)).Should(Equal("started\n"))
+	})
+
+	It("should append to a log file left by an earlier run", func() {
+		os.MkdirAll(path.Dir(logPath), 0700)
+		ioutil.WriteFile(logPath, []byte("earlier\n"), 0600)
+		log, _ = OpenLogFile(LogFileConfig{Path: logPath, MaxSize: 1024})
+		io.WriteString(log, "later\n")
+		contents, _ := ioutil.ReadFile(logPath)
+		Ω(string(contents)).Should(Equal("earlier\nlater\n"))
+		Ω(rotated()).Should(BeEmpty())
+	})
+
+	It("should rotate the log file before it grows past its size", func() {
+		log, _ = OpenLogFile(LogFileConfig{Path: logPath, MaxSize: 10})
+		io.WriteString(log, "12345678\n")
+		io.WriteString(log, "abcdefgh\n")
+		Ω(rotated()).Should(HaveLen(1))
+		old, _ := ioutil.ReadFile(rotated()[0])
+		Ω(string(old)).Should(Equal("12345678\n"))
+		current, _ := ioutil.ReadFile(logPath)
+		Ω(string(current)).Should(Equal("abcdefgh\n"))
+	})
+
+	It("should keep only the most recent rotated files", func() {
+		log, _ = OpenLogFile(LogFileConfig{Path: logPath, MaxSize: 5, Keep: 2})
+
+		for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+			io.WriteString(log, line)
+		}
+		Ω(rotated()).Should(HaveLen(2))
+		newest, _ := ioutil.ReadFile(rotated()[1])
+		Ω(string(newest)).Should(Equal("three\n"))
+		oldest, _ := ioutil.ReadFile(rotated()[0])
+		Ω(string(oldest)).Should(Equal("two\n"))
+	})
+
+	It("should rotate a log file last written in an earlier interval", func() {
+		os.MkdirAll(path.Dir(logPath), 0700)
+		ioutil.WriteFile(logPath, []byte("yesterday\n"), 0600)
+		yesterday := time.Now().Add(-24 * time.Hour)
+		os.Chtimes(logPath, yesterday, yesterday)
+		log, _ = OpenLogFile(LogFileConfig{Path: logPath, Interval: 24 * time.Hour})
+		io.WriteString(log, "today\n")
+		Ω(rotated()).Should(HaveLen(1))
+		current, _ := ioutil.ReadFile(logPath)
+		Ω(string(current)).Should(Equal("today\n"))
+	})
+
+	It("should write on to a log file it can not rotate, reporting that once", func() {
+		// the rotated name is too long for the file system
+		logPath = path.Join(dir, strings.Repeat("l", 250))
+		stderr := os.Stderr
+		reader, writer, _ := os.Pipe()
+		os.Stderr = writer
+		log, _ = OpenLogFile(LogFileConfig{Path: logPath, MaxSize: 10})
+
+		for _, line := range []string{"12345678\n", "abcdefgh\n", "ijklmnop\n"} {
+			_, err := io.WriteString(log, line)
+			Ω(err).Should(BeNil())
+		}
+		os.Stderr = stderr
+		writer.Close()
+		reported, _ := ioutil.ReadAll(reader)
+		Ω(strings.Count(string(reported), "unable to rotate the log file")).Should(Equal(1))
+		current, _ := ioutil.ReadFile(logPath)
+		Ω(string(current)).Should(Equal("12345678\nabcdefgh\nijklmnop\n"))
+	})
+
+	It("should refuse writes once closed", func() {
+		log, _ = OpenLogFile(LogFileConfig{Path: logPath})
+		log.Close()
+		_, err := io.WriteString(log, "late\n")
+		Ω(err).ShouldNot(BeNil())
+	})
+})
+
+var _ = Describe("ParseByteSize", func() {
+	It("should read sizes in binary units", func() {
+		size, err := ParseByteSize("100MB")
+		Ω(err).Should(BeNil())
+		Ω(size).Should(Equal(int64(100 << 20)))
+	})
+
+	It("should refuse a rate", func() {
+		_, err := ParseByteSize("10MB/s")
+		Ω(err).ShouldNot(BeNil())
+		Ω(strings.Contains(err.Error(), "not a size")).Should(BeTrue())
+	})
+})
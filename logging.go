@@ -0,0 +1,259 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	LogFormatText             = "text"
+	LogFormatJSON             = "json"
+	ErrUnknownLogFormatFormat = "unknown log format %s, expected text or json"
+	ErrUnknownLogLevelFormat  = "unknown log level %s, expected debug, info, notice, warning, error or critical"
+)
+
+var (
+	logContext = &runLogContext{}
+	logMutex   sync.Mutex
+	// consoleBackend is the backend ConfigureLogging chose, nil until it is
+	// called
+	consoleBackend logging.Backend
+	// activeBackends are the backends setBackends installed last, nil while
+	// the default backend of go-logging is in use
+	activeBackends []logging.Backend
+)
+
+type (
+	// Task is a unit of work within a run, a tile or a single transfer, whose
+	// id is attached to every log record written while it is in progress
+	Task struct {
+		ID       string
+		Name     string
+		ParentID string
+		started  time.Time
+		span     *traceSpan
+	}
+
+	runLogContext struct {
+		mutex  sync.Mutex
+		runID  string
+		nextID int
+		tasks  []*Task
+	}
+
+	jsonBackend struct {
+		out io.Writer
+	}
+
+	jsonRecord struct {
+		Time         time.Time `json:"time"`
+		Level        string    `json:"level"`
+		Module       string    `json:"module"`
+		RunID        string    `json:"run_id,omitempty"`
+		TaskID       string    `json:"task_id,omitempty"`
+		Task         string    `json:"task,omitempty"`
+		ParentTaskID string    `json:"parent_task_id,omitempty"`
+		Message      string    `json:"message"`
+	}
+)
+
+func ErrUnknownLogFormat(format string) error {
+	return fmt.Errorf(ErrUnknownLogFormatFormat, format)
+}
+
+func ErrUnknownLogLevel(level string) error {
+	return fmt.Errorf(ErrUnknownLogLevelFormat, level)
+}
+
+// SetLogLevel logs only the records of the level or above, those of the
+// LOG_LEVEL environment variable until it is called
+func SetLogLevel(level string) (err error) {
+	var parsed logging.Level
+
+	if parsed, err = logging.LogLevel(level); err != nil {
+		return ErrUnknownLogLevel(level)
+	}
+	logging.SetLevel(parsed, lo.LOG_MODULE)
+	return
+}
+
+// ConfigureLogging writes log records to out as text, or as json lines when
+// asked to, keeping the configured log level
+func ConfigureLogging(format string, out io.Writer) (err error) {
+	var backend logging.Backend
+
+	switch format {
+	case "", LogFormatText:
+		backend = newTextBackend(out)
+
+	case LogFormatJSON:
+		backend = &jsonBackend{out: out}
+
+	default:
+		return ErrUnknownLogFormat(format)
+	}
+	consoleBackend = backend
+	setBackends(backend)
+	return
+}
+
+// setBackends replaces every log backend, keeping the configured log level
+func setBackends(backends ...logging.Backend) {
+	level := logging.GetLevel(lo.LOG_MODULE)
+	logging.SetBackend(backends...)
+	logging.SetLevel(level, lo.LOG_MODULE)
+	activeBackends = backends
+}
+
+// newTextBackend writes log records the way the default backend of
+// go-logging does
+func newTextBackend(out io.Writer) logging.Backend {
+	return logging.NewLogBackend(out, "", stdlog.LstdFlags)
+}
+
+func (s *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) (err error) {
+	var contents []byte
+	record := jsonRecord{
+		Time:    rec.Time.UTC(),
+		Level:   level.String(),
+		Module:  rec.Module,
+		Message: rec.Message(),
+	}
+	record.RunID, record.TaskID, record.Task, record.ParentTaskID = logContext.current()
+
+	if contents, err = json.Marshal(record); err == nil {
+		logMutex.Lock()
+		defer logMutex.Unlock()
+		_, err = s.out.Write(append(contents, '\n'))
+	}
+	return
+}
+
+// SetRunID tags every following log record with the run, clearing any tasks
+// left over from an earlier run
+func SetRunID(runID string) {
+	logContext.mutex.Lock()
+	defer logContext.mutex.Unlock()
+	logContext.runID = runID
+	logContext.nextID = 0
+	logContext.tasks = nil
+}
+
+// StartTask begins a task nested in the task currently in progress
+func StartTask(name string) (task *Task) {
+	logContext.mutex.Lock()
+	logContext.nextID++
+	task = &Task{
+		ID:      fmt.Sprintf("%s.%d", logContext.runID, logContext.nextID),
+		Name:    name,
+		started: time.Now(),
+	}
+
+	parentSpan := ""
+
+	if len(logContext.tasks) > 0 {
+		parent := logContext.tasks[len(logContext.tasks)-1]
+		task.ParentID, parentSpan = parent.ID, parent.span.id()
+	}
+	task.span = startSpan(name, parentSpan, spanKindInternal)
+	logContext.tasks = append(logContext.tasks, task)
+	logContext.mutex.Unlock()
+
+	lo.G.Info("Starting %s", name)
+	publishEvent(Event{Type: EventTaskStarted})
+	return
+}
+
+// Finish logs the outcome of the task and ends it
+func (s *Task) Finish(err error) {
+	s.span.end(err)
+
+	if err != nil {
+		lo.G.Error("%s failed after %s: %s", s.Name, time.Since(s.started), err)
+		publishEvent(Event{Type: EventTaskFailed, TaskID: s.ID, Task: s.Name, Message: err.Error()})
+
+	} else {
+		lo.G.Info("%s finished after %s", s.Name, time.Since(s.started))
+		publishEvent(Event{Type: EventTaskFinished, TaskID: s.ID, Task: s.Name})
+	}
+	logContext.mutex.Lock()
+	defer logContext.mutex.Unlock()
+
+	for i := len(logContext.tasks) - 1; i >= 0; i-- {
+		if logContext.tasks[i] == s {
+			logContext.tasks = append(logContext.tasks[:i], logContext.tasks[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *runLogContext) current() (runID, taskID, taskName, parentID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	runID = s.runID
+
+	if len(s.tasks) > 0 {
+		task := s.tasks[len(s.tasks)-1]
+		taskID, taskName, parentID = task.ID, task.Name, task.ParentID
+	}
+	return
+}
+
+// taskTracker starts a task for every persistence store a tile transfers,
+// adding the time spent in each phase of a store to the phases of the tile
+type taskTracker struct {
+	tileName  string
+	phases    *phaseTimer
+	heartbeat time.Duration
+	// progress numbers the stores of a restore among its steps
+	progress *RestoreCheckpoint
+	failed   *failedStep
+}
+
+func (s taskTracker) StartStep(step string) func(error) {
+	_, finish := s.startStep(step)
+	return finish
+}
+
+// startStep starts the task of the step, returning it and the function
+// finishing it
+func (s taskTracker) startStep(step string) (*Task, func(error)) {
+	task := StartTask(s.tileName + stepSeparator + step)
+	started := time.Now()
+	finishStep := func(error) {}
+
+	if !strings.Contains(step, stepSeparator) {
+		finishStep = s.progress.StartStep(s.tileName + stepSeparator + step)
+	}
+
+	return task, func(err error) {
+		task.Finish(err)
+		finishStep(err)
+		s.failed.record(step, err)
+
+		if i := strings.LastIndex(step, stepSeparator); i >= 0 {
+			s.phases.add(step[i+1:], time.Since(started))
+		}
+	}
+}
+
+// StartTransfer starts the task of a store being dumped or restored, with a
+// heartbeat following the bytes it has streamed
+func (s taskTracker) StartTransfer(step string, total int64, transferred func() int64) func(error) {
+	task, finish := s.startStep(step)
+	stop := StartHeartbeat(s.tileName+stepSeparator+step, total, transferred, s.heartbeat)
+
+	return func(err error) {
+		stop()
+		task.span.set("cfops.bytes", transferred())
+		finish(err)
+	}
+}
@@ -0,0 +1,109 @@
+package cfops_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	. "github.com/pivotalservices/cfops"
+	"github.com/xchapter7x/lo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigureLogging", func() {
+	var (
+		out   *bytes.Buffer
+		level logging.Level
+	)
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		level = logging.GetLevel(lo.LOG_MODULE)
+	})
+
+	AfterEach(func() {
+		ConfigureLogging(LogFormatText, os.Stderr)
+		logging.SetLevel(level, lo.LOG_MODULE)
+		SetRunID("")
+	})
+
+	records := func() (records []map[string]string) {
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			record := make(map[string]string)
+			Ω(json.Unmarshal([]byte(line), &record)).Should(BeNil())
+			records = append(records, record)
+		}
+		return
+	}
+
+	Context("when json is requested", func() {
+		BeforeEach(func() {
+			Ω(ConfigureLogging(LogFormatJSON, out)).Should(BeNil())
+			SetRunID("run")
+		})
+
+		It("should tag records with the run and the innermost task", func() {
+			tile := StartTask("ER")
+			transfer := StartTask("ER/ccdb")
+			lo.G.Info("dumping")
+			transfer.Finish(nil)
+			tile.Finish(errors.New("failed"))
+
+			logged := records()
+			Ω(logged).Should(HaveLen(5))
+			Ω(logged[2]["message"]).Should(Equal("dumping"))
+			Ω(logged[2]["run_id"]).Should(Equal("run"))
+			Ω(logged[2]["task_id"]).Should(Equal(transfer.ID))
+			Ω(logged[2]["parent_task_id"]).Should(Equal(tile.ID))
+			Ω(logged[4]["task_id"]).Should(Equal(tile.ID))
+			Ω(logged[4]["level"]).Should(Equal("ERROR"))
+		})
+
+		It("should not tag records once a task finished", func() {
+			StartTask("OPSMANAGER").Finish(nil)
+			lo.G.Info("done")
+			Ω(records()[2]["task_id"]).Should(BeEmpty())
+		})
+
+		It("should log the progress of a transfer until it is stopped", func() {
+			transfer := StartTask("ER/ccdb/dump")
+			stop := StartHeartbeat(transfer.Name, 0, func() int64 { return 2048 }, 10*time.Millisecond)
+			time.Sleep(35 * time.Millisecond)
+			stop()
+			transfer.Finish(nil)
+
+			logged := records()
+			Ω(len(logged)).Should(BeNumerically(">=", 4))
+			Ω(logged[1]["message"]).Should(MatchRegexp(`^ER/ccdb/dump still running after .*: 2048 bytes so far, \d+ bytes/s$`))
+			Ω(logged[1]["task_id"]).Should(Equal(transfer.ID))
+			Ω(logged[2]["message"]).Should(HaveSuffix("2048 bytes so far, 0 bytes/s"))
+		})
+	})
+
+	Context("when the format is unknown", func() {
+		It("should return an unknown log format error", func() {
+			Ω(ConfigureLogging("xml", out)).Should(Equal(ErrUnknownLogFormat("xml")))
+		})
+	})
+
+	Context("when a log level is set", func() {
+		It("should only log records of that level or above, whatever the format", func() {
+			Ω(SetLogLevel("warning")).Should(BeNil())
+			Ω(ConfigureLogging(LogFormatJSON, out)).Should(BeNil())
+			lo.G.Info("left out")
+			lo.G.Warning("kept")
+			Ω(records()).Should(HaveLen(1))
+			Ω(records()[0]["message"]).Should(Equal("kept"))
+		})
+
+		It("should return an unknown log level error", func() {
+			Ω(SetLogLevel("verbose")).Should(Equal(ErrUnknownLogLevel("verbose")))
+		})
+	})
+})
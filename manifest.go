@@ -0,0 +1,208 @@
+package cfops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestName is the file in the destination describing a complete
+	// backup and each of its artifacts
+	ManifestName = "cfops-manifest.json"
+	// ManifestFormatVersion is the layout of the destination the manifest
+	// describes
+	ManifestFormatVersion = 1
+
+	ErrAnotherRunFormat    = "%s holds the artifacts of run %s, which was interrupted; resume it, back up into another destination, e.g. with --versioned, or give --overwrite to replace them"
+	ErrPartialBackupFormat = "%s holds a partial backup without %s, restore the stores it has with --components, or resume the backup to fill it in"
+)
+
+func ErrAnotherRun(destination, runID string) error {
+	return fmt.Errorf(ErrAnotherRunFormat, destination, runID)
+}
+
+type (
+	// Manifest describes a backup: who took it and the size and sha256 of each
+	// artifact. A synthesized manifest was written when converting a backup
+	// of an earlier cfops version rather than when it was taken
+	Manifest struct {
+		FormatVersion int       `json:"format_version"`
+		Created       time.Time `json:"created"`
+		RunID         string    `json:"run_id,omitempty"`
+		Foundation    string    `json:"foundation,omitempty"`
+		Synthesized   bool      `json:"synthesized,omitempty"`
+		// Partial is set when the backup left out the stores whose vms it
+		// could not reach, the Unreachable ones
+		Partial     bool     `json:"partial,omitempty"`
+		Unreachable []string `json:"unreachable,omitempty"`
+		// Snapshots are the iaas snapshots of the disks of the stores the
+		// backup has no archives of
+		Snapshots []Snapshot         `json:"snapshots,omitempty"`
+		Artifacts []ManifestArtifact `json:"artifacts"`
+	}
+
+	ManifestArtifact struct {
+		Name   string `json:"name"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+)
+
+// NewManifest describes the named artifacts found in the destination
+func NewManifest(destination string, artifacts []string) (manifest Manifest, err error) {
+	manifest = Manifest{FormatVersion: ManifestFormatVersion, Created: time.Now().UTC()}
+
+	for _, name := range artifacts {
+		var artifact ManifestArtifact
+
+		if _, statErr := os.Stat(path.Join(destination, name)); statErr != nil {
+			continue
+		}
+
+		if artifact, err = describeArtifact(destination, name); err != nil {
+			return
+		}
+		manifest.Artifacts = append(manifest.Artifacts, artifact)
+	}
+	return
+}
+
+func describeArtifact(destination, name string) (artifact ManifestArtifact, err error) {
+	var file *os.File
+
+	if file, err = os.Open(path.Join(destination, name)); err != nil {
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+
+	if artifact.Size, err = io.Copy(hash, file); err == nil {
+		artifact.Name = name
+		artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+	return
+}
+
+// WriteManifest atomically writes the manifest into the destination
+func WriteManifest(destination string, manifest Manifest) (err error) {
+	var contents []byte
+
+	if contents, err = json.MarshalIndent(manifest, "", "  "); err != nil {
+		return
+	}
+	tmp := path.Join(destination, ManifestName+".tmp")
+
+	if err = ioutil.WriteFile(tmp, contents, 0600); err == nil {
+		err = os.Rename(tmp, path.Join(destination, ManifestName))
+	}
+	return
+}
+
+// LoadManifest reads the manifest of the backup in the destination
+func LoadManifest(destination string) (manifest Manifest, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(path.Join(destination, ManifestName)); err == nil {
+		err = json.Unmarshal(contents, &manifest)
+	}
+	return
+}
+
+// loadBackupManifest is LoadManifest, reading the manifest from the archive
+// the backup was packed into when it is not next to it
+func loadBackupManifest(destination string) (manifest Manifest, err error) {
+	var (
+		archive  *Archive
+		closer   io.Closer
+		contents io.ReadCloser
+	)
+
+	if manifest, err = LoadManifest(destination); err == nil || !os.IsNotExist(err) {
+		return
+	}
+
+	if archive, closer, err = OpenArchiveFile(path.Join(destination, ArchiveName)); err != nil {
+		return
+	}
+	defer closer.Close()
+
+	if contents, err = archive.Open(ManifestName); err == nil {
+		defer contents.Close()
+		err = json.NewDecoder(contents).Decode(&manifest)
+	}
+	return
+}
+
+func ErrPartialBackup(destination string, unreachable []string) error {
+	return fmt.Errorf(ErrPartialBackupFormat, destination, strings.Join(unreachable, ", "))
+}
+
+// checkPartialBackup refuses to restore the whole elastic runtime from a
+// partial backup, which has no archives of the stores it left out. The
+// manifest of an archived backup is read from the archive, which is refused
+// when it has none, as is a manifest that can not be read
+func checkPartialBackup(fs flagSet) (err error) {
+	var manifest Manifest
+
+	if manifest, err = loadBackupManifest(fs.Dest()); os.IsNotExist(err) {
+		return nil
+	} else if err != nil || !manifest.Partial {
+		return
+	}
+	restoresER := fs.Tilelist() == ""
+
+	for _, tileName := range formatArray(strings.Split(fs.Tilelist(), ",")) {
+		restoresER = restoresER || tileName == ER
+	}
+
+	if restoresER && fs.Components() == "" {
+		return ErrPartialBackup(fs.Dest(), manifest.Unreachable)
+	}
+	return
+}
+
+// DestinationRun is the id of the run the artifacts in the destination belong
+// to: the run its manifest describes, whether next to the artifacts or packed
+// into their archive, or else the interrupted backup its checkpoint records.
+// It is empty when neither tells
+func DestinationRun(destination string) string {
+	if manifest, err := loadBackupManifest(destination); err == nil && manifest.RunID != "" {
+		return manifest.RunID
+	}
+	return interruptedRun(destination)
+}
+
+// interruptedRun is the id of the backup that was interrupted writing into
+// the destination once it completed a step, or empty when there is none
+func interruptedRun(destination string) string {
+	if checkpoint, err := openCheckpoint(path.Join(destination, BackupCheckpointFileName)); err == nil && len(checkpoint.Steps) > 0 {
+		return checkpoint.RunID
+	}
+	return ""
+}
+
+// writeBackupManifest describes the artifacts a complete backup run wrote
+func writeBackupManifest(destination string, entry *CatalogEntry) (manifest Manifest, err error) {
+	if manifest, err = NewManifest(destination, append(append(setArtifacts(entry), entry.DeploymentManifests...), RunLogName, DiagnosticsName)); err == nil {
+		manifest.RunID, manifest.Foundation = entry.ID, entry.Foundation
+		manifest.Partial, manifest.Unreachable = entry.Status == SetPartial, entry.Unreachable()
+		manifest.Snapshots = entry.Snapshots
+		err = WriteManifest(destination, manifest)
+	}
+	return
+}
+
+// backupArtifacts are the artifacts of the run, its log when it was shipped, the diagnostics of ops manager and the deployment manifests
+// when captured, and the manifest describing them: the files kept together
+// wherever a backup is shipped
+func backupArtifacts(entry *CatalogEntry) []string {
+	return append(append(setArtifacts(entry), entry.DeploymentManifests...), RunLogName, DiagnosticsName, ManifestName)
+}
@@ -0,0 +1,177 @@
+package cfops
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMetadataCacheTTL is how long what ops manager and the director
+	// report stays cached when no ttl is given
+	DefaultMetadataCacheTTL  = time.Hour
+	ErrMetadataCacheShortMsg = "the metadata cache is too short to have been sealed"
+	deployedProductsCacheKey = "deployed_products"
+)
+
+var ErrMetadataCacheShort = errors.New(ErrMetadataCacheShortMsg)
+
+type (
+	// MetadataCacheConfig describes where the metadata of a foundation is
+	// cached between runs. The cache is sealed with the key, with the ops
+	// manager credentials of the run when none is given, so that changing
+	// them discards the cache
+	MetadataCacheConfig struct {
+		Path string
+		TTL  time.Duration
+		Key  string
+	}
+
+	// MetadataCache keeps what ops manager and the bosh director report about
+	// a foundation between runs, such as the deployed products and the cloud
+	// controller jobs, so that repeated runs and plans need not ask them
+	// again. It is encrypted, and bound to the ops manager host: a cache of
+	// another foundation, or sealed with another key, is discarded
+	MetadataCache struct {
+		path    string
+		host    string
+		ttl     time.Duration
+		aead    cipher.AEAD
+		mutex   sync.Mutex
+		entries map[string]metadataEntry
+	}
+
+	metadataEntry struct {
+		Value   json.RawMessage `json:"value"`
+		Expires time.Time       `json:"expires"`
+	}
+)
+
+// Enabled tells whether metadata is cached at all
+func (s MetadataCacheConfig) Enabled() bool {
+	return s.Path != ""
+}
+
+// openMetadataCache opens the metadata cache the flags configure, nil when
+// they configure none. A cache that can not be read is warned about and
+// started over, it is never worth failing a run for
+func openMetadataCache(fs flagSet) *MetadataCache {
+	config := fs.MetadataCache()
+
+	if !config.Enabled() {
+		return nil
+	}
+
+	if config.Key == "" {
+		config.Key = fs.AdminUser() + "\x00" + fs.AdminPass() + "\x00" + fs.OpsManagerPass()
+	}
+	cache, err := OpenMetadataCache(config, fs.Host())
+
+	if err != nil {
+		warn("not caching the metadata of %s: %s", fs.Host(), err)
+		return nil
+	}
+	return cache
+}
+
+// OpenMetadataCache reads the metadata cache of the ops manager host, empty
+// when there is none yet or it was sealed for another host or with another
+// key
+func OpenMetadataCache(config MetadataCacheConfig, host string) (cache *MetadataCache, err error) {
+	var sealed []byte
+	cache = &MetadataCache{path: config.Path, host: host, ttl: config.TTL, entries: map[string]metadataEntry{}}
+
+	if cache.ttl <= 0 {
+		cache.ttl = DefaultMetadataCacheTTL
+	}
+
+	if cache.aead, err = newAEAD(config.Key); err != nil {
+		return nil, err
+	}
+
+	if sealed, err = ioutil.ReadFile(config.Path); os.IsNotExist(err) {
+		return cache, nil
+
+	} else if err != nil {
+		return nil, err
+	}
+
+	if openErr := cache.open(sealed); openErr != nil {
+		warn("discarding the metadata cache %s: %s", config.Path, openErr)
+		cache.entries = map[string]metadataEntry{}
+	}
+	return cache, nil
+}
+
+// Get fills v with the value cached under the key, returning false when there
+// is none or it has expired
+func (s *MetadataCache) Get(key string, v interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[key]
+	return ok && time.Now().Before(entry.Expires) && json.Unmarshal(entry.Value, v) == nil
+}
+
+// Put caches v under the key for the ttl of the cache, and saves the cache
+func (s *MetadataCache) Put(key string, v interface{}) (err error) {
+	var value []byte
+
+	if value, err = json.Marshal(v); err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries[key] = metadataEntry{Value: value, Expires: time.Now().Add(s.ttl)}
+	return s.save()
+}
+
+func (s *MetadataCache) open(sealed []byte) (err error) {
+	var contents []byte
+	size := s.aead.NonceSize()
+
+	if len(sealed) < size {
+		return ErrMetadataCacheShort
+	}
+
+	if contents, err = s.aead.Open(nil, sealed[:size], sealed[size:], []byte(s.host)); err == nil {
+		err = json.Unmarshal(contents, &s.entries)
+	}
+	return
+}
+
+// save seals the entries that have not expired, replacing the cache file
+// atomically
+func (s *MetadataCache) save() (err error) {
+	var contents []byte
+	nonce := make([]byte, s.aead.NonceSize())
+
+	for key, entry := range s.entries {
+		if !time.Now().Before(entry.Expires) {
+			delete(s.entries, key)
+		}
+	}
+
+	if contents, err = json.Marshal(s.entries); err != nil {
+		return
+	}
+
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Dir(s.path), 0700); err == nil {
+		tmp := s.path + ".tmp"
+
+		if err = ioutil.WriteFile(tmp, s.aead.Seal(nonce, nonce, contents, []byte(s.host)), 0600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	return
+}
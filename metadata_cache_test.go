@@ -0,0 +1,90 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MetadataCache", func() {
+	var (
+		dir    string
+		config MetadataCacheConfig
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "metadata")
+		config = MetadataCacheConfig{Path: path.Join(dir, "metadata", "opsman.example.com.cache"), Key: "secret"}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	put := func(config MetadataCacheConfig, host string) {
+		cache, err := OpenMetadataCache(config, host)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cache.Put("director", map[string]string{"ip": "10.0.0.5", "user": "director"})).Should(Succeed())
+	}
+
+	It("should keep what is put in it between runs, encrypted", func() {
+		var director map[string]string
+		put(config, "opsman.example.com")
+
+		cache, err := OpenMetadataCache(config, "opsman.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cache.Get("director", &director)).Should(BeTrue())
+		Ω(director).Should(Equal(map[string]string{"ip": "10.0.0.5", "user": "director"}))
+
+		contents, _ := ioutil.ReadFile(config.Path)
+		Ω(string(contents)).ShouldNot(ContainSubstring("10.0.0.5"))
+		stat, _ := os.Stat(config.Path)
+		Ω(stat.Mode().Perm()).Should(Equal(os.FileMode(0600)))
+	})
+
+	It("should discard a cache sealed with another key", func() {
+		var director map[string]string
+		put(config, "opsman.example.com")
+		config.Key = "rotated"
+
+		cache, err := OpenMetadataCache(config, "opsman.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cache.Get("director", &director)).Should(BeFalse())
+	})
+
+	It("should discard a cache of another foundation", func() {
+		var director map[string]string
+		put(config, "opsman.example.com")
+
+		cache, err := OpenMetadataCache(config, "opsman.other.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cache.Get("director", &director)).Should(BeFalse())
+	})
+
+	It("should not return what has outlived its ttl", func() {
+		var director map[string]string
+		config.TTL = 10 * time.Millisecond
+		put(config, "opsman.example.com")
+		time.Sleep(20 * time.Millisecond)
+
+		cache, _ := OpenMetadataCache(config, "opsman.example.com")
+		Ω(cache.Get("director", &director)).Should(BeFalse())
+	})
+
+	It("should start over from a file that is not a cache", func() {
+		var director map[string]string
+		os.MkdirAll(path.Dir(config.Path), 0700)
+		ioutil.WriteFile(config.Path, []byte("short"), 0600)
+
+		cache, err := OpenMetadataCache(config, "opsman.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cache.Get("director", &director)).Should(BeFalse())
+		Ω(cache.Put("director", map[string]string{})).Should(Succeed())
+	})
+})
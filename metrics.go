@@ -0,0 +1,247 @@
+package cfops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ErrPushGatewayFormat = "pushgateway %s responded with %s"
+	metricsJob           = "cfops"
+	metricsContentType   = "text/plain; version=0.0.4"
+)
+
+var metricsClient = &http.Client{Timeout: 30 * time.Second}
+
+func ErrPushGateway(gateway, status string) error {
+	return fmt.Errorf(ErrPushGatewayFormat, gateway, status)
+}
+
+type metric struct {
+	name   string
+	help   string
+	labels [][2]string
+	value  float64
+}
+
+// WriteMetrics renders a finished run in the prometheus text exposition
+// format. lastSuccess is omitted when it is zero
+func WriteMetrics(w io.Writer, entry *CatalogEntry, lastSuccess time.Time) (err error) {
+	return writeMetricFamilies(w, runMetrics(entry, lastSuccess))
+}
+
+// runMetrics is the metric set of a finished run, shared by every sink
+func runMetrics(entry *CatalogEntry, lastSuccess time.Time) []metric {
+	var (
+		runLabels = [][2]string{{"action", entry.Action}}
+		seconds   = entry.Finished.Sub(entry.Started).Seconds()
+		metrics   = []metric{
+			{"cfops_run_duration_seconds", "Duration of the last run", runLabels, seconds},
+			{"cfops_run_success", "Whether the last run completed every component", runLabels, boolValue(entry.Status == SetComplete)},
+			{"cfops_run_bytes", "Size of the artifacts written or read by the last run", runLabels, float64(entry.Bytes())},
+			{"cfops_run_throughput_bytes_per_second", "Artifact bytes per second of the last run", runLabels, throughput(entry.Bytes(), seconds)},
+			{"cfops_run_finished_timestamp_seconds", "When the last run finished", runLabels, unixSeconds(entry.Finished)},
+		}
+	)
+
+	if !lastSuccess.IsZero() {
+		metrics = append(metrics, metric{"cfops_last_success_timestamp_seconds", "When the last complete run finished", runLabels, unixSeconds(lastSuccess)})
+	}
+
+	if transfer := entry.Transfer; transfer != nil {
+		metrics = append(metrics,
+			metric{"cfops_sftp_bytes", "Bytes of the sftp packets of the last run", [][2]string{{"action", entry.Action}, {"direction", "sent"}}, float64(transfer.BytesSent)},
+			metric{"cfops_sftp_bytes", "Bytes of the sftp packets of the last run", [][2]string{{"action", entry.Action}, {"direction", "received"}}, float64(transfer.BytesReceived)},
+			metric{"cfops_sftp_retransmits", "Writes the last run sent again after the sftp server failed them", runLabels, float64(transfer.Retransmits)},
+		)
+
+		for _, name := range transfer.packetTypes() {
+			metrics = append(metrics, metric{"cfops_sftp_packets", "Sftp packets the last run sent, by type", [][2]string{{"action", entry.Action}, {"type", name}}, float64(transfer.Packets[name])})
+		}
+	}
+
+	for _, c := range entry.Components {
+		labels := [][2]string{{"action", entry.Action}, {"tile", c.Name}}
+		metrics = append(metrics,
+			metric{"cfops_tile_duration_seconds", "Duration of each tile in the last run", labels, c.Seconds},
+			metric{"cfops_tile_bytes", "Size of the artifacts of each tile in the last run", labels, float64(c.Bytes)},
+			metric{"cfops_tile_failed", "Whether each tile failed in the last run", labels, boolValue(c.Status == ComponentFailed)},
+		)
+	}
+	return metrics
+}
+
+func writeMetricFamilies(w io.Writer, metrics []metric) (err error) {
+	written := make(map[string]bool)
+
+	for _, family := range metrics {
+		if written[family.name] {
+			continue
+		}
+		written[family.name] = true
+
+		if _, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", family.name, family.help, family.name); err != nil {
+			return
+		}
+
+		for _, m := range metrics {
+			if m.name == family.name {
+				if _, err = fmt.Fprintf(w, "%s{%s} %g\n", m.name, formatLabels(m.labels), m.value); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+func formatLabels(labels [][2]string) string {
+	var formatted []string
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	for _, label := range labels {
+		formatted = append(formatted, fmt.Sprintf(`%s="%s"`, label[0], escaper.Replace(label[1])))
+	}
+	return strings.Join(formatted, ",")
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func throughput(bytes int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(bytes) / seconds
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// WriteMetricsFile atomically replaces a node exporter textfile with the
+// metrics of the run
+func WriteMetricsFile(metricsFile string, entry *CatalogEntry, lastSuccess time.Time) (err error) {
+	var buffer bytes.Buffer
+
+	if err = WriteMetrics(&buffer, entry, lastSuccess); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Dir(metricsFile), 0755); err == nil {
+		tmp := metricsFile + ".tmp"
+
+		if err = ioutil.WriteFile(tmp, buffer.Bytes(), 0644); err == nil {
+			err = os.Rename(tmp, metricsFile)
+		}
+	}
+	return
+}
+
+// PushMetrics replaces the metrics of the action for the foundation on a
+// prometheus pushgateway
+func PushMetrics(gateway, foundation string, entry *CatalogEntry, lastSuccess time.Time) (err error) {
+	var (
+		buffer   bytes.Buffer
+		request  *http.Request
+		response *http.Response
+	)
+
+	if err = WriteMetrics(&buffer, entry, lastSuccess); err != nil {
+		return
+	}
+	pushURL := fmt.Sprintf("%s/metrics/job/%s/action/%s/foundation/%s", strings.TrimRight(gateway, "/"), metricsJob, url.PathEscape(entry.Action), url.PathEscape(foundation))
+
+	if request, err = http.NewRequest("PUT", pushURL, &buffer); err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", metricsContentType)
+
+	if response, err = metricsClient.Do(request); err == nil {
+		defer response.Body.Close()
+
+		if response.StatusCode/100 != 2 {
+			err = ErrPushGateway(gateway, response.Status)
+		}
+	}
+	return
+}
+
+// publishMetrics writes and pushes the metrics of a finished run wherever the
+// operator asked for them. Failing to publish never fails the run
+func publishMetrics(fs flagSet, entry *CatalogEntry, catalog *Catalog) {
+	var lastSuccess time.Time
+
+	if fs.MetricsFile() == "" && fs.PushGateway() == "" && fs.StatsdAddress() == "" && !fs.CloudWatch().Enabled() {
+		return
+	}
+
+	if catalog != nil {
+		lastSuccess = catalog.LastSuccess(entry.Action)
+
+	} else if entry.Status == SetComplete {
+		lastSuccess = entry.Finished
+	}
+
+	if fs.MetricsFile() != "" {
+		if err := WriteMetricsFile(fs.MetricsFile(), entry, lastSuccess); err != nil {
+			warn("unable to write metrics file: %s", err)
+		}
+	}
+
+	if fs.PushGateway() != "" {
+		if err := PushMetrics(fs.PushGateway(), fs.Host(), entry, lastSuccess); err != nil {
+			warn("unable to push metrics: %s", err)
+		}
+	}
+
+	if fs.StatsdAddress() != "" {
+		if err := SendStatsd(fs.StatsdAddress(), entry, lastSuccess); err != nil {
+			warn("unable to send metrics to statsd: %s", err)
+		}
+	}
+
+	if fs.CloudWatch().Enabled() {
+		if err := PutCloudWatchMetrics(fs.CloudWatch(), fs.Host(), entry, lastSuccess); err != nil {
+			warn("unable to put metrics to cloudwatch: %s", err)
+		}
+	}
+}
+
+// artifactBytes sums the size of the artifacts a tile, or the selected
+// components of the elastic runtime, has in the destination
+func artifactBytes(destination, tileName, components string) (total int64) {
+	var artifacts []string
+
+	switch {
+	case tileName == AllTiles:
+		artifacts = append(append([]string{}, BackupArtifacts[OpsMgr]...), BackupArtifacts[ER]...)
+
+	case tileName == ER && components != "":
+		for _, component := range strings.Split(components, ",") {
+			artifacts = append(artifacts, erArtifact(strings.ToLower(strings.TrimSpace(component))))
+		}
+
+	default:
+		artifacts = BackupArtifacts[tileName]
+	}
+
+	for _, artifact := range artifacts {
+		if info, err := os.Stat(path.Join(destination, artifact)); err == nil {
+			total += info.Size()
+		}
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package cfops_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var entry *CatalogEntry
+
+	BeforeEach(func() {
+		started := time.Unix(1000, 0)
+		entry = &CatalogEntry{
+			Action:   Backup,
+			Status:   SetIncomplete,
+			Started:  started,
+			Finished: started.Add(10 * time.Second),
+			Components: []ComponentResult{
+				{Name: OpsMgr, Status: ComponentSucceeded, Seconds: 4, Bytes: 100},
+				{Name: ER, Status: ComponentFailed, Seconds: 6, Bytes: 900},
+			},
+		}
+	})
+
+	Describe("WriteMetrics", func() {
+		It("should render the run and every tile as gauges", func() {
+			var out bytes.Buffer
+			Ω(WriteMetrics(&out, entry, time.Unix(500, 0))).Should(BeNil())
+			Ω(out.String()).Should(ContainSubstring("# TYPE cfops_run_duration_seconds gauge\ncfops_run_duration_seconds{action=\"backup\"} 10\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_run_success{action=\"backup\"} 0\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_run_throughput_bytes_per_second{action=\"backup\"} 100\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_last_success_timestamp_seconds{action=\"backup\"} 500\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_tile_failed{action=\"backup\",tile=\"ER\"} 1\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_tile_bytes{action=\"backup\",tile=\"OPSMANAGER\"} 100\n"))
+		})
+
+		It("should render the sftp traffic of the run", func() {
+			var out bytes.Buffer
+			entry.Transfer = &TransferStats{BytesSent: 4096, BytesReceived: 512, Retransmits: 2, Packets: map[string]uint64{"SSH_FXP_WRITE": 3}}
+			WriteMetrics(&out, entry, time.Time{})
+			Ω(out.String()).Should(ContainSubstring("cfops_sftp_bytes{action=\"backup\",direction=\"sent\"} 4096\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_sftp_bytes{action=\"backup\",direction=\"received\"} 512\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_sftp_packets{action=\"backup\",type=\"SSH_FXP_WRITE\"} 3\n"))
+			Ω(out.String()).Should(ContainSubstring("cfops_sftp_retransmits{action=\"backup\"} 2\n"))
+		})
+
+		It("should leave out the last success when there has been none", func() {
+			var out bytes.Buffer
+			WriteMetrics(&out, entry, time.Time{})
+			Ω(out.String()).ShouldNot(ContainSubstring("cfops_last_success_timestamp_seconds"))
+		})
+	})
+
+	Describe("PushMetrics", func() {
+		var (
+			server  *httptest.Server
+			method  string
+			urlPath string
+			body    []byte
+			status  int
+		)
+
+		BeforeEach(func() {
+			status = http.StatusAccepted
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, urlPath = r.Method, r.URL.Path
+				body, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(status)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should replace the group of the action and foundation", func() {
+			Ω(PushMetrics(server.URL, "opsman.example.com", entry, time.Time{})).Should(BeNil())
+			Ω(method).Should(Equal("PUT"))
+			Ω(urlPath).Should(Equal("/metrics/job/cfops/action/backup/foundation/opsman.example.com"))
+			Ω(string(body)).Should(ContainSubstring("cfops_run_success"))
+		})
+
+		It("should return an error when the gateway refuses the metrics", func() {
+			status = http.StatusBadRequest
+			Ω(PushMetrics(server.URL, "opsman.example.com", entry, time.Time{})).Should(Equal(ErrPushGateway(server.URL, "400 Bad Request")))
+		})
+	})
+
+	Describe("SendStatsd", func() {
+		var conn net.PacketConn
+
+		BeforeEach(func() {
+			conn, _ = net.ListenPacket("udp", "127.0.0.1:0")
+		})
+
+		AfterEach(func() {
+			conn.Close()
+		})
+
+		It("should send every metric as a gauge named after its action and tile", func() {
+			Ω(SendStatsd(conn.LocalAddr().String(), entry, time.Time{})).Should(BeNil())
+			packet := make([]byte, 2048)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(packet)
+			Ω(err).Should(BeNil())
+			Ω(string(packet[:n])).Should(ContainSubstring("cfops.backup.run_duration_seconds:10|g\n"))
+			Ω(string(packet[:n])).Should(ContainSubstring("cfops.backup.ER.tile_failed:1|g\n"))
+		})
+	})
+
+	Describe("RunPipeline with a metrics file", func() {
+		var (
+			dir string
+			fs  *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "metrics")
+			writeArtifacts(dir, BackupArtifacts[OpsMgr])
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+				ER: func() (Tile, error) {
+					return &mockTile{ErrReturned: errors.New("er failed")}, nil
+				},
+			}
+			fs = &mockFlagSet{
+				tileListFlag: "opsmanager, er",
+				dest:         dir,
+				metricsFile:  path.Join(dir, "textfile", "cfops.prom"),
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should write the metrics of the failed run", func() {
+			RunPipeline(fs, Backup)
+			contents, err := ioutil.ReadFile(fs.metricsFile)
+			Ω(err).Should(BeNil())
+			Ω(string(contents)).Should(ContainSubstring("cfops_run_success{action=\"backup\"} 0\n"))
+			Ω(string(contents)).Should(ContainSubstring("cfops_tile_failed{action=\"backup\",tile=\"ER\"} 1\n"))
+			Ω(string(contents)).Should(ContainSubstring("cfops_tile_bytes{action=\"backup\",tile=\"OPSMANAGER\"} 44\n"))
+		})
+	})
+})
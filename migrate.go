@@ -0,0 +1,282 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrNoMigrationFormat = "the backup was taken on elastic runtime %s and there is no migration from %s towards %s, it can only be restored to %s"
+	deployedProductsPath = "/api/v0/deployed/products"
+	legacySettingsPath   = "/api/installation_settings"
+	ertProduct           = "cf"
+)
+
+var (
+	// Migrations bring the artifacts of a backup taken on one elastic runtime
+	// version up to the next, so that it can be restored to a foundation
+	// running a later version. Add a migration to support restoring across
+	// the versions it spans
+	Migrations []Migration
+)
+
+type (
+	// Migration rewrites the artifacts of a backup taken on elastic runtime
+	// From, a major.minor version such as 1.5, into what To expects
+	Migration struct {
+		Name string
+		From string
+		To   string
+		// Artifacts are the artifacts the migration rewrites, e.g. ccdb.backup
+		// or nfs_server.backup
+		Artifacts []string
+		Rewrite   func(artifact string, in io.Reader, out io.Writer) error
+	}
+
+	// productVersion is how the installation settings and the deployed
+	// products of ops manager describe a product, across versions
+	productVersion struct {
+		Guid           string `json:"guid"`
+		Type           string `json:"type"`
+		Identifier     string `json:"identifier"`
+		ProductVersion string `json:"product_version"`
+	}
+)
+
+func ErrNoMigration(backup, from, to string) error {
+	return fmt.Errorf(ErrNoMigrationFormat, backup, from, to, backup)
+}
+
+// minorVersion is the major.minor part of a product version, e.g. 1.5 of
+// 1.5.2.0 or 1.6.0-build.12, which is what migrations are keyed by
+func minorVersion(version string) string {
+	parts := strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3)
+
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// MigrationPath chains the migrations leading from one elastic runtime
+// version to another, none when they are the same major.minor version
+func MigrationPath(from, to string) (steps []Migration, err error) {
+	current, target := minorVersion(from), minorVersion(to)
+
+	for current != target {
+		var (
+			next  Migration
+			found bool
+		)
+
+		for _, migration := range Migrations {
+			if minorVersion(migration.From) == current {
+				next, found = migration, true
+				break
+			}
+		}
+
+		if !found || len(steps) == len(Migrations) {
+			return nil, ErrNoMigration(minorVersion(from), current, target)
+		}
+		steps = append(steps, next)
+		current = minorVersion(next.To)
+	}
+	return
+}
+
+// ertVersion finds the elastic runtime among the products of the installation
+// settings or of the deployed products of ops manager
+func ertVersion(products []productVersion) string {
+	for _, product := range products {
+		if product.Type == ertProduct || product.Identifier == ertProduct {
+			return product.ProductVersion
+		}
+	}
+	return ""
+}
+
+// BackupErtVersion is the elastic runtime version the installation settings
+// of the backup in the destination describe, empty when they do not say
+func BackupErtVersion(destination string) (version string, err error) {
+	var (
+		contents []byte
+		settings struct {
+			Products []productVersion `json:"products"`
+		}
+	)
+
+	if contents, err = ioutil.ReadFile(path.Join(destination, cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME)); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(contents, &settings); err == nil {
+		version = ertVersion(settings.Products)
+	}
+	return
+}
+
+// DeployedErtVersion asks ops manager which elastic runtime version the
+// foundation runs, through the installation settings on versions without
+// the deployed products api
+func DeployedErtVersion(host, user, pass string) (version string, err error) {
+	var products []productVersion
+
+	if products, err = deployedProducts(host, user, pass); err == nil {
+		version = ertVersion(products)
+	}
+	return
+}
+
+// deployedProducts asks ops manager for the products of the foundation,
+// through the installation settings on versions without the deployed
+// products api
+func deployedProducts(host, user, pass string) (products []productVersion, err error) {
+	var (
+		status   int
+		settings struct {
+			Products []productVersion `json:"products"`
+		}
+	)
+	client := &opsManagerClient{base: opsManagerBase(host), user: user, pass: pass}
+
+	if status, err = client.do("GET", deployedProductsPath, nil, &products); err == nil && status != http.StatusNotFound {
+		return
+	}
+
+	if err == nil {
+		_, err = client.do("GET", legacySettingsPath, nil, &settings)
+		products = settings.Products
+	}
+	return
+}
+
+// deployedErtVersion is DeployedErtVersion, answered from the metadata cache
+// while ops manager was asked within its ttl, unless fresh. It tells whether
+// the version was cached
+func deployedErtVersion(fs flagSet, cache *MetadataCache, fresh bool) (version string, cached bool, err error) {
+	var products []productVersion
+
+	if cache != nil && !fresh && cache.Get(deployedProductsCacheKey, &products) {
+		return ertVersion(products), true, nil
+	}
+
+	if products, err = deployedProducts(fs.Host(), fs.AdminUser(), fs.AdminPass()); err == nil && cache != nil {
+		if cacheErr := cache.Put(deployedProductsCacheKey, products); cacheErr != nil {
+			warn("unable to cache the deployed products of %s: %s", fs.Host(), cacheErr)
+		}
+	}
+	return ertVersion(products), false, err
+}
+
+// restoresElasticRuntime tells whether the restore includes the elastic
+// runtime, which is all migrations are concerned with
+func restoresElasticRuntime(fs flagSet) bool {
+	if !hasTilelistFlag(fs) {
+		return true
+	}
+
+	for _, tileName := range formatArray(strings.Split(fs.Tilelist(), ",")) {
+		if tileName == ER {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreMigrations are the migrations the backup in the destination needs to
+// be restored to the foundation, between the versions it found. There are
+// none when either version is not known
+func restoreMigrations(fs flagSet) (from, to string, steps []Migration, err error) {
+	var (
+		settingsErr, targetErr error
+		cached                 bool
+	)
+
+	if !restoresElasticRuntime(fs) {
+		return
+	}
+
+	if from, settingsErr = BackupErtVersion(fs.Dest()); settingsErr != nil || from == "" {
+		return
+	}
+
+	if to = fs.TargetVersion(); to == "" {
+		cache := openMetadataCache(fs)
+
+		if to, cached, targetErr = deployedErtVersion(fs, cache, false); targetErr == nil && cached {
+			// the foundation may have been upgraded since ops manager was
+			// asked, a version the backup does not migrate to is asked again
+			if _, pathErr := MigrationPath(from, to); pathErr != nil {
+				to, _, targetErr = deployedErtVersion(fs, cache, true)
+			}
+		}
+
+		if targetErr != nil {
+			warn("unable to tell which elastic runtime version %s runs, restoring without migrations: %s", fs.Host(), targetErr)
+			return from, "", nil, nil
+		}
+	}
+
+	if to != "" {
+		steps, err = MigrationPath(from, to)
+	}
+	return
+}
+
+// migrateForRestore rewrites the artifacts of the restore with the migrations
+// from the elastic runtime version of the backup to the one of the foundation,
+// before anything is restored. It returns the migrations applied and the
+// function putting the original artifacts back
+func migrateForRestore(fs flagSet) (applied []string, restore func(), err error) {
+	var (
+		from, to string
+		steps    []Migration
+		restores []func()
+	)
+	restore = func() {
+		for i := len(restores) - 1; i >= 0; i-- {
+			restores[i]()
+		}
+	}
+
+	if from, to, steps, err = restoreMigrations(fs); err != nil || len(steps) == 0 {
+		return
+	}
+	lo.G.Info("migrating the backup from elastic runtime %s to %s", from, to)
+	selected := make(map[string]bool)
+
+	for _, artifact := range restoreArtifacts(fs.Tilelist(), fs.Components()) {
+		selected[artifact] = true
+	}
+
+	for _, migration := range steps {
+		var (
+			artifacts []string
+			putBack   func()
+		)
+
+		for _, artifact := range migration.Artifacts {
+			if selected[artifact] {
+				artifacts = append(artifacts, artifact)
+			}
+		}
+
+		if putBack, err = substituteArtifacts(fs.Dest(), artifacts, migration.Rewrite); err != nil {
+			restore()
+			return nil, func() {}, err
+		}
+		restores = append(restores, putBack)
+		applied = append(applied, migration.Name)
+		lo.G.Info("applied migration %s", migration.Name)
+	}
+	return
+}
@@ -0,0 +1,204 @@
+package cfops_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// renameColumn is a migration renaming a column throughout a dump
+func renameColumn(from, to, column, renamed string) Migration {
+	return Migration{
+		Name:      fmt.Sprintf("%s-%s rename %s", from, to, column),
+		From:      from,
+		To:        to,
+		Artifacts: []string{"ccdb.backup"},
+		Rewrite: func(artifact string, in io.Reader, out io.Writer) (err error) {
+			var contents []byte
+
+			if contents, err = ioutil.ReadAll(in); err == nil {
+				_, err = io.WriteString(out, strings.Replace(string(contents), column, renamed, -1))
+			}
+			return
+		},
+	}
+}
+
+var _ = Describe("Migrations", func() {
+	var registered []Migration
+
+	BeforeEach(func() {
+		registered = Migrations
+		Migrations = []Migration{
+			renameColumn("1.5", "1.6", "droplet_hash", "droplet_guid"),
+			renameColumn("1.4", "1.5", "package_hash", "package_guid"),
+		}
+	})
+
+	AfterEach(func() {
+		Migrations = registered
+	})
+
+	Describe("MigrationPath", func() {
+		It("should chain the migrations from the version of the backup to the target", func() {
+			steps, err := MigrationPath("1.4.2.0", "1.6.0-build.12")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(steps).Should(HaveLen(2))
+			Ω(steps[0].Name).Should(Equal("1.4-1.5 rename package_hash"))
+			Ω(steps[1].Name).Should(Equal("1.5-1.6 rename droplet_hash"))
+		})
+
+		It("should not migrate between patches of the same version", func() {
+			steps, err := MigrationPath("1.5.2.0", "1.5.9")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(steps).Should(BeEmpty())
+		})
+
+		It("should refuse versions no migration leads between", func() {
+			_, err := MigrationPath("1.5.2.0", "1.7.0")
+			Ω(err).Should(MatchError(ErrNoMigration("1.5", "1.6", "1.7")))
+			_, err = MigrationPath("1.6.0", "1.5.2.0")
+			Ω(err).Should(MatchError(ErrNoMigration("1.6", "1.6", "1.5")))
+		})
+	})
+
+	Describe("restoring to a later version", func() {
+		var (
+			dir      string
+			dumpPath string
+			ccdb     *readingTile
+			fs       *mockFlagSet
+		)
+		dump := "CREATE TABLE droplets (droplet_hash text);"
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "migrate")
+			settingsPath := path.Join(dir, cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME)
+			os.MkdirAll(path.Dir(settingsPath), 0700)
+			ioutil.WriteFile(settingsPath, []byte(`{"products":[{"identifier":"p-bosh","product_version":"1.5.1.0"},{"identifier":"cf","product_version":"1.5.2.0"}]}`), 0600)
+			dumpPath = path.Join(dir, "ccdb.backup")
+			ioutil.WriteFile(dumpPath, []byte(dump), 0600)
+
+			ccdb = &readingTile{settingsPath: dumpPath}
+			SupportedTiles = map[string]func() (Tile, error){
+				ER: func() (Tile, error) {
+					return ccdb, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "er", dest: dir, lockDir: dir, version: "1.6.0"}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should restore from the migrated artifacts and record the migrations", func() {
+			entry, err := RunPipelineResult(context.Background(), fs, Restore)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ccdb.settings).Should(Equal("CREATE TABLE droplets (droplet_guid text);"))
+			Ω(entry.Migrations).Should(Equal([]string{"1.5-1.6 rename droplet_hash"}))
+
+			contents, _ := ioutil.ReadFile(dumpPath)
+			Ω(string(contents)).Should(Equal(dump))
+			Ω(dumpPath + OriginalArtifactSuffix).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should migrate artifacts the restore also remaps", func() {
+			ioutil.WriteFile(dumpPath, []byte("INSERT INTO droplets (droplet_hash) VALUES ('10.0.16.5');"), 0600)
+			ioutil.WriteFile(path.Join(dir, "remap.yml"), []byte("networks:\n- from: 10.0.16.0/20\n  to: 10.8.32.0/20\n"), 0600)
+			fs.remap = path.Join(dir, "remap.yml")
+			Ω(RunPipeline(fs, Restore)).Should(Succeed())
+			Ω(ccdb.settings).Should(Equal("INSERT INTO droplets (droplet_guid) VALUES ('10.8.32.5');"))
+
+			contents, _ := ioutil.ReadFile(dumpPath)
+			Ω(string(contents)).Should(ContainSubstring("droplet_hash"))
+			Ω(dumpPath + OriginalArtifactSuffix).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should refuse to restore anything without a migration to the version", func() {
+			fs.version = "1.7.0"
+			Ω(RunPipeline(fs, Restore)).Should(MatchError(ErrNoMigration("1.5", "1.6", "1.7")))
+			Ω(ccdb.settings).Should(BeEmpty())
+		})
+
+		It("should ask ops manager for its version when none is given", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v0/deployed/products" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, `[{"type":"p-bosh","product_version":"1.6.0.0"},{"type":"cf","product_version":"1.6.3-build.2"}]`)
+			}))
+			defer server.Close()
+			fs.host = server.URL
+			fs.version = ""
+			Ω(RunPipeline(fs, Restore)).Should(Succeed())
+			Ω(ccdb.settings).Should(ContainSubstring("droplet_guid"))
+		})
+
+		Context("with a metadata cache", func() {
+			var (
+				requests int
+				deployed string
+				server   *httptest.Server
+			)
+
+			BeforeEach(func() {
+				requests, deployed = 0, "1.6.3-build.2"
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requests++
+					fmt.Fprintf(w, `[{"guid":"cf-4a2b","type":"cf","product_version":"%s"}]`, deployed)
+				}))
+				fs.host = server.URL
+				fs.version = ""
+				fs.metadata = MetadataCacheConfig{Path: path.Join(dir, "metadata.cache"), Key: "secret"}
+			})
+
+			AfterEach(func() {
+				server.Close()
+			})
+
+			It("should ask ops manager for its version only once", func() {
+				Ω(RunPipeline(fs, Restore)).Should(Succeed())
+				ioutil.WriteFile(dumpPath, []byte(dump), 0600)
+				Ω(RunPipeline(fs, Restore)).Should(Succeed())
+				Ω(requests).Should(Equal(1))
+				Ω(ccdb.settings).Should(ContainSubstring("droplet_guid"))
+			})
+
+			It("should ask again when the cached version has no migration", func() {
+				cache, _ := OpenMetadataCache(fs.metadata, fs.host)
+				cache.Put("deployed_products", []map[string]string{{"type": "cf", "product_version": "1.7.0"}})
+				Ω(RunPipeline(fs, Restore)).Should(Succeed())
+				Ω(requests).Should(Equal(1))
+				Ω(ccdb.settings).Should(ContainSubstring("droplet_guid"))
+			})
+		})
+	})
+
+	It("should read the version from the installation settings of ops managers without the deployed products api", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/installation_settings" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `{"products":[{"type":"cf","product_version":"1.4.2.0"}]}`)
+		}))
+		defer server.Close()
+		version, err := DeployedErtVersion(server.URL, "admin", "secret")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(version).Should(Equal("1.4.2.0"))
+	})
+})
@@ -0,0 +1,112 @@
+package cfops
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	NotifyRunStarted     = "run_started"
+	NotifyPhaseCompleted = "phase_completed"
+	NotifyRunFinished    = "run_finished"
+	NotifyRunFailed      = "run_failed"
+)
+
+var registeredNotifiers = struct {
+	mutex     sync.Mutex
+	notifiers map[string]Notifier
+}{notifiers: make(map[string]Notifier)}
+
+type (
+	// Notification is a step in a run worth telling someone about: its
+	// start, the completion of each of its tiles, and its end, as
+	// NotifyRunFinished when it completed and NotifyRunFailed otherwise
+	Notification struct {
+		Type       string
+		Foundation string
+		// Entry is the run so far, and all of it for the last two types
+		Entry *CatalogEntry
+		// Component is the tile that completed, for NotifyPhaseCompleted
+		Component *ComponentResult
+	}
+
+	// Notifier tells someone about runs, e.g. by mail or by paging. It is
+	// called in line with the run, for every notification in turn, and
+	// ignores the types it has no use for. Its errors are logged as
+	// warnings and never fail the run
+	Notifier interface {
+		Notify(notification Notification) error
+	}
+
+	namedNotifier struct {
+		name string
+		Notifier
+	}
+)
+
+// RegisterNotifier adds a notifier every following run tells about its
+// progress, after the notifiers its flags ask for, replacing the one
+// registered under the same name. A nil notifier removes it
+func RegisterNotifier(name string, notifier Notifier) {
+	registeredNotifiers.mutex.Lock()
+	defer registeredNotifiers.mutex.Unlock()
+
+	if notifier == nil {
+		delete(registeredNotifiers.notifiers, name)
+	} else {
+		registeredNotifiers.notifiers[name] = notifier
+	}
+}
+
+// runNotifiers are the notifiers the flags of a run ask for followed by the
+// registered ones, by name
+func runNotifiers(fs flagSet) (notifiers []namedNotifier) {
+	if config := fs.SMTP(); config.Host != "" {
+		notifiers = append(notifiers, namedNotifier{"email", emailNotifier(config)})
+	}
+
+	if config := fs.PagerDuty(); config.RoutingKey != "" {
+		notifiers = append(notifiers, namedNotifier{"pagerduty", pagerDutyNotifier(config)})
+	}
+	registeredNotifiers.mutex.Lock()
+	defer registeredNotifiers.mutex.Unlock()
+	var names []string
+
+	for name := range registeredNotifiers.notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		notifiers = append(notifiers, namedNotifier{name, registeredNotifiers.notifiers[name]})
+	}
+	return
+}
+
+// notify hands the notification to every notifier of the run
+func (s *pipelineRun) notify(notification Notification) {
+	notification.Foundation, notification.Entry = s.fs.Host(), s.entry
+
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(notification); err != nil {
+			warn("unable to notify %s: %s", notifier.name, err)
+		}
+	}
+}
+
+// notifyFinished tells the notifiers of the run how it ended
+func (s *pipelineRun) notifyFinished() {
+	notification := Notification{Type: NotifyRunFinished}
+
+	if s.entry.Status != SetComplete {
+		notification.Type = NotifyRunFailed
+	}
+	s.notify(notification)
+}
+
+// notifyCompleted tells the notifiers of the run about the tile it last
+// recorded
+func (s *pipelineRun) notifyCompleted() {
+	component := s.entry.Components[len(s.entry.Components)-1]
+	s.notify(Notification{Type: NotifyPhaseCompleted, Component: &component})
+}
@@ -0,0 +1,87 @@
+package cfops_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingNotifier keeps every notification it is handed
+type recordingNotifier struct {
+	notifications []Notification
+	err           error
+}
+
+func (s *recordingNotifier) Notify(notification Notification) error {
+	s.notifications = append(s.notifications, notification)
+	return s.err
+}
+
+func (s *recordingNotifier) types() (types []string) {
+	for _, notification := range s.notifications {
+		types = append(types, notification.Type)
+	}
+	return
+}
+
+var _ = Describe("Notifiers", func() {
+	var (
+		dir      string
+		fs       *mockFlagSet
+		notifier *recordingNotifier
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "notifier")
+		writeArtifacts(dir, BackupArtifacts[OpsMgr])
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				return &mockTile{}, nil
+			},
+			ER: func() (Tile, error) {
+				return &mockTile{ErrReturned: errors.New("er failed")}, nil
+			},
+		}
+		fs = &mockFlagSet{tileListFlag: "opsmanager, er", dest: dir, host: "opsman.example.com"}
+		notifier = &recordingNotifier{}
+		RegisterNotifier("recording", notifier)
+	})
+
+	AfterEach(func() {
+		RegisterNotifier("recording", nil)
+		os.RemoveAll(dir)
+	})
+
+	It("should tell a registered notifier about the start, each tile and the failure of a run", func() {
+		RunPipeline(fs, Backup)
+		Ω(notifier.types()).Should(Equal([]string{NotifyRunStarted, NotifyPhaseCompleted, NotifyPhaseCompleted, NotifyRunFailed}))
+		Ω(notifier.notifications[1].Component.Name).Should(Equal(OpsMgr))
+		Ω(notifier.notifications[2].Component.Status).Should(Equal(ComponentFailed))
+		Ω(notifier.notifications[3].Foundation).Should(Equal("opsman.example.com"))
+		Ω(notifier.notifications[3].Entry.Status).Should(Equal(SetIncomplete))
+	})
+
+	It("should tell it a run that completed has finished", func() {
+		fs.tileListFlag = "opsmanager"
+		RunPipeline(fs, Backup)
+		Ω(notifier.types()).Should(Equal([]string{NotifyRunStarted, NotifyPhaseCompleted, NotifyRunFinished}))
+	})
+
+	It("should not fail the run when a notifier fails", func() {
+		notifier.err = errors.New("webhook unreachable")
+		fs.tileListFlag = "opsmanager"
+		Ω(RunPipeline(fs, Backup)).Should(BeNil())
+		Ω(notifier.notifications).Should(HaveLen(3))
+	})
+
+	It("should stop telling a notifier once it is removed", func() {
+		RegisterNotifier("recording", nil)
+		RunPipeline(fs, Backup)
+		Ω(notifier.notifications).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,166 @@
+package cfops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PagerDutyEventsURL        = "https://events.pagerduty.com/v2/enqueue"
+	ErrPagerDutyFormat        = "pagerduty responded with %s"
+	ErrPagerDutyMappingFormat = "invalid pagerduty severity mapping %q, expected e.g. incomplete=critical,aborted=warning"
+)
+
+var (
+	// DefaultPagerDutySeverities maps the status of a run that did not complete
+	// to the severity of the alert it raises
+	DefaultPagerDutySeverities = map[string]string{
+		SetIncomplete: "error",
+		SetAborted:    "warning",
+	}
+	pagerDutySeverities = map[string]bool{"critical": true, "error": true, "warning": true, "info": true}
+)
+
+type (
+	// PagerDutyConfig describes how to alert through the pagerduty events api
+	PagerDutyConfig struct {
+		RoutingKey string
+		// Severities maps run statuses to alert severities, see
+		// DefaultPagerDutySeverities
+		Severities map[string]string
+		// EventsURL defaults to PagerDutyEventsURL
+		EventsURL string
+	}
+
+	pagerDutyEvent struct {
+		RoutingKey  string            `json:"routing_key"`
+		EventAction string            `json:"event_action"`
+		DedupKey    string            `json:"dedup_key"`
+		Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	}
+
+	pagerDutyPayload struct {
+		Summary       string        `json:"summary"`
+		Source        string        `json:"source"`
+		Severity      string        `json:"severity"`
+		Timestamp     string        `json:"timestamp"`
+		Component     string        `json:"component"`
+		Group         string        `json:"group"`
+		CustomDetails *CatalogEntry `json:"custom_details"`
+	}
+)
+
+func ErrPagerDuty(status string) error {
+	return fmt.Errorf(ErrPagerDutyFormat, status)
+}
+
+func ErrPagerDutyMapping(mapping string) error {
+	return fmt.Errorf(ErrPagerDutyMappingFormat, mapping)
+}
+
+// ParsePagerDutySeverities reads a mapping like incomplete=critical,aborted=warning
+// over the default severities
+func ParsePagerDutySeverities(mapping string) (severities map[string]string, err error) {
+	severities = make(map[string]string)
+
+	for status, severity := range DefaultPagerDutySeverities {
+		severities[status] = severity
+	}
+
+	for _, pair := range strings.Split(mapping, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+
+		if len(parts) != 2 || DefaultPagerDutySeverities[parts[0]] == "" || !pagerDutySeverities[parts[1]] {
+			return nil, ErrPagerDutyMapping(mapping)
+		}
+		severities[parts[0]] = parts[1]
+	}
+	return
+}
+
+// SendPagerDutyEvent triggers an alert for a run of the action on the
+// foundation that did not complete, or resolves the open alert once a run
+// completes. Alerts of the same action and foundation share a dedup key, so
+// repeated failures update a single incident
+func SendPagerDutyEvent(config PagerDutyConfig, foundation string, entry *CatalogEntry) (err error) {
+	var (
+		contents []byte
+		response *http.Response
+	)
+	event := pagerDutyEvent{
+		RoutingKey:  config.RoutingKey,
+		EventAction: "resolve",
+		DedupKey:    fmt.Sprintf("cfops/%s/%s", foundation, entry.Action),
+	}
+
+	if entry.Status != SetComplete {
+		severity := config.Severities[entry.Status]
+
+		if severity == "" {
+			severity = DefaultPagerDutySeverities[SetIncomplete]
+		}
+		source, _ := os.Hostname()
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{
+			Summary:       fmt.Sprintf("cfops %s of %s %s: %s", entry.Action, foundation, entry.Status, failedComponents(entry)),
+			Source:        source,
+			Severity:      severity,
+			Timestamp:     entry.Finished.Format(time.RFC3339),
+			Component:     foundation,
+			Group:         entry.Action,
+			CustomDetails: entry,
+		}
+	}
+	eventsURL := config.EventsURL
+
+	if eventsURL == "" {
+		eventsURL = PagerDutyEventsURL
+	}
+
+	if contents, err = json.Marshal(event); err != nil {
+		return
+	}
+
+	if response, err = metricsClient.Post(eventsURL, "application/json", bytes.NewReader(contents)); err == nil {
+		defer response.Body.Close()
+
+		if response.StatusCode/100 != 2 {
+			err = ErrPagerDuty(response.Status)
+		}
+	}
+	return
+}
+
+func failedComponents(entry *CatalogEntry) string {
+	var failed []string
+
+	for _, c := range entry.Components {
+		if c.Status != ComponentSucceeded {
+			failed = append(failed, c.Name+" "+c.Status)
+		}
+	}
+
+	if len(failed) == 0 {
+		return "no component finished"
+	}
+	return strings.Join(failed, ", ")
+}
+
+// pagerDutyNotifier alerts on a run that did not complete, and resolves the
+// alert once a run does
+type pagerDutyNotifier PagerDutyConfig
+
+func (s pagerDutyNotifier) Notify(notification Notification) error {
+	if notification.Type == NotifyRunFailed || notification.Type == NotifyRunFinished {
+		return SendPagerDutyEvent(PagerDutyConfig(s), notification.Foundation, notification.Entry)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PagerDuty", func() {
+	var (
+		server   *httptest.Server
+		received map[string]interface{}
+		status   int
+		config   PagerDutyConfig
+		entry    *CatalogEntry
+	)
+
+	BeforeEach(func() {
+		received = nil
+		status = http.StatusAccepted
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &received)
+			w.WriteHeader(status)
+		}))
+		severities, _ := ParsePagerDutySeverities("incomplete=critical")
+		config = PagerDutyConfig{RoutingKey: "routing", Severities: severities, EventsURL: server.URL}
+		entry = &CatalogEntry{
+			Action:   Backup,
+			Status:   SetIncomplete,
+			Finished: time.Unix(1000, 0),
+			Components: []ComponentResult{
+				{Name: OpsMgr, Status: ComponentSucceeded},
+				{Name: ER, Status: ComponentFailed},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("SendPagerDutyEvent", func() {
+		It("should trigger an alert with the mapped severity for a run that did not complete", func() {
+			Ω(SendPagerDutyEvent(config, "opsman.example.com", entry)).Should(BeNil())
+			Ω(received["routing_key"]).Should(Equal("routing"))
+			Ω(received["event_action"]).Should(Equal("trigger"))
+			Ω(received["dedup_key"]).Should(Equal("cfops/opsman.example.com/backup"))
+			payload := received["payload"].(map[string]interface{})
+			Ω(payload["severity"]).Should(Equal("critical"))
+			Ω(payload["summary"]).Should(ContainSubstring("ER failed"))
+			Ω(payload["component"]).Should(Equal("opsman.example.com"))
+		})
+
+		It("should use the default severity of a status that is not mapped", func() {
+			entry.Status = SetAborted
+			SendPagerDutyEvent(config, "opsman.example.com", entry)
+			Ω(received["payload"].(map[string]interface{})["severity"]).Should(Equal("warning"))
+		})
+
+		It("should resolve the alert once a run completes", func() {
+			entry.Status = SetComplete
+			Ω(SendPagerDutyEvent(config, "opsman.example.com", entry)).Should(BeNil())
+			Ω(received["event_action"]).Should(Equal("resolve"))
+			Ω(received["dedup_key"]).Should(Equal("cfops/opsman.example.com/backup"))
+			Ω(received).ShouldNot(HaveKey("payload"))
+		})
+
+		It("should fail when pagerduty refuses the event", func() {
+			status = http.StatusBadRequest
+			Ω(SendPagerDutyEvent(config, "opsman.example.com", entry)).ShouldNot(BeNil())
+		})
+	})
+
+	Describe("ParsePagerDutySeverities", func() {
+		It("should keep the defaults of the statuses it does not map", func() {
+			severities, err := ParsePagerDutySeverities("aborted=info")
+			Ω(err).Should(BeNil())
+			Ω(severities).Should(Equal(map[string]string{SetIncomplete: "error", SetAborted: "info"}))
+		})
+
+		It("should reject unknown statuses and severities", func() {
+			_, err := ParsePagerDutySeverities("complete=critical")
+			Ω(err).ShouldNot(BeNil())
+			_, err = ParsePagerDutySeverities("incomplete=loud")
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+})
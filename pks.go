@@ -0,0 +1,346 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// PKSDir is the directory of the destination holding the backup of the
+	// pks control plane
+	PKSDir = "pks"
+	// PKSClustersIndex lists the etcd snapshot taken of each cluster
+	PKSClustersIndex = PKSDir + "/clusters.json"
+
+	ErrPKSDeploymentFormat = "unable to tell which deployment is the pks control plane among %s, name it with --pksdeployment"
+	ErrPKSInstanceFormat   = "no instance of %s runs %s"
+	ErrPKSCommandFormat    = "running %q on %s of %s failed: %s"
+
+	pksProduct       = "pivotal-container-service"
+	pksClusterPrefix = "service-instance_"
+	pksMasterGroup   = "master"
+	// the commands run on the instances read or write a file of the instance,
+	// given as %[1]s, which is copied with bosh scp
+	pksDumpCommand      = "sudo sh -c '/var/vcap/packages/pxc/bin/mysqldump --defaults-file=/var/vcap/jobs/pxc-mysql/config/mylogin.cnf --single-transaction --routines --databases %[2]s > %[1]s' && sudo chown \"$(id -un)\" %[1]s"
+	pksLoadCommand      = "sudo sh -c '/var/vcap/packages/pxc/bin/mysql --defaults-file=/var/vcap/jobs/pxc-mysql/config/mylogin.cnf < %[1]s'"
+	etcdSnapshotCommand = "sudo /var/vcap/jobs/etcd/bin/etcdctl snapshot save %[1]s && sudo chown \"$(id -un)\" %[1]s"
+	pksRemoteFormat     = "/tmp/cfops-%s"
+)
+
+var (
+	// PKSDatabases are the databases of the pks control plane a backup
+	// dumps: those of the pks api and of its uaa
+	PKSDatabases = []string{"pks", "uaa"}
+)
+
+type (
+	// PKSConfig describes backing up the pks control plane: the databases of
+	// the pks api and its uaa, and a snapshot of the etcd of every cluster,
+	// all through the bosh cli, which reads the director and its credentials
+	// from BOSH_ENVIRONMENT, BOSH_CLIENT, BOSH_CLIENT_SECRET and BOSH_CA_CERT
+	PKSConfig struct {
+		// Deployment is the pks control plane deployment, the only
+		// pivotal-container-service- deployment of the director when empty
+		Deployment string
+		// Clusters are the cluster deployments whose etcd is backed up,
+		// every service-instance_ deployment of the director when empty
+		Clusters []string
+		// Binary defaults to bosh on the path
+		Binary string
+	}
+
+	// PKSTile backs up and restores the pks control plane
+	PKSTile struct {
+		PKSConfig
+		Destination string
+	}
+
+	// PKSCluster is the etcd snapshot a backup took of a cluster
+	PKSCluster struct {
+		Deployment string `json:"deployment"`
+		Instance   string `json:"instance"`
+		Artifact   string `json:"artifact"`
+	}
+)
+
+func ErrPKSDeployment(deployments []string) error {
+	return fmt.Errorf(ErrPKSDeploymentFormat, "["+strings.Join(deployments, ", ")+"]")
+}
+
+func ErrPKSInstance(deployment, group string) error {
+	return fmt.Errorf(ErrPKSInstanceFormat, deployment, group)
+}
+
+func ErrPKSCommand(command, instance, deployment, output string) error {
+	return fmt.Errorf(ErrPKSCommandFormat, command, instance, deployment, output)
+}
+
+// NewPKSTile is the pks control plane of the director, backed up into and
+// restored from the destination
+func NewPKSTile(config PKSConfig, destination string) *PKSTile {
+	return &PKSTile{PKSConfig: config, Destination: destination}
+}
+
+func pksArtifact(database string) string {
+	return path.Join(PKSDir, database+".sql")
+}
+
+// pksRemote is the file of an instance an artifact is copied from or to
+func pksRemote(artifact string) string {
+	return fmt.Sprintf(pksRemoteFormat, path.Base(artifact))
+}
+
+// Backup dumps the databases of the control plane and snapshots the etcd of
+// each cluster into the destination, listing the snapshots in the index
+func (s *PKSTile) Backup() (err error) {
+	var (
+		deployment string
+		clusters   []string
+		instance   string
+		contents   []byte
+		index      = []PKSCluster{}
+	)
+
+	if deployment, clusters, err = s.deployments(); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Join(s.Destination, PKSDir, "clusters"), 0700); err != nil {
+		return
+	}
+
+	if instance, err = s.instanceOf(deployment, pksProduct); err != nil {
+		return
+	}
+
+	for _, database := range PKSDatabases {
+		lo.G.Info("dumping the %s database of %s", database, deployment)
+		artifact := pksArtifact(database)
+
+		if err = s.fetch(deployment, instance, fmt.Sprintf(pksDumpCommand, pksRemote(artifact), database), artifact); err != nil {
+			return
+		}
+
+		if err = ValidateDump(path.Join(s.Destination, artifact), MysqlEngine); err != nil {
+			return
+		}
+	}
+
+	for _, cluster := range clusters {
+		var master string
+
+		if master, err = s.instanceOf(cluster, pksMasterGroup); err != nil {
+			return
+		}
+		lo.G.Info("snapshotting the etcd of cluster %s", cluster)
+		artifact := path.Join(PKSDir, "clusters", cluster+".db")
+
+		if err = s.fetch(cluster, master, fmt.Sprintf(etcdSnapshotCommand, pksRemote(artifact)), artifact); err != nil {
+			return
+		}
+		index = append(index, PKSCluster{Deployment: cluster, Instance: master, Artifact: artifact})
+	}
+
+	if contents, err = json.MarshalIndent(index, "", "  "); err == nil {
+		err = ioutil.WriteFile(path.Join(s.Destination, PKSClustersIndex), contents, 0600)
+	}
+	return
+}
+
+// Restore loads the databases of the control plane. Restoring the etcd of a
+// cluster takes its masters down, so it is left to the operator, who is
+// told which snapshots the backup has
+func (s *PKSTile) Restore() (err error) {
+	var (
+		deployment string
+		instance   string
+		clusters   []PKSCluster
+	)
+
+	if deployment, _, err = s.deployments(); err != nil {
+		return
+	}
+
+	if instance, err = s.instanceOf(deployment, pksProduct); err != nil {
+		return
+	}
+
+	for _, database := range PKSDatabases {
+		lo.G.Info("restoring the %s database of %s", database, deployment)
+		artifact := pksArtifact(database)
+
+		if err = s.push(deployment, instance, artifact, fmt.Sprintf(pksLoadCommand, pksRemote(artifact))); err != nil {
+			return
+		}
+	}
+
+	if clusters, err = LoadPKSClusters(s.Destination); err != nil {
+		return
+	}
+
+	for _, cluster := range clusters {
+		lo.G.Warning("the etcd of cluster %s is not restored, restore %s onto its masters with etcdctl snapshot restore", cluster.Deployment, path.Join(s.Destination, cluster.Artifact))
+	}
+	return
+}
+
+// LoadPKSClusters reads the index of the etcd snapshots of the backup in the
+// destination
+func LoadPKSClusters(destination string) (clusters []PKSCluster, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(path.Join(destination, PKSClustersIndex)); err == nil {
+		err = json.Unmarshal(contents, &clusters)
+	}
+	return
+}
+
+// deployments are the control plane deployment and the cluster deployments
+// whose etcd is backed up
+func (s *PKSTile) deployments() (deployment string, clusters []string, err error) {
+	var (
+		rows  []map[string]string
+		names []string
+		found []string
+	)
+	deployment, clusters = s.Deployment, s.Clusters
+
+	if deployment != "" && len(clusters) > 0 {
+		return
+	}
+
+	if rows, err = runBosh(s.Binary, "deployments"); err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		names = append(names, row["name"])
+
+		if strings.HasPrefix(row["name"], pksProduct+"-") {
+			found = append(found, row["name"])
+		}
+
+		if len(s.Clusters) == 0 && strings.HasPrefix(row["name"], pksClusterPrefix) {
+			clusters = append(clusters, row["name"])
+		}
+	}
+
+	if deployment == "" && len(found) != 1 {
+		return "", nil, ErrPKSDeployment(names)
+	}
+
+	if deployment == "" {
+		deployment = found[0]
+	}
+	return
+}
+
+// instanceOf is the first instance of the instance group of the deployment
+func (s *PKSTile) instanceOf(deployment, group string) (instance string, err error) {
+	var rows []map[string]string
+
+	if rows, err = runBosh(s.Binary, "-d", deployment, "instances"); err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		if instanceGroupOf(strings.SplitN(row["instance"], "/", 2)[0], group) {
+			return row["instance"], nil
+		}
+	}
+	return "", ErrPKSInstance(deployment, group)
+}
+
+// fetch runs the command on the instance and copies the file it wrote into
+// the artifact of the destination, removing it from the instance again
+func (s *PKSTile) fetch(deployment, instance, command, artifact string) (err error) {
+	remote := pksRemote(artifact)
+	defer s.ssh(deployment, instance, "sudo rm -f "+remote)
+
+	if err = s.ssh(deployment, instance, command); err != nil {
+		return
+	}
+
+	if _, err = runBosh(s.Binary, "-d", deployment, "scp", instance+":"+remote, path.Join(s.Destination, artifact)); err != nil {
+		return
+	}
+
+	if info, statErr := os.Stat(path.Join(s.Destination, artifact)); statErr != nil || info.Size() == 0 {
+		err = ErrMissingArtifact(path.Join(s.Destination, artifact))
+	}
+	return
+}
+
+// push copies the artifact of the destination onto the instance and runs the
+// command on it, removing it from the instance again
+func (s *PKSTile) push(deployment, instance, artifact, command string) (err error) {
+	remote := pksRemote(artifact)
+	defer s.ssh(deployment, instance, "sudo rm -f "+remote)
+
+	if _, err = runBosh(s.Binary, "-d", deployment, "scp", path.Join(s.Destination, artifact), instance+":"+remote); err == nil {
+		err = s.ssh(deployment, instance, command)
+	}
+	return
+}
+
+// ssh runs the command on the instance, failing when it exits non zero
+func (s *PKSTile) ssh(deployment, instance, command string) (err error) {
+	var rows []map[string]string
+
+	if rows, err = runBosh(s.Binary, "-d", deployment, "ssh", instance, "--results", "-c", command); err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		if row["exit_code"] != "" && row["exit_code"] != "0" {
+			return ErrPKSCommand(command, instance, deployment, strings.TrimSpace(row["stderr"]))
+		}
+	}
+	return
+}
+
+// verifyPKS checks that the database dumps of the control plane are complete
+// and that the backup has every etcd snapshot its index lists
+func verifyPKS(source artifactSource) (err error) {
+	var (
+		contents io.ReadCloser
+		clusters []PKSCluster
+	)
+
+	for _, database := range PKSDatabases {
+		artifact := pksArtifact(database)
+
+		if contents, err = source.openArtifact(artifact); err != nil {
+			return
+		}
+		err = validateDump(contents, source.location(artifact), dumpCompletionMarkers[MysqlEngine])
+		contents.Close()
+
+		if err != nil {
+			return
+		}
+	}
+
+	if contents, err = source.openArtifact(PKSClustersIndex); err != nil {
+		return ErrMissingArtifact(source.location(PKSClustersIndex))
+	}
+	defer contents.Close()
+
+	if err = json.NewDecoder(contents).Decode(&clusters); err != nil {
+		return
+	}
+
+	for _, cluster := range clusters {
+		if err = checkArtifact(source, cluster.Artifact); err != nil {
+			return
+		}
+	}
+	return
+}
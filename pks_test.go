@@ -0,0 +1,132 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// pksBosh answers the commands of a deployment from <deployment>-<command>.json,
+// runs every ssh command successfully unless ssh-fails exists, and copies the
+// files of its directory named like the local file bosh scp copies to
+const pksBosh = `#!/bin/sh
+dir="$(dirname "$0")"
+echo "$*" >> "$dir/calls"
+for arg in "$@"; do command="$arg"; done
+case "$*" in
+*" ssh "*) [ -f "$dir/ssh-fails" ] && { echo '{"Tables":[{"Rows":[{"stderr":"permission denied","exit_code":"1"}]}]}'; exit 1; }
+  echo '{"Tables":[{"Rows":[{"stderr":"","exit_code":"0"}]}]}'; exit 0 ;;
+*" scp "*) case "$command" in *:*) ;; *) cp "$dir/$(basename "$command")" "$command" || exit 1 ;; esac
+  echo '{"Tables":[]}'; exit 0 ;;
+esac
+[ "$3" = "-d" ] && command="$4-$command"
+cat "$dir/$command.json" 2>/dev/null || { echo '{"Tables":[],"Lines":["Director responded with non-successful status code 401"]}'; exit 1; }
+`
+
+var _ = Describe("PKS", func() {
+	var (
+		bin  string
+		dest string
+		tile *PKSTile
+	)
+
+	answer := func(command, output string) {
+		ioutil.WriteFile(path.Join(bin, command+".json"), []byte(output), 0644)
+	}
+
+	calls := func() []string {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		bin, _ = ioutil.TempDir("", "bosh-bin")
+		dest, _ = ioutil.TempDir("", "pks")
+		ioutil.WriteFile(path.Join(bin, "bosh"), []byte(pksBosh), 0755)
+		answer("deployments", `{"Tables":[{"Rows":[{"name":"cf-0123"},{"name":"pivotal-container-service-0456"},{"name":"service-instance_7a8d"},{"name":"service-instance_9b0c"}]}]}`)
+		answer("pivotal-container-service-0456-instances", `{"Tables":[{"Rows":[{"instance":"pivotal-container-service/1a2b"}]}]}`)
+		answer("service-instance_7a8d-instances", `{"Tables":[{"Rows":[{"instance":"worker/3c4d"},{"instance":"master/5e6f"}]}]}`)
+		answer("service-instance_9b0c-instances", `{"Tables":[{"Rows":[{"instance":"master/7a8b"}]}]}`)
+		ioutil.WriteFile(path.Join(bin, "pks.sql"), []byte(mysqlDump), 0644)
+		ioutil.WriteFile(path.Join(bin, "uaa.sql"), []byte(mysqlDump), 0644)
+		ioutil.WriteFile(path.Join(bin, "service-instance_7a8d.db"), []byte("etcd"), 0644)
+		ioutil.WriteFile(path.Join(bin, "service-instance_9b0c.db"), []byte("etcd"), 0644)
+		tile = NewPKSTile(PKSConfig{Binary: path.Join(bin, "bosh")}, dest)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bin)
+		os.RemoveAll(dest)
+	})
+
+	Describe("Backup", func() {
+		It("should dump the databases of the control plane and snapshot the etcd of every cluster", func() {
+			Ω(tile.Backup()).Should(BeNil())
+			Ω(path.Join(dest, "pks", "pks.sql")).Should(BeAnExistingFile())
+			Ω(path.Join(dest, "pks", "uaa.sql")).Should(BeAnExistingFile())
+			clusters, err := LoadPKSClusters(dest)
+			Ω(err).Should(BeNil())
+			Ω(clusters).Should(Equal([]PKSCluster{
+				{Deployment: "service-instance_7a8d", Instance: "master/5e6f", Artifact: "pks/clusters/service-instance_7a8d.db"},
+				{Deployment: "service-instance_9b0c", Instance: "master/7a8b", Artifact: "pks/clusters/service-instance_9b0c.db"},
+			}))
+			Ω(calls()).Should(ContainElement("--json --non-interactive -d pivotal-container-service-0456 scp pivotal-container-service/1a2b:/tmp/cfops-pks.sql " + path.Join(dest, "pks", "pks.sql")))
+			Ω(calls()).Should(ContainElement("--json --non-interactive -d pivotal-container-service-0456 ssh pivotal-container-service/1a2b --results -c sudo rm -f /tmp/cfops-pks.sql"))
+			Ω(Verify(dest, []string{PKS})).Should(BeNil())
+		})
+
+		It("should only snapshot the etcd of the clusters it is given", func() {
+			tile.Clusters = []string{"service-instance_9b0c"}
+			Ω(tile.Backup()).Should(BeNil())
+			clusters, _ := LoadPKSClusters(dest)
+			Ω(clusters).Should(HaveLen(1))
+			Ω(path.Join(dest, "pks", "clusters", "service-instance_7a8d.db")).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should fail when it can not tell which deployment is the control plane", func() {
+			answer("deployments", `{"Tables":[{"Rows":[{"name":"pivotal-container-service-0456"},{"name":"pivotal-container-service-0789"}]}]}`)
+			Ω(tile.Backup()).Should(Equal(ErrPKSDeployment([]string{"pivotal-container-service-0456", "pivotal-container-service-0789"})))
+		})
+
+		It("should fail when a cluster has no master", func() {
+			answer("service-instance_9b0c-instances", `{"Tables":[{"Rows":[{"instance":"worker/7a8b"}]}]}`)
+			Ω(tile.Backup()).Should(Equal(ErrPKSInstance("service-instance_9b0c", "master")))
+		})
+
+		It("should fail when a command fails on the instance", func() {
+			ioutil.WriteFile(path.Join(bin, "ssh-fails"), nil, 0644)
+			err := tile.Backup()
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring("permission denied"))
+		})
+
+		It("should fail a truncated dump", func() {
+			ioutil.WriteFile(path.Join(bin, "uaa.sql"), []byte("CREATE TABLE users (id int);\n"), 0644)
+			Ω(tile.Backup()).Should(Equal(ErrTruncatedDump(path.Join(dest, "pks", "uaa.sql"))))
+		})
+	})
+
+	Describe("Restore", func() {
+		It("should copy the dumps onto the control plane and load them", func() {
+			tile.Backup()
+			os.Remove(path.Join(bin, "calls"))
+			Ω(tile.Restore()).Should(BeNil())
+			Ω(calls()).Should(ContainElement("--json --non-interactive -d pivotal-container-service-0456 scp " + path.Join(dest, "pks", "uaa.sql") + " pivotal-container-service/1a2b:/tmp/cfops-uaa.sql"))
+			Ω(strings.Join(calls(), "\n")).ShouldNot(ContainSubstring("service-instance_"))
+		})
+	})
+
+	Describe("Verify", func() {
+		It("should fail when the backup is missing an etcd snapshot its index lists", func() {
+			tile.Backup()
+			os.Remove(path.Join(dest, "pks", "clusters", "service-instance_9b0c.db"))
+			Ω(Verify(dest, []string{PKS})).Should(Equal(ErrMissingArtifact(path.Join(dest, "pks", "clusters", "service-instance_9b0c.db"))))
+		})
+	})
+})
@@ -0,0 +1,390 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/cfbackup"
+)
+
+const (
+	// the kinds of step a restore plan lists
+	PlanPrepare   = "prepare"
+	PlanUpload    = "upload"
+	PlanSSH       = "ssh"
+	PlanStop      = "stop"
+	PlanOverwrite = "overwrite"
+	PlanStart     = "start"
+	PlanCheck     = "check"
+	// planUnknownAddress stands in for an address the installation settings
+	// of the backup are not at hand to tell yet, e.g. before a restic restore
+	planUnknownAddress = "(from the installation settings of the backup)"
+)
+
+type (
+	// RestorePlan is the ordered list of what a restore would do to the
+	// foundation, for approving it before it runs
+	RestorePlan struct {
+		Destination string     `json:"destination"`
+		Target      string     `json:"target"`
+		Steps       []PlanStep `json:"steps"`
+	}
+
+	// PlanStep is one action of a restore plan
+	PlanStep struct {
+		Tile        string `json:"tile,omitempty"`
+		Kind        string `json:"kind"`
+		Target      string `json:"target,omitempty"`
+		Description string `json:"description"`
+		// Skipped is set for the steps an interrupted restore already completed
+		Skipped bool `json:"skipped,omitempty"`
+	}
+)
+
+// PlanRestore lists what a restore with the flags would do, in order, without
+// touching the foundation or the destination
+func PlanRestore(fs flagSet) (plan *RestorePlan, err error) {
+	var checkpoint *RestoreCheckpoint
+	plan = &RestorePlan{Destination: fs.Dest(), Target: fs.Host()}
+	tiles := []string{OpsMgr, ER}
+
+	if hasTilelistFlag(fs) {
+		tiles = formatArray(strings.Split(fs.Tilelist(), ","))
+	}
+
+	if err = checkPointInTime(fs); err != nil {
+		return
+	}
+
+	if !fs.RestartRestore() {
+		if checkpoint, err = OpenCheckpoint(fs.Dest()); err != nil {
+			return
+		}
+	}
+	plan.prepare(fs)
+
+	for _, tileName := range tiles {
+		skipped := checkpoint != nil && checkpoint.Completed(tileName)
+
+		switch tileName {
+		case OpsMgr:
+			plan.opsManager(fs, skipped)
+
+		case ER:
+			var scope cfbackup.Checkpoint
+
+			if checkpoint != nil {
+				scope = checkpoint.Scope(tileName)
+			}
+
+			if err = plan.elasticRuntime(fs, skipped, scope); err != nil {
+				return
+			}
+
+		case PKS:
+			plan.pks(fs, skipped)
+
+		default:
+			return nil, ErrUnsupportedTile(tileName)
+		}
+	}
+	plan.finish(fs)
+	return
+}
+
+func (s *RestorePlan) add(step PlanStep) {
+	s.Steps = append(s.Steps, step)
+}
+
+// prepare lists what is done to the backup before anything is restored
+func (s *RestorePlan) prepare(fs flagSet) {
+	if fs.BBRArtifact() != "" {
+		s.add(PlanStep{Kind: PlanPrepare, Description: fmt.Sprintf("import the bosh-backup-restore backup %s into %s", fs.BBRArtifact(), fs.Dest())})
+	}
+
+	if fs.Restic().Enabled() {
+		snapshot := fs.Restic().Snapshot
+
+		if snapshot == "" {
+			snapshot = "latest"
+		}
+		s.add(PlanStep{Kind: PlanPrepare, Description: fmt.Sprintf("restore snapshot %s of %s from restic repository %s into %s", snapshot, fs.Host(), fs.Restic().Repository, fs.Dest())})
+	}
+
+	if _, statErr := os.Stat(path.Join(fs.Dest(), ArchiveName)); statErr == nil {
+		s.add(PlanStep{Kind: PlanPrepare, Description: "extract the restored artifacts from " + ArchiveName})
+	}
+
+	if from, to, steps, err := restoreMigrations(fs); err == nil {
+		for _, migration := range steps {
+			s.add(PlanStep{Kind: PlanPrepare, Description: fmt.Sprintf("migrate the backup from elastic runtime %s towards %s with %s", from, to, migration.Name)})
+		}
+	}
+
+	if fs.Remap() != "" {
+		s.add(PlanStep{Kind: PlanPrepare, Description: fmt.Sprintf("remap the domains and networks of the installation settings and database dumps with %s", fs.Remap())})
+	}
+
+	if fs.BlobstoreCategories() != "" {
+		s.add(PlanStep{Kind: PlanPrepare, Description: fmt.Sprintf("limit the blobstore to %s", fs.BlobstoreCategories())})
+	}
+}
+
+func (s *RestorePlan) opsManager(fs flagSet, skipped bool) {
+	for _, upload := range []struct{ url, filename, replaces string }{
+		{cfbackup.OPSMGR_INSTALLATION_SETTINGS_URL, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME, "installation settings"},
+		{cfbackup.OPSMGR_INSTALLATION_ASSETS_URL, cfbackup.OPSMGR_INSTALLATION_ASSETS_FILENAME, "installation assets"},
+	} {
+		s.add(PlanStep{
+			Tile:        OpsMgr,
+			Kind:        PlanUpload,
+			Target:      fmt.Sprintf(upload.url, fs.Host()),
+			Description: fmt.Sprintf("replace the %s of ops manager with %s", upload.replaces, upload.filename),
+			Skipped:     skipped,
+		})
+	}
+	s.add(PlanStep{
+		Tile:        OpsMgr,
+		Kind:        PlanSSH,
+		Target:      fs.OpsManagerUser() + "@" + fs.Host(),
+		Description: "remove " + cfbackup.OPSMGR_DEPLOYMENTS_FILE,
+		Skipped:     skipped,
+	})
+}
+
+func (s *RestorePlan) elasticRuntime(fs flagSet, skipped bool, checkpoint cfbackup.Checkpoint) (err error) {
+	var er *cfbackup.ElasticRuntime
+
+	if er, err = planElasticRuntime(fs); err != nil {
+		return
+	}
+	director := planAddress(er.SystemsInfo[cfbackup.ER_DIRECTOR])
+	deployment := "the elastic runtime deployment"
+
+	if er.InstallationName != "" {
+		deployment = "deployment " + er.InstallationName
+	}
+	s.add(PlanStep{
+		Tile:        ER,
+		Kind:        PlanStop,
+		Target:      director,
+		Description: fmt.Sprintf("stop the cloud controller jobs of %s through the bosh director", deployment),
+		Skipped:     skipped,
+	})
+
+	for _, system := range er.PersistentSystems {
+		component := system.Get(cfbackup.SD_COMPONENT)
+		step := PlanStep{
+			Tile:    ER,
+			Kind:    PlanOverwrite,
+			Target:  cfbackup.ER_DEFAULT_SYSTEM_USER + "@" + planAddress(system),
+			Skipped: skipped || (checkpoint != nil && checkpoint.Completed(component)),
+		}
+		artifact := erArtifact(component)
+
+		switch info := system.(type) {
+		case *cfbackup.PgInfo:
+			step.Description = fmt.Sprintf("overwrite postgres database %s from %s", info.Database, artifact)
+
+		case *cfbackup.MysqlInfo:
+			step.Description = fmt.Sprintf("overwrite every mysql database from %s", artifact)
+
+		case *cfbackup.NfsInfo:
+			step.Description = fmt.Sprintf("extract %s over %s", artifact, path.Join(cfbackup.NFS_DIR_PATH, cfbackup.NFS_ARCHIVE_DIR))
+
+			if fs.BlobstoreCategories() != "" {
+				step.Description += ", replacing only " + fs.BlobstoreCategories()
+			}
+
+		default:
+			step.Description = "overwrite " + component + " from " + artifact
+		}
+		s.add(step)
+	}
+	s.add(PlanStep{
+		Tile:        ER,
+		Kind:        PlanStart,
+		Target:      director,
+		Description: fmt.Sprintf("start the cloud controller jobs of %s again", deployment),
+		Skipped:     skipped,
+	})
+	return
+}
+
+func (s *RestorePlan) pks(fs flagSet, skipped bool) {
+	deployment := "the " + pksProduct + " deployment"
+
+	if fs.PKS().Deployment != "" {
+		deployment = "deployment " + fs.PKS().Deployment
+	}
+
+	for _, database := range PKSDatabases {
+		s.add(PlanStep{
+			Tile:        PKS,
+			Kind:        PlanOverwrite,
+			Target:      deployment,
+			Description: fmt.Sprintf("overwrite mysql database %s of the pks api from %s through bosh ssh", database, pksArtifact(database)),
+			Skipped:     skipped,
+		})
+	}
+}
+
+// finish lists what is done once every tile is restored
+func (s *RestorePlan) finish(fs flagSet) {
+	if config := fs.Binlogs(); !config.PointInTime.IsZero() {
+		s.add(PlanStep{Kind: PlanOverwrite, Target: config.Host, Description: fmt.Sprintf("replay the binlogs of %s into mysql up to %s", config.Dir, config.PointInTime.UTC().Format(time.RFC3339))})
+	}
+
+	if config := fs.ApplyChanges(); config.Enabled {
+		description := "apply changes on ops manager and wait for them to finish"
+
+		if len(config.Products) > 0 {
+			description = fmt.Sprintf("apply changes to %s on ops manager and wait for them to finish", strings.Join(config.Products, ", "))
+		}
+		s.add(PlanStep{Kind: PlanStart, Target: opsManagerBase(fs.Host()), Description: description})
+	}
+
+	if config := fs.HealthCheck(); config.Enabled {
+		deployments := "every deployment"
+
+		if len(config.Deployments) > 0 {
+			deployments = strings.Join(config.Deployments, ", ")
+		}
+		s.add(PlanStep{Kind: PlanCheck, Description: "run bosh cloud-check in report mode and check the instances of " + deployments})
+	}
+
+	if config := fs.SmokeTests(); config.Enabled {
+		description := "log in as " + config.User
+		tests := config.tests()
+
+		if len(tests) > 0 {
+			description += " and run the " + strings.Join(tests, ", ") + " smoke tests"
+		}
+		s.add(PlanStep{Kind: PlanCheck, Target: config.API, Description: description})
+	}
+}
+
+// planElasticRuntime is the elastic runtime a restore would build, with the
+// addresses of its stores read from the installation settings of the backup
+// as they would be restored, when they are at hand
+func planElasticRuntime(fs flagSet) (er *cfbackup.ElasticRuntime, err error) {
+	var settings []byte
+	er = cfbackup.NewElasticRuntime("", fs.Dest())
+
+	if settings, err = planSettings(fs); err == nil && settings != nil {
+		err = readPlanCredentials(er, settings)
+	}
+
+	if err == nil {
+		err = selectComponents(er, ER, fs.Components())
+	}
+	return
+}
+
+func readPlanCredentials(er *cfbackup.ElasticRuntime, settings []byte) (err error) {
+	var file *os.File
+
+	if file, err = ioutil.TempFile("", "cfops-plan"); err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(settings)
+	file.Close()
+
+	if err == nil {
+		er.JsonFile = file.Name()
+		err = er.ReadAllUserCredentials()
+	}
+	return
+}
+
+// planSettings reads the installation settings of the backup, from the
+// destination or its archive, remapped when the restore remaps them. They
+// are nil when the backup is not at hand yet
+func planSettings(fs flagSet) (settings []byte, err error) {
+	var (
+		archive  *Archive
+		closer   io.Closer
+		contents io.ReadCloser
+		config   RemapConfig
+		remapper *Remapper
+	)
+	name := path.Join(cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME)
+	archivePath := path.Join(fs.Dest(), ArchiveName)
+
+	if settings, err = ioutil.ReadFile(path.Join(fs.Dest(), name)); os.IsNotExist(err) {
+		settings, err = nil, nil
+
+		if _, statErr := os.Stat(archivePath); statErr != nil {
+			return
+		}
+
+		if archive, closer, err = OpenArchiveFile(archivePath); err != nil {
+			return
+		}
+		defer closer.Close()
+
+		if contents, err = archive.Open(name); err != nil {
+			return
+		}
+		settings, err = ioutil.ReadAll(contents)
+		contents.Close()
+	}
+
+	if err != nil || fs.Remap() == "" {
+		return
+	}
+
+	if config, err = LoadRemapConfig(fs.Remap()); err != nil {
+		return
+	}
+
+	if remapper, err = NewRemapper(config); err == nil {
+		settings = []byte(remapper.Remap(string(settings)))
+	}
+	return
+}
+
+func planAddress(system cfbackup.SystemDump) string {
+	if system == nil || system.Get(cfbackup.SD_IP) == "" {
+		return planUnknownAddress
+	}
+	return system.Get(cfbackup.SD_IP)
+}
+
+// WritePlan prints the steps of a restore plan, numbered in the order they run
+func WritePlan(w io.Writer, plan *RestorePlan) {
+	fmt.Fprintf(w, "restore of %s to %s\n", plan.Destination, plan.Target)
+
+	for i, step := range plan.Steps {
+		line := fmt.Sprintf("%3d. %-9s", i+1, step.Kind)
+
+		if step.Tile != "" {
+			line += " " + step.Tile + ":"
+		}
+		line += " " + step.Description
+
+		if step.Target != "" {
+			line += " (" + step.Target + ")"
+		}
+
+		if step.Skipped {
+			line += " [already restored, skipped]"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// WritePlanJSON writes the restore plan as json, for tooling around change
+// approval
+func WritePlanJSON(w io.Writer, plan *RestorePlan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
@@ -0,0 +1,185 @@
+package cfops_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// installationSettings describes the elastic runtime stores at the addresses
+// given by component, as ops manager exports them
+func installationSettings(ips map[string]string) []byte {
+	job := func(component, identity string) map[string]interface{} {
+		return map[string]interface{}{
+			"identifier": component,
+			"properties": []map[string]interface{}{
+				{"value": map[string]string{"identity": identity, "password": identity + "-secret"}},
+				{"value": map[string]string{"identity": "vcap", "password": "vcap-secret"}},
+			},
+		}
+	}
+	product := func(identifier, name string, jobs ...map[string]interface{}) map[string]interface{} {
+		addresses := make(map[string][]string)
+
+		for _, job := range jobs {
+			component := job["identifier"].(string)
+			addresses[component+"-guid"] = []string{ips[component]}
+		}
+		return map[string]interface{}{"identifier": identifier, "installation_name": name, "jobs": jobs, "ips": addresses}
+	}
+	settings, _ := json.Marshal(map[string]interface{}{
+		"products": []map[string]interface{}{
+			product("microbosh", "microbosh-1", job("director", "director")),
+			product("cf", "cf-8d8e1d", job("consoledb", "root"), job("uaadb", "root"), job("ccdb", "admin"), job("nfs_server", "vcap"), job("mysql", "root")),
+		},
+	})
+	return settings
+}
+
+var _ = Describe("Restore plan", func() {
+	var (
+		dir          string
+		settingsPath string
+		fs           *mockFlagSet
+	)
+	ips := map[string]string{
+		"director":   "10.0.16.5",
+		"consoledb":  "10.0.16.21",
+		"uaadb":      "10.0.16.22",
+		"ccdb":       "10.0.16.20",
+		"nfs_server": "10.0.16.30",
+		"mysql":      "10.0.16.40",
+	}
+
+	describe := func(plan *RestorePlan) (steps []string) {
+		for _, step := range plan.Steps {
+			line := step.Kind + " " + step.Target
+
+			if step.Skipped {
+				line += " skipped"
+			}
+			steps = append(steps, line)
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "plan")
+		settingsPath = path.Join(dir, cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME)
+		os.MkdirAll(path.Dir(settingsPath), 0700)
+		ioutil.WriteFile(settingsPath, installationSettings(ips), 0600)
+		fs = &mockFlagSet{host: "opsman.example.com", opsUser: "ubuntu", tileListFlag: "opsmanager, er", dest: dir, lockDir: dir}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should list the uploads, connections and overwrites of the restore in order", func() {
+		plan, err := PlanRestore(fs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(describe(plan)).Should(Equal([]string{
+			"upload https://opsman.example.com/api/installation_settings",
+			"upload https://opsman.example.com/api/installation_asset_collection",
+			"ssh ubuntu@opsman.example.com",
+			"stop 10.0.16.5",
+			"overwrite vcap@10.0.16.21",
+			"overwrite vcap@10.0.16.22",
+			"overwrite vcap@10.0.16.20",
+			"overwrite vcap@10.0.16.30",
+			"overwrite vcap@10.0.16.40",
+			"start 10.0.16.5",
+		}))
+		Ω(plan.Steps[3].Description).Should(ContainSubstring("deployment cf-8d8e1d"))
+		Ω(plan.Steps[6].Description).Should(Equal("overwrite postgres database ccdb from ccdb.backup"))
+		Ω(plan.Steps[7].Description).Should(Equal("extract nfs_server.backup over /var/vcap/store/shared"))
+	})
+
+	It("should leave the destination as it was", func() {
+		PlanRestore(fs)
+		files, _ := ioutil.ReadDir(dir)
+		Ω(files).Should(HaveLen(1))
+		Ω(path.Join(dir, CheckpointFileName)).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should only list the chosen components and the steps around the restore", func() {
+		fs.tileListFlag = "er"
+		fs.components = "ccdb"
+		fs.applyChanges = ApplyChangesConfig{Enabled: true}
+		fs.healthCheck = HealthCheckConfig{Enabled: true, Deployments: []string{"cf-8d8e1d"}}
+		plan, err := PlanRestore(fs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(describe(plan)).Should(Equal([]string{
+			"stop 10.0.16.5",
+			"overwrite vcap@10.0.16.20",
+			"start 10.0.16.5",
+			"start https://opsman.example.com",
+			"check ",
+		}))
+	})
+
+	It("should list the databases of the pks control plane it overwrites", func() {
+		fs.tileListFlag = "pks"
+		fs.pks = PKSConfig{Deployment: "pivotal-container-service-0456"}
+		plan, err := PlanRestore(fs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(describe(plan)).Should(Equal([]string{
+			"overwrite deployment pivotal-container-service-0456",
+			"overwrite deployment pivotal-container-service-0456",
+		}))
+		Ω(plan.Steps[1].Description).Should(Equal("overwrite mysql database uaa of the pks api from pks/uaa.sql through bosh ssh"))
+	})
+
+	It("should mark the steps an interrupted restore completed as skipped", func() {
+		checkpoint, _ := OpenCheckpoint(dir)
+		checkpoint.MarkCompleted(OpsMgr)
+		checkpoint.Scope(ER).MarkCompleted("consoledb")
+		plan, err := PlanRestore(fs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(describe(plan)[0]).Should(HaveSuffix(" skipped"))
+		Ω(describe(plan)[4]).Should(Equal("overwrite vcap@10.0.16.21 skipped"))
+		Ω(describe(plan)[5]).Should(Equal("overwrite vcap@10.0.16.22"))
+
+		fs.restart = true
+		plan, _ = PlanRestore(fs)
+		Ω(describe(plan)[0]).ShouldNot(HaveSuffix(" skipped"))
+	})
+
+	It("should list the addresses the remapped installation settings restore to", func() {
+		ioutil.WriteFile(path.Join(dir, "remap.yml"), []byte("networks:\n- from: 10.0.16.0/20\n  to: 10.8.32.0/20\n"), 0600)
+		fs.remap = path.Join(dir, "remap.yml")
+		plan, err := PlanRestore(fs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(plan.Steps[0].Kind).Should(Equal(PlanPrepare))
+		Ω(describe(plan)).Should(ContainElement("overwrite vcap@10.8.32.20"))
+	})
+
+	It("should say where addresses come from when the backup is not at hand yet", func() {
+		os.Remove(settingsPath)
+		fs.restic = ResticConfig{Repository: "/srv/restic"}
+		plan, err := PlanRestore(fs)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(plan.Steps[0].Description).Should(ContainSubstring("restore snapshot latest"))
+		Ω(describe(plan)).Should(ContainElement("stop (from the installation settings of the backup)"))
+	})
+
+	It("should print the plan numbered", func() {
+		var out bytes.Buffer
+		fs.tileListFlag = "opsmanager"
+		plan, _ := PlanRestore(fs)
+		WritePlan(&out, plan)
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Ω(lines).Should(HaveLen(4))
+		Ω(lines[0]).Should(Equal("restore of " + dir + " to opsman.example.com"))
+		Ω(lines[3]).Should(Equal("  3. ssh       OPSMANAGER: remove " + cfbackup.OPSMGR_DEPLOYMENTS_FILE + " (ubuntu@opsman.example.com)"))
+	})
+})
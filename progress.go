@@ -0,0 +1,157 @@
+package cfops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// the statuses of the steps of a restore
+	StepPending   = "pending"
+	StepRunning   = "running"
+	StepCompleted = "completed"
+	StepFailed    = "failed"
+)
+
+// RestoreStep is the progress of one step of a restore, numbered in the order
+// the steps run
+type RestoreStep struct {
+	Number      int        `json:"number"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	Started     *time.Time `json:"started,omitempty"`
+	Seconds     float64    `json:"seconds,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// restoreSteps lists the steps a restore of the tiles runs, one for each
+// tile and, for the elastic runtime, one for each of its stores
+func restoreSteps(fs flagSet) (steps []RestoreStep, err error) {
+	for _, tileName := range formatArray(strings.Split(fs.Tilelist(), ",")) {
+		if tileName != ER {
+			steps = append(steps, RestoreStep{Name: tileName, Description: "restoring " + strings.ToLower(tileName)})
+			continue
+		}
+		er := cfbackup.NewElasticRuntime("", fs.Dest())
+
+		if err = selectComponents(er, tileName, fs.Components()); err != nil {
+			return
+		}
+
+		for _, system := range er.PersistentSystems {
+			component := system.Get(cfbackup.SD_COMPONENT)
+			steps = append(steps, RestoreStep{Name: tileName + stepSeparator + component, Description: "importing " + component})
+		}
+	}
+	return
+}
+
+// PlanSteps numbers the steps of the restore and persists them. Steps an
+// interrupted restore completed keep the progress it recorded
+func (s *RestoreCheckpoint) PlanSteps(steps []RestoreStep) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	earlier := make(map[string]RestoreStep)
+
+	for _, step := range s.Progress {
+		earlier[step.Name] = step
+	}
+	s.Progress = nil
+
+	for i, step := range steps {
+		step.Number, step.Status = i+1, StepPending
+
+		if _, completed := s.Steps[step.Name]; completed {
+			step.Status = StepCompleted
+
+			if previous, ok := earlier[step.Name]; ok && previous.Status == StepCompleted {
+				step.Started, step.Seconds = previous.Started, previous.Seconds
+			}
+		}
+		s.Progress = append(s.Progress, step)
+	}
+	return s.save()
+}
+
+// StartStep logs and persists that the step is running, and returns the
+// function recording its outcome. Steps that were not planned are ignored
+func (s *RestoreCheckpoint) StartStep(name string) (finish func(error)) {
+	var step *RestoreStep
+	finish = func(error) {}
+
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.Progress {
+		if s.Progress[i].Name == name {
+			step = &s.Progress[i]
+		}
+	}
+
+	if step == nil {
+		return
+	}
+	started := time.Now().UTC()
+	step.Status, step.Started, step.Error = StepRunning, &started, ""
+	total := len(s.Progress)
+	lo.G.Info("step %d/%d: %s", step.Number, total, step.Description)
+	s.saveProgress()
+
+	return func(err error) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		step.Status, step.Seconds = StepCompleted, time.Since(started).Seconds()
+
+		if err != nil {
+			step.Status, step.Error = StepFailed, err.Error()
+		}
+		lo.G.Info("step %d/%d %s after %s: %s", step.Number, total, step.Status, seconds(step.Seconds), step.Description)
+		s.saveProgress()
+	}
+}
+
+// saveProgress persists the checkpoint, warning rather than failing the step
+// when it cannot
+func (s *RestoreCheckpoint) saveProgress() {
+	if err := s.save(); err != nil {
+		warn("unable to record the progress of the restore: %s", err)
+	}
+}
+
+// save writes the checkpoint to the destination and its local copy, the
+// caller holding the mutex
+func (s *RestoreCheckpoint) save() (err error) {
+	var contents []byte
+	s.Updated = time.Now().UTC()
+
+	if contents, err = json.MarshalIndent(s, "", "  "); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(s.path, contents, 0600)
+
+	if s.local != "" {
+		// the local copy outlives a destination that went away, and the
+		// destination copy a host that did
+		localErr := os.MkdirAll(path.Dir(s.local), 0700)
+
+		if localErr == nil {
+			localErr = ioutil.WriteFile(s.local, contents, 0600)
+		}
+
+		if err == nil {
+			err = localErr
+		}
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package cfops
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrQuiesceDeploymentFormat = "unable to tell which deployment is the elastic runtime among %s, name it with --quiescedeployment"
+	// partitionSeparator joins a job to the partition ops manager names its
+	// instance groups with, e.g. clock_global-partition-7a8d1e
+	partitionSeparator = "-partition-"
+)
+
+var (
+	// DefaultQuiesceJobs are the instance groups of the elastic runtime a
+	// backup quiesces by default, the background jobs of the cloud controller
+	DefaultQuiesceJobs = []string{"cloud_controller_worker", "clock_global"}
+)
+
+type (
+	// QuiesceConfig describes the instance groups of the elastic runtime a
+	// backup stops before it starts, and starts again once it is over. The bosh
+	// cli reads the director and its credentials from BOSH_ENVIRONMENT,
+	// BOSH_CLIENT, BOSH_CLIENT_SECRET and BOSH_CA_CERT
+	QuiesceConfig struct {
+		Enabled bool
+		// Deployment is the elastic runtime deployment, the only cf- deployment
+		// of the director when empty
+		Deployment string
+		// Jobs are the jobs to stop, DefaultQuiesceJobs when empty. Adding
+		// diego_brain pauses staging and the convergence of apps too
+		Jobs []string
+		// Binary defaults to bosh on the path
+		Binary string
+	}
+)
+
+func ErrQuiesceDeployment(deployments []string) error {
+	return fmt.Errorf(ErrQuiesceDeploymentFormat, "["+strings.Join(deployments, ", ")+"]")
+}
+
+// Quiesce stops the running instance groups of the jobs of the configuration,
+// in order. It returns the instance groups it stopped and the function
+// starting them again, which has already run when quiescing fails part way.
+// Instance groups that were not running are left alone
+func Quiesce(config QuiesceConfig) (quiesced []string, resume func() error, err error) {
+	var groups []string
+	deployment := config.Deployment
+	resume = func() error { return nil }
+
+	if deployment == "" {
+		if deployment, err = config.findDeployment(); err != nil {
+			return
+		}
+	}
+
+	if groups, err = config.runningGroups(deployment); err != nil {
+		return
+	}
+
+	if len(groups) == 0 {
+		warn("nothing to quiesce, none of the instance groups of %s running the jobs is running", deployment)
+	}
+	resume = func() (resumeErr error) {
+		for i := len(quiesced) - 1; i >= 0; i-- {
+			lo.G.Info("starting %s of %s again", quiesced[i], deployment)
+
+			if _, startErr := runBosh(config.Binary, "-d", deployment, "start", quiesced[i]); startErr != nil {
+				lo.G.Error("unable to start %s of %s again, start it with bosh: %s", quiesced[i], deployment, startErr)
+				resumeErr = firstError(resumeErr, startErr)
+			}
+		}
+		return
+	}
+
+	for _, group := range groups {
+		lo.G.Info("stopping %s of %s for the backup", group, deployment)
+
+		if _, err = runBosh(config.Binary, "-d", deployment, "stop", group, "--soft"); err != nil {
+			// the group may have stopped regardless, so it is started again
+			quiesced = append(quiesced, group)
+			resume()
+			return nil, func() error { return nil }, err
+		}
+		quiesced = append(quiesced, group)
+	}
+	return
+}
+
+func (s QuiesceConfig) findDeployment() (deployment string, err error) {
+	var names []string
+
+	if deployment, names, err = elasticRuntimeDeployment(s.Binary); err == nil && deployment == "" {
+		err = ErrQuiesceDeployment(names)
+	}
+	return
+}
+
+// elasticRuntimeDeployment is the only cf- deployment of the director, none
+// when it has another number of them, along with the names of all of its
+// deployments
+func elasticRuntimeDeployment(binary string) (deployment string, names []string, err error) {
+	var (
+		rows  []map[string]string
+		found []string
+	)
+
+	if rows, err = runBosh(binary, "deployments"); err != nil {
+		return
+	}
+
+	for _, row := range rows {
+		names = append(names, row["name"])
+
+		if strings.HasPrefix(row["name"], ertProduct+"-") {
+			found = append(found, row["name"])
+		}
+	}
+
+	if len(found) == 1 {
+		deployment = found[0]
+	}
+	return
+}
+
+// instanceGroupOf tells whether the instance group runs the job, being named
+// after it or one of its partitions
+func instanceGroupOf(group, job string) bool {
+	return group == job || strings.HasPrefix(group, job+partitionSeparator)
+}
+
+// runningGroups are the instance groups of the deployment that run one of
+// the jobs of the configuration, named after it or one of its partitions
+func (s QuiesceConfig) runningGroups(deployment string) (groups []string, err error) {
+	var rows []map[string]string
+	jobs := s.Jobs
+	seen := make(map[string]bool)
+
+	if len(jobs) == 0 {
+		jobs = DefaultQuiesceJobs
+	}
+
+	if rows, err = runBosh(s.Binary, "-d", deployment, "instances"); err != nil {
+		return
+	}
+
+	for _, job := range jobs {
+		for _, row := range rows {
+			group := strings.SplitN(row["instance"], "/", 2)[0]
+
+			if seen[group] || row["process_state"] != instanceRunning {
+				continue
+			}
+
+			if instanceGroupOf(group, job) {
+				seen[group] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package cfops_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Quiesce", func() {
+	var (
+		bin    string
+		config QuiesceConfig
+	)
+
+	answer := func(command, output string, fail bool) {
+		ioutil.WriteFile(path.Join(bin, command+".json"), []byte(output), 0644)
+
+		if fail {
+			ioutil.WriteFile(path.Join(bin, command+".fail"), nil, 0644)
+		}
+	}
+
+	calls := func() []string {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		bin, _ = ioutil.TempDir("", "bosh-bin")
+		ioutil.WriteFile(path.Join(bin, "bosh"), []byte(fakeBosh), 0755)
+		answer("deployments", `{"Tables":[{"Rows":[{"name":"cf-0123"},{"name":"p-mysql-4567"}]}]}`, false)
+		answer("instances", `{"Tables":[{"Rows":[
+			{"instance":"clock_global-partition-7a8d1e/0 (3f2c)","process_state":"running"},
+			{"instance":"cloud_controller_worker-partition-7a8d1e/0 (91bd)","process_state":"running"},
+			{"instance":"cloud_controller_worker-partition-7a8d1e/1 (c07a)","process_state":"running"},
+			{"instance":"diego_brain-partition-7a8d1e/0 (5e21)","process_state":"stopped"},
+			{"instance":"router-partition-7a8d1e/0 (88aa)","process_state":"running"}
+		]}]}`, false)
+		answer("stop", `{"Tables":[],"Lines":["Succeeded"]}`, false)
+		answer("start", `{"Tables":[],"Lines":["Succeeded"]}`, false)
+		config = QuiesceConfig{Enabled: true, Binary: path.Join(bin, "bosh")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bin)
+	})
+
+	It("should stop the running instance groups of the jobs of the elastic runtime, and start them again in reverse", func() {
+		quiesced, resume, err := Quiesce(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(quiesced).Should(Equal([]string{"cloud_controller_worker-partition-7a8d1e", "clock_global-partition-7a8d1e"}))
+		Ω(resume()).Should(Succeed())
+		Ω(calls()).Should(Equal([]string{
+			"--json --non-interactive deployments",
+			"--json --non-interactive -d cf-0123 instances",
+			"--json --non-interactive -d cf-0123 stop cloud_controller_worker-partition-7a8d1e --soft",
+			"--json --non-interactive -d cf-0123 stop clock_global-partition-7a8d1e --soft",
+			"--json --non-interactive -d cf-0123 start clock_global-partition-7a8d1e",
+			"--json --non-interactive -d cf-0123 start cloud_controller_worker-partition-7a8d1e",
+		}))
+	})
+
+	It("should leave the instance groups that were not running alone", func() {
+		config.Deployment = "cf-0123"
+		config.Jobs = []string{"diego_brain"}
+		quiesced, _, err := Quiesce(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(quiesced).Should(BeEmpty())
+		Ω(calls()).Should(Equal([]string{"--json --non-interactive -d cf-0123 instances"}))
+	})
+
+	It("should ask for the deployment when it cannot tell which is the elastic runtime", func() {
+		answer("deployments", `{"Tables":[{"Rows":[{"name":"cf-0123"},{"name":"cf-4567"}]}]}`, false)
+		_, _, err := Quiesce(config)
+		Ω(err).Should(MatchError(ErrQuiesceDeployment([]string{"cf-0123", "cf-4567"})))
+	})
+
+	It("should start again what it stopped when quiescing fails", func() {
+		answer("stop", `{"Tables":[],"Lines":["Task 12 error"]}`, true)
+		_, resume, err := Quiesce(config)
+		Ω(err).Should(HaveOccurred())
+		Ω(resume()).Should(Succeed())
+		Ω(calls()[len(calls())-1]).Should(Equal("--json --non-interactive -d cf-0123 start cloud_controller_worker-partition-7a8d1e"))
+	})
+
+	Describe("running a backup", func() {
+		var (
+			dir  string
+			tile *mockTile
+			fs   *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "quiesce")
+			tile = &mockTile{}
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return tile, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir, lockDir: dir, quiesce: config}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should record the instance groups it quiesced", func() {
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entry.Quiesced).Should(HaveLen(2))
+			Ω(calls()).Should(ContainElement(ContainSubstring("start cloud_controller_worker")))
+		})
+
+		It("should resume normal operation when the backup fails", func() {
+			tile.ErrReturned = errors.New("dump failed")
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(HaveOccurred())
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+			Ω(calls()[len(calls())-1]).Should(ContainSubstring("start cloud_controller_worker"))
+		})
+
+		It("should not back up a foundation it could not quiesce", func() {
+			answer("stop", `{"Tables":[],"Lines":["Task 12 error"]}`, true)
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(HaveOccurred())
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+			Ω(tile.RunCount).Should(BeZero())
+		})
+
+		It("should fail a backup that left the foundation quiesced", func() {
+			answer("start", `{"Tables":[],"Lines":["Task 13 error"]}`, true)
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(HaveOccurred())
+			Ω(entry.Status).Should(Equal(SetComplete))
+		})
+	})
+})
@@ -0,0 +1,54 @@
+package cfops
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/xchapter7x/lo"
+)
+
+// CredentialSource resolves the admin credentials of ops manager again once
+// it refuses the ones the run started with, as when they are rotated or
+// expire during a long run
+type CredentialSource func() (user, pass string, err error)
+
+var credentialSources = struct {
+	sync.Mutex
+	sources map[string]CredentialSource
+}{sources: make(map[string]CredentialSource)}
+
+// SetCredentialSource has the calls to the ops manager at host that it
+// refuses the credentials of resolve them again from the source and retry,
+// rather than fail the run. A nil source has them fail as ever
+func SetCredentialSource(host string, source CredentialSource) {
+	credentialSources.Lock()
+	defer credentialSources.Unlock()
+
+	if source == nil {
+		delete(credentialSources.sources, opsManagerBase(host))
+		return
+	}
+	credentialSources.sources[opsManagerBase(host)] = source
+}
+
+// reauthenticate resolves the credentials of the client again after ops
+// manager refused them with the status, telling whether the call is worth
+// retrying with them
+func (s *opsManagerClient) reauthenticate(status int) bool {
+	credentialSources.Lock()
+	source := credentialSources.sources[s.base]
+	credentialSources.Unlock()
+
+	if status != http.StatusUnauthorized || source == nil {
+		return false
+	}
+	lo.G.Warning("ops manager %s refused the credentials of %s, resolving them again", s.base, s.user)
+	user, pass, err := source()
+
+	if err != nil {
+		lo.G.Error("unable to resolve the credentials of ops manager %s again: %s", s.base, err)
+		return false
+	}
+	s.user, s.pass = user, pass
+	return true
+}
@@ -0,0 +1,77 @@
+package cfops_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetCredentialSource", func() {
+	var (
+		server    *httptest.Server
+		passwords []string
+		resolved  int
+	)
+
+	BeforeEach(func() {
+		passwords, resolved = nil, 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pass, _ := r.BasicAuth()
+			passwords = append(passwords, pass)
+
+			if pass != "rotated" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"certificates":[{"product_guid":"cf-0123","location":"ops_manager"}]}`)
+		}))
+	})
+
+	AfterEach(func() {
+		SetCredentialSource(server.URL, nil)
+		server.Close()
+	})
+
+	It("should retry a refused call with the credentials resolved again", func() {
+		SetCredentialSource(server.URL, func() (string, string, error) {
+			resolved++
+			return "admin", "rotated", nil
+		})
+		certificates, err := DeployedCertificates(server.URL, "admin", "expired")
+		Ω(err).Should(BeNil())
+		Ω(certificates).Should(HaveLen(1))
+		Ω(resolved).Should(Equal(1))
+		Ω(passwords).Should(Equal([]string{"expired", "rotated"}))
+	})
+
+	It("should fail the call when the credentials can not be resolved again", func() {
+		SetCredentialSource(server.URL, func() (string, string, error) {
+			return "", "", errors.New("vault is sealed")
+		})
+		_, err := DeployedCertificates(server.URL, "admin", "expired")
+		Ω(err).ShouldNot(BeNil())
+		Ω(passwords).Should(Equal([]string{"expired"}))
+	})
+
+	It("should retry a call only once", func() {
+		SetCredentialSource(server.URL, func() (string, string, error) {
+			resolved++
+			return "admin", "wrong", nil
+		})
+		_, err := DeployedCertificates(server.URL, "admin", "expired")
+		Ω(err).ShouldNot(BeNil())
+		Ω(passwords).Should(Equal([]string{"expired", "wrong"}))
+	})
+
+	It("should fail a refused call as ever without a source", func() {
+		_, err := DeployedCertificates(server.URL, "admin", "expired")
+		Ω(err).ShouldNot(BeNil())
+		Ω(passwords).Should(Equal([]string{"expired"}))
+	})
+})
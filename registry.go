@@ -0,0 +1,378 @@
+package cfops
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/gtils/retry"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	OCIManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// BackupArtifactType marks a manifest in the registry as a cfops backup.
+	// Its config is the catalog entry of the run and each layer an artifact
+	BackupArtifactType    = "application/vnd.cfops.backup.v1"
+	BackupConfigMediaType = "application/vnd.cfops.backup.config.v1+json"
+	BackupLayerMediaType  = "application/vnd.cfops.artifact.v1"
+	// ociTitleAnnotation names the file a layer is pulled into
+	ociTitleAnnotation          = "org.opencontainers.image.title"
+	ociCreatedAnnotation        = "org.opencontainers.image.created"
+	maxTagLength                = 128
+	ErrRegistryFormat           = "registry %s %s responded with %s"
+	ErrRegistryRepositoryFormat = "invalid registry repository %q, expected e.g. harbor.example.com/cfops/foundation"
+)
+
+type (
+	// RegistryConfig describes the repository of an oci registry, such as
+	// harbor or artifactory, backups are pushed to
+	RegistryConfig struct {
+		// Repository is the host of the registry followed by the repository
+		Repository string
+		User       string
+		Pass       string
+		// PlainHTTP talks to the registry over http, for labs
+		PlainHTTP bool
+	}
+
+	ociDescriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int64             `json:"size"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	ociManifest struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		ArtifactType  string            `json:"artifactType"`
+		Config        ociDescriptor     `json:"config"`
+		Layers        []ociDescriptor   `json:"layers"`
+		Annotations   map[string]string `json:"annotations,omitempty"`
+	}
+
+	registryClient struct {
+		base          string
+		name          string
+		config        RegistryConfig
+		authorization string
+	}
+)
+
+func ErrRegistry(method, target, status string) error {
+	return fmt.Errorf(ErrRegistryFormat, method, target, status)
+}
+
+func ErrRegistryRepository(repository string) error {
+	return fmt.Errorf(ErrRegistryRepositoryFormat, repository)
+}
+
+// Enabled tells whether backups should be pushed to a registry at all
+func (s RegistryConfig) Enabled() bool {
+	return s.Repository != ""
+}
+
+// RegistryTag is the tag a backup is pushed under: its idempotency key when it
+// has one, the start of the run otherwise
+func RegistryTag(entry *CatalogEntry) string {
+	tag := strings.TrimLeft(lockNameSanitizer.ReplaceAllString(entry.IdempotencyKey, "_"), ".-")
+
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+
+	if tag != "" {
+		return tag
+	}
+	return entry.Started.UTC().Format(RunDirFormat)
+}
+
+// PushBackup pushes the artifacts of the run found in the destination to the
+// registry, one layer each, under the tag of the run. Blobs the registry
+// already holds are not uploaded again. It returns the digest reference of
+// the manifest
+func PushBackup(config RegistryConfig, destination string, entry *CatalogEntry) (reference string, err error) {
+	var (
+		client   *registryClient
+		layer    ociDescriptor
+		contents []byte
+	)
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     OCIManifestMediaType,
+		ArtifactType:  BackupArtifactType,
+		Annotations:   map[string]string{ociCreatedAnnotation: entry.Started.UTC().Format(time.RFC3339)},
+	}
+
+	if client, err = newRegistryClient(config); err != nil {
+		return
+	}
+
+	if err = client.authenticate(); err != nil {
+		return
+	}
+
+	for _, artifact := range backupArtifacts(entry) {
+		artifactPath := path.Join(destination, artifact)
+
+		if _, statErr := os.Stat(artifactPath); statErr != nil {
+			continue
+		}
+		lo.G.Info("pushing %s to %s", artifact, config.Repository)
+
+		if layer, err = client.pushFile(artifactPath); err != nil {
+			return
+		}
+		layer.Annotations = map[string]string{ociTitleAnnotation: artifact}
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+
+	if contents, err = json.Marshal(entry); err != nil {
+		return
+	}
+
+	if manifest.Config, err = client.pushBytes(BackupConfigMediaType, contents); err != nil {
+		return
+	}
+
+	if contents, err = json.Marshal(manifest); err != nil {
+		return
+	}
+	tag := RegistryTag(entry)
+
+	if err = client.pushManifest(tag, contents); err == nil {
+		reference = config.Repository + "@" + digestOf(contents)
+		lo.G.Info("pushed backup %s as %s:%s", entry.ID, config.Repository, tag)
+	}
+	return
+}
+
+func newRegistryClient(config RegistryConfig) (client *registryClient, err error) {
+	parts := strings.SplitN(config.Repository, "/", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, ErrRegistryRepository(config.Repository)
+	}
+	scheme := "https"
+
+	if config.PlainHTTP {
+		scheme = "http"
+	}
+	return &registryClient{base: scheme + "://" + parts[0], name: parts[1], config: config}, nil
+}
+
+func digestOf(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// authenticate asks the registry how to authenticate: with the credentials
+// directly, or with a token fetched with them from its token service
+func (s *registryClient) authenticate() (err error) {
+	var response *http.Response
+
+	if response, err = s.do("GET", s.base+"/v2/", "", nil, 0); err != nil {
+		return
+	}
+	response.Body.Close()
+	challenge := response.Header.Get("WWW-Authenticate")
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+
+	case response.StatusCode != http.StatusUnauthorized:
+		err = ErrRegistry("GET", "/v2/", response.Status)
+
+	case strings.HasPrefix(strings.ToLower(challenge), "bearer "):
+		err = s.fetchToken(challenge)
+
+	default:
+		s.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(s.config.User+":"+s.config.Pass))
+	}
+	return
+}
+
+func (s *registryClient) fetchToken(challenge string) (err error) {
+	var (
+		request  *http.Request
+		response *http.Response
+		token    struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+	)
+	params := challengeParams(challenge)
+	query := url.Values{"scope": {"repository:" + s.name + ":pull,push"}}
+
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+
+	if request, err = http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil); err != nil {
+		return
+	}
+
+	if s.config.User != "" {
+		request.SetBasicAuth(s.config.User, s.config.Pass)
+	}
+
+	if response, err = retry.Default().Request(archiveClient, request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return ErrRegistry("GET", params["realm"], response.Status)
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&token); err == nil {
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		s.authorization = "Bearer " + token.Token
+	}
+	return
+}
+
+// challengeParams reads the parameters of a challenge like
+// Bearer realm="https://auth.example.com/token",service="registry"
+func challengeParams(challenge string) (params map[string]string) {
+	params = make(map[string]string)
+
+	if i := strings.Index(challenge, " "); i >= 0 {
+		challenge = challenge[i+1:]
+	}
+
+	for _, pair := range strings.Split(challenge, ",") {
+		if parts := strings.SplitN(strings.TrimSpace(pair), "=", 2); len(parts) == 2 {
+			params[strings.ToLower(parts[0])] = strings.Trim(parts[1], `"`)
+		}
+	}
+	return
+}
+
+func (s *registryClient) do(method, target, contentType string, body io.Reader, size int64) (response *http.Response, err error) {
+	var request *http.Request
+
+	if request, err = http.NewRequest(method, target, body); err != nil {
+		return
+	}
+	request.ContentLength = size
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	if s.authorization != "" {
+		request.Header.Set("Authorization", s.authorization)
+	}
+	return retry.Default().Request(archiveClient, request)
+}
+
+// pushFile uploads a file as a blob, reading it once for its digest and once
+// more to upload it
+func (s *registryClient) pushFile(filePath string) (descriptor ociDescriptor, err error) {
+	var (
+		file *os.File
+		size int64
+	)
+
+	if file, err = os.Open(filePath); err != nil {
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+
+	if size, err = io.Copy(hash, file); err != nil {
+		return
+	}
+
+	if _, err = file.Seek(0, 0); err != nil {
+		return
+	}
+	descriptor = ociDescriptor{
+		MediaType: BackupLayerMediaType,
+		Digest:    "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      size,
+	}
+	err = s.pushBlob(descriptor, file)
+	return
+}
+
+func (s *registryClient) pushBytes(mediaType string, contents []byte) (descriptor ociDescriptor, err error) {
+	descriptor = ociDescriptor{MediaType: mediaType, Digest: digestOf(contents), Size: int64(len(contents))}
+	err = s.pushBlob(descriptor, bytes.NewReader(contents))
+	return
+}
+
+// pushBlob uploads a blob in a single request, unless the registry already
+// has it
+func (s *registryClient) pushBlob(descriptor ociDescriptor, contents io.Reader) (err error) {
+	var (
+		response *http.Response
+		location *url.URL
+	)
+	blobs := s.base + "/v2/" + s.name + "/blobs/"
+
+	if response, err = s.do("HEAD", blobs+descriptor.Digest, "", nil, 0); err != nil {
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		lo.G.Debug("registry already has blob " + descriptor.Digest)
+		return
+	}
+
+	if response, err = s.do("POST", blobs+"uploads/", "", nil, 0); err != nil {
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return ErrRegistry("POST", blobs+"uploads/", response.Status)
+	}
+
+	if location, err = response.Request.URL.Parse(response.Header.Get("Location")); err != nil {
+		return
+	}
+	query := location.Query()
+	query.Set("digest", descriptor.Digest)
+	location.RawQuery = query.Encode()
+
+	if response, err = s.do("PUT", location.String(), "application/octet-stream", contents, descriptor.Size); err != nil {
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		err = ErrRegistry("PUT", blobs+descriptor.Digest, response.Status)
+	}
+	return
+}
+
+func (s *registryClient) pushManifest(tag string, contents []byte) (err error) {
+	var response *http.Response
+	target := s.base + "/v2/" + s.name + "/manifests/" + tag
+
+	if response, err = s.do("PUT", target, OCIManifestMediaType, bytes.NewReader(contents), int64(len(contents))); err != nil {
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		err = ErrRegistry("PUT", target, response.Status)
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package cfops_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRegistry implements enough of the oci distribution api, behind token
+// authentication, to push to
+type fakeRegistry struct {
+	mutex     sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+	server    *httptest.Server
+}
+
+func newFakeRegistry() *fakeRegistry {
+	registry := &fakeRegistry{blobs: make(map[string][]byte), manifests: make(map[string][]byte)}
+	registry.server = httptest.NewServer(registry)
+	return registry
+}
+
+func (s *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.URL.Path == "/token" {
+		if user, pass, _ := r.BasicAuth(); user != "ci" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"registry-token"}`)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer registry-token" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, s.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.URL.Path == "/v2/":
+
+	case r.Method == "HEAD" && strings.Contains(r.URL.Path, "/blobs/"):
+		if _, ok := s.blobs[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/upload/1")
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/"):
+		sum := sha256.Sum256(body)
+
+		if digest := r.URL.Query().Get("digest"); digest == "sha256:"+hex.EncodeToString(sum[:]) {
+			s.uploads++
+			s.blobs[digest] = body
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+	case r.Method == "PUT" && strings.Contains(r.URL.Path, "/manifests/"):
+		s.manifests[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var _ = Describe("PushBackup", func() {
+	var (
+		dir      string
+		registry *fakeRegistry
+		config   RegistryConfig
+		entry    *CatalogEntry
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "registry")
+		writeArtifacts(dir, BackupArtifacts[OpsMgr])
+		registry = newFakeRegistry()
+		config = RegistryConfig{
+			Repository: strings.TrimPrefix(registry.server.URL, "http://") + "/cfops/prod",
+			User:       "ci",
+			Pass:       "secret",
+			PlainHTTP:  true,
+		}
+		entry = &CatalogEntry{ID: "run", Action: Backup, Status: SetComplete, Started: time.Unix(1000, 0), Components: []ComponentResult{{Name: OpsMgr}}}
+	})
+
+	AfterEach(func() {
+		registry.server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should push each artifact as a layer of a backup manifest", func() {
+		reference, err := PushBackup(config, dir, entry)
+		Ω(err).Should(BeNil())
+		manifest := registry.manifests["/v2/cfops/prod/manifests/"+RegistryTag(entry)]
+		Ω(manifest).ShouldNot(BeNil())
+		sum := sha256.Sum256(manifest)
+		Ω(reference).Should(Equal(config.Repository + "@sha256:" + hex.EncodeToString(sum[:])))
+
+		var pushed struct {
+			ArtifactType string `json:"artifactType"`
+			Config       struct{ Digest string }
+			Layers       []struct {
+				Digest      string
+				Annotations map[string]string
+			}
+		}
+		json.Unmarshal(manifest, &pushed)
+		Ω(pushed.ArtifactType).Should(Equal(BackupArtifactType))
+		Ω(pushed.Layers).Should(HaveLen(len(BackupArtifacts[OpsMgr])))
+		Ω(pushed.Layers[0].Annotations["org.opencontainers.image.title"]).Should(Equal(BackupArtifacts[OpsMgr][0]))
+		Ω(string(registry.blobs[pushed.Config.Digest])).Should(ContainSubstring(`"id":"run"`))
+	})
+
+	It("should only upload the blobs the registry does not have yet", func() {
+		PushBackup(config, dir, entry)
+		uploads := registry.uploads
+		entry.IdempotencyKey = "build-2"
+		_, err := PushBackup(config, dir, entry)
+		Ω(err).Should(BeNil())
+		// only the config, which records the run, differs
+		Ω(registry.uploads).Should(Equal(uploads + 1))
+		Ω(registry.manifests).Should(HaveKey("/v2/cfops/prod/manifests/build-2"))
+	})
+
+	It("should fail when the token service refuses the credentials", func() {
+		config.Pass = "wrong"
+		_, err := PushBackup(config, dir, entry)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should reject a repository without a host", func() {
+		config.Repository = "cfops"
+		_, err := PushBackup(config, dir, entry)
+		Ω(err).Should(Equal(ErrRegistryRepository("cfops")))
+	})
+})
@@ -0,0 +1,314 @@
+package cfops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	ErrRemapDomainFormat   = "remap: domain %q to %q: both are required"
+	ErrRemapIPFormat       = "remap: %q is not an ipv4 address"
+	ErrRemapNetworkFormat  = "remap: network %s cannot be remapped to %s, they must be ipv4 networks of the same size"
+	ErrRemapNetworkCIDRFmt = "remap: %q is not an ipv4 cidr"
+)
+
+type (
+	// RemapConfig is the file describing how the addressing of the foundation
+	// a backup was taken of maps onto the foundation it is restored to, e.g.
+	// a disaster recovery foundation with its own domains and networks
+	RemapConfig struct {
+		SystemDomain RemapPair `yaml:"system_domain"`
+		AppsDomain   RemapPair `yaml:"apps_domain"`
+		// Networks move every address in a cidr to the same offset in
+		// another cidr of the same size
+		Networks []RemapPair `yaml:"networks"`
+		// StaticIPs move single addresses, ahead of the networks
+		StaticIPs []RemapPair `yaml:"static_ips"`
+	}
+
+	RemapPair struct {
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+	}
+
+	// Remapper rewrites the domains and addresses of a remap config wherever
+	// they appear in text
+	Remapper struct {
+		domains  []RemapPair
+		ips      map[string]string
+		networks []networkRemap
+	}
+
+	networkRemap struct {
+		from, to *net.IPNet
+	}
+)
+
+var (
+	// RemapArtifacts are the artifacts holding addressing a restore remaps:
+	// the installation settings of ops manager and the database dumps
+	RemapArtifacts = remapArtifacts()
+)
+
+func ErrRemapDomain(from, to string) error {
+	return fmt.Errorf(ErrRemapDomainFormat, from, to)
+}
+
+func ErrRemapIP(ip string) error {
+	return fmt.Errorf(ErrRemapIPFormat, ip)
+}
+
+func ErrRemapNetwork(from, to string) error {
+	return fmt.Errorf(ErrRemapNetworkFormat, from, to)
+}
+
+func ErrRemapNetworkCIDR(cidr string) error {
+	return fmt.Errorf(ErrRemapNetworkCIDRFmt, cidr)
+}
+
+func remapArtifacts() (artifacts []string) {
+	artifacts = append(artifacts, path.Join(cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME))
+
+	for _, dump := range DatabaseDumps {
+		artifacts = append(artifacts, dump.Artifact)
+	}
+	return
+}
+
+// LoadRemapConfig reads a remap config from a yaml file
+func LoadRemapConfig(configPath string) (config RemapConfig, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(configPath); err == nil {
+		err = yaml.Unmarshal(contents, &config)
+	}
+	return
+}
+
+// NewRemapper validates the config, failing on the first mapping that is
+// incomplete or whose addresses don't parse
+func NewRemapper(config RemapConfig) (remapper *Remapper, err error) {
+	remapper = &Remapper{ips: make(map[string]string)}
+
+	for _, domain := range []RemapPair{config.SystemDomain, config.AppsDomain} {
+		switch {
+		case domain.From == "" && domain.To == "":
+			continue
+
+		case domain.From == "" || domain.To == "":
+			return nil, ErrRemapDomain(domain.From, domain.To)
+		}
+		remapper.domains = append(remapper.domains, RemapPair{From: strings.Trim(domain.From, "."), To: strings.Trim(domain.To, ".")})
+	}
+	// the apps domain is often a parent of the system domain, or the other
+	// way around, and a name must map by the longest domain it is under
+	sort.SliceStable(remapper.domains, func(i, j int) bool {
+		return len(remapper.domains[i].From) > len(remapper.domains[j].From)
+	})
+
+	for _, ip := range config.StaticIPs {
+		from, to := net.ParseIP(ip.From).To4(), net.ParseIP(ip.To).To4()
+
+		switch {
+		case from == nil:
+			return nil, ErrRemapIP(ip.From)
+
+		case to == nil:
+			return nil, ErrRemapIP(ip.To)
+		}
+		remapper.ips[from.String()] = to.String()
+	}
+
+	for _, network := range config.Networks {
+		var remap networkRemap
+
+		if remap.from, err = parseIPv4Network(network.From); err != nil {
+			return nil, err
+		}
+
+		if remap.to, err = parseIPv4Network(network.To); err != nil {
+			return nil, err
+		}
+		fromSize, _ := remap.from.Mask.Size()
+		toSize, _ := remap.to.Mask.Size()
+
+		if fromSize != toSize {
+			return nil, ErrRemapNetwork(network.From, network.To)
+		}
+		remapper.networks = append(remapper.networks, remap)
+	}
+	return
+}
+
+func parseIPv4Network(cidr string) (network *net.IPNet, err error) {
+	if _, network, err = net.ParseCIDR(cidr); err != nil || network.IP.To4() == nil {
+		return nil, ErrRemapNetworkCIDR(cidr)
+	}
+	network.IP = network.IP.To4()
+	return
+}
+
+// Remap rewrites every name under a remapped domain and every remapped
+// address in the text. Names and addresses are only matched whole, so a
+// domain is not remapped inside a longer name that merely ends like it
+func (s *Remapper) Remap(text string) string {
+	var (
+		out   bytes.Buffer
+		start = -1
+	)
+	out.Grow(len(text))
+
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && isNameByte(text[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			out.WriteString(s.remapToken(text[start:i]))
+			start = -1
+		}
+
+		if i < len(text) {
+			out.WriteByte(text[i])
+		}
+	}
+	return out.String()
+}
+
+// RemapStream remaps the text read from in, line by line, into out
+func (s *Remapper) RemapStream(in io.Reader, out io.Writer) (err error) {
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+
+	for err == nil {
+		var line string
+
+		if line, err = reader.ReadString('\n'); len(line) > 0 {
+			if _, writeErr := writer.WriteString(s.Remap(line)); writeErr != nil {
+				return writeErr
+			}
+		}
+	}
+
+	if err == io.EOF {
+		err = writer.Flush()
+	}
+	return
+}
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '.' || b == '-'
+}
+
+// remapToken remaps a run of the characters names and addresses are made of,
+// such as a name, an address or a range of addresses like 10.0.0.1-10.0.0.9,
+// leaving the dots it starts or ends with, e.g. of a wildcard or a sentence
+func (s *Remapper) remapToken(token string) string {
+	name := strings.Trim(token, ".")
+
+	if name == "" {
+		return token
+	}
+	lead := token[:strings.Index(token, name)]
+	trail := token[len(lead)+len(name):]
+
+	if addresses := strings.Split(name, "-"); s.allIPv4(addresses) {
+		for i, address := range addresses {
+			addresses[i] = s.remapIP(address)
+		}
+		return lead + strings.Join(addresses, "-") + trail
+	}
+
+	for _, domain := range s.domains {
+		if name == domain.From {
+			return lead + domain.To + trail
+
+		} else if strings.HasSuffix(name, "."+domain.From) {
+			return lead + strings.TrimSuffix(name, domain.From) + domain.To + trail
+		}
+	}
+	return token
+}
+
+func (s *Remapper) allIPv4(addresses []string) bool {
+	for _, address := range addresses {
+		if net.ParseIP(address).To4() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Remapper) remapIP(address string) string {
+	ip := net.ParseIP(address).To4()
+
+	if to, ok := s.ips[ip.String()]; ok {
+		return to
+	}
+
+	for _, network := range s.networks {
+		if network.from.Contains(ip) {
+			remapped := make(net.IP, net.IPv4len)
+
+			for i := range remapped {
+				remapped[i] = network.to.IP[i] | ip[i]&^network.from.Mask[i]
+			}
+			return remapped.String()
+		}
+	}
+	return address
+}
+
+// remapForRestore replaces the artifacts of the destination a restore reads
+// with remapped copies, when a remap config is given, returning the function
+// putting the originals back
+func remapForRestore(fs flagSet) (restore func(), err error) {
+	var (
+		config    RemapConfig
+		remapper  *Remapper
+		artifacts []string
+	)
+	restore = func() {}
+
+	if fs.Remap() == "" {
+		return
+	}
+
+	if config, err = LoadRemapConfig(fs.Remap()); err != nil {
+		return
+	}
+
+	if remapper, err = NewRemapper(config); err != nil {
+		return
+	}
+	selected := make(map[string]bool)
+
+	for _, artifact := range restoreArtifacts(fs.Tilelist(), fs.Components()) {
+		selected[artifact] = true
+	}
+
+	for _, artifact := range RemapArtifacts {
+		if selected[artifact] {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	return substituteArtifacts(fs.Dest(), artifacts, func(artifact string, in io.Reader, out io.Writer) error {
+		lo.G.Info("remapping the addressing of %s", artifact)
+		return remapper.RemapStream(in, out)
+	})
+}
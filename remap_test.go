@@ -0,0 +1,136 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pivotalservices/cfbackup"
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readingTile records the installation settings it is restored from
+type readingTile struct {
+	settingsPath string
+	settings     string
+}
+
+func (s *readingTile) Restore() (err error) {
+	var contents []byte
+	contents, err = ioutil.ReadFile(s.settingsPath)
+	s.settings = string(contents)
+	return
+}
+
+func (s *readingTile) Backup() error {
+	return nil
+}
+
+var _ = Describe("Remapper", func() {
+	var remapper *Remapper
+
+	BeforeEach(func() {
+		var err error
+		remapper, err = NewRemapper(RemapConfig{
+			SystemDomain: RemapPair{From: "sys.prod.example.com", To: "sys.dr.example.com"},
+			AppsDomain:   RemapPair{From: "prod.example.com", To: "apps.dr.example.com"},
+			Networks:     []RemapPair{{From: "10.0.16.0/20", To: "10.8.32.0/20"}},
+			StaticIPs:    []RemapPair{{From: "10.0.16.10", To: "10.9.0.10"}},
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("should remap names under the system and apps domains by the longest domain", func() {
+		Ω(remapper.Remap(`"uaa.sys.prod.example.com", https://api.sys.prod.example.com/v2`)).Should(Equal(`"uaa.sys.dr.example.com", https://api.sys.dr.example.com/v2`))
+		Ω(remapper.Remap("*.prod.example.com\tmyapp.prod.example.com.")).Should(Equal("*.apps.dr.example.com\tmyapp.apps.dr.example.com."))
+	})
+
+	It("should leave names that only end like a domain alone", func() {
+		Ω(remapper.Remap("preprod.example.com sys.prod.example.com.au")).Should(Equal("preprod.example.com sys.prod.example.com.au"))
+	})
+
+	It("should move addresses to the same offset in the new network", func() {
+		Ω(remapper.Remap(`"10.0.16.0/20", "10.0.17.5", "10.0.32.1"`)).Should(Equal(`"10.8.32.0/20", "10.8.33.5", "10.0.32.1"`))
+		Ω(remapper.Remap("reserved: 10.0.16.1-10.0.16.9")).Should(Equal("reserved: 10.8.32.1-10.8.32.9"))
+	})
+
+	It("should remap static addresses ahead of their network", func() {
+		Ω(remapper.Remap("10.0.16.10,10.0.16.11")).Should(Equal("10.9.0.10,10.8.32.11"))
+	})
+
+	It("should refuse networks of different sizes and incomplete mappings", func() {
+		_, err := NewRemapper(RemapConfig{Networks: []RemapPair{{From: "10.0.16.0/20", To: "10.8.0.0/16"}}})
+		Ω(err).Should(HaveOccurred())
+		_, err = NewRemapper(RemapConfig{SystemDomain: RemapPair{From: "sys.prod.example.com"}})
+		Ω(err).Should(HaveOccurred())
+		_, err = NewRemapper(RemapConfig{StaticIPs: []RemapPair{{From: "10.0.16.10", To: "dr"}}})
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Describe("restoring to a new foundation", func() {
+		var (
+			dir          string
+			settingsPath string
+			opsmgr       *readingTile
+			fs           *mockFlagSet
+		)
+		settings := `{"system_domain":"sys.prod.example.com","subnets":[{"cidr":"10.0.16.0/20"}]}`
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "remap")
+			settingsPath = path.Join(dir, cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME)
+			os.MkdirAll(path.Dir(settingsPath), 0700)
+			ioutil.WriteFile(settingsPath, []byte(settings), 0600)
+			ioutil.WriteFile(path.Join(dir, "remap.yml"), []byte(`system_domain:
+  from: sys.prod.example.com
+  to: sys.dr.example.com
+networks:
+- from: 10.0.16.0/20
+  to: 10.8.32.0/20
+`), 0600)
+
+			opsmgr = &readingTile{settingsPath: settingsPath}
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return opsmgr, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir, lockDir: dir, remap: path.Join(dir, "remap.yml")}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should restore from the remapped installation settings", func() {
+			Ω(RunPipeline(fs, Restore)).Should(Succeed())
+			Ω(opsmgr.settings).Should(Equal(`{"system_domain":"sys.dr.example.com","subnets":[{"cidr":"10.8.32.0/20"}]}`))
+		})
+
+		It("should leave the backup as it was taken", func() {
+			RunPipeline(fs, Restore)
+			contents, _ := ioutil.ReadFile(settingsPath)
+			Ω(string(contents)).Should(Equal(settings))
+			_, err := os.Stat(settingsPath + OriginalArtifactSuffix)
+			Ω(os.IsNotExist(err)).Should(BeTrue())
+		})
+
+		It("should put back the original an interrupted restore left", func() {
+			os.Rename(settingsPath, settingsPath+OriginalArtifactSuffix)
+			ioutil.WriteFile(settingsPath, []byte("half remapped"), 0600)
+			Ω(RunPipeline(fs, Restore)).Should(Succeed())
+			Ω(opsmgr.settings).Should(ContainSubstring("sys.dr.example.com"))
+			contents, _ := ioutil.ReadFile(settingsPath)
+			Ω(string(contents)).Should(Equal(settings))
+		})
+
+		It("should not restore with an invalid remap config", func() {
+			ioutil.WriteFile(fs.remap, []byte("networks:\n- from: 10.0.16.0/20\n  to: nowhere\n"), 0600)
+			Ω(RunPipeline(fs, Restore)).ShouldNot(Succeed())
+			Ω(opsmgr.settings).Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,180 @@
+package cfops
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ReportJSON = "json"
+	ReportCSV  = "csv"
+
+	ErrReportFormatFormat = "unknown report format %q, expected json or csv"
+	// unknownFoundation groups the runs recorded before the catalog kept the
+	// foundation of each run
+	unknownFoundation = "unknown"
+)
+
+type (
+	// Report is the catalog rendered as evidence of the backups taken: the
+	// state of the backups of each foundation, and every run
+	Report struct {
+		Generated   time.Time          `json:"generated"`
+		Foundations []FoundationReport `json:"foundations"`
+		Runs        []*CatalogEntry    `json:"runs"`
+	}
+
+	// FoundationReport is the state of the backups of a foundation. The age
+	// of its last successful backup is left out when it has never had one
+	FoundationReport struct {
+		Foundation                     string              `json:"foundation"`
+		LastBackupStatus               string              `json:"last_backup_status"`
+		LastSuccessfulBackup           *time.Time          `json:"last_successful_backup,omitempty"`
+		LastSuccessfulBackupAgeSeconds *int64              `json:"last_successful_backup_age_seconds,omitempty"`
+		LastVerification               *VerificationResult `json:"last_verification,omitempty"`
+	}
+)
+
+func ErrReportFormat(format string) error {
+	return fmt.Errorf(ErrReportFormatFormat, format)
+}
+
+// NewReport reports on the catalog as of now
+func NewReport(catalog *Catalog, now time.Time) (report Report) {
+	foundations := make(map[string]*FoundationReport)
+	report = Report{Generated: now.UTC(), Runs: catalog.Entries}
+
+	for _, entry := range catalog.Entries {
+		if entry.Action != Backup {
+			continue
+		}
+		name := entry.Foundation
+
+		if name == "" {
+			name = unknownFoundation
+		}
+		foundation, ok := foundations[name]
+
+		if !ok {
+			foundation = &FoundationReport{Foundation: name}
+			foundations[name] = foundation
+		}
+		// the entries are in the order they started, the last one wins
+		foundation.LastBackupStatus = entry.Status
+
+		if entry.Status == SetComplete {
+			finished := entry.Finished
+			age := int64(now.Sub(finished).Seconds())
+			foundation.LastSuccessfulBackup = &finished
+			foundation.LastSuccessfulBackupAgeSeconds = &age
+		}
+
+		if entry.Verification != nil {
+			foundation.LastVerification = entry.Verification
+		}
+	}
+
+	for _, foundation := range foundations {
+		report.Foundations = append(report.Foundations, *foundation)
+	}
+	sort.Sort(byFoundation(report.Foundations))
+	return
+}
+
+type byFoundation []FoundationReport
+
+func (s byFoundation) Len() int           { return len(s) }
+func (s byFoundation) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byFoundation) Less(i, j int) bool { return s[i].Foundation < s[j].Foundation }
+
+// WriteReport renders the report as json, or as csv of either the runs or
+// the foundations
+func WriteReport(w io.Writer, report Report, format string, foundations bool) (err error) {
+	switch format {
+	case ReportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+
+	case ReportCSV:
+		if foundations {
+			err = writeFoundationsCSV(w, report)
+
+		} else {
+			err = writeRunsCSV(w, report)
+		}
+
+	default:
+		err = ErrReportFormat(format)
+	}
+	return
+}
+
+func writeRunsCSV(w io.Writer, report Report) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "foundation", "action", "status", "started", "finished", "seconds", "bytes", "destination", "verified", "verification_passed", "verification_error"})
+
+	for _, entry := range report.Runs {
+		verified, passed, verificationErr, seconds := "", "", "", ""
+
+		if !entry.Finished.IsZero() {
+			seconds = strconv.FormatFloat(entry.Finished.Sub(entry.Started).Seconds(), 'f', 0, 64)
+		}
+
+		if entry.Verification != nil {
+			verified = entry.Verification.Time.Format(time.RFC3339)
+			passed = strconv.FormatBool(entry.Verification.Passed)
+			verificationErr = entry.Verification.Error
+		}
+		out.Write([]string{
+			entry.ID,
+			entry.Foundation,
+			entry.Action,
+			entry.Status,
+			entry.Started.Format(time.RFC3339),
+			formatTime(entry.Finished),
+			seconds,
+			strconv.FormatInt(entry.Bytes(), 10),
+			entry.Destination,
+			verified,
+			passed,
+			verificationErr,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeFoundationsCSV(w io.Writer, report Report) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"foundation", "last_backup_status", "last_successful_backup", "last_successful_backup_age_seconds", "last_verified", "last_verification_passed"})
+
+	for _, foundation := range report.Foundations {
+		row := []string{foundation.Foundation, foundation.LastBackupStatus, "", "", "", ""}
+
+		if foundation.LastSuccessfulBackup != nil {
+			row[2] = foundation.LastSuccessfulBackup.Format(time.RFC3339)
+			row[3] = strconv.FormatInt(*foundation.LastSuccessfulBackupAgeSeconds, 10)
+		}
+
+		if foundation.LastVerification != nil {
+			row[4] = foundation.LastVerification.Time.Format(time.RFC3339)
+			row[5] = strconv.FormatBool(foundation.LastVerification.Passed)
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
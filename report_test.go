@@ -0,0 +1,101 @@
+package cfops_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Report", func() {
+	var (
+		catalog *Catalog
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(100000, 0).UTC()
+		catalog = &Catalog{Entries: []*CatalogEntry{
+			{ID: "1", Foundation: "prod", Action: Backup, Status: SetComplete, Destination: "/backups/1", Started: now.Add(-2 * time.Hour), Finished: now.Add(-time.Hour)},
+			{ID: "2", Foundation: "prod", Action: Backup, Status: SetIncomplete, Destination: "/backups/2", Started: now.Add(-30 * time.Minute), Finished: now.Add(-20 * time.Minute)},
+			{ID: "3", Foundation: "dev", Action: Backup, Status: SetIncomplete, Destination: "/backups/3", Started: now.Add(-10 * time.Minute), Finished: now.Add(-5 * time.Minute)},
+			{ID: "4", Foundation: "prod", Action: Restore, Status: SetComplete, Destination: "/backups/1", Started: now.Add(-time.Minute)},
+		}}
+	})
+
+	Describe("NewReport", func() {
+		It("should report the age of the last successful backup of each foundation", func() {
+			report := NewReport(catalog, now)
+			Ω(report.Foundations).Should(HaveLen(2))
+			prod := report.Foundations[1]
+			Ω(prod.Foundation).Should(Equal("prod"))
+			Ω(prod.LastBackupStatus).Should(Equal(SetIncomplete))
+			Ω(*prod.LastSuccessfulBackupAgeSeconds).Should(Equal(int64(3600)))
+		})
+
+		It("should leave out the age of a foundation that never had a successful backup", func() {
+			dev := NewReport(catalog, now).Foundations[0]
+			Ω(dev.Foundation).Should(Equal("dev"))
+			Ω(dev.LastSuccessfulBackup).Should(BeNil())
+			Ω(dev.LastSuccessfulBackupAgeSeconds).Should(BeNil())
+		})
+
+		It("should include the last verification of a foundation", func() {
+			Ω(catalog.RecordVerification("/backups/1/", true, errors.New("ccdb is truncated"))).Should(BeTrue())
+			verification := NewReport(catalog, now).Foundations[1].LastVerification
+			Ω(verification.Passed).Should(BeFalse())
+			Ω(verification.Deep).Should(BeTrue())
+			Ω(verification.Error).Should(Equal("ccdb is truncated"))
+		})
+	})
+
+	Describe("RecordVerification", func() {
+		It("should not record the verification of a backup the catalog does not know", func() {
+			Ω(catalog.RecordVerification("/elsewhere", false, nil)).Should(BeFalse())
+		})
+	})
+
+	Describe("WriteReport", func() {
+		It("should render the whole report as json", func() {
+			var out bytes.Buffer
+			Ω(WriteReport(&out, NewReport(catalog, now), ReportJSON, false)).Should(BeNil())
+			var report map[string]interface{}
+			Ω(json.Unmarshal(out.Bytes(), &report)).Should(BeNil())
+			Ω(report["runs"]).Should(HaveLen(4))
+			Ω(report["foundations"]).Should(HaveLen(2))
+		})
+
+		It("should render a csv row per run", func() {
+			var out bytes.Buffer
+			catalog.RecordVerification("/backups/1", false, nil)
+			Ω(WriteReport(&out, NewReport(catalog, now), ReportCSV, false)).Should(BeNil())
+			rows, _ := csv.NewReader(&out).ReadAll()
+			Ω(rows).Should(HaveLen(5))
+			Ω(rows[1][:4]).Should(Equal([]string{"1", "prod", Backup, SetComplete}))
+			Ω(rows[1][6]).Should(Equal("3600"))
+			Ω(rows[1][10]).Should(Equal("true"))
+			Ω(rows[4][6]).Should(BeEmpty())
+		})
+
+		It("should render a csv row per foundation", func() {
+			var out bytes.Buffer
+			WriteReport(&out, NewReport(catalog, now), ReportCSV, true)
+			rows, _ := csv.NewReader(&out).ReadAll()
+			Ω(rows).Should(HaveLen(3))
+			Ω(rows[2][0]).Should(Equal("prod"))
+			Ω(rows[2][3]).Should(Equal("3600"))
+			Ω(rows[1][3]).Should(BeEmpty())
+		})
+
+		It("should refuse an unknown format", func() {
+			var out bytes.Buffer
+			Ω(WriteReport(&out, NewReport(catalog, now), "xml", false)).Should(Equal(ErrReportFormat("xml")))
+		})
+	})
+})
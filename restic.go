@@ -0,0 +1,224 @@
+package cfops
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// ResticTag marks the snapshots cfops takes, so forgetting old ones never
+	// touches anything else kept in the same repository
+	ResticTag                = "cfops"
+	ErrResticKeepFormat      = "invalid restic keep policy %q, expected e.g. daily=7,weekly=4"
+	ErrResticNoSnapshotMsg   = "the restic repository has no cfops snapshot of this foundation"
+	ErrResticNoSummaryFormat = "restic backup did not report a snapshot: %s"
+)
+
+var (
+	ErrResticNoSnapshot = errors.New(ErrResticNoSnapshotMsg)
+	// resticKeepUnits are the units of the keep policies of restic forget
+	resticKeepUnits = map[string]bool{"last": true, "hourly": true, "daily": true, "weekly": true, "monthly": true, "yearly": true}
+)
+
+type (
+	// ResticConfig describes a restic repository backups are kept in, getting
+	// its deduplication, encryption and pruning. The password is read by
+	// restic itself, from PasswordFile or the RESTIC_PASSWORD environment
+	ResticConfig struct {
+		Repository   string
+		PasswordFile string
+		// Keep is a policy like daily=7,weekly=4 applied after each backup,
+		// pruning the data only the forgotten snapshots referenced
+		Keep string
+		// Snapshot is the snapshot a restore reads, the latest when empty
+		Snapshot string
+		// Binary defaults to restic on the path
+		Binary string
+	}
+
+	resticSnapshot struct {
+		ID    string   `json:"id"`
+		Paths []string `json:"paths"`
+	}
+
+	resticMessage struct {
+		MessageType string `json:"message_type"`
+		SnapshotID  string `json:"snapshot_id"`
+	}
+)
+
+func ErrResticKeep(policy string) error {
+	return fmt.Errorf(ErrResticKeepFormat, policy)
+}
+
+func ErrResticNoSummary(output string) error {
+	return fmt.Errorf(ErrResticNoSummaryFormat, output)
+}
+
+// Enabled tells whether backups should be kept in a restic repository at all
+func (s ResticConfig) Enabled() bool {
+	return s.Repository != ""
+}
+
+// KeepArgs turns the keep policy into the arguments of restic forget
+func (s ResticConfig) KeepArgs() (args []string, err error) {
+	for _, pair := range strings.Split(s.Keep, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+
+		if len(parts) != 2 || !resticKeepUnits[parts[0]] {
+			return nil, ErrResticKeep(s.Keep)
+		}
+
+		if _, convErr := strconv.Atoi(parts[1]); convErr != nil {
+			return nil, ErrResticKeep(s.Keep)
+		}
+		args = append(args, "--keep-"+parts[0], parts[1])
+	}
+	return
+}
+
+func (s ResticConfig) run(args ...string) (out []byte, err error) {
+	var stderr bytes.Buffer
+	binary := s.Binary
+
+	if binary == "" {
+		binary = "restic"
+	}
+	cmd := execCommand(binary, append([]string{"--json"}, args...)...)
+	cmd.Env = append(os.Environ(), "RESTIC_REPOSITORY="+s.Repository)
+
+	if s.PasswordFile != "" {
+		cmd.Env = append(cmd.Env, "RESTIC_PASSWORD_FILE="+s.PasswordFile)
+	}
+	cmd.Stderr = &stderr
+
+	if out, err = cmd.Output(); err != nil {
+		err = fmt.Errorf("restic %s: %s %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return
+}
+
+// ResticBackup snapshots the artifacts of the run found in the destination
+// into the repository, tagged with the run, then forgets and prunes the
+// snapshots of the foundation the keep policy no longer covers. It returns
+// the id of the snapshot
+func ResticBackup(config ResticConfig, foundation, destination string, entry *CatalogEntry) (snapshot string, err error) {
+	var (
+		out      []byte
+		keepArgs []string
+		paths    []string
+	)
+
+	if keepArgs, err = config.KeepArgs(); err != nil {
+		return
+	}
+
+	if destination, err = filepath.Abs(destination); err != nil {
+		return
+	}
+
+	for _, artifact := range backupArtifacts(entry) {
+		if _, statErr := os.Stat(path.Join(destination, artifact)); statErr == nil {
+			paths = append(paths, path.Join(destination, artifact))
+		}
+	}
+	args := append([]string{"backup", "--host", foundation, "--tag", ResticTag, "--tag", "run=" + entry.ID}, paths...)
+	lo.G.Info("snapshotting %d artifacts into restic repository %s", len(paths), config.Repository)
+
+	if out, err = config.run(args...); err != nil {
+		return
+	}
+
+	if snapshot = resticSnapshotID(out); snapshot == "" {
+		return "", ErrResticNoSummary(strings.TrimSpace(string(out)))
+	}
+
+	if len(keepArgs) > 0 {
+		args = append([]string{"forget", "--host", foundation, "--tag", ResticTag, "--prune"}, keepArgs...)
+
+		if _, forgetErr := config.run(args...); forgetErr != nil {
+			warn("unable to forget old restic snapshots: %s", forgetErr)
+		}
+	}
+	return
+}
+
+// resticSnapshotID finds the snapshot in the summary restic backup ends its
+// json output with
+func resticSnapshotID(out []byte) (snapshot string) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+
+	for scanner.Scan() {
+		var message resticMessage
+
+		if json.Unmarshal(scanner.Bytes(), &message) == nil && message.MessageType == "summary" {
+			snapshot = message.SnapshotID
+		}
+	}
+	return
+}
+
+// ResticRestore restores the named artifacts of a snapshot of the foundation
+// into the destination, laid out as they were in the destination of the
+// backup
+func ResticRestore(config ResticConfig, foundation, destination string, artifacts []string) (err error) {
+	var (
+		out       []byte
+		snapshots []resticSnapshot
+	)
+	snapshot := config.Snapshot
+
+	if snapshot == "" {
+		snapshot = "latest"
+	}
+
+	if out, err = config.run("snapshots", "--host", foundation, "--tag", ResticTag, snapshot); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(out, &snapshots); err != nil {
+		return
+	}
+
+	if len(snapshots) == 0 {
+		return ErrResticNoSnapshot
+	}
+	found := snapshots[len(snapshots)-1]
+	root := snapshotRoot(found.Paths)
+	args := []string{"restore", found.ID + ":" + root, "--target", destination}
+
+	for _, artifact := range artifacts {
+		args = append(args, "--include", "/"+artifact)
+	}
+	lo.G.Info("restoring restic snapshot %s into %s", found.ID, destination)
+	_, err = config.run(args...)
+	return
+}
+
+// snapshotRoot is the destination a snapshot was backed up from, the path
+// of its artifacts without their path within the destination
+func snapshotRoot(paths []string) string {
+	for _, p := range paths {
+		for _, artifacts := range BackupArtifacts {
+			for _, artifact := range artifacts {
+				if strings.HasSuffix(p, "/"+artifact) {
+					return strings.TrimSuffix(p, "/"+artifact)
+				}
+			}
+		}
+	}
+	return "/"
+}
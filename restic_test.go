@@ -0,0 +1,89 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRestic records its arguments and the repository it was given, and
+// answers like restic would
+const fakeRestic = `#!/bin/sh
+echo "$RESTIC_REPOSITORY $*" >> "$(dirname "$0")/calls"
+case "$2" in
+backup)
+	echo '{"message_type":"status","percent_done":1}'
+	echo '{"message_type":"summary","snapshot_id":"4f2a9c"}'
+	;;
+snapshots)
+	echo '[{"id":"4f2a9c","paths":["/backups/prod/opsmanager/installation.json","/backups/prod/ccdb.backup"]}]'
+	;;
+esac
+`
+
+var _ = Describe("Restic", func() {
+	var (
+		dir    string
+		bin    string
+		config ResticConfig
+		entry  *CatalogEntry
+	)
+
+	calls := func() []string {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "restic")
+		bin, _ = ioutil.TempDir("", "restic-bin")
+		ioutil.WriteFile(path.Join(bin, "restic"), []byte(fakeRestic), 0755)
+		writeArtifacts(dir, BackupArtifacts[OpsMgr])
+		config = ResticConfig{Repository: "/srv/restic", Binary: path.Join(bin, "restic")}
+		entry = &CatalogEntry{ID: "run", Action: Backup, Components: []ComponentResult{{Name: OpsMgr}}}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		os.RemoveAll(bin)
+	})
+
+	Describe("ResticBackup", func() {
+		It("should snapshot the artifacts of the run tagged with it", func() {
+			snapshot, err := ResticBackup(config, "opsman.example.com", dir, entry)
+			Ω(err).Should(BeNil())
+			Ω(snapshot).Should(Equal("4f2a9c"))
+			Ω(calls()).Should(HaveLen(1))
+			Ω(calls()[0]).Should(HavePrefix("/srv/restic --json backup --host opsman.example.com --tag cfops --tag run=run "))
+			Ω(calls()[0]).Should(ContainSubstring(path.Join(dir, BackupArtifacts[OpsMgr][0])))
+		})
+
+		It("should forget and prune the snapshots the keep policy no longer covers", func() {
+			config.Keep = "daily=7, weekly=4"
+			ResticBackup(config, "opsman.example.com", dir, entry)
+			Ω(calls()).Should(HaveLen(2))
+			Ω(calls()[1]).Should(Equal("/srv/restic --json forget --host opsman.example.com --tag cfops --prune --keep-daily 7 --keep-weekly 4"))
+		})
+
+		It("should reject an invalid keep policy before snapshotting", func() {
+			config.Keep = "forever=1"
+			_, err := ResticBackup(config, "opsman.example.com", dir, entry)
+			Ω(err).Should(Equal(ErrResticKeep("forever=1")))
+			Ω(path.Join(bin, "calls")).ShouldNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("ResticRestore", func() {
+		It("should restore the artifacts into the destination as they were laid out", func() {
+			Ω(ResticRestore(config, "opsman.example.com", dir, []string{"ccdb.backup"})).Should(BeNil())
+			Ω(calls()[0]).Should(Equal("/srv/restic --json snapshots --host opsman.example.com --tag cfops latest"))
+			Ω(calls()[1]).Should(Equal("/srv/restic --json restore 4f2a9c:/backups/prod --target " + dir + " --include /ccdb.backup"))
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package cfops
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/gtils/retry"
+)
+
+const ErrRetryStatusesFormat = "invalid retry statuses %q, expected http status codes such as 500,408"
+
+// RetryConfig describes how the ssh dials, sftp writes, ops manager api calls
+// and storage uploads of a run are retried once they fail for a transient
+// reason: up to Attempts times in all, waiting Backoff before the second
+// attempt and twice as long before each one after that, never longer than
+// MaxBackoff. A request is also sent again when answered with one of the
+// Statuses, besides 429, 502, 503 and 504. A zero field keeps the default of
+// the retry policy
+type RetryConfig struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Statuses   []int
+}
+
+func ErrRetryStatuses(statuses string) error {
+	return fmt.Errorf(ErrRetryStatusesFormat, statuses)
+}
+
+// ParseRetryStatuses reads a comma separated list of http status codes, such
+// as 500,408
+func ParseRetryStatuses(statuses string) (parsed []int, err error) {
+	for _, status := range strings.Split(statuses, ",") {
+		var code int
+
+		if status = strings.TrimSpace(status); status == "" {
+			continue
+		}
+
+		if code, err = strconv.Atoi(status); err != nil || http.StatusText(code) == "" {
+			return nil, ErrRetryStatuses(statuses)
+		}
+		parsed = append(parsed, code)
+	}
+	return
+}
+
+// useRetryPolicy makes the config the retry policy of every remote operation
+// of the run, logging each retry as a warning. The returned function restores
+// the policy it replaced
+func useRetryPolicy(config RetryConfig) (restore func()) {
+	policy := retry.Default()
+
+	if config.Attempts > 0 {
+		policy.Attempts = config.Attempts
+	}
+
+	if config.Backoff > 0 {
+		policy.Backoff = config.Backoff
+	}
+
+	if config.MaxBackoff > 0 {
+		policy.MaxBackoff = config.MaxBackoff
+	}
+
+	if len(config.Statuses) > 0 {
+		policy.Statuses = config.Statuses
+	}
+	policy.OnRetry = func(operation string, attempt int, err error, wait time.Duration) {
+		warn("%s failed (attempt %d of %d), trying again in %s: %s", operation, attempt, policy.Attempts, wait, err)
+	}
+	return retry.SetDefault(policy)
+}
@@ -0,0 +1,95 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+	"github.com/pivotalservices/gtils/retry"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// flakyTile fails to dial once, then backs up
+type flakyTile struct {
+	attempts int
+	policy   retry.Policy
+}
+
+func (s *flakyTile) Backup() error {
+	s.policy = retry.Default()
+	return retry.Do("ssh dial opsman:22", func() error {
+		if s.attempts++; s.attempts < 2 {
+			return syscall.ECONNREFUSED
+		}
+		return nil
+	})
+}
+
+func (s *flakyTile) Restore() error { return nil }
+
+var _ = Describe("Retry policy", func() {
+	var (
+		dir  string
+		fs   *mockFlagSet
+		tile *flakyTile
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "retry")
+		writeArtifacts(dir, BackupArtifacts[OpsMgr])
+		tile = &flakyTile{}
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				return tile, nil
+			},
+		}
+		fs = &mockFlagSet{
+			tileListFlag: "opsmanager",
+			dest:         dir,
+			retry:        RetryConfig{Attempts: 5, Backoff: time.Millisecond, Statuses: []int{500}},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should retry the remote operations of the run as configured, warning of each retry", func() {
+		Ω(RunPipeline(fs, Backup)).Should(BeNil())
+		Ω(tile.attempts).Should(Equal(2))
+		Ω(tile.policy.Attempts).Should(Equal(5))
+		Ω(tile.policy.Backoff).Should(Equal(time.Millisecond))
+		Ω(tile.policy.MaxBackoff).Should(Equal(retry.DefaultMaxBackoff))
+		Ω(tile.policy.Statuses).Should(Equal([]int{500}))
+
+		var summary RunSummary
+		contents, _ := ioutil.ReadFile(path.Join(dir, SummaryName))
+		json.Unmarshal(contents, &summary)
+		Ω(summary.Warnings).Should(ContainElement(ContainSubstring("ssh dial opsman:22 failed (attempt 1 of 5)")))
+	})
+
+	It("should restore the default policy once the run ends", func() {
+		RunPipeline(fs, Backup)
+		Ω(retry.Default().Attempts).Should(Equal(retry.DefaultAttempts))
+	})
+})
+
+var _ = Describe("ParseRetryStatuses", func() {
+	It("should read a list of http status codes", func() {
+		Ω(ParseRetryStatuses(" 500, 408 ")).Should(Equal([]int{500, 408}))
+		Ω(ParseRetryStatuses("")).Should(BeEmpty())
+	})
+
+	It("should refuse anything but known status codes", func() {
+		for _, statuses := range []string{"500,boom", "999"} {
+			_, err := ParseRetryStatuses(statuses)
+			Ω(err).Should(Equal(ErrRetryStatuses(statuses)))
+		}
+	})
+})
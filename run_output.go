@@ -0,0 +1,206 @@
+package cfops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ErrDestinationTemplateFormat = "invalid destination template %q: %s"
+	// every tile of a backup set is kept in the one directory that verify,
+	// restore and the manifest read the set from
+	ErrDestinationTileMsg = "the tiles of a backup are kept together, so a destination can not be templated by tile"
+)
+
+type (
+	// DestinationFields are what a destination template can name, e.g.
+	// /backups/{{.Foundation}}/{{.Date}}
+	DestinationFields struct {
+		// Foundation is the ops manager host
+		Foundation string
+		// Date is the day the run started, e.g. 2026-10-15
+		Date string
+		// Time is the time of day the run started, e.g. 020304
+		Time string
+		// Timestamp is when the run started, e.g. 20261015T020304Z
+		Timestamp      string
+		IdempotencyKey string
+		// RunID identifies the run, e.g. 20261015T020304Z-1a2b3c4d
+		RunID string
+	}
+
+	// RunOutput is the machine readable outcome of a run, shaped like the
+	// output of a concourse resource: a version identifying the run, metadata
+	// to show with it, and the complete catalog entry
+	RunOutput struct {
+		Version  map[string]string `json:"version"`
+		Metadata []RunMetadata     `json:"metadata"`
+		Run      *CatalogEntry     `json:"run"`
+	}
+
+	RunMetadata struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	// RunResult is the run output along with the exit code of cfops and the
+	// error that failed the run
+	RunResult struct {
+		ExitCode int    `json:"exit_code"`
+		Error    string `json:"error,omitempty"`
+		RunOutput
+	}
+)
+
+// NewRunOutput describes the run recorded by the catalog entry
+func NewRunOutput(entry *CatalogEntry) (output RunOutput) {
+	output = RunOutput{
+		Version: map[string]string{"run_id": entry.ID},
+		Metadata: []RunMetadata{
+			{"action", entry.Action},
+			{"status", entry.Status},
+			{"destination", entry.Destination},
+			{"started", entry.Started.Format(time.RFC3339)},
+			{"finished", entry.Finished.Format(time.RFC3339)},
+			{"bytes", strconv.FormatInt(entry.Bytes(), 10)},
+		},
+		Run: entry,
+	}
+
+	if entry.IdempotencyKey != "" {
+		output.Version["idempotency_key"] = entry.IdempotencyKey
+	}
+	return
+}
+
+// WriteRunOutput writes the machine readable outcome of the run as json
+func WriteRunOutput(w io.Writer, entry *CatalogEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(NewRunOutput(entry))
+}
+
+// WriteResultFile atomically writes the result of the run as json, for a
+// supervisor such as a kubernetes job to pick up
+func WriteResultFile(resultPath string, entry *CatalogEntry, exitCode int, runErr error) (err error) {
+	result := RunResult{ExitCode: exitCode, RunOutput: NewRunOutput(entry)}
+
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	return writeResult(resultPath, result)
+}
+
+// ReadResultFile reads back the result of a run WriteResultFile wrote
+func ReadResultFile(resultPath string) (result RunResult, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(resultPath); err == nil {
+		err = json.Unmarshal(contents, &result)
+	}
+	return
+}
+
+// WriteFoundationResultFile is WriteResultFile for a run over several
+// foundations, writing the outcome of each
+func WriteFoundationResultFile(resultPath string, runs []FoundationRun) error {
+	return writeResult(resultPath, runs)
+}
+
+func writeResult(resultPath string, result interface{}) (err error) {
+	var contents []byte
+
+	if contents, err = json.MarshalIndent(result, "", "  "); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Dir(resultPath), 0755); err == nil {
+		tmp := resultPath + ".tmp"
+
+		if err = ioutil.WriteFile(tmp, append(contents, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, resultPath)
+		}
+	}
+	return
+}
+
+// VersionedDestination is the directory under destination a versioned backup
+// writes into: named after the idempotency key when there is one, so retries
+// of a run reuse it, or else after the id of the run, which starts with when
+// it started, so two runs started in the same second never share it
+func VersionedDestination(destination, idempotencyKey, runID string) string {
+	// the key may come from anywhere, so it must not name . or ..
+	if name := strings.Trim(lockNameSanitizer.ReplaceAllString(idempotencyKey, "_"), "."); name != "" {
+		return path.Join(destination, name)
+	}
+	return path.Join(destination, runID)
+}
+
+// destinationTile finds a template action naming the tile
+var destinationTile = regexp.MustCompile(`{{[^}]*\.Tile\b`)
+
+func ErrDestinationTemplate(destination, reason string) error {
+	return fmt.Errorf(ErrDestinationTemplateFormat, destination, reason)
+}
+
+// NewDestinationFields are the fields of the destination of the run of the
+// foundation started at the given time
+func NewDestinationFields(foundation, idempotencyKey, runID string, started time.Time) DestinationFields {
+	started = started.UTC()
+	return DestinationFields{
+		Foundation:     foundation,
+		Date:           started.Format("2006-01-02"),
+		Time:           started.Format("150405"),
+		Timestamp:      started.Format(RunDirFormat),
+		IdempotencyKey: idempotencyKey,
+		RunID:          runID,
+	}
+}
+
+// IsDestinationTemplate tells whether the destination names fields to resolve
+func IsDestinationTemplate(destination string) bool {
+	return strings.Contains(destination, "{{")
+}
+
+// ResolveDestination fills in the fields the destination template names. The
+// fields can not reach outside the directory they are placed in, and one left
+// empty resolves to _
+func ResolveDestination(destination string, fields DestinationFields) (resolved string, err error) {
+	var (
+		tmpl *template.Template
+		out  bytes.Buffer
+	)
+
+	if !IsDestinationTemplate(destination) {
+		return destination, nil
+	}
+
+	if destinationTile.MatchString(destination) {
+		return "", ErrDestinationTemplate(destination, ErrDestinationTileMsg)
+	}
+
+	if tmpl, err = template.New("destination").Option("missingkey=error").Parse(destination); err != nil {
+		return "", ErrDestinationTemplate(destination, err.Error())
+	}
+
+	for _, field := range []*string{&fields.Foundation, &fields.Date, &fields.Time, &fields.Timestamp, &fields.IdempotencyKey, &fields.RunID} {
+		if *field = strings.Trim(lockNameSanitizer.ReplaceAllString(*field, "_"), "."); *field == "" {
+			*field = "_"
+		}
+	}
+
+	if err = tmpl.Execute(&out, fields); err != nil {
+		return "", ErrDestinationTemplate(destination, err.Error())
+	}
+	return path.Clean(out.String()), nil
+}
@@ -0,0 +1,66 @@
+package cfops_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run output", func() {
+	var started = time.Date(2026, time.October, 15, 2, 0, 0, 0, time.UTC)
+
+	Describe("WriteRunOutput", func() {
+		It("should identify the run as a concourse version with its metadata", func() {
+			var out bytes.Buffer
+			entry := &CatalogEntry{ID: "run", Action: Backup, Status: SetComplete, IdempotencyKey: "build-42", Started: started, Finished: started}
+			Ω(WriteRunOutput(&out, entry)).Should(BeNil())
+
+			var output RunOutput
+			Ω(json.Unmarshal(out.Bytes(), &output)).Should(BeNil())
+			Ω(output.Version).Should(Equal(map[string]string{"run_id": "run", "idempotency_key": "build-42"}))
+			Ω(output.Metadata).Should(ContainElement(RunMetadata{Name: "status", Value: SetComplete}))
+			Ω(output.Run.ID).Should(Equal("run"))
+		})
+	})
+
+	Describe("VersionedDestination", func() {
+		It("should name the directory after the idempotency key or the id of the run", func() {
+			Ω(VersionedDestination("/backups", "team/pipeline #42", "20261015T020000Z-1a2b3c4d")).Should(Equal("/backups/team_pipeline__42"))
+			Ω(VersionedDestination("/backups", "", "20261015T020000Z-1a2b3c4d")).Should(Equal("/backups/20261015T020000Z-1a2b3c4d"))
+			Ω(VersionedDestination("/backups", "..", "20261015T020000Z-1a2b3c4d")).Should(Equal("/backups/20261015T020000Z-1a2b3c4d"))
+		})
+	})
+
+	Describe("ResolveDestination", func() {
+		fields := NewDestinationFields("opsman.prod", "build-42", "20261015T020000Z-1a2b3c4d", started)
+
+		It("should fill in the fields the template names", func() {
+			Ω(ResolveDestination("/backups/{{.Foundation}}/{{.Date}}/{{.Time}}", fields)).Should(Equal("/backups/opsman.prod/2026-10-15/020000"))
+			Ω(ResolveDestination("/backups/{{.IdempotencyKey}}-{{.Timestamp}}", fields)).Should(Equal("/backups/build-42-20261015T020000Z"))
+			Ω(ResolveDestination("/backups/{{.Foundation}}/{{.RunID}}", fields)).Should(Equal("/backups/opsman.prod/20261015T020000Z-1a2b3c4d"))
+		})
+
+		It("should leave a destination that is not a template as it is", func() {
+			Ω(ResolveDestination("/backups/prod", fields)).Should(Equal("/backups/prod"))
+		})
+
+		It("should not let a field reach outside its directory", func() {
+			Ω(ResolveDestination("/backups/{{.Foundation}}/{{.IdempotencyKey}}", NewDestinationFields("../etc", "", "", started))).Should(Equal("/backups/_etc/_"))
+		})
+
+		It("should refuse to template the destination by tile", func() {
+			_, err := ResolveDestination("/backups/{{.Date}}/{{ .Tile }}", fields)
+			Ω(err).Should(Equal(ErrDestinationTemplate("/backups/{{.Date}}/{{ .Tile }}", ErrDestinationTileMsg)))
+		})
+
+		It("should refuse a template naming a field it does not have", func() {
+			_, err := ResolveDestination("/backups/{{.Region}}", fields)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,92 @@
+package cfops
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	// RunLogName is the log of the backup shipped next to its artifacts, as
+	// json lines tagged with the run and task
+	RunLogName = "cfops-run.log"
+	// minSecretLength keeps a trivially short secret from redacting every
+	// occurrence of a few letters in the log
+	minSecretLength = 4
+)
+
+// secretAssignment finds secrets logged as name=value or name: value, e.g. in
+// the command line of a database dump
+var secretAssignment = regexp.MustCompile(`(?i)((?:password|passwd|secret|token)["']?\s*[=:]\s*["']?)[^\s"',&]+`)
+
+// runLog copies every log record written while it is started
+type runLog struct {
+	buffer   bytes.Buffer
+	previous []logging.Backend
+}
+
+// startRunLog copies every following log record into a run log, besides the
+// backends already in use, until it is stopped
+func startRunLog() (log *runLog) {
+	log = &runLog{previous: activeBackends}
+	backends := log.previous
+
+	if backends == nil {
+		backends = []logging.Backend{newTextBackend(os.Stderr)}
+	}
+	setBackends(append(append([]logging.Backend{}, backends...), &jsonBackend{out: &log.buffer})...)
+	return
+}
+
+func (s *runLog) stop() {
+	if s.previous == nil {
+		setBackends(newTextBackend(os.Stderr))
+		return
+	}
+	setBackends(s.previous...)
+}
+
+// RedactLog replaces the secrets, and any value logged as a password, secret
+// or token, in the log
+func RedactLog(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength {
+			continue
+		}
+		text = strings.Replace(text, secret, redacted, -1)
+
+		if escaped, err := json.Marshal(secret); err == nil {
+			text = strings.Replace(text, strings.Trim(string(escaped), `"`), redacted, -1)
+		}
+	}
+	return secretAssignment.ReplaceAllString(text, "${1}"+redacted)
+}
+
+// runSecrets are the credentials the run was given, which its log must not
+// carry
+func runSecrets(fs flagSet) (secrets []string) {
+	secrets = []string{fs.AdminPass(), fs.OpsManagerPass(), fs.SMTP().Pass, fs.Registry().Pass, fs.PagerDuty().RoutingKey, fs.DeploymentManifests().Key,
+		fs.Binlogs().Pass, fs.SmokeTests().Pass, fs.MetadataCache().Key}
+
+	for _, header := range fs.Tracing().Headers {
+		secrets = append(secrets, header[1])
+	}
+	return
+}
+
+// shipRunLog writes the redacted run log into the destination, next to its
+// artifacts
+func shipRunLog(fs flagSet, log *runLog) (err error) {
+	tmp := path.Join(fs.Dest(), RunLogName+".tmp")
+
+	if err = ioutil.WriteFile(tmp, []byte(RedactLog(log.buffer.String(), runSecrets(fs))), 0600); err == nil {
+		err = os.Rename(tmp, path.Join(fs.Dest(), RunLogName))
+	}
+	return
+}
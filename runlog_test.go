@@ -0,0 +1,125 @@
+package cfops_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+	"github.com/xchapter7x/lo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// leakyTile logs the credentials of the run, as a careless tile might
+type leakyTile struct {
+	secrets []string
+}
+
+func (s *leakyTile) Backup() error {
+	lo.G.Info("connecting with %s", strings.Join(s.secrets, " "))
+	return nil
+}
+
+func (s *leakyTile) Restore() error { return nil }
+
+var _ = Describe("Run log", func() {
+	Describe("RedactLog", func() {
+		It("should replace the secrets the run was given", func() {
+			Ω(RedactLog("connecting as admin with s3cr3t!", []string{"s3cr3t!"})).Should(Equal("connecting as admin with REDACTED"))
+		})
+
+		It("should replace a secret escaped in a json record", func() {
+			Ω(RedactLog(`{"message":"using p\"ss"}`, []string{`p"ss`})).Should(Equal(`{"message":"using REDACTED"}`))
+		})
+
+		It("should replace values logged as a password", func() {
+			Ω(RedactLog("PGPASSWORD=hunter2 pg_dump -U vcap", nil)).Should(Equal("PGPASSWORD=REDACTED pg_dump -U vcap"))
+			Ω(RedactLog(`"password": "hunter2"`, nil)).Should(Equal(`"password": "REDACTED"`))
+		})
+
+		It("should leave trivially short secrets alone", func() {
+			Ω(RedactLog("backup of er", []string{"er"})).Should(Equal("backup of er"))
+		})
+	})
+
+	Describe("running a backup", func() {
+		var (
+			dir string
+			fs  *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "runlog")
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir, shipLogs: true}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should write the log of the run next to its artifacts", func() {
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			contents, err := ioutil.ReadFile(path.Join(dir, RunLogName))
+			Ω(err).Should(BeNil())
+			Ω(string(contents)).Should(ContainSubstring(`"run_id":"` + entry.ID + `"`))
+			Ω(string(contents)).Should(ContainSubstring("Starting OPSMANAGER"))
+		})
+
+		It("should write the summary of the run", func() {
+			entry, _ := RunPipelineResult(context.Background(), fs, Backup)
+			var summary RunSummary
+			contents, _ := ioutil.ReadFile(path.Join(dir, SummaryName))
+			Ω(json.Unmarshal(contents, &summary)).Should(BeNil())
+			Ω(summary.ID).Should(Equal(entry.ID))
+			Ω(summary.Status).Should(Equal(SetComplete))
+		})
+
+		It("should redact every credential the run was given", func() {
+			secrets := []string{"binlog-s3cret", "smoke-s3cret", "metadata-k3y", "Bearer otlp-t0ken"}
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &leakyTile{secrets: secrets}, nil
+				},
+			}
+			fs.binlogs.Pass, fs.smokeTests.Pass, fs.metadata.Key = secrets[0], secrets[1], secrets[2]
+			fs.tracing.Headers = [][2]string{{"Authorization", secrets[3]}}
+			RunPipeline(fs, Backup)
+			contents, _ := ioutil.ReadFile(path.Join(dir, RunLogName))
+			Ω(string(contents)).Should(ContainSubstring("connecting with"))
+
+			for _, secret := range secrets {
+				Ω(string(contents)).ShouldNot(ContainSubstring(secret))
+			}
+		})
+
+		It("should describe the log in the manifest", func() {
+			RunPipeline(fs, Backup)
+			manifest, _ := LoadManifest(dir)
+			var names []string
+
+			for _, artifact := range manifest.Artifacts {
+				names = append(names, artifact.Name)
+			}
+			Ω(names).Should(ContainElement(RunLogName))
+			Ω(names).ShouldNot(ContainElement(SummaryName))
+		})
+
+		It("should remove the log of an earlier backup when not shipping it", func() {
+			RunPipeline(fs, Backup)
+			fs.shipLogs = false
+			RunPipeline(fs, Backup)
+			Ω(path.Join(dir, RunLogName)).ShouldNot(BeAnExistingFile())
+		})
+	})
+})
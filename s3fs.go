@@ -0,0 +1,678 @@
+package cfops
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pivotalservices/gtils/retry"
+	"github.com/pkg/sftp"
+)
+
+const (
+	ErrS3Format = "s3 %s responded with %s: %s"
+	s3Service   = "s3"
+	// DefaultS3PartSize is the size of the parts an upload is sent in, the
+	// smallest s3 accepts for all but the last part
+	DefaultS3PartSize = 5 << 20
+	s3FileMode        = 0644
+	s3DirMode         = 0755
+	// s3MaxPending bounds the writes held while an earlier one has yet to
+	// arrive, far beyond what an sftp client keeps in flight
+	s3MaxPending = 2 * DefaultS3PartSize
+)
+
+var s3Client = &http.Client{Timeout: 5 * time.Minute}
+
+type (
+	// S3Config describes the bucket an S3FS serves
+	S3Config struct {
+		Bucket string
+		// Prefix is the key of the directory served as the root, e.g. backups
+		Prefix string
+		// Region defaults to AWS_REGION
+		Region string
+		// Endpoint overrides https://s3.<region>.amazonaws.com, e.g. for minio
+		Endpoint string
+		PartSize int64
+	}
+
+	// S3FS serves the objects of a bucket over sftp. Keys are paths below the
+	// prefix, directories are the common prefixes of keys or empty objects
+	// named with a trailing slash, as the s3 console creates them. Files are
+	// read with range requests and written as multipart uploads; there are no
+	// symlinks, attributes or partial rewrites. cfops sftp-server serves one
+	// as the sftp subsystem of an ssh server
+	S3FS struct {
+		config      S3Config
+		credentials awsCredentials
+	}
+
+	s3Object struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	}
+
+	s3ListResult struct {
+		Contents       []s3Object `xml:"Contents"`
+		CommonPrefixes []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+
+	s3CompletedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	s3FileInfo struct {
+		name    string
+		size    int64
+		modTime time.Time
+		dir     bool
+	}
+
+	// s3File is an object opened for reading, a directory opened for listing
+	// or an upload replacing an object
+	s3File struct {
+		fs     *S3FS
+		name   string
+		key    string
+		info   *s3FileInfo
+		upload *s3Upload
+
+		mu      sync.Mutex
+		entries []os.FileInfo
+		listed  bool
+	}
+
+	// s3Upload collects the writes of an sftp client, which may arrive out of
+	// order, and sends them in parts once they are contiguous
+	s3Upload struct {
+		mu       sync.Mutex
+		uploadID string
+		parts    []s3CompletedPart
+		buffer   bytes.Buffer
+		next     int64 // offset of the end of the contiguous data received
+		pending  map[int64][]byte
+		held     int64 // bytes in pending
+		err      error
+	}
+)
+
+func ErrS3(request, status, body string) error {
+	return fmt.Errorf(ErrS3Format, request, status, body)
+}
+
+// NewS3FS returns the sftp filesystem of the bucket, authenticated with the
+// credentials of the environment or of the instance profile
+func NewS3FS(config S3Config) (fs *S3FS, err error) {
+	fs = &S3FS{config: config}
+
+	if fs.config.PartSize < DefaultS3PartSize {
+		fs.config.PartSize = DefaultS3PartSize
+	}
+
+	if fs.config.Region == "" {
+		fs.config.Region = os.Getenv("AWS_REGION")
+	}
+	fs.config.Prefix = strings.Trim(fs.config.Prefix, "/")
+	fs.credentials, err = loadAWSCredentials()
+	return
+}
+
+// key is the key of the object at the sftp path
+func (s *S3FS) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.config.Prefix, path.Clean("/"+name)), "/")
+}
+
+// do sends a signed request for the key, an empty key naming the bucket
+func (s *S3FS) do(method, key string, query url.Values, header http.Header, body []byte) (response *http.Response, err error) {
+	var request *http.Request
+	endpoint := s.config.Endpoint
+
+	if endpoint == "" {
+		endpoint = "https://" + s3Service + "." + s.config.Region + ".amazonaws.com"
+	}
+	resource := "/" + s.config.Bucket
+
+	if key != "" {
+		resource += "/" + key
+	}
+
+	if request, err = http.NewRequest(method, strings.TrimRight(endpoint, "/"), bytes.NewReader(body)); err != nil {
+		return
+	}
+	request.URL.Path, request.URL.RawPath = resource, awsEscapePath(resource)
+	request.URL.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	signAWSRequest(request, body, s.credentials, s.config.Region, s3Service, time.Now().UTC())
+
+	if response, err = retry.Default().Request(s3Client, request); err != nil {
+		return
+	}
+
+	if response.StatusCode/100 != 2 {
+		defer response.Body.Close()
+		contents, _ := ioutil.ReadAll(response.Body)
+
+		switch response.StatusCode {
+		case http.StatusNotFound:
+			err = syscall.ENOENT
+		case http.StatusForbidden:
+			err = syscall.EPERM
+		default:
+			err = ErrS3(method+" "+resource, response.Status, strings.TrimSpace(string(contents)))
+		}
+		return nil, err
+	}
+	return
+}
+
+// awsEscapePath escapes every byte of the path but the unreserved characters
+// and slashes, as signature version 4 expects
+func awsEscapePath(p string) string {
+	var escaped bytes.Buffer
+
+	for _, b := range []byte(p) {
+		if b == '/' || b == '-' || b == '_' || b == '.' || b == '~' ||
+			(b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// list returns the objects and common prefixes directly below the prefix, at
+// most max of them when max is set
+func (s *S3FS) list(prefix string, max int) (objects []s3Object, prefixes []string, err error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+
+	if max > 0 {
+		query.Set("max-keys", strconv.Itoa(max))
+	}
+
+	for {
+		var (
+			response *http.Response
+			result   s3ListResult
+		)
+
+		if response, err = s.do("GET", "", query, nil, nil); err != nil {
+			return
+		}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+
+		if err != nil {
+			return
+		}
+		objects = append(objects, result.Contents...)
+
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+
+		if !result.IsTruncated || max > 0 {
+			return
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// stat finds the object at the path, or the directory when there is none
+func (s *S3FS) stat(name string) (info *s3FileInfo, err error) {
+	var (
+		response *http.Response
+		objects  []s3Object
+		prefixes []string
+	)
+	key := s.key(name)
+	info = &s3FileInfo{name: path.Base(path.Clean("/" + name))}
+
+	if key == s.config.Prefix {
+		info.dir = true
+		return
+	}
+
+	if response, err = s.do("HEAD", key, nil, nil, nil); err == nil {
+		response.Body.Close()
+		info.size = response.ContentLength
+		info.modTime, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+		return
+	} else if err != syscall.ENOENT {
+		return nil, err
+	}
+
+	if objects, prefixes, err = s.list(key+"/", 1); err != nil {
+		return nil, err
+	} else if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, syscall.ENOENT
+	}
+	info.dir = true
+	return
+}
+
+func (s *S3FS) Stat(name string) (os.FileInfo, error) {
+	if info, err := s.stat(name); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	} else {
+		return info, nil
+	}
+}
+
+// Lstat is Stat, there are no symlinks in a bucket
+func (s *S3FS) Lstat(name string) (os.FileInfo, error) {
+	return s.Stat(name)
+}
+
+// EvalSymlinks is the clean path of an existing object or directory
+func (s *S3FS) EvalSymlinks(name string) (string, error) {
+	if _, err := s.Stat(name); err != nil {
+		return "", err
+	}
+	return path.Clean("/" + name), nil
+}
+
+// OpenFile opens an object for reading, a directory for listing, or starts
+// an upload replacing the object
+func (s *S3FS) OpenFile(name string, flag int, perm os.FileMode) (sftp.ServerFile, error) {
+	info, err := s.stat(name)
+	file := &s3File{fs: s, name: path.Clean("/" + name), key: s.key(name), info: info}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		return file, nil
+	}
+
+	switch {
+	case err != nil && err != syscall.ENOENT:
+	case err == nil && info.dir:
+		err = syscall.EISDIR
+	case err == syscall.ENOENT && flag&os.O_CREATE == 0:
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		err = syscall.EEXIST
+	case flag&os.O_APPEND != 0 || (err == nil && flag&os.O_TRUNC == 0):
+		// objects are only ever replaced whole
+		err = syscall.ENOTSUP
+	default:
+		file.info = &s3FileInfo{name: path.Base(file.name), modTime: time.Now()}
+		file.upload = &s3Upload{pending: map[int64][]byte{}}
+		err = nil
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
+
+func (s *S3FS) Mkdir(name string, perm os.FileMode) error {
+	if _, err := s.stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EEXIST}
+	}
+	response, err := s.do("PUT", s.key(name)+"/", nil, nil, nil)
+
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Remove deletes an object, or the marker of an empty directory
+func (s *S3FS) Remove(name string) (err error) {
+	var (
+		info     *s3FileInfo
+		response *http.Response
+		objects  []s3Object
+		prefixes []string
+	)
+	key := s.key(name)
+
+	if info, err = s.stat(name); err == nil && info.dir {
+		if objects, prefixes, err = s.list(key+"/", 2); err == nil && (len(prefixes) > 0 || len(objects) > 1 || (len(objects) == 1 && objects[0].Key != key+"/")) {
+			err = syscall.ENOTEMPTY
+		}
+		key += "/"
+	}
+
+	if err == nil {
+		if response, err = s.do("DELETE", key, nil, nil, nil); err == nil {
+			response.Body.Close()
+		}
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return
+}
+
+// Rename copies an object to its new key before deleting it. Directories
+// would take a copy of every object under them and cannot be renamed
+func (s *S3FS) Rename(oldname, newname string) (err error) {
+	var (
+		info     *s3FileInfo
+		response *http.Response
+	)
+
+	if info, err = s.stat(oldname); err == nil && info.dir {
+		err = syscall.ENOTSUP
+	}
+
+	if err == nil {
+		header := http.Header{"X-Amz-Copy-Source": {awsEscapePath("/" + s.config.Bucket + "/" + s.key(oldname))}}
+
+		if response, err = s.do("PUT", s.key(newname), nil, header, nil); err == nil {
+			response.Body.Close()
+			err = s.Remove(oldname)
+		}
+	}
+
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return
+}
+
+func (s *S3FS) Symlink(oldname, newname string) error {
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.ENOTSUP}
+}
+
+func (s *S3FS) Readlink(name string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+}
+
+func (s *S3FS) Truncate(name string, size int64) error {
+	return &os.PathError{Op: "truncate", Path: name, Err: syscall.ENOTSUP}
+}
+
+func (s *S3FS) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.ENOTSUP}
+}
+
+func (s *S3FS) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.ENOTSUP}
+}
+
+func (s *S3FS) Chown(name string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.ENOTSUP}
+}
+
+// Statvfs is not supported, a bucket has no capacity to report
+func (s *S3FS) Statvfs(name string) (*sftp.StatVFS, error) {
+	return nil, syscall.ENOTSUP
+}
+
+func (s *s3File) Name() string { return s.name }
+
+func (s *s3File) Stat() (os.FileInfo, error) {
+	if s.upload != nil {
+		s.upload.mu.Lock()
+		defer s.upload.mu.Unlock()
+		info := *s.info
+		info.size = s.upload.next
+		return &info, nil
+	}
+	return s.info, nil
+}
+
+// ReadAt reads the range of the object
+func (s *s3File) ReadAt(b []byte, off int64) (n int, err error) {
+	var response *http.Response
+
+	if s.upload != nil {
+		return 0, &os.PathError{Op: "read", Path: s.name, Err: syscall.EBADF}
+	} else if s.info.dir {
+		return 0, &os.PathError{Op: "read", Path: s.name, Err: syscall.EISDIR}
+	} else if off >= s.info.size {
+		return 0, io.EOF
+	} else if len(b) == 0 {
+		return 0, nil
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+int64(len(b))-1)}}
+
+	if response, err = s.fs.do("GET", s.key, nil, header, nil); err != nil {
+		return 0, &os.PathError{Op: "read", Path: s.name, Err: err}
+	}
+	defer response.Body.Close()
+
+	if n, err = io.ReadFull(response.Body, b); err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
+
+// WriteAt adds the data to the upload, sending every part that is complete
+func (s *s3File) WriteAt(b []byte, off int64) (int, error) {
+	if s.upload == nil {
+		return 0, &os.PathError{Op: "write", Path: s.name, Err: syscall.EBADF}
+	}
+	u := s.upload
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.err != nil {
+		return 0, u.err
+	} else if off < u.next {
+		// parts already sent cannot be rewritten
+		return 0, &os.PathError{Op: "write", Path: s.name, Err: syscall.EINVAL}
+	} else if off > u.next && u.held+int64(len(b)) > s3MaxPending {
+		// the write before it is lost or far behind, the upload is abandoned
+		u.err = &os.PathError{Op: "write", Path: s.name, Err: syscall.ENOBUFS}
+		return 0, u.err
+	}
+	u.held += int64(len(b) - len(u.pending[off]))
+	u.pending[off] = append([]byte{}, b...)
+
+	for data, ok := u.pending[u.next]; ok; data, ok = u.pending[u.next] {
+		delete(u.pending, u.next)
+		u.held -= int64(len(data))
+		u.buffer.Write(data)
+		u.next += int64(len(data))
+	}
+
+	for int64(u.buffer.Len()) >= s.fs.config.PartSize && u.err == nil {
+		u.err = s.uploadPart(u.buffer.Next(int(s.fs.config.PartSize)))
+	}
+
+	if u.err != nil {
+		return 0, u.err
+	}
+	return len(b), nil
+}
+
+func (s *s3File) uploadPart(part []byte) (err error) {
+	var response *http.Response
+	u := s.upload
+
+	if u.uploadID == "" {
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+
+		if response, err = s.fs.do("POST", s.key, url.Values{"uploads": {""}}, nil, nil); err != nil {
+			return
+		}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+
+		if err != nil {
+			return
+		}
+		u.uploadID = result.UploadID
+	}
+	number := len(u.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.uploadID}}
+
+	if response, err = s.fs.do("PUT", s.key, query, nil, part); err != nil {
+		return
+	}
+	response.Body.Close()
+	u.parts = append(u.parts, s3CompletedPart{PartNumber: number, ETag: response.Header.Get("ETag")})
+	return
+}
+
+// Close completes the upload, putting a small object in a single request
+func (s *s3File) Close() (err error) {
+	var (
+		response *http.Response
+		body     []byte
+	)
+
+	if s.upload == nil {
+		return nil
+	}
+	u := s.upload
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err = u.err; err == nil && len(u.pending) > 0 {
+		// a write never arrived, the object would have a hole
+		err = &os.PathError{Op: "close", Path: s.name, Err: syscall.EIO}
+	}
+
+	if err == nil && u.uploadID == "" {
+		if response, err = s.fs.do("PUT", s.key, nil, nil, u.buffer.Bytes()); err == nil {
+			response.Body.Close()
+		}
+		return
+	}
+
+	if err == nil && u.buffer.Len() > 0 {
+		err = s.uploadPart(u.buffer.Bytes())
+	}
+
+	if err == nil {
+		complete := struct {
+			XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+			Parts   []s3CompletedPart `xml:"Part"`
+		}{Parts: u.parts}
+
+		if body, err = xml.Marshal(complete); err == nil {
+			if response, err = s.fs.do("POST", s.key, url.Values{"uploadId": {u.uploadID}}, nil, body); err == nil {
+				response.Body.Close()
+			}
+		}
+	}
+
+	if err != nil && u.uploadID != "" {
+		if response, abortErr := s.fs.do("DELETE", s.key, url.Values{"uploadId": {u.uploadID}}, nil, nil); abortErr == nil {
+			response.Body.Close()
+		}
+	}
+	u.err = err
+	return
+}
+
+// Readdir lists the directory, in one listing of the bucket on the first call
+func (s *s3File) Readdir(n int) (entries []os.FileInfo, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.info.dir {
+		return nil, &os.PathError{Op: "readdirent", Path: s.name, Err: syscall.ENOTDIR}
+	}
+
+	if !s.listed {
+		if s.entries, err = s.fs.readdir(s.key); err != nil {
+			return nil, &os.PathError{Op: "readdirent", Path: s.name, Err: err}
+		}
+		s.listed = true
+	}
+
+	if len(s.entries) == 0 && n > 0 {
+		return nil, io.EOF
+	}
+
+	if n <= 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+	entries, s.entries = s.entries[:n], s.entries[n:]
+	return
+}
+
+func (s *S3FS) readdir(key string) (entries []os.FileInfo, err error) {
+	var (
+		objects  []s3Object
+		prefixes []string
+	)
+	prefix := key + "/"
+
+	if key == "" {
+		prefix = ""
+	}
+
+	if objects, prefixes, err = s.list(prefix, 0); err != nil {
+		return
+	}
+
+	for _, p := range prefixes {
+		entries = append(entries, &s3FileInfo{name: path.Base(p), dir: true})
+	}
+
+	for _, object := range objects {
+		if object.Key == prefix {
+			continue
+		}
+		modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+		entries = append(entries, &s3FileInfo{name: path.Base(object.Key), size: object.Size, modTime: modTime})
+	}
+	sort.Sort(byS3Name(entries))
+	return
+}
+
+type byS3Name []os.FileInfo
+
+func (s byS3Name) Len() int           { return len(s) }
+func (s byS3Name) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byS3Name) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
+
+func (s *s3File) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: s.name, Err: syscall.ENOTSUP}
+}
+
+func (s *s3File) Chmod(mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: s.name, Err: syscall.ENOTSUP}
+}
+
+func (s *s3File) Chown(uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: s.name, Err: syscall.ENOTSUP}
+}
+
+func (s *s3FileInfo) Name() string       { return s.name }
+func (s *s3FileInfo) Size() int64        { return s.size }
+func (s *s3FileInfo) ModTime() time.Time { return s.modTime }
+func (s *s3FileInfo) IsDir() bool        { return s.dir }
+func (s *s3FileInfo) Sys() interface{}   { return nil }
+
+func (s *s3FileInfo) Mode() os.FileMode {
+	if s.dir {
+		return os.ModeDir | s3DirMode
+	}
+	return s3FileMode
+}
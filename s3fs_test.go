@@ -0,0 +1,244 @@
+package cfops_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/pivotalservices/cfops"
+	"github.com/pkg/sftp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeS3 keeps the objects of one bucket, answering the requests S3FS makes
+type fakeS3 struct {
+	sync.Mutex
+	objects  map[string][]byte
+	parts    map[string][]byte
+	requests []string
+	auth     []string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/bucket"), "/")
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+	s.requests = append(s.requests, r.Method+" "+key+" "+r.URL.RawQuery+" "+r.Header.Get("Range"))
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+
+	switch {
+	case r.Method == "GET" && query.Get("list-type") == "2":
+		prefix, result, seen := query.Get("prefix"), "", map[string]bool{}
+		var keys []string
+
+		for k := range s.objects {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+
+			if i := strings.Index(k[len(prefix):], "/"); i >= 0 && len(prefix)+i+1 < len(k) {
+				if p := k[:len(prefix)+i+1]; !seen[p] {
+					seen[p] = true
+					result += fmt.Sprintf("<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", p)
+				}
+				continue
+			}
+			result += fmt.Sprintf("<Contents><Key>%s</Key><Size>%d</Size><LastModified>2016-01-02T03:04:05.000Z</LastModified></Contents>", k, len(s.objects[k]))
+		}
+		fmt.Fprintf(w, "<ListBucketResult>%s<IsTruncated>false</IsTruncated></ListBucketResult>", result)
+
+	case r.Method == "POST" && query["uploads"] != nil:
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>")
+
+	case r.Method == "POST":
+		var complete struct {
+			Parts []struct {
+				ETag string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(body, &complete)
+		var object []byte
+
+		for _, part := range complete.Parts {
+			object = append(object, s.parts[part.ETag]...)
+		}
+		s.objects[key] = object
+
+	case r.Method == "PUT" && query.Get("partNumber") != "":
+		etag := `"etag-` + query.Get("partNumber") + `"`
+		s.parts[etag] = body
+		w.Header().Set("ETag", etag)
+
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.objects[key] = s.objects[strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/bucket/")]
+
+	case r.Method == "PUT":
+		s.objects[key] = body
+
+	case r.Method == "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		object, ok := s.objects[key]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", "Sat, 02 Jan 2016 03:04:05 GMT")
+		var start, end int
+
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			if end >= len(object) {
+				end = len(object) - 1
+			}
+			object = object[start : end+1]
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(object)))
+
+		if r.Method == "GET" {
+			w.Write(object)
+		}
+	}
+}
+
+var _ = Describe("S3FS", func() {
+	var (
+		bucket *fakeS3
+		server *httptest.Server
+		client *sftp.Client
+		fs     *S3FS
+	)
+
+	BeforeEach(func() {
+		os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		bucket = &fakeS3{objects: map[string][]byte{
+			"backups/2016/installation.json": []byte(`{"installation":true}`),
+			"backups/2016/mysql.sql":         []byte("CREATE TABLE"),
+			"elsewhere/secret":               []byte("outside the prefix"),
+		}, parts: map[string][]byte{}}
+		server = httptest.NewServer(bucket)
+
+		var err error
+		fs, err = NewS3FS(S3Config{Bucket: "bucket", Prefix: "backups", Region: "us-east-1", Endpoint: server.URL})
+		Ω(err).ShouldNot(HaveOccurred())
+		clientIn, serverOut, _ := os.Pipe()
+		serverIn, clientOut, _ := os.Pipe()
+		sftpServer, err := sftp.NewServer(serverIn, serverOut, ioutil.Discard, 0, false, "/", sftp.ServeFS(fs), sftp.ConfineToRoot())
+		Ω(err).ShouldNot(HaveOccurred())
+		go sftpServer.Serve()
+		client, err = sftp.NewClientPipe(clientIn, clientOut)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	})
+
+	It("should list the objects under the prefix as directories and files", func() {
+		root, err := client.ReadDir("/")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(root).Should(HaveLen(1))
+		Ω(root[0].Name()).Should(Equal("2016"))
+		Ω(root[0].IsDir()).Should(BeTrue())
+
+		files, err := client.ReadDir("/2016")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(HaveLen(2))
+		Ω(files[0].Name()).Should(Equal("installation.json"))
+		Ω(files[0].Size()).Should(BeEquivalentTo(len(`{"installation":true}`)))
+	})
+
+	It("should read objects with range requests signed for s3", func() {
+		f, err := client.Open("/2016/mysql.sql")
+		Ω(err).ShouldNot(HaveOccurred())
+		contents, err := ioutil.ReadAll(f)
+		f.Close()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(contents)).Should(Equal("CREATE TABLE"))
+
+		bucket.Lock()
+		defer bucket.Unlock()
+		Ω(strings.Join(bucket.requests, "\n")).Should(ContainSubstring("GET backups/2016/mysql.sql  bytes=0-"))
+		Ω(bucket.auth[0]).Should(MatchRegexp(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/\d{8}/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, `))
+	})
+
+	It("should not reach objects outside of the prefix", func() {
+		_, err := client.Open("/../elsewhere/secret")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should put a small file in a single request", func() {
+		f, err := client.Create("/2016/small")
+		Ω(err).ShouldNot(HaveOccurred())
+		f.Write([]byte("small artifact"))
+		Ω(f.Close()).Should(Succeed())
+
+		bucket.Lock()
+		defer bucket.Unlock()
+		Ω(string(bucket.objects["backups/2016/small"])).Should(Equal("small artifact"))
+	})
+
+	It("should upload a large file in parts", func() {
+		contents := bytes.Repeat([]byte("0123456789abcdef"), (DefaultS3PartSize+1024)/16)
+		f, err := client.Create("/2016/large")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = f.Write(contents)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(f.Close()).Should(Succeed())
+
+		bucket.Lock()
+		defer bucket.Unlock()
+		Ω(bucket.objects["backups/2016/large"]).Should(Equal(contents))
+		Ω(strings.Join(bucket.requests, "\n")).Should(ContainSubstring("partNumber=2&uploadId=upload-1"))
+	})
+
+	It("should abandon an upload whose writes are held too long for an earlier one", func() {
+		file, err := fs.OpenFile("/2016/gap", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		Ω(err).ShouldNot(HaveOccurred())
+		chunk := make([]byte, DefaultS3PartSize)
+		_, err = file.WriteAt(chunk, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = file.WriteAt(chunk, DefaultS3PartSize+1)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = file.WriteAt(chunk, 2*DefaultS3PartSize+1)
+		Ω(err).Should(HaveOccurred())
+		_, err = file.WriteAt([]byte{0}, 0)
+		Ω(err).Should(HaveOccurred())
+		Ω(file.Close()).ShouldNot(Succeed())
+
+		bucket.Lock()
+		defer bucket.Unlock()
+		Ω(bucket.objects).ShouldNot(HaveKey("backups/2016/gap"))
+	})
+
+	It("should rename an object by copying it", func() {
+		Ω(client.Rename("/2016/mysql.sql", "/2016/mysql.old")).Should(Succeed())
+
+		bucket.Lock()
+		defer bucket.Unlock()
+		Ω(string(bucket.objects["backups/2016/mysql.old"])).Should(Equal("CREATE TABLE"))
+		Ω(bucket.objects).ShouldNot(HaveKey("backups/2016/mysql.sql"))
+	})
+})
@@ -0,0 +1,241 @@
+package cfops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrUnsupportedEngineFormat = "no sandbox available for database engine: %s"
+	ErrSandboxNotReadyFormat   = "sandbox %s did not become ready in %s"
+	SandboxMysqlImage          = "mysql:5.6"
+	SandboxPostgresImage       = "postgres:9.4"
+	sandboxPassword            = "cfops-verify"
+	mysqlSystemSchemas         = "('mysql', 'information_schema', 'performance_schema', 'sys')"
+)
+
+var (
+	// SandboxReadyTimeout bounds how long a disposable container may take to accept connections
+	SandboxReadyTimeout = 2 * time.Minute
+	sandboxPollFreq     = 2 * time.Second
+	execCommand         = exec.Command
+)
+
+// Sandbox is a disposable database server a dump can be restored into
+type Sandbox interface {
+	Restore(dump io.Reader) error
+	RowCounts() (map[string]int, error)
+	Destroy() error
+}
+
+// SandboxFactory creates a fresh sandbox for the given database engine
+type SandboxFactory func(engine string) (Sandbox, error)
+
+// ScratchMysql is an existing MySQL server whose contents may be discarded
+type ScratchMysql struct {
+	Host string
+	Port string
+	User string
+	Pass string
+}
+
+func ErrUnsupportedEngine(engine string) error {
+	return fmt.Errorf(ErrUnsupportedEngineFormat, engine)
+}
+
+// NewSandboxFactory restores mysql dumps into the scratch server when one is
+// given, and everything else into disposable docker containers
+func NewSandboxFactory(scratch ScratchMysql) SandboxFactory {
+	return func(engine string) (sandbox Sandbox, err error) {
+		switch {
+		case engine == MysqlEngine && scratch.Host != "":
+			scratchSandbox := &mysqlSandbox{client: scratch.client}
+			scratchSandbox.destroy = scratchSandbox.dropDatabases
+			sandbox = scratchSandbox
+
+		case engine == MysqlEngine || engine == PostgresEngine:
+			sandbox, err = newContainerSandbox(engine)
+
+		default:
+			err = ErrUnsupportedEngine(engine)
+		}
+		return
+	}
+}
+
+type sqlClient func(stdin io.Reader, args ...string) ([]byte, error)
+
+func (s ScratchMysql) client(stdin io.Reader, args ...string) ([]byte, error) {
+	conn := []string{"-h", s.Host, "-u", s.User, "--password=" + s.Pass}
+
+	if s.Port != "" {
+		conn = append(conn, "-P", s.Port)
+	}
+	return runCommand(stdin, "mysql", append(conn, args...)...)
+}
+
+func runCommand(stdin io.Reader, name string, args ...string) (out []byte, err error) {
+	var stderr bytes.Buffer
+	cmd := execCommand(name, args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+
+	if out, err = cmd.Output(); err != nil {
+		err = fmt.Errorf("%s: %s %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return
+}
+
+func newContainerSandbox(engine string) (sandbox Sandbox, err error) {
+	var (
+		out  []byte
+		args = []string{"run", "-d"}
+	)
+
+	switch engine {
+	case MysqlEngine:
+		args = append(args, "-e", "MYSQL_ROOT_PASSWORD="+sandboxPassword, SandboxMysqlImage)
+	case PostgresEngine:
+		args = append(args, "-e", "POSTGRES_PASSWORD="+sandboxPassword, SandboxPostgresImage)
+	}
+
+	if out, err = runCommand(nil, "docker", args...); err != nil {
+		return
+	}
+	id := strings.TrimSpace(string(out))
+	destroy := func() (err error) {
+		_, err = runCommand(nil, "docker", "rm", "-f", id)
+		return
+	}
+	dockerExec := func(client ...string) sqlClient {
+		return func(stdin io.Reader, args ...string) ([]byte, error) {
+			return runCommand(stdin, "docker", append(append([]string{"exec", "-i", id}, client...), args...)...)
+		}
+	}
+
+	if engine == MysqlEngine {
+		sandbox = &mysqlSandbox{client: dockerExec("mysql", "-uroot", "--password="+sandboxPassword), destroy: destroy}
+
+	} else {
+		sandbox = &postgresSandbox{client: dockerExec("psql", "-U", "postgres", "-v", "ON_ERROR_STOP=1"), destroy: destroy}
+	}
+
+	if err = waitForSandbox(id, dockerExec); err != nil {
+		destroy()
+	}
+	return
+}
+
+func waitForSandbox(id string, dockerExec func(...string) sqlClient) (err error) {
+	deadline := time.Now().Add(SandboxReadyTimeout)
+	ping := dockerExec("sh", "-c", "mysqladmin ping --silent -uroot --password="+sandboxPassword+" 2>/dev/null || pg_isready -U postgres")
+
+	for {
+		if _, err = ping(nil); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(sandboxPollFreq)
+	}
+
+	if err != nil {
+		err = fmt.Errorf(ErrSandboxNotReadyFormat, id, SandboxReadyTimeout)
+	}
+	return
+}
+
+type mysqlSandbox struct {
+	client  sqlClient
+	destroy func() error
+}
+
+func (s *mysqlSandbox) Restore(dump io.Reader) (err error) {
+	_, err = s.client(dump)
+	return
+}
+
+func (s *mysqlSandbox) RowCounts() (counts map[string]int, err error) {
+	var out []byte
+	query := "SELECT CONCAT(table_schema, '.', table_name) FROM information_schema.tables " +
+		"WHERE table_type = 'BASE TABLE' AND table_schema NOT IN " + mysqlSystemSchemas
+
+	if out, err = s.client(nil, "-N", "-B", "-e", query); err == nil {
+		counts, err = countRows(out, func(table string) ([]byte, error) {
+			return s.client(nil, "-N", "-B", "-e", "SELECT COUNT(*) FROM "+table)
+		})
+	}
+	return
+}
+
+func (s *mysqlSandbox) Destroy() error {
+	return s.destroy()
+}
+
+// dropDatabases empties a scratch server so the next verification starts clean
+func (s *mysqlSandbox) dropDatabases() (err error) {
+	var out []byte
+	query := "SELECT schema_name FROM information_schema.schemata WHERE schema_name NOT IN " + mysqlSystemSchemas
+
+	if out, err = s.client(nil, "-N", "-B", "-e", query); err == nil {
+
+		for _, schema := range strings.Fields(string(out)) {
+			if _, err = s.client(nil, "-e", "DROP DATABASE `"+schema+"`"); err != nil {
+				break
+			}
+		}
+	}
+	return
+}
+
+type postgresSandbox struct {
+	client  sqlClient
+	destroy func() error
+}
+
+func (s *postgresSandbox) Restore(dump io.Reader) (err error) {
+	_, err = s.client(dump)
+	return
+}
+
+func (s *postgresSandbox) RowCounts() (counts map[string]int, err error) {
+	var out []byte
+
+	if out, err = s.client(nil, "-A", "-t", "-c", "SELECT tablename FROM pg_tables WHERE schemaname = 'public'"); err == nil {
+		counts, err = countRows(out, func(table string) ([]byte, error) {
+			return s.client(nil, "-A", "-t", "-c", fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, table))
+		})
+	}
+	return
+}
+
+func (s *postgresSandbox) Destroy() error {
+	return s.destroy()
+}
+
+func countRows(tableList []byte, count func(table string) ([]byte, error)) (counts map[string]int, err error) {
+	counts = make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(tableList))
+
+	for scanner.Scan() {
+		var out []byte
+		table := strings.TrimSpace(scanner.Text())
+
+		if table == "" {
+			continue
+		}
+
+		if out, err = count(table); err != nil {
+			break
+		}
+
+		if counts[table], err = strconv.Atoi(strings.TrimSpace(string(out))); err != nil {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,314 @@
+package cfops
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xchapter7x/lo"
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	// RunDirFormat is when a run started, as destination templates name it
+	RunDirFormat             = "20060102T150405Z"
+	ErrInvalidScheduleFormat = "schedule %q: %s"
+	ErrUnknownScheduleFormat = "unknown schedule %s"
+	ErrScheduleBusyFormat    = "schedule %s already has a run waiting or in progress"
+)
+
+type (
+	// ScheduleConfig is the file the scheduler daemon reads its backups, and
+	// the callers allowed to use its api, from
+	ScheduleConfig struct {
+		Schedules []ScheduleEntry `yaml:"schedules"`
+		API       APIConfig       `yaml:"api"`
+	}
+
+	// ScheduleEntry is a backup of a set of tiles of a foundation on a cron
+	// schedule. Each run backs up into its own directory under Destination.
+	// Empty connection settings fall back to the flags of the daemon
+	ScheduleEntry struct {
+		Name           string `yaml:"name"`
+		Cron           string `yaml:"cron"`
+		Jitter         string `yaml:"jitter"`
+		Host           string `yaml:"opsmanagerhost"`
+		AdminUser      string `yaml:"adminuser"`
+		AdminPass      string `yaml:"adminpass"`
+		OpsManagerUser string `yaml:"opsmanageruser"`
+		OpsManagerPass string `yaml:"opsmanagerpass"`
+		Destination    string `yaml:"destination"`
+		Tilelist       string `yaml:"tilelist"`
+	}
+
+	// ScheduledJob is a parsed schedule entry
+	ScheduledJob struct {
+		Entry    ScheduleEntry
+		Schedule Schedule
+		// Jitter delays each run by a random duration up to it, so foundations
+		// sharing a schedule don't all start at once
+		Jitter time.Duration
+	}
+
+	// Scheduler runs scheduled jobs one at a time, and on demand
+	Scheduler struct {
+		jobs    []ScheduledJob
+		state   *SchedulerState
+		run     func(context.Context, ScheduledJob) error
+		due     chan ScheduledJob
+		mutex   sync.Mutex
+		pending map[string]bool
+		next    map[string]time.Time
+		running string
+	}
+
+	// ScheduleStatus is the state of a scheduled job
+	ScheduleStatus struct {
+		Name        string    `json:"name"`
+		Cron        string    `json:"cron"`
+		Destination string    `json:"destination"`
+		Next        time.Time `json:"next"`
+		Queued      bool      `json:"queued"`
+		Running     bool      `json:"running"`
+	}
+)
+
+func ErrInvalidSchedule(name, reason string) error {
+	return fmt.Errorf(ErrInvalidScheduleFormat, name, reason)
+}
+
+func ErrUnknownSchedule(name string) error {
+	return fmt.Errorf(ErrUnknownScheduleFormat, name)
+}
+
+func ErrScheduleBusy(name string) error {
+	return fmt.Errorf(ErrScheduleBusyFormat, name)
+}
+
+// LoadScheduleConfig reads the schedules of the daemon from a yaml file
+func LoadScheduleConfig(configPath string) (config ScheduleConfig, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(configPath); err == nil {
+		err = yaml.Unmarshal(contents, &config)
+	}
+	return
+}
+
+// Jobs parses the cron expression and jitter of every schedule, failing on the
+// first invalid or duplicate entry
+func (s ScheduleConfig) Jobs() (jobs []ScheduledJob, err error) {
+	names := make(map[string]bool)
+
+	for _, entry := range s.Schedules {
+		job := ScheduledJob{Entry: entry}
+
+		switch {
+		case entry.Name == "":
+			return nil, ErrInvalidSchedule(entry.Cron, "has no name")
+
+		case names[entry.Name]:
+			return nil, ErrInvalidSchedule(entry.Name, "is defined twice")
+		}
+		names[entry.Name] = true
+
+		if job.Schedule, err = ParseCron(entry.Cron); err != nil {
+			return nil, ErrInvalidSchedule(entry.Name, err.Error())
+		}
+
+		if entry.Jitter != "" {
+			if job.Jitter, err = time.ParseDuration(entry.Jitter); err != nil {
+				return nil, ErrInvalidSchedule(entry.Name, err.Error())
+			}
+		}
+
+		if _, err = job.RunDestination(entry.Host, NewRunID(), time.Now()); err != nil {
+			return nil, ErrInvalidSchedule(entry.Name, err.Error())
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// RunDestination is the directory the run of the job backing up the
+// foundation started at the given time backs up into: its destination
+// template resolved, or else a directory of its destination named after the
+// id of the run
+func (s ScheduledJob) RunDestination(foundation, runID string, started time.Time) (string, error) {
+	if IsDestinationTemplate(s.Entry.Destination) {
+		return ResolveDestination(s.Entry.Destination, NewDestinationFields(foundation, "", runID, started))
+	}
+	return VersionedDestination(s.Entry.Destination, "", runID), nil
+}
+
+// NewScheduler schedules the jobs, calling run for each run of a job and
+// keeping the queue and history of runs in the state
+func NewScheduler(jobs []ScheduledJob, state *SchedulerState, run func(context.Context, ScheduledJob) error) *Scheduler {
+	return &Scheduler{
+		jobs:    jobs,
+		state:   state,
+		run:     run,
+		due:     make(chan ScheduledJob, len(jobs)),
+		pending: make(map[string]bool),
+		next:    make(map[string]time.Time),
+	}
+}
+
+// Run first runs the jobs left queued in the state by an earlier daemon, then
+// runs every job at the times its schedule gives, each delayed by a random
+// jitter, until the context is cancelled. Runs happen one at a time, in the
+// order they came due, and a job that comes due while its previous run is
+// still waiting or running is skipped rather than stacked up
+func (s *Scheduler) Run(ctx context.Context) {
+	var timers sync.WaitGroup
+	queue, _ := s.state.Snapshot()
+
+	s.mutex.Lock()
+
+	for _, queued := range queue {
+		if job, ok := s.job(queued.Schedule); ok && !s.pending[job.Entry.Name] {
+			lo.G.Info("resuming the queued run of schedule %s", job.Entry.Name)
+			s.pending[job.Entry.Name] = true
+			s.due <- job
+
+		} else {
+			s.saveState(s.state.drop(queued.Schedule))
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, job := range s.jobs {
+		timers.Add(1)
+
+		go func(job ScheduledJob) {
+			defer timers.Done()
+
+			for {
+				next := job.Schedule.Next(time.Now())
+
+				if next.IsZero() {
+					lo.G.Error("schedule %s never runs again", job.Entry.Name)
+					return
+				}
+
+				if job.Jitter > 0 {
+					next = next.Add(time.Duration(rand.Int63n(int64(job.Jitter))))
+				}
+				lo.G.Debug("schedule %s runs next at %s", job.Entry.Name, next)
+				s.mutex.Lock()
+				s.next[job.Entry.Name] = next
+				s.mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return
+
+				case <-time.After(time.Until(next)):
+				}
+
+				if err := s.enqueue(job, TriggerSchedule); err != nil {
+					warn("skipping schedule %s: %s", job.Entry.Name, err)
+				}
+			}
+		}(job)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			timers.Wait()
+			return
+
+		case job := <-s.due:
+			lo.G.Info("running schedule %s", job.Entry.Name)
+			s.mutex.Lock()
+			s.running = job.Entry.Name
+			s.mutex.Unlock()
+			s.saveState(s.state.start(job.Entry.Name))
+			err := s.run(ctx, job)
+
+			if err != nil {
+				lo.G.Error("schedule %s failed: %s", job.Entry.Name, err)
+			}
+			s.saveState(s.state.finish(job.Entry.Name, err))
+			s.mutex.Lock()
+			s.running = ""
+			delete(s.pending, job.Entry.Name)
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// Trigger queues a run of the named job now on behalf of triggerBy, unless it
+// is already waiting or running
+func (s *Scheduler) Trigger(name, triggerBy string) (err error) {
+	if job, ok := s.job(name); ok {
+		return s.enqueue(job, triggerBy)
+	}
+	return ErrUnknownSchedule(name)
+}
+
+// Has tells whether a job of that name is scheduled
+func (s *Scheduler) Has(name string) (ok bool) {
+	_, ok = s.job(name)
+	return
+}
+
+// State is the queue and history of the runs of the scheduler
+func (s *Scheduler) State() *SchedulerState {
+	return s.state
+}
+
+func (s *Scheduler) job(name string) (job ScheduledJob, ok bool) {
+	for _, job = range s.jobs {
+		if job.Entry.Name == name {
+			return job, true
+		}
+	}
+	return
+}
+
+func (s *Scheduler) enqueue(job ScheduledJob, triggerBy string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending[job.Entry.Name] {
+		return ErrScheduleBusy(job.Entry.Name)
+	}
+	s.pending[job.Entry.Name] = true
+	s.saveState(s.state.queue(job.Entry.Name, triggerBy))
+	s.due <- job
+	return
+}
+
+// saveState warns about a state that could not be saved, the runs themselves
+// go ahead regardless
+func (s *Scheduler) saveState(err error) {
+	if err != nil {
+		warn("unable to save the scheduler state: %s", err)
+	}
+}
+
+// Status describes every job: when it runs next and whether a run of it is
+// waiting or running
+func (s *Scheduler) Status() (statuses []ScheduleStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, job := range s.jobs {
+		name := job.Entry.Name
+		status := ScheduleStatus{
+			Name:        name,
+			Cron:        job.Entry.Cron,
+			Destination: job.Entry.Destination,
+			Next:        s.next[name],
+			Queued:      s.pending[name] && s.running != name,
+			Running:     s.running == name,
+		}
+		statuses = append(statuses, status)
+	}
+	return
+}
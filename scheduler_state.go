@@ -0,0 +1,188 @@
+package cfops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	ScheduledRunQueued      = "queued"
+	ScheduledRunRunning     = "running"
+	ScheduledRunSucceeded   = "succeeded"
+	ScheduledRunFailed      = "failed"
+	ScheduledRunInterrupted = "interrupted"
+
+	// TriggerSchedule is who triggered a run that came due on its schedule
+	TriggerSchedule = "schedule"
+	// schedulerHistoryLimit is how many finished runs the state keeps
+	schedulerHistoryLimit = 500
+)
+
+type (
+	// SchedulerState is the queue and the history of the runs of the scheduler,
+	// kept in a json file when it has a path so neither is lost when the
+	// daemon restarts
+	SchedulerState struct {
+		mutex   sync.Mutex
+		path    string
+		Queue   []*ScheduledRun `json:"queue"`
+		History []*ScheduledRun `json:"history"`
+	}
+
+	// ScheduledRun is a run of a schedule, queued, in progress or finished
+	ScheduledRun struct {
+		Schedule  string    `json:"schedule"`
+		TriggerBy string    `json:"triggered_by"`
+		Status    string    `json:"status"`
+		Queued    time.Time `json:"queued"`
+		Started   time.Time `json:"started"`
+		Finished  time.Time `json:"finished"`
+		Error     string    `json:"error,omitempty"`
+	}
+)
+
+// OpenSchedulerState reads the state file, starting empty when there is none.
+// A run the daemon was in the middle of when it stopped is recorded as
+// interrupted. An empty path keeps the state in memory only
+func OpenSchedulerState(statePath string) (state *SchedulerState, err error) {
+	var contents []byte
+	state = &SchedulerState{path: statePath}
+
+	if statePath == "" {
+		return
+	}
+
+	if contents, err = ioutil.ReadFile(statePath); err == nil {
+		err = json.Unmarshal(contents, state)
+
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	queue := state.Queue
+	state.Queue = nil
+
+	for _, run := range queue {
+		if run.Status == ScheduledRunRunning {
+			run.Status = ScheduledRunInterrupted
+			run.Finished = time.Now()
+			state.History = append(state.History, run)
+
+		} else {
+			state.Queue = append(state.Queue, run)
+		}
+	}
+	return
+}
+
+// Snapshot copies the queue and the history, newest run last
+func (s *SchedulerState) Snapshot() (queue, history []ScheduledRun) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, run := range s.Queue {
+		queue = append(queue, *run)
+	}
+
+	for _, run := range s.History {
+		history = append(history, *run)
+	}
+	return
+}
+
+func (s *SchedulerState) queue(schedule, triggerBy string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Queue = append(s.Queue, &ScheduledRun{
+		Schedule:  schedule,
+		TriggerBy: triggerBy,
+		Status:    ScheduledRunQueued,
+		Queued:    time.Now(),
+	})
+	return s.save()
+}
+
+// start marks the queued run of the schedule as in progress
+func (s *SchedulerState) start(schedule string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if run := s.queued(schedule); run != nil {
+		run.Status = ScheduledRunRunning
+		run.Started = time.Now()
+	}
+	return s.save()
+}
+
+// finish moves the run of the schedule from the queue to the history
+func (s *SchedulerState) finish(schedule string, runErr error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, run := range s.Queue {
+		if run.Schedule == schedule {
+			run.Status = ScheduledRunSucceeded
+			run.Finished = time.Now()
+
+			if runErr != nil {
+				run.Status = ScheduledRunFailed
+				run.Error = runErr.Error()
+			}
+			s.Queue = append(s.Queue[:i], s.Queue[i+1:]...)
+			s.History = append(s.History, run)
+			break
+		}
+	}
+
+	if len(s.History) > schedulerHistoryLimit {
+		s.History = s.History[len(s.History)-schedulerHistoryLimit:]
+	}
+	return s.save()
+}
+
+// drop forgets the queued run of a schedule that no longer exists
+func (s *SchedulerState) drop(schedule string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, run := range s.Queue {
+		if run.Schedule == schedule {
+			s.Queue = append(s.Queue[:i], s.Queue[i+1:]...)
+			break
+		}
+	}
+	return s.save()
+}
+
+func (s *SchedulerState) queued(schedule string) *ScheduledRun {
+	for _, run := range s.Queue {
+		if run.Schedule == schedule {
+			return run
+		}
+	}
+	return nil
+}
+
+func (s *SchedulerState) save() (err error) {
+	var contents []byte
+
+	if s.path == "" {
+		return
+	}
+
+	if contents, err = json.MarshalIndent(s, "", "  "); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(path.Dir(s.path), 0700); err == nil {
+		tmp := s.path + ".tmp"
+
+		if err = ioutil.WriteFile(tmp, contents, 0600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	return
+}
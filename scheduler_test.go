@@ -0,0 +1,162 @@
+package cfops_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	Describe("ScheduleConfig", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "schedule")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should load every schedule of the config", func() {
+			configPath := path.Join(dir, "schedule.yml")
+			ioutil.WriteFile(configPath, []byte(`
+schedules:
+- name: prod
+  cron: "0 2 * * *"
+  jitter: 10m
+  opsmanagerhost: opsman.prod
+  destination: /backups/prod
+  tilelist: opsmanager, er
+`), 0600)
+			config, err := LoadScheduleConfig(configPath)
+			Ω(err).Should(BeNil())
+			jobs, err := config.Jobs()
+			Ω(err).Should(BeNil())
+			Ω(jobs).Should(HaveLen(1))
+			Ω(jobs[0].Jitter).Should(Equal(10 * time.Minute))
+			Ω(jobs[0].Entry.Host).Should(Equal("opsman.prod"))
+			Ω(jobs[0].RunDestination("opsman.prod", "20261015T020304Z-1a2b3c4d", time.Date(2026, time.October, 15, 2, 3, 4, 0, time.UTC))).Should(Equal("/backups/prod/20261015T020304Z-1a2b3c4d"))
+		})
+
+		It("should back up into the destination template of a job resolved for the run", func() {
+			config := ScheduleConfig{Schedules: []ScheduleEntry{{Name: "prod", Cron: "@daily", Destination: "/backups/{{.Foundation}}/{{.Date}}"}}}
+			jobs, err := config.Jobs()
+			Ω(err).Should(BeNil())
+			Ω(jobs[0].RunDestination("opsman.prod", "20261015T020304Z-1a2b3c4d", time.Date(2026, time.October, 15, 2, 3, 4, 0, time.UTC))).Should(Equal("/backups/opsman.prod/2026-10-15"))
+		})
+
+		It("should reject a destination template it can not resolve", func() {
+			config := ScheduleConfig{Schedules: []ScheduleEntry{{Name: "prod", Cron: "@daily", Destination: "/backups/{{.Tile}}"}}}
+			_, err := config.Jobs()
+			Ω(err).Should(Equal(ErrInvalidSchedule("prod", ErrDestinationTemplate("/backups/{{.Tile}}", ErrDestinationTileMsg).Error())))
+		})
+
+		It("should reject schedules defined twice", func() {
+			config := ScheduleConfig{Schedules: []ScheduleEntry{{Name: "prod", Cron: "@daily"}, {Name: "prod", Cron: "@hourly"}}}
+			_, err := config.Jobs()
+			Ω(err).Should(Equal(ErrInvalidSchedule("prod", "is defined twice")))
+		})
+	})
+
+	Describe("Scheduler", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			done   chan struct{}
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			done = make(chan struct{})
+		})
+
+		run := func(scheduler *Scheduler) {
+			go func() {
+				scheduler.Run(ctx)
+				close(done)
+			}()
+		}
+
+		It("should skip a job that comes due while its previous run is in progress", func() {
+			var runs int32
+			job := ScheduledJob{Entry: ScheduleEntry{Name: "often"}, Schedule: everySchedule(5 * time.Millisecond)}
+			state, _ := OpenSchedulerState("")
+			run(NewScheduler([]ScheduledJob{job}, state, func(context.Context, ScheduledJob) error {
+				atomic.AddInt32(&runs, 1)
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			}))
+			time.Sleep(75 * time.Millisecond)
+			cancel()
+			Eventually(done).Should(BeClosed())
+			Ω(atomic.LoadInt32(&runs)).Should(BeNumerically("<=", 2))
+		})
+
+		Context("when an earlier daemon left runs behind", func() {
+			var (
+				dir       string
+				statePath string
+			)
+
+			BeforeEach(func() {
+				dir, _ = ioutil.TempDir("", "scheduler")
+				statePath = path.Join(dir, "scheduler.json")
+				ioutil.WriteFile(statePath, []byte(`{"queue": [
+					{"schedule": "prod", "triggered_by": "schedule", "status": "running"},
+					{"schedule": "staging", "triggered_by": "ci", "status": "queued"},
+					{"schedule": "removed", "triggered_by": "ci", "status": "queued"}
+				]}`), 0600)
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("should record the interrupted run and resume the queued one", func() {
+				jobs := []ScheduledJob{
+					{Entry: ScheduleEntry{Name: "prod"}, Schedule: everySchedule(time.Hour)},
+					{Entry: ScheduleEntry{Name: "staging"}, Schedule: everySchedule(time.Hour)},
+				}
+				state, err := OpenSchedulerState(statePath)
+				Ω(err).Should(BeNil())
+				ran := make(chan string, 2)
+				run(NewScheduler(jobs, state, func(_ context.Context, job ScheduledJob) error {
+					ran <- job.Entry.Name
+					return nil
+				}))
+				Eventually(ran).Should(Receive(Equal("staging")))
+				Eventually(func() int {
+					reopened, _ := OpenSchedulerState(statePath)
+					_, history := reopened.Snapshot()
+					return len(history)
+				}).Should(Equal(2))
+				cancel()
+				Eventually(done).Should(BeClosed())
+
+				reopened, _ := OpenSchedulerState(statePath)
+				queue, history := reopened.Snapshot()
+				Ω(queue).Should(BeEmpty())
+				Ω(history[0].Status).Should(Equal(ScheduledRunInterrupted))
+				Ω(history[1].Schedule).Should(Equal("staging"))
+				Ω(history[1].Status).Should(Equal(ScheduledRunSucceeded))
+				Ω(history[1].TriggerBy).Should(Equal("ci"))
+			})
+		})
+	})
+})
+
+// everySchedule fires at a fixed interval
+type everySchedule time.Duration
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
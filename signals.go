@@ -0,0 +1,74 @@
+package cfops
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pivotalservices/gtils/command"
+	"github.com/xchapter7x/lo"
+)
+
+const ErrAbortedMsg = "run aborted by signal"
+
+var (
+	ErrAborted = errors.New(ErrAbortedMsg)
+	// ForceExit is called when a second signal arrives while a run is winding down
+	ForceExit = os.Exit
+)
+
+// WatchSignals returns a context that is cancelled on the first SIGINT or
+// SIGTERM. A second signal gives up on a graceful shutdown and exits with
+// forceExitCode. The returned function stops watching
+func WatchSignals(forceExitCode int) (ctx context.Context, stop func()) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			lo.G.Error("Received %s, stopping after cleaning up (signal again to exit immediately)", sig)
+			cancel()
+
+		case <-done:
+			return
+		}
+
+		select {
+		case <-signals:
+			ForceExit(forceExitCode)
+
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// abortOnCancel closes every in-flight remote connection, and removes remote
+// temporary files, as soon as the context is cancelled
+func abortOnCancel(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			lo.G.Info("Aborting in-flight remote operations")
+			command.Abort()
+
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,102 @@
+package cfops_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pivotalservices/gtils/command"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Aborting a run", func() {
+	var (
+		dir string
+		fs  *mockFlagSet
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "abort")
+		fs = &mockFlagSet{
+			tileListFlag: "opsmanager, er",
+			dest:         dir,
+			catalog:      path.Join(dir, "catalog.json"),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("when the context is cancelled while a tile is running", func() {
+		var (
+			ctx     context.Context
+			cancel  context.CancelFunc
+			er      *mockTile
+			aborted bool
+		)
+
+		BeforeEach(func() {
+			aborted = false
+			ctx, cancel = context.WithCancel(context.Background())
+			er = &mockTile{}
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &cancellingTile{cancel: cancel, aborted: &aborted}, nil
+				},
+				ER: func() (Tile, error) {
+					return er, nil
+				},
+			}
+		})
+
+		It("should abort in-flight remote operations", func() {
+			RunPipelineContext(ctx, fs, Backup)
+			Ω(aborted).Should(BeTrue())
+		})
+
+		It("should not start the remaining tiles", func() {
+			Ω(RunPipelineContext(ctx, fs, Backup)).Should(Equal(ErrAborted))
+			Ω(er.RunCount).Should(Equal(0))
+		})
+
+		It("should finalize the catalog entry as aborted", func() {
+			RunPipelineContext(ctx, fs, Backup)
+			catalog, _ := OpenCatalog(fs.catalog)
+			Ω(catalog.Entries[0].Status).Should(Equal(SetAborted))
+			Ω(catalog.Entries[0].Components[1].Status).Should(Equal(ComponentSkipped))
+		})
+
+		It("should release the run locks", func() {
+			RunPipelineContext(ctx, fs, Backup)
+			Ω(path.Join(dir, LockFileName)).ShouldNot(BeAnExistingFile())
+		})
+	})
+})
+
+// cancellingTile cancels the run while it is in flight and waits for the
+// abort to reach the remote operation it registered
+type cancellingTile struct {
+	cancel  context.CancelFunc
+	aborted *bool
+}
+
+func (s *cancellingTile) Backup() (err error) {
+	closed := make(chan struct{})
+	command.OnAbort(func() {
+		*s.aborted = true
+		close(closed)
+	})
+	s.cancel()
+	<-closed
+	return
+}
+
+func (s *cancellingTile) Restore() error {
+	return s.Backup()
+}
@@ -0,0 +1,274 @@
+package cfops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrSmokeTestsFormat = "the restored foundation failed its smoke tests: %s"
+	ErrSmokeAppMsg      = "the push smoke test needs an app to push"
+	ErrDropletAppMsg    = "the droplet smoke test needs a restored app, or one the push smoke test pushed"
+	ErrEmptyDropletMsg  = "the droplet downloaded is empty"
+	// the smoke tests a restore can end with
+	SmokeLogin   = "login"
+	SmokePush    = "push"
+	SmokeDroplet = "droplet"
+	// smokeAppPrefix names the app the push smoke test pushes and deletes
+	smokeAppPrefix = "cfops-smoke-"
+	dropletPath    = "/v2/apps/%s/droplet/download"
+)
+
+var (
+	ErrSmokeApp     = errors.New(ErrSmokeAppMsg)
+	ErrDropletApp   = errors.New(ErrDropletAppMsg)
+	ErrEmptyDroplet = errors.New(ErrEmptyDropletMsg)
+)
+
+type (
+	// SmokeTestConfig describes the smoke tests a restore ends with, run with
+	// the cf cli against the cloud controller of the restored foundation
+	SmokeTestConfig struct {
+		Enabled           bool
+		API               string
+		User              string
+		Pass              string
+		SkipSSLValidation bool
+		// Org and Space are targeted, and the push smoke test pushes into them
+		Org   string
+		Space string
+		// App is the directory of the app the push smoke test pushes and
+		// deletes again
+		App string
+		// DropletApp is a restored app whose droplet the droplet smoke test
+		// downloads, the pushed app when empty
+		DropletApp string
+		// Tests are the smoke tests to run after logging in, of push and
+		// droplet. When empty, push runs when App is given and droplet when
+		// either app is
+		Tests []string
+		// Binary defaults to cf on the path
+		Binary string
+	}
+
+	// SmokeTestReport is the outcome of each smoke test of a restore
+	SmokeTestReport struct {
+		Tests []SmokeTestResult `json:"tests"`
+	}
+
+	// SmokeTestResult is the outcome of a single smoke test
+	SmokeTestResult struct {
+		Name    string  `json:"name"`
+		Passed  bool    `json:"passed"`
+		Seconds float64 `json:"seconds"`
+		Error   string  `json:"error,omitempty"`
+	}
+
+	// smokeTester runs the cf cli with a cf home of its own, so that the
+	// configuration of the operator is left alone
+	smokeTester struct {
+		config SmokeTestConfig
+		home   string
+		pushed string
+	}
+)
+
+func ErrSmokeTests(failed string) error {
+	return fmt.Errorf(ErrSmokeTestsFormat, failed)
+}
+
+// Passed tells whether every smoke test passed
+func (s *SmokeTestReport) Passed() bool {
+	return len(s.failed()) == 0
+}
+
+func (s *SmokeTestReport) failed() (failed []string) {
+	for _, test := range s.Tests {
+		if !test.Passed {
+			failed = append(failed, test.Name+": "+test.Error)
+		}
+	}
+	return
+}
+
+func (s *SmokeTestReport) run(name string, test func() error) (err error) {
+	started := time.Now()
+	lo.G.Info("running the %s smoke test", name)
+	result := SmokeTestResult{Name: name, Passed: true}
+
+	if err = test(); err != nil {
+		result.Passed, result.Error = false, err.Error()
+	}
+	result.Seconds = time.Since(started).Seconds()
+	s.Tests = append(s.Tests, result)
+	return
+}
+
+// tests are the smoke tests to run after logging in
+func (s SmokeTestConfig) tests() []string {
+	if len(s.Tests) > 0 {
+		return s.Tests
+	}
+	var tests []string
+
+	if s.App != "" {
+		tests = append(tests, SmokePush)
+	}
+
+	if s.App != "" || s.DropletApp != "" {
+		tests = append(tests, SmokeDroplet)
+	}
+	return tests
+}
+
+// RunSmokeTests logs in to the restored foundation and runs the smoke tests
+// of the configuration, failing when any of them fails. The tests after a
+// failed login are not run
+func RunSmokeTests(config SmokeTestConfig) (report *SmokeTestReport, err error) {
+	var home string
+	report = &SmokeTestReport{}
+
+	if home, err = ioutil.TempDir("", "cfops-smoke"); err != nil {
+		return
+	}
+	defer os.RemoveAll(home)
+	tester := &smokeTester{config: config, home: home}
+	defer tester.cleanup()
+
+	if report.run(SmokeLogin, tester.login) == nil {
+		for _, name := range config.tests() {
+			switch name {
+			case SmokePush:
+				report.run(name, tester.push)
+
+			case SmokeDroplet:
+				report.run(name, tester.droplet)
+
+			default:
+				report.run(name, func() error { return fmt.Errorf("there is no %s smoke test", name) })
+			}
+		}
+	}
+
+	if failed := report.failed(); len(failed) > 0 {
+		err = ErrSmokeTests(strings.Join(failed, "; "))
+	}
+	return
+}
+
+func (s *smokeTester) login() (err error) {
+	args := []string{"api", s.config.API}
+
+	if s.config.SkipSSLValidation {
+		args = append(args, "--skip-ssl-validation")
+	}
+
+	if _, err = s.cf(args...); err != nil {
+		return
+	}
+
+	if _, err = s.cf("auth", s.config.User, s.config.Pass); err != nil {
+		return
+	}
+
+	if s.config.Org != "" {
+		args = []string{"target", "-o", s.config.Org}
+
+		if s.config.Space != "" {
+			args = append(args, "-s", s.config.Space)
+		}
+		_, err = s.cf(args...)
+	}
+	return
+}
+
+func (s *smokeTester) push() (err error) {
+	if s.config.App == "" {
+		return ErrSmokeApp
+	}
+	name := fmt.Sprintf("%s%d", smokeAppPrefix, time.Now().Unix())
+
+	// the app is deleted even when it did not start
+	s.pushed = name
+	_, err = s.cf("push", name, "-p", s.config.App, "--random-route")
+	return
+}
+
+func (s *smokeTester) droplet() (err error) {
+	var (
+		guid string
+		file *os.File
+	)
+	app := s.config.DropletApp
+	head := make([]byte, 512)
+
+	if app == "" {
+		app = s.pushed
+	}
+
+	if app == "" {
+		return ErrDropletApp
+	}
+
+	if guid, err = s.cf("app", app, "--guid"); err != nil {
+		return
+	}
+	droplet := path.Join(s.home, "droplet.tgz")
+
+	if _, err = s.cf("curl", fmt.Sprintf(dropletPath, strings.TrimSpace(guid)), "--output", droplet); err != nil {
+		return
+	}
+
+	if file, err = os.Open(droplet); err != nil {
+		return
+	}
+	defer file.Close()
+	n, _ := file.Read(head)
+	head = bytes.TrimSpace(head[:n])
+
+	switch {
+	case len(head) == 0:
+		err = ErrEmptyDroplet
+
+	// the cloud controller answers errors with a json document
+	case bytes.HasPrefix(head, []byte("{")) && bytes.Contains(head, []byte(`"error_code"`)):
+		err = errors.New(string(head))
+	}
+	return
+}
+
+func (s *smokeTester) cleanup() {
+	if s.pushed == "" {
+		return
+	}
+
+	if _, err := s.cf("delete", s.pushed, "-f", "-r"); err != nil {
+		warn("unable to delete the smoke test app %s: %s", s.pushed, err)
+	}
+}
+
+func (s *smokeTester) cf(args ...string) (output string, err error) {
+	var stdout, stderr bytes.Buffer
+	binary := s.config.Binary
+
+	if binary == "" {
+		binary = "cf"
+	}
+	cmd := execCommand(binary, args...)
+	cmd.Env = append(os.Environ(), "CF_HOME="+s.home)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err = cmd.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String() + " " + stderr.String())
+		return "", fmt.Errorf("cf %s: %v %s", args[0], err, message)
+	}
+	return stdout.String(), nil
+}
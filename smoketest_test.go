@@ -0,0 +1,123 @@
+package cfops_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCf records its arguments and answers like the cf cli would, failing
+// the commands named by a .fail file and downloading the droplet file
+const fakeCf = `#!/bin/sh
+dir="$(dirname "$0")"
+echo "$*" >> "$dir/calls"
+[ -n "$CF_HOME" ] || exit 3
+[ -f "$dir/$1.fail" ] && { echo "FAILED $1"; exit 1; }
+case "$1" in
+app) echo "5e21-guid" ;;
+curl) for arg in "$@"; do out="$arg"; done; cat "$dir/droplet" > "$out" ;;
+esac
+exit 0
+`
+
+var _ = Describe("RunSmokeTests", func() {
+	var (
+		bin    string
+		config SmokeTestConfig
+	)
+
+	calls := func() (commands []string) {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+
+		for _, call := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+			commands = append(commands, strings.Split(call, " ")[0])
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		bin, _ = ioutil.TempDir("", "cf-bin")
+		ioutil.WriteFile(path.Join(bin, "cf"), []byte(fakeCf), 0755)
+		ioutil.WriteFile(path.Join(bin, "droplet"), []byte("\x1f\x8b\x08 droplet"), 0644)
+		config = SmokeTestConfig{Enabled: true, API: "https://api.sys.example.com", User: "admin", Pass: "secret", Binary: path.Join(bin, "cf")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bin)
+	})
+
+	It("should only log in when no app is given", func() {
+		report, err := RunSmokeTests(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(report.Passed()).Should(BeTrue())
+		Ω(report.Tests).Should(HaveLen(1))
+		Ω(calls()).Should(Equal([]string{"api", "auth"}))
+	})
+
+	It("should push the app, download its droplet and delete it again", func() {
+		config.Org, config.Space, config.App = "system", "smoke", bin
+		report, err := RunSmokeTests(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(report.Tests).Should(HaveLen(3))
+		Ω(report.Tests[2].Name).Should(Equal(SmokeDroplet))
+		Ω(calls()).Should(Equal([]string{"api", "auth", "target", "push", "app", "curl", "delete"}))
+
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		Ω(string(contents)).Should(ContainSubstring("curl /v2/apps/5e21-guid/droplet/download --output "))
+	})
+
+	It("should fail the droplet test when the cloud controller cannot serve the droplet", func() {
+		config.DropletApp = "restored-app"
+		ioutil.WriteFile(path.Join(bin, "droplet"), []byte(`{"code":10000,"description":"Unknown request","error_code":"CF-NotFound"}`), 0644)
+		report, err := RunSmokeTests(config)
+		Ω(err).Should(HaveOccurred())
+		Ω(report.Passed()).Should(BeFalse())
+		Ω(report.Tests[1].Error).Should(ContainSubstring("CF-NotFound"))
+		Ω(calls()).ShouldNot(ContainElement("delete"))
+	})
+
+	It("should not run the tests after a failed login", func() {
+		config.App = bin
+		ioutil.WriteFile(path.Join(bin, "auth.fail"), nil, 0644)
+		report, err := RunSmokeTests(config)
+		Ω(err).Should(HaveOccurred())
+		Ω(report.Tests).Should(HaveLen(1))
+		Ω(report.Tests[0].Passed).Should(BeFalse())
+		Ω(calls()).Should(Equal([]string{"api", "auth"}))
+	})
+
+	It("should delete the app it pushed even when it did not start", func() {
+		config.App = bin
+		ioutil.WriteFile(path.Join(bin, "push.fail"), nil, 0644)
+		_, err := RunSmokeTests(config)
+		Ω(err).Should(HaveOccurred())
+		Ω(calls()[len(calls())-1]).Should(Equal("delete"))
+	})
+
+	Describe("running a restore", func() {
+		It("should not complete a restore of a foundation that fails its smoke tests, and summarize them", func() {
+			var summary bytes.Buffer
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+			ioutil.WriteFile(path.Join(bin, "auth.fail"), nil, 0644)
+			entry, err := RunPipelineResult(context.Background(), &mockFlagSet{tileListFlag: "opsmanager", dest: tempDest(), smokeTests: config}, Restore)
+			Ω(err).Should(HaveOccurred())
+			Ω(entry.Status).Should(Equal(SetIncomplete))
+			Ω(entry.SmokeTests.Passed()).Should(BeFalse())
+
+			WriteSummary(&summary, entry)
+			Ω(summary.String()).Should(ContainSubstring("  smoke tests\n    login      failed"))
+		})
+	})
+})
@@ -0,0 +1,343 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	// StrategyDump backs a tile up by dumping its stores, as ever
+	StrategyDump = "dump"
+	// StrategySnapshot backs a tile up by snapshotting the persistent disks
+	// of the instances of its stores on the iaas
+	StrategySnapshot = "snapshot"
+
+	ErrStrategyFormat           = "%q is not a backup strategy, expected a tile and a strategy such as er=snapshot"
+	ErrTileStrategyFormat       = "the %s tile can not be backed up with the %s strategy, only with %s"
+	ErrSnapshotDeploymentFormat = "unable to tell which deployment is the elastic runtime among %s, name it with --snapshotdeployment"
+	ErrSnapshotInstancesFormat  = "no instance group of %s runs %s, so there is no disk of it to snapshot"
+	ErrNoSnapshotFormat         = "bosh took no snapshot of %s of %s, are snapshots enabled on the director?"
+	ErrSnapshotBackupFormat     = "%s holds snapshots of the disks of %s rather than archives of them, restore those disks from their snapshots on the iaas, and the other stores with --components"
+)
+
+var (
+	// TileStrategies are the strategies each tile can be backed up with,
+	// the first being its default. Ops manager is not deployed by bosh, so
+	// only the elastic runtime can be snapshotted
+	TileStrategies = map[string][]string{
+		OpsMgr: []string{StrategyDump},
+		ER:     []string{StrategyDump, StrategySnapshot},
+		PKS:    []string{StrategyDump},
+	}
+)
+
+type (
+	// SnapshotConfig describes backing up tiles by snapshotting the persistent
+	// disks of the instances of their stores, crash consistent, rather than
+	// dumping the stores. The bosh director takes the snapshots through its
+	// cpi, EBS snapshots on aws, persistent disk snapshots on gcp and vm
+	// snapshots on vsphere, so it must have snapshots enabled. The bosh cli
+	// reads the director and its credentials from BOSH_ENVIRONMENT,
+	// BOSH_CLIENT, BOSH_CLIENT_SECRET and BOSH_CA_CERT
+	SnapshotConfig struct {
+		// Strategies are the strategies of the tiles, StrategyDump for those
+		// missing
+		Strategies map[string]string
+		// Deployment is the elastic runtime deployment, the only cf- deployment
+		// of the director when empty
+		Deployment string
+		// Components are the stores snapshotted rather than dumped, every store
+		// of the tile when empty, e.g. just the big nfs_server and mysql
+		Components []string
+		// Binary defaults to bosh on the path
+		Binary string
+	}
+
+	// Snapshot is a snapshot of the persistent disk of an instance of a store
+	// a backup took
+	Snapshot struct {
+		Tile       string `json:"tile"`
+		Component  string `json:"component"`
+		Deployment string `json:"deployment"`
+		Instance   string `json:"instance"`
+		CID        string `json:"cid"`
+		// CPI is the cpi of the director, and so the iaas, that took it
+		CPI     string `json:"cpi,omitempty"`
+		Created string `json:"created_at,omitempty"`
+		Clean   bool   `json:"clean"`
+	}
+)
+
+func ErrStrategy(strategy string) error {
+	return fmt.Errorf(ErrStrategyFormat, strategy)
+}
+
+func ErrTileStrategy(tileName, strategy string) error {
+	return fmt.Errorf(ErrTileStrategyFormat, tileName, strategy, strings.Join(TileStrategies[tileName], ", "))
+}
+
+func ErrSnapshotDeployment(deployments []string) error {
+	return fmt.Errorf(ErrSnapshotDeploymentFormat, "["+strings.Join(deployments, ", ")+"]")
+}
+
+func ErrSnapshotInstances(deployment, component string) error {
+	return fmt.Errorf(ErrSnapshotInstancesFormat, deployment, component)
+}
+
+func ErrNoSnapshot(instance, deployment string) error {
+	return fmt.Errorf(ErrNoSnapshotFormat, instance, deployment)
+}
+
+func ErrSnapshotBackup(destination string, components []string) error {
+	return fmt.Errorf(ErrSnapshotBackupFormat, destination, strings.Join(components, ", "))
+}
+
+// ParseStrategies reads a csv list of tiles and the strategies they are
+// backed up with, such as er=snapshot
+func ParseStrategies(list string) (strategies map[string]string, err error) {
+	strategies = make(map[string]string)
+
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+
+		if len(parts) != 2 {
+			return nil, ErrStrategy(entry)
+		}
+		tileName, strategy := strings.ToUpper(strings.TrimSpace(parts[0])), strings.ToLower(strings.TrimSpace(parts[1]))
+		known, supported := TileStrategies[tileName]
+
+		if !supported {
+			return nil, ErrUnsupportedTile(tileName)
+		}
+
+		if !containsString(known, strategy) {
+			return nil, ErrTileStrategy(tileName, strategy)
+		}
+		strategies[tileName] = strategy
+	}
+	return
+}
+
+// Strategy is the strategy the tile is backed up with
+func (s SnapshotConfig) Strategy(tileName string) string {
+	if strategy, set := s.Strategies[tileName]; set {
+		return strategy
+	}
+	return StrategyDump
+}
+
+// TakeSnapshots has the director snapshot the persistent disks of every
+// instance running one of the components of the tile, returning the
+// snapshots it took
+func TakeSnapshots(config SnapshotConfig, tileName string, components []string) (snapshots []Snapshot, err error) {
+	var (
+		names       []string
+		instances   []map[string]string
+		before      []map[string]string
+		after       []map[string]string
+		taken       = make(map[string]string)
+		snapshotted = make(map[string]bool)
+	)
+	deployment := config.Deployment
+
+	if deployment == "" {
+		if deployment, names, err = elasticRuntimeDeployment(config.Binary); err == nil && deployment == "" {
+			err = ErrSnapshotDeployment(names)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	if instances, err = runBosh(config.Binary, "-d", deployment, "instances"); err != nil {
+		return
+	}
+
+	if before, err = runBosh(config.Binary, "-d", deployment, "snapshots"); err != nil {
+		return
+	}
+
+	for _, component := range components {
+		found := false
+
+		for _, row := range instances {
+			if !instanceGroupOf(strings.SplitN(row["instance"], "/", 2)[0], component) {
+				continue
+			}
+			found = true
+			lo.G.Info("snapshotting the disk of %s of %s", row["instance"], deployment)
+
+			if _, err = runBosh(config.Binary, "-d", deployment, "take-snapshot", row["instance"]); err != nil {
+				return
+			}
+			taken[row["instance"]] = component
+		}
+
+		if !found {
+			return nil, ErrSnapshotInstances(deployment, component)
+		}
+	}
+
+	if after, err = runBosh(config.Binary, "-d", deployment, "snapshots"); err != nil {
+		return
+	}
+	cpi := directorCPI(config.Binary)
+	existing := make(map[string]bool)
+
+	for _, row := range before {
+		existing[row["cid"]] = true
+	}
+
+	for _, row := range after {
+		if component, ours := taken[row["instance"]]; ours && !existing[row["cid"]] {
+			snapshots = append(snapshots, Snapshot{
+				Tile:       tileName,
+				Component:  component,
+				Deployment: deployment,
+				Instance:   row["instance"],
+				CID:        row["cid"],
+				CPI:        cpi,
+				Created:    row["created_at"],
+				Clean:      row["clean"] == "true",
+			})
+			snapshotted[row["instance"]] = true
+		}
+	}
+
+	for instance := range taken {
+		if !snapshotted[instance] {
+			return nil, ErrNoSnapshot(instance, deployment)
+		}
+	}
+	return
+}
+
+// directorCPI is the cpi of the director, none when it can not tell
+func directorCPI(binary string) string {
+	if rows, err := runBosh(binary, "env"); err == nil && len(rows) > 0 {
+		return rows[0]["cpi"]
+	}
+	return ""
+}
+
+// snapshotStores snapshots the disks of the stores of the elastic runtime
+// the configuration snapshots, leaving only the others for the tile to dump.
+// It tells whether any store is left to dump
+func (s *pipelineRun) snapshotStores(er *cfbackup.ElasticRuntime, tileName string) (dump bool, err error) {
+	var (
+		components []string
+		dumped     []cfbackup.SystemDump
+		snapshots  []Snapshot
+	)
+	config := s.fs.Snapshots()
+
+	for _, component := range config.Components {
+		var found bool
+
+		for _, system := range er.PersistentSystems {
+			found = found || system.Get(cfbackup.SD_COMPONENT) == component
+		}
+
+		if !found && s.fs.Components() == "" {
+			return false, ErrUnknownComponent(tileName, component)
+		}
+	}
+
+	for _, system := range er.PersistentSystems {
+		if component := system.Get(cfbackup.SD_COMPONENT); len(config.Components) == 0 || containsString(config.Components, component) {
+			components = append(components, component)
+		} else {
+			dumped = append(dumped, system)
+		}
+	}
+	started := time.Now()
+	snapshots, err = TakeSnapshots(config, tileName, components)
+	s.phases.add(PhaseSnapshot, time.Since(started))
+
+	if err != nil {
+		return false, tileError(tileName, "", PhaseSnapshot, err)
+	}
+	s.entry.Snapshots = append(s.entry.Snapshots, snapshots...)
+
+	// the archives an earlier backup left of the stores are not part of this one
+	for _, component := range components {
+		os.Remove(path.Join(s.fs.Dest(), erArtifact(component)))
+	}
+	er.PersistentSystems = dumped
+	return len(dumped) > 0, nil
+}
+
+// snapshottedArtifacts are the archives the backup in the source does not
+// have, as it snapshotted the disks of their stores instead
+func snapshottedArtifacts(source artifactSource) (artifacts map[string]bool) {
+	var manifest Manifest
+	artifacts = make(map[string]bool)
+	contents, err := source.openArtifact(ManifestName)
+
+	if err != nil {
+		return
+	}
+	defer contents.Close()
+
+	if json.NewDecoder(contents).Decode(&manifest) == nil {
+		for _, snapshot := range manifest.Snapshots {
+			artifacts[erArtifact(snapshot.Component)] = true
+		}
+	}
+	return
+}
+
+// checkSnapshotBackup refuses to restore the stores a backup snapshotted
+// rather than archived, which cfops has nothing to restore from
+func checkSnapshotBackup(fs flagSet) (err error) {
+	var (
+		manifest    Manifest
+		snapshotted []string
+	)
+
+	if manifest, err = LoadManifest(fs.Dest()); err != nil || len(manifest.Snapshots) == 0 {
+		return nil
+	}
+	restoresER := fs.Tilelist() == ""
+
+	for _, tileName := range formatArray(strings.Split(fs.Tilelist(), ",")) {
+		restoresER = restoresER || tileName == ER
+	}
+
+	for _, snapshot := range manifest.Snapshots {
+		restored := fs.Components() == ""
+
+		for _, component := range strings.Split(fs.Components(), ",") {
+			restored = restored || strings.ToLower(strings.TrimSpace(component)) == snapshot.Component
+		}
+
+		if restoresER && restored && !containsString(snapshotted, snapshot.Component) {
+			snapshotted = append(snapshotted, snapshot.Component)
+		}
+	}
+
+	if len(snapshotted) > 0 {
+		return ErrSnapshotBackup(fs.Dest(), snapshotted)
+	}
+	return
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package cfops_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// snapshotBosh answers the snapshots of a deployment with those listed in
+// snapshots-after.json once it was asked to take one
+const snapshotBosh = `#!/bin/sh
+dir="$(dirname "$0")"
+echo "$*" >> "$dir/calls"
+case "$*" in
+*take-snapshot*) touch "$dir/taken"; echo '{"Tables":[]}'; exit 0 ;;
+*snapshots*) [ -f "$dir/taken" ] && { cat "$dir/snapshots-after.json"; exit 0; } ;;
+esac
+for arg in "$@"; do command="$arg"; done
+cat "$dir/$command.json" 2>/dev/null || { echo '{"Tables":[],"Lines":["Director responded with non-successful status code 401"]}'; exit 1; }
+`
+
+var _ = Describe("Snapshots", func() {
+	var (
+		bin    string
+		config SnapshotConfig
+	)
+
+	answer := func(command, output string) {
+		ioutil.WriteFile(path.Join(bin, command+".json"), []byte(output), 0644)
+	}
+
+	calls := func() []string {
+		contents, _ := ioutil.ReadFile(path.Join(bin, "calls"))
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		bin, _ = ioutil.TempDir("", "bosh-bin")
+		ioutil.WriteFile(path.Join(bin, "bosh"), []byte(snapshotBosh), 0755)
+		answer("deployments", `{"Tables":[{"Rows":[{"name":"cf-0123"},{"name":"p-mysql-0456"}]}]}`)
+		answer("env", `{"Tables":[{"Rows":[{"name":"p-bosh","cpi":"aws_cpi"}]}]}`)
+		answer("instances", `{"Tables":[{"Rows":[{"instance":"nfs_server-partition-7a8d1e/0a1b"},{"instance":"mysql-partition-7a8d1e/2c3d"},{"instance":"router/4e5f"}]}]}`)
+		answer("snapshots", `{"Tables":[{"Rows":[{"instance":"nfs_server-partition-7a8d1e/0a1b","cid":"snap-old","created_at":"Mon Oct 12 01:00:00 UTC 2026","clean":"true"}]}]}`)
+		answer("snapshots-after", `{"Tables":[{"Rows":[{"instance":"nfs_server-partition-7a8d1e/0a1b","cid":"snap-old","created_at":"Mon Oct 12 01:00:00 UTC 2026","clean":"true"},{"instance":"nfs_server-partition-7a8d1e/0a1b","cid":"snap-0a1b","created_at":"Thu Oct 15 01:00:00 UTC 2026","clean":"false"},{"instance":"mysql-partition-7a8d1e/2c3d","cid":"snap-2c3d","created_at":"Thu Oct 15 01:00:00 UTC 2026","clean":"false"}]}]}`)
+		config = SnapshotConfig{Strategies: map[string]string{ER: StrategySnapshot}, Binary: path.Join(bin, "bosh")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(bin)
+	})
+
+	Describe("ParseStrategies", func() {
+		It("should read the strategy of each tile", func() {
+			strategies, err := ParseStrategies("er=snapshot, opsmanager=dump")
+			Ω(err).Should(BeNil())
+			Ω(strategies).Should(Equal(map[string]string{ER: StrategySnapshot, OpsMgr: StrategyDump}))
+			Ω(SnapshotConfig{Strategies: strategies}.Strategy(ER)).Should(Equal(StrategySnapshot))
+		})
+
+		It("should dump the tiles it does not name", func() {
+			Ω(SnapshotConfig{}.Strategy(ER)).Should(Equal(StrategyDump))
+		})
+
+		It("should refuse to snapshot ops manager, which bosh does not deploy", func() {
+			_, err := ParseStrategies("opsmanager=snapshot")
+			Ω(err).Should(Equal(ErrTileStrategy(OpsMgr, StrategySnapshot)))
+		})
+
+		It("should refuse an unknown tile or a malformed entry", func() {
+			_, err := ParseStrategies("notatile=dump")
+			Ω(err).Should(Equal(ErrUnsupportedTile("NOTATILE")))
+			_, err = ParseStrategies("er")
+			Ω(err).Should(Equal(ErrStrategy("er")))
+		})
+	})
+
+	Describe("TakeSnapshots", func() {
+		It("should snapshot the instances of each store and record the snapshots taken", func() {
+			snapshots, err := TakeSnapshots(config, ER, []string{"nfs_server", "mysql"})
+			Ω(err).Should(BeNil())
+			Ω(snapshots).Should(Equal([]Snapshot{
+				{Tile: ER, Component: "nfs_server", Deployment: "cf-0123", Instance: "nfs_server-partition-7a8d1e/0a1b", CID: "snap-0a1b", CPI: "aws_cpi", Created: "Thu Oct 15 01:00:00 UTC 2026"},
+				{Tile: ER, Component: "mysql", Deployment: "cf-0123", Instance: "mysql-partition-7a8d1e/2c3d", CID: "snap-2c3d", CPI: "aws_cpi", Created: "Thu Oct 15 01:00:00 UTC 2026"},
+			}))
+			Ω(calls()).Should(ContainElement("--json --non-interactive -d cf-0123 take-snapshot nfs_server-partition-7a8d1e/0a1b"))
+			Ω(calls()).Should(ContainElement("--json --non-interactive -d cf-0123 take-snapshot mysql-partition-7a8d1e/2c3d"))
+		})
+
+		It("should fail when no instance runs a store", func() {
+			_, err := TakeSnapshots(config, ER, []string{"ccdb"})
+			Ω(err).Should(Equal(ErrSnapshotInstances("cf-0123", "ccdb")))
+		})
+
+		It("should fail when the director took no snapshot", func() {
+			answer("snapshots-after", `{"Tables":[{"Rows":[]}]}`)
+			_, err := TakeSnapshots(config, ER, []string{"mysql"})
+			Ω(err).Should(Equal(ErrNoSnapshot("mysql-partition-7a8d1e/2c3d", "cf-0123")))
+		})
+
+		It("should fail when it can not tell which deployment is the elastic runtime", func() {
+			answer("deployments", `{"Tables":[{"Rows":[{"name":"cf-0123"},{"name":"cf-0789"}]}]}`)
+			_, err := TakeSnapshots(config, ER, []string{"mysql"})
+			Ω(err).Should(Equal(ErrSnapshotDeployment([]string{"cf-0123", "cf-0789"})))
+		})
+	})
+
+	Describe("RunPipeline with the snapshot strategy", func() {
+		var fs *mockFlagSet
+
+		BeforeEach(func() {
+			dir, _ := ioutil.TempDir("", "snapshot")
+			SupportedTiles = map[string]func() (Tile, error){
+				ER: func() (Tile, error) {
+					er := cfbackup.NewElasticRuntime("installation.json", dir)
+					er.PersistentSystems = []cfbackup.SystemDump{
+						&cfbackup.SystemInfo{Component: "nfs_server"},
+						&cfbackup.SystemInfo{Component: "mysql"},
+					}
+					return er, nil
+				},
+			}
+			config.Components = []string{"nfs_server", "mysql"}
+			fs = &mockFlagSet{tileListFlag: "er", dest: dir, catalog: path.Join(dir, "catalog.json"), snapshots: config}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(fs.dest)
+		})
+
+		It("should record the snapshots of the stores in the manifest rather than dump them", func() {
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			manifest, err := LoadManifest(fs.dest)
+			Ω(err).Should(BeNil())
+			Ω(manifest.Snapshots).Should(HaveLen(2))
+			Ω(manifest.Snapshots[0].CID).Should(Equal("snap-0a1b"))
+			Ω(path.Join(fs.dest, "nfs_server.backup")).ShouldNot(BeAnExistingFile())
+		})
+
+		It("should verify the backup without the archives of the stores it snapshotted", func() {
+			RunPipeline(fs, Backup)
+			for _, artifact := range BackupArtifacts[ER] {
+				if artifact != "nfs_server.backup" && artifact != "mysql.backup" {
+					writeArtifacts(fs.dest, []string{artifact})
+				}
+			}
+			Ω(Verify(fs.dest, []string{ER})).Should(BeNil())
+		})
+
+		It("should refuse to restore the stores it snapshotted", func() {
+			RunPipeline(fs, Backup)
+			Ω(RunPipeline(fs, Restore)).Should(Equal(ErrSnapshotBackup(fs.dest, []string{"nfs_server", "mysql"})))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package cfops
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	statsdPrefix = "cfops"
+	// statsdMaxPacket keeps each datagram below a typical network MTU
+	statsdMaxPacket = 1432
+)
+
+var statsdNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// SendStatsd sends the metric set of a finished run to a statsd server as
+// gauges, named cfops.<action>[.<tile>].<metric>
+func SendStatsd(address string, entry *CatalogEntry, lastSuccess time.Time) (err error) {
+	var (
+		conn   net.Conn
+		packet bytes.Buffer
+	)
+
+	if conn, err = net.Dial("udp", address); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for _, m := range runMetrics(entry, lastSuccess) {
+		line := fmt.Sprintf("%s:%g|g\n", statsdName(m), m.value)
+
+		if packet.Len() > 0 && packet.Len()+len(line) > statsdMaxPacket {
+			if _, err = conn.Write(packet.Bytes()); err != nil {
+				return
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return
+}
+
+func statsdName(m metric) string {
+	parts := []string{statsdPrefix}
+
+	for _, label := range m.labels {
+		parts = append(parts, statsdNameSanitizer.ReplaceAllString(label[1], "_"))
+	}
+	return strings.Join(append(parts, strings.TrimPrefix(m.name, statsdPrefix+"_")), ".")
+}
@@ -0,0 +1,101 @@
+package cfops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// FindCheckpoint is the most recently updated checkpoint of a run of the
+// foundation against the destination, among the copies kept in the
+// destination and those kept locally in dir, nil when there is none. Either
+// the foundation or the destination may be left empty
+func FindCheckpoint(dir, foundation, destination string) (found *RestoreCheckpoint, err error) {
+	type candidate struct{ path, action string }
+	var candidates []candidate
+
+	if destination != "" {
+		candidates = append(candidates, candidate{path.Join(destination, BackupCheckpointFileName), Backup}, candidate{path.Join(destination, CheckpointFileName), Restore})
+	}
+
+	if dir != "" && foundation != "" {
+		candidates = append(candidates, candidate{LocalCheckpointPath(dir, foundation, Backup), Backup}, candidate{LocalCheckpointPath(dir, foundation, Restore), Restore})
+	}
+
+	for _, c := range candidates {
+		var checkpoint *RestoreCheckpoint
+
+		if checkpoint, err = openCheckpoint(c.path); err != nil {
+			return nil, err
+		}
+
+		// checkpoints written before they described their run
+		if checkpoint.Action == "" {
+			checkpoint.Action = c.action
+		}
+
+		switch {
+		// there is no such file
+		case checkpoint.Updated.IsZero() && len(checkpoint.Steps) == 0:
+			continue
+
+		case foundation != "" && checkpoint.Foundation != "" && checkpoint.Foundation != foundation:
+			continue
+
+		case destination != "" && checkpoint.Destination != "" && path.Clean(checkpoint.Destination) != path.Clean(destination):
+			continue
+		}
+
+		if found == nil || checkpoint.Updated.After(found.Updated) {
+			found = checkpoint
+		}
+	}
+	return
+}
+
+// WriteStatus writes what the run of the checkpoint was doing when last seen,
+// as json or as text
+func WriteStatus(w io.Writer, checkpoint *RestoreCheckpoint, now time.Time, asJSON bool) (err error) {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(checkpoint)
+	}
+	fmt.Fprintf(w, "%s of %s, %s\n", checkpoint.Action, checkpoint.Foundation, checkpoint.Destination)
+
+	if checkpoint.RunID != "" {
+		fmt.Fprintf(w, "run: %s\n", checkpoint.RunID)
+	}
+
+	if !checkpoint.Updated.IsZero() {
+		fmt.Fprintf(w, "last seen: %s (%s ago)\n", checkpoint.Updated.Format(time.RFC3339), now.Sub(checkpoint.Updated).Round(time.Second))
+	}
+
+	if checkpoint.Phase != "" {
+		fmt.Fprintf(w, "phase: %s\n", checkpoint.Phase)
+	}
+	var transfers []string
+
+	for transfer := range checkpoint.Transfers {
+		transfers = append(transfers, transfer)
+	}
+	sort.Strings(transfers)
+
+	for _, transfer := range transfers {
+		fmt.Fprintf(w, "in flight: %s at %d bytes\n", transfer, checkpoint.Transfers[transfer])
+	}
+
+	for _, step := range checkpoint.CompletedSteps() {
+		fmt.Fprintf(w, "completed: %s\n", step)
+	}
+
+	for _, step := range checkpoint.Progress {
+		if step.Status != StepCompleted {
+			fmt.Fprintf(w, "step %d/%d %s: %s\n", step.Number, len(checkpoint.Progress), step.Status, step.Description)
+		}
+	}
+	return
+}
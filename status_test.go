@@ -0,0 +1,120 @@
+package cfops_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Status", func() {
+	var (
+		dir    string
+		local  string
+		opsmgr *mockTile
+		fs     *mockFlagSet
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "status")
+		local = path.Join(dir, "checkpoints")
+		opsmgr = &mockTile{}
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				return opsmgr, nil
+			},
+		}
+		fs = &mockFlagSet{tileListFlag: "opsmanager", dest: path.Join(dir, "backup"), host: "opsman.prod", checkpoints: local}
+		os.MkdirAll(fs.dest, 0755)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("a run that did not complete", func() {
+		BeforeEach(func() {
+			opsmgr.ErrReturned = errors.New("connection refused")
+			RunPipeline(fs, Backup)
+		})
+
+		It("should leave its checkpoint both in the destination and locally", func() {
+			Ω(path.Join(fs.dest, BackupCheckpointFileName)).Should(BeAnExistingFile())
+			Ω(LocalCheckpointPath(local, "opsman.prod", Backup)).Should(BeAnExistingFile())
+		})
+
+		It("should be found from the destination once the host running it is gone", func() {
+			os.RemoveAll(local)
+			checkpoint, err := FindCheckpoint(local, "", fs.dest)
+			Ω(err).Should(BeNil())
+			Ω(checkpoint.Action).Should(Equal(Backup))
+			Ω(checkpoint.Foundation).Should(Equal("opsman.prod"))
+			Ω(checkpoint.Tilelist).Should(Equal("opsmanager"))
+			Ω(checkpoint.Phase).Should(Equal(OpsMgr))
+		})
+
+		It("should be found locally once the destination is gone", func() {
+			os.RemoveAll(fs.dest)
+			checkpoint, err := FindCheckpoint(local, "opsman.prod", "")
+			Ω(err).Should(BeNil())
+			Ω(checkpoint.Destination).Should(Equal(fs.dest))
+		})
+
+		It("should not be taken for a run of another foundation", func() {
+			Ω(FindCheckpoint(local, "opsman.staging", fs.dest)).Should(BeNil())
+		})
+
+		It("should remove both copies once a resumed run completes", func() {
+			opsmgr.ErrReturned = nil
+			Ω(RunPipeline(fs, Backup)).Should(BeNil())
+			Ω(FindCheckpoint(local, "opsman.prod", fs.dest)).Should(BeNil())
+		})
+	})
+
+	It("should record the bytes of the transfers in flight", func() {
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				stop := StartHeartbeat("OPSMANAGER/installation/dump", 4096, func() int64 { return 1024 }, 10*time.Millisecond)
+				time.Sleep(35 * time.Millisecond)
+				stop()
+				return nil, errors.New("interrupted")
+			},
+		}
+		RunPipeline(fs, Backup)
+
+		checkpoint, err := FindCheckpoint("", "", fs.dest)
+		Ω(err).Should(BeNil())
+		Ω(checkpoint.Transfers).Should(Equal(map[string]int64{"OPSMANAGER/installation/dump": 1024}))
+	})
+
+	It("should tell what the run was doing when last seen", func() {
+		updated := time.Date(2026, time.October, 15, 2, 0, 0, 0, time.UTC)
+		checkpoint := &RestoreCheckpoint{
+			Action:      Backup,
+			Foundation:  "opsman.prod",
+			RunID:       "20261015T015500Z-0badf00d",
+			Destination: "/backups/prod",
+			Steps:       map[string]time.Time{OpsMgr: updated, ER + "/ccdb": updated},
+			Phase:       ER + "/nfs_server/dump",
+			Transfers:   map[string]int64{ER + "/nfs_server/dump": 2048},
+			Updated:     updated,
+		}
+		var out bytes.Buffer
+		Ω(WriteStatus(&out, checkpoint, updated.Add(90*time.Second), false)).Should(BeNil())
+		Ω(out.String()).Should(Equal(`backup of opsman.prod, /backups/prod
+run: 20261015T015500Z-0badf00d
+last seen: 2026-10-15T02:00:00Z (1m30s ago)
+phase: ER/nfs_server/dump
+in flight: ER/nfs_server/dump at 2048 bytes
+completed: ER/ccdb
+completed: OPSMANAGER
+`))
+	})
+})
@@ -0,0 +1,118 @@
+package cfops
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	// OriginalArtifactSuffix names where an artifact is kept while a restore
+	// reads a rewritten copy of it
+	OriginalArtifactSuffix  = ".original"
+	rewrittenArtifactSuffix = ".rewritten"
+)
+
+var (
+	// substituted are the artifacts whose original is kept aside by a
+	// restore in progress, which later rewrites of them build on
+	substituted      = make(map[string]bool)
+	substitutedMutex sync.Mutex
+)
+
+// substituteArtifacts replaces each of the artifacts found in the destination
+// with the copy rewrite writes of it, keeping the original next to it. The
+// returned function puts the originals back. Originals an interrupted restore
+// left next to an artifact are put back before it is rewritten again, while
+// an artifact the same restore already substituted is rewritten in place
+func substituteArtifacts(destination string, artifacts []string, rewrite func(artifact string, in io.Reader, out io.Writer) error) (restore func(), err error) {
+	var kept []string
+	restore = func() {
+		substitutedMutex.Lock()
+		defer substitutedMutex.Unlock()
+
+		for _, artifact := range kept {
+			target := path.Join(destination, artifact)
+			delete(substituted, target)
+
+			if renameErr := os.Rename(target+OriginalArtifactSuffix, target); renameErr != nil {
+				warn("unable to put back the original of %s: %s", artifact, renameErr)
+			}
+		}
+	}
+	substitutedMutex.Lock()
+
+	for _, artifact := range artifacts {
+		target := path.Join(destination, artifact)
+		rewriteArtifact := func(in io.Reader, out io.Writer) error {
+			return rewrite(artifact, in, out)
+		}
+
+		if substituted[target] {
+			if err = rewriteFile(target, target+rewrittenArtifactSuffix, rewriteArtifact); err == nil {
+				err = os.Rename(target+rewrittenArtifactSuffix, target)
+			}
+
+			if err != nil {
+				os.Remove(target + rewrittenArtifactSuffix)
+				break
+			}
+			continue
+		}
+
+		if _, statErr := os.Stat(target + OriginalArtifactSuffix); statErr == nil {
+			if err = os.Rename(target+OriginalArtifactSuffix, target); err != nil {
+				break
+			}
+		}
+
+		if _, statErr := os.Stat(target); statErr != nil {
+			continue
+		}
+
+		if err = os.Rename(target, target+OriginalArtifactSuffix); err != nil {
+			break
+		}
+		kept = append(kept, artifact)
+		substituted[target] = true
+
+		if err = rewriteFile(target+OriginalArtifactSuffix, target, rewriteArtifact); err != nil {
+			break
+		}
+	}
+
+	substitutedMutex.Unlock()
+
+	if err != nil {
+		restore()
+		restore = func() {}
+	}
+	return
+}
+
+func rewriteFile(from, to string, rewrite func(io.Reader, io.Writer) error) (err error) {
+	var (
+		in, out *os.File
+		info    os.FileInfo
+	)
+
+	if in, err = os.Open(from); err != nil {
+		return
+	}
+	defer in.Close()
+
+	if info, err = in.Stat(); err != nil {
+		return
+	}
+
+	if out, err = os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode()); err != nil {
+		return
+	}
+
+	if err = rewrite(in, out); err != nil {
+		out.Close()
+		return
+	}
+	return out.Close()
+}
@@ -0,0 +1,78 @@
+package cfops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	// SummaryName is the outcome of a backup written next to its artifacts
+	// at the end of the run, whether it completed or not
+	SummaryName = "summary.json"
+	// RestoreSummaryName is the outcome of a restore, written next to the
+	// artifacts it restored without replacing the summary of their backup
+	RestoreSummaryName = "restore-summary.json"
+)
+
+// RunSummary is the catalog entry of a run, the size and sha256 of each
+// artifact when a manifest describes them, and the warnings of the run
+type RunSummary struct {
+	*CatalogEntry
+	Artifacts []ManifestArtifact `json:"artifacts,omitempty"`
+	Warnings  []string           `json:"warnings,omitempty"`
+}
+
+// collectWarnings gathers the warnings the run publishes until stop is
+// called, which returns them
+func collectWarnings(runID string) (stop func() []string) {
+	var warnings []string
+	subscription, cancel := SubscribeEvents()
+	done := make(chan bool)
+
+	go func() {
+		defer close(done)
+
+		for event := range subscription {
+			if event.Type == EventWarning && event.RunID == runID {
+				warnings = append(warnings, event.Message)
+			}
+		}
+	}()
+	return func() []string {
+		cancel()
+		<-done
+		return warnings
+	}
+}
+
+// writeRunSummary writes the summary of the finished run into the
+// destination. The checksums are those of the manifest the backup wrote,
+// which may since have been packed into an archive, or of the backup a
+// restore restored
+func writeRunSummary(destination string, entry *CatalogEntry, written *Manifest, warnings []string) (err error) {
+	var contents []byte
+	summary := RunSummary{CatalogEntry: entry, Warnings: warnings}
+	name := SummaryName
+
+	if entry.Action == Restore {
+		name = RestoreSummaryName
+	}
+
+	if written != nil {
+		summary.Artifacts = written.Artifacts
+	} else if manifest, manifestErr := loadBackupManifest(destination); manifestErr == nil && entry.Action == Restore {
+		summary.Artifacts = manifest.Artifacts
+	}
+
+	if contents, err = json.MarshalIndent(summary, "", "  "); err != nil {
+		return
+	}
+	tmp := path.Join(destination, name+".tmp")
+
+	if err = ioutil.WriteFile(tmp, append(contents, '\n'), 0600); err == nil {
+		err = os.Rename(tmp, path.Join(destination, name))
+	}
+	return
+}
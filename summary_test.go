@@ -0,0 +1,86 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run summary", func() {
+	var (
+		dir string
+		fs  *mockFlagSet
+	)
+
+	readSummary := func(name string) (summary RunSummary) {
+		contents, err := ioutil.ReadFile(path.Join(dir, name))
+		Ω(err).Should(BeNil())
+		Ω(json.Unmarshal(contents, &summary)).Should(BeNil())
+		return
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "summary")
+		writeArtifacts(dir, BackupArtifacts[OpsMgr])
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				return &mockTile{}, nil
+			},
+			ER: func() (Tile, error) {
+				return &mockTile{ErrReturned: errors.New("er failed")}, nil
+			},
+		}
+		fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir}
+	})
+
+	AfterEach(func() {
+		RegisterNotifier("failing", nil)
+		os.RemoveAll(dir)
+	})
+
+	It("should write the outcome and checksums of a complete backup next to its artifacts", func() {
+		Ω(RunPipeline(fs, Backup)).Should(BeNil())
+		summary := readSummary(SummaryName)
+		Ω(summary.Status).Should(Equal(SetComplete))
+		Ω(summary.Components).Should(HaveLen(1))
+		Ω(summary.Components[0].Bytes).Should(BeNumerically(">", 0))
+		Ω(summary.Artifacts).ShouldNot(BeEmpty())
+		Ω(summary.Artifacts[0].SHA256).Should(HaveLen(64))
+		Ω(summary.Warnings).Should(BeEmpty())
+	})
+
+	It("should take the checksums of a backup packed into an archive from its manifest", func() {
+		fs.archive = true
+		Ω(RunPipeline(fs, Backup)).Should(BeNil())
+		Ω(path.Join(dir, ManifestName)).ShouldNot(BeAnExistingFile())
+		summary := readSummary(SummaryName)
+		Ω(summary.Artifacts).ShouldNot(BeEmpty())
+		Ω(summary.Artifacts[0].SHA256).Should(HaveLen(64))
+	})
+
+	It("should write the failed components and the warnings of a failed backup", func() {
+		RegisterNotifier("failing", &recordingNotifier{err: errors.New("webhook unreachable")})
+		fs.tileListFlag = "opsmanager, er"
+		RunPipeline(fs, Backup)
+		summary := readSummary(SummaryName)
+		Ω(summary.Status).Should(Equal(SetIncomplete))
+		Ω(summary.Components[1].Error).Should(ContainSubstring("er failed"))
+		Ω(summary.Artifacts).Should(BeEmpty())
+		Ω(summary.Warnings).Should(ContainElement(ContainSubstring("webhook unreachable")))
+	})
+
+	It("should keep the summary of the backup a restore restored", func() {
+		Ω(RunPipeline(fs, Backup)).Should(BeNil())
+		backupID := readSummary(SummaryName).ID
+		RunPipeline(fs, Restore)
+		Ω(readSummary(SummaryName).ID).Should(Equal(backupID))
+		Ω(readSummary(RestoreSummaryName).Action).Should(Equal(Restore))
+	})
+})
@@ -0,0 +1,218 @@
+package cfops
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	ErrSyslogAddressFormat   = "syslog address %s must look like udp://host:port, tcp://host:port or tls://host:port"
+	ErrUnknownFacilityFormat = "unknown syslog facility %s"
+	ErrNoCertificatesFormat  = "no certificates found in %s"
+	// syslogStructuredDataID names the structured data element carrying the run
+	// and task ids, 32473 is the enterprise number reserved for examples
+	syslogStructuredDataID = "cfops@32473"
+	syslogDialTimeout      = 10 * time.Second
+)
+
+var (
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+		"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+		"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+		"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+	syslogSeverities = map[logging.Level]int{
+		logging.CRITICAL: 2,
+		logging.ERROR:    3,
+		logging.WARNING:  4,
+		logging.NOTICE:   5,
+		logging.INFO:     6,
+		logging.DEBUG:    7,
+	}
+	syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+func ErrSyslogAddress(address string) error {
+	return fmt.Errorf(ErrSyslogAddressFormat, address)
+}
+
+func ErrUnknownFacility(facility string) error {
+	return fmt.Errorf(ErrUnknownFacilityFormat, facility)
+}
+
+func ErrNoCertificates(pemFile string) error {
+	return fmt.Errorf(ErrNoCertificatesFormat, pemFile)
+}
+
+// syslogBackend writes RFC 5424 messages to a remote syslog endpoint. Stream
+// transports use octet counting framing and reconnect once on a failed write
+type syslogBackend struct {
+	network   string
+	host      string
+	tlsConfig *tls.Config
+	facility  int
+	hostname  string
+	appName   string
+	conn      net.Conn
+	mutex     sync.Mutex
+}
+
+// ConfigureSyslog sends every log record to the syslog endpoint as well, with
+// the given facility (user when empty). caFile optionally replaces the system
+// roots used to verify a tls endpoint
+func ConfigureSyslog(address, facility, caFile string) (err error) {
+	var backend *syslogBackend
+
+	if backend, err = newSyslogBackend(address, facility, caFile); err != nil {
+		return
+	}
+	console := consoleBackend
+
+	if console == nil {
+		console = newTextBackend(os.Stderr)
+	}
+	setBackends(console, backend)
+	return
+}
+
+func newSyslogBackend(address, facility, caFile string) (backend *syslogBackend, err error) {
+	var (
+		endpoint *url.URL
+		ok       bool
+	)
+	backend = &syslogBackend{appName: path.Base(os.Args[0])}
+	backend.hostname, _ = os.Hostname()
+
+	if facility == "" {
+		facility = "user"
+	}
+
+	if backend.facility, ok = syslogFacilities[strings.ToLower(facility)]; !ok {
+		return nil, ErrUnknownFacility(facility)
+	}
+
+	if endpoint, err = url.Parse(address); err != nil || endpoint.Host == "" {
+		return nil, ErrSyslogAddress(address)
+	}
+	backend.host = endpoint.Host
+
+	switch endpoint.Scheme {
+	case "udp", "tcp":
+		backend.network = endpoint.Scheme
+
+	case "tls":
+		backend.network = "tcp"
+		backend.tlsConfig = &tls.Config{ServerName: endpoint.Hostname()}
+
+		if caFile != "" {
+			backend.tlsConfig.RootCAs, err = loadCertPool(caFile)
+		}
+
+	default:
+		return nil, ErrSyslogAddress(address)
+	}
+	return
+}
+
+func loadCertPool(caFile string) (pool *x509.CertPool, err error) {
+	var contents []byte
+
+	if contents, err = ioutil.ReadFile(caFile); err == nil {
+		pool = x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(contents) {
+			err = ErrNoCertificates(caFile)
+		}
+	}
+	return
+}
+
+func (s *syslogBackend) Log(level logging.Level, calldepth int, rec *logging.Record) (err error) {
+	message := s.format(level, rec)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err = s.write(message); err != nil && s.network != "udp" {
+		s.close()
+		err = s.write(message)
+	}
+	return
+}
+
+func (s *syslogBackend) format(level logging.Level, rec *logging.Record) string {
+	var params []string
+	runID, taskID, taskName, parentID := logContext.current()
+
+	for _, param := range [][2]string{{"run_id", runID}, {"task_id", taskID}, {"task", taskName}, {"parent_task_id", parentID}} {
+		if param[1] != "" {
+			params = append(params, fmt.Sprintf(`%s="%s"`, param[0], syslogParamEscaper.Replace(param[1])))
+		}
+	}
+	structuredData := "-"
+
+	if len(params) > 0 {
+		structuredData = fmt.Sprintf("[%s %s]", syslogStructuredDataID, strings.Join(params, " "))
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		s.facility*8+syslogSeverities[level],
+		rec.Time.UTC().Format(time.RFC3339Nano),
+		nilValue(s.hostname),
+		nilValue(s.appName),
+		os.Getpid(),
+		nilValue(rec.Module),
+		structuredData,
+		rec.Message(),
+	)
+}
+
+func nilValue(field string) string {
+	if field == "" {
+		return "-"
+	}
+	return strings.Replace(field, " ", "_", -1)
+}
+
+func (s *syslogBackend) write(message string) (err error) {
+	if s.conn == nil {
+		if err = s.connect(); err != nil {
+			return
+		}
+	}
+
+	if s.network != "udp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	_, err = s.conn.Write([]byte(message))
+	return
+}
+
+func (s *syslogBackend) connect() (err error) {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+
+	if s.tlsConfig != nil {
+		s.conn, err = tls.DialWithDialer(dialer, s.network, s.host, s.tlsConfig)
+
+	} else {
+		s.conn, err = dialer.Dial(s.network, s.host)
+	}
+	return
+}
+
+func (s *syslogBackend) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
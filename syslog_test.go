@@ -0,0 +1,95 @@
+package cfops_test
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	. "github.com/pivotalservices/cfops"
+	"github.com/xchapter7x/lo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigureSyslog", func() {
+	var level logging.Level
+
+	BeforeEach(func() {
+		level = logging.GetLevel(lo.LOG_MODULE)
+	})
+
+	AfterEach(func() {
+		ConfigureLogging(LogFormatText, os.Stderr)
+		logging.SetLevel(level, lo.LOG_MODULE)
+		SetRunID("")
+	})
+
+	Context("when the endpoint is udp", func() {
+		var conn net.PacketConn
+
+		BeforeEach(func() {
+			conn, _ = net.ListenPacket("udp", "127.0.0.1:0")
+		})
+
+		AfterEach(func() {
+			conn.Close()
+		})
+
+		It("should send rfc 5424 messages with the facility and run id", func() {
+			Ω(ConfigureSyslog("udp://"+conn.LocalAddr().String(), "local0", "")).Should(BeNil())
+			SetRunID("run")
+			lo.G.Error("dump failed")
+
+			packet := make([]byte, 2048)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFrom(packet)
+			Ω(err).Should(BeNil())
+			Ω(string(packet[:n])).Should(HavePrefix("<131>1 "))
+			Ω(string(packet[:n])).Should(HaveSuffix(` [cfops@32473 run_id="run"] dump failed`))
+		})
+	})
+
+	Context("when the endpoint is tcp", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			listener, _ = net.Listen("tcp", "127.0.0.1:0")
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("should frame each message with its length", func() {
+			Ω(ConfigureSyslog("tcp://"+listener.Addr().String(), "", "")).Should(BeNil())
+			lo.G.Error("dump failed")
+
+			conn, err := listener.Accept()
+			Ω(err).Should(BeNil())
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			reader := bufio.NewReader(conn)
+			length, _ := reader.ReadString(' ')
+			message := make([]byte, len(strings.TrimSpace(length))+100)
+			n, _ := reader.Read(message)
+			Ω(string(message[:n])).Should(HavePrefix("<11>1 "))
+			Ω(string(message[:n])).Should(HaveSuffix("- dump failed"))
+		})
+	})
+
+	Context("when the address has no supported scheme", func() {
+		It("should return a syslog address error", func() {
+			Ω(ConfigureSyslog("syslog.example.com:514", "", "")).Should(Equal(ErrSyslogAddress("syslog.example.com:514")))
+		})
+	})
+
+	Context("when the facility is unknown", func() {
+		It("should return an unknown facility error", func() {
+			Ω(ConfigureSyslog("udp://127.0.0.1:514", "local9", "")).Should(Equal(ErrUnknownFacility("local9")))
+		})
+	})
+})
@@ -0,0 +1,115 @@
+package cfops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrByteRateFormat       = "%q is not a rate, expected bytes a second such as 50MB or 512KB/s"
+	ErrBandwidthLimitFormat = "%q is not a bandwidth limit, expected a component and a rate such as nfs_server=20MB"
+)
+
+type (
+	// RestoreLimits keep a restore into a live foundation from saturating its
+	// stores. They do not apply to backups
+	RestoreLimits struct {
+		// Rate caps how many bytes a second each store is restored at, none
+		// when zero
+		Rate int64
+		// Concurrency is how many stores are restored at once, one after the
+		// other when zero or one
+		Concurrency int
+		// Adaptive starts at half of Concurrency, or of every store when that
+		// is not set, and adapts how many stores are restored at once to the
+		// throughput they reach
+		Adaptive bool
+	}
+
+	// BandwidthLimits cap the bandwidth of the transfers of the elastic
+	// runtime stores, backups and restores alike, however many run at once
+	BandwidthLimits struct {
+		// Total caps, in bytes a second, all the transfers together, none
+		// when zero
+		Total int64
+		// Components caps the transfers of single components, e.g. nfs_server
+		Components map[string]int64
+	}
+)
+
+var byteRateUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+func ErrByteRate(rate string) error {
+	return fmt.Errorf(ErrByteRateFormat, rate)
+}
+
+func ErrBandwidthLimit(limit string) error {
+	return fmt.Errorf(ErrBandwidthLimitFormat, limit)
+}
+
+// ParseBandwidthLimits reads the total rate, and the csv list of component
+// rates such as 'nfs_server=20MB, ccdb=5MB', in the units of ParseByteRate
+func ParseBandwidthLimits(total, components string) (limits BandwidthLimits, err error) {
+	if limits.Total, err = ParseByteRate(total); err != nil {
+		return
+	}
+
+	for _, limit := range strings.Split(components, ",") {
+		var rate int64
+
+		if limit = strings.TrimSpace(limit); limit == "" {
+			continue
+		}
+		parts := strings.SplitN(limit, "=", 2)
+		component := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		if len(parts) != 2 || component == "" {
+			return BandwidthLimits{}, ErrBandwidthLimit(limit)
+		}
+
+		if rate, err = ParseByteRate(parts[1]); err != nil {
+			return BandwidthLimits{}, err
+		}
+
+		if limits.Components == nil {
+			limits.Components = make(map[string]int64)
+		}
+		limits.Components[component] = rate
+	}
+	return
+}
+
+// ParseByteRate reads a rate of bytes a second such as 50MB, 512KB/s or
+// 1048576, in binary units. An empty rate is no limit
+func ParseByteRate(rate string) (bytes int64, err error) {
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(rate)), "/S")
+	multiplier := int64(1)
+
+	if value == "" {
+		return
+	}
+
+	for _, unit := range byteRateUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.bytes
+			break
+		}
+	}
+
+	if bytes, err = strconv.ParseInt(value, 10, 64); err != nil || bytes < 0 {
+		return 0, ErrByteRate(rate)
+	}
+	return bytes * multiplier, nil
+}
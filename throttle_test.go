@@ -0,0 +1,54 @@
+package cfops_test
+
+import (
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseBandwidthLimits", func() {
+	It("should read the total and the rate of each component", func() {
+		limits, err := ParseBandwidthLimits("100MB", "nfs_server=20MB, CCDB = 5MB/s")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(limits.Total).Should(Equal(int64(100 << 20)))
+		Ω(limits.Components).Should(Equal(map[string]int64{"nfs_server": 20 << 20, "ccdb": 5 << 20}))
+	})
+
+	It("should be no limit when nothing is given", func() {
+		limits, err := ParseBandwidthLimits("", "")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(limits).Should(Equal(BandwidthLimits{}))
+	})
+
+	It("should refuse a component limit without a rate", func() {
+		_, err := ParseBandwidthLimits("", "nfs_server")
+		Ω(err).Should(MatchError(ErrBandwidthLimit("nfs_server")))
+		_, err = ParseBandwidthLimits("", "nfs_server=fast")
+		Ω(err).Should(MatchError(ErrByteRate("fast")))
+	})
+})
+
+var _ = Describe("ParseByteRate", func() {
+	It("should read rates in binary units, with or without a unit of time", func() {
+		for rate, bytes := range map[string]int64{
+			"":          0,
+			"1048576":   1 << 20,
+			"50MB":      50 << 20,
+			"512kb/s":   512 << 10,
+			"1G":        1 << 30,
+			" 20 MB/s ": 20 << 20,
+		} {
+			parsed, err := ParseByteRate(rate)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(parsed).Should(Equal(bytes), rate)
+		}
+	})
+
+	It("should refuse what is not a rate", func() {
+		for _, rate := range []string{"fast", "50TB", "-5MB", "1.5MB"} {
+			_, err := ParseByteRate(rate)
+			Ω(err).Should(MatchError(ErrByteRate(rate)))
+		}
+	})
+})
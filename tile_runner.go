@@ -1,21 +1,26 @@
 package cfops
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/pivotalservices/cfbackup"
 	"github.com/xchapter7x/lo"
 )
 
 const (
-	ErrUnsupportedTileFormat = "you have a unsupported tile in your list: %s"
-	loggerName               = "cfops"
-	Restore                  = "restore"
-	Backup                   = "backup"
-	OpsMgr                   = "OPSMANAGER"
-	ER                       = "ER"
+	ErrUnsupportedTileFormat  = "you have a unsupported tile in your list: %s"
+	ErrUnknownComponentFormat = "tile %s has no component named: %s"
+	loggerName                = "cfops"
+	Restore                   = "restore"
+	Backup                    = "backup"
+	OpsMgr                    = "OPSMANAGER"
+	ER                        = "ER"
+	PKS                       = "PKS"
 )
 
 var (
@@ -30,6 +35,10 @@ func ErrUnsupportedTile(errString string) error {
 	return fmt.Errorf(ErrUnsupportedTileFormat, errString)
 }
 
+func ErrUnknownComponent(tileName, component string) error {
+	return fmt.Errorf(ErrUnknownComponentFormat, tileName, component)
+}
+
 type Tile interface {
 	Backup() error
 	Restore() error
@@ -47,6 +56,55 @@ type flagSet interface {
 	OpsManagerPass() string
 	Dest() string
 	Tilelist() string
+	Catalog() string
+	CleanupOnFailure() bool
+	SkipUnreachable() bool
+	Overwrite() bool
+	RestartRestore() bool
+	LockDir() string
+	CheckpointDir() string
+	MetricsFile() string
+	PushGateway() string
+	StatsdAddress() string
+	CloudWatch() CloudWatchConfig
+	SMTP() SMTPConfig
+	PagerDuty() PagerDutyConfig
+	AuditLog() string
+	BreakLock() bool
+	Components() string
+	ConsistencyWindow() time.Duration
+	RestoreLimits() RestoreLimits
+	Bandwidth() BandwidthLimits
+	ExtractConcurrency() int
+	TransferSegments() int
+	MetadataCache() MetadataCacheConfig
+	Archive() bool
+	Registry() RegistryConfig
+	Restic() ResticConfig
+	BBRArtifact() string
+	Remap() string
+	BlobstoreCategories() string
+	BlobstoreMirror() string
+	TargetVersion() string
+	Binlogs() BinlogConfig
+	ShipLogs() bool
+	Diagnostics() bool
+	AsyncExport() AsyncExportConfig
+	Reauthenticate() (user, pass string, err error)
+	DeploymentManifests() DeploymentManifestsConfig
+	ApplyChanges() ApplyChangesConfig
+	HealthCheck() HealthCheckConfig
+	SmokeTests() SmokeTestConfig
+	Quiesce() QuiesceConfig
+	Snapshots() SnapshotConfig
+	PKS() PKSConfig
+	Heartbeat() time.Duration
+	Tracing() TracingConfig
+	Retry() RetryConfig
+	IdempotencyKey() string
+	// RunID is the id given to a new run, e.g. the one its versioned
+	// destination is named after, or empty to generate one
+	RunID() string
 }
 
 func formatArray(a []string) []string {
@@ -57,18 +115,44 @@ func formatArray(a []string) []string {
 }
 
 func SetupSupportedTiles(fs flagSet) {
+	// every elastic runtime of the run shares the one limiter
+	bandwidth := cfbackup.NewTransferLimiter(fs.Bandwidth().Total, fs.Bandwidth().Components)
+	metadata := openMetadataCache(fs)
+	SetCredentialSource(fs.Host(), fs.Reauthenticate)
 	SupportedTiles = map[string]func() (Tile, error){
 		OpsMgr: func() (opsmgr Tile, err error) {
-			opsmgr, err = cfbackup.NewOpsManager(fs.Host(), fs.AdminUser(), fs.AdminPass(), fs.OpsManagerUser(), fs.OpsManagerPass(), fs.Dest())
+			var opsManager *cfbackup.OpsManager
+
+			if opsManager, err = cfbackup.NewOpsManager(fs.Host(), fs.AdminUser(), fs.AdminPass(), fs.OpsManagerUser(), fs.OpsManagerPass(), fs.Dest()); err == nil {
+				opsManager.AsyncExport = cfbackup.AsyncExport(fs.AsyncExport())
+				opsManager.Reauthenticate = fs.Reauthenticate
+				opsmgr = opsManager
+			}
 			lo.G.Debug("Creating a new OpsManager object")
 			return
 		},
 		ER: func() (er Tile, err error) {
 			installationFilePath := path.Join(fs.Dest(), cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME)
-			er = cfbackup.NewElasticRuntime(installationFilePath, fs.Dest())
+			elasticRuntime := cfbackup.NewElasticRuntime(installationFilePath, fs.Dest())
+			elasticRuntime.ConsistencyWindow = fs.ConsistencyWindow()
+			elasticRuntime.RestoreRate = fs.RestoreLimits().Rate
+			elasticRuntime.RestoreConcurrency = fs.RestoreLimits().Concurrency
+			elasticRuntime.AdaptiveConcurrency = fs.RestoreLimits().Adaptive
+			elasticRuntime.BlobstoreMirror = fs.BlobstoreMirror()
+			elasticRuntime.Bandwidth = bandwidth
+			elasticRuntime.TransferSegments = fs.TransferSegments()
+			elasticRuntime.SkipUnreachable = fs.SkipUnreachable()
+
+			if metadata != nil {
+				elasticRuntime.Metadata = metadata
+			}
+			er = elasticRuntime
 			lo.G.Debug("Creating a new ElasticRuntime object")
 			return
 		},
+		PKS: func() (Tile, error) {
+			return NewPKSTile(fs.PKS(), fs.Dest()), nil
+		},
 	}
 }
 
@@ -100,31 +184,645 @@ func getSupportedTile(tilename string) (tile Tile, err error) {
 	return
 }
 
-func runTileListUsingAction(fs flagSet, action string) (err error) {
-	tiles := formatArray(strings.Split(fs.Tilelist(), ","))
+// pipelineRun carries the state of a single invocation through the tiles it runs
+type pipelineRun struct {
+	ctx        context.Context
+	fs         flagSet
+	action     string
+	entry      *CatalogEntry
+	checkpoint *RestoreCheckpoint
+	// phases times the tile in progress
+	phases *phaseTimer
+	// unreachable are the stores a backup of the tile in progress left out
+	unreachable []string
+	notifiers   []namedNotifier
+}
+
+func (s *pipelineRun) runTile(tileName string) (err error) {
+	var (
+		tile   Tile
+		failed = &failedStep{}
+	)
+
+	if s.checkpoint != nil && s.checkpoint.Completed(tileName) {
+		lo.G.Info("Skipping completed step " + tileName)
+		return
+	}
+	task := StartTask(tileName)
+	defer func() { task.Finish(err) }()
+
+	if tileName != ER {
+		finishStep := s.checkpoint.StartStep(tileName)
+		defer func() { finishStep(err) }()
+	}
+
+	if tile, err = getSupportedTile(tileName); err != nil {
+		return
+	}
+
+	er, isElasticRuntime := tile.(*cfbackup.ElasticRuntime)
+
+	if isElasticRuntime {
+		er.Tracker = taskTracker{tileName: tileName, phases: s.phases, heartbeat: s.fs.Heartbeat(), progress: s.checkpoint, failed: failed}
 
-	for _, tileName := range tiles {
-		var tile Tile
+		if s.checkpoint != nil {
+			er.Checkpoint = s.checkpoint.Scope(tileName)
+		}
 
-		if tile, err = getSupportedTile(tileName); err == nil {
-			err = runTileUsingAction(tile, action)
+		if err = selectComponents(er, tileName, s.fs.Components()); err != nil {
+			return
+		}
+
+		if err = checkBandwidthComponents(er, tileName, s.fs.Bandwidth()); err != nil {
+			return
+		}
+	}
+	dump, snapshotted := true, false
+
+	if isElasticRuntime && s.action == Backup && s.fs.Snapshots().Strategy(tileName) == StrategySnapshot {
+		if dump, err = s.snapshotStores(er, tileName); err != nil {
+			return
 		}
+		snapshotted = true
+	}
+
+	if dump {
+		if err = runTileUsingAction(tile, s.action); err != nil {
+			step := failed.step()
+			return tileError(tileName, s.stepHost(er, step), step, err)
+		}
+	}
+
+	if isElasticRuntime {
+		s.unreachable = er.Unreachable
+	}
+
+	// a dump is only good once it is known not to be truncated
+	if components, dumped := reachedComponents(er, s.fs.Components(), snapshotted); isElasticRuntime && s.action == Backup && dumped {
+		started := time.Now()
+		err = tileError(tileName, "", PhaseVerify, ValidateDumps(s.fs.Dest(), components))
+		s.phases.add(PhaseVerify, time.Since(started))
+	}
+
+	// a tile restricted to some of its components, or missing those it could
+	// not reach, has not completed as a whole
+	if err == nil && s.checkpoint != nil && s.fs.Components() == "" && len(s.unreachable) == 0 {
+		err = s.checkpoint.MarkCompleted(tileName)
+	}
+	return
+}
+
+// reachedComponents are the csv list of components a backup of the tile
+// dumped: the selected components less those it could not reach, and less
+// those it snapshotted. It did not dump any when it could reach none
+func reachedComponents(er *cfbackup.ElasticRuntime, components string, snapshotted bool) (reached string, dumped bool) {
+	if er == nil || (len(er.Unreachable) == 0 && !snapshotted) {
+		return components, true
+	}
+	var list []string
+
+	for _, system := range er.PersistentSystems {
+		component := system.Get(cfbackup.SD_COMPONENT)
+		left := false
+
+		for _, unreachable := range er.Unreachable {
+			left = left || unreachable == component
+		}
+
+		if !left {
+			list = append(list, component)
+		}
+	}
+	return strings.Join(list, ","), len(list) > 0
+}
+
+// stepHost is the host a step of the tile talks to: the vm of the store the
+// step transfers, or else ops manager
+func (s *pipelineRun) stepHost(er *cfbackup.ElasticRuntime, step string) string {
+	component := strings.Split(step, stepSeparator)[0]
+
+	if er != nil && component != "" {
+		for _, system := range er.PersistentSystems {
+			if system.Get(cfbackup.SD_COMPONENT) == component && system.Get(cfbackup.SD_IP) != "" {
+				return system.Get(cfbackup.SD_IP)
+			}
+		}
+	}
+	return s.fs.Host()
+}
+
+// selectComponents restricts the persistence stores of the tile to the csv
+// list of components, leaving every other artifact in the destination untouched
+func selectComponents(er *cfbackup.ElasticRuntime, tileName, components string) (err error) {
+	var selected []cfbackup.SystemDump
+
+	if components == "" {
+		return
+	}
+
+	for _, component := range strings.Split(components, ",") {
+		var found bool
+		component = strings.ToLower(strings.TrimSpace(component))
+
+		for _, system := range er.PersistentSystems {
+			if system.Get(cfbackup.SD_COMPONENT) == component {
+				selected = append(selected, system)
+				found = true
+			}
+		}
+
+		if !found {
+			return ErrUnknownComponent(tileName, component)
+		}
+	}
+	er.PersistentSystems = selected
+	return
+}
+
+// checkBandwidthComponents refuses a bandwidth limit of a component the tile
+// does not have, which would otherwise limit nothing
+func checkBandwidthComponents(er *cfbackup.ElasticRuntime, tileName string, limits BandwidthLimits) error {
+	for component := range limits.Components {
+		var found bool
+
+		for _, system := range er.PersistentSystems {
+			found = found || system.Get(cfbackup.SD_COMPONENT) == component
+		}
+
+		if !found {
+			return ErrUnknownComponent(tileName, component)
+		}
+	}
+	return nil
+}
+
+func runTileListUsingAction(run *pipelineRun) (err error) {
+	tiles := formatArray(strings.Split(run.fs.Tilelist(), ","))
+
+	for i, tileName := range tiles {
+		if run.ctx.Err() != nil {
+			err = ErrAborted
+
+			for _, skipped := range tiles[i:] {
+				run.entry.Skip(skipped)
+			}
+			break
+		}
+		started := time.Now()
+		run.phases, run.unreachable = &phaseTimer{}, nil
+		err = run.runTile(tileName)
+		run.entry.Add(ComponentResult{
+			Name:        tileName,
+			Seconds:     time.Since(started).Seconds(),
+			Bytes:       artifactBytes(run.fs.Dest(), tileName, run.fs.Components()),
+			Phases:      run.phases.list(),
+			Unreachable: run.unreachable,
+		}, err)
+		run.notifyCompleted()
 
 		if err != nil {
+			for _, skipped := range tiles[i+1:] {
+				run.entry.Skip(skipped)
+			}
 			break
 		}
 	}
 	return
 }
 
-func RunPipeline(fs flagSet, action string) (err error) {
+func runPipelineSet(run *pipelineRun) (err error) {
+	fs := run.fs
 
 	if hasTilelistFlag(fs) {
 		lo.G.Debug("Running a tile list action")
-		err = runTileListUsingAction(fs, action)
+		err = runTileListUsingAction(run)
 
 	} else {
-		err = BuiltinPipelineExecution[action](fs.Host(), fs.AdminUser(), fs.AdminPass(), fs.OpsManagerUser(), fs.OpsManagerPass(), fs.Dest())
+		task := StartTask(AllTiles)
+		started := time.Now()
+		err = tileError(AllTiles, fs.Host(), "", BuiltinPipelineExecution[run.action](fs.Host(), fs.AdminUser(), fs.AdminPass(), fs.OpsManagerUser(), fs.OpsManagerPass(), fs.Dest()))
+		task.Finish(err)
+		run.entry.Add(ComponentResult{
+			Name:    AllTiles,
+			Seconds: time.Since(started).Seconds(),
+			Bytes:   artifactBytes(fs.Dest(), AllTiles, ""),
+		}, err)
+		run.notifyCompleted()
+	}
+	return
+}
+
+// openRestoreCheckpoint loads the progress of an earlier interrupted restore
+// of the destination, discarding it when a restart was requested
+func openRestoreCheckpoint(fs flagSet) (checkpoint *RestoreCheckpoint, err error) {
+	if checkpoint, err = OpenCheckpoint(fs.Dest()); err == nil {
+		err = describeRun(checkpoint, fs, Restore)
+	}
+	return
+}
+
+// openBackupCheckpoint loads what an earlier interrupted backup of the
+// foundation into the destination completed, discarding it, along with the
+// manifest of a partial backup, when a restart was requested
+func openBackupCheckpoint(fs flagSet) (checkpoint *RestoreCheckpoint, err error) {
+	if checkpoint, err = OpenBackupCheckpoint(fs.Dest(), fs.Host()); err != nil {
+		return
+	}
+	partial := checkpoint.RunID
+
+	if err = describeRun(checkpoint, fs, Backup); err == nil && fs.RestartRestore() && partial != "" && DestinationRun(fs.Dest()) == partial {
+		os.Remove(path.Join(fs.Dest(), ManifestName))
+	}
+	return
+}
+
+// describeRun records the run in its checkpoint for cfops status and resume,
+// keeping a copy of it locally, and discards the progress of an earlier run
+// when a restart was requested
+func describeRun(checkpoint *RestoreCheckpoint, fs flagSet, action string) (err error) {
+	checkpoint.Action, checkpoint.Foundation, checkpoint.Destination = action, fs.Host(), fs.Dest()
+	checkpoint.Tilelist, checkpoint.Components = fs.Tilelist(), fs.Components()
+
+	if fs.CheckpointDir() != "" {
+		err = checkpoint.keepLocally(LocalCheckpointPath(fs.CheckpointDir(), fs.Host(), action))
+	}
+
+	if err == nil && fs.RestartRestore() {
+		err = checkpoint.Remove()
+	}
+	return
+}
+
+// RunPipeline runs the action over the tiles as a single set, recording the
+// outcome in the catalog when one is configured. A failed backup set can
+// optionally have its partial artifacts removed, and backups and restores
+// resume from the last completed step of an interrupted run. Only one run may
+// hold a foundation and its destination at a time, and every run is recorded
+// in the audit log
+func RunPipeline(fs flagSet, action string) (err error) {
+	return RunPipelineContext(context.Background(), fs, action)
+}
+
+// RunPipelineContext is RunPipeline, stopping as soon as the context is
+// cancelled: no further tiles are started, in-flight remote operations are
+// aborted and the set is recorded as aborted
+func RunPipelineContext(ctx context.Context, fs flagSet, action string) (err error) {
+	_, err = RunPipelineResult(ctx, fs, action)
+	return
+}
+
+// RunPipelineResult is RunPipelineContext, also returning the catalog entry
+// recording the run. A run given an idempotency key that already completed
+// is not repeated, its entry is returned instead
+func RunPipelineResult(ctx context.Context, fs flagSet, action string) (entry *CatalogEntry, err error) {
+	var (
+		catalog    *Catalog
+		lock       *RunLock
+		runLog     *runLog
+		done       bool
+		migrations []string
+		manifest   *Manifest
+		quiesceErr error
+		healthErr  error
+		resumeErr  error
+		run        = &pipelineRun{ctx: ctx, fs: fs, action: action, entry: NewCatalogEntry(action, fs.Dest())}
+	)
+	run.entry.IdempotencyKey = fs.IdempotencyKey()
+	defer useRetryPolicy(fs.Retry())()
+	defer func() { err = withHint(err) }()
+	defer func() { entry = run.entry }()
+
+	defer func() {
+		if auditErr := auditRun(fs, action, run.entry, err); err == nil {
+			err = auditErr
+		}
+	}()
+
+	if lock, err = AcquireLock(fs.LockDir(), fs.Host(), fs.Dest(), action, fs.BreakLock()); err != nil {
+		return
+	}
+	defer lock.Release()
+
+	if action == Restore {
+		if err = importBBRForRestore(fs); err != nil {
+			return
+		}
+	}
+
+	if action == Restore && IsLegacyLayout(fs.Dest()) {
+		return nil, ErrLegacyLayout(fs.Dest())
+	}
+
+	if action == Restore {
+		if err = checkPointInTime(fs); err != nil {
+			return
+		}
+	}
+
+	if action == Restore && fs.Restic().Enabled() {
+		if err = ResticRestore(fs.Restic(), fs.Host(), fs.Dest(), restoreArtifacts(fs.Tilelist(), fs.Components())); err != nil {
+			return
+		}
+	}
+
+	if action == Restore {
+		var removeExtracted func()
+
+		if removeExtracted, err = extractForRestore(fs); err != nil {
+			return
+		}
+		defer removeExtracted()
+	}
+
+	if action == Restore {
+		if err = checkPartialBackup(fs); err == nil {
+			err = checkSnapshotBackup(fs)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	if action == Restore {
+		var restoreUnmigrated func()
+
+		if migrations, restoreUnmigrated, err = migrateForRestore(fs); err != nil {
+			return
+		}
+		defer restoreUnmigrated()
+	}
+
+	if action == Restore {
+		var restoreOriginals func()
+
+		if restoreOriginals, err = remapForRestore(fs); err != nil {
+			return
+		}
+		defer restoreOriginals()
+	}
+
+	if action == Restore {
+		var restoreBlobstore func()
+
+		if restoreBlobstore, err = filterBlobstoreForRestore(fs); err != nil {
+			return
+		}
+		defer restoreBlobstore()
+	}
+
+	if action == Restore && hasTilelistFlag(fs) {
+		var steps []RestoreStep
+
+		if run.checkpoint, err = openRestoreCheckpoint(fs); err != nil {
+			return
+		}
+
+		if steps, err = restoreSteps(fs); err != nil {
+			return
+		}
+
+		if err = run.checkpoint.PlanSteps(steps); err != nil {
+			return
+		}
+	}
+
+	if action == Backup && hasTilelistFlag(fs) {
+		if run.checkpoint, err = openBackupCheckpoint(fs); err != nil {
+			return
+		}
+	}
+
+	// a backup resuming an interrupted one carries on its run
+	resumed := ""
+
+	if action == Backup && run.checkpoint != nil && len(run.checkpoint.Steps) > 0 && fs.IdempotencyKey() == "" {
+		resumed = run.checkpoint.RunID
+	}
+
+	if fs.Catalog() != "" {
+		if catalog, err = OpenCatalog(fs.Catalog()); err != nil {
+			return
+		}
+
+		if resumed != "" {
+			run.entry = catalog.Continue(action, fs.Dest(), resumed)
+		} else if run.entry, done = catalog.BeginKeyed(action, fs.Dest(), fs.IdempotencyKey()); done {
+			lo.G.Info("%s %s already completed as run %s", action, fs.IdempotencyKey(), run.entry.ID)
+			return
+		}
+	} else if resumed != "" {
+		run.entry.ID = resumed
+	}
+
+	if resumed == "" && fs.IdempotencyKey() == "" && fs.RunID() != "" {
+		run.entry.ID = fs.RunID()
+	}
+
+	if action == Backup && !fs.Overwrite() {
+		if owner := interruptedRun(fs.Dest()); owner != "" && owner != run.entry.ID {
+			return nil, ErrAnotherRun(fs.Dest(), owner)
+		}
+	}
+
+	if catalog != nil {
+		if err = catalog.Save(); err != nil {
+			return
+		}
+	}
+
+	if run.checkpoint != nil {
+		run.checkpoint.RunID = run.entry.ID
+	}
+	run.entry.Foundation = fs.Host()
+	run.entry.Migrations = migrations
+	SetRunID(run.entry.ID)
+	stopWarnings := collectWarnings(run.entry.ID)
+	traceRoot, stopTracing := startTrace(fs.Tracing(), action, run.entry.ID)
+
+	if action == Backup {
+		for _, stale := range []string{ManifestName, RunLogName, DiagnosticsName, SummaryName} {
+			os.Remove(path.Join(fs.Dest(), stale))
+		}
+		os.RemoveAll(path.Join(fs.Dest(), DeploymentManifestsDir))
+	}
+
+	if action == Backup {
+		recordBinlogCoordinates(fs.Binlogs())
+	}
+
+	if action == Backup && fs.ShipLogs() {
+		runLog = startRunLog()
+	}
+	publishEvent(Event{Type: EventRunStarted, Message: action})
+	run.notifiers = runNotifiers(fs)
+	run.notify(Notification{Type: NotifyRunStarted})
+	resume := func() error { return nil }
+	transfersBefore := readTransferStats()
+
+	if action == Backup && fs.HealthCheck().Enabled {
+		run.entry.Health, healthErr = CheckBackupHealth(fs.HealthCheck())
+	}
+
+	if action == Backup && fs.Quiesce().Enabled && healthErr == nil {
+		run.entry.Quiesced, resume, quiesceErr = Quiesce(fs.Quiesce())
+	}
+	stopAborting := abortOnCancel(ctx)
+	stopFollowing := func() {}
+
+	if run.checkpoint != nil {
+		stopFollowing = run.checkpoint.follow()
+	}
+
+	if err = firstError(healthErr, quiesceErr); err == nil {
+		err = runPipelineSet(run)
+	}
+	stopFollowing()
+	stopAborting()
+	resumeErr = resume()
+	run.entry.Finish()
+	run.entry.Transfer = NewTransferStats(transfersBefore, readTransferStats())
+
+	if healthErr != nil || quiesceErr != nil {
+		run.entry.Status = SetIncomplete
+	}
+
+	if ctx.Err() != nil {
+		run.entry.Status = SetAborted
+		err = ErrAborted
+	}
+
+	if !run.entry.Succeeded() && action == Backup && fs.CleanupOnFailure() {
+		run.entry.ArtifactsRemoved = removeSetArtifacts(fs.Dest(), run.entry)
+
+		// there is nothing left to resume
+		if run.checkpoint != nil {
+			run.checkpoint.Remove()
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup && fs.Diagnostics() {
+		if _, diagnosticsErr := CaptureDiagnostics(fs.Host(), fs.AdminUser(), fs.AdminPass(), fs.Dest()); diagnosticsErr != nil {
+			warn("unable to capture the diagnostics of %s: %s", fs.Host(), diagnosticsErr)
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup && fs.DeploymentManifests().Enabled {
+		var manifestsErr error
+
+		if run.entry.DeploymentManifests, manifestsErr = CaptureDeploymentManifests(fs.DeploymentManifests(), fs.Dest()); manifestsErr != nil {
+			warn("unable to capture the deployment manifests: %s", manifestsErr)
+		}
+	}
+
+	if runLog != nil {
+		runLog.stop()
+
+		if shipErr := shipRunLog(fs, runLog); shipErr != nil {
+			warn("unable to ship the run log: %s", shipErr)
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup {
+		var written Manifest
+
+		if written, err = writeBackupManifest(fs.Dest(), run.entry); err == nil {
+			manifest = &written
+		} else {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup && fs.Binlogs().Enabled() {
+		if run.entry.Binlogs, err = CaptureBinlogs(fs.Binlogs()); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup && fs.Registry().Enabled() {
+		if run.entry.Registry, err = PushBackup(fs.Registry(), fs.Dest(), run.entry); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup && fs.Restic().Enabled() {
+		if run.entry.ResticSnapshot, err = ResticBackup(fs.Restic(), fs.Host(), fs.Dest(), run.entry); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Succeeded() && action == Backup && fs.Archive() {
+		if err = ArchiveBackup(fs.Dest(), run.entry); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Status == SetComplete && action == Restore {
+		if run.entry.RecoveredTo, err = replayForRestore(fs); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Status == SetComplete && action == Restore && fs.ApplyChanges().Enabled {
+		if run.entry.ApplyChanges, err = ApplyChanges(ctx, fs.ApplyChanges(), fs.Host(), fs.AdminUser(), fs.AdminPass()); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+
+		if ctx.Err() != nil {
+			run.entry.Status = SetAborted
+			err = ErrAborted
+		}
+	}
+
+	if run.entry.Status == SetComplete && action == Restore && fs.HealthCheck().Enabled {
+		if run.entry.Health, err = CheckHealth(fs.HealthCheck()); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Status == SetComplete && action == Restore && fs.SmokeTests().Enabled {
+		if run.entry.SmokeTests, err = RunSmokeTests(fs.SmokeTests()); err != nil {
+			run.entry.Status = SetIncomplete
+		}
+	}
+
+	if run.entry.Status == SetComplete && run.checkpoint != nil {
+		err = run.checkpoint.Remove()
+	}
+
+	if resumeErr != nil && err == nil {
+		// the backup itself is sound, but the foundation is left quiesced
+		err = resumeErr
+	}
+
+	if catalog != nil {
+		if saveErr := catalog.Save(); err == nil {
+			err = saveErr
+		}
+	}
+	logSummary(run.entry)
+
+	if summaryErr := writeRunSummary(fs.Dest(), run.entry, manifest, stopWarnings()); summaryErr != nil {
+		warn("unable to write the run summary: %s", summaryErr)
+	}
+	publishEvent(Event{Type: EventRunFinished, Message: run.entry.Status})
+	publishMetrics(fs, run.entry, catalog)
+	run.notifyFinished()
+	stopTracing()
+	finishTrace(fs.Tracing(), fs.Host(), traceRoot, run.entry)
+	return
+}
+
+func removeSetArtifacts(destination string, entry *CatalogEntry) (removed bool) {
+	removed = true
+
+	for _, artifact := range setArtifacts(entry) {
+		lo.G.Debug("Removing partial artifact " + artifact)
+
+		if err := os.Remove(path.Join(destination, artifact)); err != nil && !os.IsNotExist(err) {
+			warn("unable to remove partial artifact: %s", err)
+			removed = false
+		}
 	}
 	return
 }
@@ -1,6 +1,14 @@
 package cfops_test
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pivotalservices/cfbackup"
 	. "github.com/pivotalservices/cfops"
 
 	. "github.com/onsi/ginkgo"
@@ -16,6 +24,66 @@ var _ = Describe("Tile Runner", func() {
 				}).ShouldNot(Panic())
 			})
 		})
+
+		Context("when a consistency window is given", func() {
+			It("should hand it to the elastic runtime tile", func() {
+				SetupSupportedTiles(&mockFlagSet{window: 5 * time.Minute})
+				tile, _ := SupportedTiles[ER]()
+				Ω(tile.(*cfbackup.ElasticRuntime).ConsistencyWindow).Should(Equal(5 * time.Minute))
+			})
+		})
+
+		Context("when restore limits are given", func() {
+			It("should hand them to the elastic runtime tile", func() {
+				SetupSupportedTiles(&mockFlagSet{limits: RestoreLimits{Rate: 50 << 20, Concurrency: 2, Adaptive: true}})
+				tile, _ := SupportedTiles[ER]()
+				Ω(tile.(*cfbackup.ElasticRuntime).RestoreRate).Should(Equal(int64(50 << 20)))
+				Ω(tile.(*cfbackup.ElasticRuntime).RestoreConcurrency).Should(Equal(2))
+				Ω(tile.(*cfbackup.ElasticRuntime).AdaptiveConcurrency).Should(BeTrue())
+			})
+		})
+
+		Context("when bandwidth limits are given", func() {
+			It("should share one limiter between the elastic runtime tiles of the run", func() {
+				SetupSupportedTiles(&mockFlagSet{bandwidth: BandwidthLimits{Total: 100 << 20}})
+				first, _ := SupportedTiles[ER]()
+				second, _ := SupportedTiles[ER]()
+				Ω(first.(*cfbackup.ElasticRuntime).Bandwidth).ShouldNot(BeNil())
+				Ω(first.(*cfbackup.ElasticRuntime).Bandwidth == second.(*cfbackup.ElasticRuntime).Bandwidth).Should(BeTrue())
+			})
+		})
+
+		Context("when transfer segments are given", func() {
+			It("should hand them to the elastic runtime tile", func() {
+				SetupSupportedTiles(&mockFlagSet{segments: 4})
+				tile, _ := SupportedTiles[ER]()
+				Ω(tile.(*cfbackup.ElasticRuntime).TransferSegments).Should(Equal(4))
+			})
+		})
+
+		Context("when a metadata cache is given", func() {
+			It("should hand it to the elastic runtime tile", func() {
+				dir, _ := ioutil.TempDir("", "metadata")
+				defer os.RemoveAll(dir)
+				SetupSupportedTiles(&mockFlagSet{metadata: MetadataCacheConfig{Path: path.Join(dir, "metadata.cache")}})
+				tile, _ := SupportedTiles[ER]()
+				Ω(tile.(*cfbackup.ElasticRuntime).Metadata).ShouldNot(BeNil())
+			})
+
+			It("should leave the elastic runtime tile without one otherwise", func() {
+				SetupSupportedTiles(&mockFlagSet{})
+				tile, _ := SupportedTiles[ER]()
+				Ω(tile.(*cfbackup.ElasticRuntime).Metadata).Should(BeNil())
+			})
+		})
+
+		Context("when a blobstore mirror is given", func() {
+			It("should hand it to the elastic runtime tile", func() {
+				SetupSupportedTiles(&mockFlagSet{blobMirror: "/var/cfops/mirror"})
+				tile, _ := SupportedTiles[ER]()
+				Ω(tile.(*cfbackup.ElasticRuntime).BlobstoreMirror).Should(Equal("/var/cfops/mirror"))
+			})
+		})
 	})
 
 	Describe("RunPipeline", func() {
@@ -35,4 +103,240 @@ var _ = Describe("Tile Runner", func() {
 		})
 
 	})
+
+	Describe("RunPipeline as a backup set", func() {
+		var (
+			dir string
+			fs  *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "set")
+			writeArtifacts(dir, BackupArtifacts[OpsMgr])
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+				ER: func() (Tile, error) {
+					return &mockTile{ErrReturned: errors.New("er failed")}, nil
+				},
+			}
+			fs = &mockFlagSet{
+				tileListFlag: "opsmanager, er",
+				dest:         dir,
+				catalog:      path.Join(dir, "catalog.json"),
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		Context("when a component fails", func() {
+			It("should record the set as incomplete in the catalog", func() {
+				Ω(RunPipeline(fs, Backup)).ShouldNot(BeNil())
+				catalog, _ := OpenCatalog(fs.catalog)
+				Ω(catalog.Entries).Should(HaveLen(1))
+				Ω(catalog.Entries[0].Status).Should(Equal(SetIncomplete))
+				Ω(catalog.Entries[0].Components[0].Status).Should(Equal(ComponentSucceeded))
+				Ω(catalog.Entries[0].Components[1].Status).Should(Equal(ComponentFailed))
+			})
+
+			It("should keep the partial artifacts by default", func() {
+				RunPipeline(fs, Backup)
+				Ω(Verify(dir, []string{OpsMgr})).Should(BeNil())
+			})
+
+			It("should remove the partial artifacts when asked to", func() {
+				fs.cleanup = true
+				RunPipeline(fs, Backup)
+				Ω(Verify(dir, []string{OpsMgr})).ShouldNot(BeNil())
+				catalog, _ := OpenCatalog(fs.catalog)
+				Ω(catalog.Entries[0].ArtifactsRemoved).Should(BeTrue())
+			})
+
+			It("should never be selected as the latest backup", func() {
+				RunPipeline(fs, Backup)
+				_, err := LatestBackup(fs.catalog)
+				Ω(err).Should(Equal(ErrNoCompleteBackup))
+			})
+		})
+
+		Context("when every component succeeds", func() {
+			BeforeEach(func() {
+				fs.tileListFlag = "opsmanager"
+			})
+
+			It("should record a complete set that restore latest selects", func() {
+				Ω(RunPipeline(fs, Backup)).Should(BeNil())
+				Ω(LatestBackup(fs.catalog)).Should(Equal(dir))
+			})
+		})
+	})
+
+	Describe("RunPipeline restricted to components", func() {
+		var fs *mockFlagSet
+
+		BeforeEach(func() {
+			SupportedTiles = map[string]func() (Tile, error){
+				ER: func() (Tile, error) {
+					return cfbackup.NewElasticRuntime("installation.json", "."), nil
+				},
+			}
+			fs = &mockFlagSet{
+				tileListFlag: "er",
+				components:   "ccdb, nosuchdb",
+				dest:         tempDest(),
+			}
+		})
+
+		Context("when a component is not part of the tile", func() {
+			It("should fail before touching the foundation", func() {
+				Ω(RunPipeline(fs, Restore)).Should(Equal(ErrUnknownComponent(ER, "nosuchdb")))
+			})
+		})
+
+		Context("when a bandwidth limit names a component that is not part of the tile", func() {
+			It("should fail before touching the foundation", func() {
+				fs.components = ""
+				fs.bandwidth = BandwidthLimits{Components: map[string]int64{"nosuchdb": 1 << 20}}
+				Ω(RunPipeline(fs, Backup)).Should(Equal(ErrUnknownComponent(ER, "nosuchdb")))
+			})
+		})
+	})
+
+	Describe("RunPipeline restoring a partial backup", func() {
+		var fs *mockFlagSet
+
+		BeforeEach(func() {
+			dir, _ := ioutil.TempDir("", "partial")
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+				ER: func() (Tile, error) {
+					return &mockTile{}, nil
+				},
+			}
+			WriteManifest(dir, Manifest{FormatVersion: ManifestFormatVersion, Partial: true, Unreachable: []string{"ccdb"}})
+			fs = &mockFlagSet{tileListFlag: "opsmanager, er", dest: dir}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(fs.dest)
+		})
+
+		It("should refuse to restore every store of the elastic runtime", func() {
+			Ω(RunPipeline(fs, Restore)).Should(Equal(ErrPartialBackup(fs.dest, []string{"ccdb"})))
+		})
+
+		It("should restore the stores it has", func() {
+			fs.components = "uaadb"
+			Ω(RunPipeline(fs, Restore)).Should(BeNil())
+		})
+
+		It("should restore ops manager alone", func() {
+			fs.tileListFlag = "opsmanager"
+			Ω(RunPipeline(fs, Restore)).Should(BeNil())
+		})
+
+		It("should read the manifest of a partial backup packed into an archive", func() {
+			Ω(ArchiveBackup(fs.dest, &CatalogEntry{Action: Backup})).Should(BeNil())
+			Ω(path.Join(fs.dest, ManifestName)).ShouldNot(BeAnExistingFile())
+			Ω(RunPipeline(fs, Restore)).Should(Equal(ErrPartialBackup(fs.dest, []string{"ccdb"})))
+		})
+
+		It("should refuse an archive without a manifest", func() {
+			os.Remove(path.Join(fs.dest, ManifestName))
+			Ω(ArchiveBackup(fs.dest, &CatalogEntry{Action: Backup})).Should(BeNil())
+			fs.tileListFlag = "opsmanager"
+			Ω(RunPipeline(fs, Restore)).Should(Equal(ErrArchiveEntry(ManifestName)))
+		})
+	})
+
+	Describe("RunPipeline into a destination holding the artifacts of another run", func() {
+		var (
+			dir  string
+			runs int
+			fs   *mockFlagSet
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "owner")
+			runs = 0
+			SupportedTiles = map[string]func() (Tile, error){
+				OpsMgr: func() (Tile, error) {
+					runs++
+					return &mockTile{}, nil
+				},
+				ER: func() (Tile, error) {
+					runs++
+					return &mockTile{}, nil
+				},
+			}
+			fs = &mockFlagSet{tileListFlag: "opsmanager", dest: dir, host: "opsman.prod", catalog: path.Join(dir, "catalog.json")}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should replace a complete backup of an earlier run", func() {
+			RunPipeline(fs, Backup)
+			second, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			Ω(runs).Should(Equal(2))
+			Ω(DestinationRun(dir)).Should(Equal(second.ID))
+		})
+
+		It("should refuse to write over the artifacts of an interrupted run", func() {
+			checkpoint, _ := OpenBackupCheckpoint(dir, "opsman.prod")
+			checkpoint.RunID = "20261015T020000Z-0badf00d"
+			checkpoint.MarkCompleted(OpsMgr)
+			fs.idempotency = "build-42"
+
+			Ω(RunPipeline(fs, Backup)).Should(Equal(ErrAnotherRun(dir, "20261015T020000Z-0badf00d")))
+			Ω(runs).Should(Equal(0))
+		})
+
+		It("should replace them when asked to overwrite", func() {
+			checkpoint, _ := OpenBackupCheckpoint(dir, "opsman.prod")
+			checkpoint.RunID = "20261015T020000Z-0badf00d"
+			checkpoint.MarkCompleted(OpsMgr)
+			fs.idempotency, fs.overwrite = "build-42", true
+
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			Ω(DestinationRun(dir)).Should(Equal(entry.ID))
+		})
+
+		It("should read the run of a backup packed into an archive", func() {
+			fs.archive = true
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			Ω(path.Join(dir, ManifestName)).ShouldNot(BeAnExistingFile())
+			Ω(DestinationRun(dir)).Should(Equal(entry.ID))
+		})
+
+		It("should give the run the id it was started with", func() {
+			fs.runID = "20261015T020000Z-1a2b3c4d"
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			Ω(entry.ID).Should(Equal("20261015T020000Z-1a2b3c4d"))
+			Ω(DestinationRun(dir)).Should(Equal(entry.ID))
+		})
+
+		It("should carry on the run of the partial backup it resumes", func() {
+			checkpoint, _ := OpenBackupCheckpoint(dir, "opsman.prod")
+			checkpoint.RunID = "20261015T020000Z-0badf00d"
+			checkpoint.MarkCompleted(OpsMgr)
+			fs.tileListFlag = "opsmanager, er"
+
+			entry, err := RunPipelineResult(context.Background(), fs, Backup)
+			Ω(err).Should(BeNil())
+			Ω(entry.ID).Should(Equal("20261015T020000Z-0badf00d"))
+			Ω(runs).Should(Equal(1))
+			Ω(DestinationRun(dir)).Should(Equal(entry.ID))
+		})
+	})
 })
@@ -0,0 +1,124 @@
+package cfops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	PhaseConnect = cfbackup.ER_PHASE_CONNECT
+	PhaseDump    = cfbackup.ER_PHASE_DUMP
+	PhaseRestore = cfbackup.ER_PHASE_RESTORE
+	PhaseVerify  = "verify"
+	// PhaseSnapshot is the time the director took to snapshot the disks of
+	// the stores a tile snapshots rather than dumps
+	PhaseSnapshot = "snapshot"
+)
+
+// phaseTimer accumulates the time a tile spends in each phase, in the order
+// the phases first occur. A nil timer ignores every phase
+type phaseTimer struct {
+	mutex  sync.Mutex
+	phases []PhaseTiming
+}
+
+func (s *phaseTimer) add(name string, elapsed time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range s.phases {
+		if s.phases[i].Name == name {
+			s.phases[i].Seconds += elapsed.Seconds()
+			return
+		}
+	}
+	s.phases = append(s.phases, PhaseTiming{Name: name, Seconds: elapsed.Seconds()})
+}
+
+func (s *phaseTimer) list() []PhaseTiming {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]PhaseTiming(nil), s.phases...)
+}
+
+// WriteSummary writes a table of the time, bytes and outcome of every tile in
+// the run, broken down by phase, followed by the total time of each phase, the
+// sftp traffic and the outcome of the smoke tests a restore ended with
+func WriteSummary(w io.Writer, entry *CatalogEntry) {
+	var (
+		totals = &phaseTimer{}
+		run    = entry.Finished.Sub(entry.Started)
+	)
+	fmt.Fprintf(w, "%s %s in %s, %d bytes\n", entry.Action, entry.Status, run.Round(time.Millisecond), entry.Bytes())
+
+	for _, c := range entry.Components {
+		fmt.Fprintf(w, "  %-12s %-10s %10s %12d bytes", c.Name, c.Status, seconds(c.Seconds), c.Bytes)
+
+		if c.Error != "" {
+			fmt.Fprintf(w, "  %s", c.Error)
+		}
+		fmt.Fprintln(w)
+
+		for _, phase := range c.Phases {
+			fmt.Fprintf(w, "    %-10s %10s\n", phase.Name, seconds(phase.Seconds))
+			totals.add(phase.Name, time.Duration(phase.Seconds*float64(time.Second)))
+		}
+	}
+
+	if phases := totals.list(); len(phases) > 0 {
+		fmt.Fprintln(w, "  phases")
+
+		for _, phase := range phases {
+			fmt.Fprintf(w, "    %-10s %10s\n", phase.Name, seconds(phase.Seconds))
+		}
+	}
+
+	if transfer := entry.Transfer; transfer != nil {
+		fmt.Fprintf(w, "  sftp %d bytes sent, %d received, %d packets, %d retransmits\n", transfer.BytesSent, transfer.BytesReceived, transfer.PacketTotal(), transfer.Retransmits)
+	}
+
+	if entry.SmokeTests != nil {
+		fmt.Fprintln(w, "  smoke tests")
+
+		for _, test := range entry.SmokeTests.Tests {
+			outcome := "passed"
+
+			if !test.Passed {
+				outcome = "failed"
+			}
+			fmt.Fprintf(w, "    %-10s %-10s %10s", test.Name, outcome, seconds(test.Seconds))
+
+			if test.Error != "" {
+				fmt.Fprintf(w, "  %s", test.Error)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+// logSummary logs the summary of the run a line at a time
+func logSummary(entry *CatalogEntry) {
+	var summary bytes.Buffer
+	WriteSummary(&summary, entry)
+
+	for _, line := range strings.Split(strings.TrimRight(summary.String(), "\n"), "\n") {
+		lo.G.Info(line)
+	}
+}
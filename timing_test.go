@@ -0,0 +1,49 @@
+package cfops_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteSummary", func() {
+	It("should break each tile down by phase and total every phase", func() {
+		var summary bytes.Buffer
+		started := time.Now()
+		WriteSummary(&summary, &CatalogEntry{
+			Action:   Backup,
+			Status:   SetComplete,
+			Started:  started,
+			Finished: started.Add(90 * time.Second),
+			Components: []ComponentResult{
+				{Name: ER, Status: ComponentSucceeded, Seconds: 80, Bytes: 2048, Phases: []PhaseTiming{
+					{Name: PhaseConnect, Seconds: 1.5},
+					{Name: PhaseDump, Seconds: 70},
+					{Name: PhaseVerify, Seconds: 2},
+				}},
+				{Name: OpsMgr, Status: ComponentSucceeded, Seconds: 10, Bytes: 1024, Phases: []PhaseTiming{
+					{Name: PhaseDump, Seconds: 5},
+				}},
+			},
+		})
+		Ω(summary.String()).Should(HavePrefix("backup complete in 1m30s, 3072 bytes\n"))
+		Ω(summary.String()).Should(ContainSubstring("    connect          1.5s\n"))
+		Ω(summary.String()).Should(HaveSuffix("  phases\n    connect          1.5s\n    dump            1m15s\n    verify             2s\n"))
+	})
+	It("should total the sftp traffic of the run", func() {
+		var summary bytes.Buffer
+		WriteSummary(&summary, &CatalogEntry{
+			Action: Backup,
+			Status: SetComplete,
+			Transfer: &TransferStats{BytesSent: 4096, BytesReceived: 512, Retransmits: 1, Packets: map[string]uint64{
+				"SSH_FXP_OPEN":  1,
+				"SSH_FXP_WRITE": 3,
+			}},
+		})
+		Ω(summary.String()).Should(ContainSubstring("  sftp 4096 bytes sent, 512 received, 4 packets, 1 retransmits\n"))
+	})
+})
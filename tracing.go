@@ -0,0 +1,315 @@
+package cfops
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotalservices/gtils/command"
+)
+
+const (
+	ErrOTLPFormat        = "otlp collector %s responded with %s"
+	ErrOTLPHeadersFormat = "invalid otlp headers %q, expected e.g. x-api-key=secret,team=platform"
+	otlpTracesPath       = "/v1/traces"
+	// the kinds and status of otlp spans
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanStatusError  = 2
+)
+
+// activeTrace is the trace of the run in progress, nil when the run is not
+// traced
+var activeTrace = struct {
+	mutex sync.Mutex
+	trace *runTrace
+}{}
+
+type (
+	// TracingConfig describes the opentelemetry collector the spans of a
+	// run are exported to, over otlp/http
+	TracingConfig struct {
+		// Endpoint is the base url of the collector, e.g.
+		// http://collector:4318. The spans are posted to its /v1/traces
+		Endpoint string
+		// Headers are added to the export, e.g. to authenticate
+		Headers [][2]string
+	}
+
+	// runTrace keeps the spans of a run until it is exported
+	runTrace struct {
+		id    string
+		root  *traceSpan
+		spans []*traceSpan
+	}
+
+	// traceSpan times a run, a task of it or a remote command. A nil span
+	// records nothing, so that an untraced run need not check
+	traceSpan struct {
+		ID           string          `json:"spanId"`
+		TraceID      string          `json:"traceId"`
+		ParentID     string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       *otlpStatus     `json:"status,omitempty"`
+		attributesMu sync.Mutex
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue string `json:"stringValue,omitempty"`
+		// IntValue is a decimal int64, as otlp/json has it
+		IntValue string `json:"intValue,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpExport struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []*traceSpan `json:"spans"`
+	}
+)
+
+func ErrOTLP(endpoint, status string) error {
+	return fmt.Errorf(ErrOTLPFormat, endpoint, status)
+}
+
+func ErrOTLPHeaders(headers string) error {
+	return fmt.Errorf(ErrOTLPHeadersFormat, headers)
+}
+
+// Enabled tells whether the run should be traced at all
+func (s TracingConfig) Enabled() bool {
+	return s.Endpoint != ""
+}
+
+// ParseOTLPHeaders reads headers like x-api-key=secret,team=platform, as
+// OTEL_EXPORTER_OTLP_HEADERS has them
+func ParseOTLPHeaders(headers string) (parsed [][2]string, err error) {
+	for _, pair := range strings.Split(headers, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, ErrOTLPHeaders(headers)
+		}
+		parsed = append(parsed, [2]string{strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])})
+	}
+	return
+}
+
+// startTrace begins the trace of a run when the config enables tracing,
+// returning the span of the whole run and a function ending the spans of
+// remote commands
+func startTrace(config TracingConfig, action, runID string) (root *traceSpan, stop func()) {
+	if !config.Enabled() {
+		return nil, func() {}
+	}
+	trace := &runTrace{id: randomHex(16)}
+	activeTrace.mutex.Lock()
+	activeTrace.trace = trace
+	activeTrace.mutex.Unlock()
+
+	root = startSpan("cfops "+action, "", spanKindInternal)
+	root.set("cfops.run_id", runID)
+	trace.root = root
+	return root, command.OnExecute(traceCommand)
+}
+
+// traceCommand starts the span of a remote command in the task in progress,
+// named after the program run so that its arguments, which may hold
+// credentials, are left out
+func traceCommand(commandLine string) func(error) {
+	program := strings.Fields(commandLine)
+
+	if len(program) == 0 {
+		program = []string{"command"}
+	}
+	span := startSpan(program[0], currentSpanID(), spanKindClient)
+	return span.end
+}
+
+// startSpan begins a span of the trace in progress, a child of the span of
+// the run when parentID is empty. It is nil when the run is not traced
+func startSpan(name, parentID string, kind int) *traceSpan {
+	activeTrace.mutex.Lock()
+	defer activeTrace.mutex.Unlock()
+	trace := activeTrace.trace
+
+	if trace == nil {
+		return nil
+	}
+
+	if parentID == "" && trace.root != nil {
+		parentID = trace.root.ID
+	}
+	return &traceSpan{
+		ID:       randomHex(8),
+		TraceID:  trace.id,
+		ParentID: parentID,
+		Name:     name,
+		Kind:     kind,
+		Start:    unixNano(time.Now()),
+	}
+}
+
+// currentSpanID is the span of the task in progress
+func currentSpanID() string {
+	logContext.mutex.Lock()
+	defer logContext.mutex.Unlock()
+
+	if len(logContext.tasks) == 0 {
+		return ""
+	}
+	return logContext.tasks[len(logContext.tasks)-1].span.id()
+}
+
+func (s *traceSpan) id() string {
+	if s == nil {
+		return ""
+	}
+	return s.ID
+}
+
+// set records an attribute of the span, a string or an int64
+func (s *traceSpan) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	attribute := otlpAttribute{Key: key}
+
+	switch v := value.(type) {
+	case int64:
+		attribute.Value.IntValue = strconv.FormatInt(v, 10)
+	default:
+		attribute.Value.StringValue = fmt.Sprint(v)
+	}
+	s.attributesMu.Lock()
+	defer s.attributesMu.Unlock()
+	s.Attributes = append(s.Attributes, attribute)
+}
+
+// end ends the span, as failed when err is set, and keeps it for the export
+// of the trace it belongs to
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.End = unixNano(time.Now())
+
+	if err != nil {
+		s.Status = &otlpStatus{Code: spanStatusError, Message: err.Error()}
+	}
+	activeTrace.mutex.Lock()
+	defer activeTrace.mutex.Unlock()
+
+	if trace := activeTrace.trace; trace != nil && trace.id == s.TraceID {
+		trace.spans = append(trace.spans, s)
+	}
+}
+
+// finishTrace ends the span of the finished run and exports its trace.
+// Failing to export never fails the run
+func finishTrace(config TracingConfig, foundation string, root *traceSpan, entry *CatalogEntry) {
+	var err error
+
+	if entry.Status != SetComplete {
+		err = fmt.Errorf("%s %s", entry.Action, entry.Status)
+	}
+	root.set("cfops.status", entry.Status)
+	root.end(err)
+
+	if exportErr := exportTrace(config, foundation); exportErr != nil {
+		warn("unable to export the trace of the run: %s", exportErr)
+	}
+}
+
+// exportTrace posts the spans of the run to the collector and ends the trace
+func exportTrace(config TracingConfig, foundation string) (err error) {
+	var (
+		contents []byte
+		request  *http.Request
+		response *http.Response
+		export   otlpExport
+	)
+	activeTrace.mutex.Lock()
+	trace := activeTrace.trace
+	activeTrace.trace = nil
+	activeTrace.mutex.Unlock()
+
+	if trace == nil || len(trace.spans) == 0 {
+		return
+	}
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{{Spans: trace.spans}}}
+	resource.Resource.Attributes = []otlpAttribute{
+		{Key: "service.name", Value: otlpValue{StringValue: loggerName}},
+		{Key: "cfops.foundation", Value: otlpValue{StringValue: foundation}},
+	}
+	resource.ScopeSpans[0].Scope.Name = loggerName
+	export.ResourceSpans = []otlpResourceSpans{resource}
+
+	if contents, err = json.Marshal(export); err != nil {
+		return
+	}
+	endpoint := strings.TrimRight(config.Endpoint, "/") + otlpTracesPath
+
+	if request, err = http.NewRequest("POST", endpoint, bytes.NewReader(contents)); err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	for _, header := range config.Headers {
+		request.Header.Set(header[0], header[1])
+	}
+
+	if response, err = metricsClient.Do(request); err == nil {
+		defer response.Body.Close()
+
+		if response.StatusCode/100 != 2 {
+			err = ErrOTLP(endpoint, response.Status)
+		}
+	}
+	return
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,137 @@
+package cfops_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	. "github.com/pivotalservices/cfops"
+	"github.com/pivotalservices/gtils/command"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// commandTile runs a remote command over a fake ssh session
+type commandTile struct{}
+
+func (s commandTile) Backup() error {
+	executor := &command.DefaultRemoteExecutor{Client: fakeSSHClient{}}
+	return executor.Execute(ioutil.Discard, "mysqldump --password=secret ccdb")
+}
+
+func (s commandTile) Restore() error { return nil }
+
+type fakeSSHClient struct{}
+
+func (fakeSSHClient) NewSession() (command.SSHSession, error) { return fakeSSHSession{}, nil }
+
+type fakeSSHSession struct{}
+
+func (fakeSSHSession) Start(string) error             { return nil }
+func (fakeSSHSession) Wait() error                    { return nil }
+func (fakeSSHSession) Close() error                   { return nil }
+func (fakeSSHSession) StdoutPipe() (io.Reader, error) { return strings.NewReader("dump"), nil }
+
+type exportedSpan struct {
+	TraceID    string `json:"traceId"`
+	SpanID     string `json:"spanId"`
+	ParentID   string `json:"parentSpanId"`
+	Name       string `json:"name"`
+	Attributes []struct {
+		Key string `json:"key"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+var _ = Describe("Tracing", func() {
+	var (
+		dir     string
+		fs      *mockFlagSet
+		server  *httptest.Server
+		urlPath string
+		apiKey  string
+		spans   map[string]exportedSpan
+	)
+
+	BeforeEach(func() {
+		spans = make(map[string]exportedSpan)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var export struct {
+				ResourceSpans []struct {
+					ScopeSpans []struct {
+						Spans []exportedSpan `json:"spans"`
+					} `json:"scopeSpans"`
+				} `json:"resourceSpans"`
+			}
+			urlPath, apiKey = r.URL.Path, r.Header.Get("x-api-key")
+			json.NewDecoder(r.Body).Decode(&export)
+
+			for _, span := range export.ResourceSpans[0].ScopeSpans[0].Spans {
+				spans[span.Name] = span
+			}
+		}))
+		dir, _ = ioutil.TempDir("", "tracing")
+		writeArtifacts(dir, BackupArtifacts[OpsMgr])
+		SupportedTiles = map[string]func() (Tile, error){
+			OpsMgr: func() (Tile, error) {
+				return commandTile{}, nil
+			},
+			ER: func() (Tile, error) {
+				return &mockTile{ErrReturned: errors.New("er failed")}, nil
+			},
+		}
+		fs = &mockFlagSet{
+			tileListFlag: "opsmanager, er",
+			dest:         dir,
+			tracing:      TracingConfig{Endpoint: server.URL + "/", Headers: [][2]string{{"x-api-key", "secret"}}},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should export a span of the run, each tile and each remote command", func() {
+		RunPipeline(fs, Backup)
+		Ω(urlPath).Should(Equal("/v1/traces"))
+		Ω(apiKey).Should(Equal("secret"))
+		run := spans["cfops backup"]
+		Ω(run.TraceID).Should(HaveLen(32))
+		Ω(run.ParentID).Should(BeEmpty())
+		Ω(run.Status.Message).Should(Equal("backup incomplete"))
+		Ω(spans[OpsMgr].ParentID).Should(Equal(run.SpanID))
+		Ω(spans[OpsMgr].TraceID).Should(Equal(run.TraceID))
+		Ω(spans["mysqldump"].ParentID).Should(Equal(spans[OpsMgr].SpanID))
+		Ω(spans[ER].Status.Code).Should(Equal(2))
+		Ω(spans[ER].Status.Message).Should(ContainSubstring("er failed"))
+	})
+
+	It("should export nothing when no collector is given", func() {
+		fs.tracing = TracingConfig{}
+		RunPipeline(fs, Backup)
+		Ω(spans).Should(BeEmpty())
+	})
+
+	Describe("ParseOTLPHeaders", func() {
+		It("should read name=value pairs", func() {
+			headers, err := ParseOTLPHeaders("x-api-key=secret, team=platform")
+			Ω(err).Should(BeNil())
+			Ω(headers).Should(Equal([][2]string{{"x-api-key", "secret"}, {"team", "platform"}}))
+		})
+
+		It("should refuse a header without a value", func() {
+			_, err := ParseOTLPHeaders("x-api-key")
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package cfops
+
+import (
+	"sort"
+
+	"github.com/pivotalservices/gtils/osutils"
+	"github.com/pkg/sftp"
+)
+
+// readTransferStats reads the counters of every sftp client of the process
+var readTransferStats = osutils.TransferStats
+
+// NewTransferStats is the sftp traffic between two readings of the
+// counters, nil when there was none
+func NewTransferStats(before, after sftp.ClientStats) *TransferStats {
+	if after.BytesSent == before.BytesSent {
+		return nil
+	}
+	stats := &TransferStats{
+		BytesSent:     after.BytesSent - before.BytesSent,
+		BytesReceived: after.BytesReceived - before.BytesReceived,
+		Packets:       make(map[string]uint64),
+		Retransmits:   after.Retransmits - before.Retransmits,
+	}
+
+	for name, count := range after.Packets {
+		if count > before.Packets[name] {
+			stats.Packets[name] = count - before.Packets[name]
+		}
+	}
+	return stats
+}
+
+// PacketTotal is the number of packets sent
+func (s *TransferStats) PacketTotal() (total uint64) {
+	for _, count := range s.Packets {
+		total += count
+	}
+	return
+}
+
+// packetTypes are the types of the packets sent, sorted
+func (s *TransferStats) packetTypes() (types []string) {
+	for name := range s.Packets {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return
+}
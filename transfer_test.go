@@ -0,0 +1,30 @@
+package cfops_test
+
+import (
+	. "github.com/pivotalservices/cfops"
+	"github.com/pkg/sftp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewTransferStats", func() {
+	It("should count the traffic between the two readings", func() {
+		before := sftp.ClientStats{BytesSent: 100, BytesReceived: 50, Packets: map[string]uint64{"SSH_FXP_INIT": 1}}
+		after := sftp.ClientStats{BytesSent: 300, BytesReceived: 90, Retransmits: 1, Packets: map[string]uint64{
+			"SSH_FXP_INIT":  1,
+			"SSH_FXP_WRITE": 2,
+		}}
+		Ω(NewTransferStats(before, after)).Should(Equal(&TransferStats{
+			BytesSent:     200,
+			BytesReceived: 40,
+			Retransmits:   1,
+			Packets:       map[string]uint64{"SSH_FXP_WRITE": 2},
+		}))
+	})
+
+	It("should be nil when nothing was sent", func() {
+		stats := sftp.ClientStats{BytesSent: 100}
+		Ω(NewTransferStats(stats, stats)).Should(BeNil())
+	})
+})
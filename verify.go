@@ -0,0 +1,331 @@
+package cfops
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pivotalservices/cfbackup"
+	"github.com/xchapter7x/lo"
+)
+
+const (
+	ErrMissingArtifactFormat = "backup artifact is missing or empty: %s"
+	ErrNoTablesFormat        = "database dump %s restored without any tables"
+	ErrEmptyTableFormat      = "database dump %s is missing rows in required table %s"
+	MysqlEngine              = "mysql"
+	PostgresEngine           = "postgres"
+)
+
+// DatabaseDump describes a database dump artifact and the tables a healthy
+// restore of it must contain rows for
+type DatabaseDump struct {
+	Artifact       string
+	Engine         string
+	RequiredTables []string
+}
+
+var (
+	// BackupArtifacts lists the files each tile writes, relative to the destination
+	BackupArtifacts = map[string][]string{
+		OpsMgr: []string{
+			path.Join(cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_SETTINGS_FILENAME),
+			path.Join(cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_INSTALLATION_ASSETS_FILENAME),
+			path.Join(cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_DEPLOYMENTS_FILENAME),
+			path.Join(cfbackup.OPSMGR_BACKUP_DIR, cfbackup.OPSMGR_ENCRYPTIONKEY_FILENAME),
+		},
+		ER: []string{
+			erArtifact("ccdb"),
+			erArtifact("uaadb"),
+			erArtifact("consoledb"),
+			erArtifact("mysql"),
+			erArtifact("nfs_server"),
+		},
+		PKS: []string{
+			pksArtifact("pks"),
+			pksArtifact("uaa"),
+			PKSClustersIndex,
+		},
+	}
+	// DatabaseDumps lists the dumps a deep verification restores into a sandbox
+	DatabaseDumps = []DatabaseDump{
+		DatabaseDump{Artifact: erArtifact("ccdb"), Engine: PostgresEngine, RequiredTables: []string{"organizations", "spaces"}},
+		DatabaseDump{Artifact: erArtifact("uaadb"), Engine: PostgresEngine, RequiredTables: []string{"users", "oauth_client_details"}},
+		DatabaseDump{Artifact: erArtifact("consoledb"), Engine: PostgresEngine},
+		DatabaseDump{Artifact: erArtifact("mysql"), Engine: MysqlEngine},
+	}
+)
+
+func erArtifact(component string) string {
+	return fmt.Sprintf(cfbackup.ER_BACKUP_FILE_FORMAT, component)
+}
+
+func ErrMissingArtifact(artifact string) error {
+	return fmt.Errorf(ErrMissingArtifactFormat, artifact)
+}
+
+func ErrNoTables(artifact string) error {
+	return fmt.Errorf(ErrNoTablesFormat, artifact)
+}
+
+func ErrEmptyTable(artifact, table string) error {
+	return fmt.Errorf(ErrEmptyTableFormat, artifact, table)
+}
+
+type (
+	// artifactSource reads the artifacts of a backup, from its directory or
+	// from its indexed archive
+	artifactSource interface {
+		location(artifact string) string
+		artifactSize(artifact string) (int64, error)
+		openArtifact(artifact string) (io.ReadCloser, error)
+	}
+
+	dirSource string
+
+	archiveSource struct {
+		*Archive
+		name string
+	}
+)
+
+func (s dirSource) location(artifact string) string {
+	return path.Join(string(s), artifact)
+}
+
+func (s dirSource) artifactSize(artifact string) (size int64, err error) {
+	var info os.FileInfo
+
+	if info, err = os.Stat(s.location(artifact)); err == nil {
+		size = info.Size()
+	}
+	return
+}
+
+func (s dirSource) openArtifact(artifact string) (io.ReadCloser, error) {
+	return os.Open(s.location(artifact))
+}
+
+func (s archiveSource) location(artifact string) string {
+	return s.name + ":" + artifact
+}
+
+func (s archiveSource) artifactSize(artifact string) (size int64, err error) {
+	entry, ok := s.Entry(artifact)
+
+	if !ok {
+		return 0, ErrArchiveEntry(artifact)
+	}
+	return entry.Size, nil
+}
+
+func (s archiveSource) openArtifact(artifact string) (io.ReadCloser, error) {
+	return s.Open(artifact)
+}
+
+// openBackup reads a backup from an http url serving its archive through
+// range requests, from a local archive, or from a destination directory,
+// preferring the archive in it when there is one
+func openBackup(destination string) (source artifactSource, closer func(), err error) {
+	var (
+		archive *Archive
+		file    io.Closer
+	)
+	closer = func() {}
+	archivePath := destination
+
+	if strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://") {
+		if archive, err = OpenHTTPArchive(destination); err == nil {
+			source = archiveSource{Archive: archive, name: destination}
+		}
+		return
+	}
+
+	if !strings.HasSuffix(destination, ".tar") {
+		archivePath = path.Join(destination, ArchiveName)
+
+		if _, statErr := os.Stat(archivePath); statErr != nil {
+			return dirSource(destination), closer, nil
+		}
+	}
+
+	if archive, file, err = OpenArchiveFile(archivePath); err == nil {
+		source = archiveSource{Archive: archive, name: archivePath}
+		closer = func() { file.Close() }
+	}
+	return
+}
+
+// RunVerify verifies the backup at the destination for the tiles in the csv
+// tilelist (all tiles when empty), restoring database dumps into sandboxes
+// when deep is set. The destination may also be an indexed archive, local or
+// served over http, only the artifacts being verified are read from it
+func RunVerify(destination, tilelist string, deep bool, sandboxes SandboxFactory) (err error) {
+	var (
+		source artifactSource
+		closer func()
+	)
+	tiles := []string{OpsMgr, ER}
+
+	if tilelist != "" {
+		tiles = formatArray(strings.Split(tilelist, ","))
+	}
+
+	if source, closer, err = openBackup(destination); err != nil {
+		return
+	}
+	defer closer()
+
+	if err = verifySource(source, tiles); err == nil && deep {
+		err = deepVerifySource(source, sandboxes)
+	}
+	return
+}
+
+// Verify checks that every artifact of the given tiles exists and is non-empty,
+// and that database dumps are complete
+func Verify(destination string, tiles []string) (err error) {
+	var (
+		source artifactSource
+		closer func()
+	)
+
+	if source, closer, err = openBackup(destination); err == nil {
+		defer closer()
+		err = verifySource(source, tiles)
+	}
+	return
+}
+
+func verifySource(source artifactSource, tiles []string) (err error) {
+	snapshotted := snapshottedArtifacts(source)
+
+	for _, tileName := range tiles {
+		artifacts, ok := BackupArtifacts[tileName]
+
+		if !ok {
+			err = ErrUnsupportedTile(tileName)
+			break
+		}
+
+		for _, artifact := range artifacts {
+			if snapshotted[artifact] {
+				continue
+			}
+
+			if err = checkArtifact(source, artifact); err != nil {
+				return
+			}
+		}
+
+		if tileName == ER {
+			if err = validateDumps(source, archivedComponents(snapshotted)); err != nil {
+				return
+			}
+		}
+
+		if tileName == PKS {
+			if err = verifyPKS(source); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// archivedComponents are the csv list of the stores of the elastic runtime
+// a backup has archives of, all of them when it snapshotted none
+func archivedComponents(snapshotted map[string]bool) string {
+	var components []string
+
+	if len(snapshotted) == 0 {
+		return ""
+	}
+
+	for _, dump := range DatabaseDumps {
+		if !snapshotted[dump.Artifact] {
+			components = append(components, strings.TrimSuffix(dump.Artifact, erArtifact("")))
+		}
+	}
+	return strings.Join(components, ",")
+}
+
+// DeepVerify restores each database dump into a disposable sandbox and
+// sanity checks the restored schema and row counts
+func DeepVerify(destination string, sandboxes SandboxFactory) (err error) {
+	var (
+		source artifactSource
+		closer func()
+	)
+
+	if source, closer, err = openBackup(destination); err == nil {
+		defer closer()
+		err = deepVerifySource(source, sandboxes)
+	}
+	return
+}
+
+func deepVerifySource(source artifactSource, sandboxes SandboxFactory) (err error) {
+	snapshotted := snapshottedArtifacts(source)
+
+	for _, dump := range DatabaseDumps {
+		if snapshotted[dump.Artifact] {
+			continue
+		}
+		lo.G.Debug("Deep verifying " + dump.Artifact)
+
+		if err = verifyDump(source, dump, sandboxes); err != nil {
+			break
+		}
+	}
+	return
+}
+
+func verifyDump(source artifactSource, dump DatabaseDump, sandboxes SandboxFactory) (err error) {
+	var (
+		sandbox  Sandbox
+		contents io.ReadCloser
+		counts   map[string]int
+	)
+
+	if contents, err = source.openArtifact(dump.Artifact); err != nil {
+		return
+	}
+	defer contents.Close()
+
+	if sandbox, err = sandboxes(dump.Engine); err != nil {
+		return
+	}
+	defer sandbox.Destroy()
+
+	if err = sandbox.Restore(contents); err == nil {
+
+		if counts, err = sandbox.RowCounts(); err == nil {
+			err = checkRowCounts(dump, counts)
+		}
+	}
+	return
+}
+
+func checkRowCounts(dump DatabaseDump, counts map[string]int) (err error) {
+	if len(counts) == 0 {
+		return ErrNoTables(dump.Artifact)
+	}
+
+	for _, table := range dump.RequiredTables {
+		if counts[table] == 0 {
+			err = ErrEmptyTable(dump.Artifact, table)
+			break
+		}
+	}
+	return
+}
+
+func checkArtifact(source artifactSource, artifact string) (err error) {
+	if size, sizeErr := source.artifactSize(artifact); sizeErr != nil || size == 0 {
+		err = ErrMissingArtifact(source.location(artifact))
+	}
+	return
+}
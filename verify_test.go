@@ -0,0 +1,175 @@
+package cfops_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/pivotalservices/cfops"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verify", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "verify")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("when every artifact of the tile exists", func() {
+		BeforeEach(func() {
+			writeArtifacts(dir, BackupArtifacts[ER])
+		})
+
+		It("should not return an error", func() {
+			Ω(Verify(dir, []string{ER})).Should(BeNil())
+		})
+	})
+
+	Context("when an artifact is empty", func() {
+		BeforeEach(func() {
+			writeArtifacts(dir, BackupArtifacts[ER])
+			os.Truncate(path.Join(dir, BackupArtifacts[ER][0]), 0)
+		})
+
+		It("should return an error naming the artifact", func() {
+			err := Verify(dir, []string{ER})
+			Ω(err).Should(Equal(ErrMissingArtifact(path.Join(dir, BackupArtifacts[ER][0]))))
+		})
+	})
+
+	Context("when given an unsupported tile", func() {
+		It("should return an unsupported tile error", func() {
+			Ω(Verify(dir, []string{"NOTATILE"})).Should(Equal(ErrUnsupportedTile("NOTATILE")))
+		})
+	})
+
+	Describe("DeepVerify", func() {
+		var sandbox *mockSandbox
+
+		BeforeEach(func() {
+			writeArtifacts(dir, BackupArtifacts[ER])
+			sandbox = &mockSandbox{
+				counts: map[string]int{
+					"organizations":        1,
+					"spaces":               1,
+					"users":                1,
+					"oauth_client_details": 1,
+				},
+			}
+		})
+
+		Context("when every dump restores with rows in its required tables", func() {
+			It("should restore and destroy a sandbox per dump", func() {
+				Ω(DeepVerify(dir, sandbox.factory)).Should(BeNil())
+				Ω(sandbox.restored).Should(Equal(len(DatabaseDumps)))
+				Ω(sandbox.destroyed).Should(Equal(len(DatabaseDumps)))
+			})
+		})
+
+		Context("when a required table is empty", func() {
+			BeforeEach(func() {
+				sandbox.counts["users"] = 0
+			})
+
+			It("should return an empty table error", func() {
+				Ω(DeepVerify(dir, sandbox.factory)).Should(Equal(ErrEmptyTable(DatabaseDumps[1].Artifact, "users")))
+			})
+		})
+
+		Context("when a dump restores no tables", func() {
+			BeforeEach(func() {
+				sandbox.counts = map[string]int{}
+			})
+
+			It("should return a no tables error", func() {
+				Ω(DeepVerify(dir, sandbox.factory)).Should(Equal(ErrNoTables(DatabaseDumps[0].Artifact)))
+			})
+		})
+
+		Context("when the restore fails", func() {
+			BeforeEach(func() {
+				sandbox.restoreErr = errors.New("restore failed")
+			})
+
+			It("should return the error and still destroy the sandbox", func() {
+				Ω(DeepVerify(dir, sandbox.factory)).Should(Equal(sandbox.restoreErr))
+				Ω(sandbox.destroyed).Should(Equal(1))
+			})
+		})
+	})
+})
+
+func writeArtifacts(dir string, artifacts []string) {
+	for _, artifact := range artifacts {
+		p := path.Join(dir, artifact)
+		os.MkdirAll(path.Dir(p), 0755)
+		ioutil.WriteFile(p, []byte(artifactContents(artifact)), 0644)
+	}
+}
+
+func artifactContents(artifact string) string {
+	for _, dump := range DatabaseDumps {
+		if dump.Artifact == artifact && dump.Engine == MysqlEngine {
+			return mysqlDump
+		}
+
+		if dump.Artifact == artifact {
+			return postgresDump
+		}
+	}
+	return "-- artifact"
+}
+
+const (
+	mysqlDump = `-- MySQL dump 10.13
+CREATE TABLE users (id int);
+INSERT INTO users VALUES (1);
+-- Dump completed on 2015-08-01 12:00:00
+`
+	postgresDump = `--
+-- PostgreSQL database dump
+--
+CREATE TABLE organizations (id integer);
+COPY organizations (id) FROM stdin;
+1
+\.
+--
+-- PostgreSQL database dump complete
+--
+
+`
+)
+
+type mockSandbox struct {
+	counts     map[string]int
+	restoreErr error
+	restored   int
+	destroyed  int
+}
+
+func (s *mockSandbox) factory(engine string) (Sandbox, error) {
+	return s, nil
+}
+
+func (s *mockSandbox) Restore(dump io.Reader) error {
+	s.restored++
+	return s.restoreErr
+}
+
+func (s *mockSandbox) RowCounts() (map[string]int, error) {
+	return s.counts, nil
+}
+
+func (s *mockSandbox) Destroy() error {
+	s.destroyed++
+	return nil
+}